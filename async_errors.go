@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ProbeAttachError - Reported on Options.ErrorChan when a probe couldn't be attached, or re-attached by the health
+// check, after the manager started
+type ProbeAttachError struct {
+	// Probe - Identification pair of the probe
+	Probe ProbeIdentificationPair
+
+	// Err - Attach error
+	Err error
+}
+
+func (e *ProbeAttachError) Error() string {
+	return fmt.Sprintf("probe %s couldn't be attached: %v", e.Probe, e.Err)
+}
+
+// Unwrap - Returns the attach error
+func (e *ProbeAttachError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeDetachedError - Reported on Options.ErrorChan when the health check finds a probe that is no longer attached to
+// its hook point, see Options.HealthCheckInterval
+type ProbeDetachedError struct {
+	// Probe - Identification pair of the probe
+	Probe ProbeIdentificationPair
+
+	// Reattached - (HealthCheckReattach) True if the probe was attached again
+	Reattached bool
+
+	// Err - Reason why the probe is unhealthy, wraps ErrProbeUnhealthy
+	Err error
+}
+
+func (e *ProbeDetachedError) Error() string {
+	if e.Reattached {
+		return fmt.Sprintf("probe %s was detached and attached again: %v", e.Probe, e.Err)
+	}
+	return fmt.Sprintf("probe %s was detached: %v", e.Probe, e.Err)
+}
+
+// Unwrap - Returns the reason why the probe is unhealthy
+func (e *ProbeDetachedError) Unwrap() error {
+	return e.Err
+}
+
+// PerfReadError - Reported on Options.ErrorChan when the reader of a perf map or of a ring buffer fails to read a
+// sample
+type PerfReadError struct {
+	// Map - Name of the perf map or of the ring buffer
+	Map string
+
+	// Fatal - True if the reader stopped because of the error
+	Fatal bool
+
+	// Err - Read error
+	Err error
+}
+
+func (e *PerfReadError) Error() string {
+	if e.Fatal {
+		return fmt.Sprintf("reader of %s stopped: %v", e.Map, e.Err)
+	}
+	return fmt.Sprintf("reader of %s: %v", e.Map, e.Err)
+}
+
+// Unwrap - Returns the read error
+func (e *PerfReadError) Unwrap() error {
+	return e.Err
+}
+
+// MapUpdateError - Reported on Options.ErrorChan when the manager fails to update a map in the background, for example
+// when the perf ring buffers of a perf map are resized
+type MapUpdateError struct {
+	// Map - Name of the map
+	Map string
+
+	// Err - Update error
+	Err error
+}
+
+func (e *MapUpdateError) Error() string {
+	return fmt.Sprintf("map %s couldn't be updated: %v", e.Map, e.Err)
+}
+
+// Unwrap - Returns the update error
+func (e *MapUpdateError) Unwrap() error {
+	return e.Err
+}
+
+// reportError - Sends the provided error on ErrorChan, without blocking. The errors that don't fit in the channel are
+// counted in DroppedErrors.
+func (m *Manager) reportError(err error) {
+	if m == nil || m.options.ErrorChan == nil {
+		return
+	}
+	select {
+	case m.options.ErrorChan <- err:
+	default:
+		atomic.AddUint64(&m.droppedErrors, 1)
+	}
+}
+
+// DroppedErrors - Returns the number of errors that were dropped because ErrorChan was full
+func (m *Manager) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&m.droppedErrors)
+}
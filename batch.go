@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchSize - Default maximum number of samples passed to the BatchDataHandler of a PerfMap at once
+	DefaultBatchSize = 64
+	// DefaultBatchFlushInterval - Default maximum amount of time a sample is held in the batch of a PerfMap
+	DefaultBatchFlushInterval = 10 * time.Millisecond
+)
+
+// sampleBatcher - Groups samples per CPU and delivers them in batches, once a batch is full or when it is flushed
+type sampleBatcher struct {
+	lock     sync.Mutex
+	size     int
+	interval time.Duration
+	batches  map[int][][]byte
+	deliver  func(cpu int, samples [][]byte)
+}
+
+// newSampleBatcher - Creates a new sample batcher, default values are used for the size and the interval if they are
+// not set
+func newSampleBatcher(size int, interval time.Duration, deliver func(cpu int, samples [][]byte)) *sampleBatcher {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if interval <= 0 {
+		interval = DefaultBatchFlushInterval
+	}
+	return &sampleBatcher{
+		size:     size,
+		interval: interval,
+		batches:  make(map[int][][]byte),
+		deliver:  deliver,
+	}
+}
+
+// push - Appends a sample to the batch of its CPU and delivers the batch if it is full
+func (sb *sampleBatcher) push(cpu int, data []byte) {
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+	batch := append(sb.batches[cpu], data)
+	if len(batch) < sb.size {
+		sb.batches[cpu] = batch
+		return
+	}
+	delete(sb.batches, cpu)
+	sb.deliver(cpu, batch)
+}
+
+// flushAll - Delivers all the pending batches
+func (sb *sampleBatcher) flushAll() {
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+	for cpu, batch := range sb.batches {
+		delete(sb.batches, cpu)
+		sb.deliver(cpu, batch)
+	}
+}
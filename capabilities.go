@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capability - Linux capability required to load and attach the programs of a manager
+type Capability int
+
+const (
+	// CapNetAdmin - CAP_NET_ADMIN, required by the networking programs (XDP, TC, sockets, cgroups)
+	CapNetAdmin Capability = unix.CAP_NET_ADMIN
+	// CapSysAdmin - CAP_SYS_ADMIN, replaces CAP_BPF and CAP_PERFMON before kernel 5.8
+	CapSysAdmin Capability = unix.CAP_SYS_ADMIN
+	// CapPerfmon - CAP_PERFMON, required by the tracing programs and by the perf maps (kernel 5.8+)
+	CapPerfmon Capability = unix.CAP_PERFMON
+	// CapBPF - CAP_BPF, required to create maps and load programs (kernel 5.8+)
+	CapBPF Capability = unix.CAP_BPF
+)
+
+func (c Capability) String() string {
+	switch c {
+	case CapNetAdmin:
+		return "CAP_NET_ADMIN"
+	case CapSysAdmin:
+		return "CAP_SYS_ADMIN"
+	case CapPerfmon:
+		return "CAP_PERFMON"
+	case CapBPF:
+		return "CAP_BPF"
+	default:
+		return fmt.Sprintf("Capability(%d)", int(c))
+	}
+}
+
+// tokenKernelVersion - First kernel version supporting BPF tokens
+var tokenKernelVersion = NewKernelVersion(6, 9, 0)
+
+// capabilitiesKernelVersion - First kernel version supporting CAP_BPF and CAP_PERFMON
+var capabilitiesKernelVersion = NewKernelVersion(5, 8, 0)
+
+// CapabilityReport - Capabilities required by the configured probes and maps of a manager, see
+// Manager.RequiredCapabilities
+type CapabilityReport struct {
+	// Required - Capabilities required by the manager
+	Required []Capability
+
+	// Reasons - Probes and maps that require each capability
+	Reasons map[Capability][]string
+
+	// Missing - Required capabilities missing from the effective capabilities of the process
+	Missing []Capability
+
+	// TokenSufficient - (Options.BPFTokenPath) True if the BPF filesystem at BPFTokenPath delegates the creation of
+	// any map and the loading of any program, which then don't require CAP_BPF
+	TokenSufficient bool
+
+	// Sufficient - True if the process has the required capabilities, or if the BPF token covers the missing ones
+	Sufficient bool
+}
+
+// RequiredCapabilities - Reports the capabilities required by the configured probes and maps of the manager, and
+// whether the process has them. It is meant to be called before Init, to fail early with an actionable error: the
+// program types are deduced from the sections of the probes.
+func (m *Manager) RequiredCapabilities() (*CapabilityReport, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+
+	version, err := CurrentKernelVersion()
+	if err != nil {
+		return nil, err
+	}
+	legacy := version < capabilitiesKernelVersion
+	report := &CapabilityReport{Reasons: make(map[Capability][]string)}
+	require := func(capability Capability, reason string) {
+		if legacy && (capability == CapBPF || capability == CapPerfmon) {
+			capability = CapSysAdmin
+		}
+		report.Reasons[capability] = append(report.Reasons[capability], reason)
+	}
+
+	require(CapBPF, "maps and programs")
+	for _, probe := range m.Probes {
+		if capability, ok := sectionCapability(probe.Section); ok {
+			require(capability, fmt.Sprintf("probe %s", probe.GetIdentificationPair()))
+		}
+	}
+	for _, perfMap := range m.PerfMaps {
+		require(CapPerfmon, fmt.Sprintf("perf map %s", perfMap.Name))
+	}
+	for capability, reasons := range report.Reasons {
+		report.Required = append(report.Required, capability)
+		report.Reasons[capability] = dedupStrings(reasons)
+	}
+	sort.Slice(report.Required, func(i, j int) bool { return report.Required[i] < report.Required[j] })
+
+	effective, err := effectiveCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	for _, capability := range report.Required {
+		if effective&(1<<uint(capability)) == 0 {
+			report.Missing = append(report.Missing, capability)
+		}
+	}
+
+	if m.options.BPFTokenPath != "" && version >= tokenKernelVersion {
+		report.TokenSufficient = bpffsDelegatesAll(m.options.BPFTokenPath)
+	}
+	report.Sufficient = len(report.Missing) == 0 || (report.TokenSufficient && len(report.Missing) == 1 && report.Missing[0] == CapBPF)
+	return report, nil
+}
+
+// sectionCapability - Returns the capability required to attach a program of the provided section, in addition to
+// CAP_BPF
+func sectionCapability(section string) (Capability, bool) {
+	prefix := section
+	if i := strings.IndexByte(section, '/'); i >= 0 {
+		prefix = section[:i]
+	}
+	switch prefix {
+	case "kprobe", "kretprobe", "uprobe", "uretprobe", "usdt", "tracepoint", "raw_tracepoint", "raw_tp", "tp_btf",
+		"perf_event", "fentry", "fexit", "fmod_ret", "lsm", "iter":
+		return CapPerfmon, true
+	case "xdp", "classifier", "tc", "tcx", "socket", "sk_skb", "sk_msg", "sockops", "cgroup_skb", "cgroup", "cgroup_sock",
+		"flow_dissector", "sk_lookup":
+		return CapNetAdmin, true
+	default:
+		return 0, false
+	}
+}
+
+// effectiveCapabilities - Returns the effective capabilities of the process, as a bit set
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "CapEff:"); value != scanner.Text() {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("CapEff not found in /proc/self/status")
+}
+
+// bpffsDelegatesAll - Returns true if the BPF filesystem mounted at the provided path delegates the creation of any
+// map and the loading of any program through BPF tokens
+func bpffsDelegatesAll(path string) bool {
+	options := bpffsMountOptions(path)
+	delegates := func(option string, values ...string) bool {
+		delegated := strings.Split(options[option], ":")
+		for _, value := range values {
+			found := false
+			for _, d := range delegated {
+				if d == value || d == "any" {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return delegates("delegate_cmds", "map_create", "prog_load") && delegates("delegate_maps", "any") &&
+		delegates("delegate_progs", "any")
+}
+
+// bpffsMountOptions - Returns the super block options of the BPF filesystem mounted at the provided path
+func bpffsMountOptions(path string) map[string]string {
+	options := make(map[string]string)
+	path = filepath.Clean(path)
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return options
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// ID parent major:minor root mount_point options [optional fields] - type source super_options
+		fields := strings.Fields(scanner.Text())
+		separator := -1
+		for i, field := range fields {
+			if field == "-" {
+				separator = i
+				break
+			}
+		}
+		if len(fields) < 5 || separator < 0 || len(fields) < separator+4 || fields[4] != path || fields[separator+1] != "bpf" {
+			continue
+		}
+		for _, option := range strings.Split(fields[separator+3], ",") {
+			key, value, _ := strings.Cut(option, "=")
+			options[key] = value
+		}
+	}
+	return options
+}
+
+// dedupStrings - Returns the provided strings without duplicates, in order
+func dedupStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var output []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			output = append(output, value)
+		}
+	}
+	return output
+}
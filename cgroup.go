@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// CGroupAttachFlags - Flags of the BPF_PROG_ATTACH command, used to attach a cgroup program without a bpf_link
+type CGroupAttachFlags uint32
+
+const (
+	// CGroupAttachAllowOverride - BPF_F_ALLOW_OVERRIDE, the program can be overridden by a program attached to a
+	// descendant cgroup
+	CGroupAttachAllowOverride CGroupAttachFlags = unix.BPF_F_ALLOW_OVERRIDE
+	// CGroupAttachAllowMulti - BPF_F_ALLOW_MULTI, the program runs along with the programs attached to the cgroup and
+	// to its descendant cgroups (kernel 4.15+)
+	CGroupAttachAllowMulti CGroupAttachFlags = unix.BPF_F_ALLOW_MULTI
+	// CGroupAttachReplace - BPF_F_REPLACE, the program atomically replaces the program set in
+	// Probe.CGroupReplaceProgramID (kernel 5.6+). Requires CGroupAttachAllowMulti.
+	CGroupAttachReplace CGroupAttachFlags = unix.BPF_F_REPLACE
+)
+
+// cgroupAttachment - (cgroup family programs) Program attached to a cgroup with BPF_PROG_ATTACH
+type cgroupAttachment struct {
+	cgroup     *os.File
+	program    *ebpf.Program
+	attachType ebpf.AttachType
+}
+
+// Close - Detaches the program from the cgroup
+func (a *cgroupAttachment) Close() error {
+	err := link.RawDetachProgram(link.RawDetachProgramOptions{
+		Target:  int(a.cgroup.Fd()),
+		Program: a.program,
+		Attach:  a.attachType,
+	})
+	return ConcatErrors(err, a.cgroup.Close())
+}
+
+// resolveCGroupPath - Returns the provided path if it is in a cgroup v2 hierarchy. On the hybrid systems, a cgroup v1
+// path is translated to the same cgroup in the cgroup v2 hierarchy, if it exists.
+func resolveCGroupPath(path string) (string, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", errors.New(fmt.Sprintf("error:%v , couldn't stat cgroup %s", err, path))
+	}
+	switch stat.Type {
+	case unix.CGROUP2_SUPER_MAGIC:
+		return path, nil
+	case unix.CGROUP_SUPER_MAGIC:
+	default:
+		return "", fmt.Errorf("error:%w , %s isn't a cgroup", ErrNotCGroupV2, path)
+	}
+
+	v1Root, v2Root, err := cgroupMountPoints(path)
+	if err != nil {
+		return "", err
+	}
+	if v2Root == "" {
+		return "", fmt.Errorf("error:%w , %s is a cgroup v1 and cgroup v2 isn't mounted", ErrNotCGroupV2, path)
+	}
+	relative, err := filepath.Rel(v1Root, path)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("error:%v , couldn't translate cgroup %s", err, path))
+	}
+	translated := filepath.Join(v2Root, relative)
+	if err = unix.Statfs(translated, &stat); err != nil || stat.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", fmt.Errorf("error:%w , %s is a cgroup v1 and %s doesn't exist", ErrNotCGroupV2, path, translated)
+	}
+	return translated, nil
+}
+
+// cgroupMountPoints - Returns the cgroup v1 mount point that contains the provided path, and the first cgroup v2 mount
+// point
+func cgroupMountPoints(path string) (string, string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var v1Root, v2Root string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// device mount_point fs_type options dump pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		switch fields[2] {
+		case "cgroup2":
+			if v2Root == "" {
+				v2Root = fields[1]
+			}
+		case "cgroup":
+			if (path == fields[1] || strings.HasPrefix(path, fields[1]+"/")) && len(fields[1]) > len(v1Root) {
+				v1Root = fields[1]
+			}
+		}
+	}
+	return v1Root, v2Root, scanner.Err()
+}
+
+// attachCGroup - Attaches the probe to its cgroup, with a bpf_link unless CGroupAttachFlags is set
+func (p *Probe) attachCGroup() error {
+	if p.CGroupPath == "" {
+		return errors.New("CGroupPath cant be empty.")
+	}
+	path, err := resolveCGroupPath(p.CGroupPath)
+	if err != nil {
+		return err
+	}
+
+	if p.CGroupAttachFlags != 0 {
+		return p.attachCGroupProgram(path)
+	}
+	opts := link.CgroupOptions{
+		Path:    path,
+		Attach:  p.programSpec.AttachType,
+		Program: p.program,
+	}
+	kp, err := link.AttachCgroup(opts)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , failed to attach probe %v to cgroup %s, attach type:%s", err, p.GetIdentificationPair(), path, p.programSpec.AttachType.String()))
+	}
+
+	p.link = kp
+	return nil
+}
+
+// attachCGroupProgram - Attaches the probe to the cgroup at the provided path with BPF_PROG_ATTACH and
+// CGroupAttachFlags
+func (p *Probe) attachCGroupProgram(path string) error {
+	opts := link.RawAttachProgramOptions{
+		Program: p.program,
+		Attach:  p.programSpec.AttachType,
+		Flags:   uint32(p.CGroupAttachFlags),
+	}
+	if p.CGroupAttachFlags&CGroupAttachReplace != 0 {
+		if p.CGroupAttachFlags&CGroupAttachAllowMulti == 0 || p.CGroupReplaceProgramID == 0 {
+			return fmt.Errorf("error:%w , CGroupAttachReplace requires CGroupAttachAllowMulti and CGroupReplaceProgramID", ErrInvalidCGroupFlags)
+		}
+		replace, err := ebpf.NewProgramFromID(p.CGroupReplaceProgramID)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't find program %d to replace", err, p.CGroupReplaceProgramID))
+		}
+		defer replace.Close()
+		opts.Replace = replace
+	}
+
+	cgroup, err := os.Open(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't open cgroup %s", err, path))
+	}
+	opts.Target = int(cgroup.Fd())
+	replaced := p.replacedCGroupProgram(path)
+	if err = link.RawAttachProgram(opts); err != nil {
+		_ = cgroup.Close()
+		return errors.New(fmt.Sprintf("error:%v , failed to attach probe %v to cgroup %s, attach type:%s, flags:%#x", err, p.GetIdentificationPair(), path, p.programSpec.AttachType.String(), uint32(p.CGroupAttachFlags)))
+	}
+	p.cgroupAttachment = &cgroupAttachment{cgroup: cgroup, program: p.program, attachType: p.programSpec.AttachType}
+	p.replaced = replaced
+	return nil
+}
+
+// detachCGroup - Detaches the probe from its cgroup, if it was attached with BPF_PROG_ATTACH
+func (p *Probe) detachCGroup() error {
+	if p.cgroupAttachment == nil {
+		return nil
+	}
+	err := p.cgroupAttachment.Close()
+	p.cgroupAttachment = nil
+	return err
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestResolveCGroupPath(t *testing.T) {
+	if _, err := resolveCGroupPath(t.TempDir()); !errors.Is(err, ErrNotCGroupV2) {
+		t.Errorf("expected ErrNotCGroupV2 outside of a cgroup hierarchy, got %v", err)
+	}
+
+	v1Root, v2Root, err := cgroupMountPoints("/sys/fs/cgroup/memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1Root == "" || v2Root == "" {
+		t.Skip("not a hybrid cgroup system")
+	}
+	path, err := resolveCGroupPath(v1Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != v2Root {
+		t.Errorf("expected %s to be translated to %s, got %s", v1Root, v2Root, path)
+	}
+}
+
+func TestAttachCGroup(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	_, v2Root, err := cgroupMountPoints("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2Root == "" {
+		t.Skip("cgroup v2 isn't mounted")
+	}
+	cgroup := filepath.Join(v2Root, "ebpfmanager_test")
+	if err = os.Mkdir(cgroup, 0755); err != nil {
+		t.Skipf("couldn't create cgroup: %v", err)
+	}
+	defer os.Remove(cgroup)
+
+	spec := &ebpf.ProgramSpec{
+		Type:       ebpf.CGroupSKB,
+		AttachType: ebpf.AttachCGroupInetEgress,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return(),
+		},
+	}
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	for _, flags := range []CGroupAttachFlags{0, CGroupAttachAllowMulti} {
+		p := &Probe{
+			manager:           &Manager{},
+			program:           prog,
+			programSpec:       spec,
+			state:             initialized,
+			Section:           "cgroup_skb/egress",
+			EbpfFuncName:      "egress",
+			Enabled:           true,
+			ProbeRetry:        1,
+			CGroupPath:        cgroup,
+			CGroupAttachFlags: flags,
+		}
+		if err = p.Attach(); err != nil {
+			t.Fatal(err)
+		}
+		if (p.cgroupAttachment != nil) != (flags != 0) {
+			t.Errorf("unexpected attachment with flags %#x", flags)
+		}
+		if err = p.Detach(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &Probe{programSpec: spec, program: prog, CGroupPath: cgroup, CGroupAttachFlags: CGroupAttachReplace}
+	if err = p.attachCGroup(); !errors.Is(err, ErrInvalidCGroupFlags) {
+		t.Errorf("expected ErrInvalidCGroupFlags, got %v", err)
+	}
+}
+
+func TestAdoptPinnedLink(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	_, v2Root, err := cgroupMountPoints("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2Root == "" {
+		t.Skip("cgroup v2 isn't mounted")
+	}
+	cgroup := filepath.Join(v2Root, "ebpfmanager_adopt_test")
+	if err = os.Mkdir(cgroup, 0755); err != nil {
+		t.Skipf("couldn't create cgroup: %v", err)
+	}
+	defer os.Remove(cgroup)
+	pinPath := filepath.Join(mountBPFFS(t), "link")
+
+	newProbe := func(attachType ebpf.AttachType) *Probe {
+		spec := &ebpf.ProgramSpec{
+			Type:       ebpf.CGroupSKB,
+			AttachType: attachType,
+			License:    "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 1),
+				asm.Return(),
+			},
+		}
+		prog, err := ebpf.NewProgram(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = prog.Close() })
+		return &Probe{
+			manager:      &Manager{options: Options{AdoptPinnedLinks: true}},
+			program:      prog,
+			programSpec:  spec,
+			state:        initialized,
+			EbpfFuncName: "egress",
+			Enabled:      true,
+			ProbeRetry:   1,
+			CGroupPath:   cgroup,
+			linkPinPath:  pinPath,
+		}
+	}
+
+	// the first instance crashes, its link stays pinned
+	first := newProbe(ebpf.AttachCGroupInetEgress)
+	if err = first.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	_ = first.link.Close()
+
+	second := newProbe(ebpf.AttachCGroupInetEgress)
+	if err = second.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := second.link.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Program != second.programID() {
+		t.Errorf("expected the adopted link to run the new program %d, got %d", second.programID(), info.Program)
+	}
+	if second.GetLastError() != nil {
+		t.Errorf("unexpected error %v", second.GetLastError())
+	}
+	_ = second.link.Close()
+
+	// a pinned link attached to another hook point is replaced
+	third := newProbe(ebpf.AttachCGroupInetIngress)
+	if err = third.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(third.GetLastError(), ErrIncompatibleLink) {
+		t.Errorf("expected ErrIncompatibleLink, got %v", third.GetLastError())
+	}
+	if info, err = third.link.Info(); err != nil || info.Program != third.programID() {
+		t.Errorf("expected a new link, got %v (%v)", info, err)
+	}
+	if err = third.Detach(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(pinPath); !os.IsNotExist(err) {
+		t.Errorf("expected the link to be unpinned, got %v", err)
+	}
+}
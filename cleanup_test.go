@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestCleanupType(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+	progPin, mapPin := filepath.Join(root, "rewrite"), filepath.Join(root, "map_val")
+	sockets, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(sockets[0])
+	defer unix.Close(sockets[1])
+
+	newManager := func(probeCleanup, mapCleanup CleanupType) (*Manager, error) {
+		elf, err := os.Open("testdata/rewrite.elf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer elf.Close()
+		m := &Manager{
+			Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite", SocketFD: sockets[0], PinPath: progPin, Cleanup: probeCleanup}},
+			Maps:   []*Map{{Name: "map_val", MapOptions: MapOptions{PinPath: mapPin, Cleanup: mapCleanup}}},
+		}
+		return m, m.Init(elf)
+	}
+
+	if _, err = newManager(CleanupCloseFD, CleanupDefault); !errors.Is(err, ErrInvalidCleanupType) {
+		t.Errorf("expected ErrInvalidCleanupType for a probe, got %v", err)
+	}
+	if _, err = newManager(CleanupDefault, CleanupLeaveAttached); !errors.Is(err, ErrInvalidCleanupType) {
+		t.Errorf("expected ErrInvalidCleanupType for a map, got %v", err)
+	}
+
+	// the socket filter stays attached and pinned, the map stays pinned
+	m, err := newManager(CleanupLeaveAttached, CleanupCloseFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	for _, pin := range []string{progPin, mapPin} {
+		if _, err = os.Stat(pin); err != nil {
+			t.Errorf("expected %s to stay pinned: %v", pin, err)
+		}
+	}
+	if err = unix.SetsockoptInt(sockets[0], unix.SOL_SOCKET, unix.SO_DETACH_BPF, 0); err != nil {
+		t.Errorf("expected the socket filter to stay attached: %v", err)
+	}
+
+	// the pinned objects are reused then removed
+	m, err = newManager(CleanupUnpin, CleanupUnpin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Stop(CleanKeepPinned); err != nil {
+		t.Fatal(err)
+	}
+	for _, pin := range []string{progPin, mapPin} {
+		if _, err = os.Stat(pin); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be unpinned, got %v", pin, err)
+		}
+	}
+	if err = unix.SetsockoptInt(sockets[0], unix.SOL_SOCKET, unix.SO_DETACH_BPF, 0); !errors.Is(err, unix.ENOENT) {
+		t.Errorf("expected the socket filter to be detached, got %v", err)
+	}
+}
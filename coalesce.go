@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCoalesceMaxKeys - Default maximum number of keys held by the coalescer of a PerfMap
+const DefaultCoalesceMaxKeys = 4096
+
+// coalescedSample - First sample of a key and number of samples of this key seen within the coalescing window
+type coalescedSample struct {
+	key   string
+	cpu   int
+	data  []byte
+	count uint64
+	first time.Time
+}
+
+// sampleCoalescer - Merges the samples of the same key seen within a time window into their first sample, and
+// delivers it with the number of merged samples once the window expired
+type sampleCoalescer struct {
+	lock    sync.Mutex
+	window  time.Duration
+	maxKeys int
+	keyOf   func(cpu int, data []byte) string
+	pending map[string]*coalescedSample
+	order   []*coalescedSample
+	deliver func(cpu int, data []byte, count uint64)
+}
+
+// newSampleCoalescer - Creates a new sample coalescer, samples are keyed by their content if keyOf isn't set, and a
+// default value is used for the maximum number of keys if it isn't set
+func newSampleCoalescer(window time.Duration, maxKeys int, keyOf func(cpu int, data []byte) string, deliver func(cpu int, data []byte, count uint64)) *sampleCoalescer {
+	if maxKeys <= 0 {
+		maxKeys = DefaultCoalesceMaxKeys
+	}
+	if keyOf == nil {
+		keyOf = func(_ int, data []byte) string {
+			return string(data)
+		}
+	}
+	return &sampleCoalescer{
+		window:  window,
+		maxKeys: maxKeys,
+		keyOf:   keyOf,
+		pending: make(map[string]*coalescedSample),
+		deliver: deliver,
+	}
+}
+
+// push - Merges the provided sample into the pending sample of its key, or holds it as the first sample of its key.
+// The oldest pending sample is delivered early if too many keys are pending.
+func (sc *sampleCoalescer) push(cpu int, data []byte) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	key := sc.keyOf(cpu, data)
+	if sample, ok := sc.pending[key]; ok {
+		sample.count++
+		return
+	}
+	if len(sc.pending) >= sc.maxKeys {
+		sc.deliverOldest()
+	}
+	sample := &coalescedSample{key: key, cpu: cpu, data: data, count: 1, first: time.Now()}
+	sc.pending[key] = sample
+	sc.order = append(sc.order, sample)
+}
+
+// deliverOldest - Delivers the oldest pending sample
+func (sc *sampleCoalescer) deliverOldest() {
+	sample := sc.order[0]
+	sc.order = sc.order[1:]
+	delete(sc.pending, sample.key)
+	sc.deliver(sample.cpu, sample.data, sample.count)
+}
+
+// flushExpired - Delivers the pending samples whose window expired
+func (sc *sampleCoalescer) flushExpired() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	now := time.Now()
+	for len(sc.order) > 0 && now.Sub(sc.order[0].first) >= sc.window {
+		sc.deliverOldest()
+	}
+}
+
+// flushAll - Delivers all the pending samples
+func (sc *sampleCoalescer) flushAll() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	for len(sc.order) > 0 {
+		sc.deliverOldest()
+	}
+}
+
+// deliverCoalesced - Hands the provided coalesced sample over to CoalescedDataHandler, or to the next stage of the
+// perf map with its count dropped
+func (m *PerfMap) deliverCoalesced(CPU int, data []byte, count uint64) {
+	if m.CoalescedDataHandler == nil {
+		m.dispatch(CPU, data)
+		return
+	}
+	m.manager.dispatchEvent(func() {
+		m.manager.runHandler(&m.events, m.Name, "perf_map", CPU, 1, func() {
+			m.CoalescedDataHandler(CPU, data, count, m, m.manager)
+		})
+	}, m.countUserspaceDrop)
+}
+
+// flushCoalescer - Periodically delivers the pending samples whose window expired, until the perf map is stopped. done
+// is closed once the last samples were handed over to the next stages.
+func (m *PerfMap) flushCoalescer(coalescer *sampleCoalescer, stop chan struct{}, done chan struct{}) {
+	defer m.manager.wg.Done()
+	defer close(done)
+	interval := coalescer.window / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			coalescer.flushExpired()
+		case <-stop:
+			coalescer.flushAll()
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package manager
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPerfMapCoalesce(t *testing.T) {
+	var lock sync.Mutex
+	counts := map[byte]uint64{}
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:        true,
+			CoalesceWindow:  time.Hour,
+			CoalesceMaxKeys: 2,
+			CoalesceKey: func(CPU int, data []byte) string {
+				return string(data[:1])
+			},
+			CoalescedDataHandler: func(CPU int, data []byte, count uint64, perfMap *PerfMap, manager *Manager) {
+				lock.Lock()
+				defer lock.Unlock()
+				counts[data[0]] += count
+			},
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range [][]byte{{1, 0}, {1, 1}, {2, 0}, {1, 2}, {2, 1}} {
+		if err := perfMap.InjectSample(0, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lock.Lock()
+	if len(counts) != 0 {
+		t.Errorf("expected the samples to be held until the window expires, got %v", counts)
+	}
+	lock.Unlock()
+
+	// the oldest key is delivered early once CoalesceMaxKeys keys are pending
+	if err := perfMap.InjectSample(0, []byte{3, 0}); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if len(counts) != 1 || counts[1] != 3 {
+		t.Errorf("expected the 3 samples of key 1 to be delivered, got %v", counts)
+	}
+	lock.Unlock()
+
+	// the pending samples are delivered when the perf map is stopped
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+	if counts[2] != 2 || counts[3] != 1 {
+		t.Errorf("expected the pending samples to be flushed, got %v", counts)
+	}
+}
+
+func TestSampleCoalescerWindow(t *testing.T) {
+	var delivered [][]byte
+	coalescer := newSampleCoalescer(20*time.Millisecond, 0, nil, func(cpu int, data []byte, count uint64) {
+		delivered = append(delivered, data)
+	})
+	coalescer.push(0, []byte{1})
+	coalescer.push(0, []byte{1})
+	coalescer.push(0, []byte{2})
+	coalescer.flushExpired()
+	if len(delivered) != 0 {
+		t.Errorf("expected no sample before the window expired, got %v", delivered)
+	}
+	time.Sleep(30 * time.Millisecond)
+	coalescer.flushExpired()
+	if len(delivered) != 2 {
+		t.Errorf("expected the 2 distinct samples to be delivered, got %v", delivered)
+	}
+}
+
+func TestPerfMapStopFlushesStages(t *testing.T) {
+	const samples = 5000
+	var lock sync.Mutex
+	var delivered int
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:        true,
+			OrderedDelivery: true,
+			ReorderWindow:   time.Hour,
+			CoalesceWindow:  time.Hour,
+			CoalesceKey: func(CPU int, data []byte) string {
+				return string(data)
+			},
+			BatchSize:          samples * 2,
+			BatchFlushInterval: time.Hour,
+			BatchDataHandler: func(CPU int, samples [][]byte, perfMap *PerfMap, manager *Manager) {
+				lock.Lock()
+				defer lock.Unlock()
+				delivered += len(samples)
+			},
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < samples; i++ {
+		sample := make([]byte, 8)
+		binary.LittleEndian.PutUint64(sample, uint64(i+1))
+		if err := perfMap.InjectSample(i%4, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the reorder buffer, then the coalescer, then the batcher are flushed before Stop returns
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if delivered != samples {
+		t.Errorf("expected the %d samples to be delivered, got %d", samples, delivered)
+	}
+	lock.Unlock()
+	m.wg.Wait()
+}
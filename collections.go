@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// CollectionAsset - An eBPF object loaded by a manager along with its other objects, see Manager.InitWithAssets
+type CollectionAsset struct {
+	// Namespace - Prefix of the names of the programs and maps of the object, see NamespacedName. The programs and maps
+	// of an object without a namespace keep their names.
+	Namespace string
+
+	// Reader - Reader containing the eBPF bytecode of the object, compressed or not (see RegisterDecompressor).
+	// Ignored if Spec is set.
+	Reader io.ReaderAt
+
+	// FS, Name - File system holding the object, usually an embed.FS, and path of the object in it. The object is read
+	// and decompressed at Init. Ignored if Spec or Reader is set.
+	FS   fs.FS
+	Name string
+
+	// Spec - CollectionSpec of the object. It is modified in place by the manager.
+	Spec *ebpf.CollectionSpec
+
+	// Arch - Architecture the object was built for, as a GOARCH (amd64, arm64...) or as a kernel / clang target name
+	// (x86, x86_64, aarch64...). The objects built for another architecture than the running one are skipped, so that
+	// the objects of every architecture can be bundled with the same Namespace. The objects without an Arch are loaded
+	// on every architecture.
+	Arch string
+}
+
+// archAliases - GOARCH of the kernel and clang names of the architectures
+var archAliases = map[string]string{
+	"x86":     "amd64",
+	"x86_64":  "amd64",
+	"i386":    "386",
+	"i686":    "386",
+	"ia32":    "386",
+	"aarch64": "arm64",
+	"riscv":   "riscv64",
+	"s390":    "s390x",
+}
+
+// normalizeArch - Returns the GOARCH of the provided architecture name
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+	if goarch, ok := archAliases[arch]; ok {
+		return goarch
+	}
+	return arch
+}
+
+// selectArchAssets - Returns the assets that can be loaded on the running architecture, see CollectionAsset.Arch
+func selectArchAssets(assets []CollectionAsset) ([]CollectionAsset, error) {
+	var selected []CollectionAsset
+	for _, asset := range assets {
+		if asset.Arch == "" || normalizeArch(asset.Arch) == runtime.GOARCH {
+			selected = append(selected, asset)
+		}
+	}
+	if len(selected) == 0 && len(assets) > 0 {
+		return nil, errors.New(fmt.Sprintf("error:%v , no asset was built for %s", ErrInvalidAsset, runtime.GOARCH))
+	}
+	return selected, nil
+}
+
+// NamespacedName - Returns the name under which the manager knows the provided program or map of an object with the
+// provided namespace: <namespace>.<name>. The data sections (.rodata, .data, .bss) are named <section>.<namespace>, so
+// that the global constants of every object can be edited with the constant editors. Use it as the EbpfFuncName of the
+// probes and as the name of the maps of the manager.
+func NamespacedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	if strings.HasPrefix(name, ".") {
+		return name + "." + namespace
+	}
+	return namespace + "." + name
+}
+
+// InitWithAssets - Initializes the manager with several eBPF objects, built independently, which then share the
+// lifecycle, the options and the probe list of the manager. The programs and maps of the objects are renamed with
+// their namespace (see NamespacedName). The maps that have the same name in several objects are shared if their
+// definitions match. The BTF used to decode events is the BTF of the first object. The objects built for another
+// architecture are skipped (see CollectionAsset.Arch), and the objects must have the byte order of the kernel.
+func (m *Manager) InitWithAssets(assets []CollectionAsset, options Options) error {
+	return m.initWithOptions(func() (*ebpf.CollectionSpec, error) {
+		return mergeCollectionAssets(assets)
+	}, options)
+}
+
+// loadAssetSpec - Returns the CollectionSpec of the provided asset, with its programs and maps renamed with its
+// namespace
+func loadAssetSpec(asset CollectionAsset) (*ebpf.CollectionSpec, error) {
+	spec := asset.Spec
+	if spec == nil {
+		reader := asset.Reader
+		if reader == nil && asset.FS != nil {
+			var err error
+			if reader, err = readFSAsset(asset.FS, asset.Name); err != nil {
+				return nil, err
+			}
+		}
+		if reader == nil {
+			return nil, errors.New(fmt.Sprintf("error:%v , asset %s has neither a Reader, an FS nor a Spec", ErrInvalidAsset, asset.Namespace))
+		}
+		reader, err := decompressAsset(reader)
+		if err != nil {
+			return nil, err
+		}
+		if spec, err = ebpf.LoadCollectionSpecFromReader(reader); err != nil {
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't load asset %s", err, asset.Namespace))
+		}
+	}
+	if asset.Namespace == "" {
+		return spec, nil
+	}
+
+	maps := make(map[string]*ebpf.MapSpec, len(spec.Maps))
+	for name, mapSpec := range spec.Maps {
+		maps[NamespacedName(asset.Namespace, name)] = mapSpec
+	}
+	programs := make(map[string]*ebpf.ProgramSpec, len(spec.Programs))
+	for name, progSpec := range spec.Programs {
+		// rewrite the map references of the instructions, the maps are looked up by reference when the program is
+		// loaded
+		for i := range progSpec.Instructions {
+			ins := &progSpec.Instructions[i]
+			if !ins.IsLoadFromMap() || ins.Reference() == "" {
+				continue
+			}
+			if _, ok := spec.Maps[ins.Reference()]; ok {
+				*ins = ins.WithReference(NamespacedName(asset.Namespace, ins.Reference()))
+			}
+		}
+		programs[NamespacedName(asset.Namespace, name)] = progSpec
+	}
+	spec.Maps, spec.Programs = maps, programs
+	return spec, nil
+}
+
+// mergeCollectionAssets - Merges the CollectionSpecs of the provided assets into one CollectionSpec
+func mergeCollectionAssets(assets []CollectionAsset) (*ebpf.CollectionSpec, error) {
+	if len(assets) == 0 {
+		return nil, errors.New(fmt.Sprintf("error:%v , no asset provided", ErrInvalidAsset))
+	}
+	assets, err := selectArchAssets(assets)
+	if err != nil {
+		return nil, err
+	}
+	var merged *ebpf.CollectionSpec
+	for _, asset := range assets {
+		spec, err := loadAssetSpec(asset)
+		if err != nil {
+			return nil, err
+		}
+		if spec.ByteOrder != nil && spec.ByteOrder != nativeEndian {
+			return nil, errors.New(fmt.Sprintf("error:%v , asset %s was compiled for %s, the kernel is %s", ErrInvalidAsset, asset.Namespace, spec.ByteOrder, nativeEndian))
+		}
+		if merged == nil {
+			merged = spec
+			continue
+		}
+		if spec.ByteOrder != merged.ByteOrder {
+			return nil, errors.New(fmt.Sprintf("error:%v , asset %s was compiled for another byte order", ErrInvalidAsset, asset.Namespace))
+		}
+		for name, mapSpec := range spec.Maps {
+			existing, ok := merged.Maps[name]
+			if ok && !sameMapDefinition(existing, mapSpec) {
+				return nil, errors.New(fmt.Sprintf("error:%v , map %s of asset %s conflicts with %v", ErrInvalidAsset, name, asset.Namespace, existing))
+			}
+			if !ok {
+				merged.Maps[name] = mapSpec
+			}
+		}
+		for name, progSpec := range spec.Programs {
+			if _, ok := merged.Programs[name]; ok {
+				return nil, errors.New(fmt.Sprintf("error:%v , program %s of asset %s is already defined", ErrInvalidAsset, name, asset.Namespace))
+			}
+			merged.Programs[name] = progSpec
+		}
+	}
+	return merged, nil
+}
+
+// sameMapDefinition - Returns true if the provided map specs can be shared by the programs of several assets. The data
+// sections are never shared.
+func sameMapDefinition(a, b *ebpf.MapSpec) bool {
+	if strings.HasPrefix(a.Name, ".") {
+		return false
+	}
+	return a.Type == b.Type && a.KeySize == b.KeySize && a.ValueSize == b.ValueSize && a.MaxEntries == b.MaxEntries &&
+		a.Flags == b.Flags && a.Pinning == b.Pinning
+}
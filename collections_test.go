@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"encoding/binary"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestNamespacedName(t *testing.T) {
+	for _, tc := range []struct {
+		namespace, name, expected string
+	}{
+		{"", "events", "events"},
+		{"net", "events", "net.events"},
+		{"net", ".rodata", ".rodata.net"},
+	} {
+		if got := NamespacedName(tc.namespace, tc.name); got != tc.expected {
+			t.Errorf("NamespacedName(%q, %q) = %q, expected %q", tc.namespace, tc.name, got, tc.expected)
+		}
+	}
+}
+
+func TestInitWithAssets(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "socket/map", EbpfFuncName: NamespacedName("a", "rewrite_map")},
+			{Section: "socket/map", EbpfFuncName: NamespacedName("b", "rewrite_map")},
+		},
+		Maps: []*Map{{Name: NamespacedName("a", "map_val")}, {Name: NamespacedName("b", "map_val")}},
+	}
+	assets := []CollectionAsset{{Namespace: "a", Reader: first}, {Namespace: "b", Reader: second}}
+	if err = m.InitWithAssets(assets, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	for i, namespace := range []string{"a", "b"} {
+		array, found, err := m.GetMap(NamespacedName(namespace, "map_val"))
+		if err != nil || !found {
+			t.Fatalf("couldn't find the map of asset %s: %v", namespace, err)
+		}
+		if err = array.Put(uint32(0), uint32(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, namespace := range []string{"a", "b"} {
+		_, ret, _, err := m.TestRunProgram(NamespacedName(namespace, "rewrite_map"), make([]byte, 14), 1)
+		if err != nil {
+			t.Skipf("test run not supported: %v", err)
+		}
+		if ret != uint32(i+1) {
+			t.Errorf("expected the program of asset %s to read its own map, got %d", namespace, ret)
+		}
+	}
+}
+
+func TestInitWithConflictingAssets(t *testing.T) {
+	first, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	m := &Manager{}
+	err = m.InitWithAssets([]CollectionAsset{{Reader: first}, {Reader: second}}, Options{})
+	if err == nil {
+		t.Fatal("expected an error for the programs defined by both assets")
+	}
+	if err = m.InitWithAssets(nil, Options{}); err == nil {
+		t.Fatal("expected an error without assets")
+	}
+}
+
+func TestInitWithArchAssets(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	native, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer native.Close()
+	foreign, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	otherArch := "aarch64"
+	if runtime.GOARCH == "arm64" {
+		otherArch = "x86_64"
+	}
+
+	// both objects define the same programs, only the one of the running architecture is loaded
+	m := &Manager{Probes: []*Probe{{Section: "socket/map", EbpfFuncName: NamespacedName("a", "rewrite_map")}}}
+	assets := []CollectionAsset{
+		{Namespace: "a", Reader: foreign, Arch: otherArch},
+		{Namespace: "a", Reader: native, Arch: runtime.GOARCH},
+	}
+	if err = m.InitWithAssets(assets, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Stop(CleanAll)
+
+	m = &Manager{}
+	if err = m.InitWithAssets([]CollectionAsset{{Reader: foreign, Arch: otherArch}}, Options{}); err == nil {
+		t.Errorf("expected an error without an asset for %s", runtime.GOARCH)
+	}
+
+	var otherEndian binary.ByteOrder = binary.BigEndian
+	if nativeEndian == binary.BigEndian {
+		otherEndian = binary.LittleEndian
+	}
+	spec := &ebpf.CollectionSpec{ByteOrder: otherEndian, Maps: map[string]*ebpf.MapSpec{}, Programs: map[string]*ebpf.ProgramSpec{}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err == nil {
+		t.Error("expected an error for an object of another byte order")
+	}
+}
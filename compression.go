@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// Decompressor - Returns a reader decompressing the provided compressed stream, see RegisterDecompressor
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+// compressionFormat - Compression format detected from the magic number at the beginning of an asset
+type compressionFormat struct {
+	name       string
+	magic      []byte
+	decompress Decompressor
+}
+
+var (
+	compressionLock sync.RWMutex
+	// compressionFormats - gzip is decompressed with the standard library. The zstd magic number is known so that a
+	// zstd asset is reported as such when no zstd Decompressor was registered.
+	compressionFormats = []*compressionFormat{
+		{name: "gzip", magic: []byte{0x1f, 0x8b}, decompress: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}},
+		{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	}
+)
+
+// RegisterDecompressor - Registers the decompressor of the assets that start with the provided magic number, or
+// replaces the decompressor of a known format. The eBPF objects (see CollectionAsset) and the BTF files (see
+// LoadBTFSpec) compressed with a registered format are transparently decompressed at Init. gzip is supported out of
+// the box, zstd requires a decompressor, for instance with github.com/klauspost/compress/zstd:
+//
+//	manager.RegisterDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) {
+//		return zstd.NewReader(r)
+//	})
+func RegisterDecompressor(name string, magic []byte, decompress Decompressor) {
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+	for _, format := range compressionFormats {
+		if format.name == name || bytes.Equal(format.magic, magic) {
+			format.name, format.magic, format.decompress = name, append([]byte(nil), magic...), decompress
+			return
+		}
+	}
+	compressionFormats = append(compressionFormats, &compressionFormat{name: name, magic: append([]byte(nil), magic...), decompress: decompress})
+}
+
+// detectCompression - Returns the compression format of the provided asset, nil if it isn't compressed
+func detectCompression(r io.ReaderAt) *compressionFormat {
+	header := make([]byte, 16)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+
+	compressionLock.RLock()
+	defer compressionLock.RUnlock()
+	for _, format := range compressionFormats {
+		if len(format.magic) > 0 && bytes.HasPrefix(header, format.magic) {
+			return format
+		}
+	}
+	return nil
+}
+
+// decompressAsset - Returns the decompressed content of the provided asset, or the asset itself if it isn't
+// compressed with a known format
+func decompressAsset(r io.ReaderAt) (io.ReaderAt, error) {
+	format := detectCompression(r)
+	if format == nil {
+		return r, nil
+	}
+	if format.decompress == nil {
+		return nil, fmt.Errorf("error:%w , no decompressor registered for %s, see RegisterDecompressor", ErrInvalidAsset, format.name)
+	}
+	decompressed, err := format.decompress(io.NewSectionReader(r, 0, 1<<63-1))
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't decompress %s asset: %v", ErrInvalidAsset, format.name, err)
+	}
+	if closer, ok := decompressed.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data, err := io.ReadAll(decompressed)
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't decompress %s asset: %v", ErrInvalidAsset, format.name, err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// readFSAsset - Reads the provided file of the provided file system, an embed.FS for instance
+func readFSAsset(fsys fs.FS, name string) (io.ReaderAt, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't read asset %s", err, name))
+	}
+	return bytes.NewReader(data), nil
+}
+
+// InitWithFS - Initializes the manager with the eBPF object stored at the provided path of the provided file system,
+// usually an embed.FS. The object can be compressed, see RegisterDecompressor.
+func (m *Manager) InitWithFS(fsys fs.FS, name string, options Options) error {
+	return m.InitWithAssets([]CollectionAsset{{FS: fsys, Name: name}}, options)
+}
+
+// LoadBTFSpec - Parses the BTF (raw BTF or ELF with a .BTF section) stored in the provided reader, compressed or not
+// (see RegisterDecompressor). Use it to embed the BTF of the kernels that don't expose theirs, and set the result as
+// Options.KernelTypes.
+func LoadBTFSpec(r io.ReaderAt) (*btf.Spec, error) {
+	decompressed, err := decompressAsset(r)
+	if err != nil {
+		return nil, err
+	}
+	return btf.LoadSpecFromReader(decompressed)
+}
+
+// LoadBTFSpecFromFS - Parses the BTF stored at the provided path of the provided file system, see LoadBTFSpec
+func LoadBTFSpecFromFS(fsys fs.FS, name string) (*btf.Spec, error) {
+	r, err := readFSAsset(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := LoadBTFSpec(r)
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't parse BTF %s", err, name)
+	}
+	return spec, nil
+}
+
+// loadBTFFile - Parses the BTF file at the provided path, compressed or not
+func loadBTFFile(path string) (*btf.Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBTFSpec(f)
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressedAssets(t *testing.T) {
+	elf, err := os.ReadFile("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := loadAssetSpec(CollectionAsset{Reader: bytes.NewReader(elf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"probes/rewrite.elf.gz": {Data: gzipBytes(t, elf)}}
+	for _, asset := range []CollectionAsset{
+		{Reader: bytes.NewReader(gzipBytes(t, elf))},
+		{FS: fsys, Name: "probes/rewrite.elf.gz"},
+	} {
+		spec, err := loadAssetSpec(asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spec.Programs) != len(expected.Programs) || len(spec.Maps) != len(expected.Maps) {
+			t.Errorf("expected %d programs and %d maps, got %d and %d", len(expected.Programs), len(expected.Maps), len(spec.Programs), len(spec.Maps))
+		}
+	}
+	if _, err = loadAssetSpec(CollectionAsset{FS: fsys, Name: "probes/missing.elf"}); err == nil {
+		t.Error("expected a missing file to be reported")
+	}
+
+	// zstd requires a registered decompressor
+	zstdAsset := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, elf...)
+	if _, err = loadAssetSpec(CollectionAsset{Reader: bytes.NewReader(zstdAsset)}); !errors.Is(err, ErrInvalidAsset) {
+		t.Errorf("expected ErrInvalidAsset, got %v", err)
+	}
+	RegisterDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) {
+		// skip the magic number of the fake format
+		_, err := io.CopyN(io.Discard, r, 4)
+		return r, err
+	})
+	defer RegisterDecompressor("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, nil)
+	if _, err = loadAssetSpec(CollectionAsset{Reader: bytes.NewReader(zstdAsset)}); err != nil {
+		t.Errorf("expected the registered decompressor to be used, got %v", err)
+	}
+}
+
+func TestLoadBTFSpecFromFS(t *testing.T) {
+	elf, err := os.ReadFile("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadBTFSpecFromFS(fstest.MapFS{"rewrite.btf.gz": {Data: gzipBytes(t, elf)}}, "rewrite.btf.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec == nil {
+		t.Fatal("expected a BTF spec")
+	}
+}
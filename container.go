@@ -0,0 +1,330 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ContainerScope - Kernel identifiers of a container, used to scope the events of the probes to the container with
+// filters or rewritten constants. See ResolveContainer.
+type ContainerScope struct {
+	// ContainerID - ID of the container, or the cgroup path it was resolved from
+	ContainerID string
+	// CGroupPath - Path of the cgroup v2 of the container
+	CGroupPath string
+	// CGroupID - Kernel ID of the cgroup of the container, as returned by bpf_get_current_cgroup_id
+	CGroupID uint64
+	// PID - A process of the container, 0 if the cgroup of the container doesn't have any process
+	PID int
+	// MntNS - Inode number of the mount namespace of PID, 0 if the cgroup of the container doesn't have any process
+	MntNS uint32
+}
+
+// ConstantEditors - Returns the constant editors rewriting the provided constants with the cgroup ID (uint64) and
+// the mount namespace (uint32) of the container. The constants are applied when the manager is initialized, use the
+// filters to change the scope of the probes at runtime, see Manager.AddContainer. An empty name skips its constant.
+func (s ContainerScope) ConstantEditors(cgroupIDConstant string, mntNSConstant string) []ConstantEditor {
+	var editors []ConstantEditor
+	if cgroupIDConstant != "" {
+		editors = append(editors, ConstantEditor{Name: cgroupIDConstant, Value: s.CGroupID})
+	}
+	if mntNSConstant != "" {
+		editors = append(editors, ConstantEditor{Name: mntNSConstant, Value: s.MntNS})
+	}
+	return editors
+}
+
+// CGroupID - Returns the kernel ID of the cgroup v2 at the provided path
+func CGroupID(path string) (uint64, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err == nil && len(handle.Bytes()) == 8 {
+		return nativeEndian.Uint64(handle.Bytes()), nil
+	}
+	// the ID of a cgroup v2 is the inode number of its directory
+	var stat unix.Stat_t
+	if err = unix.Stat(path, &stat); err != nil {
+		return 0, errors.New(fmt.Sprintf("error:%v , couldn't resolve the ID of cgroup %s", err, path))
+	}
+	return stat.Ino, nil
+}
+
+// MountNamespace - Returns the inode number of the mount namespace of the provided process
+func MountNamespace(pid int) (uint32, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(fmt.Sprintf("/proc/%d/ns/mnt", pid), &stat); err != nil {
+		return 0, errors.New(fmt.Sprintf("error:%v , couldn't resolve the mount namespace of process %d", err, pid))
+	}
+	return uint32(stat.Ino), nil
+}
+
+// errContainerFound - Stops the walk of the cgroup hierarchy once the cgroup of the container was found
+var errContainerFound = errors.New("container found")
+
+// FindContainerCGroup - Returns the path of the cgroup v2 of the container with the provided ID, in the cgroup v2
+// hierarchy mounted at root (the first cgroup v2 mount point when empty). The cgroup of a container is the first
+// directory whose name contains the container ID: <id>, docker-<id>.scope, cri-containerd-<id>.scope...
+func FindContainerCGroup(root string, containerID string) (string, error) {
+	if containerID == "" {
+		return "", fmt.Errorf("error:%w , empty container ID", ErrUnknownContainer)
+	}
+	if root == "" {
+		_, v2Root, err := cgroupMountPoints("")
+		if err != nil {
+			return "", err
+		}
+		if v2Root == "" {
+			return "", fmt.Errorf("error:%w , cgroup v2 isn't mounted", ErrNotCGroupV2)
+		}
+		root = v2Root
+	}
+	var found string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// the cgroups of the processes that exit disappear during the walk
+			return nil
+		}
+		if entry.IsDir() && strings.Contains(entry.Name(), containerID) {
+			found = path
+			return errContainerFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errContainerFound) {
+		return "", errors.New(fmt.Sprintf("error:%v , couldn't walk cgroup hierarchy %s", err, root))
+	}
+	if found == "" {
+		return "", fmt.Errorf("error:%w , container %s in %s", ErrUnknownContainer, containerID, root)
+	}
+	return found, nil
+}
+
+// cgroupProcess - Returns a process of the cgroup at the provided path or of its descendants, 0 if they don't have
+// any process
+func cgroupProcess(path string) int {
+	pid := 0
+	_ = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		procs, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			return nil
+		}
+		for _, line := range strings.Fields(string(procs)) {
+			if pid, err = strconv.Atoi(line); err == nil && pid > 0 {
+				return errContainerFound
+			}
+		}
+		pid = 0
+		return nil
+	})
+	return pid
+}
+
+// ResolveContainer - Resolves the kernel identifiers of the provided container: a container ID looked up in the cgroup
+// v2 hierarchy mounted at root (see FindContainerCGroup), or the path of its cgroup, absolute or relative to root
+func ResolveContainer(root string, containerID string) (ContainerScope, error) {
+	scope := ContainerScope{ContainerID: containerID}
+	if strings.HasPrefix(containerID, "/") {
+		scope.CGroupPath = containerID
+		if _, err := os.Stat(containerID); err != nil {
+			if root == "" {
+				_, root, _ = cgroupMountPoints("")
+			}
+			scope.CGroupPath = filepath.Join(root, containerID)
+		}
+		if _, err := os.Stat(scope.CGroupPath); err != nil {
+			return scope, fmt.Errorf("error:%w , cgroup %s: %v", ErrUnknownContainer, containerID, err)
+		}
+	} else {
+		path, err := FindContainerCGroup(root, containerID)
+		if err != nil {
+			return scope, err
+		}
+		scope.CGroupPath = path
+	}
+
+	var err error
+	if scope.CGroupID, err = CGroupID(scope.CGroupPath); err != nil {
+		return scope, err
+	}
+	if scope.PID = cgroupProcess(scope.CGroupPath); scope.PID != 0 {
+		if scope.MntNS, err = MountNamespace(scope.PID); err != nil {
+			// the process exited in the meantime
+			scope.PID = 0
+		}
+	}
+	return scope, nil
+}
+
+// ContainerOptions - Options of a container added to the filters of the manager, see Manager.AddContainer
+type ContainerOptions struct {
+	// CGroupRoot - Mount point of the cgroup v2 hierarchy. Defaults to the first cgroup v2 mount point.
+	CGroupRoot string
+
+	// CGroupFilter - Name of the FilterCGroupID filter the cgroup ID of the container is added to. Ignored when empty.
+	CGroupFilter string
+
+	// MntNSFilter - Name of the FilterMntNS filter the mount namespace of the container is added to. Ignored when empty.
+	MntNSFilter string
+
+	// RefreshInterval - Interval at which the identifiers of the container are resolved again, so that the filters
+	// follow the container when it restarts. Disabled when 0.
+	RefreshInterval time.Duration
+
+	// RefreshHandler - (RefreshInterval) Callback function called when the identifiers of the container changed, or
+	// couldn't be resolved (err is then set and the filters are left unchanged)
+	RefreshHandler func(previous ContainerScope, current ContainerScope, err error)
+}
+
+// containerWatch - Container added to the filters of the manager
+type containerWatch struct {
+	options ContainerOptions
+	scope   ContainerScope
+	stop    chan struct{}
+}
+
+// AddContainer - Resolves the provided container (see ResolveContainer) and adds its cgroup ID and its mount
+// namespace to the filters of the manager selected in options, so that the probes are scoped to the container. When
+// options.RefreshInterval is set, the filters are updated when the container restarts. See RemoveContainer.
+func (m *Manager) AddContainer(containerID string, options ContainerOptions) (ContainerScope, error) {
+	scope, err := ResolveContainer(options.CGroupRoot, containerID)
+	if err != nil {
+		return scope, err
+	}
+	watch := &containerWatch{options: options, scope: scope}
+
+	m.containerLock.Lock()
+	defer m.containerLock.Unlock()
+	if _, exists := m.containers[containerID]; exists {
+		return scope, fmt.Errorf("error:%w , container %s was already added", ErrInvalidFilter, containerID)
+	}
+	if err = m.updateContainerFilters(options, ContainerScope{}, scope); err != nil {
+		return scope, err
+	}
+	if m.containers == nil {
+		m.containers = make(map[string]*containerWatch)
+	}
+	m.containers[containerID] = watch
+	if options.RefreshInterval > 0 {
+		watch.stop = make(chan struct{})
+		m.wg.Add(1)
+		go m.refreshContainer(containerID, watch)
+	}
+	return scope, nil
+}
+
+// RemoveContainer - Removes the provided container from the filters of the manager, see AddContainer
+func (m *Manager) RemoveContainer(containerID string) error {
+	m.containerLock.Lock()
+	defer m.containerLock.Unlock()
+	watch, ok := m.containers[containerID]
+	if !ok {
+		return fmt.Errorf("error:%w , container %s", ErrUnknownContainer, containerID)
+	}
+	if watch.stop != nil {
+		close(watch.stop)
+	}
+	delete(m.containers, containerID)
+	return m.updateContainerFilters(watch.options, watch.scope, ContainerScope{})
+}
+
+// updateContainerFilters - Replaces the identifiers of the previous scope of a container by the ones of its current
+// scope in the selected filters. A zero identifier is neither removed nor added.
+func (m *Manager) updateContainerFilters(options ContainerOptions, previous ContainerScope, current ContainerScope) error {
+	if options.CGroupFilter != "" && previous.CGroupID != current.CGroupID {
+		filter, err := m.CGroupFilter(options.CGroupFilter)
+		if err != nil {
+			return err
+		}
+		if previous.CGroupID != 0 {
+			if err = filter.Remove(previous.CGroupID); err != nil {
+				return err
+			}
+		}
+		if current.CGroupID != 0 {
+			if err = filter.Add(current.CGroupID); err != nil {
+				return err
+			}
+		}
+	}
+	if options.MntNSFilter != "" && previous.MntNS != current.MntNS {
+		filter, err := m.MntNSFilter(options.MntNSFilter)
+		if err != nil {
+			return err
+		}
+		if previous.MntNS != 0 {
+			if err = filter.Remove(previous.MntNS); err != nil {
+				return err
+			}
+		}
+		if current.MntNS != 0 {
+			if err = filter.Add(current.MntNS); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refreshContainer - Resolves the identifiers of the provided container every RefreshInterval, and updates the
+// filters when they changed
+func (m *Manager) refreshContainer(containerID string, watch *containerWatch) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(watch.options.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watch.stop:
+			return
+		case <-ticker.C:
+		}
+		current, err := ResolveContainer(watch.options.CGroupRoot, containerID)
+
+		m.containerLock.Lock()
+		select {
+		case <-watch.stop:
+			m.containerLock.Unlock()
+			return
+		default:
+		}
+		previous := watch.scope
+		if err == nil && (current.CGroupID != previous.CGroupID || current.MntNS != previous.MntNS) {
+			if err = m.updateContainerFilters(watch.options, previous, current); err == nil {
+				watch.scope = current
+			}
+		} else if err == nil {
+			// nothing changed
+			m.containerLock.Unlock()
+			continue
+		}
+		m.containerLock.Unlock()
+
+		if err != nil {
+			m.reportError(err)
+		}
+		if watch.options.RefreshHandler != nil {
+			watch.options.RefreshHandler(previous, current, err)
+		}
+	}
+}
+
+// stopContainerWatches - Stops refreshing the containers added to the filters of the manager
+func (m *Manager) stopContainerWatches() {
+	m.containerLock.Lock()
+	defer m.containerLock.Unlock()
+	for _, watch := range m.containers {
+		if watch.stop != nil {
+			close(watch.stop)
+		}
+	}
+	m.containers = nil
+}
@@ -0,0 +1,272 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultContainerRefreshInterval - Default interval at which the veth pairs of the container of a probe are resolved
+// again, see Probe.ContainerRefreshInterval
+const DefaultContainerRefreshInterval = 5 * time.Second
+
+// ethtoolStatsStringSet - ETH_SS_STATS, the string set of the names of the statistics of a network device
+const ethtoolStatsStringSet = 1
+
+// ethtoolStringLen - ETH_GSTRING_LEN
+const ethtoolStringLen = 32
+
+// ethtoolIfreq - struct ifreq, with the ethtool command in ifr_data
+type ethtoolIfreq struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// ethtool - Runs the ethtool command in cmd on the provided interface
+func ethtool(fd int, ifname string, cmd []byte) error {
+	ifr := ethtoolIfreq{data: unsafe.Pointer(&cmd[0])}
+	copy(ifr.name[:unix.IFNAMSIZ-1], ifname)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(cmd)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// vethPeerIfindex - Returns the interface index of the peer of the provided veth interface, read from its
+// "peer_ifindex" ethtool statistic. The index is the one of the network namespace of the peer. ok is false if the
+// interface isn't a veth interface.
+func vethPeerIfindex(fd int, ifname string) (ifindex int32, ok bool, err error) {
+	info, err := unix.IoctlGetEthtoolDrvinfo(fd, ifname)
+	if err != nil {
+		return 0, false, err
+	}
+	if unix.ByteSliceToString(info.Driver[:]) != "veth" || info.N_stats == 0 {
+		return 0, false, nil
+	}
+	count := int(info.N_stats)
+
+	names := make([]byte, 12+count*ethtoolStringLen)
+	nativeEndian.PutUint32(names[0:4], unix.ETHTOOL_GSTRINGS)
+	nativeEndian.PutUint32(names[4:8], ethtoolStatsStringSet)
+	nativeEndian.PutUint32(names[8:12], uint32(count))
+	if err = ethtool(fd, ifname, names); err != nil {
+		return 0, false, err
+	}
+	stats := make([]byte, 8+count*8)
+	nativeEndian.PutUint32(stats[0:4], unix.ETHTOOL_GSTATS)
+	nativeEndian.PutUint32(stats[4:8], uint32(count))
+	if err = ethtool(fd, ifname, stats); err != nil {
+		return 0, false, err
+	}
+	for i := 0; i < count; i++ {
+		name := names[12+i*ethtoolStringLen : 12+(i+1)*ethtoolStringLen]
+		if unix.ByteSliceToString(name) == "peer_ifindex" {
+			return int32(nativeEndian.Uint64(stats[8+i*8:])), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// processInterfaces - Returns the names of the network interfaces of the network namespace of the provided process
+func processInterfaces(pid int) ([]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the first 2 lines are headers, without colon
+		if name, _, ok := strings.Cut(scanner.Text(), ":"); ok {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names, scanner.Err()
+}
+
+// VethPair - veth interface of a container, see ContainerVethPairs
+type VethPair struct {
+	// Ifindex, Ifname - Interface in the network namespace of the container
+	Ifindex int32
+	Ifname  string
+
+	// PeerIfindex - Index of the peer of the interface, in the network namespace of the peer: usually the host side of
+	// the veth pair
+	PeerIfindex int32
+}
+
+// ContainerVethPairs - Returns the veth interfaces of the network namespace of the provided process, and the indexes
+// of their peers. The veth pairs are found with ethtool, without netlink.
+func ContainerVethPairs(pid int) ([]VethPair, error) {
+	names, err := processInterfaces(pid)
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't list the interfaces of process %d: %v", ErrUnknownContainer, pid, err)
+	}
+	// the socket stays in the network namespace it was created in
+	fd := -1
+	if err = runInNetns(fmt.Sprintf("/proc/%d/ns/net", pid), func() error {
+		fd, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	var pairs []VethPair
+	for _, name := range names {
+		peer, ok, err := vethPeerIfindex(fd, name)
+		if err != nil || !ok {
+			// the interface was deleted in the meantime, or isn't a veth interface
+			continue
+		}
+		ifr, err := unix.NewIfreq(name)
+		if err != nil {
+			continue
+		}
+		if err = unix.IoctlIfreq(fd, unix.SIOCGIFINDEX, ifr); err != nil {
+			continue
+		}
+		pairs = append(pairs, VethPair{Ifindex: int32(ifr.Uint32()), Ifname: name, PeerIfindex: peer})
+	}
+	return pairs, nil
+}
+
+// containerPID - Returns a process of the container of the probe, see Container and ContainerPID
+func (p *Probe) containerPID() (int, error) {
+	if p.Container == "" {
+		return p.ContainerPID, nil
+	}
+	scope, err := ResolveContainer("", p.Container)
+	if err != nil {
+		return 0, err
+	}
+	if scope.PID == 0 {
+		return 0, fmt.Errorf("error:%w , container %s doesn't have any process", ErrUnknownContainer, p.Container)
+	}
+	return scope.PID, nil
+}
+
+// resolveContainerInterfaces - Returns the interfaces of the network namespace of the probe that are the peers of the
+// veth interfaces of its container, indexed by interface index
+func (p *Probe) resolveContainerInterfaces() (map[int32]string, error) {
+	pid, err := p.containerPID()
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := ContainerVethPairs(pid)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make(map[int32]string, len(pairs))
+	err = runInNetns(p.NetnsPath, func() error {
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+		for _, pair := range pairs {
+			// the peers in another network namespace don't exist here, or are other interfaces with the same index
+			iface, err := net.InterfaceByIndex(int(pair.PeerIfindex))
+			if err != nil {
+				continue
+			}
+			if peer, ok, err := vethPeerIfindex(fd, iface.Name); err == nil && ok && peer == pair.Ifindex {
+				interfaces[pair.PeerIfindex] = iface.Name
+			}
+		}
+		return nil
+	})
+	return interfaces, err
+}
+
+// isContainerScoped - Returns true if the probe is attached to the veth pairs of a container, see Container and
+// ContainerPID
+func (p *Probe) isContainerScoped() bool {
+	return p.Container != "" || p.ContainerPID != 0
+}
+
+// attachContainerInterfaces - (TC classifiers & XDP) Attaches the probe to the host side of the veth pairs of its
+// container, and resolves them again every ContainerRefreshInterval to follow the container as it restarts
+func (p *Probe) attachContainerInterfaces() error {
+	interfaces, err := p.resolveContainerInterfaces()
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't resolve the interfaces of the container of probe %v", err, p.GetIdentificationPair()))
+	}
+	interval := p.ContainerRefreshInterval
+	if interval == 0 {
+		interval = DefaultContainerRefreshInterval
+	}
+
+	p.interfaceLock.Lock()
+	defer p.interfaceLock.Unlock()
+	p.interfaceAttachments = make(map[int32]*InterfaceAttachment)
+	for ifindex, ifname := range interfaces {
+		p.attachInterface(ifindex, ifname)
+	}
+	p.interfaceWatchStop = make(chan struct{})
+	p.interfaceWatchDone = make(chan struct{})
+	go p.refreshContainerInterfaces(interval, p.interfaceWatchStop, p.interfaceWatchDone)
+	return nil
+}
+
+// refreshContainerInterfaces - Resolves the interfaces of the container of the probe every interval, and attaches or
+// detaches the probe as they are created and deleted, until stop is closed
+func (p *Probe) refreshContainerInterfaces(interval time.Duration, stop chan struct{}, exited chan struct{}) {
+	defer close(exited)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		interfaces, resolveErr := p.resolveContainerInterfaces()
+		if resolveErr != nil {
+			// the container is restarting, only the deleted interfaces are detached
+			p.manager.reportError(fmt.Errorf("error:%w , couldn't resolve the interfaces of the container of probe %v", resolveErr, p.GetIdentificationPair()))
+		}
+
+		var err error
+		p.interfaceLock.Lock()
+		for ifindex, attachment := range p.interfaceAttachments {
+			if _, ok := interfaces[ifindex]; ok {
+				continue
+			}
+			deleted := !interfaceExists(p.NetnsPath, ifindex, attachment.Ifname)
+			if resolveErr == nil || deleted {
+				err = ConcatErrors(err, p.detachInterface(ifindex, deleted))
+			}
+		}
+		for ifindex, ifname := range interfaces {
+			p.attachInterface(ifindex, ifname)
+		}
+		p.interfaceLock.Unlock()
+		if err != nil {
+			p.manager.reportError(fmt.Errorf("error:%w , couldn't detach probe %v from the interfaces of its container", err, p.GetIdentificationPair()))
+		}
+	}
+}
+
+// interfaceExists - Returns true if the provided interface exists in the provided network namespace
+func interfaceExists(netnsPath string, ifindex int32, ifname string) bool {
+	exists := false
+	_ = runInNetns(netnsPath, func() error {
+		iface, err := net.InterfaceByIndex(int(ifindex))
+		exists = err == nil && iface.Name == ifname
+		return nil
+	})
+	return exists
+}
@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"os/exec"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+)
+
+func TestContainerInterfaces(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	host := newNetns(t)
+	container := exec.Command("sleep", "60")
+	container.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	if err := container.Start(); err != nil {
+		t.Skipf("couldn't start a process in a network namespace: %v", err)
+	}
+	defer func() {
+		_ = container.Process.Kill()
+		_ = container.Wait()
+	}()
+	pid := container.Process.Pid
+
+	// veth pairs between the host and the container, and a veth pair that stays on the host
+	addVeth := func(name string, peer string, peerPID int) {
+		t.Helper()
+		if err := runInNetns(host, func() error {
+			if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer}); err != nil {
+				return err
+			}
+			if peerPID == 0 {
+				return nil
+			}
+			link, err := netlink.LinkByName(peer)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetNsPid(link, peerPID)
+		}); err != nil {
+			t.Skipf("couldn't create veth pair: %v", err)
+		}
+	}
+	addVeth("host0", "eth0", pid)
+	addVeth("other0", "otherpeer0", 0)
+
+	pairs, err := ContainerVethPairs(pid)
+	if err != nil || len(pairs) != 1 || pairs[0].Ifname != "eth0" {
+		t.Fatalf("unexpected veth pairs %+v (%v)", pairs, err)
+	}
+
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"ingress": {
+			Name:        "ingress",
+			Type:        ebpf.SchedCLS,
+			SectionName: "classifier/ingress",
+			License:     "MIT",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		},
+	}}
+	probe := &Probe{
+		Section:                  "classifier/ingress",
+		EbpfFuncName:             "ingress",
+		NetnsPath:                host,
+		NetworkDirection:         Ingress,
+		ContainerPID:             pid,
+		ContainerRefreshInterval: 20 * time.Millisecond,
+	}
+	m := &Manager{Probes: []*Probe{probe}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(expected ...string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			var names []string
+			for _, attachment := range probe.GetInterfaceAttachments() {
+				if attachment.Err == nil {
+					names = append(names, attachment.Ifname)
+				}
+			}
+			sort.Strings(names)
+			if len(names) == len(expected) && (len(names) == 0 || names[0] == expected[0]) && (len(names) < 2 || names[1] == expected[1]) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the probe to be attached to %v, got %v", expected, names)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("host0")
+
+	// the probe follows the veth pairs of the container
+	addVeth("host1", "eth1", pid)
+	waitFor("host0", "host1")
+	if err = runInNetns(host, func() error {
+		link, err := netlink.LinkByName("host0")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("host1")
+
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if attachments := probe.GetInterfaceAttachments(); len(attachments) != 0 {
+		t.Errorf("expected the probe to be detached from all the interfaces, got %v", attachments)
+	}
+}
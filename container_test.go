@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestContainerFilters(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	_, root, err := cgroupMountPoints("")
+	if err != nil || root == "" {
+		t.Skip("cgroup v2 isn't mounted")
+	}
+	containerID := fmt.Sprintf("ebpfmanager%d", os.Getpid())
+	path := filepath.Join(root, "docker-"+containerID+".scope")
+
+	// start a "container": a process in its own cgroup
+	startContainer := func() *exec.Cmd {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Skipf("couldn't create a cgroup: %v", err)
+		}
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+			_ = cmd.Process.Kill()
+			t.Skipf("couldn't move a process to the cgroup: %v", err)
+		}
+		return cmd
+	}
+	stopContainer := func(cmd *exec.Cmd) {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = os.Remove(path)
+	}
+	container := startContainer()
+	defer func() { stopContainer(container) }()
+
+	m := &Manager{}
+	options := Options{Filters: []FilterSpec{{Name: "cgroups", Kind: FilterCGroupID}, {Name: "mnt_ns", Kind: FilterMntNS}}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: &ebpf.CollectionSpec{}}}, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	refreshed := make(chan ContainerScope, 1)
+	scope, err := m.AddContainer(containerID, ContainerOptions{
+		CGroupRoot:      root,
+		CGroupFilter:    "cgroups",
+		MntNSFilter:     "mnt_ns",
+		RefreshInterval: 10 * time.Millisecond,
+		RefreshHandler: func(previous ContainerScope, current ContainerScope, err error) {
+			if err != nil {
+				return
+			}
+			select {
+			case refreshed <- current:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope.CGroupPath != path || scope.PID != container.Process.Pid {
+		t.Errorf("unexpected scope %+v", scope)
+	}
+	mntNS, _ := MountNamespace(container.Process.Pid)
+	cgroups, _ := m.CGroupFilter("cgroups")
+	mntNSs, _ := m.MntNSFilter("mnt_ns")
+	if ok, _ := cgroups.Contains(scope.CGroupID); !ok || scope.MntNS != mntNS {
+		t.Errorf("expected the cgroup ID of the container in the filter, got %+v", scope)
+	}
+	if ok, _ := mntNSs.Contains(mntNS); !ok {
+		t.Error("expected the mount namespace of the container in the filter")
+	}
+
+	// the filters follow the container when it restarts in a new cgroup
+	stopContainer(container)
+	container = startContainer()
+	select {
+	case current := <-refreshed:
+		if current.CGroupID == scope.CGroupID {
+			t.Fatal("expected a new cgroup ID")
+		}
+		ids, _ := cgroups.List()
+		if len(ids) != 1 || ids[0] != current.CGroupID {
+			t.Errorf("expected the filter to only hold the new cgroup ID %d, got %v", current.CGroupID, ids)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the container wasn't refreshed")
+	}
+
+	if err = m.RemoveContainer(containerID); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := cgroups.List(); len(ids) != 0 {
+		t.Errorf("expected an empty filter, got %v", ids)
+	}
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// btfHubArch - Returns the architecture directory of the BTFHub archive layout for the provided uname machine
+func btfHubArch(machine string) string {
+	switch machine {
+	case "aarch64":
+		return "arm64"
+	default:
+		return machine
+	}
+}
+
+// osRelease - Returns the ID and VERSION_ID fields of the provided os-release file
+func osRelease(path string) (string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	var id, versionID string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return id, versionID
+}
+
+// btfCandidates - Returns the paths at which the BTF of the provided kernel release is looked up in the provided
+// search directory: <dir>/<release>.btf, <dir>/vmlinux-<release>, and the BTFHub archive layout
+// <dir>/<id>/<version_id>/<arch>/<release>.btf
+func btfCandidates(dir, release, machine, id, versionID string) []string {
+	candidates := []string{
+		filepath.Join(dir, release+".btf"),
+		filepath.Join(dir, "vmlinux-"+release),
+	}
+	if id != "" && versionID != "" {
+		candidates = append(candidates, filepath.Join(dir, id, versionID, btfHubArch(machine), release+".btf"))
+	}
+	return candidates
+}
+
+// findKernelBTF - Looks up the BTF of the running kernel in the provided search paths. A search path is either a BTF
+// file, or a directory laid out as described in btfCandidates. The BTF files can be gzip compressed, the BTFHub tar
+// archives have to be extracted.
+func findKernelBTF(searchPaths []string) (*btf.Spec, string, error) {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return nil, "", fmt.Errorf("couldn't get the kernel release: %w", err)
+	}
+	release := unix.ByteSliceToString(uname.Release[:])
+	machine := unix.ByteSliceToString(uname.Machine[:])
+	id, versionID := osRelease("/etc/os-release")
+
+	for _, searchPath := range searchPaths {
+		info, err := os.Stat(searchPath)
+		if err != nil {
+			continue
+		}
+		candidates := []string{searchPath}
+		if info.IsDir() {
+			candidates = btfCandidates(searchPath, release, machine, id, versionID)
+		}
+		for _, candidate := range candidates {
+			if _, err = os.Stat(candidate); err != nil {
+				continue
+			}
+			spec, err := loadBTFFile(candidate)
+			if err != nil {
+				return nil, "", fmt.Errorf("error:%w , couldn't parse kernel BTF at %s", err, candidate)
+			}
+			return spec, candidate, nil
+		}
+	}
+	return nil, "", fmt.Errorf("error:%w , release %s, search paths %v", ErrKernelBTFNotFound, release, searchPaths)
+}
+
+// reportCORERelocations - Looks for the CO-RE relocations of the programs of the manager that can't be resolved
+// against the kernel BTF, and reports them on CORERelocationErrChan. Called when the collection couldn't be loaded.
+func (m *Manager) reportCORERelocations() {
+	if m.options.CORERelocationErrChan == nil || m.collectionSpec == nil {
+		return
+	}
+	target := m.kernelTypes()
+	if target == nil {
+		m.sendCORERelocationError(fmt.Errorf("error:%w , the kernel BTF isn't available", ErrCORERelocation))
+		return
+	}
+	for name, spec := range m.collectionSpec.Programs {
+		for _, err := range coreRelocationErrors(spec, target) {
+			m.sendCORERelocationError(fmt.Errorf("error:%w , program %s: %v", ErrCORERelocation, name, err))
+		}
+	}
+}
+
+// sendCORERelocationError - Sends the provided error on CORERelocationErrChan, without blocking
+func (m *Manager) sendCORERelocationError(err error) {
+	select {
+	case m.options.CORERelocationErrChan <- err:
+	default:
+	}
+}
+
+// coreRelocationErrors - Resolves the CO-RE relocations of the provided program one by one against the target BTF, and
+// returns an error for each relocation that fails or is poisoned (the local type or field doesn't exist in the target)
+func coreRelocationErrors(spec *ebpf.ProgramSpec, target *btf.Spec) []error {
+	var errs []error
+	iter := spec.Instructions.Iterate()
+	for iter.Next() {
+		relo := btf.CORERelocationMetadata(iter.Ins)
+		if relo == nil {
+			continue
+		}
+		fixups, err := btf.CORERelocate([]*btf.CORERelocation{relo}, target, spec.ByteOrder)
+		if err == nil && len(fixups) == 1 && !strings.HasSuffix(fixups[0].String(), "=poison") {
+			continue
+		}
+		if err == nil {
+			err = errors.New("no matching type in the kernel BTF")
+		}
+		errs = append(errs, fmt.Errorf("instruction %d%s: %w", iter.Offset, instructionSource(iter.Ins), err))
+	}
+	return errs
+}
+
+// instructionSource - Returns the source line of the instruction, if the program was compiled with line info
+func instructionSource(ins *asm.Instruction) string {
+	source := ins.Source()
+	if source == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", strings.TrimSpace(source.String()))
+}
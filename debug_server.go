@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// DebugHandler - Returns an HTTP handler exposing the state of the manager as JSON, so that it can be mounted on the
+// HTTP server of the application. See Options.DebugListenAddr for the served endpoints.
+func (m *Manager) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/probes", func(w http.ResponseWriter, _ *http.Request) {
+		dump, err := m.GetDump()
+		if err != nil {
+			writeDebugResponse(w, nil, err)
+			return
+		}
+		writeDebugResponse(w, dump.Probes, nil)
+	})
+	mux.HandleFunc("/maps", func(w http.ResponseWriter, _ *http.Request) {
+		dump, err := m.GetDump()
+		if err != nil {
+			writeDebugResponse(w, nil, err)
+			return
+		}
+		writeDebugResponse(w, struct {
+			Maps        []MapDump `json:"maps"`
+			PerfMaps    []MapDump `json:"perf_maps"`
+			RingBuffers []MapDump `json:"ring_buffers"`
+		}{dump.Maps, dump.PerfMaps, dump.RingBuffers}, nil)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, _ *http.Request) {
+		stats, err := m.GetProgramStats()
+		writeDebugResponse(w, stats, err)
+	})
+	mux.HandleFunc("/dump", func(w http.ResponseWriter, _ *http.Request) {
+		dump, err := m.GetDump()
+		writeDebugResponse(w, dump, err)
+	})
+	mux.HandleFunc("/objects", func(w http.ResponseWriter, _ *http.Request) {
+		infos, err := m.GetObjectInfos()
+		writeDebugResponse(w, infos, err)
+	})
+	return mux
+}
+
+// writeDebugResponse - Writes the JSON encoded response of a debug endpoint, or its error
+func writeDebugResponse(w http.ResponseWriter, response interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrManagerNotInitialized) {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		response = struct {
+			Error string `json:"error"`
+		}{err.Error()}
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// startDebugServer - (DebugListenAddr) Starts serving the debug endpoints of the manager
+func (m *Manager) startDebugServer() error {
+	if m.options.DebugListenAddr == "" || m.debugServer != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", m.options.DebugListenAddr)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't listen on %s for the debug server", err, m.options.DebugListenAddr))
+	}
+	m.debugServer = &http.Server{Handler: m.DebugHandler()}
+	m.wg.Add(1)
+	go func(server *http.Server) {
+		defer m.wg.Done()
+		_ = server.Serve(listener)
+	}(m.debugServer)
+	return nil
+}
+
+// stopDebugServer - (DebugListenAddr) Stops the debug server. The pending requests are interrupted: they may be waiting
+// for the state lock of the manager, which is held while the manager stops.
+func (m *Manager) stopDebugServer() error {
+	if m.debugServer == nil {
+		return nil
+	}
+	err := m.debugServer.Close()
+	m.debugServer = nil
+	return err
+}
@@ -0,0 +1,44 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestDebugHandler(t *testing.T) {
+	m := &Manager{}
+	server := httptest.NewServer(m.DebugHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected %d before the manager is initialized, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	m.collection = &ebpf.Collection{}
+	m.state = running
+	m.Probes = []*Probe{{UID: "test", Section: "socket/test", EbpfFuncName: "test"}}
+	resp, err = http.Get(server.URL + "/probes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var probes []ProbeDump
+	if err = json.NewDecoder(resp.Body).Decode(&probes); err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 1 || probes[0].EbpfFuncName != "test" || probes[0].State != "reset" {
+		t.Errorf("unexpected probes %+v", probes)
+	}
+}
@@ -0,0 +1,224 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// Decoder - Decodes the raw samples of a perf map or a ring buffer into structured events, see
+// PerfMapOptions.EventHandler and RingBufferOptions.EventHandler
+type Decoder interface {
+	Decode(data []byte) (interface{}, error)
+}
+
+// DecoderFunc - Adapter to use an ordinary function as a Decoder
+type DecoderFunc func(data []byte) (interface{}, error)
+
+// Decode - Implements Decoder
+func (f DecoderFunc) Decode(data []byte) (interface{}, error) {
+	return f(data)
+}
+
+// structDecoder - Decoder unmarshalling the samples into a Go type with binary.Read
+type structDecoder struct {
+	typ   reflect.Type
+	order binary.ByteOrder
+}
+
+// NewStructDecoder - Returns a Decoder unmarshalling each sample into a new value of the type of sample with
+// binary.Read, in the provided byte order. The events are pointers to the new values: a *T when sample is a T or a *T.
+// The layout of the Go type must match the layout of the eBPF structure, padding included.
+func NewStructDecoder(sample interface{}, order binary.ByteOrder) Decoder {
+	typ := reflect.TypeOf(sample)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return &structDecoder{typ: typ, order: order}
+}
+
+// Decode - Implements Decoder
+func (d *structDecoder) Decode(data []byte) (interface{}, error) {
+	event := reflect.New(d.typ).Interface()
+	if err := binary.Read(bytes.NewReader(data), d.order, event); err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't decode a %s: %v", ErrDecodeFailed, d.typ, err)
+	}
+	return event, nil
+}
+
+// btfDecoder - Decoder walking the BTF layout of the samples
+type btfDecoder struct {
+	typ   btf.Type
+	order binary.ByteOrder
+}
+
+// NewBTFDecoder - Returns a Decoder laying out each sample according to the provided BTF type, in the provided byte
+// order. Structures and unions are decoded into map[string]interface{} keyed by member name, arrays into
+// []interface{} (or into a string for char arrays), integers, enums and pointers into int64 or uint64, and floats into
+// float64. See PerfMapOptions.EventType to decode the samples with a type of the BTF of the manager.
+func NewBTFDecoder(typ btf.Type, order binary.ByteOrder) Decoder {
+	return &btfDecoder{typ: typ, order: order}
+}
+
+// Decode - Implements Decoder
+func (d *btfDecoder) Decode(data []byte) (interface{}, error) {
+	size, err := btf.Sizeof(d.typ)
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , %v", ErrDecodeFailed, err)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("error:%w , sample of %d bytes, expected at least %d", ErrDecodeFailed, len(data), size)
+	}
+	return d.decode(d.typ, data)
+}
+
+// decode - Decodes the provided data according to typ. The data is assumed to be long enough.
+func (d *btfDecoder) decode(typ btf.Type, data []byte) (interface{}, error) {
+	switch t := btf.UnderlyingType(typ).(type) {
+	case *btf.Int:
+		value := d.uint(data, t.Size)
+		if t.Encoding == btf.Bool {
+			return value != 0, nil
+		}
+		if t.Encoding == btf.Signed {
+			return signExtend(value, t.Size*8), nil
+		}
+		return value, nil
+	case *btf.Enum:
+		value := d.uint(data, t.Size)
+		if t.Signed {
+			return signExtend(value, t.Size*8), nil
+		}
+		return value, nil
+	case *btf.Pointer:
+		return d.uint(data, 8), nil
+	case *btf.Float:
+		if t.Size == 4 {
+			return float64(math.Float32frombits(uint32(d.uint(data, 4)))), nil
+		}
+		return math.Float64frombits(d.uint(data, 8)), nil
+	case *btf.Array:
+		elemSize, err := btf.Sizeof(t.Type)
+		if err != nil {
+			return nil, err
+		}
+		if isChar(t.Type) {
+			str := data[:t.Nelems]
+			if end := bytes.IndexByte(str, 0); end >= 0 {
+				str = str[:end]
+			}
+			return string(str), nil
+		}
+		values := make([]interface{}, 0, t.Nelems)
+		for i := 0; i < int(t.Nelems); i++ {
+			value, err := d.decode(t.Type, data[i*elemSize:])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case *btf.Struct:
+		return d.decodeMembers(t.Members, data)
+	case *btf.Union:
+		return d.decodeMembers(t.Members, data)
+	default:
+		return nil, fmt.Errorf("error:%w , unsupported type %v", ErrDecodeFailed, typ)
+	}
+}
+
+// decodeMembers - Decodes the members of a structure or a union
+func (d *btfDecoder) decodeMembers(members []btf.Member, data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(members))
+	for _, member := range members {
+		var value interface{}
+		var err error
+		if member.BitfieldSize > 0 {
+			value, err = d.decodeBitfield(member, data)
+		} else {
+			value, err = d.decode(member.Type, data[member.Offset/8:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", member.Name, err)
+		}
+		if member.Name == "" {
+			// the members of anonymous structures and unions are promoted
+			if nested, ok := value.(map[string]interface{}); ok {
+				for name, nestedValue := range nested {
+					values[name] = nestedValue
+				}
+				continue
+			}
+		}
+		values[member.Name] = value
+	}
+	return values, nil
+}
+
+// decodeBitfield - Decodes a bitfield member
+func (d *btfDecoder) decodeBitfield(member btf.Member, data []byte) (interface{}, error) {
+	size, err := btf.Sizeof(member.Type)
+	if err != nil {
+		return nil, err
+	}
+	// read the storage unit containing the bitfield
+	unitBits := uint32(size * 8)
+	unitOffset := uint32(member.Offset) / unitBits * unitBits
+	unit := d.uint(data[unitOffset/8:], uint32(size))
+	shift := uint32(member.Offset) - unitOffset
+	if d.order == binary.BigEndian {
+		shift = unitBits - shift - uint32(member.BitfieldSize)
+	}
+	value := unit >> shift & (1<<uint32(member.BitfieldSize) - 1)
+	if i, ok := btf.UnderlyingType(member.Type).(*btf.Int); ok && i.Encoding == btf.Signed {
+		return signExtend(value, uint32(member.BitfieldSize)), nil
+	}
+	return value, nil
+}
+
+// isChar - Returns true if the provided type is a character. Compilers usually encode char as a signed integer, the
+// name of the type is therefore checked too.
+func isChar(typ btf.Type) bool {
+	i, ok := btf.UnderlyingType(typ).(*btf.Int)
+	if !ok || i.Size != 1 {
+		return false
+	}
+	return i.Encoding == btf.Char || i.Name == "char" || i.Name == "signed char" || i.Name == "unsigned char"
+}
+
+// uint - Reads an unsigned integer of the provided size
+func (d *btfDecoder) uint(data []byte, size uint32) uint64 {
+	switch size {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(d.order.Uint16(data))
+	case 4:
+		return uint64(d.order.Uint32(data))
+	default:
+		return d.order.Uint64(data)
+	}
+}
+
+// signExtend - Interprets the lower bits of value as a signed integer
+func signExtend(value uint64, bits uint32) int64 {
+	shift := 64 - bits
+	return int64(value<<shift) >> shift
+}
+
+// newEventTypeDecoder - Returns a BTF decoder for the named type of the BTF of the manager
+func (m *Manager) newEventTypeDecoder(name string) (Decoder, error) {
+	if m == nil || m.collectionSpec == nil || m.collectionSpec.Types == nil {
+		return nil, fmt.Errorf("error:%w , no BTF to look up event type %s", ErrDecodeFailed, name)
+	}
+	typ, err := m.collectionSpec.Types.AnyTypeByName(name)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't find event type %s", err, name))
+	}
+	return NewBTFDecoder(typ, m.collectionSpec.ByteOrder), nil
+}
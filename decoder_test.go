@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestStructDecoder(t *testing.T) {
+	type event struct {
+		PID  uint32
+		Comm [4]byte
+	}
+	decoder := NewStructDecoder(event{}, binary.LittleEndian)
+	decoded, err := decoder.Decode([]byte{42, 0, 0, 0, 'b', 'a', 's', 'h'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := decoded.(*event); !ok || e.PID != 42 || string(e.Comm[:]) != "bash" {
+		t.Errorf("unexpected event %#v", decoded)
+	}
+	if _, err = decoder.Decode([]byte{1, 2}); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("expected ErrDecodeFailed on a short sample, got %v", err)
+	}
+}
+
+func TestBTFDecoder(t *testing.T) {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4}
+	s32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Signed}
+	// struct { u32 pid; char comm[4]; int ret; u32 flag:1, level:3; union { u32 uid; int gid; }; }
+	typ := &btf.Struct{Name: "event", Size: 20, Members: []btf.Member{
+		{Name: "pid", Type: u32, Offset: 0},
+		{Name: "comm", Type: &btf.Array{Type: char, Nelems: 4}, Offset: 32},
+		{Name: "ret", Type: s32, Offset: 64},
+		{Name: "flag", Type: u32, Offset: 96, BitfieldSize: 1},
+		{Name: "level", Type: u32, Offset: 97, BitfieldSize: 3},
+		{Name: "", Type: &btf.Union{Size: 4, Members: []btf.Member{
+			{Name: "uid", Type: u32},
+			{Name: "gid", Type: s32},
+		}}, Offset: 128},
+	}}
+	data := []byte{
+		42, 0, 0, 0,
+		's', 'h', 0, 0,
+		0xfe, 0xff, 0xff, 0xff,
+		0x0b, 0, 0, 0,
+		0xff, 0xff, 0xff, 0xff,
+	}
+	decoded, err := NewBTFDecoder(typ, binary.LittleEndian).Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"pid":   uint64(42),
+		"comm":  "sh",
+		"ret":   int64(-2),
+		"flag":  uint64(1),
+		"level": uint64(5),
+		"uid":   uint64(0xffffffff),
+		"gid":   int64(-1),
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded)
+	}
+	if _, err = NewBTFDecoder(typ, binary.LittleEndian).Decode(data[:8]); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("expected ErrDecodeFailed on a short sample, got %v", err)
+	}
+}
+
+func TestPerfMapEventHandler(t *testing.T) {
+	var events []interface{}
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			EventHandler: func(CPU int, event interface{}, perfMap *PerfMap, manager *Manager) {
+				events = append(events, event)
+			},
+			Decoder: DecoderFunc(func(data []byte) (interface{}, error) {
+				if len(data) == 0 {
+					return nil, ErrDecodeFailed
+				}
+				return int(data[0]), nil
+			}),
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range [][]byte{{7}, {}, {8}} {
+		if err := perfMap.InjectSample(0, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(events, []interface{}{7, 8}) || perfMap.PerfMapStats.DecodeErrors != 1 {
+		t.Errorf("expected events [7 8] and 1 decode error, got %v and %d", events, perfMap.PerfMapStats.DecodeErrors)
+	}
+
+	missing := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:     true,
+			EventHandler: func(CPU int, event interface{}, perfMap *PerfMap, manager *Manager) {},
+		},
+	}
+	if err := missing.Init(m); !errors.Is(err, ErrMissingDecoder) {
+		t.Errorf("expected ErrMissingDecoder, got %v", err)
+	}
+}
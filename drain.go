@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf/perf"
+	"github.com/hashicorp/go-multierror"
+)
+
+// drainPollInterval - Interval at which the activity of the readers is checked while they drain their buffers
+const drainPollInterval = 10 * time.Millisecond
+
+// readerActivity - Tracks the activity of the goroutine reading a perf map or a ring buffer, so that the manager can
+// tell when the records left in the kernel buffer were all read
+type readerActivity struct {
+	records uint64
+	waiting int32
+}
+
+// beginRead - Called by the reader before it waits for the next record
+func (a *readerActivity) beginRead() {
+	atomic.StoreInt32(&a.waiting, 1)
+}
+
+// endRead - Called by the reader once it got a record
+func (a *readerActivity) endRead() {
+	atomic.StoreInt32(&a.waiting, 0)
+	atomic.AddUint64(&a.records, 1)
+}
+
+// addRecords - Called by the reader pool once it read n records, the pool then waits for new records
+func (a *readerActivity) addRecords(n int) {
+	atomic.AddUint64(&a.records, uint64(n))
+}
+
+// waitIdle - Returns once the reader waits for new records and didn't get any for a poll interval, that is to say once
+// its buffer is empty, or once ctx is done
+func (a *readerActivity) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	last := atomic.LoadUint64(&a.records)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		records := atomic.LoadUint64(&a.records)
+		if records == last && atomic.LoadInt32(&a.waiting) == 1 {
+			return nil
+		}
+		last = records
+	}
+}
+
+// drain - Waits until the records left in the perf ring buffer are read, or until ctx is done. The records below the
+// Watermark or WakeupEvents threshold of the rings, which don't wake up the reader, are flushed once the reader is idle.
+func (m *PerfMap) drain(ctx context.Context) error {
+	m.stateLock.RLock()
+	isReading := m.state == running && m.perfReader != nil
+	reader, isPerCPU := m.perfReader.(*perCPURecordReader)
+	pooled := m.pooled
+	m.stateLock.RUnlock()
+	if !isReading {
+		return nil
+	}
+	if m.ExternalPolling {
+		_, err := m.ReadAvailable()
+		return err
+	}
+	if err := m.activity.waitIdle(ctx); err != nil || !isPerCPU {
+		return err
+	}
+	if pooled {
+		n, err := m.readAvailable(reader)
+		m.activity.addRecords(n)
+		if errors.Is(err, perf.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+	reader.flush()
+	return m.activity.waitIdle(ctx)
+}
+
+// drain - Waits until the records left in the ring buffer are read, or until ctx is done
+func (rb *RingBuffer) drain(ctx context.Context) error {
+	rb.stateLock.RLock()
+	isReading := rb.state == running && (rb.reader != nil || rb.perfReader != nil)
+	rb.stateLock.RUnlock()
+	if !isReading {
+		return nil
+	}
+	return rb.activity.waitIdle(ctx)
+}
+
+// StopWithContext - Detaches all eBPF programs first, so that no new sample is written, then keeps reading the perf
+// ring buffers and the ring buffers until the samples left in the kernel buffers are all handled, or until ctx is
+// done, and finally stops the manager like Stop. Use it so that a short-lived tool doesn't lose the last samples
+// written before it stopped. The samples below the Watermark or WakeupEvents threshold of the perf ring buffers are
+// read as well for the perf maps that set FlushOnDrain. The samples still in the kernel buffers once ctx is done are lost,
+// and the context error is returned. The cleanup parameter defines which maps should be closed, see MapCleanupType.
+func (m *Manager) StopWithContext(ctx context.Context, cleanup MapCleanupType) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+
+	var err error
+	for _, probe := range m.Probes {
+		if e := probe.Stop(); e != nil {
+			err = multierror.Append(err, fmt.Errorf("error:%w , program %s couldn't gracefully shut down", e, probe.EbpfFuncName))
+		}
+	}
+
+	var drainErr error
+	var drainOnce sync.Once
+	var drainGroup sync.WaitGroup
+	drain := func(drainFunc func(ctx context.Context) error) {
+		drainGroup.Add(1)
+		go func() {
+			defer drainGroup.Done()
+			if e := drainFunc(ctx); e != nil {
+				drainOnce.Do(func() {
+					drainErr = e
+				})
+			}
+		}()
+	}
+	for _, perfMap := range m.PerfMaps {
+		drain(perfMap.drain)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		drain(ringBuffer.drain)
+	}
+	drainGroup.Wait()
+	if drainErr != nil {
+		err = multierror.Append(err, fmt.Errorf("error:%w , the perf and ring buffers couldn't be drained", drainErr))
+	}
+
+	if e := m.stop(0, cleanup); e != nil {
+		err = multierror.Append(err, e)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestStopWithContext(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		timeout time.Duration
+		drained bool
+	}{
+		{name: "drained", timeout: 5 * time.Second, drained: true},
+		{name: "deadline", timeout: 20 * time.Millisecond},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var handled uint64
+			m := &Manager{wg: &sync.WaitGroup{}, state: initialized}
+			array, err := m.NewRingBuffer(ebpf.MapSpec{Name: "events", Type: ebpf.RingBuf}, MapOptions{}, RingBufferOptions{
+				RingBufferSize: 4096,
+				DataHandler: func(data []byte, ringBuffer *RingBuffer, manager *Manager) {
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddUint64(&handled, 1)
+				},
+			})
+			if err != nil {
+				t.Skipf("ring buffers aren't supported: %v", err)
+			}
+			prog := newRingbufOutputProgram(t, array)
+			defer prog.Close()
+			// 50 samples of 16 bytes with their headers fit in the ring buffer
+			if _, _, err = prog.Benchmark(make([]byte, 14), 50, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+			err = m.StopWithContext(ctx, CleanAll)
+			if test.drained {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if count := atomic.LoadUint64(&handled); count != 50 {
+					t.Errorf("expected the 50 samples to be handled, got %d", count)
+				}
+				return
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected the deadline to be exceeded, got %v", err)
+			}
+			if count := atomic.LoadUint64(&handled); count == 50 {
+				t.Error("expected some samples to be lost")
+			}
+		})
+	}
+}
+
+func TestStopWithContextFlushesPerfMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// each sample of newPerfOutputProgram takes 16 bytes in the perf ring buffer: 3 samples don't wake up the reader
+	for name, options := range map[string]PerfMapOptions{
+		"wakeup_events": {WakeupEvents: 4, FlushOnDrain: true},
+		"watermark":     {Watermark: 56, FlushOnDrain: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var handled uint64
+			m := &Manager{wg: &sync.WaitGroup{}, state: initialized, collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}}}
+			options.PerfRingBufferSize = os.Getpagesize()
+			options.DataHandler = func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				atomic.AddUint64(&handled, 1)
+			}
+			perfMap := &PerfMap{Map: Map{Name: "events"}, PerfMapOptions: options}
+			if err = perfMap.Init(m); err != nil {
+				t.Fatal(err)
+			}
+			if err = perfMap.Start(); err != nil {
+				t.Fatal(err)
+			}
+			m.PerfMaps = append(m.PerfMaps, perfMap)
+			prog := newPerfOutputProgram(t, array)
+			defer prog.Close()
+			if _, _, err = prog.Benchmark(make([]byte, 14), 3, nil); err != nil {
+				t.Skipf("couldn't run the program: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err = m.StopWithContext(ctx, CleanAll); err != nil {
+				t.Fatal(err)
+			}
+			if count := atomic.LoadUint64(&handled); count != 3 {
+				t.Errorf("expected the 3 samples below the threshold to be handled, got %d", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// DumpFormat - Output format of Manager.DumpTo
+type DumpFormat int
+
+const (
+	// DumpText - Human readable dump, see Manager.Dump
+	DumpText DumpFormat = iota
+	// DumpJSON - JSON encoded ManagerDump
+	DumpJSON
+)
+
+// DumpSink - Callback function that receives the structured dump of a manager, see Manager.DumpToSinks
+type DumpSink func(dump *ManagerDump) error
+
+// ManagerDump - Machine readable dump of a manager, see Manager.GetDump
+type ManagerDump struct {
+	State       string      `json:"state"`
+	Probes      []ProbeDump `json:"probes"`
+	Maps        []MapDump   `json:"maps"`
+	PerfMaps    []MapDump   `json:"perf_maps"`
+	RingBuffers []MapDump   `json:"ring_buffers"`
+	// Kernel - Result of the last call to Manager.ScanKernel, if any
+	Kernel *KernelScan `json:"kernel,omitempty"`
+	// MapSubstitutions - Map types substituted at Init, see Options.MapTypeFallbacks
+	MapSubstitutions []MapTypeSubstitution `json:"map_substitutions,omitempty"`
+}
+
+// ProbeDump - Machine readable dump of a probe of the manager
+type ProbeDump struct {
+	UID          string `json:"uid,omitempty"`
+	EbpfFuncName string `json:"ebpf_func_name"`
+	Section      string `json:"section"`
+	Enabled      bool   `json:"enabled"`
+	State        string `json:"state"`
+	ProgramID    uint32 `json:"program_id,omitempty"`
+	ProgramFD    int    `json:"program_fd,omitempty"`
+	PinPath      string `json:"pin_path,omitempty"`
+	RunCount     uint64 `json:"run_count,omitempty"`
+	RunTimeNs    uint64 `json:"run_time_ns,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	// AttachMethod - (kprobes & TC classifiers) Method used to attach the probe, see KprobeAttachMethod and
+	// TCAttachMode
+	AttachMethod string `json:"attach_method,omitempty"`
+	// LinkID, LinkType, LinkFD - bpf_link of the probe, if it is attached with one
+	LinkID   uint32 `json:"link_id,omitempty"`
+	LinkType string `json:"link_type,omitempty"`
+	LinkFD   int    `json:"link_fd,omitempty"`
+	// Dump - Output of the DumpHandler of the probe
+	Dump string `json:"dump,omitempty"`
+}
+
+// MapDump - Machine readable dump of a map, a perf map or a ring buffer of the manager
+type MapDump struct {
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	Type       string `json:"type,omitempty"`
+	KeySize    uint32 `json:"key_size,omitempty"`
+	ValueSize  uint32 `json:"value_size,omitempty"`
+	MaxEntries uint32 `json:"max_entries,omitempty"`
+	Flags      uint32 `json:"flags,omitempty"`
+	MapID      uint32 `json:"map_id,omitempty"`
+	PinPath    string `json:"pin_path,omitempty"`
+	// UserspaceDrops, DecodeErrors - (perf maps & ring buffers) Samples dropped in user space, and samples that
+	// couldn't be decoded
+	UserspaceDrops uint64 `json:"userspace_drops,omitempty"`
+	DecodeErrors   uint64 `json:"decode_errors,omitempty"`
+	// Events - (perf maps & ring buffers) End-to-end accounting of the samples, see Manager.GetEventStats
+	Events *EventStats `json:"events,omitempty"`
+	// Dump - Output of the DumpHandler of the map
+	Dump string `json:"dump,omitempty"`
+}
+
+// String - Returns the name of the state
+func (s state) String() string {
+	switch s {
+	case reset:
+		return "reset"
+	case initialized:
+		return "initialized"
+	case paused:
+		return "paused"
+	case running:
+		return "running"
+	default:
+		return fmt.Sprintf("state(%d)", uint(s))
+	}
+}
+
+// dumpMap - Returns the machine readable dump of the provided map, the dump field is filled by the caller
+func dumpMap(m *Map) MapDump {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	dump := MapDump{Name: m.Name, State: m.state.String(), PinPath: m.PinPath}
+	if m.array == nil {
+		return dump
+	}
+	dump.Type = m.array.Type().String()
+	dump.KeySize, dump.ValueSize = m.array.KeySize(), m.array.ValueSize()
+	dump.MaxEntries, dump.Flags = m.array.MaxEntries(), m.array.Flags()
+	if info, err := m.array.Info(); err == nil {
+		id, _ := info.ID()
+		dump.MapID = uint32(id)
+	}
+	return dump
+}
+
+// dumpProbe - Returns the machine readable dump of the provided probe
+func dumpProbe(p *Probe) ProbeDump {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	dump := ProbeDump{
+		UID:          p.UID,
+		EbpfFuncName: p.EbpfFuncName,
+		Section:      p.Section,
+		Enabled:      p.Enabled,
+		State:        p.state.String(),
+		PinPath:      p.PinPath,
+	}
+	if p.lastError != nil {
+		dump.LastError = p.lastError.Error()
+	}
+	if p.kprobeAttachMethod != AttachKprobeMethodNotSet {
+		dump.AttachMethod = p.kprobeAttachMethod.String()
+	} else if p.programSpec != nil && p.programSpec.Type == ebpf.SchedCLS && p.state >= running {
+		dump.AttachMethod = p.tcAttachMode.String()
+	}
+	if p.link != nil {
+		if info, err := p.link.Info(); err == nil {
+			dump.LinkID, dump.LinkType = uint32(info.ID), linkTypeName(info.Type)
+		}
+		if fdLink, ok := p.link.(interface{ FD() int }); ok {
+			dump.LinkFD = fdLink.FD()
+		}
+	}
+	if p.program == nil {
+		return dump
+	}
+	dump.ProgramFD = p.program.FD()
+	if info, err := p.program.Info(); err == nil {
+		id, _ := info.ID()
+		dump.ProgramID = uint32(id)
+		dump.RunCount, _ = info.RunCount()
+		runTime, _ := info.Runtime()
+		dump.RunTimeNs = uint64(runTime)
+	}
+	return dump
+}
+
+// GetDump - Returns the machine readable dump of the manager: the states of its probes and maps, their kernel IDs,
+// specs, pin paths and statistics, and the output of the DumpHandlers of the maps. The runtime statistics of the
+// programs are only collected when EnableProgramStats was called.
+func (m *Manager) GetDump() (*ManagerDump, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	dump := &ManagerDump{State: m.state.String()}
+	for _, probe := range m.Probes {
+		probeDump := dumpProbe(probe)
+		if probe.DumpHandler != nil {
+			probeDump.Dump = probe.DumpHandler(probe, m)
+		}
+		dump.Probes = append(dump.Probes, probeDump)
+	}
+	for _, managerMap := range m.Maps {
+		mapDump := dumpMap(managerMap)
+		if managerMap.DumpHandler != nil {
+			mapDump.Dump = managerMap.DumpHandler(managerMap, m)
+		}
+		dump.Maps = append(dump.Maps, mapDump)
+	}
+	for _, perfMap := range m.PerfMaps {
+		mapDump := dumpMap(&perfMap.Map)
+		events := perfMap.events.stats(perfMap.Name, "perf_map")
+		mapDump.Events = &events
+		if perfMap.PerfMapStats != nil {
+			mapDump.UserspaceDrops = atomic.LoadUint64(&perfMap.PerfMapStats.UserspaceDrops)
+			mapDump.DecodeErrors = atomic.LoadUint64(&perfMap.PerfMapStats.DecodeErrors)
+		}
+		if perfMap.DumpHandler != nil {
+			mapDump.Dump = perfMap.DumpHandler(perfMap, m)
+		} else if perfMap.KeepRecentSamples > 0 {
+			mapDump.Dump = dumpRecentSamples(perfMap, m)
+		}
+		dump.PerfMaps = append(dump.PerfMaps, mapDump)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		mapDump := dumpMap(&ringBuffer.Map)
+		events := ringBuffer.events.stats(ringBuffer.Name, "ring_buffer")
+		mapDump.Events = &events
+		mapDump.UserspaceDrops = ringBuffer.UserspaceDrops()
+		if ringBuffer.DumpHandler != nil {
+			mapDump.Dump = ringBuffer.DumpHandler(ringBuffer, m)
+		}
+		dump.RingBuffers = append(dump.RingBuffers, mapDump)
+	}
+	dump.Kernel = m.lastKernelScan()
+	dump.MapSubstitutions = append(dump.MapSubstitutions, m.mapSubstitutions...)
+	return dump, nil
+}
+
+// DumpAll - Returns a human readable report of the manager: the probes (state, attach method, file descriptors, link
+// and last error, followed by the output of their DumpHandler), then the maps, perf maps and ring buffers with a
+// DumpHandler, see DumpMaps
+func (m *Manager) DumpAll() (string, error) {
+	dump, err := m.GetDump()
+	if err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	w := tabwriter.NewWriter(&output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PROBE\tSECTION\tSTATE\tATTACH\tPROG_ID\tPROG_FD\tLINK\tLAST_ERROR")
+	for _, probe := range dump.Probes {
+		attachMethod := probe.AttachMethod
+		if attachMethod == "" {
+			attachMethod = "-"
+		}
+		linkInfo := "-"
+		if probe.LinkID != 0 {
+			linkInfo = fmt.Sprintf("%s#%d(fd %d)", probe.LinkType, probe.LinkID, probe.LinkFD)
+		}
+		lastError := probe.LastError
+		if lastError == "" {
+			lastError = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", ProbeIdentificationPair{probe.UID, probe.EbpfFuncName}, probe.Section, probe.State, attachMethod, probe.ProgramID, probe.ProgramFD, linkInfo, lastError)
+	}
+	_ = w.Flush()
+	for _, probe := range dump.Probes {
+		output.WriteString(probe.Dump)
+	}
+
+	maps, err := m.DumpMaps()
+	if err != nil {
+		return "", err
+	}
+	output.WriteString(maps)
+
+	for _, substitution := range dump.MapSubstitutions {
+		_, _ = fmt.Fprintf(&output, "Map substitution: %s: %s -> %s\n", substitution.Map, substitution.Requested, substitution.Selected)
+	}
+	if dump.Kernel != nil {
+		_, _ = fmt.Fprintf(&output, "Kernel: %d programs, %d maps, %d links\n", len(dump.Kernel.Programs), len(dump.Kernel.Maps), len(dump.Kernel.Links))
+		for _, conflict := range dump.Kernel.Conflicts {
+			_, _ = fmt.Fprintf(&output, "Conflict: %s, program %d: %s\n", conflict.Probe, conflict.ProgramID, conflict.Reason)
+		}
+	}
+	return output.String(), nil
+}
+
+// linkTypeName - Returns the name of the provided link type
+func linkTypeName(linkType link.Type) string {
+	switch linkType {
+	case link.RawTracepointType:
+		return "raw_tracepoint"
+	case link.TracingType:
+		return "tracing"
+	case link.CgroupType:
+		return "cgroup"
+	case link.IterType:
+		return "iter"
+	case link.NetNsType:
+		return "netns"
+	case link.XDPType:
+		return "xdp"
+	case link.PerfEventType:
+		return "perf_event"
+	case link.KprobeMultiType:
+		return "kprobe_multi"
+	default:
+		return fmt.Sprintf("link_type(%d)", int(linkType))
+	}
+}
+
+// DumpTo - Writes the dump of the manager to the provided writer, in the provided format: the human readable output of
+// Dump, or the JSON encoded output of GetDump
+func (m *Manager) DumpTo(w io.Writer, format DumpFormat) error {
+	switch format {
+	case DumpText:
+		output, err := m.Dump()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, output)
+		return err
+	case DumpJSON:
+		dump, err := m.GetDump()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(dump)
+	default:
+		return fmt.Errorf("error:%w , %d", ErrInvalidDumpFormat, format)
+	}
+}
+
+// DumpToSinks - Hands the dump of the manager over to the provided sinks, for example to publish it on a debug
+// endpoint or in a log pipeline. All the sinks are called, the first error is returned.
+func (m *Manager) DumpToSinks(sinks ...DumpSink) error {
+	dump, err := m.GetDump()
+	if err != nil {
+		return err
+	}
+	var sinkErr error
+	for _, sink := range sinks {
+		if err = sink(dump); err != nil && sinkErr == nil {
+			sinkErr = err
+		}
+	}
+	return sinkErr
+}
@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestDumpTo(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.SocketFilter,
+		License:      "GPL",
+		Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 0), asm.Return()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	m := &Manager{
+		collection: &ebpf.Collection{},
+		state:      running,
+		Probes: []*Probe{{UID: "test", Section: "socket/test", EbpfFuncName: "test", Enabled: true, program: prog, state: running, DumpHandler: func(*Probe, *Manager) string {
+			return "probe dumped\n"
+		}}},
+		Maps: []*Map{{Name: "values", array: array, state: initialized, MapOptions: MapOptions{DumpHandler: func(*Map, *Manager) string {
+			return "dumped"
+		}}}},
+	}
+
+	var output bytes.Buffer
+	if err = m.DumpTo(&output, DumpJSON); err != nil {
+		t.Fatal(err)
+	}
+	var dump ManagerDump
+	if err = json.Unmarshal(output.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.State != "running" || len(dump.Probes) != 1 || len(dump.Maps) != 1 {
+		t.Fatalf("unexpected dump %+v", dump)
+	}
+	if probe := dump.Probes[0]; probe.UID != "test" || probe.State != "running" || probe.ProgramID == 0 || probe.ProgramFD != prog.FD() || probe.Dump != "probe dumped\n" {
+		t.Errorf("unexpected probe dump %+v", probe)
+	}
+	if values := dump.Maps[0]; values.Type != ebpf.Array.String() || values.ValueSize != 8 || values.MaxEntries != 2 || values.MapID == 0 || values.Dump != "dumped" {
+		t.Errorf("unexpected map dump %+v", values)
+	}
+
+	var sunk *ManagerDump
+	if err = m.DumpToSinks(func(dump *ManagerDump) error {
+		sunk = dump
+		return nil
+	}); err != nil || sunk == nil || sunk.Maps[0].Name != "values" {
+		t.Errorf("expected the sink to receive the dump, got %+v (%v)", sunk, err)
+	}
+	report, err := m.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report, "socket/test") || !strings.Contains(report, "probe dumped") || strings.Count(report, "dumped") != 2 {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	if err = m.DumpTo(&output, DumpFormat(42)); !errors.Is(err, ErrInvalidDumpFormat) {
+		t.Errorf("expected ErrInvalidDumpFormat, got %v", err)
+	}
+}
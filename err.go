@@ -1,6 +1,10 @@
 package manager
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/cilium/ebpf"
+)
 
 var (
 	ErrManagerNotInitialized = errors.New("the manager must be initialized first")
@@ -28,4 +32,96 @@ var (
 	ErrMapNotRunning           = errors.New("the map is not running")
 	ErrLoopbackDisabled        = errors.New("loopback is disabled")
 	ErrMissingEditorFlags      = errors.New("missing editor flags in map editor")
+	ErrTimeout                 = errors.New("timed out")
+	ErrNotPerCPUMap            = errors.New("not a per-CPU map")
+	ErrWatermarkConflict       = errors.New("Watermark and WakeupEvents are mutually exclusive")
+	ErrSharedPerfMapOptions    = errors.New("the perf maps of a shared perf event array must have the same reader options")
+	ErrNotTestMode             = errors.New("the perf map or the ring buffer isn't in test mode")
+	ErrNotRingBuffer           = errors.New("the map isn't a ring buffer")
+	ErrNoTrampolineSupport     = errors.New("BPF trampolines (fentry / fexit) aren't supported by the kernel")
+	ErrProgramTypeMismatch     = errors.New("the program type doesn't match the probe")
+	ErrUnknownUSDT             = errors.New("unknown USDT marker")
+	ErrNoBPFLSMSupport         = errors.New("the BPF LSM isn't enabled, make sure CONFIG_BPF_LSM is set and bpf is in the lsm= kernel parameter")
+	ErrUnknownPinning          = errors.New("unknown pinning strategy")
+	ErrMissingPinPrefix        = errors.New("a PinPrefix is required to identify the pins of the manager")
+	ErrInvalidPinTemplate      = errors.New("invalid pin path template")
+	ErrIncompatiblePinnedMap   = errors.New("the pinned map doesn't match its spec")
+	ErrProbeUnsupported        = errors.New("the probe isn't supported by the running kernel")
+	ErrNotProgArray            = errors.New("the map isn't a program array")
+	ErrNoAttachCookies         = errors.New("attach cookies (bpf_cookie) aren't supported by the kernel, they require kernel 5.15+")
+	ErrNoTCXSupport            = errors.New("tcx links aren't supported by the kernel, they require kernel 6.6+")
+	ErrTCXAttachFailed         = errors.New("couldn't attach the tcx link")
+	ErrMissingTCXRelative      = errors.New("the TCXOrderBefore and TCXOrderAfter orders require a relative probe or program")
+	ErrXDPPriority             = errors.New("the XDP priority must be between 0 and XDPDispatcherSlots - 1")
+	ErrXDPSlotInUse            = errors.New("the slot of the XDP dispatcher is already in use")
+	ErrMissingDecoder          = errors.New("an EventHandler requires a Decoder or an EventType")
+	ErrDecodeFailed            = errors.New("couldn't decode the sample")
+	ErrTestRunFailed           = errors.New("the test run of the program failed")
+	ErrProbeUnhealthy          = errors.New("the probe is no longer attached to its hook point")
+	ErrNoBuildID               = errors.New("the binary doesn't have a GNU build-id")
+	ErrUprobeSymbolNotFound    = errors.New("couldn't locate the symbol of the uprobe")
+	ErrNoMatchingProcess       = errors.New("no running process maps a binary matching the pattern")
+	ErrUprobeNotPIDScoped      = errors.New("the uprobe is attached system wide, it isn't scoped to processes")
+	ErrNoGoReturnSite          = errors.New("no return instruction of the function could be attached")
+	ErrInvalidProbeVariant     = errors.New("invalid probe variant")
+	ErrInvalidCleanupType      = errors.New("invalid cleanup type")
+	ErrMissingPrivileges       = errors.New("the process lacks the privileges required by the probe")
+	ErrUnprivilegedBPFDisabled = errors.New("bpf() is disabled for the unprivileged users")
+	ErrMissCountUnavailable    = errors.New("the miss counter of the kprobe isn't available")
+	ErrUnknownProbeGroup       = errors.New("no probe carries the group tag")
+	ErrNoTPBTFSupport          = errors.New("BTF raw tracepoints (tp_btf) aren't supported by the kernel")
+	ErrNotCGroupV2             = errors.New("eBPF cgroup programs require a cgroup v2 hierarchy")
+	ErrInvalidCGroupFlags      = errors.New("invalid cgroup attach flags")
+	ErrNotSockMap              = errors.New("the map isn't a SOCKMAP or a SOCKHASH")
+	ErrInvalidPerfCPU          = errors.New("invalid CPU for the perf ring buffers")
+	ErrOverwritablePerCPU      = errors.New("overwritable perf ring buffers can't be sized or opened per CPU")
+	ErrKernelBTFNotFound       = errors.New("couldn't find the BTF of the running kernel")
+	ErrCORERelocation          = errors.New("the CO-RE relocation can't be resolved against the kernel BTF")
+	ErrInvalidAsset            = errors.New("invalid eBPF object asset")
+	ErrIncompatibleMapEditor   = errors.New("the external map doesn't match the definition of the map it replaces")
+	ErrNotIterator             = errors.New("the probe isn't a running bpf_iter program")
+	ErrInvalidPerfEvent        = errors.New("invalid perf event configuration")
+	ErrNoOrderedDataHandler    = errors.New("OrderedStream requires Options.OrderedDataHandler")
+	ErrInvalidDumpFormat       = errors.New("unknown dump format")
+	ErrInvalidMapBatch         = errors.New("keys and values must be slices of the same length")
+	ErrMapBatchFailed          = errors.New("some entries of the batch operation failed")
+	ErrNoKprobeCandidate       = errors.New("none of the candidate functions of the kprobe exists")
+	ErrKprobeAddress           = errors.New("couldn't resolve the kernel address of the kprobe")
+	ErrInvalidKprobeOffset     = errors.New("invalid kprobe offset")
+	ErrIncompatibleLink        = errors.New("the pinned link doesn't match the probe")
+	ErrNotStructOpsMap         = errors.New("the map isn't a BPF_MAP_TYPE_STRUCT_OPS map")
+	ErrUnknownStructOps        = errors.New("unknown struct_ops map")
+	ErrStructOpsAttachFailed   = errors.New("couldn't register the struct_ops map")
+	ErrNoMatchingFunction      = errors.New("no kernel function matching the pattern can be probed")
+	ErrTooManyMatches          = errors.New("too many kernel functions match the pattern")
+	ErrNoKprobeMulti           = errors.New("kprobe_multi links aren't supported by the kernel, they require kernel 5.18+")
+	ErrNoUprobeMulti           = errors.New("uprobe_multi links aren't supported by the kernel, they require kernel 6.6+")
+	ErrNotXSKMap               = errors.New("the map isn't a BPF_MAP_TYPE_XSKMAP map")
+	ErrInvalidXSKOptions       = errors.New("invalid AF_XDP socket options")
+	ErrXSKRingFull             = errors.New("no frame or TX descriptor is available")
+	ErrFreplaceTarget          = errors.New("couldn't resolve the target program of the extension")
+	ErrTracingTarget           = errors.New("couldn't resolve the program traced by the probe")
+	ErrStartRolledBack         = errors.New("a mandatory probe failed to attach, the manager was rolled back")
+	ErrInvalidExternalObject   = errors.New("invalid external program or map")
+	ErrFDTransfer              = errors.New("couldn't transfer the file descriptor over the Unix socket")
+	ErrRecordSink              = errors.New("couldn't write the sample to the record sink")
+	ErrResyncFailed            = errors.New("couldn't resync the state of the perf map")
+	ErrInvalidPeriodicTask     = errors.New("invalid periodic task")
+	ErrInvalidFlag             = errors.New("invalid flag")
+	ErrInvalidMapPoller        = errors.New("invalid map poller")
+	ErrInvalidRecord           = errors.New("invalid recorded sample")
+	ErrInvalidFilter           = errors.New("invalid filter")
+	ErrUnknownContainer        = errors.New("couldn't find the cgroup of the container")
+	ErrIncompatibleMapSpec     = errors.New("the edited map spec isn't valid")
+	ErrInterfaceNotFound       = errors.New("couldn't find the interface of the probe")
+	ErrAttachRetryExhausted    = errors.New("the attach retries of the probe were exhausted")
+	ErrHandleInvalidated       = errors.New("the handle was invalidated when the manager stopped")
+	ErrInvalidState            = errors.New("invalid manager state")
+	ErrMountFailed             = errors.New("couldn't mount the filesystem")
+	ErrKernelModuleNotLoaded   = errors.New("the kernel module of the hook point isn't loaded")
+	ErrNotAggregatable         = errors.New("the per-CPU values can't be aggregated")
+	ErrFDUnavailable           = errors.New("the file descriptor isn't available")
+
+	// ErrKeyNotExist - Returned by the Map helpers when the requested key doesn't exist
+	ErrKeyNotExist = ebpf.ErrKeyNotExist
 )
@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// latencyBuckets - Upper bounds of the buckets of the handler latency histograms, see EventStats
+var latencyBuckets = [...]time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// EventStats - End-to-end accounting of the samples of a perf map or a ring buffer: the samples read from the kernel,
+// the ones lost by the kernel, the ones dropped or rejected in user space and the latency of the handlers. See
+// Manager.GetEventStats.
+type EventStats struct {
+	// Name - Name of the perf map or of the ring buffer
+	Name string `json:"name"`
+
+	// Kind - "perf_map" or "ring_buffer"
+	Kind string `json:"kind"`
+
+	// Received, ReceivedBytes - Number of samples, and of bytes, read from the kernel
+	Received      uint64 `json:"received"`
+	ReceivedBytes uint64 `json:"received_bytes"`
+
+	// KernelDrops - (perf maps) Number of samples lost by the kernel because the perf ring buffer was full. The BPF
+	// ring buffers report this error to the eBPF program instead.
+	KernelDrops uint64 `json:"kernel_drops"`
+
+	// UserspaceDrops - Number of samples dropped by the event worker pool of the manager because its queue was full,
+	// see Options.EventDropPolicy
+	UserspaceDrops uint64 `json:"userspace_drops"`
+
+	// DecodeErrors - (EventHandler) Number of samples that couldn't be decoded
+	DecodeErrors uint64 `json:"decode_errors"`
+
+	// Handled - Number of samples handed over to the handlers of the perf map or of the ring buffer
+	Handled uint64 `json:"handled"`
+
+	// BytesPerSecond - Average number of bytes read per second since the reader started
+	BytesPerSecond float64 `json:"bytes_per_second"`
+
+	// HandlerLatency - Distribution of the run times of the handlers
+	HandlerLatency LatencyHistogram `json:"handler_latency"`
+
+	// SlowHandlers - Number of handler runs longer than Options.SlowHandlerThreshold
+	SlowHandlers uint64 `json:"slow_handlers"`
+}
+
+// Lost - Returns the number of samples that were lost end-to-end: dropped by the kernel, dropped in user space or that
+// couldn't be decoded
+func (s EventStats) Lost() uint64 {
+	return s.KernelDrops + s.UserspaceDrops + s.DecodeErrors
+}
+
+// Diff - Returns the accounting of the samples since the provided older snapshot of the same perf map or ring buffer,
+// for example in a PeriodicTask that publishes the statistics of the event streams. BytesPerSecond is the rate over
+// the interval between the two snapshots when it is provided, the rate of s otherwise.
+func (s EventStats) Diff(old EventStats, interval time.Duration) EventStats {
+	diff := s
+	diff.Received -= old.Received
+	diff.ReceivedBytes -= old.ReceivedBytes
+	diff.KernelDrops -= old.KernelDrops
+	diff.UserspaceDrops -= old.UserspaceDrops
+	diff.DecodeErrors -= old.DecodeErrors
+	diff.Handled -= old.Handled
+	diff.SlowHandlers -= old.SlowHandlers
+	if interval > 0 {
+		diff.BytesPerSecond = float64(diff.ReceivedBytes) / interval.Seconds()
+	}
+	diff.HandlerLatency.Counts = append([]uint64(nil), s.HandlerLatency.Counts...)
+	for i := range diff.HandlerLatency.Counts {
+		if i < len(old.HandlerLatency.Counts) {
+			diff.HandlerLatency.Counts[i] -= old.HandlerLatency.Counts[i]
+		}
+	}
+	diff.HandlerLatency.Count -= old.HandlerLatency.Count
+	diff.HandlerLatency.Sum -= old.HandlerLatency.Sum
+	return diff
+}
+
+// LatencyHistogram - Histogram of durations
+type LatencyHistogram struct {
+	// Buckets - Upper bounds of the buckets, from 1µs to 1s
+	Buckets []time.Duration `json:"buckets"`
+
+	// Counts - Number of durations in each bucket, the last count is the number of durations above the last bound
+	Counts []uint64 `json:"counts"`
+
+	// Count, Sum - Number of durations, and their sum
+	Count uint64        `json:"count"`
+	Sum   time.Duration `json:"sum"`
+}
+
+// Average - Returns the average duration of the histogram
+func (h LatencyHistogram) Average() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// eventCounters - Counters of the samples of a perf map or of a ring buffer, updated atomically
+type eventCounters struct {
+	received       uint64
+	receivedBytes  uint64
+	kernelDrops    uint64
+	userspaceDrops uint64
+	decodeErrors   uint64
+	handled        uint64
+	latencySum     uint64
+	latencyCounts  [len(latencyBuckets) + 1]uint64
+	slowHandlers   uint64
+	// started - Unix time in nanoseconds when the reader started
+	started int64
+}
+
+// start - Resets the start time of the rate of the counters
+func (c *eventCounters) start() {
+	atomic.StoreInt64(&c.started, time.Now().UnixNano())
+}
+
+// receive - Counts a sample read from the kernel
+func (c *eventCounters) receive(size int) {
+	atomic.AddUint64(&c.received, 1)
+	atomic.AddUint64(&c.receivedBytes, uint64(size))
+}
+
+// handle - Counts count samples handed over to a handler that ran for the provided duration
+func (c *eventCounters) handle(count int, latency time.Duration) {
+	atomic.AddUint64(&c.handled, uint64(count))
+	atomic.AddUint64(&c.latencySum, uint64(latency))
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddUint64(&c.latencyCounts[bucket], 1)
+}
+
+// stats - Returns a snapshot of the counters
+func (c *eventCounters) stats(name string, kind string) EventStats {
+	stats := EventStats{
+		Name:           name,
+		Kind:           kind,
+		Received:       atomic.LoadUint64(&c.received),
+		ReceivedBytes:  atomic.LoadUint64(&c.receivedBytes),
+		KernelDrops:    atomic.LoadUint64(&c.kernelDrops),
+		UserspaceDrops: atomic.LoadUint64(&c.userspaceDrops),
+		DecodeErrors:   atomic.LoadUint64(&c.decodeErrors),
+		Handled:        atomic.LoadUint64(&c.handled),
+		SlowHandlers:   atomic.LoadUint64(&c.slowHandlers),
+		HandlerLatency: LatencyHistogram{
+			Buckets: append([]time.Duration(nil), latencyBuckets[:]...),
+			Counts:  make([]uint64, len(latencyBuckets)+1),
+			Sum:     time.Duration(atomic.LoadUint64(&c.latencySum)),
+		},
+	}
+	for i := range stats.HandlerLatency.Counts {
+		stats.HandlerLatency.Counts[i] = atomic.LoadUint64(&c.latencyCounts[i])
+		stats.HandlerLatency.Count += stats.HandlerLatency.Counts[i]
+	}
+	if started := atomic.LoadInt64(&c.started); started > 0 {
+		if elapsed := time.Since(time.Unix(0, started)).Seconds(); elapsed > 0 {
+			stats.BytesPerSecond = float64(stats.ReceivedBytes) / elapsed
+		}
+	}
+	return stats
+}
+
+// GetEventStats - Returns the end-to-end accounting of the samples of the perf maps and of the ring buffers of the
+// manager, see EventStats
+func (m *Manager) GetEventStats() ([]EventStats, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	var stats []EventStats
+	for _, perfMap := range m.PerfMaps {
+		stats = append(stats, perfMap.events.stats(perfMap.Name, "perf_map"))
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		stats = append(stats, ringBuffer.events.stats(ringBuffer.Name, "ring_buffer"))
+	}
+	return stats, nil
+}
+
+// DumpEventStats - Returns a human readable table of the accounting of the samples of the perf maps and of the ring
+// buffers of the manager, see GetEventStats
+func (m *Manager) DumpEventStats() (string, error) {
+	stats, err := m.GetEventStats()
+	if err != nil {
+		return "", err
+	}
+	if len(stats) == 0 {
+		return "", nil
+	}
+	var output strings.Builder
+	w := tabwriter.NewWriter(&output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "MAP\tKIND\tRECEIVED\tKERNEL_DROPS\tUSERSPACE_DROPS\tDECODE_ERRORS\tHANDLED\tBYTES/S\tAVG_LATENCY")
+	for _, s := range stats {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%.0f\t%s\n", s.Name, s.Kind, s.Received, s.KernelDrops, s.UserspaceDrops, s.DecodeErrors, s.Handled, s.BytesPerSecond, s.HandlerLatency.Average())
+	}
+	_ = w.Flush()
+	return output.String(), nil
+}
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventDropPolicy - Defines what the event worker pool of a manager does with a new event when its queue is full
+type EventDropPolicy int
+
+const (
+	// EventBlock - The reader waits until a worker is available. The events are never dropped in userspace, but the
+	// kernel drops them (and reports them as lost samples) once its ring buffer is full. This is the default policy.
+	EventBlock EventDropPolicy = iota
+	// EventDropOldest - The oldest event of the queue is dropped to make room for the new one
+	EventDropOldest
+	// EventDropNewest - The new event is dropped
+	EventDropNewest
+)
+
+func (p EventDropPolicy) String() string {
+	switch p {
+	case EventBlock:
+		return "block"
+	case EventDropOldest:
+		return "drop-oldest"
+	case EventDropNewest:
+		return "drop-newest"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultEventQueueSize - Default number of events queued for the event worker pool of a manager
+const DefaultEventQueueSize = 1024
+
+// eventTask - Event queued for the event worker pool: run calls the data handler of the event, drop is called instead
+// if the event is dropped
+type eventTask struct {
+	run  func()
+	drop func()
+}
+
+// eventPool - Bounded pool of workers calling the data handlers of the perf maps and ring buffers of a manager, so that
+// a slow handler doesn't stall the readers
+type eventPool struct {
+	lock    sync.RWMutex
+	closed  bool
+	queue   chan eventTask
+	policy  EventDropPolicy
+	workers sync.WaitGroup
+	dropped *uint64
+}
+
+// newEventPool - Starts a pool of the provided number of workers. The drops are counted in dropped.
+func newEventPool(workers int, queueSize int, policy EventDropPolicy, dropped *uint64) *eventPool {
+	if queueSize <= 0 {
+		queueSize = DefaultEventQueueSize
+	}
+	pool := &eventPool{
+		queue:   make(chan eventTask, queueSize),
+		policy:  policy,
+		dropped: dropped,
+	}
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer pool.workers.Done()
+			for task := range pool.queue {
+				task.run()
+			}
+		}()
+	}
+	return pool
+}
+
+// submit - Queues the provided event according to the drop policy of the pool. The event is handled right away once
+// the pool is closed.
+func (p *eventPool) submit(task eventTask) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		task.run()
+		return
+	}
+
+	switch p.policy {
+	case EventDropNewest:
+		select {
+		case p.queue <- task:
+		default:
+			p.drop(task)
+		}
+	case EventDropOldest:
+		for {
+			select {
+			case p.queue <- task:
+				return
+			default:
+			}
+			select {
+			case oldest := <-p.queue:
+				p.drop(oldest)
+			default:
+			}
+		}
+	default:
+		p.queue <- task
+	}
+}
+
+// drop - Counts the provided event as dropped
+func (p *eventPool) drop(task eventTask) {
+	atomic.AddUint64(p.dropped, 1)
+	if task.drop != nil {
+		task.drop()
+	}
+}
+
+// close - Waits until the queued events are handled and stops the workers
+func (p *eventPool) close() {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.lock.Unlock()
+	p.workers.Wait()
+}
+
+// startEventPool - Starts the event worker pool of the manager if Options.EventConcurrency is set
+func (m *Manager) startEventPool() {
+	if m.options.EventConcurrency <= 0 || m.eventPool != nil {
+		return
+	}
+	m.eventPool = newEventPool(m.options.EventConcurrency, m.options.EventQueueSize, m.options.EventDropPolicy, &m.droppedEvents)
+}
+
+// stopEventPool - Handles the queued events and stops the event worker pool of the manager
+func (m *Manager) stopEventPool() {
+	if m.eventPool == nil {
+		return
+	}
+	m.eventPool.close()
+	m.eventPool = nil
+}
+
+// dispatchEvent - Calls run on the event worker pool of the manager if there is one, and right away otherwise. drop is
+// called if the event is dropped.
+func (m *Manager) dispatchEvent(run func(), drop func()) {
+	if m == nil || m.eventPool == nil {
+		m.fenced(run)
+		return
+	}
+	m.eventPool.submit(eventTask{run: func() { m.fenced(run) }, drop: drop})
+}
+
+// DroppedEvents - Returns the number of events dropped in userspace by the event worker pool of the manager because
+// its queue was full. See Options.EventDropPolicy.
+func (m *Manager) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&m.droppedEvents)
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// startBlockedPerfMap - Starts a test mode perf map whose DataHandler runs on a single worker, and blocks the worker on
+// a first sample. The samples delivered afterwards are sent on the returned channel once release is closed.
+func startBlockedPerfMap(t *testing.T, policy EventDropPolicy) (*Manager, *PerfMap, chan byte, chan struct{}) {
+	delivered := make(chan byte, 16)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				if data[0] == 0 {
+					close(started)
+				}
+				<-release
+				delivered <- data[0]
+			},
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+	m := &Manager{
+		wg:       &sync.WaitGroup{},
+		options:  Options{EventConcurrency: 1, EventQueueSize: 1, EventDropPolicy: policy},
+		PerfMaps: []*PerfMap{perfMap},
+	}
+	m.startEventPool()
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.InjectSample(0, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the worker didn't pick the first sample")
+	}
+	return m, perfMap, delivered, release
+}
+
+func TestEventPoolDropPolicies(t *testing.T) {
+	for policy, expected := range map[EventDropPolicy][]byte{
+		EventDropNewest: {0, 1},
+		EventDropOldest: {0, 3},
+	} {
+		t.Run(policy.String(), func(t *testing.T) {
+			m, perfMap, delivered, release := startBlockedPerfMap(t, policy)
+			// the queue holds a single sample, the reader doesn't wait for the busy worker
+			for _, sample := range []byte{1, 2, 3} {
+				if err := perfMap.InjectSample(0, []byte{sample}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			close(release)
+			m.stopEventPool()
+			close(delivered)
+
+			var samples []byte
+			for sample := range delivered {
+				samples = append(samples, sample)
+			}
+			if string(samples) != string(expected) {
+				t.Errorf("expected samples %v to be delivered, got %v", expected, samples)
+			}
+			if drops := perfMap.PerfMapStats.UserspaceDrops; drops != 2 || m.DroppedEvents() != 2 {
+				t.Errorf("expected 2 dropped samples, got %d (manager: %d)", drops, m.DroppedEvents())
+			}
+		})
+	}
+}
+
+func TestEventPoolBlock(t *testing.T) {
+	m, perfMap, delivered, release := startBlockedPerfMap(t, EventBlock)
+	if err := perfMap.InjectSample(0, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	injected := make(chan struct{})
+	go func() {
+		_ = perfMap.InjectSample(0, []byte{2})
+		close(injected)
+	}()
+	select {
+	case <-injected:
+		t.Fatal("expected the reader to wait for a worker")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-injected
+	m.stopEventPool()
+	if len(delivered) != 3 || m.DroppedEvents() != 0 {
+		t.Errorf("expected all the samples to be delivered, got %d delivered and %d dropped", len(delivered), m.DroppedEvents())
+	}
+}
@@ -69,7 +69,7 @@ func demoAddHook() error {
 
 	// Detaching a hook point does not close the underlying eBPF program, which means that the other hook points are
 	// still working
-	err = m.DetachHook(secondRmdir.Section, secondRmdir.UID)
+	err = m.DetachHook(secondRmdir.GetIdentificationPair())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// AddExternalMap - Registers a map that was loaded outside of the manager (received over a Unix socket, created by
+// another library, ...) once the manager is initialized. The manager takes ownership of the provided map: it is
+// pinned according to the pinning strategy of the manager (or at options.PinPath), closed with the manager according
+// to its map cleanup type, and reported by the dumps. Clone the map beforehand to keep using it after the manager is
+// stopped. The map is also made available to the programs loaded afterwards by the manager, see AddProbe. See
+// RemoveMap.
+func (m *Manager) AddExternalMap(name string, array *ebpf.Map, options MapOptions) (*Map, error) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	if array == nil || name == "" {
+		return nil, fmt.Errorf("error:%w , couldn't add map %s", ErrInvalidExternalObject, name)
+	}
+	if _, exists := m.getMap(name); exists {
+		return nil, fmt.Errorf("error:%w , couldn't add map %s", ErrMapNameInUse, name)
+	}
+	switch m.options.PinningStrategy {
+	case PinByName:
+		options.PinPath = m.pinName("map", name)
+	case PinNone:
+		options.PinPath = ""
+	}
+
+	managerMap := &Map{
+		array:      array,
+		Name:       name,
+		MapOptions: options,
+	}
+	if managerMap.PinPath != "" {
+		if err := array.Pin(managerMap.PinPath); err != nil {
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't pin map %s at %s", err, name, managerMap.PinPath))
+		}
+	}
+	if err := managerMap.Init(m); err != nil {
+		// Clean up
+		_ = managerMap.close(CleanAll)
+		return nil, err
+	}
+	m.collection.Maps[name] = array
+	m.Maps = append(m.Maps, managerMap)
+	return managerMap, nil
+}
+
+// AddExternalProgram - Registers a program that was loaded outside of the manager (received over a Unix socket,
+// created by another library, ...) once the manager is initialized, and attaches it with the provided probe if the
+// manager is running. The program type comes from the program itself, the probe section and attach type only select
+// the hook point, so that attachType is only required by the programs that need one at attach time (cgroup
+// programs, ...). The manager takes ownership of the provided program: the probe is then handled like the probes
+// provided at initialization, it is pinned at PinPath, stopped with the manager, reported by the dumps and selected by
+// its identification pair. Clone the program beforehand to keep using it after the probe is stopped. The program is left to the caller on
+// failure. See RemoveProbe.
+func (m *Manager) AddExternalProgram(probe *Probe, prog *ebpf.Program, attachType ebpf.AttachType) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	id := probe.GetIdentificationPair()
+	if prog == nil {
+		return fmt.Errorf("error:%w , couldn't add probe %v", ErrInvalidExternalObject, id)
+	}
+	// program extensions are loaded against their target program, see FreplaceTarget
+	if prog.Type() == ebpf.UnspecifiedProgram || prog.Type() == ebpf.Extension {
+		return fmt.Errorf("error:%w , program type %s of probe %v isn't supported", ErrInvalidExternalObject, prog.Type(), id)
+	}
+	if _, exists := m.GetProbe(id); exists {
+		return fmt.Errorf("error:%w , couldn't add probe %v", ErrIdentificationPairInUse, id)
+	}
+	// the program is registered in the collection, so that it is closed with the manager
+	name := probe.EbpfFuncName + probe.UID
+	if _, exists := m.collection.Programs[name]; exists {
+		return fmt.Errorf("error:%w , program %s already exists", ErrInvalidExternalObject, name)
+	}
+	probe.Enabled = true
+	probe.program = prog
+	probe.programSpec = &ebpf.ProgramSpec{
+		Name:        probe.EbpfFuncName,
+		Type:        prog.Type(),
+		AttachType:  attachType,
+		SectionName: probe.Section,
+	}
+
+	if err := probe.InitWithOptions(m, false, true); err != nil {
+		// clean up
+		_ = probe.Stop()
+		probe.program = nil
+		return errors.New(fmt.Sprintf("error:%v , failed to initialize external probe %v", err, id))
+	}
+	if m.state == running {
+		if err := probe.Attach(); err != nil {
+			// clean up
+			_ = probe.Stop()
+			probe.program = nil
+			return errors.New(fmt.Sprintf("error:%v , failed to attach external probe %v", err, id))
+		}
+	}
+	m.collection.Programs[name] = prog
+	m.Probes = append(m.Probes, probe)
+	return nil
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/perf"
+)
+
+// GetLinkFD - Returns the fd of the BPF link that attaches the program of the probe. Returns ErrFDUnavailable if the
+// probe isn't attached, or if it was attached without a BPF link (see GetPerfEventFDs). The fd is owned by the
+// probe: it must not be closed, and is only valid until the probe is detached.
+func (p *Probe) GetLinkFD() (int, error) {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	if fdLink, ok := p.link.(interface{ FD() int }); ok {
+		return fdLink.FD(), nil
+	}
+	return -1, fmt.Errorf("error:%w , probe %s has no BPF link", ErrFDUnavailable, p.GetIdentificationPair())
+}
+
+// GetPerfEventFDs - Returns the fds of the perf events opened by the manager to attach the program of the probe: one
+// per CPU for the sampling perf events (see SampleFrequency), one for the kprobes attached through the kprobe_events
+// interface. The perf events opened by the eBPF library for the other probes aren't exposed. The fds are owned by the
+// probe: they must not be closed, and are only valid until the probe is detached.
+func (p *Probe) GetPerfEventFDs() []int {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	var fds []int
+	if p.kprobeEvent != nil && p.kprobeEvent.fd >= 0 {
+		fds = append(fds, p.kprobeEvent.fd)
+	}
+	if p.perfEventAttachment != nil {
+		fds = append(fds, p.perfEventAttachment.fds...)
+	}
+	return fds
+}
+
+// GetEpollFD - (ExternalPolling) Returns the epoll fd that becomes readable when samples are available in the perf
+// ring buffers, so that the perf map can be watched by the event loop of the caller along with other fds. Call
+// ReadAvailable once it is readable. The fd is owned by the perf map: it must not be closed, and is only valid until
+// the perf map is stopped or resized.
+func (m *PerfMap) GetEpollFD() (int, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state != running {
+		return -1, ErrMapNotRunning
+	}
+	if reader, ok := m.perfReader.(*perCPURecordReader); ok && m.ExternalPolling {
+		return reader.epollFD, nil
+	}
+	return -1, fmt.Errorf("error:%w , perf map %s isn't polled externally", ErrFDUnavailable, m.Name)
+}
+
+// ReadAvailable - (ExternalPolling) Dispatches the samples available in the perf ring buffers to the handlers of the
+// perf map without waiting for new ones, and returns the number of records that were read. Must not be called
+// concurrently for the same perf map.
+func (m *PerfMap) ReadAvailable() (int, error) {
+	m.stateLock.RLock()
+	if m.state != running {
+		m.stateLock.RUnlock()
+		return 0, ErrMapNotRunning
+	}
+	reader, ok := m.perfReader.(*perCPURecordReader)
+	m.stateLock.RUnlock()
+	if !ok || !m.ExternalPolling {
+		return 0, fmt.Errorf("error:%w , perf map %s isn't polled externally", ErrFDUnavailable, m.Name)
+	}
+
+	n, err := m.readAvailable(reader)
+	if errors.Is(err, perf.ErrClosed) {
+		return 0, ErrMapNotRunning
+	}
+	return n, err
+}
+
+// readAvailable - Dispatches the samples available in the perf ring buffers of the provided reader, see ReadAvailable
+func (m *PerfMap) readAvailable(reader *perCPURecordReader) (int, error) {
+	records, err := reader.readAvailable()
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		m.processRecord(record)
+	}
+	return len(records), nil
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestPerfMapExternalPolling(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	var samples [][]byte
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			PerfRingBufferSize: os.Getpagesize(),
+			ExternalPolling:    true,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				samples = append(samples, data)
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if err = perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer perfMap.Stop(CleanAll)
+	epollFD, err := perfMap.GetEpollFD()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// xdp: writes the 4 bytes 0x01020304 on the perf ring buffer of the current CPU
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -8, 0x01020304, asm.Word),
+			asm.LoadMapPtr(asm.R2, array.FD()),
+			asm.LoadImm(asm.R3, 0xffffffff, asm.DWord),
+			asm.Mov.Reg(asm.R4, asm.RFP),
+			asm.Add.Imm(asm.R4, -8),
+			asm.Mov.Imm(asm.R5, 4),
+			asm.FnPerfEventOutput.Call(),
+			asm.Mov.Imm(asm.R0, 2),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, err = prog.Benchmark(make([]byte, 14), 1, nil); err != nil {
+		t.Skipf("couldn't run the program: %v", err)
+	}
+
+	// the epoll fd of the perf map is watched by the event loop of the caller
+	events := make([]unix.EpollEvent, 1)
+	if n, err := unix.EpollWait(epollFD, events, 1000); err != nil || n != 1 {
+		t.Fatalf("expected the epoll fd to be readable, got %d (%v)", n, err)
+	}
+	if n, err := perfMap.ReadAvailable(); err != nil || n != 1 {
+		t.Fatalf("expected 1 record, got %d (%v)", n, err)
+	}
+	if len(samples) != 1 || len(samples[0]) < 4 || nativeEndian.Uint32(samples[0]) != 0x01020304 {
+		t.Errorf("unexpected samples %v", samples)
+	}
+	if n, err := perfMap.ReadAvailable(); err != nil || n != 0 {
+		t.Errorf("expected no record, got %d (%v)", n, err)
+	}
+
+	probe := &Probe{EbpfFuncName: "kprobe_vfs_open"}
+	if _, err = probe.GetLinkFD(); !errors.Is(err, ErrFDUnavailable) {
+		t.Errorf("expected ErrFDUnavailable for a detached probe, got %v", err)
+	}
+	if fds := probe.GetPerfEventFDs(); len(fds) != 0 {
+		t.Errorf("expected no perf event fd for a detached probe, got %v", fds)
+	}
+}
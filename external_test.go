@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestExternalObjects(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newProgram := func(name string) *ebpf.ProgramSpec {
+		return &ebpf.ProgramSpec{
+			Name:        name,
+			Type:        ebpf.SocketFilter,
+			SectionName: "socket/" + name,
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		}
+	}
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{"filter": newProgram("filter")}}
+	m := &Manager{Probes: []*Probe{{Section: "socket/filter", EbpfFuncName: "filter"}}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// objects loaded by another library
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ebpf.NewProgram(newProgram("external"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.AddExternalMap("external_map", nil, MapOptions{}); !errors.Is(err, ErrInvalidExternalObject) {
+		t.Errorf("expected ErrInvalidExternalObject, got %v", err)
+	}
+	if _, err = m.AddExternalMap("external_map", array, MapOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if found, ok, _ := m.GetMap("external_map"); !ok || found != array {
+		t.Error("expected the external map to be registered")
+	}
+	if _, err = m.AddExternalMap("external_map", array, MapOptions{}); !errors.Is(err, ErrMapNameInUse) {
+		t.Errorf("expected ErrMapNameInUse, got %v", err)
+	}
+
+	probe := &Probe{UID: "ext", Section: "socket/external", EbpfFuncName: "external"}
+	if err = m.AddExternalProgram(probe, prog, ebpf.AttachNone); err != nil {
+		t.Fatal(err)
+	}
+	found, ok := m.GetProbe(probe.GetIdentificationPair())
+	if !ok || found.Program() != prog || found.programSpec.Type != ebpf.SocketFilter {
+		t.Fatal("expected the external program to be registered")
+	}
+	if err = m.AddExternalProgram(&Probe{UID: "ext", Section: "socket/external", EbpfFuncName: "external"}, prog, ebpf.AttachNone); !errors.Is(err, ErrIdentificationPairInUse) {
+		t.Errorf("expected ErrIdentificationPairInUse, got %v", err)
+	}
+
+	// the external objects are closed with the manager
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if array.FD() >= 0 || prog.FD() >= 0 {
+		t.Error("expected the external objects to be closed with the manager")
+	}
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// maxFDTransferName - Maximum length of the name of a map sent over a Unix socket
+const maxFDTransferName = 4096
+
+// mapGetFDByID - Opens a new file descriptor for the map with the provided ID, with the provided BPF_F_RDONLY /
+// BPF_F_WRONLY open flags
+func mapGetFDByID(id ebpf.MapID, openFlags uint32) (int, error) {
+	// union bpf_attr, get fd by id variant
+	attr := struct {
+		id        uint32
+		nextID    uint32
+		openFlags uint32
+	}{id: uint32(id), openFlags: openFlags}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_MAP_GET_FD_BY_ID, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// SendMapFD - Sends a file descriptor of the requested map, along with its name, over the provided Unix socket
+// (SCM_RIGHTS), so that a privileged loader process can share its maps with an unprivileged consumer process. When
+// readOnly is set, the file descriptor is opened with BPF_F_RDONLY: the consumer can look the map up, but not update
+// it. The map itself stays owned by the sending manager. See ReceiveMapFD.
+func (m *Manager) SendMapFD(conn *net.UnixConn, name string, readOnly bool) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	if len(name) > maxFDTransferName {
+		return fmt.Errorf("error:%w , map name %s is too long", ErrFDTransfer, name)
+	}
+	array, ok := m.getMap(name)
+	if !ok || array == nil {
+		return fmt.Errorf("error:%w , couldn't find map %s", ErrUnknownMap, name)
+	}
+
+	fd := array.FD()
+	if readOnly {
+		info, err := array.Info()
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't get the info of map %s", err, name))
+		}
+		id, ok := info.ID()
+		if !ok {
+			return fmt.Errorf("error:%w , the ID of map %s isn't available, it requires kernel 4.13+", ErrFDTransfer, name)
+		}
+		if fd, err = mapGetFDByID(id, unix.BPF_F_RDONLY); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't open a read-only file descriptor for map %s", err, name))
+		}
+		defer unix.Close(fd)
+	}
+	if _, _, err := conn.WriteMsgUnix([]byte(name), unix.UnixRights(fd), nil); err != nil {
+		return fmt.Errorf("error:%w , couldn't send map %s: %v", ErrFDTransfer, name, err)
+	}
+	return nil
+}
+
+// ReceiveMapFD - Receives a map sent by SendMapFD over the provided Unix socket, and adds it to the maps of the
+// manager under the name chosen by the sender. The receiving manager doesn't own the map: its pin and its content are
+// never cleaned up, whatever the cleanup type of the manager, only the received file descriptor is closed when the
+// manager is stopped or the map removed. See RemoveMap.
+func (m *Manager) ReceiveMapFD(conn *net.UnixConn) (*Map, error) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	buf, oob := make([]byte, maxFDTransferName), make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't receive map: %v", ErrFDTransfer, err)
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't parse the control message: %v", ErrFDTransfer, err)
+	}
+	var fds []int
+	for _, message := range messages {
+		rights, err := unix.ParseUnixRights(&message)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			_ = unix.Close(fd)
+		}
+		return nil, fmt.Errorf("error:%w , expected one file descriptor, got %d", ErrFDTransfer, len(fds))
+	}
+	name := string(buf[:n])
+	array, err := ebpf.NewMapFromFD(fds[0])
+	if err != nil {
+		_ = unix.Close(fds[0])
+		return nil, fmt.Errorf("error:%w , map %s: %v", ErrFDTransfer, name, err)
+	}
+	if _, exists := m.getMap(name); exists {
+		_ = array.Close()
+		return nil, fmt.Errorf("error:%w , couldn't add map %s", ErrMapNameInUse, name)
+	}
+
+	managerMap := &Map{
+		array:       array,
+		Name:        name,
+		externalMap: true,
+		received:    true,
+	}
+	if err = managerMap.Init(m); err != nil {
+		_ = array.Close()
+		return nil, err
+	}
+	m.collection.Maps[name] = array
+	m.Maps = append(m.Maps, managerMap)
+	return managerMap, nil
+}
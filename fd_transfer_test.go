@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestMapFDTransfer(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newManager := func(mapName string) *Manager {
+		spec := &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				mapName: {Name: mapName, Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+			},
+			Programs: map[string]*ebpf.ProgramSpec{
+				"filter": {
+					Name:         "filter",
+					Type:         ebpf.SocketFilter,
+					SectionName:  "socket/filter",
+					License:      "GPL",
+					Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 0), asm.Return()},
+				},
+			},
+		}
+		m := &Manager{Probes: []*Probe{{Section: "socket/filter", EbpfFuncName: "filter"}}}
+		if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	newConn := func(fd int) *net.UnixConn {
+		file := os.NewFile(uintptr(fd), "unix")
+		defer file.Close()
+		conn, err := net.FileConn(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn.(*net.UnixConn)
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaderConn, consumerConn := newConn(fds[0]), newConn(fds[1])
+	defer loaderConn.Close()
+	defer consumerConn.Close()
+
+	loader, consumer := newManager("shared"), newManager("local")
+	defer loader.Stop(CleanAll)
+	shared, _, _ := loader.GetMap("shared")
+	if err = shared.Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = loader.SendMapFD(loaderConn, "unknown", true); !errors.Is(err, ErrUnknownMap) {
+		t.Errorf("expected ErrUnknownMap, got %v", err)
+	}
+	if err = loader.SendMapFD(loaderConn, "shared", true); err != nil {
+		t.Fatal(err)
+	}
+	received, err := consumer.ReceiveMapFD(consumerConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Name != "shared" {
+		t.Errorf("expected map shared, got %s", received.Name)
+	}
+	array, _, _ := consumer.GetMap("shared")
+	var value uint32
+	if err = array.Lookup(uint32(0), &value); err != nil || value != 42 {
+		t.Errorf("expected to read 42 from the received map, got %d (%v)", value, err)
+	}
+	if err = array.Put(uint32(0), uint32(1)); err == nil {
+		t.Error("expected the received map to be read-only")
+	}
+
+	// the consumer doesn't clean up the map of the loader
+	if err = consumer.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if err = shared.Lookup(uint32(0), &value); err != nil || value != 42 {
+		t.Errorf("expected the map of the loader to be intact, got %d (%v)", value, err)
+	}
+}
@@ -0,0 +1,337 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	haveTrampolinesOnce sync.Once
+	haveTrampolinesErr  error
+
+	haveBPFLSMOnce sync.Once
+	haveBPFLSMErr  error
+
+	haveAttachCookiesOnce sync.Once
+	haveAttachCookiesErr  error
+
+	haveTCXOnce sync.Once
+	haveTCXErr  error
+
+	haveKprobeMultiOnce sync.Once
+	haveKprobeMultiErr  error
+
+	haveUprobeMultiOnce sync.Once
+	haveUprobeMultiErr  error
+
+	haveBTFRawTracepointsOnce sync.Once
+	haveBTFRawTracepointsErr  error
+
+	currentKernelVersionOnce sync.Once
+	currentKernelVersion     KernelVersion
+	currentKernelVersionErr  error
+)
+
+// lsmListPath - Path to the list of active Linux Security Modules
+const lsmListPath = "/sys/kernel/security/lsm"
+
+// HaveTrampolines - Returns nil if the kernel supports BPF trampolines, which are required by fentry / fexit
+// programs. The result is computed once by loading and attaching a minimal fentry program.
+func HaveTrampolines() error {
+	haveTrampolinesOnce.Do(func() {
+		haveTrampolinesErr = probeTrampolines()
+	})
+	return haveTrampolinesErr
+}
+
+// probeTrampolines - Loads and attaches a minimal fentry program on bpf_fentry_test1, a function exposed by the
+// kernel for that purpose
+func probeTrampolines() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Tracing,
+		AttachType: ebpf.AttachTraceFEntry,
+		AttachTo:   "bpf_fentry_test1",
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoTrampolineSupport, err)
+	}
+	defer prog.Close()
+
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoTrampolineSupport, err)
+	}
+	return l.Close()
+}
+
+// HaveBTFRawTracepoints - Returns nil if the kernel supports BTF-enabled raw tracepoints (tp_btf), available since
+// kernel 5.5. The result is computed once by loading and attaching a minimal tp_btf program on sched_switch.
+func HaveBTFRawTracepoints() error {
+	haveBTFRawTracepointsOnce.Do(func() {
+		haveBTFRawTracepointsErr = probeBTFRawTracepoints()
+	})
+	return haveBTFRawTracepointsErr
+}
+
+// probeBTFRawTracepoints - Loads and attaches a minimal tp_btf program on the sched_switch tracepoint
+func probeBTFRawTracepoints() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Tracing,
+		AttachType: ebpf.AttachTraceRawTp,
+		AttachTo:   "sched_switch",
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoTPBTFSupport, err)
+	}
+	defer prog.Close()
+
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoTPBTFSupport, err)
+	}
+	return l.Close()
+}
+
+// HaveBPFLSM - Returns nil if the BPF LSM is active, which is required by LSM programs. This requires a kernel built
+// with CONFIG_BPF_LSM, and "bpf" in the list of active LSMs (see the lsm= kernel parameter).
+func HaveBPFLSM() error {
+	haveBPFLSMOnce.Do(func() {
+		haveBPFLSMErr = probeBPFLSM(lsmListPath)
+	})
+	return haveBPFLSMErr
+}
+
+// probeBPFLSM - Looks for the BPF LSM in the provided list of active LSMs
+func probeBPFLSM(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error:%w , couldn't read %s: %v", ErrNoBPFLSMSupport, path, err)
+	}
+	for _, lsm := range strings.Split(strings.TrimSpace(string(content)), ",") {
+		if lsm == "bpf" {
+			return nil
+		}
+	}
+	return fmt.Errorf("error:%w , active LSMs: %s", ErrNoBPFLSMSupport, strings.TrimSpace(string(content)))
+}
+
+// HaveAttachCookies - Returns nil if the kernel supports attach cookies on kprobes, uprobes and tracepoints, see
+// Probe.Cookie. Attach cookies are set on bpf_link based perf events, which are available since kernel 5.15.
+func HaveAttachCookies() error {
+	haveAttachCookiesOnce.Do(func() {
+		haveAttachCookiesErr = probeAttachCookies()
+	})
+	return haveAttachCookiesErr
+}
+
+// probeAttachCookies - Creates a perf event link on an invalid file descriptor: kernels that support perf event links
+// reject the file descriptor (EBADF), older kernels reject the attach type (EINVAL)
+func probeAttachCookies() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.Kprobe,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoAttachCookies, err)
+	}
+	defer prog.Close()
+
+	// union bpf_attr, link_create variant
+	attr := struct {
+		progFD     uint32
+		targetFD   uint32
+		attachType uint32
+		flags      uint32
+		bpfCookie  uint64
+	}{
+		progFD:     uint32(prog.FD()),
+		targetFD:   ^uint32(0),
+		attachType: uint32(ebpf.AttachPerfEvent),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno == 0 {
+		_ = unix.Close(int(fd))
+		return nil
+	}
+	if errno == unix.EBADF {
+		return nil
+	}
+	return fmt.Errorf("error:%w , %v", ErrNoAttachCookies, errno)
+}
+
+// HaveTCX - Returns nil if the kernel supports tcx links, see TCAttachModeTCX. tcx links are available since kernel
+// 6.6.
+func HaveTCX() error {
+	haveTCXOnce.Do(func() {
+		haveTCXErr = probeTCX()
+	})
+	return haveTCXErr
+}
+
+// probeTCX - Creates a tcx link on an invalid interface index: kernels that support tcx links reject the interface
+// (ENODEV), older kernels reject the attach type (EINVAL)
+func probeTCX() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoTCXSupport, err)
+	}
+	defer prog.Close()
+
+	// union bpf_attr, link_create variant
+	attr := struct {
+		progFD        uint32
+		targetIfindex uint32
+		attachType    uint32
+		flags         uint32
+	}{
+		progFD:     uint32(prog.FD()),
+		attachType: uint32(attachTCXIngress),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno == 0 {
+		_ = unix.Close(int(fd))
+		return nil
+	}
+	if errno == unix.ENODEV {
+		return nil
+	}
+	return fmt.Errorf("error:%w , %v", ErrNoTCXSupport, errno)
+}
+
+// HaveKprobeMulti - Returns nil if the kernel supports kprobe_multi links, see Probe.MatchFuncName. kprobe_multi
+// links are available since kernel 5.18, on kernels built with CONFIG_FPROBE.
+func HaveKprobeMulti() error {
+	haveKprobeMultiOnce.Do(func() {
+		haveKprobeMultiErr = probeKprobeMulti()
+	})
+	return haveKprobeMultiErr
+}
+
+// probeKprobeMulti - Attaches a kprobe_multi program to vprintk
+func probeKprobeMulti() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Kprobe,
+		AttachType: ebpf.AttachTraceKprobeMulti,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoKprobeMulti, err)
+	}
+	defer prog.Close()
+
+	l, err := link.KprobeMulti(prog, link.KprobeMultiOptions{Symbols: []string{"vprintk"}})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoKprobeMulti, err)
+	}
+	return l.Close()
+}
+
+// HaveUprobeMulti - Returns nil if the kernel supports uprobe_multi links, see Probe.UprobeGoReturns. uprobe_multi
+// links are available since kernel 6.6.
+func HaveUprobeMulti() error {
+	haveUprobeMultiOnce.Do(func() {
+		haveUprobeMultiErr = probeUprobeMulti()
+	})
+	return haveUprobeMultiErr
+}
+
+// probeUprobeMulti - Creates a uprobe_multi link on a directory: kernels that support uprobe_multi links reject the
+// path (EBADF), older kernels reject the attach type (EINVAL)
+func probeUprobeMulti() error {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Kprobe,
+		AttachType: attachTraceUprobeMulti,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , %v", ErrNoUprobeMulti, err)
+	}
+	defer prog.Close()
+
+	fd, err := createUprobeMultiLink(prog, "/", []uint64{0}, nil, 0)
+	if err == nil {
+		_ = unix.Close(fd)
+		return nil
+	}
+	if errors.Is(err, unix.EBADF) {
+		return nil
+	}
+	return fmt.Errorf("error:%w , %v", ErrNoUprobeMulti, err)
+}
+
+// KernelVersion - Version of a Linux kernel, encoded like the KERNEL_VERSION macro of the kernel headers
+type KernelVersion uint32
+
+// NewKernelVersion - Returns the KernelVersion of the provided release. The patch level is capped to 255, like the
+// kernel does.
+func NewKernelVersion(major, minor, patch uint32) KernelVersion {
+	if patch > 255 {
+		patch = 255
+	}
+	return KernelVersion(major<<16 | minor<<8 | patch)
+}
+
+func (v KernelVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", uint32(v)>>16, uint32(v)>>8&0xff, uint32(v)&0xff)
+}
+
+// CurrentKernelVersion - Returns the version of the running kernel, as reported by uname
+func CurrentKernelVersion() (KernelVersion, error) {
+	currentKernelVersionOnce.Do(func() {
+		var uname unix.Utsname
+		if err := unix.Uname(&uname); err != nil {
+			currentKernelVersionErr = fmt.Errorf("couldn't get the kernel release: %w", err)
+			return
+		}
+		currentKernelVersion, currentKernelVersionErr = parseKernelVersion(unix.ByteSliceToString(uname.Release[:]))
+	})
+	return currentKernelVersion, currentKernelVersionErr
+}
+
+// parseKernelVersion - Parses a kernel release such as 5.15.0-91-generic. The patch level is optional.
+func parseKernelVersion(release string) (KernelVersion, error) {
+	var major, minor, patch uint32
+	n, _ := fmt.Sscanf(release, "%d.%d.%d", &major, &minor, &patch)
+	if n < 2 {
+		return 0, fmt.Errorf("couldn't parse kernel release %q", release)
+	}
+	return NewKernelVersion(major, minor, patch), nil
+}
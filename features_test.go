@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestProbeBPFLSM(t *testing.T) {
+	dir := t.TempDir()
+	for content, expected := range map[string]error{
+		"lockdown,capability,yama,bpf\n": nil,
+		"lockdown,capability,yama\n":     ErrNoBPFLSMSupport,
+		"lockdown,bpfilter":              ErrNoBPFLSMSupport,
+	} {
+		path := filepath.Join(dir, "lsm")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := probeBPFLSM(path); !errors.Is(err, expected) {
+			t.Errorf("expected %v for %q, got %v", expected, content, err)
+		}
+	}
+	if err := probeBPFLSM(filepath.Join(dir, "missing")); !errors.Is(err, ErrNoBPFLSMSupport) {
+		t.Errorf("expected ErrNoBPFLSMSupport when securityfs isn't available, got %v", err)
+	}
+}
+
+func TestHaveAttachCookies(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	version, err := CurrentKernelVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = HaveAttachCookies()
+	if version >= NewKernelVersion(5, 15, 0) {
+		if err != nil {
+			t.Errorf("expected attach cookies to be supported on kernel %s, got %v", version, err)
+		}
+	} else if !errors.Is(err, ErrNoAttachCookies) {
+		t.Errorf("expected ErrNoAttachCookies on kernel %s, got %v", version, err)
+	}
+}
+
+func TestParseKernelVersion(t *testing.T) {
+	for release, expected := range map[string]KernelVersion{
+		"5.15.0-91-generic":     NewKernelVersion(5, 15, 0),
+		"4.14.336":              NewKernelVersion(4, 14, 255),
+		"6.1-rc4":               NewKernelVersion(6, 1, 0),
+		"4.19.0-26-cloud-amd64": NewKernelVersion(4, 19, 0),
+	} {
+		version, err := parseKernelVersion(release)
+		if err != nil {
+			t.Errorf("couldn't parse %q: %v", release, err)
+		} else if version != expected {
+			t.Errorf("expected %s for %q, got %s", expected, release, version)
+		}
+	}
+	if _, err := parseKernelVersion("unknown"); err == nil {
+		t.Error("expected an error for an invalid release")
+	}
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "kprobe/vfs_open", EbpfFuncName: "kprobe_vfs_open"},
+			{Section: "xdp/ingress", EbpfFuncName: "ingress"},
+		},
+		PerfMaps: []*PerfMap{{Map: Map{Name: "events"}}},
+	}
+	report, err := m.RequiredCapabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := CurrentKernelVersion(); version < capabilitiesKernelVersion {
+		t.Skip("CAP_BPF and CAP_PERFMON require kernel 5.8+")
+	}
+	if len(report.Required) != 3 || report.Required[0] != CapNetAdmin || report.Required[1] != CapPerfmon || report.Required[2] != CapBPF {
+		t.Errorf("unexpected required capabilities %v", report.Required)
+	}
+	if reasons := report.Reasons[CapPerfmon]; len(reasons) != 2 {
+		t.Errorf("expected the kprobe and the perf map to require CAP_PERFMON, got %v", reasons)
+	}
+	if report.Sufficient != (len(report.Missing) == 0) {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestBPFFSDelegation(t *testing.T) {
+	root := t.TempDir()
+	if bpffsDelegatesAll(root) {
+		t.Error("expected a directory without BPF filesystem not to delegate anything")
+	}
+	if err := unix.Mount("bpf", root, "bpf", 0, "delegate_cmds=any,delegate_maps=any,delegate_progs=any"); err != nil {
+		t.Skipf("couldn't mount a delegating BPF filesystem: %v", err)
+	}
+	defer unix.Unmount(root, 0)
+	if !bpffsDelegatesAll(root) {
+		t.Errorf("expected %s to delegate all the maps and programs: %v", root, bpffsMountOptions(root))
+	}
+}
+
+func TestHaveKprobeMulti(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	err := HaveKprobeMulti()
+	if err != nil && !errors.Is(err, ErrNoKprobeMulti) {
+		t.Errorf("expected nil or ErrNoKprobeMulti, got %v", err)
+	}
+	if again := HaveKprobeMulti(); again != err {
+		t.Errorf("the result should be cached, got %v then %v", err, again)
+	}
+}
+
+func TestHaveUprobeMulti(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	err := HaveUprobeMulti()
+	if err != nil && !errors.Is(err, ErrNoUprobeMulti) {
+		t.Errorf("expected nil or ErrNoUprobeMulti, got %v", err)
+	}
+	if again := HaveUprobeMulti(); again != err {
+		t.Errorf("the result should be cached, got %v then %v", err, again)
+	}
+}
@@ -0,0 +1,551 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// FilterKind - Type of the values of a filter, which defines the layout of the keys of its map
+type FilterKind int
+
+const (
+	// FilterPID - The keys of the filter are PIDs (or TGIDs), __u32 in the host byte order
+	FilterPID FilterKind = iota + 1
+	// FilterComm - The keys of the filter are process names (comm), char[16] NUL padded, as returned by
+	// bpf_get_current_comm
+	FilterComm
+	// FilterPort - The keys of the filter are TCP / UDP ports, __u16 in the host byte order unless
+	// FilterSpec.NetworkByteOrder is set
+	FilterPort
+	// FilterCGroupID - The keys of the filter are cgroup IDs, __u64 in the host byte order, as returned by
+	// bpf_get_current_cgroup_id
+	FilterCGroupID
+	// FilterMntNS - The keys of the filter are mount namespace inode numbers, __u32 in the host byte order, as read
+	// from task->nsproxy->mnt_ns->ns.inum
+	FilterMntNS
+)
+
+// DefaultFilterMaxEntries - Default maximum number of values of a filter created by the manager
+const DefaultFilterMaxEntries = 1024
+
+// filterCommLen - TASK_COMM_LEN
+const filterCommLen = 16
+
+// keySize - Returns the size of the keys of the filters of this kind
+func (k FilterKind) keySize() uint32 {
+	switch k {
+	case FilterPID:
+		return 4
+	case FilterComm:
+		return filterCommLen
+	case FilterPort:
+		return 2
+	case FilterCGroupID:
+		return 8
+	case FilterMntNS:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func (k FilterKind) String() string {
+	switch k {
+	case FilterPID:
+		return "pid"
+	case FilterComm:
+		return "comm"
+	case FilterPort:
+		return "port"
+	case FilterCGroupID:
+		return "cgroup_id"
+	case FilterMntNS:
+		return "mnt_ns"
+	default:
+		return fmt.Sprintf("FilterKind(%d)", int(k))
+	}
+}
+
+// FilterSpec - Declares a runtime filter of the eBPF programs: a set of PIDs, process names, ports, cgroup IDs or
+// mount namespaces stored as the keys of a hash map, which the programs look up to allow or deny an event. The manager
+// materializes the filter in its map, and exposes typed Add / Remove / List methods, see Manager.PIDFilter,
+// Manager.CommFilter, Manager.PortFilter, Manager.CGroupFilter and Manager.MntNSFilter. Whether the set is an
+// allowlist or a denylist is up to the programs.
+type FilterSpec struct {
+	// Name - Name of the map of the filter. If the programs don't define it, the manager creates a BPF_MAP_TYPE_HASH
+	// map with a value of 1 byte.
+	Name string
+
+	// Kind - Type of the values of the filter
+	Kind FilterKind
+
+	// MaxEntries - Maximum number of values of a filter created by the manager. Defaults to DefaultFilterMaxEntries.
+	MaxEntries uint32
+
+	// NetworkByteOrder - (FilterPort) The ports are stored in the network byte order
+	NetworkByteOrder bool
+
+	// Values - Initial values of the filter, added at Init: uint32 PIDs, string process names, uint16 ports, uint64
+	// cgroup IDs or uint32 mount namespaces
+	Values []interface{}
+}
+
+// getFilterSpec - Returns the spec of the filter with the provided name
+func (m *Manager) getFilterSpec(name string) (FilterSpec, bool) {
+	for _, spec := range m.options.Filters {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return FilterSpec{}, false
+}
+
+// prepareFilters - Checks the maps of the filters defined by the programs, and adds the ones they don't define to the
+// CollectionSpec
+func (m *Manager) prepareFilters() error {
+	for _, spec := range m.options.Filters {
+		keySize := spec.Kind.keySize()
+		if spec.Name == "" || keySize == 0 {
+			return fmt.Errorf("error:%w , filter %q of kind %s", ErrInvalidFilter, spec.Name, spec.Kind)
+		}
+		if mapSpec, ok := m.collectionSpec.Maps[spec.Name]; ok {
+			if mapSpec.KeySize != keySize || mapSpec.ValueSize == 0 {
+				return fmt.Errorf("error:%w , map %s has %d bytes keys, %s filters require %d bytes", ErrInvalidFilter, spec.Name, mapSpec.KeySize, spec.Kind, keySize)
+			}
+			continue
+		}
+		maxEntries := spec.MaxEntries
+		if maxEntries == 0 {
+			maxEntries = DefaultFilterMaxEntries
+		}
+		if m.collectionSpec.Maps == nil {
+			m.collectionSpec.Maps = make(map[string]*ebpf.MapSpec)
+		}
+		m.collectionSpec.Maps[spec.Name] = &ebpf.MapSpec{
+			Name:       spec.Name,
+			Type:       ebpf.Hash,
+			KeySize:    keySize,
+			ValueSize:  1,
+			MaxEntries: maxEntries,
+		}
+	}
+	return nil
+}
+
+// populateFilters - Adds the initial values of the filters to their maps
+func (m *Manager) populateFilters() error {
+	for _, spec := range m.options.Filters {
+		filter, err := m.newFilterMap(spec.Name, spec.Kind)
+		if err != nil {
+			return err
+		}
+		for _, value := range spec.Values {
+			if err = filter.add(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filterMap - Map of a filter, see FilterSpec
+type filterMap struct {
+	spec  FilterSpec
+	array *ebpf.Map
+}
+
+// newFilterMap - Returns the map of the filter with the provided name, which must be of the provided kind
+func (m *Manager) newFilterMap(name string, kind FilterKind) (*filterMap, error) {
+	spec, ok := m.getFilterSpec(name)
+	if !ok {
+		return nil, fmt.Errorf("error:%w , unknown filter %s", ErrInvalidFilter, name)
+	}
+	if spec.Kind != kind {
+		return nil, fmt.Errorf("error:%w , filter %s is a %s filter, not a %s filter", ErrInvalidFilter, name, spec.Kind, kind)
+	}
+	if m.collection == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	array, ok := m.getMap(name)
+	if !ok {
+		return nil, fmt.Errorf("error:%w , couldn't find map %s", ErrUnknownMap, name)
+	}
+	return &filterMap{spec: spec, array: array}, nil
+}
+
+// key - Encodes the provided value as a key of the map of the filter
+func (f *filterMap) key(value interface{}) ([]byte, error) {
+	key := make([]byte, f.spec.Kind.keySize())
+	switch v := value.(type) {
+	case uint32:
+		if f.spec.Kind != FilterPID && f.spec.Kind != FilterMntNS {
+			break
+		}
+		nativeEndian.PutUint32(key, v)
+		return key, nil
+	case string:
+		if f.spec.Kind != FilterComm {
+			break
+		}
+		// the kernel truncates the process names to 15 characters
+		if len(v) >= filterCommLen {
+			v = v[:filterCommLen-1]
+		}
+		copy(key, v)
+		return key, nil
+	case uint16:
+		if f.spec.Kind != FilterPort {
+			break
+		}
+		if f.spec.NetworkByteOrder {
+			binary.BigEndian.PutUint16(key, v)
+		} else {
+			nativeEndian.PutUint16(key, v)
+		}
+		return key, nil
+	case uint64:
+		if f.spec.Kind != FilterCGroupID {
+			break
+		}
+		nativeEndian.PutUint64(key, v)
+		return key, nil
+	}
+	return nil, fmt.Errorf("error:%w , value %v of type %T can't be added to %s filter %s", ErrInvalidFilter, value, value, f.spec.Kind, f.spec.Name)
+}
+
+// value - Decodes the provided key of the map of the filter
+func (f *filterMap) value(key []byte) interface{} {
+	switch f.spec.Kind {
+	case FilterPID, FilterMntNS:
+		return nativeEndian.Uint32(key)
+	case FilterComm:
+		if i := bytes.IndexByte(key, 0); i >= 0 {
+			key = key[:i]
+		}
+		return string(key)
+	case FilterPort:
+		if f.spec.NetworkByteOrder {
+			return binary.BigEndian.Uint16(key)
+		}
+		return nativeEndian.Uint16(key)
+	default:
+		return nativeEndian.Uint64(key)
+	}
+}
+
+// add - Adds the provided value to the filter
+func (f *filterMap) add(value interface{}) error {
+	key, err := f.key(value)
+	if err != nil {
+		return err
+	}
+	// the programs only look the keys up, the value is set to 1
+	mapValue := make([]byte, f.array.ValueSize())
+	mapValue[0] = 1
+	if err = f.array.Put(key, mapValue); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't add %v to filter %s", err, value, f.spec.Name))
+	}
+	return nil
+}
+
+// remove - Removes the provided value from the filter, removing a value that isn't in the filter isn't an error
+func (f *filterMap) remove(value interface{}) error {
+	key, err := f.key(value)
+	if err != nil {
+		return err
+	}
+	if err = f.array.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return errors.New(fmt.Sprintf("error:%v , couldn't remove %v from filter %s", err, value, f.spec.Name))
+	}
+	return nil
+}
+
+// contains - Returns true if the provided value is in the filter
+func (f *filterMap) contains(value interface{}) (bool, error) {
+	key, err := f.key(value)
+	if err != nil {
+		return false, err
+	}
+	mapValue := make([]byte, f.array.ValueSize())
+	if err = f.array.Lookup(key, &mapValue); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// list - Returns the values of the filter
+func (f *filterMap) list() ([]interface{}, error) {
+	var values []interface{}
+	key := make([]byte, f.spec.Kind.keySize())
+	var mapValue []byte
+	iterator := f.array.Iterate()
+	for iterator.Next(&key, &mapValue) {
+		values = append(values, f.value(key))
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't list filter %s", err, f.spec.Name))
+	}
+	return values, nil
+}
+
+// PIDFilter - Filter of PIDs, see FilterPID
+type PIDFilter struct {
+	filter *filterMap
+}
+
+// PIDFilter - Returns the PID filter with the provided name, see FilterSpec
+func (m *Manager) PIDFilter(name string) (*PIDFilter, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	filter, err := m.newFilterMap(name, FilterPID)
+	if err != nil {
+		return nil, err
+	}
+	return &PIDFilter{filter: filter}, nil
+}
+
+// Add - Adds the provided PIDs to the filter
+func (f *PIDFilter) Add(pids ...uint32) error {
+	for _, pid := range pids {
+		if err := f.filter.add(pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove - Removes the provided PIDs from the filter
+func (f *PIDFilter) Remove(pids ...uint32) error {
+	for _, pid := range pids {
+		if err := f.filter.remove(pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains - Returns true if the provided PID is in the filter
+func (f *PIDFilter) Contains(pid uint32) (bool, error) {
+	return f.filter.contains(pid)
+}
+
+// List - Returns the PIDs of the filter
+func (f *PIDFilter) List() ([]uint32, error) {
+	values, err := f.filter.list()
+	pids := make([]uint32, 0, len(values))
+	for _, value := range values {
+		pids = append(pids, value.(uint32))
+	}
+	return pids, err
+}
+
+// CommFilter - Filter of process names, see FilterComm
+type CommFilter struct {
+	filter *filterMap
+}
+
+// CommFilter - Returns the process name filter with the provided name, see FilterSpec
+func (m *Manager) CommFilter(name string) (*CommFilter, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	filter, err := m.newFilterMap(name, FilterComm)
+	if err != nil {
+		return nil, err
+	}
+	return &CommFilter{filter: filter}, nil
+}
+
+// Add - Adds the provided process names to the filter, they are truncated to 15 characters like in the kernel
+func (f *CommFilter) Add(comms ...string) error {
+	for _, comm := range comms {
+		if err := f.filter.add(comm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove - Removes the provided process names from the filter
+func (f *CommFilter) Remove(comms ...string) error {
+	for _, comm := range comms {
+		if err := f.filter.remove(comm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains - Returns true if the provided process name is in the filter
+func (f *CommFilter) Contains(comm string) (bool, error) {
+	return f.filter.contains(comm)
+}
+
+// List - Returns the process names of the filter
+func (f *CommFilter) List() ([]string, error) {
+	values, err := f.filter.list()
+	comms := make([]string, 0, len(values))
+	for _, value := range values {
+		comms = append(comms, value.(string))
+	}
+	return comms, err
+}
+
+// PortFilter - Filter of ports, see FilterPort
+type PortFilter struct {
+	filter *filterMap
+}
+
+// PortFilter - Returns the port filter with the provided name, see FilterSpec
+func (m *Manager) PortFilter(name string) (*PortFilter, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	filter, err := m.newFilterMap(name, FilterPort)
+	if err != nil {
+		return nil, err
+	}
+	return &PortFilter{filter: filter}, nil
+}
+
+// Add - Adds the provided ports to the filter
+func (f *PortFilter) Add(ports ...uint16) error {
+	for _, port := range ports {
+		if err := f.filter.add(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove - Removes the provided ports from the filter
+func (f *PortFilter) Remove(ports ...uint16) error {
+	for _, port := range ports {
+		if err := f.filter.remove(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains - Returns true if the provided port is in the filter
+func (f *PortFilter) Contains(port uint16) (bool, error) {
+	return f.filter.contains(port)
+}
+
+// List - Returns the ports of the filter
+func (f *PortFilter) List() ([]uint16, error) {
+	values, err := f.filter.list()
+	ports := make([]uint16, 0, len(values))
+	for _, value := range values {
+		ports = append(ports, value.(uint16))
+	}
+	return ports, err
+}
+
+// CGroupFilter - Filter of cgroup IDs, see FilterCGroupID
+type CGroupFilter struct {
+	filter *filterMap
+}
+
+// CGroupFilter - Returns the cgroup ID filter with the provided name, see FilterSpec
+func (m *Manager) CGroupFilter(name string) (*CGroupFilter, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	filter, err := m.newFilterMap(name, FilterCGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return &CGroupFilter{filter: filter}, nil
+}
+
+// Add - Adds the provided cgroup IDs to the filter
+func (f *CGroupFilter) Add(ids ...uint64) error {
+	for _, id := range ids {
+		if err := f.filter.add(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove - Removes the provided cgroup IDs from the filter
+func (f *CGroupFilter) Remove(ids ...uint64) error {
+	for _, id := range ids {
+		if err := f.filter.remove(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains - Returns true if the provided cgroup ID is in the filter
+func (f *CGroupFilter) Contains(id uint64) (bool, error) {
+	return f.filter.contains(id)
+}
+
+// List - Returns the cgroup IDs of the filter
+func (f *CGroupFilter) List() ([]uint64, error) {
+	values, err := f.filter.list()
+	ids := make([]uint64, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, value.(uint64))
+	}
+	return ids, err
+}
+
+// MntNSFilter - Filter of mount namespaces, see FilterMntNS
+type MntNSFilter struct {
+	filter *filterMap
+}
+
+// MntNSFilter - Returns the mount namespace filter with the provided name, see FilterSpec
+func (m *Manager) MntNSFilter(name string) (*MntNSFilter, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	filter, err := m.newFilterMap(name, FilterMntNS)
+	if err != nil {
+		return nil, err
+	}
+	return &MntNSFilter{filter: filter}, nil
+}
+
+// Add - Adds the provided mount namespaces to the filter
+func (f *MntNSFilter) Add(inodes ...uint32) error {
+	for _, inode := range inodes {
+		if err := f.filter.add(inode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove - Removes the provided mount namespaces from the filter
+func (f *MntNSFilter) Remove(inodes ...uint32) error {
+	for _, inode := range inodes {
+		if err := f.filter.remove(inode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains - Returns true if the provided mount namespace is in the filter
+func (f *MntNSFilter) Contains(inode uint32) (bool, error) {
+	return f.filter.contains(inode)
+}
+
+// List - Returns the mount namespaces of the filter
+func (f *MntNSFilter) List() ([]uint32, error) {
+	values, err := f.filter.list()
+	inodes := make([]uint32, 0, len(values))
+	for _, value := range values {
+		inodes = append(inodes, value.(uint32))
+	}
+	return inodes, err
+}
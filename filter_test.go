@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestFilters(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	spec := &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			// defined by the programs, with a larger value
+			"allowed_pids": {Name: "allowed_pids", Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 16},
+		},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"filter": {
+				Name:         "filter",
+				Type:         ebpf.SocketFilter,
+				SectionName:  "socket/filter",
+				License:      "GPL",
+				Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 0), asm.Return()},
+			},
+		},
+	}
+	m := &Manager{Probes: []*Probe{{Section: "socket/filter", EbpfFuncName: "filter"}}}
+	options := Options{Filters: []FilterSpec{
+		{Name: "allowed_pids", Kind: FilterPID, Values: []interface{}{uint32(1)}},
+		{Name: "denied_comms", Kind: FilterComm, Values: []interface{}{"a_very_long_process_name"}},
+		{Name: "ports", Kind: FilterPort, NetworkByteOrder: true},
+	}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	pids, err := m.PIDFilter("allowed_pids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pids.Add(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err = pids.Remove(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	list, err := pids.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	if len(list) != 2 || list[0] != 1 || list[1] != 2 {
+		t.Errorf("expected PIDs 1 and 2, got %v", list)
+	}
+
+	// the map of the comm filter was created by the manager, the names are truncated like in the kernel
+	comms, err := m.CommFilter("denied_comms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := comms.Contains("a_very_long_pro"); err != nil || !ok {
+		t.Errorf("expected the truncated process name in the filter: %v", err)
+	}
+	names, err := comms.List()
+	if err != nil || len(names) != 1 || names[0] != "a_very_long_pro" {
+		t.Errorf("unexpected process names %v: %v", names, err)
+	}
+
+	// the ports are stored in the network byte order
+	ports, err := m.PortFilter("ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ports.Add(0x1234); err != nil {
+		t.Fatal(err)
+	}
+	array, _, _ := m.GetMap("ports")
+	var value uint8
+	if err = array.Lookup([]byte{0x12, 0x34}, &value); err != nil || value != 1 {
+		t.Errorf("expected the port in the network byte order: %v", err)
+	}
+
+	if _, err = m.CGroupFilter("ports"); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter, got %v", err)
+	}
+}
+
+func TestFilterKeySizeMismatch(t *testing.T) {
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"cgroups": {Name: "cgroups", Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 16},
+	}}
+	m := &Manager{}
+	err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{Filters: []FilterSpec{{Name: "cgroups", Kind: FilterCGroupID}}})
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter, got %v", err)
+	}
+}
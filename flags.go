@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// FlagsMapName - Name of the BPF_MAP_TYPE_ARRAY map of the flags of the manager, see Options.Flags
+const FlagsMapName = "ebpfmanager_flags"
+
+// flagNamePattern - The names of the flags are C identifiers
+var flagNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// FlagSpec - Declares a runtime flag shared by user space and the eBPF programs: a __u64 value at a fixed index of the
+// FlagsMapName map. The index of a flag is its position in Options.Flags, FlagsHeader generates the matching
+// constants for the eBPF programs. See Manager.Flags.
+type FlagSpec struct {
+	// Name - Name of the flag, a C identifier
+	Name string
+
+	// Default - Value of the flag at Init
+	Default uint64
+}
+
+// Flags - Typed access to the flags of the manager, see Options.Flags
+type Flags struct {
+	specs   []FlagSpec
+	indexes map[string]uint32
+	array   *ebpf.Map
+}
+
+// checkFlags - Checks the names of the provided flags
+func checkFlags(specs []FlagSpec) error {
+	names := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if !flagNamePattern.MatchString(spec.Name) {
+			return fmt.Errorf("error:%w , flag name %q isn't a C identifier", ErrInvalidFlag, spec.Name)
+		}
+		if names[strings.ToUpper(spec.Name)] {
+			return fmt.Errorf("error:%w , flag %s is declared twice", ErrInvalidFlag, spec.Name)
+		}
+		names[strings.ToUpper(spec.Name)] = true
+	}
+	return nil
+}
+
+// FlagsHeader - Generates the C header shared by the eBPF programs with the manager for the provided flags: the
+// declaration of the FlagsMapName map, an EBPFMANAGER_FLAG_[NAME] constant per flag holding its index, and an
+// ebpfmanager_flag(index) helper returning the value of a flag. Meant for go:generate, with the Options.Flags of
+// the manager.
+func FlagsHeader(specs []FlagSpec) (string, error) {
+	if err := checkFlags(specs); err != nil {
+		return "", err
+	}
+	var header strings.Builder
+	header.WriteString("/* Code generated by manager.FlagsHeader. DO NOT EDIT. */\n")
+	header.WriteString("#ifndef __EBPFMANAGER_FLAGS_H\n#define __EBPFMANAGER_FLAGS_H\n\n")
+	for i, spec := range specs {
+		_, _ = fmt.Fprintf(&header, "#define EBPFMANAGER_FLAG_%s %d\n", strings.ToUpper(spec.Name), i)
+	}
+	_, _ = fmt.Fprintf(&header, "#define EBPFMANAGER_FLAGS_COUNT %d\n\n", len(specs))
+	_, _ = fmt.Fprintf(&header, "struct bpf_map_def SEC(\"maps/%s\") %s = {\n", FlagsMapName, FlagsMapName)
+	header.WriteString("    .type = BPF_MAP_TYPE_ARRAY,\n")
+	header.WriteString("    .key_size = sizeof(__u32),\n")
+	header.WriteString("    .value_size = sizeof(__u64),\n")
+	header.WriteString("    .max_entries = EBPFMANAGER_FLAGS_COUNT,\n")
+	header.WriteString("};\n\n")
+	header.WriteString("static __always_inline __u64 ebpfmanager_flag(__u32 index) {\n")
+	_, _ = fmt.Fprintf(&header, "    __u64 *value = bpf_map_lookup_elem(&%s, &index);\n", FlagsMapName)
+	header.WriteString("    return value ? *value : 0;\n")
+	header.WriteString("}\n\n#endif\n")
+	return header.String(), nil
+}
+
+// prepareFlags - Checks the map of the flags if the programs define it, adds it to the CollectionSpec otherwise
+func (m *Manager) prepareFlags() error {
+	if len(m.options.Flags) == 0 {
+		return nil
+	}
+	if err := checkFlags(m.options.Flags); err != nil {
+		return err
+	}
+	if mapSpec, ok := m.collectionSpec.Maps[FlagsMapName]; ok {
+		if mapSpec.Type != ebpf.Array || mapSpec.KeySize != 4 || mapSpec.ValueSize != 8 || mapSpec.MaxEntries < uint32(len(m.options.Flags)) {
+			return fmt.Errorf("error:%w , map %s must be an array of at least %d __u64 values", ErrInvalidFlag, FlagsMapName, len(m.options.Flags))
+		}
+		return nil
+	}
+	if m.collectionSpec.Maps == nil {
+		m.collectionSpec.Maps = make(map[string]*ebpf.MapSpec)
+	}
+	m.collectionSpec.Maps[FlagsMapName] = &ebpf.MapSpec{
+		Name:       FlagsMapName,
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: uint32(len(m.options.Flags)),
+	}
+	return nil
+}
+
+// populateFlags - Writes the default values of the flags to their map
+func (m *Manager) populateFlags() error {
+	if len(m.options.Flags) == 0 {
+		return nil
+	}
+	flags, err := m.newFlags()
+	if err != nil {
+		return err
+	}
+	for _, spec := range m.options.Flags {
+		if err = flags.Set(spec.Name, spec.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newFlags - Returns the flags of the manager
+func (m *Manager) newFlags() (*Flags, error) {
+	if m.collection == nil {
+		return nil, ErrManagerNotInitialized
+	}
+	if len(m.options.Flags) == 0 {
+		return nil, fmt.Errorf("error:%w , the manager doesn't declare any flag", ErrInvalidFlag)
+	}
+	array, ok := m.getMap(FlagsMapName)
+	if !ok {
+		return nil, fmt.Errorf("error:%w , couldn't find map %s", ErrUnknownMap, FlagsMapName)
+	}
+	flags := &Flags{specs: m.options.Flags, indexes: make(map[string]uint32, len(m.options.Flags)), array: array}
+	for i, spec := range m.options.Flags {
+		flags.indexes[spec.Name] = uint32(i)
+	}
+	return flags, nil
+}
+
+// Flags - Returns the flags of the manager, see Options.Flags
+func (m *Manager) Flags() (*Flags, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.newFlags()
+}
+
+// Index - Returns the index of the provided flag in the FlagsMapName map
+func (f *Flags) Index(name string) (uint32, error) {
+	index, ok := f.indexes[name]
+	if !ok {
+		return 0, fmt.Errorf("error:%w , unknown flag %s", ErrInvalidFlag, name)
+	}
+	return index, nil
+}
+
+// Set - Sets the value of the provided flag
+func (f *Flags) Set(name string, value uint64) error {
+	index, err := f.Index(name)
+	if err != nil {
+		return err
+	}
+	if err = f.array.Put(index, value); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't set flag %s", err, name))
+	}
+	return nil
+}
+
+// Get - Returns the value of the provided flag
+func (f *Flags) Get(name string) (uint64, error) {
+	index, err := f.Index(name)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	if err = f.array.Lookup(index, &value); err != nil {
+		return 0, errors.New(fmt.Sprintf("error:%v , couldn't get flag %s", err, name))
+	}
+	return value, nil
+}
+
+// SetBool - Sets the provided flag to 1 if enabled is true, 0 otherwise
+func (f *Flags) SetBool(name string, enabled bool) error {
+	var value uint64
+	if enabled {
+		value = 1
+	}
+	return f.Set(name, value)
+}
+
+// GetBool - Returns true if the value of the provided flag isn't 0
+func (f *Flags) GetBool(name string) (bool, error) {
+	value, err := f.Get(name)
+	return value != 0, err
+}
+
+// SetInt - Sets the value of the provided flag to a signed integer, read as a __s64 by the eBPF programs
+func (f *Flags) SetInt(name string, value int64) error {
+	return f.Set(name, uint64(value))
+}
+
+// GetInt - Returns the value of the provided flag as a signed integer
+func (f *Flags) GetInt(name string) (int64, error) {
+	value, err := f.Get(name)
+	return int64(value), err
+}
+
+// List - Returns the values of all the flags, indexed by name
+func (f *Flags) List() (map[string]uint64, error) {
+	values := make(map[string]uint64, len(f.specs))
+	for _, spec := range f.specs {
+		value, err := f.Get(spec.Name)
+		if err != nil {
+			return nil, err
+		}
+		values[spec.Name] = value
+	}
+	return values, nil
+}
+
+// Names - Returns the names of the flags, sorted by index
+func (f *Flags) Names() []string {
+	names := make([]string, 0, len(f.specs))
+	for _, spec := range f.specs {
+		names = append(names, spec.Name)
+	}
+	return names
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestFlags(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	specs := []FlagSpec{{Name: "verbose"}, {Name: "sample_rate", Default: 100}, {Name: "threshold"}}
+	header, err := FlagsHeader(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"#define EBPFMANAGER_FLAG_SAMPLE_RATE 1\n", "#define EBPFMANAGER_FLAGS_COUNT 3\n", `SEC("maps/ebpfmanager_flags")`} {
+		if !strings.Contains(header, expected) {
+			t.Errorf("expected the header to contain %q:\n%s", expected, header)
+		}
+	}
+	if _, err = FlagsHeader([]FlagSpec{{Name: "a-b"}}); !errors.Is(err, ErrInvalidFlag) {
+		t.Errorf("expected ErrInvalidFlag, got %v", err)
+	}
+
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"filter": {
+			Name:         "filter",
+			Type:         ebpf.SocketFilter,
+			SectionName:  "socket/filter",
+			License:      "GPL",
+			Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 0), asm.Return()},
+		},
+	}}
+	m := &Manager{Probes: []*Probe{{Section: "socket/filter", EbpfFuncName: "filter"}}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{Flags: specs}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	flags, err := m.Flags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = flags.SetBool("verbose", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = flags.SetInt("threshold", -5); err != nil {
+		t.Fatal(err)
+	}
+	if threshold, err := flags.GetInt("threshold"); err != nil || threshold != -5 {
+		t.Errorf("expected -5, got %d (%v)", threshold, err)
+	}
+	values, err := flags.List()
+	if err != nil || values["verbose"] != 1 || values["sample_rate"] != 100 {
+		t.Errorf("unexpected flags %v (%v)", values, err)
+	}
+	// the programs read the flags by index
+	array, _, _ := m.GetMap(FlagsMapName)
+	var raw uint64
+	if err = array.Lookup(uint32(1), &raw); err != nil || raw != 100 {
+		t.Errorf("expected sample_rate at index 1, got %d (%v)", raw, err)
+	}
+	if _, err = flags.Get("unknown"); !errors.Is(err, ErrInvalidFlag) {
+		t.Errorf("expected ErrInvalidFlag, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EventGate - Map entry checked by the eBPF programs before they emit a sample, see Options.EventGate. For example,
+// with an array map "events_gate" holding a uint32 at key 0:
+//
+//	if (bpf_map_lookup_elem(&events_gate, &zero) && *gate == 0) return 0;
+//	bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+type EventGate struct {
+	// MapName - Name of the map holding the gate
+	MapName string
+
+	// Key - Key of the gate in the map
+	Key interface{}
+
+	// Open - Value written to the gate when the event streams are resumed
+	Open interface{}
+
+	// Closed - Value written to the gate when the event streams are paused
+	Closed interface{}
+}
+
+// set - Writes the provided value to the gate
+func (g *EventGate) set(m *Manager, value interface{}) error {
+	gateMap, ok := m.getMap(g.MapName)
+	if !ok {
+		return fmt.Errorf("error:%w , event gate %s", ErrUnknownMap, g.MapName)
+	}
+	if err := gateMap.Put(g.Key, value); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't update event gate %s", err, g.MapName))
+	}
+	return nil
+}
+
+// pausableStream - Perf map or ring buffer paused by PauseEventStreams
+type pausableStream interface {
+	Pause() error
+	Resume() error
+}
+
+// fenced - Runs the provided handler. While the event streams are paused, the handler waits until they are resumed.
+func (m *Manager) fenced(handler func()) {
+	if m == nil {
+		handler()
+		return
+	}
+	m.eventFence.RLock()
+	defer m.eventFence.RUnlock()
+	handler()
+}
+
+// PauseEventStreams - Pauses the readers of all the perf maps and ring buffers of the manager, and closes the event
+// gate of the programs if Options.EventGate is set, so that the kernel stops emitting samples. PauseEventStreams
+// returns once the handlers in progress are done: no DataHandler, EventHandler, BatchDataHandler or
+// OrderedDataHandler runs until ResumeEventStreams is called, which makes it safe to snapshot the state built by the
+// handlers. The samples read in the meantime wait for ResumeEventStreams, or are dropped by the event worker pool
+// when its queue is full. Must not be called from a handler.
+func (m *Manager) PauseEventStreams() error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < running {
+		return ErrManagerNotStarted
+	}
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+	if m.streamsPaused {
+		return nil
+	}
+
+	var err error
+	if m.options.EventGate != nil {
+		if err = m.options.EventGate.set(m, m.options.EventGate.Closed); err != nil {
+			return err
+		}
+	}
+	var streams []pausableStream
+	for _, perfMap := range m.PerfMaps {
+		streams = append(streams, perfMap)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		streams = append(streams, ringBuffer)
+	}
+	m.pausedStreams = m.pausedStreams[:0]
+	for _, stream := range streams {
+		// the streams that aren't running, or that were already paused, are left as is
+		if e := stream.Pause(); e == nil {
+			m.pausedStreams = append(m.pausedStreams, stream)
+		} else if !errors.Is(e, ErrMapNotRunning) {
+			err = ConcatErrors(err, e)
+		}
+	}
+
+	// wait for the handlers in progress
+	m.eventFence.Lock()
+	m.streamsPaused = true
+	return err
+}
+
+// ResumeEventStreams - Resumes the event streams paused by PauseEventStreams: the handlers run again, the readers are
+// resumed and the event gate of the programs is opened
+func (m *Manager) ResumeEventStreams() error {
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+	if !m.streamsPaused {
+		return nil
+	}
+	m.streamsPaused = false
+	m.eventFence.Unlock()
+
+	var err error
+	for _, stream := range m.pausedStreams {
+		err = ConcatErrors(err, stream.Resume())
+	}
+	m.pausedStreams = nil
+	if m.options.EventGate != nil {
+		err = ConcatErrors(err, m.options.EventGate.set(m, m.options.EventGate.Open))
+	}
+	return err
+}
+
+// EventStreamsPaused - Returns true if the event streams are paused, see PauseEventStreams
+func (m *Manager) EventStreamsPaused() bool {
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+	return m.streamsPaused
+}
+
+// releaseEventFence - Lets the handlers run again when the manager is stopped with paused event streams, so that the
+// readers can shut down
+func (m *Manager) releaseEventFence() {
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+	if m.streamsPaused {
+		m.streamsPaused = false
+		m.pausedStreams = nil
+		m.eventFence.Unlock()
+	}
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestPauseEventStreams(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	gate, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handled := make(chan []byte, 2)
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				started <- struct{}{}
+				<-release
+				handled <- data
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"gate": gate}},
+		options:    Options{EventGate: &EventGate{MapName: "gate", Key: uint32(0), Open: uint32(1), Closed: uint32(0)}},
+		PerfMaps:   []*PerfMap{perfMap},
+		state:      running,
+	}
+	if err = perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a handler is in progress when the streams are paused
+	go func() {
+		_ = perfMap.InjectSample(0, []byte("first"))
+	}()
+	<-started
+	pauseDone := make(chan error, 1)
+	go func() {
+		pauseDone <- m.PauseEventStreams()
+	}()
+	select {
+	case <-pauseDone:
+		t.Fatal("PauseEventStreams returned before the handler in progress was done")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err = <-pauseDone; err != nil {
+		t.Fatal(err)
+	}
+	<-handled
+	if !m.EventStreamsPaused() || perfMap.state != paused {
+		t.Error("expected the event streams to be paused")
+	}
+	var value uint32
+	if err = gate.Lookup(uint32(0), &value); err != nil || value != 0 {
+		t.Errorf("expected the event gate to be closed, got %d (%v)", value, err)
+	}
+
+	// a sample read before the readers were paused waits for ResumeEventStreams
+	go perfMap.deliver(0, []byte("second"))
+	select {
+	case <-handled:
+		t.Fatal("a handler ran while the event streams were paused")
+	case <-started:
+		t.Fatal("a handler ran while the event streams were paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = m.ResumeEventStreams(); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if data := <-handled; string(data) != "second" {
+		t.Errorf("unexpected sample %q", data)
+	}
+	if m.EventStreamsPaused() || perfMap.state != running {
+		t.Error("expected the event streams to be resumed")
+	}
+	if err = gate.Lookup(uint32(0), &value); err != nil || value != 1 {
+		t.Errorf("expected the event gate to be open, got %d (%v)", value, err)
+	}
+}
@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// isFreplaceSpec - Returns true if the program of the probe is a program extension (freplace)
+func (p *Probe) isFreplaceSpec() bool {
+	return p.programSpec != nil && p.programSpec.Type == ebpf.Extension
+}
+
+// freplaceFuncName - (freplace) Returns the function of the target program replaced by the extension
+func (p *Probe) freplaceFuncName() string {
+	if p.AttachToFuncName != "" {
+		return p.AttachToFuncName
+	}
+	return p.programSpec.AttachTo
+}
+
+// removeTargetedPrograms - Removes the program extensions and the tracing programs of other programs from the collection
+// spec: they can only be loaded once their target program is loaded, they are loaded by their probes when they are
+// initialized
+func (m *Manager) removeTargetedPrograms() {
+	for _, probe := range m.Probes {
+		if !probe.isFreplaceSpec() && !probe.isBPFTracingSpec() {
+			continue
+		}
+		name := probe.EbpfFuncName
+		if probe.CopyProgram {
+			name += probe.UID
+		}
+		delete(m.collectionSpec.Programs, name)
+	}
+}
+
+// resolveFreplaceTarget - (freplace) Resolves the target program of the extension, and prepares the spec of the
+// extension to be loaded against the function it replaces
+func (p *Probe) resolveFreplaceTarget() error {
+	funcName := p.freplaceFuncName()
+	if funcName == "" {
+		return fmt.Errorf("error:%w , no function to replace for probe %s", ErrFreplaceTarget, p.GetIdentificationPair())
+	}
+
+	var target *ebpf.Program
+	var err error
+	switch {
+	case p.FreplaceTarget != "":
+		var ok bool
+		if target, ok = p.manager.collection.Programs[p.FreplaceTarget]; !ok {
+			return fmt.Errorf("error:%w , couldn't find program %s for probe %s", ErrFreplaceTarget, p.FreplaceTarget, p.GetIdentificationPair())
+		}
+		// the program belongs to the collection, use a duplicate so that the probe can close it
+		if target, err = target.Clone(); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't duplicate program %s", err, p.FreplaceTarget))
+		}
+	case p.FreplaceTargetPinPath != "":
+		if target, err = ebpf.LoadPinnedProgram(p.FreplaceTargetPinPath, nil); err != nil {
+			return fmt.Errorf("error:%w , couldn't load the program pinned at %s: %v", ErrFreplaceTarget, p.FreplaceTargetPinPath, err)
+		}
+	case p.FreplaceTargetFD > 0:
+		// duplicate the file descriptor, it still belongs to the caller
+		fd, err := unix.FcntlInt(uintptr(p.FreplaceTargetFD), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("error:%w , couldn't duplicate file descriptor %d: %v", ErrFreplaceTarget, p.FreplaceTargetFD, err)
+		}
+		if target, err = ebpf.NewProgramFromFD(fd); err != nil {
+			return fmt.Errorf("error:%w , file descriptor %d: %v", ErrFreplaceTarget, p.FreplaceTargetFD, err)
+		}
+	default:
+		return fmt.Errorf("error:%w , FreplaceTarget, FreplaceTargetPinPath or FreplaceTargetFD must be set for probe %s", ErrFreplaceTarget, p.GetIdentificationPair())
+	}
+
+	return p.loadAgainst(target, funcName)
+}
+
+// loadAgainst - (freplace, fentry / fexit on BPF) Prepares the spec of the probe to be loaded against the provided
+// function of the target program, which is then owned by the probe
+func (p *Probe) loadAgainst(target *ebpf.Program, funcName string) error {
+	// the spec may be shared with other probes
+	spec := p.programSpec.Copy()
+	spec.AttachTarget = target
+	spec.AttachTo = funcName
+	for name, array := range p.manager.collection.Maps {
+		if err := spec.Instructions.AssociateMap(name, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+			_ = target.Close()
+			return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with the program of probe %v", err, name, p.GetIdentificationPair()))
+		}
+	}
+	p.programSpec = spec
+	p.targetProgram = target
+	p.manualLoadNeeded = true
+	return nil
+}
+
+// attachFreplace - (freplace) Replaces the function of the target program with the program of the probe
+func (p *Probe) attachFreplace() error {
+	// program extensions are attached with BPF trampolines
+	if err := HaveTrampolines(); err != nil {
+		return err
+	}
+	// the target is provided again so that the extension can be attached again after it was detached
+	l, err := link.AttachFreplace(p.targetProgram, p.freplaceFuncName(), p.program)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't replace function %s with probe %v", err, p.freplaceFuncName(), p.GetIdentificationPair()))
+	}
+	p.link = l
+	return nil
+}
+
+// closeTargetProgram - (freplace, fentry / fexit on BPF) Releases the target program of the probe
+func (p *Probe) closeTargetProgram() error {
+	if p.targetProgram == nil {
+		return nil
+	}
+	err := p.targetProgram.Close()
+	p.targetProgram = nil
+	return err
+}
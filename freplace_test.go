@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestFreplace(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// the XDP dispatcher passes the packets until one of its slots is replaced
+	dispatcher, err := ebpf.NewProgram(newXDPDispatcherSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{collection: &ebpf.Collection{
+		Programs: map[string]*ebpf.Program{xdpDispatcherName: dispatcher},
+		Maps:     map[string]*ebpf.Map{},
+	}}
+	defer m.collection.Close()
+
+	fn := &btf.Func{Name: "drop", Type: xdpFuncProto(), Linkage: btf.GlobalFunc}
+	p := &Probe{
+		Section:      "freplace/" + xdpDispatcherSlot(0),
+		EbpfFuncName: "drop",
+		Enabled:      true,
+		programSpec: &ebpf.ProgramSpec{
+			Name:     "drop",
+			Type:     ebpf.Extension,
+			AttachTo: xdpDispatcherSlot(0),
+			License:  "GPL",
+			Instructions: asm.Instructions{
+				btf.WithFuncMetadata(asm.Mov.Imm(asm.R0, 1), fn).WithSymbol("drop"),
+				asm.Return(),
+			},
+		},
+	}
+	if err = p.Init(m); !errors.Is(err, ErrFreplaceTarget) {
+		t.Errorf("expected ErrFreplaceTarget, got %v", err)
+	}
+
+	if err = HaveTrampolines(); err != nil {
+		t.Skip(err)
+	}
+	p.FreplaceTarget = xdpDispatcherName
+	if err = p.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	defer p.program.Close()
+	run := func() uint32 {
+		ret, _, err := dispatcher.Test(make([]byte, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := run(); ret != 1 {
+		t.Errorf("expected the replaced slot to drop the packet, got %d", ret)
+	}
+
+	// the extension can be attached again once detached
+	if err = p.detach(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := run(); ret != xdpPass {
+		t.Errorf("expected the dispatcher to pass once the extension is detached, got %d", ret)
+	}
+	if err = p.attachFreplace(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := run(); ret != 1 {
+		t.Errorf("expected the extension to be attached again, got %d", ret)
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if p.targetProgram != nil {
+		t.Error("expected the target program to be released")
+	}
+}
@@ -0,0 +1,439 @@
+package manager
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cilium/ebpf/link"
+)
+
+// GoReturnSite - (uprobes) Return instruction of a Go function, and result of the attachment of the probe to it, see
+// Probe.UprobeGoReturns and Probe.GetGoReturnSites
+type GoReturnSite struct {
+	// Address - Virtual address of the return instruction
+	Address uint64
+
+	// Offset - File offset of the return instruction in the binary
+	Offset uint64
+
+	// Err - Error returned when the probe was attached to the return instruction, if any
+	Err error
+}
+
+// goReturnSite - Uprobes attached to a return instruction of a Go function, one per process the probe is scoped to
+type goReturnSite struct {
+	GoReturnSite
+	links []link.Link
+}
+
+// FindGoReturnSites - Returns the return instructions of the provided function of the binary at the provided path.
+// The function is looked up in the Go symbol table of the binary (.gopclntab), which survives stripping, then in its
+// ELF symbol table, and its code is disassembled to find the RET instructions. Only x86_64 and arm64 binaries are
+// supported.
+func FindGoReturnSites(binaryPath string, symbol string) ([]GoReturnSite, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't open %s", err, binaryPath))
+	}
+	defer f.Close()
+
+	entry, end, err := goFunctionBounds(f, symbol)
+	if err != nil {
+		return nil, err
+	}
+	code, err := readCode(f, entry, end)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't read the code of %s", err, symbol))
+	}
+	offsets, err := returnInstructions(f.Machine, code)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't disassemble %s", err, symbol))
+	}
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("error:%w , function %s", ErrNoGoReturnSite, symbol)
+	}
+	sites := make([]GoReturnSite, 0, len(offsets))
+	for _, offset := range offsets {
+		site := GoReturnSite{Address: entry + offset}
+		if site.Offset, err = virtualAddressToOffset(f, site.Address); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// goFunctionBounds - Returns the entry address and the end address of the provided function, looked up in the Go
+// symbol table of the provided ELF file, then in its ELF symbol table
+func goFunctionBounds(f *elf.File, symbol string) (uint64, uint64, error) {
+	if pclntab, text := f.Section(".gopclntab"), f.Section(".text"); pclntab != nil && text != nil {
+		if data, err := pclntab.Data(); err == nil {
+			if table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr)); err == nil {
+				if fn := table.LookupFunc(symbol); fn != nil {
+					return fn.Entry, fn.End, nil
+				}
+			}
+		}
+	}
+	if syms, err := f.Symbols(); err == nil {
+		for _, sym := range syms {
+			if sym.Name == symbol && elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 && sym.Size > 0 {
+				return sym.Value, sym.Value + sym.Size, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("error:%w , symbol %s", ErrUprobeSymbolNotFound, symbol)
+}
+
+// readCode - Returns the code of the provided ELF file between the provided virtual addresses
+func readCode(f *elf.File, start, end uint64) ([]byte, error) {
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_PROGBITS || section.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		if start >= section.Addr && end <= section.Addr+section.Size {
+			code := make([]byte, end-start)
+			if _, err := section.ReadAt(code, int64(start-section.Addr)); err != nil {
+				return nil, err
+			}
+			return code, nil
+		}
+	}
+	return nil, fmt.Errorf("0x%x-0x%x isn't in an executable section", start, end)
+}
+
+// returnInstructions - Returns the offsets of the return instructions of the provided code
+func returnInstructions(machine elf.Machine, code []byte) ([]uint64, error) {
+	var offsets []uint64
+	switch machine {
+	case elf.EM_X86_64:
+		for offset := 0; offset < len(code); {
+			length, err := x86InstructionLength(code[offset:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at offset 0x%x", err, offset)
+			}
+			// RET and RET imm16
+			if length == 1 && code[offset] == 0xc3 || length == 3 && code[offset] == 0xc2 {
+				offsets = append(offsets, uint64(offset))
+			}
+			offset += length
+		}
+	case elf.EM_AARCH64:
+		for offset := 0; offset+4 <= len(code); offset += 4 {
+			// RET Xn
+			if binary.LittleEndian.Uint32(code[offset:])&0xfffffc1f == 0xd65f0000 {
+				offsets = append(offsets, uint64(offset))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported machine %s", machine)
+	}
+	return offsets, nil
+}
+
+// x86InstructionLength - Returns the length of the x86_64 instruction at the start of the provided code. Only the
+// prefixes, the opcode, the ModRM, SIB, displacement and immediate bytes are decoded, the operands aren't.
+func x86InstructionLength(code []byte) (int, error) {
+	i := 0
+	var opSize16, addrSize32, rexW bool
+prefixes:
+	for ; i < len(code); i++ {
+		switch code[i] {
+		case 0xf0, 0xf2, 0xf3, 0x2e, 0x36, 0x3e, 0x26, 0x64, 0x65:
+		case 0x66:
+			opSize16 = true
+		case 0x67:
+			addrSize32 = true
+		default:
+			break prefixes
+		}
+	}
+	if i < len(code) && code[i]&0xf0 == 0x40 {
+		rexW = code[i]&0x08 != 0
+		i++
+	}
+	if i >= len(code) {
+		return 0, errors.New("truncated instruction")
+	}
+
+	modRM := func() {
+		if i >= len(code) {
+			i++
+			return
+		}
+		mod, rm := code[i]>>6, code[i]&7
+		i++
+		if mod == 3 {
+			return
+		}
+		if rm == 4 {
+			if i < len(code) && mod == 0 && code[i]&7 == 5 {
+				i += 4
+			}
+			i++
+		} else if mod == 0 && rm == 5 {
+			// RIP relative
+			i += 4
+		}
+		if mod == 1 {
+			i++
+		} else if mod == 2 {
+			i += 4
+		}
+	}
+	immZ := 4
+	if opSize16 && !rexW {
+		immZ = 2
+	}
+	// (VEX & EVEX) the instructions of the 0F3A map and a few of the 0F map take an imm8
+	vex := func(opcodeMap byte, payload int) (int, error) {
+		i += payload
+		if i >= len(code) {
+			return 0, errors.New("truncated instruction")
+		}
+		opcode := code[i]
+		i++
+		// VZEROUPPER and VZEROALL have no operand
+		if opcodeMap != 1 || opcode != 0x77 {
+			modRM()
+		}
+		switch {
+		case opcodeMap == 3:
+			i++
+		case opcodeMap == 1 && (opcode >= 0x70 && opcode <= 0x73 || opcode == 0xc2 || opcode >= 0xc4 && opcode <= 0xc6):
+			i++
+		}
+		if i > len(code) {
+			return 0, errors.New("truncated instruction")
+		}
+		return i, nil
+	}
+
+	op := code[i]
+	i++
+	imm := 0
+	switch {
+	case op == 0xc5:
+		return vex(1, 1)
+	case op == 0xc4 && i < len(code):
+		return vex(code[i]&0x1f, 2)
+	case op == 0x62 && i < len(code):
+		return vex(code[i]&0x07, 3)
+	case op == 0x0f:
+		if i >= len(code) {
+			return 0, errors.New("truncated instruction")
+		}
+		op2 := code[i]
+		i++
+		switch {
+		case op2 == 0x38:
+			i++
+			modRM()
+		case op2 == 0x3a:
+			i++
+			modRM()
+			imm = 1
+		case op2 == 0x05 || op2 == 0x06 || op2 == 0x07 || op2 == 0x08 || op2 == 0x09 || op2 == 0x0b || op2 == 0x0e ||
+			op2 >= 0x30 && op2 <= 0x37 || op2 == 0x77 || op2 == 0xa0 || op2 == 0xa1 || op2 == 0xa2 || op2 == 0xa8 ||
+			op2 == 0xa9 || op2 == 0xaa || op2 >= 0xc8 && op2 <= 0xcf:
+		case op2 >= 0x80 && op2 <= 0x8f:
+			imm = 4
+		case op2 >= 0x70 && op2 <= 0x73 || op2 == 0xa4 || op2 == 0xac || op2 == 0xba || op2 == 0xc2 ||
+			op2 >= 0xc4 && op2 <= 0xc6:
+			modRM()
+			imm = 1
+		case op2 <= 0x03 || op2 == 0x0d || op2 >= 0x10 && op2 <= 0x23 || op2 >= 0x28 && op2 <= 0x2f ||
+			op2 >= 0x40 && op2 <= 0x7f || op2 >= 0x90 && op2 <= 0x9f || op2 >= 0xa3:
+			modRM()
+		default:
+			return 0, fmt.Errorf("unknown opcode 0x0f 0x%02x", op2)
+		}
+	case op < 0x40 && op&7 < 4:
+		modRM()
+	case op < 0x40 && op&7 == 4:
+		imm = 1
+	case op < 0x40 && op&7 == 5:
+		imm = immZ
+	case op >= 0x50 && op <= 0x5f, op >= 0x6c && op <= 0x6f, op >= 0x90 && op <= 0x99, op >= 0x9b && op <= 0x9f,
+		op >= 0xa4 && op <= 0xa7, op >= 0xaa && op <= 0xaf, op == 0xc3, op == 0xc9, op == 0xcb, op == 0xcc,
+		op == 0xcf, op == 0xd7, op >= 0xec && op <= 0xef, op == 0xf1, op == 0xf4, op == 0xf5, op >= 0xf8 && op <= 0xfd:
+	case op == 0x63, op >= 0x84 && op <= 0x8f, op >= 0xd0 && op <= 0xd3, op >= 0xd8 && op <= 0xdf, op == 0xfe,
+		op == 0xff:
+		modRM()
+	case op == 0x68:
+		imm = immZ
+	case op == 0x69, op == 0x81, op == 0xc7:
+		modRM()
+		imm = immZ
+	case op == 0x6b, op == 0x80, op == 0x83, op == 0xc0, op == 0xc1, op == 0xc6:
+		modRM()
+		imm = 1
+	case op == 0x6a, op >= 0x70 && op <= 0x7f, op == 0xa8, op >= 0xb0 && op <= 0xb7, op == 0xcd,
+		op >= 0xe0 && op <= 0xe7, op == 0xeb:
+		imm = 1
+	case op >= 0xa0 && op <= 0xa3:
+		// moffs
+		imm = 8
+		if addrSize32 {
+			imm = 4
+		}
+	case op == 0xa9:
+		imm = immZ
+	case op >= 0xb8 && op <= 0xbf:
+		imm = immZ
+		if rexW {
+			imm = 8
+		}
+	case op == 0xc2, op == 0xca:
+		imm = 2
+	case op == 0xc8:
+		imm = 3
+	case op == 0xe8, op == 0xe9:
+		imm = 4
+	case op == 0xf6, op == 0xf7:
+		// TEST r/m, imm is the only form of the group with an immediate
+		if i < len(code) && code[i]>>3&7 < 2 {
+			imm = 1
+			if op == 0xf7 {
+				imm = immZ
+			}
+		}
+		modRM()
+	default:
+		return 0, fmt.Errorf("unknown opcode 0x%02x", op)
+	}
+	i += imm
+	if i > len(code) {
+		return 0, errors.New("truncated instruction")
+	}
+	return i, nil
+}
+
+// attachGoReturns - Attaches the program of the probe at each return instruction of its Go function, all or at least
+// one of them. The program is attached for each process of AttachPIDs and AttachPID, or system wide if there is none.
+func (p *Probe) attachGoReturns(binaryPath string) error {
+	sites, err := FindGoReturnSites(binaryPath, p.funcName)
+	if err != nil {
+		return err
+	}
+	pids := p.targetPIDs()
+	if len(pids) == 0 {
+		// system wide
+		pids = []int{0}
+	}
+	p.goReturnSites = make([]goReturnSite, 0, len(sites))
+	if p.usesUprobeMulti() {
+		return p.attachGoReturnsMulti(binaryPath, sites, pids)
+	}
+	var attached bool
+	for _, site := range sites {
+		returnSite := goReturnSite{GoReturnSite: site}
+		returnSite.links, returnSite.Err = p.openUprobeAtPIDs(binaryPath, site.Offset, pids)
+		attached = attached || returnSite.Err == nil
+		p.goReturnSites = append(p.goReturnSites, returnSite)
+	}
+	if !attached {
+		firstErr := p.goReturnSites[0].Err
+		_ = p.detachGoReturns()
+		return fmt.Errorf("error:%w , function %s: %v", ErrNoGoReturnSite, p.funcName, firstErr)
+	}
+	return nil
+}
+
+// attachGoReturnsMulti - Attaches the program of the probe at all the return instructions of its Go function with a
+// single uprobe_multi link per process. The links can't be created if one of the return instructions can't be probed.
+func (p *Probe) attachGoReturnsMulti(binaryPath string, sites []GoReturnSite, pids []int) error {
+	offsets := make([]uint64, 0, len(sites))
+	for _, site := range sites {
+		offsets = append(offsets, site.Offset)
+	}
+	for _, pid := range pids {
+		l, err := p.openUprobeMulti(binaryPath, offsets, pid)
+		if err != nil {
+			if pid > 0 {
+				err = fmt.Errorf("%w , pid %d", err, pid)
+			}
+			_ = p.detachGoReturns()
+			return fmt.Errorf("error:%w , function %s: %v", ErrNoGoReturnSite, p.funcName, err)
+		}
+		p.uprobeMultiLinks = append(p.uprobeMultiLinks, l)
+	}
+	for _, site := range sites {
+		// the links are shared, they are closed with the probe
+		p.goReturnSites = append(p.goReturnSites, goReturnSite{GoReturnSite: site})
+	}
+	return nil
+}
+
+// openUprobeAtPIDs - Attaches the program of the probe at the provided file offset of the binary at the provided path
+// for each of the provided processes, all or none of them
+func (p *Probe) openUprobeAtPIDs(binaryPath string, offset uint64, pids []int) ([]link.Link, error) {
+	links := make([]link.Link, 0, len(pids))
+	for _, pid := range pids {
+		l, err := p.openUprobeAt(binaryPath, offset, pid)
+		if err != nil {
+			for _, opened := range links {
+				_ = opened.Close()
+			}
+			if pid > 0 {
+				err = fmt.Errorf("%w , pid %d", err, pid)
+			}
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// openUprobeAt - Attaches the program of the probe at the provided file offset of the binary at the provided path, for
+// the provided process only if pid isn't 0
+func (p *Probe) openUprobeAt(binaryPath string, offset uint64, pid int) (link.Link, error) {
+	ex, err := link.OpenExecutable(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.checkCookie(); err != nil {
+		return nil, err
+	}
+	return ex.Uprobe(p.funcName, p.program, &link.UprobeOptions{
+		RealFilePath: p.RealFilePath,
+		Address:      offset,
+		Offset:       p.NonElfOffset,
+		PID:          pid,
+		Cookie:       p.Cookie,
+	})
+}
+
+// detachGoReturns - Detaches the program of the probe from the return instructions of its Go function
+func (p *Probe) detachGoReturns() error {
+	var err error
+	for _, site := range p.goReturnSites {
+		for _, l := range site.links {
+			err = ConcatErrors(err, l.Close())
+		}
+	}
+	for _, l := range p.uprobeMultiLinks {
+		err = ConcatErrors(err, l.Close())
+	}
+	p.goReturnSites, p.uprobeMultiLinks = nil, nil
+	return err
+}
+
+// GetGoReturnSites - (uprobes) Returns the return instructions of the function of the probe and the result of the
+// attachment of the probe to each of them, sorted by address, see UprobeGoReturns
+func (p *Probe) GetGoReturnSites() []GoReturnSite {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	sites := make([]GoReturnSite, 0, len(p.goReturnSites))
+	for _, site := range p.goReturnSites {
+		sites = append(sites, site.GoReturnSite)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Address < sites[j].Address
+	})
+	return sites
+}
@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestX86InstructionLength(t *testing.T) {
+	for _, test := range []struct {
+		code   []byte
+		length int
+	}{
+		{[]byte{0xc3}, 1},                                           // RET
+		{[]byte{0x48, 0x8b, 0x5c, 0x24, 0x10}, 5},                   // MOVQ 0x10(SP), BX
+		{[]byte{0x48, 0xb8, 1, 2, 3, 4, 5, 6, 7, 8}, 10},            // MOVQ $imm64, AX
+		{[]byte{0x66, 0x0f, 0x6f, 0x05, 0x2f, 0xee, 0x3e, 0x00}, 8}, // MOVDQA rip+disp32, X0
+		{[]byte{0x49, 0x3b, 0x66, 0x10}, 4},                         // CMPQ SP, 0x10(R14)
+		{[]byte{0x0f, 0x86, 0x5c, 0x01, 0x00, 0x00}, 6},             // JBE rel32
+		{[]byte{0xf7, 0x04, 0x25, 0, 0, 0, 0, 1, 0, 0, 0}, 11},      // TESTL $1, abs32
+		{[]byte{0x66, 0xc7, 0x40, 0x08, 0x01, 0x00}, 6},             // MOVW $1, 8(AX)
+		{[]byte{0xc5, 0xf8, 0x77}, 3},                               // VZEROUPPER
+		{[]byte{0xc4, 0xe3, 0x7d, 0x39, 0xc1, 0x01}, 6},             // VEXTRACTI128 $1, Y0, X1
+		{[]byte{0x62, 0xf1, 0x7c, 0x48, 0x10, 0x44, 0x24, 0x01}, 8}, // VMOVUPS 0x40(SP), Z0
+		{[]byte{0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00}, 8}, // NOPL 0(AX)(AX*1)
+	} {
+		length, err := x86InstructionLength(test.code)
+		if err != nil || length != test.length {
+			t.Errorf("% x: expected %d bytes, got %d (%v)", test.code, test.length, length, err)
+		}
+	}
+	if _, err := x86InstructionLength([]byte{0x48, 0xb8, 1, 2}); err == nil {
+		t.Error("expected a truncated instruction error")
+	}
+	if _, err := x86InstructionLength([]byte{0x06}); err == nil {
+		t.Error("expected an unknown opcode error")
+	}
+}
+
+func TestUprobeGoReturns(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const symbol = "github.com/gojue/ebpfmanager.uprobePIDTarget"
+	sites, err := FindGoReturnSites(executable, symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := os.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, site := range sites {
+		if runtime.GOARCH == "amd64" && binary[site.Offset] != 0xc3 {
+			t.Errorf("expected a RET at 0x%x, got 0x%02x", site.Address, binary[site.Offset])
+		}
+	}
+	if _, err = FindGoReturnSites(executable, "github.com/gojue/ebpfmanager.missing"); !errors.Is(err, ErrUprobeSymbolNotFound) {
+		t.Errorf("expected ErrUprobeSymbolNotFound, got %v", err)
+	}
+
+	for name, attachType := range map[string]ebpf.AttachType{
+		"uprobe":       ebpf.AttachNone,
+		"uprobe_multi": attachTraceUprobeMulti,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if attachType == attachTraceUprobeMulti {
+				if err := HaveUprobeMulti(); err != nil {
+					t.Skip(err)
+				}
+			}
+			calls, prog := newCallCounterWithAttachType(t, attachType)
+			defer calls.Close()
+			defer prog.Close()
+			p := &Probe{
+				manager:          &Manager{},
+				program:          prog,
+				programSpec:      &ebpf.ProgramSpec{Type: ebpf.Kprobe, AttachType: attachType},
+				Section:          "uretprobe/uprobePIDTarget",
+				AttachToFuncName: symbol,
+				BinaryPath:       executable,
+				UprobeGoReturns:  true,
+			}
+			if err := p.attachUprobe(); err != nil {
+				t.Skipf("uprobes not supported: %v", err)
+			}
+			defer p.detachGoReturns()
+			if attached := p.GetGoReturnSites(); len(attached) != len(sites) || attached[0].Err != nil {
+				t.Errorf("unexpected return sites %+v", attached)
+			}
+			if multi := len(p.uprobeMultiLinks) == 1; multi != (attachType == attachTraceUprobeMulti) {
+				t.Errorf("unexpected uprobe_multi links %v", p.uprobeMultiLinks)
+			}
+
+			uprobePIDTarget()
+			uprobePIDTarget()
+			var count uint64
+			if err := calls.Lookup(uint32(0), &count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 {
+				t.Errorf("expected the program to run once per return, got %d", count)
+			}
+		})
+	}
+}
+
+func TestUprobeGoReturnsAttachPIDs(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process
+	cmd := exec.Command("sleep", "10")
+	if err = cmd.Start(); err != nil {
+		t.Skipf("couldn't start sleep: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	for name, attachType := range map[string]ebpf.AttachType{
+		"uprobe":       ebpf.AttachNone,
+		"uprobe_multi": attachTraceUprobeMulti,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if attachType == attachTraceUprobeMulti {
+				if err := HaveUprobeMulti(); err != nil {
+					t.Skip(err)
+				}
+			}
+			for _, test := range []struct {
+				pids  []int
+				count uint64
+			}{
+				{[]int{cmd.Process.Pid}, 0},
+				{[]int{os.Getpid(), cmd.Process.Pid}, 2},
+			} {
+				calls, prog := newCallCounterWithAttachType(t, attachType)
+				p := &Probe{
+					manager:          &Manager{},
+					program:          prog,
+					programSpec:      &ebpf.ProgramSpec{Type: ebpf.Kprobe, AttachType: attachType},
+					Section:          "uretprobe/uprobePIDTarget",
+					AttachToFuncName: "github.com/gojue/ebpfmanager.uprobePIDTarget",
+					BinaryPath:       executable,
+					AttachPIDs:       test.pids,
+					UprobeGoReturns:  true,
+				}
+				if err := p.attachUprobe(); err != nil {
+					calls.Close()
+					prog.Close()
+					t.Skipf("uprobes not supported: %v", err)
+				}
+				if multi := len(p.uprobeMultiLinks); attachType == attachTraceUprobeMulti && multi != len(test.pids) {
+					t.Errorf("expected one uprobe_multi link per process, got %d", multi)
+				}
+
+				uprobePIDTarget()
+				uprobePIDTarget()
+				var count uint64
+				if err := calls.Lookup(uint32(0), &count); err != nil {
+					t.Error(err)
+				}
+				if count != test.count {
+					t.Errorf("pids %v: expected the program to run %d times, got %d", test.pids, test.count, count)
+				}
+				_ = p.detachGoReturns()
+				calls.Close()
+				prog.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SlowHandlerWarning - Reported to Options.SlowHandlerCallback when a handler of a perf map or of a ring buffer ran for
+// longer than Options.SlowHandlerThreshold
+type SlowHandlerWarning struct {
+	// Map - Name of the perf map or of the ring buffer
+	Map string
+
+	// Kind - "perf_map" or "ring_buffer"
+	Kind string
+
+	// CPU - CPU of the sample(s) handed over to the handler, -1 for the ring buffers
+	CPU int
+
+	// Samples - Number of samples handed over to the handler, more than 1 for a BatchDataHandler
+	Samples int
+
+	// Duration - Run time of the handler
+	Duration time.Duration
+
+	// Threshold - Options.SlowHandlerThreshold
+	Threshold time.Duration
+}
+
+func (w SlowHandlerWarning) String() string {
+	return fmt.Sprintf("slow handler on %s %s (CPU %d, %d sample(s)): took %s, threshold %s", w.Kind, w.Map, w.CPU, w.Samples, w.Duration, w.Threshold)
+}
+
+// runHandler - Runs the provided handler of a perf map or of a ring buffer, accounts its run time in counters and reports
+// it to Options.SlowHandlerCallback if it exceeds Options.SlowHandlerThreshold. With Options.HandlerProfileLabels, the
+// handler runs with the pprof labels "ebpf_map", "ebpf_kind" and "ebpf_cpu".
+func (m *Manager) runHandler(counters *eventCounters, name string, kind string, CPU int, samples int, handler func()) {
+	start := time.Now()
+	if m != nil && m.options.HandlerProfileLabels {
+		labels := pprof.Labels("ebpf_map", name, "ebpf_kind", kind, "ebpf_cpu", strconv.Itoa(CPU))
+		pprof.Do(context.Background(), labels, func(context.Context) {
+			handler()
+		})
+	} else {
+		handler()
+	}
+	latency := time.Since(start)
+	counters.handle(samples, latency)
+
+	if m == nil || m.options.SlowHandlerThreshold <= 0 || latency < m.options.SlowHandlerThreshold {
+		return
+	}
+	atomic.AddUint64(&counters.slowHandlers, 1)
+	if m.options.SlowHandlerCallback != nil {
+		m.options.SlowHandlerCallback(SlowHandlerWarning{
+			Map:       name,
+			Kind:      kind,
+			CPU:       CPU,
+			Samples:   samples,
+			Duration:  latency,
+			Threshold: m.options.SlowHandlerThreshold,
+		})
+	}
+}
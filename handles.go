@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultHandleReleaseTimeout - Default maximum time Stop waits for the outstanding handles to be released, see
+// Options.HandleReleaseTimeout
+const DefaultHandleReleaseTimeout = time.Second
+
+// handle - Map or program handle tracked by the manager
+type handle interface {
+	invalidate() error
+}
+
+// handleRegistry - Outstanding map and program handles of a manager
+type handleRegistry struct {
+	lock     sync.Mutex
+	handles  map[handle]struct{}
+	released chan struct{}
+	closed   bool
+}
+
+// open - Accepts new handles, once the manager is initialized
+func (r *handleRegistry) open() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = false
+}
+
+// register - Tracks the provided handle, returns false if the manager is stopping
+func (r *handleRegistry) register(h handle) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return false
+	}
+	if r.handles == nil {
+		r.handles = make(map[handle]struct{})
+	}
+	r.handles[h] = struct{}{}
+	return true
+}
+
+// unregister - Stops tracking the provided handle, returns false if it was already released or invalidated
+func (r *handleRegistry) unregister(h handle) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.handles[h]; !ok {
+		return false
+	}
+	delete(r.handles, h)
+	if len(r.handles) == 0 && r.released != nil {
+		close(r.released)
+		r.released = nil
+	}
+	return true
+}
+
+// count - Returns the number of outstanding handles
+func (r *handleRegistry) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.handles)
+}
+
+// releaseAll - Waits at most timeout for the outstanding handles to be released, then invalidates the remaining ones
+func (r *handleRegistry) releaseAll(timeout time.Duration) error {
+	r.lock.Lock()
+	r.closed = true
+	if len(r.handles) > 0 && timeout > 0 {
+		if r.released == nil {
+			r.released = make(chan struct{})
+		}
+		released := r.released
+		r.lock.Unlock()
+		timer := time.NewTimer(timeout)
+		select {
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+		r.lock.Lock()
+	}
+	remaining := r.handles
+	r.handles = nil
+	if r.released != nil {
+		close(r.released)
+		r.released = nil
+	}
+	r.lock.Unlock()
+
+	if len(remaining) == 0 {
+		return nil
+	}
+	var err error
+	for h := range remaining {
+		err = ConcatErrors(err, h.invalidate())
+	}
+	return ConcatErrors(fmt.Errorf("error:%w , %d handles weren't released", ErrHandleInvalidated, len(remaining)), err)
+}
+
+// MapHandle - Reference to a map of the manager that remains valid until it is released, see Manager.AcquireMap
+type MapHandle struct {
+	name     string
+	array    *ebpf.Map
+	registry *handleRegistry
+}
+
+// Name - Returns the name of the map
+func (h *MapHandle) Name() string {
+	return h.name
+}
+
+// Map - Returns the map. It remains usable until Release is called, even if the manager closes its own copy of the
+// map in the meantime. Once the handle was invalidated by Stop, the operations on the map return an error.
+func (h *MapHandle) Map() *ebpf.Map {
+	return h.array
+}
+
+// Release - Releases the handle, the map must not be used afterwards
+func (h *MapHandle) Release() error {
+	if !h.registry.unregister(h) {
+		return nil
+	}
+	return h.array.Close()
+}
+
+// invalidate - Closes the map of a handle that wasn't released when the manager stopped
+func (h *MapHandle) invalidate() error {
+	return h.array.Close()
+}
+
+// ProgramHandle - Reference to a program of the manager that remains valid until it is released, see
+// Manager.AcquireProgram
+type ProgramHandle struct {
+	id       ProbeIdentificationPair
+	program  *ebpf.Program
+	registry *handleRegistry
+}
+
+// ProbeIdentificationPair - Returns the identification pair the program was acquired with
+func (h *ProgramHandle) ProbeIdentificationPair() ProbeIdentificationPair {
+	return h.id
+}
+
+// Program - Returns the program. It remains usable until Release is called, even if the manager closes its own copy
+// of the program in the meantime. Once the handle was invalidated by Stop, the operations on the program return an
+// error.
+func (h *ProgramHandle) Program() *ebpf.Program {
+	return h.program
+}
+
+// Release - Releases the handle, the program must not be used afterwards
+func (h *ProgramHandle) Release() error {
+	if !h.registry.unregister(h) {
+		return nil
+	}
+	return h.program.Close()
+}
+
+// invalidate - Closes the program of a handle that wasn't released when the manager stopped
+func (h *ProgramHandle) invalidate() error {
+	return h.program.Close()
+}
+
+// AcquireMap - Returns a handle on the requested map. Unlike the map returned by GetMap, the map of the handle holds
+// its own file descriptor: it can safely be used from other goroutines while the manager is stopped. Stop waits for
+// the outstanding handles to be released (see Options.HandleReleaseTimeout). Release the handle when done.
+// name: name of the map, as defined by its section SEC("maps/[name]")
+func (m *Manager) AcquireMap(name string) (*MapHandle, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	eBPFMap, ok := m.getMap(name)
+	if !ok || eBPFMap == nil {
+		return nil, fmt.Errorf("error:%w , map %s", ErrUnknownMap, name)
+	}
+	clone, err := eBPFMap.Clone()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't acquire map %s", err, name))
+	}
+	h := &MapHandle{name: name, array: clone, registry: &m.handles}
+	if !m.handles.register(h) {
+		_ = clone.Close()
+		return nil, ErrManagerNotInitialized
+	}
+	return h, nil
+}
+
+// AcquireProgram - Returns a handle on the program of the requested probe, see AcquireMap. When the UID of id is
+// empty, the program of the first probe with the provided function name is returned.
+func (m *Manager) AcquireProgram(id ProbeIdentificationPair) (*ProgramHandle, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	var prog *ebpf.Program
+	for _, probe := range m.Probes {
+		if (id.UID == "" && probe.EbpfFuncName == id.EbpfFuncName) || (id.UID != "" && probe.IdentificationPairMatches(id)) {
+			prog = probe.program
+			break
+		}
+	}
+	if prog == nil && id.UID == "" {
+		prog = m.collection.Programs[id.EbpfFuncName]
+	}
+	if prog == nil {
+		return nil, fmt.Errorf("error:%w , program %v", ErrUnknownMatchFuncName, id)
+	}
+	clone, err := prog.Clone()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't acquire program %v", err, id))
+	}
+	h := &ProgramHandle{id: id, program: clone, registry: &m.handles}
+	if !m.handles.register(h) {
+		_ = clone.Close()
+		return nil, ErrManagerNotInitialized
+	}
+	return h, nil
+}
+
+// OutstandingHandles - Returns the number of map and program handles that weren't released yet
+func (m *Manager) OutstandingHandles() int {
+	return m.handles.count()
+}
+
+// releaseHandles - Waits for the outstanding handles to be released, then invalidates the remaining ones
+func (m *Manager) releaseHandles() error {
+	timeout := m.options.HandleReleaseTimeout
+	if timeout == 0 {
+		timeout = DefaultHandleReleaseTimeout
+	}
+	return m.handles.releaseAll(timeout)
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func initHandlesManager(t *testing.T, options Options) *Manager {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite"}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	if err = m.InitWithOptions(elf, options); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStopWaitsForHandles(t *testing.T) {
+	m := initHandlesManager(t, Options{HandleReleaseTimeout: 10 * time.Second})
+
+	mapHandle, err := m.AcquireMap("map_val")
+	if err != nil {
+		t.Fatal(err)
+	}
+	progHandle, err := m.AcquireProgram(ProbeIdentificationPair{EbpfFuncName: "rewrite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.AcquireMap("unknown"); !errors.Is(err, ErrUnknownMap) {
+		t.Errorf("expected ErrUnknownMap for an unknown map, got %v", err)
+	}
+	if _, err = m.AcquireProgram(ProbeIdentificationPair{EbpfFuncName: "unknown"}); !errors.Is(err, ErrUnknownMatchFuncName) {
+		t.Errorf("expected ErrUnknownMatchFuncName for an unknown program, got %v", err)
+	}
+	if n := m.OutstandingHandles(); n != 2 {
+		t.Fatalf("expected 2 outstanding handles, got %d", n)
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- m.Stop(CleanAll)
+	}()
+	select {
+	case err = <-stopped:
+		t.Fatalf("Stop returned before the handles were released: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the handles remain usable while Stop waits for them
+	if _, err = mapHandle.Map().Info(); err != nil {
+		t.Errorf("couldn't use the map of the handle: %v", err)
+	}
+	if _, err = progHandle.Program().Info(); err != nil {
+		t.Errorf("couldn't use the program of the handle: %v", err)
+	}
+	if err = mapHandle.Release(); err != nil {
+		t.Error(err)
+	}
+	if err = progHandle.Release(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err = <-stopped:
+		if err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop didn't return once the handles were released")
+	}
+	if _, err = m.AcquireMap("map_val"); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized after Stop, got %v", err)
+	}
+}
+
+func TestStopInvalidatesHandles(t *testing.T) {
+	m := initHandlesManager(t, Options{HandleReleaseTimeout: 100 * time.Millisecond})
+
+	mapHandle, err := m.AcquireMap("map_val")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Stop(CleanAll); !errors.Is(err, ErrHandleInvalidated) {
+		t.Errorf("expected ErrHandleInvalidated, got %v", err)
+	}
+	if _, err = mapHandle.Map().Info(); err == nil {
+		t.Error("expected the map of an invalidated handle to be closed")
+	}
+	if err = mapHandle.Release(); err != nil {
+		t.Errorf("releasing an invalidated handle failed: %v", err)
+	}
+	if n := m.OutstandingHandles(); n != 0 {
+		t.Errorf("expected no outstanding handles, got %d", n)
+	}
+}
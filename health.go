@@ -0,0 +1,273 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/florianl/go-tc"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ProbeHealthEvent - Reported on Options.ProbeHealthChan when the health check of the manager finds a probe that is no
+// longer attached to its hook point
+type ProbeHealthEvent struct {
+	// Probe - Unhealthy probe
+	Probe *Probe
+
+	// Err - Reason why the probe is unhealthy, wraps ErrProbeUnhealthy
+	Err error
+
+	// Reattached - True if the probe was re-attached, see Options.HealthCheckReattach
+	Reattached bool
+
+	// ReattachErr - Error returned when the probe was re-attached, if any
+	ReattachErr error
+}
+
+// binaryIdentity - (uprobes) Device and inode of the binary of the probe when it was attached
+type binaryIdentity struct {
+	dev uint64
+	ino uint64
+}
+
+// statBinary - Returns the identity of the binary at the provided path
+func statBinary(path string) (binaryIdentity, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return binaryIdentity{}, err
+	}
+	return binaryIdentity{dev: uint64(stat.Dev), ino: stat.Ino}, nil
+}
+
+// checkHealth - Returns an error wrapping ErrProbeUnhealthy if the probe is running but no longer attached to its hook
+// point: the binary of a uprobe was replaced, the interface of a TC classifier or of an XDP program was deleted or
+// recreated, its filter or its XDP program was removed, the kprobe event of a kprobe was removed from tracefs, or its
+// bpf_link reports an error
+func (p *Probe) checkHealth() error {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	if p.state < running || !p.Enabled || p.programSpec == nil {
+		return nil
+	}
+
+	if err := checkLinkHealth(p.link); err != nil {
+		return err
+	}
+
+	switch p.programSpec.Type {
+	case ebpf.Kprobe:
+		if p.kprobeEvent != nil {
+			path := filepath.Join(p.kprobeEvent.tracefs, "events", kprobeEventsGroup, p.kprobeEvent.name)
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("error:%w , kprobe event %s was removed", ErrProbeUnhealthy, p.kprobeEvent.name)
+			}
+		}
+		if p.binaryIdentity != (binaryIdentity{}) {
+			identity, err := statBinary(p.binaryPath())
+			if err != nil {
+				return fmt.Errorf("error:%w , binary %s: %v", ErrProbeUnhealthy, p.BinaryPath, err)
+			}
+			if identity != p.binaryIdentity {
+				return fmt.Errorf("error:%w , binary %s was replaced", ErrProbeUnhealthy, p.BinaryPath)
+			}
+		}
+	case ebpf.SchedCLS, ebpf.XDP:
+		if p.IfnameMatcher != nil || p.isContainerScoped() {
+			// the sub-probes follow the interfaces
+			return nil
+		}
+		return p.checkInterfaceHealth()
+	}
+	return nil
+}
+
+const (
+	// bpfLinkTypeTCX, bpfLinkTypeNetkit - BPF_LINK_TYPE_TCX and BPF_LINK_TYPE_NETKIT, which aren't defined by
+	// cilium/ebpf v0.10
+	bpfLinkTypeTCX    = 11
+	bpfLinkTypeNetkit = 13
+)
+
+// checkLinkHealth - Returns an error wrapping ErrProbeUnhealthy if the provided bpf_link reports an error, or if the
+// kernel detached it from its interface. The links that aren't bpf_links aren't checked.
+func checkLinkHealth(l link.Link) error {
+	fdLink, ok := l.(interface{ FD() int })
+	if !ok {
+		return nil
+	}
+
+	// struct bpf_link_info, xdp, tcx and netkit variants
+	var info struct {
+		linkType uint32
+		id       uint32
+		progID   uint32
+		_        uint32
+		ifindex  uint32
+		_        [28]byte
+	}
+	// union bpf_attr, info variant
+	attr := struct {
+		bpfFD   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFD:   uint32(fdLink.FD()),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return fmt.Errorf("error:%w , link: %v", ErrProbeUnhealthy, errno)
+	}
+	switch info.linkType {
+	case uint32(link.XDPType), bpfLinkTypeTCX, bpfLinkTypeNetkit:
+		if info.ifindex == 0 {
+			return fmt.Errorf("error:%w , link %d was detached from its interface", ErrProbeUnhealthy, info.id)
+		}
+	}
+	return nil
+}
+
+// checkInterfaceHealth - (TC classifier & XDP) Checks that the interface of the probe still exists, and that the
+// program of the probe is still attached to it
+func (p *Probe) checkInterfaceHealth() error {
+	var nlink netlink.Link
+	err := runInNetns(p.NetnsPath, func() error {
+		var err error
+		nlink, err = netlink.LinkByIndex(int(p.Ifindex))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error:%w , interface %v: %v", ErrProbeUnhealthy, p.Ifindex, err)
+	}
+	if p.ifindexResolved && nlink.Attrs().Name != p.Ifname {
+		return fmt.Errorf("error:%w , interface %v was recreated", ErrProbeUnhealthy, p.Ifname)
+	}
+
+	switch {
+	case p.programSpec.Type == ebpf.XDP && p.xdpDispatcherPrefix == "":
+		info, err := p.program.Info()
+		if err != nil {
+			return nil
+		}
+		if id, ok := info.ID(); ok && (nlink.Attrs().Xdp == nil || nlink.Attrs().Xdp.ProgId != uint32(id)) {
+			return fmt.Errorf("error:%w , XDP program of interface %v was replaced", ErrProbeUnhealthy, p.Ifindex)
+		}
+	case p.programSpec.Type == ebpf.SchedCLS && p.tcAttachMode == TCAttachModeNetlink:
+		ntl, ok := p.manager.getNetlinkConnection(netlinkCacheKey{p.Ifindex, p.IfindexNetns, p.NetnsPath})
+		if !ok {
+			return nil
+		}
+		if p.lookupTCFilter(ntl, tc.Msg{Ifindex: uint32(p.Ifindex), Parent: p.getTCFilterParentHandle()}) == nil {
+			return fmt.Errorf("error:%w , TC filter of interface %v was removed", ErrProbeUnhealthy, p.Ifindex)
+		}
+	}
+	return nil
+}
+
+// reattach - Detaches the probe from its stale hook point and attaches it again. The interface of a TC classifier or
+// of an XDP program is resolved again from its name.
+func (p *Probe) reattach() error {
+	p.stateLock.Lock()
+	if p.state < running {
+		p.stateLock.Unlock()
+		return nil
+	}
+	// the stale hook point might be gone already
+	_ = p.detach()
+	if p.ifindexResolved {
+		if p.programSpec.Type == ebpf.SchedCLS {
+			p.manager.removeNetlinkConnection(netlinkCacheKey{p.Ifindex, p.IfindexNetns, p.NetnsPath})
+		}
+		p.Ifindex = 0
+		p.ifindexResolved = false
+		if err := p.resolveIfindex(); err != nil {
+			p.state = initialized
+			p.stateLock.Unlock()
+			p.manager.dispatchFailure(p, &ProbeAttachError{Probe: p.GetIdentificationPair(), Err: err})
+			return err
+		}
+	}
+	p.state = initialized
+	p.attachRetryAttempt = 0
+	p.stateLock.Unlock()
+	return p.Attach()
+}
+
+// CheckProbesHealth - Checks that the running probes of the manager are still attached to their hook points, and
+// returns the unhealthy ones. They are re-attached if Options.HealthCheckReattach is set. See
+// Options.HealthCheckInterval to run the health check periodically.
+func (m *Manager) CheckProbesHealth() []ProbeHealthEvent {
+	m.stateLock.RLock()
+	probes := append([]*Probe(nil), m.Probes...)
+	m.stateLock.RUnlock()
+
+	var events []ProbeHealthEvent
+	for _, probe := range probes {
+		err := probe.checkHealth()
+		if err == nil {
+			continue
+		}
+		event := ProbeHealthEvent{Probe: probe, Err: err}
+		if m.options.HealthCheckReattach {
+			event.ReattachErr = probe.reattach()
+			event.Reattached = event.ReattachErr == nil
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// startHealthCheck - Starts the health check goroutine of the manager if Options.HealthCheckInterval is set
+func (m *Manager) startHealthCheck() {
+	if m.options.HealthCheckInterval <= 0 || m.healthStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	m.healthStop = stop
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.options.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			for _, event := range m.CheckProbesHealth() {
+				detached := &ProbeDetachedError{Probe: event.Probe.GetIdentificationPair(), Reattached: event.Reattached, Err: event.Err}
+				if !event.Reattached && event.ReattachErr == nil {
+					// the probes that couldn't be re-attached were already reported
+					m.dispatchFailure(event.Probe, detached)
+				} else {
+					m.reportError(detached)
+				}
+				if m.options.ProbeHealthChan == nil {
+					continue
+				}
+				select {
+				case m.options.ProbeHealthChan <- event:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// stopHealthCheck - Stops the health check goroutine of the manager
+func (m *Manager) stopHealthCheck() {
+	if m.healthStop == nil {
+		return
+	}
+	close(m.healthStop)
+	m.healthStop = nil
+}
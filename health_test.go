@@ -0,0 +1,179 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+)
+
+func TestCheckProbesHealth(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	m := &Manager{
+		netlinkCache: make(map[netlinkCacheKey]*netlinkCacheValue),
+		options:      Options{HealthCheckReattach: true},
+	}
+	p := &Probe{
+		manager:          m,
+		program:          prog,
+		programSpec:      &ebpf.ProgramSpec{Type: ebpf.SchedCLS},
+		Section:          "classifier",
+		EbpfFuncName:     "classifier",
+		Enabled:          true,
+		Ifindex:          1,
+		NetnsPath:        path,
+		NetworkDirection: Ingress,
+		ProbeRetry:       1,
+		state:            initialized,
+	}
+	m.Probes = []*Probe{p}
+	if err = p.Attach(); err != nil {
+		t.Skipf("couldn't attach TC classifier: %v", err)
+	}
+	defer func() {
+		_ = p.detachTCCLS()
+	}()
+	if events := m.CheckProbesHealth(); len(events) != 0 {
+		t.Fatalf("expected the probe to be healthy, got %v", events[0].Err)
+	}
+
+	// another tool removes the filter of the probe
+	ntl, _ := m.getNetlinkConnection(netlinkCacheKey{1, 0, path})
+	if err = ntl.rtNetlink.Filter().Delete(p.tcFilterObject); err != nil {
+		t.Fatal(err)
+	}
+	events := m.CheckProbesHealth()
+	if len(events) != 1 {
+		t.Fatalf("expected the probe to be unhealthy, got %d events", len(events))
+	}
+	if !errors.Is(events[0].Err, ErrProbeUnhealthy) {
+		t.Errorf("expected ErrProbeUnhealthy, got %v", events[0].Err)
+	}
+	if !events[0].Reattached {
+		t.Fatalf("expected the probe to be re-attached, got %v", events[0].ReattachErr)
+	}
+	if events = m.CheckProbesHealth(); len(events) != 0 {
+		t.Errorf("expected the re-attached probe to be healthy, got %v", events[0].Err)
+	}
+}
+
+func TestCheckProbesHealthConcurrentAddProbe(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	const interfaces = 8
+	for i := 0; i < interfaces; i++ {
+		name := fmt.Sprintf("health%d", i)
+		if err := runInNetns(path, func() error {
+			return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+		}); err != nil {
+			t.Skipf("couldn't create veth pair: %v", err)
+		}
+	}
+
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"ingress": {
+			Name:        "ingress",
+			Type:        ebpf.SchedCLS,
+			SectionName: "classifier/ingress",
+			License:     "MIT",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		},
+	}}
+	m := &Manager{Probes: []*Probe{{
+		Section:          "classifier/ingress",
+		EbpfFuncName:     "ingress",
+		Ifindex:          1,
+		NetnsPath:        path,
+		NetworkDirection: Ingress,
+	}}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{HealthCheckReattach: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err := m.Start(); err != nil {
+		t.Skipf("couldn't attach TC classifier: %v", err)
+	}
+
+	// the health check looks up the netlink sockets of the probes while new ones are opened
+	stop := make(chan struct{})
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, event := range m.CheckProbesHealth() {
+				t.Errorf("expected the probes to be healthy, got %v", event.Err)
+			}
+		}
+	}()
+	for i := 0; i < interfaces; i++ {
+		name := fmt.Sprintf("health%d", i)
+		if err := m.AddProbe(&Probe{
+			UID:              name,
+			Section:          "classifier/ingress",
+			EbpfFuncName:     "ingress",
+			CopyProgram:      true,
+			Ifname:           name,
+			NetnsPath:        path,
+			NetworkDirection: Ingress,
+		}); err != nil {
+			t.Error(err)
+		}
+	}
+	close(stop)
+	<-checked
+}
+
+// closedLink - link.Link whose file descriptor was closed
+type closedLink struct {
+	link.Link
+}
+
+func (closedLink) FD() int {
+	return -1
+}
+
+func TestCheckLinkHealth(t *testing.T) {
+	if err := checkLinkHealth(nil); err != nil {
+		t.Errorf("expected the probes without a bpf_link to be healthy, got %v", err)
+	}
+	p := &Probe{
+		programSpec: &ebpf.ProgramSpec{Type: ebpf.Kprobe},
+		link:        closedLink{},
+		Enabled:     true,
+		state:       running,
+	}
+	if err := p.checkHealth(); !errors.Is(err, ErrProbeUnhealthy) {
+		t.Errorf("expected ErrProbeUnhealthy for a closed link, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// IfnameMatcher - (TC classifiers & XDP) Selects network interfaces by name, with a glob pattern or a regular
+// expression, see Probe.IfnameMatcher
+type IfnameMatcher struct {
+	// Pattern - Glob pattern matching the names of the interfaces (see filepath.Match), for example "veth*"
+	Pattern string
+
+	// Regexp - Regular expression matching the names of the interfaces, used instead of Pattern when set
+	Regexp *regexp.Regexp
+}
+
+// Matches - Returns true if the provided interface name is selected
+func (im *IfnameMatcher) Matches(ifname string) bool {
+	if im.Regexp != nil {
+		return im.Regexp.MatchString(ifname)
+	}
+	ok, _ := filepath.Match(im.Pattern, ifname)
+	return ok
+}
+
+// validate - Checks that the matcher selects interfaces
+func (im *IfnameMatcher) validate() error {
+	if im.Regexp != nil {
+		return nil
+	}
+	if im.Pattern == "" {
+		return errors.New("empty interface pattern")
+	}
+	if _, err := filepath.Match(im.Pattern, ""); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , invalid interface pattern %s", err, im.Pattern))
+	}
+	return nil
+}
+
+// InterfaceAttachment - (TC classifiers & XDP) Attachment of a probe to an interface matching Probe.IfnameMatcher, see
+// Probe.GetInterfaceAttachments
+type InterfaceAttachment struct {
+	// Ifindex, Ifname - Interface the probe is attached to
+	Ifindex int32
+	Ifname  string
+
+	// Probe - Sub-probe attached to the interface, nil if the attachment failed
+	Probe *Probe
+
+	// Err - Error returned when the probe was attached to the interface, if any
+	Err error
+}
+
+// newInterfaceProbe - Returns a sub-probe sharing the program of the probe, attached to the provided interface
+func (p *Probe) newInterfaceProbe(ifindex int32, ifname string) *Probe {
+	sub := p.Copy()
+	sub.IfnameMatcher = nil
+	sub.Container, sub.ContainerPID = "", 0
+	sub.Ifindex, sub.Ifname = ifindex, ifname
+	// the pins belong to the parent probe
+	sub.PinPath, sub.LinkPinPath = "", ""
+	sub.manager = p.manager
+	sub.program = p.program
+	sub.programSpec = p.programSpec
+	sub.ifindexResolved = true
+	sub.state = initialized
+	return sub
+}
+
+// attachInterface - Attaches a sub-probe to the provided interface, unless the probe is already attached to it. The
+// interface lock must be held.
+func (p *Probe) attachInterface(ifindex int32, ifname string) {
+	// a failed attachment is retried on the next event of the interface, when it is brought up for example
+	if attachment, ok := p.interfaceAttachments[ifindex]; ok && attachment.Err == nil {
+		return
+	}
+	attachment := &InterfaceAttachment{Ifindex: ifindex, Ifname: ifname}
+	sub := p.newInterfaceProbe(ifindex, ifname)
+	sub.stateLock.Lock()
+	attachment.Err = sub.attachHook()
+	if attachment.Err == nil {
+		sub.state = running
+		attachment.Probe = sub
+	}
+	sub.stateLock.Unlock()
+	if attachment.Err != nil {
+		p.manager.reportError(&ProbeAttachError{Probe: p.GetIdentificationPair(), Err: fmt.Errorf("error:%w , interface %s", attachment.Err, ifname)})
+	}
+	p.interfaceAttachments[ifindex] = attachment
+}
+
+// detachInterface - Detaches the sub-probe of the provided interface. When the interface was deleted, only the
+// resources held in user space are released. The interface lock must be held.
+func (p *Probe) detachInterface(ifindex int32, deleted bool) error {
+	attachment, ok := p.interfaceAttachments[ifindex]
+	if !ok {
+		return nil
+	}
+	delete(p.interfaceAttachments, ifindex)
+	sub := attachment.Probe
+	if sub == nil {
+		return nil
+	}
+	sub.stateLock.Lock()
+	defer sub.stateLock.Unlock()
+	sub.state = initialized
+	if !deleted {
+		return sub.detach()
+	}
+	// the kernel detached the program from the deleted interface
+	var err error
+	if sub.link != nil {
+		err = sub.link.Close()
+		sub.link = nil
+	}
+	key := netlinkCacheKey{sub.Ifindex, sub.IfindexNetns, sub.NetnsPath}
+	if ntl, ok := p.manager.removeNetlinkConnection(key); ok {
+		err = ConcatErrors(err, ntl.rtNetlink.Close())
+	}
+	return err
+}
+
+// subscribeLinkEvents - Opens a netlink socket receiving the link events of the provided network namespace. The socket
+// is non-blocking, so that closing the returned file interrupts the pending reads.
+func subscribeLinkEvents(netnsPath string) (*os.File, error) {
+	fd := -1
+	err := runInNetns(netnsPath, func() error {
+		var err error
+		if fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE); err != nil {
+			return err
+		}
+		return unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK})
+	})
+	if err != nil {
+		if fd >= 0 {
+			_ = unix.Close(fd)
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "rtnetlink"), nil
+}
+
+// attachInterfaceMatching - (TC classifiers & XDP) Attaches the probe to the interfaces matching IfnameMatcher, and
+// watches the link events of the network namespace of the probe to attach it to the new matching interfaces and detach
+// it from the deleted ones
+func (p *Probe) attachInterfaceMatching() error {
+	if err := p.IfnameMatcher.validate(); err != nil {
+		return err
+	}
+	// subscribe first so that no interface is missed, the interfaces listed twice are attached once
+	events, err := subscribeLinkEvents(p.NetnsPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't watch the interfaces of probe %v", err, p.GetIdentificationPair()))
+	}
+	var links []netlink.Link
+	err = runInNetns(p.NetnsPath, func() error {
+		links, err = netlink.LinkList()
+		return err
+	})
+	if err != nil {
+		_ = events.Close()
+		return errors.New(fmt.Sprintf("error:%v , couldn't list the interfaces of probe %v", err, p.GetIdentificationPair()))
+	}
+
+	p.interfaceLock.Lock()
+	p.interfaceAttachments = make(map[int32]*InterfaceAttachment)
+	for _, nlink := range links {
+		if attrs := nlink.Attrs(); p.IfnameMatcher.Matches(attrs.Name) {
+			p.attachInterface(int32(attrs.Index), attrs.Name)
+		}
+	}
+	p.interfaceEvents = events
+	p.interfaceWatchDone = make(chan struct{})
+	go p.watchInterfaces(events, p.interfaceWatchDone)
+	p.interfaceLock.Unlock()
+	return nil
+}
+
+// watchInterfaces - Attaches and detaches the probe as the matching interfaces are created, renamed and deleted, until
+// the link events socket is closed
+func (p *Probe) watchInterfaces(events *os.File, exited chan struct{}) {
+	defer close(exited)
+	conn, err := events.SyscallConn()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		var n int
+		var recvErr error
+		if err = conn.Read(func(fd uintptr) bool {
+			n, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+			return recvErr != unix.EAGAIN
+		}); err != nil {
+			// the socket was closed
+			return
+		}
+		if recvErr != nil {
+			// ENOBUFS: events were lost, the next events are still handled
+			p.manager.reportError(fmt.Errorf("error:%w , couldn't read the link events of probe %v", recvErr, p.GetIdentificationPair()))
+			continue
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, message := range messages {
+			p.handleLinkEvent(message)
+		}
+	}
+}
+
+// handleLinkEvent - Attaches or detaches the probe according to the provided link event
+func (p *Probe) handleLinkEvent(message syscall.NetlinkMessage) {
+	if (message.Header.Type != unix.RTM_NEWLINK && message.Header.Type != unix.RTM_DELLINK) || len(message.Data) < unix.SizeofIfInfomsg {
+		return
+	}
+	ifindex := int32(nativeEndian.Uint32(message.Data[4:8]))
+	attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+	if err != nil {
+		return
+	}
+	var ifname string
+	for _, attribute := range attributes {
+		if attribute.Attr.Type == unix.IFLA_IFNAME {
+			ifname = strings.TrimRight(string(attribute.Value), "\x00")
+		}
+	}
+
+	p.interfaceLock.Lock()
+	if p.interfaceAttachments == nil {
+		p.interfaceLock.Unlock()
+		return
+	}
+	switch {
+	case message.Header.Type == unix.RTM_DELLINK:
+		err = p.detachInterface(ifindex, true)
+	case p.IfnameMatcher.Matches(ifname):
+		p.attachInterface(ifindex, ifname)
+	default:
+		// the interface was renamed and no longer matches
+		err = p.detachInterface(ifindex, false)
+	}
+	p.interfaceLock.Unlock()
+	if err != nil {
+		p.manager.reportError(fmt.Errorf("error:%w , couldn't detach probe %v from interface %s", err, p.GetIdentificationPair(), ifname))
+	}
+}
+
+// GetInterfaceAttachments - (TC classifiers & XDP) Returns the attachments of the probe to the interfaces matching
+// IfnameMatcher, sorted by interface index
+func (p *Probe) GetInterfaceAttachments() []InterfaceAttachment {
+	p.interfaceLock.Lock()
+	defer p.interfaceLock.Unlock()
+	attachments := make([]InterfaceAttachment, 0, len(p.interfaceAttachments))
+	for _, attachment := range p.interfaceAttachments {
+		attachments = append(attachments, *attachment)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].Ifindex < attachments[j].Ifindex
+	})
+	return attachments
+}
+
+// detachInterfaceMatching - Stops watching the link events, or the container of the probe, and detaches the probe
+// from all the matching interfaces
+func (p *Probe) detachInterfaceMatching() error {
+	p.interfaceLock.Lock()
+	events, stop, exited := p.interfaceEvents, p.interfaceWatchStop, p.interfaceWatchDone
+	p.interfaceEvents, p.interfaceWatchStop, p.interfaceWatchDone = nil, nil, nil
+	p.interfaceLock.Unlock()
+	var err error
+	if events != nil {
+		err = events.Close()
+	}
+	if stop != nil {
+		close(stop)
+	}
+	if exited != nil {
+		<-exited
+	}
+
+	p.interfaceLock.Lock()
+	defer p.interfaceLock.Unlock()
+	for ifindex := range p.interfaceAttachments {
+		err = ConcatErrors(err, p.detachInterface(ifindex, false))
+	}
+	p.interfaceAttachments = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIfnameMatcher(t *testing.T) {
+	glob := &IfnameMatcher{Pattern: "veth*"}
+	re := &IfnameMatcher{Pattern: "ignored", Regexp: regexp.MustCompile(`^(cali|lxc)[0-9a-f]+$`)}
+	for _, tc := range []struct {
+		matcher  *IfnameMatcher
+		ifname   string
+		expected bool
+	}{
+		{glob, "veth1234", true},
+		{glob, "eth0", false},
+		{re, "cali0a1b", true},
+		{re, "lxc42", true},
+		{re, "ignored", false},
+	} {
+		if tc.matcher.Matches(tc.ifname) != tc.expected {
+			t.Errorf("expected %v to match %s: %v", tc.matcher, tc.ifname, tc.expected)
+		}
+	}
+	if err := (&IfnameMatcher{Pattern: "[veth"}).validate(); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestInterfaceMatching(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	// veth pairs, like the interfaces of containers
+	addVeth := func(name string, peer string) {
+		t.Helper()
+		if err := runInNetns(path, func() error {
+			return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer})
+		}); err != nil {
+			t.Skipf("couldn't create veth pair: %v", err)
+		}
+	}
+	addVeth("match0", "peer0")
+
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"ingress": {
+			Name:        "ingress",
+			Type:        ebpf.SchedCLS,
+			SectionName: "classifier/ingress",
+			License:     "MIT",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		},
+	}}
+	probe := &Probe{
+		Section:          "classifier/ingress",
+		EbpfFuncName:     "ingress",
+		NetnsPath:        path,
+		NetworkDirection: Ingress,
+		IfnameMatcher:    &IfnameMatcher{Pattern: "match*"},
+	}
+	m := &Manager{Probes: []*Probe{probe}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	attachedTo := func() []string {
+		var names []string
+		for _, attachment := range probe.GetInterfaceAttachments() {
+			if attachment.Err != nil {
+				t.Fatalf("couldn't attach to %s: %v", attachment.Ifname, attachment.Err)
+			}
+			names = append(names, attachment.Ifname)
+		}
+		return names
+	}
+	waitFor := func(expected ...string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			names := attachedTo()
+			if len(names) == len(expected) {
+				equal := true
+				for i := range names {
+					equal = equal && names[i] == expected[i]
+				}
+				if equal {
+					return
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the probe to be attached to %v, got %v", expected, names)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("match0")
+
+	// the probe follows the interfaces
+	addVeth("match1", "peer1")
+	waitFor("match0", "match1")
+	if err := runInNetns(path, func() error {
+		link, err := netlink.LinkByName("match0")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("match1")
+
+	if err := m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if attachments := probe.GetInterfaceAttachments(); len(attachments) != 0 {
+		t.Errorf("expected the probe to be detached from all the interfaces, got %v", attachments)
+	}
+}
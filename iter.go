@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// isIterSpec - Returns true if the program of the probe is a bpf_iter program, SEC("iter/[target]")
+func (p *Probe) isIterSpec() bool {
+	return p.programSpec.Type == ebpf.Tracing && p.programSpec.AttachType == ebpf.AttachTraceIter
+}
+
+// attachIter - Attaches the bpf_iter program of the probe to its target, and to the map set in IterMap for the map
+// element iterators (bpf_map_elem, sockmap)
+func (p *Probe) attachIter() error {
+	opts := link.IterOptions{Program: p.program}
+	if p.IterMap != "" {
+		iterMap, ok := p.manager.getMap(p.IterMap)
+		if !ok {
+			return errors.New(fmt.Sprintf("error:%v , couldn't find map %s", ErrUnknownMap, p.IterMap))
+		}
+		opts.Map = iterMap
+	}
+	iter, err := link.AttachIter(opts)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't attach iterator %s, matchFuncName:%s", err, p.Section, p.EbpfFuncName))
+	}
+	p.link = iter
+	return nil
+}
+
+// RunIterator - Runs the bpf_iter program of the provided probe, and returns a reader over the seq_file output of the
+// program. Each read runs the program on the next objects of its target (tasks, sockets, map elements...), until the
+// end of the iteration is reached. The probe must be running. Close the reader once done.
+func (m *Manager) RunIterator(id ProbeIdentificationPair) (io.ReadCloser, error) {
+	probe, found := m.GetProbe(id)
+	if !found {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't find probe %v", ErrUnknownMatchFuncName, id))
+	}
+	probe.stateLock.RLock()
+	defer probe.stateLock.RUnlock()
+	iter, ok := probe.link.(*link.Iter)
+	if probe.state < running || !ok {
+		return nil, fmt.Errorf("error:%w , probe %v", ErrNotIterator, id)
+	}
+	reader, err := iter.Open()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't run iterator %v", err, id))
+	}
+	return reader, nil
+}
@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// taskIterSpec - Returns a CollectionSpec with a task iterator that writes one byte per task
+func taskIterSpec() *ebpf.CollectionSpec {
+	return &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"dump_task": {
+				Name:        "dump_task",
+				Type:        ebpf.Tracing,
+				AttachType:  ebpf.AttachTraceIter,
+				AttachTo:    "task",
+				SectionName: "iter/task",
+				License:     "GPL",
+				Instructions: asm.Instructions{
+					// struct bpf_iter__task { struct bpf_iter_meta *meta; struct task_struct *task; }
+					asm.LoadMem(asm.R2, asm.R1, 8, asm.DWord),
+					asm.JEq.Imm(asm.R2, 0, "exit"),
+					asm.LoadMem(asm.R1, asm.R1, 0, asm.DWord),
+					asm.LoadMem(asm.R1, asm.R1, 0, asm.DWord),
+					asm.StoreImm(asm.RFP, -8, 'x', asm.DWord),
+					asm.Mov.Reg(asm.R2, asm.RFP),
+					asm.Add.Imm(asm.R2, -8),
+					asm.Mov.Imm(asm.R3, 1),
+					asm.FnSeqWrite.Call(),
+					asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+					asm.Return(),
+				},
+			},
+		},
+	}
+}
+
+func TestRunIterator(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	id := ProbeIdentificationPair{EbpfFuncName: "dump_task"}
+	m := &Manager{Probes: []*Probe{{Section: "iter/task", EbpfFuncName: id.EbpfFuncName}}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: taskIterSpec()}}, Options{}); err != nil {
+		t.Skipf("task iterators not supported: %v", err)
+	}
+	defer m.Stop(CleanAll)
+
+	if _, err := m.RunIterator(id); !errors.Is(err, ErrNotIterator) {
+		t.Errorf("expected ErrNotIterator before Start, got %v", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := m.RunIterator(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) == 0 || output[0] != 'x' {
+		t.Errorf("expected one byte per task, got %q", output)
+	}
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kernelSymbol - Text symbol of the kernel, read from /proc/kallsyms
+type kernelSymbol struct {
+	name    string
+	address uint64
+}
+
+// readKernelSymbols - Returns the text (code) and weak symbols of symFile, sorted by address
+func readKernelSymbols(symFile string) ([]kernelSymbol, error) {
+	if symFile == "" {
+		symFile = defaultSymFile
+	}
+	file, err := os.Open(symFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var symbols []kernelSymbol
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// address type name [module]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if kind := strings.ToLower(fields[1]); kind != "t" && kind != "w" {
+			continue
+		}
+		address, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		symbols = append(symbols, kernelSymbol{name: fields[2], address: address})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].address < symbols[j].address
+	})
+	return symbols, nil
+}
+
+// resolveKernelAddress - Returns the symbol of symFile that contains the provided kernel address, and the offset of
+// the address in this symbol
+func resolveKernelAddress(address uint64, symFile string) (string, uint64, error) {
+	symbols, err := readKernelSymbols(symFile)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(symbols) == 0 || symbols[len(symbols)-1].address == 0 {
+		// kptr_restrict hides the addresses to unprivileged readers
+		return "", 0, fmt.Errorf("error:%w , the kernel addresses of %s are hidden", ErrKprobeAddress, symFile)
+	}
+	i := sort.Search(len(symbols), func(i int) bool {
+		return symbols[i].address > address
+	})
+	if i == 0 {
+		return "", 0, fmt.Errorf("error:%w , no symbol contains 0x%x", ErrKprobeAddress, address)
+	}
+	symbol := symbols[i-1]
+	return symbol.name, address - symbol.address, nil
+}
+
+// kernelSymbolSize - Returns the size of the provided symbol, up to the next symbol. Returns false if the symbol isn't
+// in symbols, is the last one or if the addresses are hidden.
+func kernelSymbolSize(symbols []kernelSymbol, name string) (uint64, bool) {
+	for i, symbol := range symbols {
+		if symbol.name != name || symbol.address == 0 {
+			continue
+		}
+		for _, next := range symbols[i+1:] {
+			if next.address > symbol.address {
+				return next.address - symbol.address, true
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// existingKernelSymbols - Returns the provided symbols that exist in symFile, in order
+func existingKernelSymbols(names []string, symFile string) ([]string, error) {
+	symbols, err := readKernelSymbols(symFile)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		exists[symbol.name] = true
+	}
+	var existing []string
+	for _, name := range names {
+		if exists[name] {
+			existing = append(existing, name)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("error:%w , %s", ErrNoKprobeCandidate, strings.Join(names, ", "))
+	}
+	return existing, nil
+}
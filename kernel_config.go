@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	kernelConfigOnce sync.Once
+	kernelConfig     map[string]string
+	kernelConfigErr  error
+)
+
+// KernelConfig - Returns the build configuration of the running kernel, indexed by option (CONFIG_BPF_LSM...), read
+// from /proc/config.gz or from /boot/config-[release]. The configuration is read once.
+func KernelConfig() (map[string]string, error) {
+	kernelConfigOnce.Do(func() {
+		kernelConfig, kernelConfigErr = readKernelConfig()
+	})
+	return kernelConfig, kernelConfigErr
+}
+
+// readKernelConfig - Reads the build configuration of the running kernel, see KernelConfig
+func readKernelConfig() (map[string]string, error) {
+	if f, err := os.Open("/proc/config.gz"); err == nil {
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read /proc/config.gz: %w", err)
+		}
+		return parseKernelConfig(r)
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return nil, fmt.Errorf("couldn't get the kernel release: %w", err)
+	}
+	f, err := os.Open("/boot/config-" + unix.ByteSliceToString(uname.Release[:]))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find the kernel configuration: %w", err)
+	}
+	defer f.Close()
+	return parseKernelConfig(f)
+}
+
+// parseKernelConfig - Parses a kernel configuration file, the options that aren't set are left out
+func parseKernelConfig(r io.Reader) (map[string]string, error) {
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if option, value, ok := strings.Cut(line, "="); ok {
+			config[option] = strings.Trim(value, `"`)
+		}
+	}
+	return config, scanner.Err()
+}
+
+// FunctionEntryPadding - Returns the size of the instructions inserted by the compiler at the entry of the kernel
+// functions, before their body, for the running kernel and architecture: the endbr64 landing pad of the x86 kernels
+// built with CONFIG_X86_KERNEL_IBT and the call to __fentry__ of the kernels with ftrace, or the BTI landing pad
+// (CONFIG_ARM64_BTI_KERNEL) and the 2 patchable instructions of ftrace (CONFIG_DYNAMIC_FTRACE_WITH_REGS / ARGS) on
+// arm64. See Probe.KprobeBodyOffset.
+func FunctionEntryPadding() (uint64, error) {
+	config, err := KernelConfig()
+	if err != nil {
+		return 0, err
+	}
+	return functionEntryPadding(config, runtime.GOARCH), nil
+}
+
+// functionEntryPadding - Returns the entry padding of the kernel functions for the provided configuration and
+// architecture, see FunctionEntryPadding
+func functionEntryPadding(config map[string]string, arch string) uint64 {
+	enabled := func(option string) bool {
+		return config[option] == "y"
+	}
+	var padding uint64
+	switch arch {
+	case "amd64", "386":
+		if enabled("CONFIG_X86_KERNEL_IBT") {
+			padding += 4
+		}
+		if enabled("CONFIG_FUNCTION_TRACER") {
+			padding += 5
+		}
+	case "arm64":
+		if enabled("CONFIG_ARM64_BTI_KERNEL") {
+			padding += 4
+		}
+		if enabled("CONFIG_DYNAMIC_FTRACE_WITH_REGS") || enabled("CONFIG_DYNAMIC_FTRACE_WITH_ARGS") {
+			padding += 8
+		}
+	}
+	return padding
+}
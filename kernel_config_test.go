@@ -0,0 +1,36 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFunctionEntryPadding(t *testing.T) {
+	config, err := parseKernelConfig(strings.NewReader("# CONFIG_X86_KERNEL_IBT is not set\n" +
+		"CONFIG_FUNCTION_TRACER=y\n" +
+		"CONFIG_DYNAMIC_FTRACE_WITH_ARGS=y\n" +
+		"CONFIG_ARM64_BTI_KERNEL=y\n" +
+		"CONFIG_LOCALVERSION=\"-generic\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["CONFIG_LOCALVERSION"] != "-generic" {
+		t.Errorf("unexpected configuration %v", config)
+	}
+	for arch, expected := range map[string]uint64{"amd64": 5, "arm64": 12, "riscv64": 0} {
+		if padding := functionEntryPadding(config, arch); padding != expected {
+			t.Errorf("expected a padding of %d bytes on %s, got %d", expected, arch, padding)
+		}
+	}
+	config["CONFIG_X86_KERNEL_IBT"] = "y"
+	if padding := functionEntryPadding(config, "amd64"); padding != 9 {
+		t.Errorf("expected a padding of 9 bytes with IBT, got %d", padding)
+	}
+
+	if _, err = KernelConfig(); err != nil {
+		t.Skipf("kernel configuration not available: %v", err)
+	}
+	if _, err = FunctionEntryPadding(); err != nil {
+		t.Error(err)
+	}
+}
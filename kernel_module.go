@@ -0,0 +1,127 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+const (
+	// sysModuleDir - Directory of the kernel modules in sysfs
+	sysModuleDir = "/sys/module"
+	// kernelBTFDir - Directory of the BTF of the kernel and of its modules in sysfs
+	kernelBTFDir = "/sys/kernel/btf"
+)
+
+// moduleWaitPolicy - Retry policy of the probes that wait for their kernel module, see Probe.WaitForModule
+var moduleWaitPolicy = &RetryPolicy{
+	MaxBackoff: time.Second,
+	Retryable: func(err error) bool {
+		return errors.Is(err, ErrKernelModuleNotLoaded)
+	},
+}
+
+// IsKernelModuleLoaded - Returns true if the provided kernel module is loaded and initialized, or built into the kernel
+func IsKernelModuleLoaded(module string) bool {
+	dir := filepath.Join(sysModuleDir, strings.ReplaceAll(module, "-", "_"))
+	state, err := os.ReadFile(filepath.Join(dir, "initstate"))
+	if err == nil {
+		return strings.TrimSpace(string(state)) == "live"
+	}
+	// the built-in modules don't have an initstate
+	_, err = os.Stat(dir)
+	return err == nil
+}
+
+// LoadKernelModuleBTF - Loads the BTF of the provided kernel module from /sys/kernel/btf/<module>. The returned spec
+// only contains the types of the module, the types of the kernel are resolved against the kernel BTF.
+func LoadKernelModuleBTF(module string) (*btf.Spec, error) {
+	base, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , %v", ErrKernelBTFNotFound, err)
+	}
+	f, err := os.Open(filepath.Join(kernelBTFDir, strings.ReplaceAll(module, "-", "_")))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return btf.LoadSplitSpecFromReader(f, base)
+}
+
+// kernelModuleSymbols - Returns the text symbols of the provided kernel module in symFile
+func kernelModuleSymbols(module string, symFile string) (map[string]bool, error) {
+	if symFile == "" {
+		symFile = defaultSymFile
+	}
+	file, err := os.Open(symFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tag := "[" + strings.ReplaceAll(module, "-", "_") + "]"
+	symbols := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// address type name [module]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != tag {
+			continue
+		}
+		if kind := strings.ToLower(fields[1]); kind == "t" || kind == "w" {
+			symbols[fields[2]] = true
+		}
+	}
+	return symbols, scanner.Err()
+}
+
+// kernelModuleFuncs - Returns the provided functions that are defined by the provided kernel module. The functions
+// are looked up in the BTF of the module, or in symFile if the module doesn't have BTF.
+func kernelModuleFuncs(module string, funcs []string, symFile string) ([]string, error) {
+	var defined func(name string) bool
+	if spec, err := LoadKernelModuleBTF(module); err == nil {
+		defined = func(name string) bool {
+			var fn *btf.Func
+			return spec.TypeByName(name, &fn) == nil
+		}
+	} else {
+		symbols, errSym := kernelModuleSymbols(module, symFile)
+		if errSym != nil {
+			return nil, ConcatErrors(err, errSym)
+		}
+		defined = func(name string) bool {
+			return symbols[name]
+		}
+	}
+	var found []string
+	for _, name := range funcs {
+		if defined(name) {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("error:%w , %s isn't defined by kernel module %s", ErrNoKprobeCandidate, strings.Join(funcs, ", "), module)
+	}
+	return found, nil
+}
+
+// checkKernelModule - Returns an error if the hook point of the probe is defined by a kernel module that isn't
+// loaded, see KernelModule
+func (p *Probe) checkKernelModule() error {
+	if p.KernelModule == "" || IsKernelModuleLoaded(p.KernelModule) {
+		return nil
+	}
+	return fmt.Errorf("error:%w , %s is required by probe %v", ErrKernelModuleNotLoaded, p.KernelModule, p.GetIdentificationPair())
+}
+
+// loadsLazily - Returns true if the program of the probe is loaded when the probe is first attached instead of at
+// Init: LazyLoad is set, or the program may target a kernel module that isn't loaded yet
+func (p *Probe) loadsLazily() bool {
+	return p.LazyLoad || (p.WaitForModule && p.KernelModule != "")
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestIsKernelModuleLoaded(t *testing.T) {
+	if IsKernelModuleLoaded("ebpfmanager_missing") {
+		t.Error("expected a missing module not to be loaded")
+	}
+	modules, err := os.ReadDir(sysModuleDir)
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, module := range modules {
+		if _, err = os.Stat(filepath.Join(sysModuleDir, module.Name(), "initstate")); errors.Is(err, os.ErrNotExist) {
+			if !IsKernelModuleLoaded(module.Name()) {
+				t.Errorf("expected the built-in module %s to be loaded", module.Name())
+			}
+			return
+		}
+	}
+}
+
+func TestKernelModuleFuncs(t *testing.T) {
+	symFile := filepath.Join(t.TempDir(), "kallsyms")
+	symbols := "ffffffff81000000 T foo_close\n" +
+		"ffffffffc0001000 t foo_open\t[foo_mod]\n" +
+		"ffffffffc0002000 d foo_ops\t[foo_mod]\n"
+	if err := os.WriteFile(symFile, []byte(symbols), 0644); err != nil {
+		t.Fatal(err)
+	}
+	funcs, err := kernelModuleFuncs("foo-mod", []string{"foo_close", "foo_open", "foo_ops"}, symFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(funcs) != 1 || funcs[0] != "foo_open" {
+		t.Errorf("expected only foo_open to be defined by foo_mod, got %v", funcs)
+	}
+	if _, err = kernelModuleFuncs("foo_mod", []string{"foo_close"}, symFile); !errors.Is(err, ErrNoKprobeCandidate) {
+		t.Errorf("expected ErrNoKprobeCandidate, got %v", err)
+	}
+}
+
+func TestWaitForModule(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"kprobe_module": {
+			Name:        "kprobe_module",
+			Type:        ebpf.Kprobe,
+			SectionName: "kprobe/module_func",
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		},
+	}}
+	id := ProbeIdentificationPair{EbpfFuncName: "kprobe_module"}
+	newManager := func(wait bool) *Manager {
+		return &Manager{Probes: []*Probe{{
+			Section:          "kprobe/module_func",
+			EbpfFuncName:     "kprobe_module",
+			AttachToFuncName: "module_func",
+			KernelModule:     "ebpfmanager_missing",
+			WaitForModule:    wait,
+		}}}
+	}
+
+	// the mandatory probe can't be attached
+	m := newManager(false)
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); !errors.Is(err, ErrStartRolledBack) || !errors.Is(m.Probes[0].GetLastError(), ErrKernelModuleNotLoaded) {
+		t.Errorf("expected ErrKernelModuleNotLoaded, got %v", err)
+	}
+	_ = m.Stop(CleanAll)
+
+	// the probe waits for the module, its program isn't loaded yet
+	m = newManager(true)
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if _, ok := m.collection.Programs["kprobe_module"]; ok {
+		t.Error("expected the program of the probe not to be loaded at Init")
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status, ok := m.GetProbeStatus(id)
+	if !ok || status.Running || !status.Retrying || !errors.Is(status.LastError, ErrKernelModuleNotLoaded) {
+		t.Errorf("expected the probe to wait for its module, got running %v, retrying %v: %v", status.Running, status.Retrying, status.LastError)
+	}
+}
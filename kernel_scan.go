@@ -0,0 +1,282 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// KernelScan - BPF objects loaded in the kernel, see Manager.ScanKernel
+type KernelScan struct {
+	Programs  []KernelProgram  `json:"programs"`
+	Maps      []KernelMap      `json:"maps"`
+	Links     []KernelLink     `json:"links"`
+	Conflicts []AttachConflict `json:"conflicts,omitempty"`
+}
+
+// KernelProgram - BPF program loaded in the kernel. Owned is true if it belongs to the manager.
+type KernelProgram struct {
+	ID    uint32 `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Tag   string `json:"tag"`
+	Owned bool   `json:"owned"`
+}
+
+// KernelMap - BPF map loaded in the kernel. Owned is true if it belongs to the manager.
+type KernelMap struct {
+	ID    uint32 `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Owned bool   `json:"owned"`
+}
+
+// KernelLink - bpf_link of the kernel. Ifindex is only set for XDP links. Owned is true if it belongs to the manager.
+type KernelLink struct {
+	ID        uint32 `json:"id"`
+	Type      string `json:"type"`
+	ProgramID uint32 `json:"program_id"`
+	Ifindex   uint32 `json:"ifindex,omitempty"`
+	Owned     bool   `json:"owned"`
+}
+
+// AttachConflict - Program that isn't managed by the manager and is attached to the hook point of one of its probes,
+// for example another XDP program on the interface of an XDP probe
+type AttachConflict struct {
+	Probe     ProbeIdentificationPair `json:"probe"`
+	ProgramID uint32                  `json:"program_id"`
+	LinkID    uint32                  `json:"link_id,omitempty"`
+	Reason    string                  `json:"reason"`
+}
+
+// ScanKernel - Lists the programs, maps and links loaded in the kernel, including the ones loaded by other
+// applications, and flags the programs attached to the hook points of the probes of the manager. The scan is read-only,
+// it requires CAP_SYS_ADMIN. The result of the last scan is included in the dump of the manager, see GetDump.
+func (m *Manager) ScanKernel() (*KernelScan, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	ownedPrograms, ownedMaps, ownedLinks := m.ownedObjectIDs()
+	scan := &KernelScan{}
+	var id ebpf.ProgramID
+	for {
+		var err error
+		if id, err = ebpf.ProgramGetNextID(id); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't list the programs", err))
+		}
+		if program, ok := scanProgram(id); ok {
+			program.Owned = ownedPrograms[program.ID]
+			scan.Programs = append(scan.Programs, program)
+		}
+	}
+	var mapID ebpf.MapID
+	for {
+		var err error
+		if mapID, err = ebpf.MapGetNextID(mapID); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't list the maps", err))
+		}
+		if kernelMap, ok := scanMap(mapID); ok {
+			kernelMap.Owned = ownedMaps[kernelMap.ID]
+			scan.Maps = append(scan.Maps, kernelMap)
+		}
+	}
+	var linkID uint32
+	for {
+		var err error
+		if linkID, err = linkGetNextID(linkID); err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				break
+			}
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't list the links", err))
+		}
+		if kernelLink, ok := scanLink(linkID); ok {
+			kernelLink.Owned = ownedLinks[kernelLink.ID]
+			scan.Links = append(scan.Links, kernelLink)
+		}
+	}
+	scan.Conflicts = m.attachConflicts(scan, ownedPrograms)
+	m.kernelScan.Store(scan)
+	return scan, nil
+}
+
+// ownedObjectIDs - Returns the IDs of the programs, maps and links of the manager
+func (m *Manager) ownedObjectIDs() (programs, maps, links map[uint32]bool) {
+	programs, maps, links = make(map[uint32]bool), make(map[uint32]bool), make(map[uint32]bool)
+	for _, prog := range m.collection.Programs {
+		if info, err := prog.Info(); err == nil {
+			id, _ := info.ID()
+			programs[uint32(id)] = true
+		}
+	}
+	for _, array := range m.collection.Maps {
+		if info, err := array.Info(); err == nil {
+			id, _ := info.ID()
+			maps[uint32(id)] = true
+		}
+	}
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		if probe.program != nil {
+			if info, err := probe.program.Info(); err == nil {
+				id, _ := info.ID()
+				programs[uint32(id)] = true
+			}
+		}
+		if probe.link != nil {
+			if info, err := probe.link.Info(); err == nil {
+				links[uint32(info.ID)] = true
+			}
+		}
+		probe.stateLock.RUnlock()
+	}
+	return programs, maps, links
+}
+
+// scanProgram - Returns the description of the program with the provided ID, if it still exists
+func scanProgram(id ebpf.ProgramID) (KernelProgram, bool) {
+	prog, err := ebpf.NewProgramFromID(id)
+	if err != nil {
+		return KernelProgram{}, false
+	}
+	defer prog.Close()
+	info, err := prog.Info()
+	if err != nil {
+		return KernelProgram{}, false
+	}
+	return KernelProgram{ID: uint32(id), Name: info.Name, Type: info.Type.String(), Tag: info.Tag}, true
+}
+
+// scanMap - Returns the description of the map with the provided ID, if it still exists
+func scanMap(id ebpf.MapID) (KernelMap, bool) {
+	array, err := ebpf.NewMapFromID(id)
+	if err != nil {
+		return KernelMap{}, false
+	}
+	defer array.Close()
+	info, err := array.Info()
+	if err != nil {
+		return KernelMap{}, false
+	}
+	return KernelMap{ID: uint32(id), Name: info.Name, Type: info.Type.String()}, true
+}
+
+// linkGetNextID - Returns the ID of the link following the provided one, or ENOENT
+func linkGetNextID(id uint32) (uint32, error) {
+	// union bpf_attr, get next id variant
+	attr := struct {
+		startID uint32
+		nextID  uint32
+	}{startID: id}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_GET_NEXT_ID, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errno
+	}
+	return attr.nextID, nil
+}
+
+// scanLink - Returns the description of the link with the provided ID, if it still exists
+func scanLink(id uint32) (KernelLink, bool) {
+	// union bpf_attr, get fd by id variant
+	attr := struct {
+		id        uint32
+		nextID    uint32
+		openFlags uint32
+	}{id: id}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_GET_FD_BY_ID, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return KernelLink{}, false
+	}
+	defer unix.Close(int(fd))
+
+	// struct bpf_link_info, xdp variant
+	var info struct {
+		linkType uint32
+		id       uint32
+		progID   uint32
+		_        uint32
+		ifindex  uint32
+		_        [28]byte
+	}
+	infoAttr := struct {
+		bpfFD   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFD:   uint32(fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	if _, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&infoAttr)), unsafe.Sizeof(infoAttr)); errno != 0 {
+		return KernelLink{}, false
+	}
+	kernelLink := KernelLink{ID: info.id, Type: linkTypeName(link.Type(info.linkType)), ProgramID: info.progID}
+	if link.Type(info.linkType) == link.XDPType {
+		kernelLink.Ifindex = info.ifindex
+	}
+	return kernelLink, true
+}
+
+// attachConflicts - Returns the programs of other applications attached to the interfaces of the XDP probes of the
+// manager, with an XDP link or with netlink
+func (m *Manager) attachConflicts(scan *KernelScan, ownedPrograms map[uint32]bool) []AttachConflict {
+	var conflicts []AttachConflict
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		isXDP := probe.programSpec != nil && probe.programSpec.Type == ebpf.XDP && probe.Ifindex != 0
+		id, ifindex, netnsPath := probe.GetIdentificationPair(), probe.Ifindex, probe.NetnsPath
+		probe.stateLock.RUnlock()
+		if !isXDP {
+			continue
+		}
+		reported := make(map[uint32]bool)
+		for _, kernelLink := range scan.Links {
+			// the links of other network namespaces can't be told apart by their ifindex
+			if netnsPath == "" && kernelLink.Ifindex == uint32(ifindex) && !kernelLink.Owned && !ownedPrograms[kernelLink.ProgramID] {
+				conflicts = append(conflicts, AttachConflict{
+					Probe:     id,
+					ProgramID: kernelLink.ProgramID,
+					LinkID:    kernelLink.ID,
+					Reason:    fmt.Sprintf("XDP link on interface %d", ifindex),
+				})
+				reported[kernelLink.ProgramID] = true
+			}
+		}
+		var attached uint32
+		_ = runInNetns(netnsPath, func() error {
+			nlink, err := netlink.LinkByIndex(int(ifindex))
+			if err == nil && nlink.Attrs().Xdp != nil {
+				attached = nlink.Attrs().Xdp.ProgId
+			}
+			return err
+		})
+		if attached != 0 && !ownedPrograms[attached] && !reported[attached] {
+			conflicts = append(conflicts, AttachConflict{
+				Probe:     id,
+				ProgramID: attached,
+				Reason:    fmt.Sprintf("XDP program attached to interface %d with netlink", ifindex),
+			})
+		}
+	}
+	return conflicts
+}
+
+// lastKernelScan - Returns the result of the last call to ScanKernel, if any
+func (m *Manager) lastKernelScan() *KernelScan {
+	scan, _ := m.kernelScan.Load().(*KernelScan)
+	return scan
+}
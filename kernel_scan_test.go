@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+)
+
+func TestScanKernel(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	spec := &ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, xdpPass),
+			asm.Return(),
+		},
+	}
+	owned, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// another application attached an XDP program to the interface of the probe
+	err = runInNetns(path, func() error {
+		lo, err := netlink.LinkByIndex(1)
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetXdpFdWithFlags(lo, other.FD(), int(XdpAttachModeSkb))
+	})
+	if err != nil {
+		t.Skipf("couldn't attach an XDP program: %v", err)
+	}
+
+	m := &Manager{
+		collection: &ebpf.Collection{Programs: map[string]*ebpf.Program{"xdp_pass": owned}},
+		Probes:     []*Probe{{EbpfFuncName: "xdp_pass", programSpec: spec, Ifindex: 1, NetnsPath: path}},
+	}
+	defer m.collection.Close()
+	if _, err = m.ScanKernel(); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized, got %v", err)
+	}
+	m.state = initialized
+	scan, err := m.ScanKernel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ownedInfo, _ := owned.Info()
+	ownedID, _ := ownedInfo.ID()
+	otherInfo, _ := other.Info()
+	otherID, _ := otherInfo.ID()
+	found := 0
+	for _, program := range scan.Programs {
+		if program.ID == uint32(ownedID) && program.Owned || program.ID == uint32(otherID) && !program.Owned {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("expected the programs of the manager and of the other application, got %+v", scan.Programs)
+	}
+	if len(scan.Conflicts) != 1 || scan.Conflicts[0].ProgramID != uint32(otherID) {
+		t.Fatalf("expected the XDP program of the other application to conflict, got %+v", scan.Conflicts)
+	}
+
+	// the findings are included in the dump
+	dump, err := m.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump, fmt.Sprintf("program %d: XDP program attached", otherID)) {
+		t.Errorf("expected the conflict in the dump, got %s", dump)
+	}
+}
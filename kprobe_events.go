@@ -0,0 +1,184 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// KprobeAttachMethod - Method used to attach a kprobe or a kretprobe
+type KprobeAttachMethod int
+
+const (
+	// AttachKprobeMethodNotSet - The probe isn't a kprobe, or it wasn't attached yet
+	AttachKprobeMethodNotSet KprobeAttachMethod = iota
+	// AttachKprobeWithBPFLink - perf_event_open based kprobe, attached with a bpf_link (kernel 5.15+)
+	AttachKprobeWithBPFLink
+	// AttachKprobeWithPerfEventOpen - perf_event_open based kprobe, attached with the PERF_EVENT_IOC_SET_BPF ioctl
+	AttachKprobeWithPerfEventOpen
+	// AttachKprobeWithKprobeEvents - kprobe created by the manager through the legacy kprobe_events interface of tracefs
+	AttachKprobeWithKprobeEvents
+	// AttachKprobeWithKprobeMulti - kprobe_multi link shared by all the functions matching Probe.MatchFuncName (kernel
+	// 5.18+)
+	AttachKprobeWithKprobeMulti
+)
+
+func (m KprobeAttachMethod) String() string {
+	switch m {
+	case AttachKprobeMethodNotSet:
+		return "not set"
+	case AttachKprobeWithBPFLink:
+		return "bpf_link"
+	case AttachKprobeWithPerfEventOpen:
+		return "perf_event_open"
+	case AttachKprobeWithKprobeEvents:
+		return "kprobe_events"
+	case AttachKprobeWithKprobeMulti:
+		return "kprobe_multi"
+	default:
+		return fmt.Sprintf("KprobeAttachMethod(%d)", int(m))
+	}
+}
+
+// kprobeEventsGroup - Group of the kprobe events created by the manager
+const kprobeEventsGroup = "ebpfmanager"
+
+// kprobeEventsCounter - Makes the names of the kprobe events created by the process unique
+var kprobeEventsCounter uint64
+
+var (
+	// tracefsRoots - Mount points of tracefs, in order of preference. The mount points of the tracefs mounted by the
+	// managers come first, see Options.MountTraceFS.
+	tracefsRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+	tracefsLock  sync.RWMutex
+)
+
+// kprobeEvent - Kprobe created through the legacy kprobe_events interface of tracefs, and attached with the
+// PERF_EVENT_IOC_SET_BPF ioctl
+type kprobeEvent struct {
+	tracefs string
+	name    string
+	fd      int
+}
+
+// tracefsRoot - Returns the mount point of tracefs that exposes the kprobe_events interface
+func tracefsRoot() (string, error) {
+	tracefsLock.RLock()
+	defer tracefsLock.RUnlock()
+	for _, root := range tracefsRoots {
+		if _, err := os.Stat(filepath.Join(root, "kprobe_events")); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("kprobe_events not found in %s: %w", strings.Join(tracefsRoots, ", "), link.ErrNotSupported)
+}
+
+// kprobeEventName - Returns a unique kprobe event name for the provided symbol
+func kprobeEventName(symbol string, isRet bool) string {
+	prefix := "p"
+	if isRet {
+		prefix = "r"
+	}
+	sanitized := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, symbol)
+	// the PID and the start time of the process identify the events left by a crashed process, see
+	// CleanupStaleTracefsEvents
+	return fmt.Sprintf("%s_%s_%d_%d_%d", prefix, sanitized, os.Getpid(), selfStartTime(), atomic.AddUint64(&kprobeEventsCounter, 1))
+}
+
+// attachKprobeEvent - Creates a kprobe on the provided symbol through the kprobe_events interface, and attaches the
+// provided program to it. A kretprobe is created if isRet is set, with maxActive instances if it isn't 0.
+func attachKprobeEvent(prog *ebpf.Program, symbol string, isRet bool, maxActive int) (*kprobeEvent, error) {
+	tracefs, err := tracefsRoot()
+	if err != nil {
+		return nil, err
+	}
+	event := &kprobeEvent{tracefs: tracefs, name: kprobeEventName(symbol, isRet), fd: -1}
+
+	probeType := "p"
+	if isRet {
+		probeType = "r"
+		if maxActive > 0 {
+			probeType = fmt.Sprintf("r%d", maxActive)
+		}
+	}
+	if err = event.write(fmt.Sprintf("%s:%s/%s %s", probeType, kprobeEventsGroup, event.name, symbol)); err != nil {
+		return nil, fmt.Errorf("couldn't create kprobe event on %s: %w", symbol, err)
+	}
+
+	if err = event.open(prog); err != nil {
+		_ = event.Close()
+		return nil, err
+	}
+	return event, nil
+}
+
+// write - Writes the provided line to kprobe_events
+func (e *kprobeEvent) write(line string) error {
+	f, err := os.OpenFile(filepath.Join(e.tracefs, "kprobe_events"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return err
+}
+
+// open - Opens the perf event of the kprobe event and attaches the provided program to it
+func (e *kprobeEvent) open(prog *ebpf.Program) error {
+	raw, err := os.ReadFile(filepath.Join(e.tracefs, "events", kprobeEventsGroup, e.name, "id"))
+	if err != nil {
+		return fmt.Errorf("error:%v , %v", ErrKprobeIDNotExist, err)
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("error:%v , %v", ErrKprobeIDNotExist, err)
+	}
+
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_TRACEPOINT,
+		Config:      id,
+		Sample_type: unix.PERF_SAMPLE_RAW,
+		Sample:      1,
+		Wakeup:      1,
+	}
+	e.fd, err = unix.PerfEventOpen(&attr, -1, 0, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		e.fd = -1
+		return fmt.Errorf("couldn't open the perf event of kprobe event %s: %w", e.name, err)
+	}
+	if err = unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_SET_BPF, prog.FD()); err != nil {
+		return fmt.Errorf("couldn't attach the program to kprobe event %s: %w", e.name, err)
+	}
+	if err = unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		return fmt.Errorf("couldn't enable kprobe event %s: %w", e.name, err)
+	}
+	return nil
+}
+
+// Close - Detaches the program and removes the kprobe event
+func (e *kprobeEvent) Close() error {
+	var err error
+	if e.fd >= 0 {
+		_ = unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_DISABLE, 0)
+		err = unix.Close(e.fd)
+		e.fd = -1
+	}
+	if errTmp := e.write(fmt.Sprintf("-:%s/%s", kprobeEventsGroup, e.name)); errTmp != nil && !errors.Is(errTmp, os.ErrNotExist) {
+		err = ConcatErrors(err, fmt.Errorf("couldn't remove kprobe event %s: %w", e.name, errTmp))
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// DefaultMatchFuncMaxCount - Default maximum number of functions a probe with a MatchFuncName pattern can be attached
+// to, see Probe.MatchFuncMaxCount
+const DefaultMatchFuncMaxCount = 1000
+
+// KprobeAttachment - (kprobes) Result of the attachment of a probe to a function matching Probe.MatchFuncName, see
+// Probe.GetKprobeAttachments
+type KprobeAttachment struct {
+	// FuncName - Kernel function of the kprobe
+	FuncName string
+
+	// Method - Method used to attach the kprobe, if it was attached
+	Method KprobeAttachMethod
+
+	// Err - Error returned when the kprobe was attached to the function, if any
+	Err error
+}
+
+// matchedKprobe - (kprobes) Kprobe attached to a function matching Probe.MatchFuncName
+type matchedKprobe struct {
+	funcName string
+	link     link.Link
+	event    *kprobeEvent
+	method   KprobeAttachMethod
+	err      error
+}
+
+// Close - Detaches the kprobe from its function
+func (k *matchedKprobe) Close() error {
+	var err error
+	if k.link != nil {
+		err = k.link.Close()
+	}
+	if k.event != nil {
+		err = ConcatErrors(err, k.event.Close())
+	}
+	return err
+}
+
+// matchKernelSymbols - Returns the names of the text symbols of symFile that match pattern and none of the exclude
+// patterns, sorted by name. Returns an error if more than max symbols match.
+func matchKernelSymbols(pattern string, exclude []string, max int, symFile string) ([]string, error) {
+	fnRegex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , invalid pattern %s", err, pattern))
+	}
+	var excludeRegexes []*regexp.Regexp
+	for _, excludePattern := range exclude {
+		excludeRegex, err := regexp.Compile(excludePattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error:%v , invalid exclude pattern %s", err, excludePattern))
+		}
+		excludeRegexes = append(excludeRegexes, excludeRegex)
+	}
+	symbols, err := readKernelSymbols(symFile)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+symbols:
+	for _, symbol := range symbols {
+		if seen[symbol.name] || !fnRegex.MatchString(symbol.name) {
+			continue
+		}
+		seen[symbol.name] = true
+		for _, excludeRegex := range excludeRegexes {
+			if excludeRegex.MatchString(symbol.name) {
+				continue symbols
+			}
+		}
+		names = append(names, symbol.name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("error:%w , pattern %s", ErrNoMatchingFunction, pattern)
+	}
+	if max > 0 && len(names) > max {
+		return nil, fmt.Errorf("error:%w , %d functions match %s, the maximum is %d", ErrTooManyMatches, len(names), pattern, max)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// resolveKprobeMatching - (kprobes) Lists the kernel functions matching MatchFuncName
+func (p *Probe) resolveKprobeMatching() error {
+	maxCount := p.MatchFuncMaxCount
+	if maxCount == 0 {
+		maxCount = DefaultMatchFuncMaxCount
+	}
+	names, err := matchKernelSymbols(p.MatchFuncName, p.MatchFuncExclude, maxCount, p.manager.options.SymFile)
+	if err != nil {
+		return err
+	}
+	p.matchedFuncNames = names
+	return nil
+}
+
+// prepareKprobeMulti - Loads the programs of the kprobes with a MatchFuncName pattern as kprobe_multi programs when the
+// kernel supports kprobe_multi links. A kprobe_multi program can't be attached with a regular kprobe.
+func (m *Manager) prepareKprobeMulti() {
+	if m.options.DisableKprobeMulti {
+		return
+	}
+	for _, probe := range m.Probes {
+		if probe.MatchFuncName == "" || probe.programSpec == nil || probe.programSpec.Type != ebpf.Kprobe {
+			continue
+		}
+		if !strings.HasPrefix(probe.Section, "kprobe/") && !strings.HasPrefix(probe.Section, "kretprobe/") {
+			continue
+		}
+		if HaveKprobeMulti() != nil {
+			return
+		}
+		probe.programSpec.AttachType = ebpf.AttachTraceKprobeMulti
+	}
+}
+
+// attachKprobeMatching - Attaches the kprobe to every function matching MatchFuncName. The functions that can't be
+// probed (blacklisted or notrace functions) are skipped, the attachment fails only if none of them can be probed.
+func (p *Probe) attachKprobeMatching(isRet bool) error {
+	if p.programSpec.AttachType == ebpf.AttachTraceKprobeMulti {
+		return p.attachKprobeMulti(isRet)
+	}
+	var errs error
+	p.matchedKprobes = make([]*matchedKprobe, 0, len(p.matchedFuncNames))
+	for _, funcName := range p.matchedFuncNames {
+		kprobe := &matchedKprobe{funcName: funcName}
+		kprobe.link, kprobe.event, kprobe.method, kprobe.err = p.openKprobe(funcName, isRet)
+		if kprobe.err != nil && errs == nil {
+			errs = kprobe.err
+		}
+		p.matchedKprobes = append(p.matchedKprobes, kprobe)
+	}
+	for _, kprobe := range p.matchedKprobes {
+		if kprobe.err == nil {
+			return nil
+		}
+	}
+	_ = p.detachKprobeMatching()
+	return fmt.Errorf("error:%w , none of the %d functions matching %s can be probed: %v", ErrNoMatchingFunction, len(p.matchedFuncNames), p.MatchFuncName, errs)
+}
+
+// attachKprobeMulti - Attaches the kprobe to every function matching MatchFuncName with a single kprobe_multi link. The
+// link can't be created if one of the functions can't be probed: the functions are filtered with the
+// available_filter_functions list of tracefs when it is available.
+func (p *Probe) attachKprobeMulti(isRet bool) error {
+	funcNames := filterTraceableFunctions(p.matchedFuncNames)
+	if len(funcNames) == 0 {
+		return fmt.Errorf("error:%w , none of the functions matching %s can be traced", ErrNoMatchingFunction, p.MatchFuncName)
+	}
+	opts := link.KprobeMultiOptions{Symbols: funcNames}
+	if p.Cookie != 0 {
+		opts.Cookies = make([]uint64, len(funcNames))
+		for i := range opts.Cookies {
+			opts.Cookies[i] = p.Cookie
+		}
+	}
+	attach := link.KprobeMulti
+	if isRet {
+		attach = link.KretprobeMulti
+	}
+	l, err := attach(p.program, opts)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't attach the kprobe_multi link of %s to %d functions", err, p.GetIdentificationPair(), len(funcNames)))
+	}
+	p.link = l
+	p.kprobeAttachMethod = AttachKprobeWithKprobeMulti
+	p.matchedKprobes = make([]*matchedKprobe, 0, len(funcNames))
+	for _, funcName := range funcNames {
+		// the link is shared, it is closed with the probe
+		p.matchedKprobes = append(p.matchedKprobes, &matchedKprobe{funcName: funcName, method: AttachKprobeWithKprobeMulti})
+	}
+	return nil
+}
+
+// filterTraceableFunctions - Returns the provided functions that are listed in the available_filter_functions file of
+// tracefs, or all of them if the file can't be read
+func filterTraceableFunctions(names []string) []string {
+	root, err := tracefsRoot()
+	if err != nil {
+		return names
+	}
+	file, err := os.Open(filepath.Join(root, "available_filter_functions"))
+	if err != nil {
+		return names
+	}
+	defer file.Close()
+	traceable := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name [module]
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			traceable[fields[0]] = true
+		}
+	}
+	if scanner.Err() != nil || len(traceable) == 0 {
+		return names
+	}
+	var output []string
+	for _, name := range names {
+		if traceable[name] {
+			output = append(output, name)
+		}
+	}
+	return output
+}
+
+// detachKprobeMatching - Detaches the kprobes of the functions matching MatchFuncName
+func (p *Probe) detachKprobeMatching() error {
+	var err error
+	for _, kprobe := range p.matchedKprobes {
+		err = ConcatErrors(err, kprobe.Close())
+	}
+	p.matchedKprobes = nil
+	return err
+}
+
+// GetKprobeAttachments - (kprobes) Returns the result of the attachment of the probe to each function matching
+// MatchFuncName, sorted by function name
+func (p *Probe) GetKprobeAttachments() []KprobeAttachment {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	attachments := make([]KprobeAttachment, 0, len(p.matchedKprobes))
+	for _, kprobe := range p.matchedKprobes {
+		attachments = append(attachments, KprobeAttachment{FuncName: kprobe.funcName, Method: kprobe.method, Err: kprobe.err})
+	}
+	return attachments
+}
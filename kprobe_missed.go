@@ -0,0 +1,114 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// bpfLinkTypePerfEvent - BPF_LINK_TYPE_PERF_EVENT
+	bpfLinkTypePerfEvent = 7
+	// bpfPerfEventKprobe, bpfPerfEventKretprobe - BPF_PERF_EVENT_KPROBE and BPF_PERF_EVENT_KRETPROBE
+	bpfPerfEventKprobe    = 1
+	bpfPerfEventKretprobe = 2
+)
+
+// GetKretprobeMissCount - (kretprobes) Returns the number of times the kretprobe missed a return because more than
+// KProbeMaxActive instances of the function were running at the same time, or a kprobe missed a hit. Increase
+// KProbeMaxActive (or Options.DefaultKProbeMaxActive) if it grows with the workload. The counter is read from the
+// kprobe_profile file of tracefs for the kretprobes attached with AttachKprobeWithKprobeEvents, and from the bpf_link
+// of the kretprobe otherwise (kernel 6.7+).
+func (p *Probe) GetKretprobeMissCount() (uint64, error) {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	if p.state < running || p.programSpec == nil || p.programSpec.Type != ebpf.Kprobe {
+		return 0, fmt.Errorf("error:%w , probe %s isn't a running kprobe", ErrMissCountUnavailable, p.EbpfFuncName)
+	}
+	if p.kprobeEvent != nil {
+		return p.kprobeEvent.missCount()
+	}
+	if p.link != nil {
+		return linkMissCount(p.link)
+	}
+	return 0, fmt.Errorf("error:%w , probe %s", ErrMissCountUnavailable, p.EbpfFuncName)
+}
+
+// missCount - Returns the nmissed counter of the kprobe event, read from kprobe_profile
+func (e *kprobeEvent) missCount() (uint64, error) {
+	f, err := os.Open(filepath.Join(e.tracefs, "kprobe_profile"))
+	if err != nil {
+		return 0, fmt.Errorf("error:%w , %v", ErrMissCountUnavailable, err)
+	}
+	defer f.Close()
+
+	// event name, nhit, nmissed
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != e.name {
+			continue
+		}
+		missed, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("error:%v , couldn't parse the profile of kprobe event %s", err, e.name))
+		}
+		return missed, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("error:%w , kprobe event %s not found in kprobe_profile", ErrMissCountUnavailable, e.name)
+}
+
+// linkMissCount - Returns the missed counter of the kprobe of the provided perf event link, read from its link info
+func linkMissCount(l link.Link) (uint64, error) {
+	fdLink, ok := l.(interface{ FD() int })
+	if !ok {
+		return 0, fmt.Errorf("error:%w , the kprobe isn't attached with a bpf_link", ErrMissCountUnavailable)
+	}
+
+	// struct bpf_link_info, perf_event.kprobe variant
+	var info struct {
+		linkType      uint32
+		id            uint32
+		progID        uint32
+		_             uint32
+		perfEventType uint32
+		_             uint32
+		funcName      uint64
+		nameLen       uint32
+		offset        uint32
+		addr          uint64
+		missed        uint64
+		cookie        uint64
+	}
+	// union bpf_attr, info variant
+	attr := struct {
+		bpfFD   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFD:   uint32(fdLink.FD()),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, fmt.Errorf("error:%w , couldn't get the link info: %v", ErrMissCountUnavailable, errno)
+	}
+	// the kprobe info of perf event links, and its missed counter, are filled since kernel 6.7
+	if info.linkType != bpfLinkTypePerfEvent || (info.perfEventType != bpfPerfEventKprobe && info.perfEventType != bpfPerfEventKretprobe) {
+		return 0, fmt.Errorf("error:%w , the kernel doesn't report the kprobe of the link", ErrMissCountUnavailable)
+	}
+	return info.missed, nil
+}
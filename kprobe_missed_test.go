@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKprobeEventMissCount(t *testing.T) {
+	tracefs := t.TempDir()
+	profile := "  p_vfs_read_1_1                                            12               0\n" +
+		"  r_vfs_read_1_2                                           345              17\n"
+	if err := os.WriteFile(filepath.Join(tracefs, "kprobe_profile"), []byte(profile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &kprobeEvent{tracefs: tracefs, name: "r_vfs_read_1_2", fd: -1}
+	missed, err := event.missCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missed != 17 {
+		t.Errorf("expected 17 missed hits, got %d", missed)
+	}
+
+	event.name = "r_vfs_write_1_3"
+	if _, err = event.missCount(); !errors.Is(err, ErrMissCountUnavailable) {
+		t.Errorf("expected ErrMissCountUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// programKey - Returns the name of the program of the probe in the CollectionSpec
+func (p *Probe) programKey() string {
+	if p.CopyProgram {
+		return p.EbpfFuncName + p.UID
+	}
+	return p.EbpfFuncName
+}
+
+// lazyPrograms - Returns the programs of the CollectionSpec used only by probes that set LazyLoad or WaitForModule
+func (m *Manager) lazyPrograms() map[string]bool {
+	eager := make(map[string]bool)
+	for _, probe := range m.Probes {
+		if !probe.loadsLazily() {
+			eager[probe.programKey()] = true
+		}
+	}
+	lazy := make(map[string]bool)
+	for _, probe := range m.Probes {
+		if probe.loadsLazily() && !eager[probe.programKey()] {
+			lazy[probe.programKey()] = true
+		}
+	}
+	return lazy
+}
+
+// collectionLoadSpec - Returns the CollectionSpec loaded at Init: the CollectionSpec of the manager without the
+// programs of the lazy probes. The CollectionSpec of the manager is left untouched, so that the lazy probes can load
+// their programs later.
+func (m *Manager) collectionLoadSpec() *ebpf.CollectionSpec {
+	lazy := m.lazyPrograms()
+	if len(lazy) == 0 {
+		return m.collectionSpec
+	}
+	spec := *m.collectionSpec
+	spec.Programs = make(map[string]*ebpf.ProgramSpec, len(m.collectionSpec.Programs))
+	for name, program := range m.collectionSpec.Programs {
+		if !lazy[name] {
+			spec.Programs[name] = program
+		}
+	}
+	return &spec
+}
+
+// prepareLazyLoad - (LazyLoad) Prepares a copy of the program spec of the probe to be loaded on its own, against the
+// maps of the collection. Does nothing if the program was loaded with the collection.
+func (p *Probe) prepareLazyLoad() error {
+	if _, loaded := p.manager.collection.Programs[p.programKey()]; loaded || p.programSpec == nil {
+		return nil
+	}
+	spec := p.programSpec.Copy()
+	for name, array := range p.manager.collection.Maps {
+		if err := spec.Instructions.AssociateMap(name, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+			return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with %v", err, name, p.GetIdentificationPair()))
+		}
+	}
+	p.programSpec = spec
+	p.manualLoadNeeded = true
+	p.checkPin = true
+	return nil
+}
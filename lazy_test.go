@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestLazyLoad(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newProgram := func(name string, ret int32) *ebpf.ProgramSpec {
+		return &ebpf.ProgramSpec{
+			Name:        name,
+			Type:        ebpf.SocketFilter,
+			SectionName: "socket/" + name,
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, ret),
+				asm.Return(),
+			},
+		}
+	}
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"eager": newProgram("eager", 1),
+		"lazy":  newProgram("lazy", 2),
+	}}
+	m := &Manager{Probes: []*Probe{
+		{Section: "socket/eager", EbpfFuncName: "eager"},
+		{Section: "socket/lazy", EbpfFuncName: "lazy", LazyLoad: true, ProbeGroup: []string{"lazy"}},
+	}}
+	options := Options{ActivatedProbes: []ProbesSelector{&ProbeSelector{ProbeIdentificationPair: ProbeIdentificationPair{EbpfFuncName: "eager"}}}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	// the program of the disabled lazy probe isn't loaded at Init
+	if _, ok := m.collection.Programs["lazy"]; ok {
+		t.Fatal("expected the lazy program not to be loaded at Init")
+	}
+	if _, ok := m.collectionSpec.Programs["lazy"]; !ok {
+		t.Fatal("expected the CollectionSpec of the lazy program to be retained")
+	}
+	lazy := m.Probes[1]
+	if lazy.Program() != nil {
+		t.Fatal("expected the lazy probe not to have a program")
+	}
+
+	// the program is loaded when the probe is activated
+	if err := m.ActivateGroup("lazy"); err != nil {
+		t.Fatal(err)
+	}
+	if lazy.Program() == nil {
+		t.Fatal("expected the lazy program to be loaded on activation")
+	}
+	ret, _, err := lazy.Test(make([]byte, 14))
+	if err != nil {
+		t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+	}
+	if ret != 2 {
+		t.Errorf("expected the lazy program to return 2, got %d", ret)
+	}
+}
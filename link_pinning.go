@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// adoptPinnedLink - (not thread safe, AdoptPinnedLinks) Adopts the link pinned at the link pin path of the probe by a
+// previous instance of the manager, instead of attaching the program again. Returns false if there is no such link, or
+// if it doesn't match the probe: the incompatible links are unpinned, which detaches them.
+func (p *Probe) adoptPinnedLink() bool {
+	if p.linkPinPath == "" || p.manager == nil || (!p.manager.options.AdoptPinnedLinks && p.manager.loadedState == nil) {
+		return false
+	}
+	if _, err := os.Stat(p.linkPinPath); err != nil {
+		return false
+	}
+	pinned, err := link.LoadPinnedLink(p.linkPinPath, nil)
+	if err != nil {
+		// not a link, the pin is replaced
+		return false
+	}
+	if err = p.checkPinnedLink(pinned); err != nil {
+		p.lastError = err
+		_ = pinned.Unpin()
+		_ = pinned.Close()
+		return false
+	}
+	p.link = pinned
+	return true
+}
+
+// checkPinnedLink - Returns an error if the provided pinned link doesn't attach the program of the probe to its hook
+// point. The program of the link is replaced with the program of the probe when the link supports it, otherwise the
+// program of the link must have the same instructions and maps as the program of the probe.
+func (p *Probe) checkPinnedLink(pinned link.Link) error {
+	info, err := pinned.Info()
+	if err != nil {
+		return err
+	}
+	if err = p.checkPinnedLinkTarget(info); err != nil {
+		return fmt.Errorf("error:%w , %v", ErrIncompatibleLink, err)
+	}
+	if info.Program == p.programID() {
+		return nil
+	}
+	err = pinned.Update(p.program)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, link.ErrNotSupported) && !errors.Is(err, unix.EOPNOTSUPP) && !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+	// the link can't be updated, it must already run an identical program
+	prog, err := ebpf.NewProgramFromID(info.Program)
+	if err != nil {
+		return err
+	}
+	defer prog.Close()
+	if !sameProgram(prog, p.program) {
+		return fmt.Errorf("error:%w , the link runs another program (ID %d)", ErrIncompatibleLink, info.Program)
+	}
+	return nil
+}
+
+// checkPinnedLinkTarget - Returns an error if the provided link doesn't target the hook point of the probe
+func (p *Probe) checkPinnedLinkTarget(info *link.Info) error {
+	expected, ok := expectedLinkType(p.programSpec)
+	if !ok || info.Type != expected {
+		return errors.New(fmt.Sprintf("link type %d doesn't match program type %s", info.Type, p.programSpec.Type))
+	}
+	switch {
+	case info.XDP() != nil:
+		if int32(info.XDP().Ifindex) != p.Ifindex {
+			return errors.New(fmt.Sprintf("the link targets interface %d instead of %d", info.XDP().Ifindex, p.Ifindex))
+		}
+	case info.Tracing() != nil:
+		if ebpf.AttachType(info.Tracing().AttachType) != p.programSpec.AttachType {
+			return errors.New(fmt.Sprintf("the link has attach type %s", ebpf.AttachType(info.Tracing().AttachType)))
+		}
+	case info.NetNs() != nil:
+		if ebpf.AttachType(info.NetNs().AttachType) != p.programSpec.AttachType {
+			return errors.New(fmt.Sprintf("the link has attach type %s", ebpf.AttachType(info.NetNs().AttachType)))
+		}
+		return p.checkNetNsLinkTarget(info.NetNs())
+	case info.Cgroup() != nil:
+		if ebpf.AttachType(info.Cgroup().AttachType) != p.programSpec.AttachType {
+			return errors.New(fmt.Sprintf("the link has attach type %s", ebpf.AttachType(info.Cgroup().AttachType)))
+		}
+		path, err := resolveCGroupPath(p.CGroupPath)
+		if err != nil {
+			return err
+		}
+		var stat unix.Stat_t
+		if err = unix.Stat(path, &stat); err != nil {
+			return err
+		}
+		// the ID of a cgroup v2 is the inode number of its directory
+		if info.Cgroup().CgroupId != stat.Ino {
+			return errors.New(fmt.Sprintf("the link targets cgroup %d instead of %s", info.Cgroup().CgroupId, path))
+		}
+	}
+	return nil
+}
+
+// expectedLinkType - Returns the type of the bpf_link that attaches a program of the provided spec
+func expectedLinkType(spec *ebpf.ProgramSpec) (link.Type, bool) {
+	switch spec.Type {
+	case ebpf.XDP:
+		return link.XDPType, true
+	case ebpf.RawTracepoint:
+		return link.RawTracepointType, true
+	case ebpf.Tracing:
+		if spec.AttachType == ebpf.AttachTraceIter {
+			return link.IterType, true
+		}
+		return link.TracingType, true
+	case ebpf.LSM:
+		return link.TracingType, true
+	case ebpf.CGroupDevice, ebpf.CGroupSKB, ebpf.CGroupSock, ebpf.SockOps, ebpf.CGroupSockAddr, ebpf.CGroupSockopt, ebpf.CGroupSysctl:
+		return link.CgroupType, true
+	case ebpf.SkLookup, ebpf.FlowDissector:
+		return link.NetNsType, true
+	default:
+		return link.UnspecifiedType, false
+	}
+}
+
+// programID - Returns the kernel ID of the program of the probe, 0 if it isn't available
+func (p *Probe) programID() ebpf.ProgramID {
+	if p.program == nil {
+		return 0
+	}
+	info, err := p.program.Info()
+	if err != nil {
+		return 0
+	}
+	id, _ := info.ID()
+	return id
+}
+
+// sameProgram - Returns true if the provided programs have the same type, instructions and maps
+func sameProgram(a, b *ebpf.Program) bool {
+	infoA, errA := a.Info()
+	infoB, errB := b.Info()
+	if errA != nil || errB != nil || infoA.Type != infoB.Type || infoA.Tag != infoB.Tag {
+		return false
+	}
+	mapsA, okA := infoA.MapIDs()
+	mapsB, okB := infoB.MapIDs()
+	if okA != okB || len(mapsA) != len(mapsB) {
+		return false
+	}
+	sort.Slice(mapsA, func(i, j int) bool { return mapsA[i] < mapsA[j] })
+	sort.Slice(mapsB, func(i, j int) bool { return mapsB[i] < mapsB[j] })
+	for i := range mapsA {
+		if mapsA[i] != mapsB[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,15 @@
 package manager
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"errors"
@@ -15,12 +19,18 @@ import (
 	"golang.org/x/sys/unix"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
 )
 
 // ConstantEditor - A constant editor tries to rewrite the value of a constant in a compiled eBPF program.
 //
 // Constant edition only works before the eBPF programs are loaded in the kernel, and therefore before the
 // Manager is started. If no program sections are provided, the manager will try to edit the constant in all eBPF programs.
+// Constants declared as global variables (volatile const, stored in .rodata) are rewritten with their BTF information
+// and are shared by all the programs, the other constants are rewritten in the instructions of each program. A global
+// constant can be given a different value per attachment: when all the ProbeIdentificationPairs of the editor select
+// probes with CopyProgram, each of those probes gets its own copy of the .rodata sections.
 type ConstantEditor struct {
 	// Name - Name of the constant to rewrite
 	Name string
@@ -33,14 +43,17 @@ type ConstantEditor struct {
 	FailOnMissing bool
 
 	// ProbeIdentificationPairs - Identifies the list of programs to edit. If empty, it will apply to all the programs
-	// of the manager. Will return an error if at least one edition failed.
+	// of the manager. Will return an error if at least one edition failed. Ignored for global variables, unless all the
+	// selected probes set CopyProgram.
 	ProbeIdentificationPairs []ProbeIdentificationPair
 }
 
 // TailCallRoute - A tail call route defines how tail calls should be routed between eBPF programs.
 //
 // The provided eBPF program will be inserted in the provided eBPF program array, at the provided key. The eBPF program
-// can be provided by its section or by its *ebpf.Program representation.
+// can be provided by its section, by its *ebpf.Program representation or by its file descriptor. The routes of
+// Options.TailCallRouter are applied when the manager is started, use Manager.UpdateTailCallRoutes and
+// Manager.DeleteTailCallRoutes to change them at runtime.
 type TailCallRoute struct {
 	// ProgArrayName - Name of the BPF_MAP_TYPE_PROG_ARRAY map as defined in its section SEC("maps/[ProgArray]")
 	ProgArrayName string
@@ -53,6 +66,10 @@ type TailCallRoute struct {
 
 	// Program - Program to insert in the ProgArray map
 	Program *ebpf.Program
+
+	// ProgramFD - File descriptor of the program to insert in the ProgArray map, for programs that weren't loaded with
+	// cilium/ebpf. Ignored if Program is set.
+	ProgramFD int
 }
 
 // MapRoute - A map route defines how multiple maps should be routed between eBPF programs.
@@ -82,6 +99,12 @@ const (
 	EditMaxEntries MapSpecEditorFlag = 1 << 2
 	EditFlags      MapSpecEditorFlag = 1 << 3
 	EditInnerMap   MapSpecEditorFlag = 1 << 4
+	// EditKeyValueSize - Edits the key and value sizes of the map. The BTF types of the key and value are dropped.
+	EditKeyValueSize MapSpecEditorFlag = 1 << 5
+	// EditNumaNode - Edits the NUMA node of the map, BPF_F_NUMA_NODE is added to the flags of the map
+	EditNumaNode MapSpecEditorFlag = 1 << 6
+	// EditName - Edits the name of the map in the kernel, the map is still selected by its name in the ELF file
+	EditName MapSpecEditorFlag = 1 << 7
 )
 
 // MapSpecEditor - A MapSpec editor defines how specific parameters of specific maps should be updated at runtime
@@ -93,8 +116,18 @@ type MapSpecEditor struct {
 	Type ebpf.MapType
 	// MaxEntries - Max Entries of the map.
 	MaxEntries uint32
+	// MaxEntriesPerCPU - (EditMaxEntries) When set, the max entries of the map are MaxEntriesPerCPU times the number
+	// of possible CPUs, so that the map scales with the machine. Overrides MaxEntries.
+	MaxEntriesPerCPU uint32
 	// Flags - Flags provided to the kernel during the loading process.
 	Flags uint32
+	// KeySize, ValueSize - (EditKeyValueSize) Sizes of the keys and values of the map.
+	KeySize   uint32
+	ValueSize uint32
+	// NumaNode - (EditNumaNode) NUMA node on which the memory of the map is allocated.
+	NumaNode uint32
+	// Name - (EditName) Name of the map in the kernel, at most 15 characters.
+	Name string
 	// EditorFlag - Use this flag to specify what fields should be updated. See MapSpecEditorFlag.
 	EditorFlag MapSpecEditorFlag
 
@@ -134,9 +167,13 @@ func (ps *ProbeSelector) RunValidator(manager *Manager) error {
 	if !ok {
 		return fmt.Errorf("probe not found: %s", ps.ProbeIdentificationPair)
 	}
-	if !p.IsRunning() && p.Enabled {
+	// the probes attached in the background are validated, see RetryPolicy
+	if !p.IsRunning() && p.Enabled && !p.isRetrying() {
 		return fmt.Errorf("error:%v, %s", p.GetLastError(), ps.ProbeIdentificationPair.String())
 	}
+	if p.skipReason != nil {
+		return fmt.Errorf("%s: is skipped: %v", ps.ProbeIdentificationPair.String(), p.skipReason)
+	}
 	if !p.Enabled {
 		return fmt.Errorf(
 			"%s: is disabled, add it to the activation list and check that it was not explicitly excluded by the manager options",
@@ -174,16 +211,59 @@ type Options struct {
 	// MapSpecEditor - Pre-loading MapSpec editors.
 	MapSpecEditors map[string]MapSpecEditor
 
+	// InstructionPatchers - Pre-loading instruction patchers, run on every program of the manager after the constant,
+	// MapSpec and map editors. See InstructionPatcher for more.
+	InstructionPatchers []InstructionPatcher
+
 	// VerifierOptions - Defines the log level of the verifier and the size of its log buffer. Set to 0 to disable
 	// logging and 1 to get a verbose output of the error. Increase the buffer size if the output is truncated.
 	VerifierOptions ebpf.CollectionOptions
 
+	// VerifierLogSizeStart - Size of the verifier log buffer of the first attempt to load the programs. Overrides
+	// VerifierOptions.Programs.LogSize when set.
+	VerifierLogSizeStart int
+
+	// OrderedDataHandler - Callback function called with the samples of the perf maps and ring buffers that enable
+	// OrderedStream, merged in timestamp order across all of them and across CPUs. The source is the name of the perf
+	// map or ring buffer, the CPU is -1 for the ring buffers. The samples are held in a bounded reorder buffer, see
+	// GetOrderedStreamDrops for the late samples.
+	OrderedDataHandler func(source string, CPU int, data []byte, manager *Manager)
+
+	// OrderedStreamWindow - (OrderedDataHandler) Amount of time a sample is held before being delivered. Defaults to
+	// DefaultReorderWindow.
+	OrderedStreamWindow time.Duration
+
+	// OrderedStreamBufferSize - (OrderedDataHandler) Maximum number of samples held in the reorder buffer. Defaults to
+	// DefaultReorderBufferSize.
+	OrderedStreamBufferSize int
+
+	// OrderedStreamLatePolicy - (OrderedDataHandler) Defines what happens to a sample older than a sample that was
+	// already delivered. Defaults to ReorderDropLate.
+	OrderedStreamLatePolicy ReorderLatePolicy
+
+	// EventGate - Map entry checked by the programs before they emit a sample. PauseEventStreams writes its Closed
+	// value so that the kernel stops emitting samples, and ResumeEventStreams its Open value.
+	EventGate *EventGate
+
+	// VerifyOnly - Dry-run mode: Init parses the ELF, applies the editors and loads every program with the verifier, but
+	// closes the programs and the maps right away. Nothing is pinned nor attached, and the manager is left
+	// uninitialized. The error of Init reports all the rejected programs. Meant for the CI checks that the programs
+	// verify on a matrix of kernels.
+	VerifyOnly bool
+
+	// VerifierLogSizeMax - When a program is rejected and its verifier log doesn't fit in the log buffer, the programs
+	// are loaded again with a log buffer twice as big, until the log fits or the buffer reaches VerifierLogSizeMax.
+	// The rejection is returned as a ProgramLoadError, which carries the verifier log. Disabled when 0.
+	VerifierLogSizeMax int
+
 	// MapEditors - External map editor. The provided eBPF maps will overwrite the maps of the Manager if their names
 	// match.
 	// This is particularly useful to share maps across Managers (and therefore across isolated eBPF programs), without
 	// having to use the MapRouter indirection. However this technique only works before the eBPF programs are loaded,
 	// and therefore before the Manager is started. The keys of the map are the names of the maps to edit, as defined
-	// in their sections SEC("maps/[name]").
+	// in their sections SEC("maps/[name]"). The provided maps must match the definitions of the maps they replace, and
+	// are not closed by the manager unless CleanExternalEdited is used. Use GetMap on the manager that owns a map to
+	// share it with another manager.
 	MapEditors map[string]*ebpf.Map
 
 	// MapRouter - External map routing. See MapRoute for more.
@@ -213,21 +293,237 @@ type Options struct {
 	// ProbeRetryDelay - Defines the delay to wait before a probe should retry to attach / detach on error.
 	DefaultProbeRetryDelay time.Duration
 
+	// DefaultRetryPolicy - Manager-level default value for the background attach retries of the probes, see
+	// Probe.RetryPolicy. Disabled when nil.
+	DefaultRetryPolicy *RetryPolicy
+
+	// HandleReleaseTimeout - Maximum time Stop waits for the handles acquired with AcquireMap and AcquireProgram to be
+	// released, before it invalidates them. Defaults to DefaultHandleReleaseTimeout, a negative value invalidates the
+	// outstanding handles right away.
+	HandleReleaseTimeout time.Duration
+
 	// RLimit - The maps & programs provided to the manager might exceed the maximum allowed memory lock.
 	// (RLIMIT_MEMLOCK) If a limit is provided here it will be applied when the manager is initialized.
 	RLimit *unix.Rlimit
 
+	// RemoveMemlock - When enabled, RLIMIT_MEMLOCK is removed when the manager is initialized, unless the kernel
+	// accounts the memory of the maps and programs to the memory cgroup of the process (kernel 5.11+, see
+	// HaveMemcgAccounting). Ignored if RLimit is set. See Manager.GetMemoryFootprint to budget this memory.
+	RemoveMemlock bool
+
 	// KeepKernelBTF - Defines if the kernel types defined in VerifierOptions.Programs.KernelTypes should be cleaned up
 	// once the manager is done using them. By default, the manager will clean them up to save up space. DISCLAIMER: if
 	// your program uses "manager.CloneProgram", you might want to enable "KeepKernelBTF". As a workaround, you can also
 	// try to strip as much as possible the content of "KernelTypes" to reduce the memory overhead.
 	KeepKernelBTF bool
+
+	// KernelTypes - Kernel BTF used to resolve the CO-RE relocations of the programs of the manager. This is useful on
+	// kernels that do not expose /sys/kernel/btf/vmlinux: ship your own BTF blob and provide it here. When set, it
+	// overrides VerifierOptions.Programs.KernelTypes. When unset, the kernel BTF is looked up as usual.
+	KernelTypes *btf.Spec
+
+	// ProbeFailureHandler - Callback function called when a probe couldn't be (re-)attached, or when a component of the
+	// manager stopped working because of a kernel error. The probe is nil when the failing component is not a probe,
+	// for example when a perf ring reader stopped on a fatal read error. The error names the failing component. The
+	// handler is called from a goroutine of the manager, in the order of the failures, and not from the operation that
+	// failed: it can call the methods of the manager, which wait until that operation released its locks.
+	ProbeFailureHandler func(probe *Probe, err error)
+
+	// ErrorChan - Channel on which the failures that happen after the manager started are reported, as typed errors
+	// naming the failing component: *ProbeAttachError, *ProbeDetachedError, *PerfReadError and *MapUpdateError. The
+	// errors are sent without blocking, use a buffered channel: the errors that don't fit are counted in
+	// Manager.DroppedErrors. The per component channels (PerfErrChan, RingBuffer.ErrChan) still receive their errors.
+	ErrorChan chan error
+
+	// SlowHandlerThreshold - When set, the runs of the handlers of the perf maps and of the ring buffers (DataHandler,
+	// EventHandler and BatchDataHandler) that take longer than this duration are counted in EventStats.SlowHandlers and
+	// reported to SlowHandlerCallback. A slow handler holds the reader of its perf map or ring buffer back, or an
+	// event worker with EventConcurrency, and ends up with samples lost by the kernel.
+	SlowHandlerThreshold time.Duration
+
+	// SlowHandlerCallback - (SlowHandlerThreshold) Callback function called with the map name, the CPU and the run time
+	// of the slow handlers. Called from the goroutine that ran the handler, it must not block.
+	SlowHandlerCallback func(warning SlowHandlerWarning)
+
+	// HandlerProfileLabels - When enabled, the handlers of the perf maps and of the ring buffers run with the pprof
+	// labels ebpf_map, ebpf_kind and ebpf_cpu, so that their samples can be told apart in the CPU profiles (go tool
+	// pprof -tagfocus ebpf_map=...). This costs an allocation per handler run.
+	HandlerProfileLabels bool
+
+	// PeriodicTasks - Tasks run periodically while the manager is running, see PeriodicTask and
+	// Manager.AddPeriodicTask
+	PeriodicTasks []PeriodicTask
+
+	// RunCleanup - (Manager.Run) Defines which maps are closed when Run returns, see MapCleanupType. Defaults to
+	// CleanAll.
+	RunCleanup MapCleanupType
+
+	// RunDrainTimeout - (Manager.Run) When set, Run keeps handling the samples left in the perf ring buffers and the
+	// ring buffers for at most this duration once the probes are detached, see StopWithContext
+	RunDrainTimeout time.Duration
+
+	// KernelTypesPath - Path to a BTF blob (raw BTF or ELF with a .BTF section, compressed or not, see
+	// RegisterDecompressor) to parse and use as KernelTypes. Ignored if KernelTypes is set.
+	KernelTypesPath string
+
+	// BTFSearchPaths - BTF files, or directories, in which the BTF of the running kernel is looked up when the kernel
+	// doesn't expose /sys/kernel/btf/vmlinux, and neither KernelTypes nor KernelTypesPath are set. A directory can hold
+	// <release>.btf or vmlinux-<release> files, or follow the layout of the BTFHub archive:
+	// <id>/<version_id>/<arch>/<release>.btf, where id and version_id come from /etc/os-release. The BTF files can be
+	// gzip compressed, the BTFHub tar archives have to be extracted.
+	BTFSearchPaths []string
+
+	// CORERelocationErrChan - Channel on which the CO-RE relocations that can't be resolved against the kernel BTF are
+	// reported, one error per relocation, when the programs of the manager couldn't be loaded. The errors wrap
+	// ErrCORERelocation and name the program, the instruction and its source line.
+	CORERelocationErrChan chan error
+
+	// BPFFSRoot - Mount point of the BPF filesystem in which the objects of the manager are pinned with the PinByName
+	// strategy, and in which CleanupPinnedObjects looks for stale pins. Defaults to DefaultBPFFSRoot.
+	BPFFSRoot string
+
+	// PinningStrategy - Defines how the maps, programs and links of the manager are pinned. Defaults to PinAbsolute.
+	PinningStrategy PinningStrategy
+
+	// PinPrefix - Prefix of the names of the pins created with the PinByName strategy. The prefix identifies the pins
+	// owned by the manager, it should be unique to the application.
+	PinPrefix string
+
+	// PinPathTemplate - (PinByTemplate) Template of the pin paths of the maps, programs and links of the manager, with
+	// the placeholders {root} (BPFFSRoot), {manager} (ManagerName), {version} (PinVersion), {kind} ("map", "prog" or
+	// "link") and {name}. Defaults to DefaultPinPathTemplate. {version} must be a directory for CollectPinGarbage.
+	PinPathTemplate string
+
+	// ManagerName - (PinByTemplate) Name of the manager in the pin paths, it should be unique to the application
+	ManagerName string
+
+	// PinVersion - (PinByTemplate) Version of the programs in the pin paths. Defaults to a hash of the programs and maps
+	// of the manager, so that the pins of two builds never collide.
+	PinVersion string
+
+	// CollectPinGarbage - (PinByTemplate) Removes the pins of the versions of the manager that stopped at Init, see
+	// Manager.CollectPinGarbage. The errors are reported on ErrorChan.
+	CollectPinGarbage bool
+
+	// TCCleanupStrategy - Defines how the TC classifiers of the manager are cleaned up when they are detached.
+	// Defaults to TCCleanupQdisc.
+	TCCleanupStrategy TCCleanupStrategy
+
+	// BPFTokenPath - Mount point of a BPF filesystem delegating BPF commands to unprivileged users through BPF tokens
+	// (kernel 6.9+, see the delegate_cmds, delegate_maps, delegate_progs and delegate_attachs mount options).
+	// RequiredCapabilities reports whether its delegation covers the maps and programs of the manager. Note that the
+	// vendored cilium/ebpf can't create maps and programs with a token yet, the process still needs CAP_BPF to Init.
+	BPFTokenPath string
+
+	// AdoptPinnedLinks - When enabled, the links pinned by a previous instance of the manager (see Probe.LinkPinPath and
+	// PinByName) are reused when the probes are attached, instead of attaching the programs again: the hook points
+	// aren't left unprobed across a restart. A pinned link is adopted if it targets the hook point of its probe, its
+	// program is then replaced with the program of the probe. The links that can't be updated (tracing programs) must
+	// already run the same instructions with the same maps, which requires the maps to be pinned as well. The other
+	// pinned links are unpinned and the probes attached again.
+	AdoptPinnedLinks bool
+
+	// DisableKprobeMulti - The kprobes with a MatchFuncName pattern are attached with a single kprobe_multi link when the
+	// kernel supports it (kernel 5.18+, see HaveKprobeMulti). When set, they are attached with one kprobe per function.
+	DisableKprobeMulti bool
+
+	// DisableUprobeMulti - The uprobes attached at the return instructions of a Go function (see
+	// Probe.UprobeGoReturns) are attached with a single uprobe_multi link when the kernel supports it (kernel 6.6+, see
+	// HaveUprobeMulti). When set, they are attached with one uprobe per return instruction.
+	DisableUprobeMulti bool
+
+	// MountBPFFS - Mounts a BPF filesystem at BPFFSRoot when the manager pins objects there and it isn't mounted, in
+	// minimal containers for example, and creates the directories of the pins. Requires CAP_SYS_ADMIN. The BPF
+	// filesystem is left mounted when the manager stops, so that the pins survive.
+	MountBPFFS bool
+
+	// MountTraceFS - Mounts tracefs at TraceFSRoot when the manager has kprobes or tracepoints and tracefs isn't
+	// mounted. Requires CAP_SYS_ADMIN. tracefs is left mounted when the manager stops. The mounts are made in the
+	// mount namespace of the process: start the process in a private mount namespace (unshare -m) to keep them away
+	// from the host, a multi-threaded Go process can't switch its own mount namespace.
+	MountTraceFS bool
+
+	// TraceFSRoot - (MountTraceFS) Mount point of the tracefs mounted by the manager. Defaults to DefaultTraceFSRoot.
+	TraceFSRoot string
+
+	// CleanupStalePins - Removes the pins left in BPFFSRoot by a previous instance of the manager when the manager is
+	// initialized, see CleanupPinnedObjects. Don't set it if the pinned maps should be reused across restarts.
+	CleanupStalePins bool
+
+	// EventConcurrency - Number of workers calling the DataHandler of the perf maps and ring buffers of the manager.
+	// When set, the readers hand the samples over to a bounded worker pool instead of calling DataHandler themselves,
+	// so that a slow handler doesn't stall them. Note that the samples are then delivered out of order if there is
+	// more than one worker. Disabled when 0.
+	EventConcurrency int
+
+	// EventQueueSize - (EventConcurrency) Maximum number of samples waiting for a worker. Defaults to
+	// DefaultEventQueueSize.
+	EventQueueSize int
+
+	// EventDropPolicy - (EventConcurrency) Defines what happens to a new sample when the queue is full. The samples
+	// dropped in userspace are counted in Manager.DroppedEvents, and in PerfMapStats.UserspaceDrops or
+	// RingBuffer.UserspaceDrops. Defaults to EventBlock.
+	EventDropPolicy EventDropPolicy
+
+	// ReaderPoolSize - Number of goroutines reading the perf maps and ring buffers of the manager, multiplexed over a
+	// shared epoll instance, instead of one goroutine per perf map and per ring buffer. This lowers the scheduling
+	// overhead of the managers with dozens of event maps on many-core machines. The perf maps are then read with one
+	// perf ring buffer per CPU opened by the manager (see PerfMapOptions.CPUs). The overwritable perf maps, the perf
+	// maps with a PollTimeout or defined on a BPF ring buffer, and the ring buffers substituted by a perf event array
+	// keep their own goroutine. A slow handler holds back the other maps serviced by its reader, see
+	// EventConcurrency. Disabled when 0.
+	ReaderPoolSize int
+
+	// HealthCheckInterval - Interval at which the manager checks that its running probes are still attached to their
+	// hook points, see Manager.CheckProbesHealth. A probe can silently stop working when the binary of a uprobe is
+	// replaced, when the interface of a TC classifier or of an XDP program is recreated, or when another tool removes
+	// its filter, its XDP program or its kprobe event. Disabled when 0.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckReattach - (HealthCheckInterval) Re-attaches the unhealthy probes. The probes that couldn't be
+	// re-attached are reported to the ProbeFailureHandler.
+	HealthCheckReattach bool
+
+	// ProbeHealthChan - (HealthCheckInterval) Channel on which the unhealthy probes are reported
+	ProbeHealthChan chan ProbeHealthEvent
+
+	// DebugFileDirectories - Directories in which the separate debug files of the binaries of the uprobes are looked
+	// up by build-id ([directory]/.build-id/xx/yyyy.debug), when a symbol isn't in the symbol tables of a binary.
+	// Defaults to DefaultDebugFileDirectory.
+	DebugFileDirectories []string
+
+	// DebugListenAddr - Address (host:port) on which the manager serves its debug endpoints over HTTP, while it is
+	// running: /probes, /maps, /stats (see GetProgramStats), /dump (see GetDump) and /objects (see GetObjectInfos). The
+	// responses are JSON encoded. Use Manager.DebugHandler to mount the endpoints on an existing HTTP server instead.
+	// Disabled when empty.
+	DebugListenAddr string
+
+	// Filters - Runtime filters of the programs (PID allowlist, process name denylist, port set, cgroup ID set...),
+	// materialized in their maps at Init. See FilterSpec.
+	Filters []FilterSpec
+
+	// Flags - Runtime flags shared with the programs, materialized in the FlagsMapName map at Init. See FlagSpec and
+	// Manager.Flags.
+	Flags []FlagSpec
+
+	// MapTypeFallbacks - Map types loaded in place of the map types that the running kernel doesn't support, applied
+	// after MapSpecEditors. Fallbacks are followed until a supported type is found, see DefaultMapTypeFallbacks and
+	// Manager.MapTypeSubstitutions. Disabled when nil.
+	MapTypeFallbacks map[ebpf.MapType]ebpf.MapType
+
+	// DegradeUnprivileged - When the process lacks CAP_BPF and CAP_SYS_ADMIN but the unprivileged users can use bpf()
+	// (kernel.unprivileged_bpf_disabled is 0), Init leaves out what requires privileges instead of failing: the probes
+	// whose program isn't a socket filter or a cgroup_skb program (CAP_NET_ADMIN), or uses a map that can't be created,
+	// are skipped like unsupported probes, and these maps are removed along with the perf maps that can't be read.
+	// See Manager.PrivilegeDegradations for what was left out.
+	DegradeUnprivileged bool
 }
 
 // netlinkCacheKey - (TC classifier programs only) Key used to recover the netlink cache of an interface
 type netlinkCacheKey struct {
-	Ifindex int32
-	Netns   uint64
+	Ifindex   int32
+	Netns     uint64
+	NetnsPath string
 }
 
 // netlinkCacheValue - (TC classifier programs only) Netlink socket and qdisc object used to update the classifiers of
@@ -235,6 +531,7 @@ type netlinkCacheKey struct {
 type netlinkCacheValue struct {
 	rtNetlink     *tc.Tc
 	schedClsCount int
+	qdiscCreated  bool
 }
 
 // Manager - Helper structure that manages multiple eBPF programs and maps
@@ -244,8 +541,74 @@ type Manager struct {
 	collection     *ebpf.Collection
 	options        Options
 	netlinkCache   map[netlinkCacheKey]*netlinkCacheValue
+	netlinkLock    sync.Mutex
 	state          state
 	stateLock      sync.RWMutex
+	perfMapShares  map[*ebpf.Map][]*PerfMap
+	perfMapRefLock sync.Mutex
+	eventPool      *eventPool
+	readerPool     *readerPool
+	droppedEvents  uint64
+	droppedErrors  uint64
+	runFatal       chan error
+	programStats   io.Closer
+	healthStop     chan struct{}
+	debugServer    *http.Server
+	xskLock        sync.Mutex
+	xskSockets     []*XSKSocket
+	kernelScan     atomic.Value
+	containerLock  sync.Mutex
+	containers     map[string]*containerWatch
+
+	// pinVersion - (PinByTemplate) Version of the pins of the manager, see GetPinVersion
+	pinVersion string
+
+	// mapSubstitutions - Map types substituted at Init, see Options.MapTypeFallbacks
+	mapSubstitutions []MapTypeSubstitution
+
+	// degradations - Probes and maps left out at Init, see Options.DegradeUnprivileged
+	degradations []PrivilegeDegradation
+
+	// retryStop, retryGroup - Background attach retries of the probes, see RetryPolicy
+	retryStop  chan struct{}
+	retryGroup sync.WaitGroup
+
+	// tasks, tasksCtx, tasksCancel, tasksGroup - Periodic tasks of the manager, see PeriodicTask
+	tasks       []PeriodicTask
+	tasksLock   sync.Mutex
+	tasksCtx    context.Context
+	tasksCancel context.CancelFunc
+	tasksGroup  sync.WaitGroup
+
+	// handles - Map and program handles acquired with AcquireMap and AcquireProgram
+	handles handleRegistry
+
+	// eventFence, streamsLock, streamsPaused, pausedStreams - Flow control of the event streams, see
+	// PauseEventStreams
+	eventFence    sync.RWMutex
+	streamsLock   sync.Mutex
+	streamsPaused bool
+	pausedStreams []pausableStream
+
+	// loadedState, stateSaved - State of a previous instance re-adopted at Init, and whether the state of this
+	// instance was saved, see SaveState and LoadState
+	loadedState *ManagerState
+	stateSaved  bool
+
+	// orderedStream, orderedStreamStop, orderedStreamDrops - Merged samples of the perf maps and ring buffers that
+	// enable OrderedStream, see Options.OrderedDataHandler
+	orderedStream      *reorderBuffer
+	orderedStreamStop  chan struct{}
+	orderedStreamDrops uint64
+
+	// failureLock, failures, deliveringFailures - Failures waiting to be handed over to Options.ProbeFailureHandler
+	failureLock        sync.Mutex
+	failures           []probeFailure
+	deliveringFailures bool
+
+	// updatedPrograms - Programs loaded by UpdateProbeProgram that aren't in the collection, closed along with the
+	// manager
+	updatedPrograms []*ebpf.Program
 
 	// Probes - List of probes handled by the manager
 	Probes []*Probe
@@ -256,6 +619,12 @@ type Manager struct {
 
 	// PerfMaps - List of perf ring buffers handled by the manager
 	PerfMaps []*PerfMap
+
+	// RingBuffers - List of BPF ring buffers handled by the manager
+	RingBuffers []*RingBuffer
+
+	// StructOps - List of struct_ops maps registered to the kernel by the manager
+	StructOps []*StructOps
 }
 
 // DumpMaps - Return a string containing human readable info about eBPF maps
@@ -293,14 +662,26 @@ func (m *Manager) DumpMaps(maps ...string) (string, error) {
 	}
 	// Look in the list of perf maps
 	for _, perfMap := range m.PerfMaps {
-		if perfMap.DumpHandler != nil && needDump(perfMap.Name) {
+		if !needDump(perfMap.Name) {
+			continue
+		}
+		if perfMap.DumpHandler != nil {
 			output.WriteString(perfMap.DumpHandler(perfMap, m))
+		} else if perfMap.KeepRecentSamples > 0 {
+			output.WriteString(dumpRecentSamples(perfMap, m))
+		}
+	}
+	// Look in the list of ring buffers
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.DumpHandler != nil && needDump(ringBuffer.Name) {
+			output.WriteString(ringBuffer.DumpHandler(ringBuffer, m))
 		}
 	}
 	return output.String(), nil
 }
 
-// GetMap - Return a pointer to the requested eBPF map
+// GetMap - Return a pointer to the requested eBPF map. The map is closed by Stop, use AcquireMap to share it with
+// goroutines that may outlive the manager.
 // name: name of the map, as defined by its section SEC("maps/[name]")
 func (m *Manager) GetMap(name string) (*ebpf.Map, bool, error) {
 	m.stateLock.RLock()
@@ -308,23 +689,75 @@ func (m *Manager) GetMap(name string) (*ebpf.Map, bool, error) {
 	if m.collection == nil || m.state < initialized {
 		return nil, false, ErrManagerNotInitialized
 	}
+	eBPFMap, ok := m.getMap(name)
+	return eBPFMap, ok, nil
+}
+
+// getMap - Thread unsafe version of GetMap, the manager must be initialized
+func (m *Manager) getMap(name string) (*ebpf.Map, bool) {
 	eBPFMap, ok := m.collection.Maps[name]
 	if ok {
-		return eBPFMap, true, nil
+		return eBPFMap, true
 	}
 	// Look in the list of maps
 	for _, managerMap := range m.Maps {
 		if managerMap.Name == name {
-			return managerMap.array, true, nil
+			return managerMap.array, true
 		}
 	}
 	// Look in the list of perf maps
 	for _, perfMap := range m.PerfMaps {
 		if perfMap.Name == name {
-			return perfMap.array, true, nil
+			return perfMap.array, true
 		}
 	}
-	return nil, false, nil
+	// Look in the list of ring buffers
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.Name == name {
+			return ringBuffer.array, true
+		}
+	}
+	return nil, false
+}
+
+// probeFailure - Failure queued for the ProbeFailureHandler
+type probeFailure struct {
+	probe *Probe
+	err   error
+}
+
+// dispatchFailure - Reports the terminal error of a component of the manager to the ProbeFailureHandler, if any. The
+// provided probe is nil when the failing component is not a probe. The failures are reported while the manager or the
+// probe might be locked: they are queued, and handed over to the ProbeFailureHandler by a goroutine of their own.
+func (m *Manager) dispatchFailure(probe *Probe, err error) {
+	m.reportError(err)
+	m.reportFatal(err)
+	if m == nil || m.options.ProbeFailureHandler == nil {
+		return
+	}
+	m.failureLock.Lock()
+	defer m.failureLock.Unlock()
+	m.failures = append(m.failures, probeFailure{probe: probe, err: err})
+	if !m.deliveringFailures {
+		m.deliveringFailures = true
+		go m.deliverFailures()
+	}
+}
+
+// deliverFailures - Calls the ProbeFailureHandler with the queued failures, in order, until the queue is empty
+func (m *Manager) deliverFailures() {
+	for {
+		m.failureLock.Lock()
+		if len(m.failures) == 0 {
+			m.deliveringFailures = false
+			m.failureLock.Unlock()
+			return
+		}
+		failure := m.failures[0]
+		m.failures = m.failures[1:]
+		m.failureLock.Unlock()
+		m.options.ProbeFailureHandler(failure.probe, failure.err)
+	}
 }
 
 // GetMapSpec - Return a pointer to the requested eBPF MapSpec. This is useful when duplicating a map.
@@ -350,6 +783,12 @@ func (m *Manager) GetMapSpec(name string) (*ebpf.MapSpec, bool, error) {
 			return perfMap.arraySpec, true, nil
 		}
 	}
+	// Look in the list of ring buffers
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.Name == name {
+			return ringBuffer.arraySpec, true, nil
+		}
+	}
 	return nil, false, nil
 }
 
@@ -382,7 +821,17 @@ func (m *Manager) GetPerfMap(name string) (*PerfMap, bool) {
 	return nil, false
 }
 
-// GetProgram - Return a pointer to the requested eBPF program
+// GetRingBuffer - Select a ring buffer by its name
+func (m *Manager) GetRingBuffer(name string) (*RingBuffer, bool) {
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.Name == name {
+			return ringBuffer, true
+		}
+	}
+	return nil, false
+}
+
+// GetProgram - Return a pointer to the requested eBPF program. The program is closed by Stop, see AcquireProgram.
 // section: section of the program, as defined by its section SEC("[section]")
 // id: unique identifier given to a probe. If UID is empty, then all the programs matching the provided section are
 // returned.
@@ -460,6 +909,124 @@ func (m *Manager) GetProbe(id ProbeIdentificationPair) (*Probe, bool) {
 	return nil, false
 }
 
+// ProbeStatus - Activation status of a probe, see Manager.GetProbesStatus
+type ProbeStatus struct {
+	ProbeIdentificationPair
+
+	// Enabled - True if the probe is activated
+	Enabled bool
+
+	// Running - True if the probe is attached to its hook point
+	Running bool
+
+	// SkipReason - Set if the probe was skipped because it can't work on the running kernel, see
+	// Probe.KernelVersionMin, Probe.KernelVersionMax and Probe.FeatureCheck, or because the process lacks the privileges
+	// it requires, see Options.DegradeUnprivileged
+	SkipReason error
+
+	// LastError - Last error that the probe encountered
+	LastError error
+
+	// Retrying - True if the probe is being attached in the background, see RetryPolicy
+	Retrying bool
+
+	// RetryAttempts - Number of background attach attempts made so far
+	RetryAttempts uint
+
+	// NextRetry - (Retrying) Time of the next background attach attempt
+	NextRetry time.Time
+}
+
+// status - Returns the activation status of the probe
+func (p *Probe) status() ProbeStatus {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return ProbeStatus{
+		ProbeIdentificationPair: p.GetIdentificationPair(),
+		Enabled:                 p.Enabled,
+		Running:                 p.state == running,
+		SkipReason:              p.skipReason,
+		LastError:               p.lastError,
+		Retrying:                p.retrying,
+		RetryAttempts:           p.retryAttempts,
+		NextRetry:               p.nextRetry,
+	}
+}
+
+// GetProbesStatus - Returns the activation status of the probes of the manager, including the probes that were
+// skipped on the running kernel
+func (m *Manager) GetProbesStatus() []ProbeStatus {
+	status := make([]ProbeStatus, 0, len(m.Probes))
+	for _, probe := range m.Probes {
+		status = append(status, probe.status())
+	}
+	return status
+}
+
+// GetProbeStatus - Returns the activation status of the requested probe
+func (m *Manager) GetProbeStatus(id ProbeIdentificationPair) (ProbeStatus, bool) {
+	probe, ok := m.GetProbe(id)
+	if !ok {
+		return ProbeStatus{}, false
+	}
+	return probe.status(), true
+}
+
+// GetProgramInfo - Returns the kernel info of the eBPF program of the requested probe
+func (m *Manager) GetProgramInfo(id ProbeIdentificationPair) (*ebpf.ProgramInfo, error) {
+	probe, ok := m.GetProbe(id)
+	if !ok {
+		return nil, fmt.Errorf("error:%w , couldn't find probe %v", ErrUnknownMatchFuncName, id)
+	}
+	if !probe.IsInitialized() || probe.program == nil {
+		return nil, fmt.Errorf("error:%w , probe %v", ErrProbeNotInitialized, id)
+	}
+	info, err := probe.program.Info()
+	if err != nil {
+		return nil, fmt.Errorf("error:%w , couldn't get info of probe %v", err, id)
+	}
+	return info, nil
+}
+
+// ListPrograms - Returns the identification pairs of the probes of the manager
+func (m *Manager) ListPrograms() []ProbeIdentificationPair {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	ids := make([]ProbeIdentificationPair, 0, len(m.Probes))
+	for _, probe := range m.Probes {
+		ids = append(ids, probe.GetIdentificationPair())
+	}
+	return ids
+}
+
+// ListMaps - Returns the sorted names of the maps of the manager, including the perf maps and the maps of the
+// collection that weren't declared in the manager
+func (m *Manager) ListMaps() []string {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	names := make(map[string]struct{})
+	if m.collection != nil {
+		for name := range m.collection.Maps {
+			names[name] = struct{}{}
+		}
+	}
+	for _, managerMap := range m.Maps {
+		names[managerMap.Name] = struct{}{}
+	}
+	for _, perfMap := range m.PerfMaps {
+		names[perfMap.Name] = struct{}{}
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		names[ringBuffer.Name] = struct{}{}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
 // Init - Initialize the manager.
 // elf: reader containing the eBPF bytecode
 func (m *Manager) Init(elf io.ReaderAt) error {
@@ -467,9 +1034,54 @@ func (m *Manager) Init(elf io.ReaderAt) error {
 }
 
 // InitWithOptions - Initialize the manager.
-// elf: reader containing the eBPF bytecode
+// elf: reader containing the eBPF bytecode, compressed or not (see RegisterDecompressor)
 // options: options provided to the manager to configure its initialization
 func (m *Manager) InitWithOptions(elf io.ReaderAt, options Options) error {
+	return m.initWithOptions(func() (*ebpf.CollectionSpec, error) {
+		decompressed, err := decompressAsset(elf)
+		if err != nil {
+			return nil, err
+		}
+		return ebpf.LoadCollectionSpecFromReader(decompressed)
+	}, options)
+}
+
+// InitTestMode - Initializes the perf maps and the ring buffers of the manager without a kernel, so that their
+// handlers can be unit tested: they must all be in TestMode, the probes and the maps are ignored. Start the perf maps
+// and the ring buffers, feed them with InjectSample or Replay, then Stop the manager to flush the pending batches and
+// wait for the handlers. See the managertest package for fakes built on top of it.
+func (m *Manager) InitTestMode(options Options) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.state > initialized {
+		return ErrManagerRunning
+	}
+	m.wg = &sync.WaitGroup{}
+	m.options = options
+	m.netlinkCache = make(map[netlinkCacheKey]*netlinkCacheValue)
+	for _, perfMap := range m.PerfMaps {
+		if !perfMap.TestMode {
+			return fmt.Errorf("error:%w , perf map %s", ErrNotTestMode, perfMap.Name)
+		}
+		if err := perfMap.Init(m); err != nil {
+			return err
+		}
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		if !ringBuffer.TestMode {
+			return fmt.Errorf("error:%w , ring buffer %s", ErrNotTestMode, ringBuffer.Name)
+		}
+		if err := ringBuffer.Init(m); err != nil {
+			return err
+		}
+	}
+	m.handles.open()
+	m.state = initialized
+	return nil
+}
+
+// initWithOptions - Initialize the manager with the CollectionSpec returned by the provided loader
+func (m *Manager) initWithOptions(loadSpec func() (*ebpf.CollectionSpec, error), options Options) error {
 	m.stateLock.Lock()
 	if m.state > initialized {
 		m.stateLock.Unlock()
@@ -488,18 +1100,47 @@ func (m *Manager) InitWithOptions(elf io.ReaderAt, options Options) error {
 		m.stateLock.Unlock()
 		return err
 	}
+	if err := m.setPeriodicTasks(m.options.PeriodicTasks); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
+
+	// set the pin paths of the maps and programs, and remove the pins of a previous instance if requested
+	if err := m.applyPinningStrategy(); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
+	m.applyLoadedState()
+	if m.options.CleanupStalePins && m.loadedState == nil {
+		if err := m.cleanupPinnedObjects(); err != nil {
+			m.stateLock.Unlock()
+			return err
+		}
+	}
 
 	// set resource limit if requested
 	if m.options.RLimit != nil {
 		err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, m.options.RLimit)
 		if err != nil {
+			m.stateLock.Unlock()
 			return errors.New(fmt.Sprintf("error:%v , couldn't adjust RLIMIT_MEMLOCK", err))
 		}
+	} else if m.options.RemoveMemlock {
+		if err := raiseMemlock(); err != nil {
+			m.stateLock.Unlock()
+			return err
+		}
 	}
 
-	// Load the provided elf buffer
+	// Resolve the kernel BTF used for CO-RE relocations
+	if err := m.loadKernelTypes(); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
+
+	// Load the provided eBPF objects
 	var err error
-	m.collectionSpec, err = ebpf.LoadCollectionSpecFromReader(elf)
+	m.collectionSpec, err = loadSpec()
 	if err != nil {
 		m.stateLock.Unlock()
 		return err
@@ -515,18 +1156,40 @@ func (m *Manager) InitWithOptions(elf io.ReaderAt, options Options) error {
 	for _, excludedMatchFun := range m.options.ExcludedEbpfFuncs {
 		delete(m.collectionSpec.Programs, excludedMatchFun)
 	}
+	// Replace the programs of the probes by their variant for the running kernel
+	if err := m.selectProbeVariants(); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
+	// Leave out what the process isn't allowed to load
+	if err := m.degradeUnprivileged(); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
 	// Match Maps and program specs
 	if err := m.matchSpecs(); err != nil {
 		m.stateLock.Unlock()
 		return err
 	}
+	if err := m.applyPinTemplate(); err != nil {
+		m.stateLock.Unlock()
+		return err
+	}
 	// Configure activated probes
 	m.activateProbes()
+	m.removeSkippedPrograms()
+	m.removeTargetedPrograms()
+	m.prepareKprobeMulti()
+	m.prepareUprobeMulti()
+	m.handles.open()
 	m.state = initialized
 	m.stateLock.Unlock()
 
-	// Edit program constants
-	if len(options.ConstantEditors) > 0 {
+	// Edit program constants, including the throttling constants of the probes
+	if editors := m.throttleConstantEditors(); len(editors) > 0 {
+		m.options.ConstantEditors = append(append([]ConstantEditor{}, options.ConstantEditors...), editors...)
+	}
+	if len(m.options.ConstantEditors) > 0 {
 		if err := m.editConstants(); err != nil {
 			return err
 		}
@@ -539,13 +1202,47 @@ func (m *Manager) InitWithOptions(elf io.ReaderAt, options Options) error {
 		}
 	}
 
+	// Fall back to the map types supported by the kernel
+	m.applyMapTypeFallbacks()
+
+	// Declare the maps of the filters and of the flags
+	if err := m.prepareFilters(); err != nil {
+		return err
+	}
+	if err := m.prepareFlags(); err != nil {
+		return err
+	}
+
 	// Edit program maps
 	if len(options.MapEditors) > 0 {
+		if err := m.checkMapEditors(options.MapEditors); err != nil {
+			return err
+		}
 		if err := m.editMaps(options.MapEditors); err != nil {
 			return err
 		}
 	}
 
+	// Patch program instructions
+	if err := m.patchInstructions(); err != nil {
+		return err
+	}
+
+	// Verify the programs without loading the manager
+	if options.VerifyOnly {
+		return m.verifyPrograms()
+	}
+
+	// Mount bpffs and tracefs if requested, for the minimal containers that don't mount them
+	if err := m.mountFilesystems(); err != nil {
+		return err
+	}
+	if m.hasTracefsProbes() {
+		if _, err := CleanupStaleTracefsEvents(); err != nil {
+			m.reportError(fmt.Errorf("error:%w , couldn't clean up the stale tracefs events", err))
+		}
+	}
+
 	// Load pinned maps and pinned programs to avoid loading them twice
 	if err := m.loadPinnedObjects(); err != nil {
 		return err
@@ -555,7 +1252,19 @@ func (m *Manager) InitWithOptions(elf io.ReaderAt, options Options) error {
 	if err := m.loadCollection(); err != nil {
 		return err
 	}
-	return nil
+
+	// Remove the pins of the previous versions
+	if m.options.CollectPinGarbage {
+		if _, err := m.collectPinGarbage(); err != nil {
+			m.reportError(fmt.Errorf("error:%w , couldn't collect the stale pins", err))
+		}
+	}
+
+	// Populate the filters and the flags with their initial values
+	if err := m.populateFilters(); err != nil {
+		return err
+	}
+	return m.populateFlags()
 }
 
 // Start - Attach eBPF programs, start perf ring readers and apply maps and tail calls routing.
@@ -576,21 +1285,63 @@ func (m *Manager) Start() error {
 	}
 	// clean up tracefs TODO 作用是什么？
 
+	// Start the event workers and the ordered stream before the readers
+	m.startEventPool()
+	m.startOrderedStream()
+	if err := m.startReaderPool(); err != nil {
+		m.stopOrderedStream()
+		m.stopEventPool()
+		m.stateLock.Unlock()
+		return err
+	}
+
 	// Start perf ring readers
 	for _, perfRing := range m.PerfMaps {
 		if err := perfRing.Start(); err != nil {
 			// Clean up
-			_ = m.stop(CleanInternal)
+			_ = m.stop(0, CleanInternal)
 			m.stateLock.Unlock()
 			return err
 		}
 	}
 
-	// Attach eBPF programs
-	for _, probe := range m.Probes {
-		// ignore the error, they are already collected per probes and will be surfaced by the
-		// activation validators if needed.
-		_ = probe.Attach()
+	// Start ring buffer readers
+	for _, ringBuffer := range m.RingBuffers {
+		if err := ringBuffer.Start(); err != nil {
+			// Clean up
+			_ = m.stop(0, CleanInternal)
+			m.stateLock.Unlock()
+			return err
+		}
+	}
+
+	// Attach eBPF programs, all or none of the mandatory ones
+	if err := m.attachProbes(); err != nil {
+		// Clean up
+		_ = m.stop(0, CleanInternal)
+		m.stateLock.Unlock()
+		return err
+	}
+
+	// Register struct_ops maps
+	if err := m.startStructOps(); err != nil {
+		// Clean up
+		_ = m.stop(0, CleanInternal)
+		m.stateLock.Unlock()
+		return err
+	}
+
+	// Watch the attachments of the probes
+	m.startHealthCheck()
+	m.startPeriodicTasks()
+	m.startMapPollers()
+
+	// Serve the debug endpoints
+	if err := m.startDebugServer(); err != nil {
+		// Clean up
+		_ = m.stop(0, CleanInternal)
+		m.stateLock.Unlock()
+		return err
 	}
 
 	m.state = running
@@ -622,63 +1373,156 @@ func (m *Manager) Start() error {
 		_ = m.Stop(CleanInternal)
 		return err
 	}
+
+	// Freeze maps now that they are populated
+	if err := m.freezeMaps(); err != nil {
+		// Clean up
+		_ = m.Stop(CleanInternal)
+		return err
+	}
+	return nil
+}
+
+// freezeMaps - Freezes the maps that requested it with FreezeAfterInit
+func (m *Manager) freezeMaps() error {
+	for _, managerMap := range m.Maps {
+		if !managerMap.FreezeAfterInit {
+			continue
+		}
+		if err := managerMap.FreezeMap(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Stop - Detach all eBPF programs and stop perf ring readers. The cleanup parameter defines which maps should be closed.
 // See MapCleanupType for mode.
 func (m *Manager) Stop(cleanup MapCleanupType) error {
+	return m.StopWithTimeout(0, cleanup)
+}
+
+// StopWithTimeout - Detach all eBPF programs and stop perf ring readers. Probes, perf maps and maps are stopped
+// concurrently, and each of them is given at most timeout to shut down. The returned error names every component that
+// failed or timed out. A timeout of 0 waits forever. The cleanup parameter defines which maps should be closed. See
+// MapCleanupType for mode.
+func (m *Manager) StopWithTimeout(timeout time.Duration, cleanup MapCleanupType) error {
 	m.stateLock.RLock()
 	defer m.stateLock.RUnlock()
 	if m.state < initialized {
 		return ErrManagerNotInitialized
 	}
-	return m.stop(cleanup)
+	return m.stop(timeout, cleanup)
 }
 
-func (m *Manager) stop(cleanup MapCleanupType) error {
+func (m *Manager) stop(timeout time.Duration, cleanup MapCleanupType) error {
 	var err error
+	var errLock sync.Mutex
+	var stopGroup sync.WaitGroup
+	stopComponent := func(stopFunc func() error, format string, args ...interface{}) {
+		stopGroup.Add(1)
+		go func() {
+			defer stopGroup.Done()
+			if e := runWithTimeout(timeout, stopFunc); e != nil {
+				errLock.Lock()
+				err = multierror.Append(err, fmt.Errorf("error:%w , "+format, append([]interface{}{e}, args...)...))
+				errLock.Unlock()
+			}
+		}()
+	}
 
-	// Stop perf ring readers
-	for _, perfRing := range m.PerfMaps {
-		e := perfRing.Stop(cleanup)
-		if e != nil {
-			e = errors.New(fmt.Sprintf("error:%v , perf ring reader %s couldn't gracefully shut down", perfRing.Stop(cleanup), perfRing.Name))
-		}
-		err = ConcatErrors(err, e)
+	// Let the handlers blocked by PauseEventStreams run, so that the readers can shut down
+	m.releaseEventFence()
+
+	// Stop the health check and the periodic tasks before the probes are detached
+	m.stopHealthCheck()
+	m.stopPeriodicTasks()
+	m.stopMapPollers()
+	m.stopAttachRetries()
+	m.stopContainerWatches()
+	if e := m.stopDebugServer(); e != nil {
+		err = multierror.Append(err, fmt.Errorf("error:%w , couldn't stop the debug server", e))
 	}
 
-	// Detach eBPF programs
+	// Stop perf ring readers and detach eBPF programs
+	for _, perfRing := range m.PerfMaps {
+		perfRing := perfRing
+		stopComponent(func() error {
+			return perfRing.Stop(cleanup)
+		}, "perf ring reader %s couldn't gracefully shut down", perfRing.Name)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		ringBuffer := ringBuffer
+		stopComponent(func() error {
+			return ringBuffer.Stop(cleanup)
+		}, "ring buffer reader %s couldn't gracefully shut down", ringBuffer.Name)
+	}
 	for _, probe := range m.Probes {
-		e := probe.Stop()
-		if e != nil {
-			e = errors.New(fmt.Sprintf("error:%v , program %s couldn't gracefully shut down", e, probe.EbpfFuncName))
-		}
-		err = ConcatErrors(err, e)
+		probe := probe
+		stopComponent(probe.stopOnExit, "program %s couldn't gracefully shut down", probe.EbpfFuncName)
+	}
+	for _, structOps := range m.StructOps {
+		stopComponent(structOps.disable, "struct_ops map %s couldn't be unregistered", structOps.Name)
+	}
+	stopGroup.Wait()
+
+	// Close the AF_XDP sockets before their XSKMAPs
+	if e := m.closeXSKSockets(); e != nil {
+		err = multierror.Append(err, fmt.Errorf("error:%w , couldn't close the AF_XDP sockets", e))
+	}
+
+	// Deliver the samples left in the ordered stream
+	m.stopOrderedStream()
+
+	// Wait for the handles acquired by the caller before the maps and programs are closed
+	if e := m.releaseHandles(); e != nil {
+		err = multierror.Append(err, e)
 	}
 
 	// Close maps
 	for _, managerMap := range m.Maps {
-		e := managerMap.Close(cleanup)
-		if e != nil {
-			e = errors.New(fmt.Sprintf("error:%v , couldn't gracefully close map %s", e, managerMap.Name))
-		}
-		err = ConcatErrors(err, e)
+		managerMap := managerMap
+		stopComponent(func() error {
+			return managerMap.Close(cleanup)
+		}, "couldn't gracefully close map %s", managerMap.Name)
 	}
+	stopGroup.Wait()
 
 	// Close all netlink sockets
+	m.netlinkLock.Lock()
 	for _, entry := range m.netlinkCache {
-		err = ConcatErrors(err, entry.rtNetlink.Close())
+		if e := entry.rtNetlink.Close(); e != nil {
+			err = multierror.Append(err, e)
+		}
 	}
+	m.netlinkLock.Unlock()
 
 	// Clean up collection
 	// Note: we might end up closing the same programs and maps multiple times but the library gracefully handles those
 	// situations. We can't only rely on the collection to close all maps and programs because some pinned objects were
 	// removed from the collection.
-	m.collection.Close()
+	if m.collection != nil {
+		m.collection.Close()
+	}
+	for _, prog := range m.updatedPrograms {
+		_ = prog.Close()
+	}
+	m.updatedPrograms = nil
 
 	// Wait for all go routines to stop
-	m.wg.Wait()
+	if e := runWithTimeout(timeout, func() error {
+		m.stopReaderPool()
+		m.wg.Wait()
+		// the readers are stopped, handle the samples left in the event queue
+		m.stopEventPool()
+		return nil
+	}); e != nil {
+		err = multierror.Append(err, fmt.Errorf("error:%w , perf ring readers couldn't gracefully shut down", e))
+	}
+	if e := m.disableProgramStats(); e != nil {
+		err = multierror.Append(err, fmt.Errorf("error:%w , couldn't disable the runtime statistics of the programs", e))
+	}
+	m.stateSaved = false
 	m.state = reset
 	return err
 }
@@ -760,6 +1604,38 @@ func (m *Manager) NewPerfRing(spec ebpf.MapSpec, options MapOptions, perfMapOpti
 	return perfMap.array, nil
 }
 
+// NewRingBuffer - Creates a new BPF ring buffer and start listening for events.
+// Use a MapRoute to make this map available to the programs of the manager.
+func (m *Manager) NewRingBuffer(spec ebpf.MapSpec, options MapOptions, ringBufferOptions RingBufferOptions) (*ebpf.Map, error) {
+	// check if the name of the new map is available
+	_, exists, _ := m.GetMap(spec.Name)
+	if exists {
+		return nil, ErrMapNameInUse
+	}
+
+	// Create new map and ring buffer reader
+	ringBuffer, err := loadNewRingBuffer(spec, options, ringBufferOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup ring buffer reader
+	if err := ringBuffer.Init(m); err != nil {
+		return nil, err
+	}
+
+	// Start ring buffer reader
+	if err := ringBuffer.Start(); err != nil {
+		// clean up
+		_ = ringBuffer.Stop(CleanInternal)
+		return nil, err
+	}
+
+	// Add map to the list of ring buffers managed by the manager
+	m.RingBuffers = append(m.RingBuffers, ringBuffer)
+	return ringBuffer.array, nil
+}
+
 // ClonePerfRing - Clone an existing perf map and create a new one with the same spec.
 // Use a MapRoute to make this map available to the programs of the manager.
 func (m *Manager) ClonePerfRing(name string, newName string, options MapOptions, perfMapOptions PerfMapOptions) (*ebpf.Map, error) {
@@ -848,33 +1724,33 @@ func (m *Manager) AddHook(UID string, newProbe *Probe) error {
 // DetachHook - Detach an eBPF program from a hook point. If there is only one instance left of this program in the
 // kernel, then the probe will be detached but the program will not be closed (so that it can be used later). In that
 // case, calling DetachHook has essentially the same effect as calling Detach() on the right Probe instance. However,
-// if there are more than one instance in the kernel of the requested program, then the probe selected by (section, UID)
-// is detached, and its own version of the program is closed.
-func (m *Manager) DetachHook(section string, UID string) error {
-	oldID := ProbeIdentificationPair{UID, section}
+// if there are more than one instance in the kernel of the requested program, then the probe selected by its
+// identification pair is detached, and its own version of the program is closed. The program is never closed while
+// another probe shares it, see ProbeIdentificationPair.
+func (m *Manager) DetachHook(id ProbeIdentificationPair) error {
 	// Check how many instances of the program are left in the kernel
-	progs, _, err := m.GetProgram(ProbeIdentificationPair{"", section})
+	progs, _, err := m.GetProgram(ProbeIdentificationPair{"", id.EbpfFuncName})
 	if err != nil {
 		return err
 	}
-	shouldStop := len(progs) > 1
 
 	// Look for the probe
 	idToDelete := -1
-	for id, managerProbe := range m.Probes {
-		if managerProbe.IdentificationPairMatches(oldID) {
-			// Detach or stop the probe depending on shouldStop
-			if shouldStop {
-				if err = managerProbe.Stop(); err != nil {
-					return errors.New(fmt.Sprintf("error:%v , couldn't stop probe %v", err, oldID))
-				}
-			} else {
-				if err = managerProbe.Detach(); err != nil {
-					return errors.New(fmt.Sprintf("error:%v , couldn't detach probe %v", err, oldID))
-				}
+	for i, managerProbe := range m.Probes {
+		if !managerProbe.IdentificationPairMatches(id) {
+			continue
+		}
+		// Detach or stop the probe depending on the other instances of its program
+		if m.closableProgram(managerProbe.program, progs) {
+			if err = managerProbe.Stop(); err != nil {
+				return errors.New(fmt.Sprintf("error:%v , couldn't stop probe %v", err, id))
+			}
+		} else {
+			if err = managerProbe.Detach(); err != nil {
+				return errors.New(fmt.Sprintf("error:%v , couldn't detach probe %v", err, id))
 			}
-			idToDelete = id
 		}
+		idToDelete = i
 	}
 	if idToDelete >= 0 {
 		m.Probes = append(m.Probes[:idToDelete], m.Probes[idToDelete+1:]...)
@@ -882,6 +1758,109 @@ func (m *Manager) DetachHook(section string, UID string) error {
 	return nil
 }
 
+// closableProgram - Returns true if the program of a detached probe can be closed: no other probe shares it, and
+// another instance of the program of its function is left in progs
+func (m *Manager) closableProgram(prog *ebpf.Program, progs []*ebpf.Program) bool {
+	if m.sharedProgram(prog) {
+		return false
+	}
+	for _, instance := range progs {
+		if instance != prog {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedProgram - Returns true if more than one probe of the manager use the provided program
+func (m *Manager) sharedProgram(prog *ebpf.Program) bool {
+	return m.programUsers(prog) > 1
+}
+
+// programUsers - Returns the number of probes of the manager that use the provided program
+func (m *Manager) programUsers(prog *ebpf.Program) int {
+	users := 0
+	for _, probe := range m.Probes {
+		if probe.program == prog {
+			users++
+		}
+	}
+	return users
+}
+
+// UpdateProbeProgram - Loads the provided program and replaces the program of the requested probe with it, so that
+// a fixed program can be shipped without restarting the manager. The maps referenced by the new program are resolved
+// against the maps of the manager. If the probe is attached through a BPF link, the attachment is updated atomically
+// (BPF_LINK_UPDATE), otherwise the previous program is detached before the new one is attached, which might drop the
+// events triggered in between. The previous program is closed on success, unless other probes of its function still
+// use it.
+func (m *Manager) UpdateProbeProgram(id ProbeIdentificationPair, newSpec *ebpf.ProgramSpec) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	probe, ok := m.GetProbe(id)
+	if !ok {
+		return fmt.Errorf("error:%w , couldn't find probe %v", ErrUnknownMatchFuncName, id)
+	}
+	if !probe.IsInitialized() {
+		return fmt.Errorf("error:%w , probe %v", ErrProbeNotInitialized, id)
+	}
+	if newSpec.Type != probe.programSpec.Type {
+		return fmt.Errorf("error:%w , probe %v expects a %s program, got %s", ErrProgramTypeMismatch, id, probe.programSpec.Type, newSpec.Type)
+	}
+
+	// Resolve the maps of the new program and load it
+	spec := newSpec.Copy()
+	for name, array := range m.collection.Maps {
+		if err := spec.Instructions.AssociateMap(name, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+			return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with the new program of probe %v", err, name, id))
+		}
+	}
+	prog, err := ebpf.NewProgramWithOptions(spec, m.options.VerifierOptions.Programs)
+	if err != nil {
+		if loadErr := newProgramLoadError(id.EbpfFuncName, spec, err); loadErr != nil {
+			return loadErr
+		}
+		return errors.New(fmt.Sprintf("error:%v , couldn't load the new program of probe %v", err, id))
+	}
+
+	// the new program is used by the probe even if it couldn't be pinned, err is returned once the collection is
+	// updated
+	oldProg, err := probe.updateProgram(prog, spec)
+	if oldProg == nil {
+		_ = prog.Close()
+		return err
+	}
+
+	// The previous program is still used by the other probes of its function, the new one is closed along with the
+	// manager
+	if m.programUsers(oldProg) > 0 {
+		m.updatedPrograms = append(m.updatedPrograms, prog)
+		return err
+	}
+
+	// The new program takes the place of the previous one, so that it is closed along with the manager
+	owned := false
+	for name, collectionProg := range m.collection.Programs {
+		if collectionProg == oldProg {
+			m.collection.Programs[name] = prog
+			owned = true
+		}
+	}
+	for i, updatedProg := range m.updatedPrograms {
+		if updatedProg == oldProg {
+			m.updatedPrograms[i] = prog
+			owned = true
+		}
+	}
+	if !owned {
+		m.updatedPrograms = append(m.updatedPrograms, prog)
+	}
+	return ConcatErrors(err, oldProg.Close())
+}
+
 // CloneProgram - Create a clone of a program, load it in the kernel and attach it to its hook point. Since the eBPF
 // program instructions are copied before the program is loaded, you can edit them with a ConstantEditor, or remap
 // the eBPF maps as you like. This is particularly useful to workaround the absence of Array of Maps and Hash of Maps:
@@ -930,6 +1909,13 @@ func (m *Manager) CloneProgram(UID string, newProbe *Probe, constantsEditors []C
 		return errors.New(fmt.Sprintf("error:%v , couldn't rewrite maps in %v", err, newProbe.GetIdentificationPair()))
 	}
 
+	// Patch instructions
+	if newProbe.InstructionPatcher != nil {
+		if err = newProbe.InstructionPatcher(newProbe.programSpec); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't patch the instructions of %v", err, newProbe.GetIdentificationPair()))
+		}
+	}
+
 	// Init
 	if err = newProbe.InitWithOptions(m, true, true); err != nil {
 		// clean up
@@ -1012,11 +1998,16 @@ func (m *Manager) updateTailCallRoute(route TailCallRoute) error {
 	if !found {
 		return errors.New(fmt.Sprintf("error:%v , couldn't find routing map %s", ErrUnknownMap, route.ProgArrayName))
 	}
+	if routingMap.Type() != ebpf.ProgramArray {
+		return fmt.Errorf("error:%w , map %s has type %s", ErrNotProgArray, route.ProgArrayName, routingMap.Type())
+	}
 
 	// Get file descriptor of the routed program
 	var fd uint32
 	if route.Program != nil {
 		fd = uint32(route.Program.FD())
+	} else if route.ProgramFD > 0 {
+		fd = uint32(route.ProgramFD)
 	} else {
 		progs, found, err := m.GetProgram(route.ProbeIdentificationPair)
 		if err != nil {
@@ -1035,6 +2026,24 @@ func (m *Manager) updateTailCallRoute(route TailCallRoute) error {
 	return nil
 }
 
+// DeleteTailCallRoutes - Removes the programs at the keys of the provided routes from their program arrays. Only the
+// ProgArrayName and the Key of the routes are used.
+func (m *Manager) DeleteTailCallRoutes(router ...TailCallRoute) error {
+	for _, route := range router {
+		routingMap, found, err := m.GetMap(route.ProgArrayName)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New(fmt.Sprintf("error:%v , couldn't find routing map %s", ErrUnknownMap, route.ProgArrayName))
+		}
+		if err = routingMap.Delete(route.Key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return errors.New(fmt.Sprintf("error:%v , couldn't delete key %d of routing map %s", err, route.Key, route.ProgArrayName))
+		}
+	}
+	return nil
+}
+
 func (m *Manager) getProbeProgramSpec(matchFuncName string) (*ebpf.ProgramSpec, error) {
 	spec, ok := m.collectionSpec.Programs[matchFuncName]
 	if !ok {
@@ -1068,6 +2077,14 @@ func (m *Manager) matchSpecs() error {
 			probe.programSpec = programSpec.Copy()
 			m.collectionSpec.Programs[probe.EbpfFuncName+probe.UID] = probe.programSpec
 		}
+		if probe.isTrampolineSpec() {
+			if err = probe.matchTrampolineSpec(m.kernelTypes(), m.options.SymFile, HaveTrampolines() == nil); err != nil {
+				return err
+			}
+		}
+		if probe.isBTFRawTracepointSpec() {
+			probe.matchBTFRawTracepointSpec(HaveBTFRawTracepoints() == nil)
+		}
 	}
 
 	// Match maps
@@ -1078,17 +2095,38 @@ func (m *Manager) matchSpecs() error {
 		}
 		spec.Contents = managerMap.Contents
 		spec.Freeze = managerMap.Freeze
+		if spec.InnerMap == nil && managerMap.InnerMapSpec != nil {
+			spec.InnerMap = managerMap.InnerMapSpec
+		}
 		managerMap.arraySpec = spec
 	}
 
 	// Match perfmaps
 	for _, perfMap := range m.PerfMaps {
+		if perfMap.TestMode {
+			continue
+		}
 		spec, ok := m.collectionSpec.Maps[perfMap.Name]
 		if !ok {
 			return errors.New(fmt.Sprintf("error:%v , couldn't find map at maps/%s", ErrUnknownMap, perfMap.Name))
 		}
 		perfMap.arraySpec = spec
 	}
+
+	// Match ring buffers
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.TestMode {
+			continue
+		}
+		spec, ok := m.collectionSpec.Maps[ringBuffer.Name]
+		if !ok {
+			return errors.New(fmt.Sprintf("error:%v , couldn't find map at maps/%s", ErrUnknownMap, ringBuffer.Name))
+		}
+		if ringBuffer.RingBufferSize > 0 {
+			spec.MaxEntries = uint32(ringBuffer.RingBufferSize)
+		}
+		ringBuffer.arraySpec = spec
+	}
 	return nil
 }
 
@@ -1109,9 +2147,15 @@ func (m *Manager) activateProbes() {
 			}
 		}
 
+		// skip the probes that can't work on the running kernel
+		mProbe.skipReason = mProbe.checkKernelSupport()
+		if mProbe.skipReason != nil {
+			shouldActivate = false
+		}
+
 		mProbe.Enabled = shouldActivate
 
-		if shouldPopulateActivatedProbes {
+		if shouldPopulateActivatedProbes && mProbe.skipReason == nil && !mProbe.Optional {
 			// this will ensure that we check that everything has been activated by default when no selectors are provided
 			m.options.ActivatedProbes = append(m.options.ActivatedProbes, &ProbeSelector{
 				ProbeIdentificationPair: mProbe.GetIdentificationPair(),
@@ -1120,6 +2164,23 @@ func (m *Manager) activateProbes() {
 	}
 }
 
+// removeSkippedPrograms - Removes the programs used only by skipped probes from the CollectionSpec, so that they aren't
+// loaded: the verifier would most likely reject them on the running kernel. The copies made for the skipped probes that
+// set CopyProgram are removed as well.
+func (m *Manager) removeSkippedPrograms() {
+	used := make(map[string]bool)
+	for _, probe := range m.Probes {
+		if probe.skipReason == nil {
+			used[probe.programKey()] = true
+		}
+	}
+	for _, probe := range m.Probes {
+		if probe.skipReason != nil && !used[probe.programKey()] {
+			delete(m.collectionSpec.Programs, probe.programKey())
+		}
+	}
+}
+
 // UpdateActivatedProbes - update the list of activated probes
 func (m *Manager) UpdateActivatedProbes(selectors []ProbesSelector) error {
 	currentProbes := make(map[ProbeIdentificationPair]*Probe)
@@ -1141,6 +2202,10 @@ func (m *Manager) UpdateActivatedProbes(selectors []ProbesSelector) error {
 			delete(currentProbes, id)
 		} else {
 			probe, _ := m.GetProbe(id)
+			if probe.skipReason != nil {
+				// the program of a skipped probe isn't loaded, the validators will report it
+				continue
+			}
 			probe.Enabled = true
 			if err := probe.Init(m); err != nil {
 				return err
@@ -1176,21 +2241,44 @@ func (m *Manager) UpdateActivatedProbes(selectors []ProbesSelector) error {
 	return nil
 }
 
-// editConstants - Edit the programs in the CollectionSpec with the provided constant editors. Tries with the BTF global
-// variable first, and fall back to the asm method if BTF is not available.
+// editConstants - Edit the programs in the CollectionSpec with the provided constant editors. The constants defined as
+// global variables (BTF .rodata sections) are rewritten in the data sections, the others fall back to the asm method.
 func (m *Manager) editConstants() error {
 	// Start with the BTF based solution
-	rodata := m.collectionSpec.Maps[".rodata"]
-	if rodata != nil && rodata.Key != nil && rodata.Value != nil {
-		consts := map[string]interface{}{}
-		for _, editor := range m.options.ConstantEditors {
-			consts[editor.Name] = editor.Value
+	globals := m.globalConstants()
+	consts := map[string]interface{}{}
+	probeConsts := map[*Probe]map[string]interface{}{}
+	var asmEditors []ConstantEditor
+	for _, editor := range m.options.ConstantEditors {
+		if _, ok := globals[editor.Name]; ok {
+			probes := m.copiedProbes(editor.ProbeIdentificationPairs)
+			for _, probe := range probes {
+				if probeConsts[probe] == nil {
+					probeConsts[probe] = map[string]interface{}{}
+				}
+				probeConsts[probe][editor.Name] = editor.Value
+			}
+			if len(probes) == 0 {
+				consts[editor.Name] = editor.Value
+			}
+			continue
+		}
+		asmEditors = append(asmEditors, editor)
+	}
+	if len(consts) > 0 {
+		if err := m.collectionSpec.RewriteConstants(consts); err != nil {
+			return err
+		}
+	}
+	// The copies of the data sections start from the rewritten ones
+	for probe, values := range probeConsts {
+		if err := m.rewriteProbeConstants(probe, values); err != nil {
+			return err
 		}
-		return m.collectionSpec.RewriteConstants(consts)
 	}
 
 	// Fall back to the old school constant edition
-	for _, constantEditor := range m.options.ConstantEditors {
+	for _, constantEditor := range asmEditors {
 
 		// Edit the constant of the provided programs
 		for _, id := range constantEditor.ProbeIdentificationPairs {
@@ -1221,6 +2309,66 @@ func (m *Manager) editConstants() error {
 	return nil
 }
 
+// copiedProbes - Returns the probes selected by the provided identification pairs, if all of them set CopyProgram
+func (m *Manager) copiedProbes(ids []ProbeIdentificationPair) []*Probe {
+	var probes []*Probe
+	for _, id := range ids {
+		probe, found := m.GetProbe(id)
+		if !found || !probe.CopyProgram || probe.programSpec == nil {
+			return nil
+		}
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
+// rewriteProbeConstants - Rewrites the provided global constants for the copied program of the provided probe only:
+// the .rodata sections referenced by the program are copied to <section>.<function><UID>, and the program is updated
+// to reference the copies
+func (m *Manager) rewriteProbeConstants(probe *Probe, consts map[string]interface{}) error {
+	copies := map[string]*ebpf.MapSpec{}
+	for _, ins := range probe.programSpec.Instructions {
+		name := ins.Reference()
+		if spec, ok := m.collectionSpec.Maps[name]; ok && strings.HasPrefix(name, ".rodata") {
+			copies[name] = spec.Copy()
+		}
+	}
+	if err := (&ebpf.CollectionSpec{Maps: copies}).RewriteConstants(consts); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't rewrite the constants of %v", err, probe.GetIdentificationPair()))
+	}
+
+	for name, spec := range copies {
+		copyName := name + "." + probe.EbpfFuncName + probe.UID
+		m.collectionSpec.Maps[copyName] = spec
+		for i := range probe.programSpec.Instructions {
+			ins := &probe.programSpec.Instructions[i]
+			if ins.Reference() == name {
+				*ins = ins.WithReference(copyName)
+			}
+		}
+	}
+	return nil
+}
+
+// globalConstants - Returns the names of the global constants defined in the BTF .rodata sections of the
+// CollectionSpec
+func (m *Manager) globalConstants() map[string]struct{} {
+	globals := make(map[string]struct{})
+	for name, spec := range m.collectionSpec.Maps {
+		if !strings.HasPrefix(name, ".rodata") {
+			continue
+		}
+		datasec, ok := spec.Value.(*btf.Datasec)
+		if !ok {
+			continue
+		}
+		for _, v := range datasec.Vars {
+			globals[v.Type.TypeName()] = struct{}{}
+		}
+	}
+	return globals
+}
+
 // editMapSpecs - Update the MapSpec with the provided MapSpec editors.
 func (m *Manager) editMapSpecs() error {
 	for name, mapEditor := range m.options.MapSpecEditors {
@@ -1235,15 +2383,38 @@ func (m *Manager) editMapSpecs() error {
 		if mapEditor.EditorFlag == 0 {
 			return fmt.Errorf("failed to edit maps/%s: %w", name, ErrMissingEditorFlags)
 		}
+		edited := *spec
 		if EditType&mapEditor.EditorFlag == EditType {
-			spec.Type = mapEditor.Type
+			edited.Type = mapEditor.Type
 		}
 		if EditMaxEntries&mapEditor.EditorFlag == EditMaxEntries {
-			spec.MaxEntries = mapEditor.MaxEntries
+			edited.MaxEntries = mapEditor.MaxEntries
+			if mapEditor.MaxEntriesPerCPU > 0 {
+				cpus, err := possibleCPUs()
+				if err != nil {
+					return errors.New(fmt.Sprintf("error:%v , failed to edit maps/%s: couldn't get the number of CPUs", err, name))
+				}
+				edited.MaxEntries = mapEditor.MaxEntriesPerCPU * uint32(cpus)
+			}
 		}
 		if EditFlags&mapEditor.EditorFlag == EditFlags {
-			spec.Flags = mapEditor.Flags
+			edited.Flags = mapEditor.Flags
+		}
+		if EditKeyValueSize&mapEditor.EditorFlag == EditKeyValueSize {
+			edited.KeySize, edited.ValueSize = mapEditor.KeySize, mapEditor.ValueSize
+			edited.Key, edited.Value = nil, nil
+		}
+		if EditNumaNode&mapEditor.EditorFlag == EditNumaNode {
+			edited.NumaNode = mapEditor.NumaNode
+			edited.Flags |= unix.BPF_F_NUMA_NODE
+		}
+		if EditName&mapEditor.EditorFlag == EditName {
+			edited.Name = mapEditor.Name
 		}
+		if err = checkMapSpecEdit(spec, &edited); err != nil {
+			return fmt.Errorf("error:%w , failed to edit maps/%s: %v", ErrIncompatibleMapSpec, name, err)
+		}
+		*spec = edited
 
 		if EditInnerMap&mapEditor.EditorFlag == EditInnerMap {
 			// InnerMap  替换
@@ -1258,6 +2429,9 @@ func (m *Manager) editMapSpecs() error {
 // editConstant - Edit the provided program with the provided constant using the asm method.
 func (m *Manager) editConstant(prog *ebpf.ProgramSpec, editor ConstantEditor) error {
 	edit := Edit(&prog.Instructions)
+	if len(edit.ReferenceOffsets[editor.Name]) == 0 && !editor.FailOnMissing {
+		return nil
+	}
 	data, ok := (editor.Value).(uint64)
 	if !ok {
 		return fmt.Errorf("with the asm method, the constant value has to be of type uint64")
@@ -1282,6 +2456,21 @@ func (m *Manager) rewriteMaps(program *ebpf.ProgramSpec, eBPFMaps map[string]*eb
 	return nil
 }
 
+// checkMapEditors - Checks that the maps provided in Options.MapEditors match the definitions of the maps they
+// replace, so that a map shared by several managers is rejected before the programs are loaded
+func (m *Manager) checkMapEditors(maps map[string]*ebpf.Map) error {
+	for name, rwMap := range maps {
+		spec, ok := m.collectionSpec.Maps[name]
+		if !ok {
+			return errors.New(fmt.Sprintf("error:%v , couldn't find map %s to replace", ErrUnknownMap, name))
+		}
+		if err := spec.Compatible(rwMap); err != nil {
+			return fmt.Errorf("error:%w , map %s: %v", ErrIncompatibleMapEditor, name, err)
+		}
+	}
+	return nil
+}
+
 // editMaps - RewriteMaps replaces all references to specific maps.
 func (m *Manager) editMaps(maps map[string]*ebpf.Map) error {
 	// Rewrite maps
@@ -1291,8 +2480,8 @@ func (m *Manager) editMaps(maps map[string]*ebpf.Map) error {
 
 	// The rewrite operation removed the original maps from the CollectionSpec and will therefore not appear in the
 	// Collection, make the mapping with the Manager.Maps now
-	found := false
 	for name, rwMap := range maps {
+		found := false
 		for _, managerMap := range m.Maps {
 			if managerMap.Name == name {
 				managerMap.array = rwMap
@@ -1309,6 +2498,14 @@ func (m *Manager) editMaps(maps map[string]*ebpf.Map) error {
 				found = true
 			}
 		}
+		for _, ringBuffer := range m.RingBuffers {
+			if ringBuffer.Name == name {
+				ringBuffer.array = rwMap
+				ringBuffer.externalMap = true
+				ringBuffer.editedMap = true
+				found = true
+			}
+		}
 		if !found {
 			// Create a new entry
 			m.Maps = append(m.Maps, &Map{
@@ -1323,12 +2520,104 @@ func (m *Manager) editMaps(maps map[string]*ebpf.Map) error {
 	return nil
 }
 
+// joinPerfMap - Registers a started perf map on its perf event array, which can be shared by several perf maps. Returns
+// true if the perf map is the first one: it reads the perf ring buffers for all of them.
+func (m *Manager) joinPerfMap(perfMap *PerfMap) bool {
+	m.perfMapRefLock.Lock()
+	defer m.perfMapRefLock.Unlock()
+	if m.perfMapShares == nil {
+		m.perfMapShares = make(map[*ebpf.Map][]*PerfMap)
+	}
+	// the list is copied on write, so that the followers of the first perf map can be read without lock
+	shares := m.perfMapShares[perfMap.array]
+	shares = append(shares[:len(shares):len(shares)], perfMap)
+	m.perfMapShares[perfMap.array] = shares
+	perfMap.followers.Store([]*PerfMap(nil))
+	shares[0].followers.Store(shares[1:])
+	return len(shares) == 1
+}
+
+// leavePerfMap - Unregisters a stopped perf map from its perf event array. Returns the perf map that must now read the
+// perf ring buffers if the provided perf map was reading them, and true if it was the last perf map of the array.
+func (m *Manager) leavePerfMap(perfMap *PerfMap) (*PerfMap, bool) {
+	m.perfMapRefLock.Lock()
+	defer m.perfMapRefLock.Unlock()
+	shares := m.perfMapShares[perfMap.array]
+	var left []*PerfMap
+	for _, share := range shares {
+		if share != perfMap {
+			left = append(left, share)
+		}
+	}
+	perfMap.followers.Store([]*PerfMap(nil))
+	if len(left) == 0 {
+		delete(m.perfMapShares, perfMap.array)
+		return nil, true
+	}
+	m.perfMapShares[perfMap.array] = left
+	left[0].followers.Store(left[1:])
+	if shares[0] == perfMap {
+		return left[0], false
+	}
+	return nil, false
+}
+
+// isSharedPerfMap - Returns true if the perf event array of the provided perf map is shared with other perf maps
+func (m *Manager) isSharedPerfMap(perfMap *PerfMap) bool {
+	m.perfMapRefLock.Lock()
+	defer m.perfMapRefLock.Unlock()
+	return len(m.perfMapShares[perfMap.array]) > 1
+}
+
+// kernelTypes - Returns the kernel BTF provided in the manager options, or the BTF of the running kernel. Returns nil
+// if the kernel BTF isn't available.
+func (m *Manager) kernelTypes() *btf.Spec {
+	if m.options.VerifierOptions.Programs.KernelTypes != nil {
+		return m.options.VerifierOptions.Programs.KernelTypes
+	}
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil
+	}
+	return spec
+}
+
+// loadKernelTypes - Plumbs the kernel BTF provided in the manager options into the verifier options, so that CO-RE
+// relocations are resolved against it. Falls back to BTFSearchPaths when the kernel BTF isn't available.
+func (m *Manager) loadKernelTypes() error {
+	if m.options.KernelTypes == nil && m.options.KernelTypesPath != "" {
+		spec, err := loadBTFFile(m.options.KernelTypesPath)
+		if err != nil {
+			return fmt.Errorf("error:%w , couldn't parse kernel BTF at %s", err, m.options.KernelTypesPath)
+		}
+		m.options.KernelTypes = spec
+	}
+	if m.options.KernelTypes == nil && len(m.options.BTFSearchPaths) > 0 {
+		if _, err := btf.LoadKernelSpec(); err != nil {
+			spec, _, err := findKernelBTF(m.options.BTFSearchPaths)
+			if err != nil {
+				return err
+			}
+			m.options.KernelTypes = spec
+		}
+	}
+	if m.options.KernelTypes != nil {
+		m.options.VerifierOptions.Programs.KernelTypes = m.options.KernelTypes
+	}
+	return nil
+}
+
 // loadCollection - Load the eBPF maps and programs in the CollectionSpec. Programs and Maps are pinned when requested.
 func (m *Manager) loadCollection() error {
 	var err error
 	// Load collection
-	m.collection, err = ebpf.NewCollectionWithOptions(m.collectionSpec, m.options.VerifierOptions)
+	m.collection, err = m.newCollection()
 	if err != nil {
+		m.reportCORERelocations()
+		var loadErr *ProgramLoadError
+		if errors.As(err, &loadErr) {
+			return loadErr
+		}
 		return errors.New(fmt.Sprintf("error:%v , couldn't load eBPF programs, cs:%v", err, m.collectionSpec))
 	}
 
@@ -1346,8 +2635,20 @@ func (m *Manager) loadCollection() error {
 		}
 	}
 
+	// Initialize RingBuffers
+	for _, ringBuffer := range m.RingBuffers {
+		if err := ringBuffer.Init(m); err != nil {
+			return err
+		}
+	}
+
 	// Initialize Probes
 	for _, probe := range m.Probes {
+		// Lazy probes are initialized when they are first attached
+		if _, loaded := m.collection.Programs[probe.programKey()]; probe.loadsLazily() && !loaded {
+			probe.manager = m
+			continue
+		}
 		// Find program
 		if err := probe.Init(m); err != nil {
 			return err
@@ -1362,7 +2663,7 @@ func (m *Manager) loadCollection() error {
 func (m *Manager) loadPinnedObjects() error {
 	// Look for pinned maps
 	for _, managerMap := range m.Maps {
-		if managerMap.PinPath == "" {
+		if managerMap.PinPath == "" && managerMap.LoadPinPath == "" {
 			continue
 		}
 		if err := m.loadPinnedMap(managerMap); err != nil {
@@ -1375,7 +2676,7 @@ func (m *Manager) loadPinnedObjects() error {
 
 	// Look for pinned perf buffer
 	for _, perfMap := range m.PerfMaps {
-		if perfMap.PinPath == "" {
+		if perfMap.PinPath == "" && perfMap.LoadPinPath == "" {
 			continue
 		}
 		if err := m.loadPinnedMap(&perfMap.Map); err != nil {
@@ -1386,6 +2687,19 @@ func (m *Manager) loadPinnedObjects() error {
 		}
 	}
 
+	// Look for pinned ring buffers
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.PinPath == "" && ringBuffer.LoadPinPath == "" {
+			continue
+		}
+		if err := m.loadPinnedMap(&ringBuffer.Map); err != nil {
+			if err == ErrPinnedObjectNotFound {
+				continue
+			}
+			return err
+		}
+	}
+
 	// Look for pinned programs
 	for _, prog := range m.Probes {
 		if prog.PinPath == "" {
@@ -1401,10 +2715,16 @@ func (m *Manager) loadPinnedObjects() error {
 	return nil
 }
 
-// loadPinnedMap - Loads a pinned map
+// loadPinnedMap - Loads a pinned map. Maps reused from LoadPinPath are checked against their spec, and pinned at their
+// PinPath.
 func (m *Manager) loadPinnedMap(managerMap *Map) error {
+	pinPath := managerMap.PinPath
+	if managerMap.LoadPinPath != "" {
+		pinPath = managerMap.LoadPinPath
+	}
+
 	// Check if the pinned object exists
-	if _, err := os.Stat(managerMap.PinPath); err != nil {
+	if _, err := os.Stat(pinPath); err != nil {
 		return ErrPinnedObjectNotFound
 	}
 
@@ -1419,9 +2739,22 @@ func (m *Manager) loadPinnedMap(managerMap *Map) error {
 		}
 		pinnedMap, err := ebpf.LoadPinnedMapExplicit(managerMap.PinPath, &abi)
 	*/
-	pinnedMap, err := ebpf.LoadPinnedMap(managerMap.PinPath, nil)
+	pinnedMap, err := ebpf.LoadPinnedMap(pinPath, nil)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error:%v , couldn't load map %s from %s", err, managerMap.Name, managerMap.PinPath))
+		return errors.New(fmt.Sprintf("error:%v , couldn't load map %s from %s", err, managerMap.Name, pinPath))
+	}
+	if managerMap.LoadPinPath != "" {
+		if err = managerMap.arraySpec.Compatible(pinnedMap); err != nil {
+			_ = pinnedMap.Close()
+			return fmt.Errorf("error:%w , map %s pinned at %s: %v", ErrIncompatiblePinnedMap, managerMap.Name, pinPath, err)
+		}
+		if managerMap.PinPath != "" && managerMap.PinPath != pinPath {
+			if err = pinnedMap.Pin(managerMap.PinPath); err != nil {
+				_ = pinnedMap.Close()
+				return errors.New(fmt.Sprintf("error:%v , couldn't pin map %s at %s", err, managerMap.Name, managerMap.PinPath))
+			}
+		}
+		managerMap.reused = true
 	}
 
 	// Replace map in CollectionSpec
@@ -1470,12 +2803,21 @@ func (m *Manager) sanityCheck() error {
 		}
 		cache[managerMap.Name] = true
 	}
+	// Multiple perf maps can share the same perf event array, but it can't be used by a map as well
+	perfCache := map[string]bool{}
 	for _, perfMap := range m.PerfMaps {
-		_, ok := cache[perfMap.Name]
-		if ok {
+		if cache[perfMap.Name] && !perfCache[perfMap.Name] {
 			return errors.New(fmt.Sprintf("error:%v , map %s failed the sanity check", ErrMapNameInUse, perfMap.Name))
 		}
 		cache[perfMap.Name] = true
+		perfCache[perfMap.Name] = true
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		_, ok := cache[ringBuffer.Name]
+		if ok {
+			return errors.New(fmt.Sprintf("error:%v , map %s failed the sanity check", ErrMapNameInUse, ringBuffer.Name))
+		}
+		cache[ringBuffer.Name] = true
 	}
 
 	// Check if probes identification pairs are unique, request the usage of CloneProbe otherwise
@@ -1486,25 +2828,69 @@ func (m *Manager) sanityCheck() error {
 			return errors.New(fmt.Sprintf("error:%v , %v failed the sanity check", ErrCloneProbeRequired, managerProbe.GetIdentificationPair()))
 		}
 		cache[managerProbe.GetIdentificationPair().String()] = true
+		if managerProbe.Cleanup == CleanupCloseFD {
+			return fmt.Errorf("error:%w , %v: CleanupCloseFD only applies to maps", ErrInvalidCleanupType, managerProbe.GetIdentificationPair())
+		}
+	}
+	maps := append([]*Map(nil), m.Maps...)
+	for _, perfMap := range m.PerfMaps {
+		maps = append(maps, &perfMap.Map)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		maps = append(maps, &ringBuffer.Map)
+	}
+	for _, managerMap := range maps {
+		if managerMap.Cleanup == CleanupLeaveAttached || managerMap.Cleanup == CleanupDetachOnly {
+			return fmt.Errorf("error:%w , map %s: CleanupLeaveAttached and CleanupDetachOnly only apply to probes", ErrInvalidCleanupType, managerMap.Name)
+		}
 	}
 	return nil
 }
 
+// getNetlinkConnection - (TC classifier) Returns the cached netlink socket of the provided interface, if any
+func (m *Manager) getNetlinkConnection(key netlinkCacheKey) (*netlinkCacheValue, bool) {
+	m.netlinkLock.Lock()
+	defer m.netlinkLock.Unlock()
+	ntl, ok := m.netlinkCache[key]
+	return ntl, ok
+}
+
+// removeNetlinkConnection - (TC classifier) Removes the netlink socket of the provided interface from the cache, and
+// returns it so that the caller can close it
+func (m *Manager) removeNetlinkConnection(key netlinkCacheKey) (*netlinkCacheValue, bool) {
+	m.netlinkLock.Lock()
+	defer m.netlinkLock.Unlock()
+	ntl, ok := m.netlinkCache[key]
+	delete(m.netlinkCache, key)
+	return ntl, ok
+}
+
 // newNetlinkConnection - (TC classifier) TC classifiers are attached by creating a qdisc on the requested
 // interface. A netlink socket is required to create a qdisc. Since this socket can be re-used for multiple classifiers,
-// instantiate the connection at the manager level and cache the netlink socket.
-func (m *Manager) newNetlinkConnection(ifindex int32, netns uint64) (*netlinkCacheValue, error) {
+// instantiate the connection at the manager level and cache the netlink socket. A netlink socket stays bound to the
+// network namespace in which it was created, the socket is therefore created in netnsPath if provided. The cached
+// socket is returned if another probe of the interface opened it in the meantime.
+func (m *Manager) newNetlinkConnection(ifindex int32, netns uint64, netnsPath string) (*netlinkCacheValue, error) {
+	m.netlinkLock.Lock()
+	defer m.netlinkLock.Unlock()
+	key := netlinkCacheKey{Ifindex: ifindex, Netns: netns, NetnsPath: netnsPath}
+	if cached, ok := m.netlinkCache[key]; ok {
+		return cached, nil
+	}
 	var cacheEntry netlinkCacheValue
-	var err error
 	// Open a netlink socket for the requested namespace
-	cacheEntry.rtNetlink, err = tc.Open(&tc.Config{
-		NetNS: int(netns),
+	err := runInNetns(netnsPath, func() error {
+		var err error
+		cacheEntry.rtNetlink, err = tc.Open(&tc.Config{
+			NetNS: int(netns),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("error:%v , couldn't open a NETLink socket in namespace %v", err, netns))
 	}
 
 	// Insert in manager cache
-	m.netlinkCache[netlinkCacheKey{Ifindex: ifindex, Netns: netns}] = &cacheEntry
+	m.netlinkCache[key] = &cacheEntry
 	return &cacheEntry, nil
 }
@@ -0,0 +1,1028 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestInitWithKernelTypes(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := btf.LoadSpec("/sys/kernel/btf/vmlinux")
+	if err != nil {
+		t.Skipf("kernel BTF not available: %v", err)
+	}
+
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	m := &Manager{}
+	if err = m.InitWithOptions(elf, Options{KernelTypes: spec, KeepKernelBTF: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	if m.options.VerifierOptions.Programs.KernelTypes != spec {
+		t.Error("the provided kernel BTF wasn't plumbed into the verifier options")
+	}
+}
+
+func TestInitWithKernelTypesPath(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CO-RE relocations of relocs_read.elf only resolve against the types of relocs_read_tgt.elf, the fields of
+	// its structures are declared in another order
+	init := func(options Options) (*Manager, error) {
+		elf, err := os.Open("testdata/relocs_read.elf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer elf.Close()
+		m := &Manager{}
+		return m, m.InitWithOptions(elf, options)
+	}
+	if m, err := init(Options{}); err == nil {
+		_ = m.Stop(CleanAll)
+		t.Fatal("expected the CO-RE relocations not to resolve against the kernel BTF")
+	}
+
+	m, err := init(Options{KernelTypesPath: "testdata/relocs_read_tgt.elf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	prog, ok, err := m.GetProgram(ProbeIdentificationPair{EbpfFuncName: "reads"})
+	if err != nil || !ok || len(prog) == 0 {
+		t.Fatalf("couldn't find program reads: %v", err)
+	}
+	ret, _, err := prog[0].Test(make([]byte, 14))
+	if err != nil {
+		t.Skipf("couldn't run the program: %v", err)
+	}
+	// the program returns the line of the first failed assertion
+	if ret != 0 {
+		t.Errorf("the CO-RE relocations weren't resolved against the provided BTF, assertion failed on line %d", ret)
+	}
+}
+
+func TestInitWithInvalidKernelTypesPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vmlinux")
+	if err := os.WriteFile(path, []byte("not a BTF blob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	m := &Manager{}
+	if err = m.InitWithOptions(elf, Options{KernelTypesPath: path}); err == nil {
+		t.Fatal("expected an error when the provided kernel BTF can't be parsed")
+	}
+}
+
+func TestFindKernelBTF(t *testing.T) {
+	if _, err := os.Stat("/sys/kernel/btf/vmlinux"); err != nil {
+		t.Skipf("kernel BTF not available: %v", err)
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		t.Fatal(err)
+	}
+	release := unix.ByteSliceToString(uname.Release[:])
+
+	dir := t.TempDir()
+	if err := os.Symlink("/sys/kernel/btf/vmlinux", filepath.Join(dir, release+".btf")); err != nil {
+		t.Fatal(err)
+	}
+	spec, path, err := findKernelBTF([]string{filepath.Join(dir, "missing"), dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec == nil || path != filepath.Join(dir, release+".btf") {
+		t.Errorf("unexpected kernel BTF %s", path)
+	}
+
+	if _, _, err = findKernelBTF([]string{t.TempDir()}); !errors.Is(err, ErrKernelBTFNotFound) {
+		t.Errorf("expected ErrKernelBTFNotFound, got %v", err)
+	}
+
+	candidates := btfCandidates("/btfhub", "5.4.0-91-generic", "aarch64", "ubuntu", "20.04")
+	if want := "/btfhub/ubuntu/20.04/arm64/5.4.0-91-generic.btf"; candidates[len(candidates)-1] != want {
+		t.Errorf("expected the BTFHub candidate %s, got %v", want, candidates)
+	}
+}
+
+func TestStopWithTimeoutStuckMap(t *testing.T) {
+	stuckMap := &Map{Name: "stuck_map", state: initialized}
+	m := &Manager{
+		wg:    &sync.WaitGroup{},
+		state: initialized,
+		Maps:  []*Map{stuckMap},
+	}
+
+	// hold the state lock of the map so that it can't be closed
+	stuckMap.stateLock.Lock()
+	defer stuckMap.stateLock.Unlock()
+
+	start := time.Now()
+	err := m.StopWithTimeout(100*time.Millisecond, CleanAll)
+	if err == nil {
+		t.Fatal("expected an error for the stuck map")
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), stuckMap.Name) {
+		t.Errorf("expected the error to name the stuck map, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("StopWithTimeout took %s, the timeout wasn't enforced", elapsed)
+	}
+}
+
+func TestProgramAndMapIDs(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	probeID := ProbeIdentificationPair{EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: probeID.EbpfFuncName}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	info, err := m.GetProgramInfo(probeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoID, _ := info.ID()
+	progID, err := m.Probes[0].ProgramID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infoID != progID {
+		t.Errorf("GetProgramInfo returned program %d, expected %d", infoID, progID)
+	}
+	if prog, err := ebpf.NewProgramFromID(progID); err != nil {
+		t.Errorf("couldn't open program %d: %v", progID, err)
+	} else {
+		prog.Close()
+	}
+
+	mapID, err := m.Maps[0].ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapInfo, err := m.Maps[0].array.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := mapInfo.ID(); mapID != expected {
+		t.Errorf("Map.ID returned %d, expected %d", mapID, expected)
+	}
+
+	if programs := m.ListPrograms(); len(programs) != 1 || !programs[0].Matches(probeID) {
+		t.Errorf("expected ListPrograms to return %v, got %v", probeID, programs)
+	}
+	if maps := m.ListMaps(); len(maps) != 1 || maps[0] != "map_val" {
+		t.Errorf("expected ListMaps to return [map_val], got %v", maps)
+	}
+	if _, err = m.GetProgramInfo(ProbeIdentificationPair{EbpfFuncName: "unknown"}); err == nil {
+		t.Error("expected an error for an unknown probe")
+	}
+}
+
+func TestUpdateProbeProgram(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	// the rewrite program drops all the packets received on the socket
+	probeID := ProbeIdentificationPair{EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: probeID.EbpfFuncName, SocketFD: fds[1]}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	received := func() bool {
+		if _, err := unix.Write(fds[0], []byte("packet")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := unix.Read(fds[1], make([]byte, 16))
+		return err == nil
+	}
+	if received() {
+		t.Fatal("expected the packet to be dropped by the rewrite program")
+	}
+	oldID, err := m.Probes[0].ProgramID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new program accepts the number of bytes stored in map_val
+	if err = m.Maps[0].Put(uint32(0), uint32(16)); err != nil {
+		t.Fatal(err)
+	}
+	newSpec := acceptingProgramSpec()
+	if err = m.UpdateProbeProgram(probeID, newSpec); err != nil {
+		t.Fatal(err)
+	}
+	if !received() {
+		t.Error("expected the packet to be accepted by the new program")
+	}
+	newID, err := m.Probes[0].ProgramID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID == oldID || !m.Probes[0].IsRunning() {
+		t.Errorf("expected the probe to run a new program, got %d (previously %d)", newID, oldID)
+	}
+	// the kernel frees programs asynchronously
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		prog, err := ebpf.NewProgramFromID(oldID)
+		if err != nil {
+			break
+		}
+		prog.Close()
+		if time.Since(start) > time.Second {
+			t.Error("expected the previous program to be closed")
+			break
+		}
+	}
+
+	newSpec.Type = ebpf.XDP
+	if err = m.UpdateProbeProgram(probeID, newSpec); !errors.Is(err, ErrProgramTypeMismatch) {
+		t.Errorf("expected ErrProgramTypeMismatch, got %v", err)
+	}
+
+	// programs can't be pinned outside of a BPF file system: the new program stays attached, the previous one is
+	// closed
+	newSpec.Type = ebpf.SocketFilter
+	m.Probes[0].PinPath = filepath.Join(t.TempDir(), "rewrite")
+	if err = os.WriteFile(m.Probes[0].PinPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.UpdateProbeProgram(probeID, newSpec); err == nil {
+		t.Fatal("expected the pinning of the new program to fail")
+	}
+	pinnedID, err := m.Probes[0].ProgramID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinnedID == newID || !m.Probes[0].IsRunning() || !received() {
+		t.Errorf("expected the probe to run the new program, got %d (previously %d)", pinnedID, newID)
+	}
+	if m.collection.Programs[probeID.EbpfFuncName] != m.Probes[0].program {
+		t.Error("expected the new program to be closed along with the manager")
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		prog, err := ebpf.NewProgramFromID(newID)
+		if err != nil {
+			break
+		}
+		prog.Close()
+		if time.Since(start) > time.Second {
+			t.Error("expected the previous program to be closed")
+			break
+		}
+	}
+}
+
+// acceptingProgramSpec - Returns a socket filter accepting the number of bytes stored in map_val
+func acceptingProgramSpec() *ebpf.ProgramSpec {
+	return &ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("map_val"),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, "exit"),
+			asm.LoadMem(asm.R0, asm.R0, 0, asm.Word),
+			asm.Return(),
+			asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+			asm.Return(),
+		},
+	}
+}
+
+func TestUpdateSharedProbeProgram(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	var sockets [2][2]int
+	for i := range sockets {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fds[0])
+		defer unix.Close(fds[1])
+		sockets[i] = [2]int{fds[0], fds[1]}
+	}
+	received := func(i int) bool {
+		if _, err := unix.Write(sockets[i][0], []byte("packet")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := unix.Read(sockets[i][1], make([]byte, 16))
+		return err == nil
+	}
+
+	// both probes use the rewrite program of the collection, which drops all the packets
+	idA := ProbeIdentificationPair{UID: "a", EbpfFuncName: "rewrite"}
+	idB := ProbeIdentificationPair{UID: "b", EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "socket", UID: idA.UID, EbpfFuncName: idA.EbpfFuncName, SocketFD: sockets[0][1]},
+			{Section: "socket", UID: idB.UID, EbpfFuncName: idB.EbpfFuncName, SocketFD: sockets[1][1]},
+		},
+		Maps: []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Maps[0].Put(uint32(0), uint32(16)); err != nil {
+		t.Fatal(err)
+	}
+	sharedProg := m.Probes[1].program
+
+	// the program of the collection is still used by probe b
+	if err = m.UpdateProbeProgram(idA, acceptingProgramSpec()); err != nil {
+		t.Fatal(err)
+	}
+	if !received(0) || received(1) {
+		t.Error("expected probe a to run the new program, and probe b the previous one")
+	}
+	if _, err = sharedProg.Info(); err != nil {
+		t.Errorf("expected the program of probe b to stay open, got %v", err)
+	}
+	if err = m.Probes[1].Detach(); err != nil {
+		t.Errorf("couldn't detach probe b: %v", err)
+	}
+	if err = m.Probes[1].Attach(); err != nil {
+		t.Errorf("couldn't re-attach probe b: %v", err)
+	}
+	if m.collection.Programs[idA.EbpfFuncName] != sharedProg || m.collection.Programs[idA.EbpfFuncName+idA.UID] != nil {
+		t.Error("expected the collection to be left untouched")
+	}
+	updatedProg := m.Probes[0].program
+	updatedID, err := m.Probes[0].ProgramID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the program of the collection isn't used anymore once probe b is updated
+	if err = m.UpdateProbeProgram(idB, acceptingProgramSpec()); err != nil {
+		t.Fatal(err)
+	}
+	if !received(1) {
+		t.Error("expected probe b to run the new program")
+	}
+	if _, err = sharedProg.Info(); err == nil {
+		t.Error("expected the previous program to be closed")
+	}
+	if m.collection.Programs[idB.EbpfFuncName] != m.Probes[1].program {
+		t.Error("expected the new program of probe b to replace the program of the collection")
+	}
+
+	// the new program of probe a is closed along with the manager
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = updatedProg.Info(); err == nil {
+		t.Error("expected the new program of probe a to be closed")
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		prog, err := ebpf.NewProgramFromID(updatedID)
+		if err != nil {
+			break
+		}
+		prog.Close()
+		if time.Since(start) > time.Second {
+			t.Error("expected the new program of probe a to be released")
+			break
+		}
+	}
+}
+
+func TestTailCallRoutes(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	probeID := ProbeIdentificationPair{EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: probeID.EbpfFuncName}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	progArray, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.ProgramArray, KeySize: 4, ValueSize: 4, MaxEntries: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.collection.Maps["prog_array"] = progArray
+	progs, _, err := m.GetProgram(probeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []TailCallRoute{
+		{ProgArrayName: "prog_array", Key: 1, ProbeIdentificationPair: probeID},
+		{ProgArrayName: "prog_array", Key: 2, ProgramFD: progs[0].FD()},
+	}
+	if err = m.UpdateTailCallRoutes(routes...); err != nil {
+		t.Fatal(err)
+	}
+	var id ebpf.ProgramID
+	for _, route := range routes {
+		if err = progArray.Lookup(route.Key, &id); err != nil {
+			t.Errorf("expected a program at key %d: %v", route.Key, err)
+		}
+	}
+
+	if err = m.DeleteTailCallRoutes(routes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = progArray.Lookup(routes[0].Key, &id); !errors.Is(err, ebpf.ErrKeyNotExist) {
+		t.Errorf("expected key %d to be deleted, got %v", routes[0].Key, err)
+	}
+
+	err = m.UpdateTailCallRoutes(TailCallRoute{ProgArrayName: "map_val", Key: 0, ProbeIdentificationPair: probeID})
+	if !errors.Is(err, ErrNotProgArray) {
+		t.Errorf("expected ErrNotProgArray, got %v", err)
+	}
+}
+
+func TestConstantEditors(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	probeID := ProbeIdentificationPair{EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: probeID.EbpfFuncName}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	options := Options{
+		ConstantEditors: []ConstantEditor{
+			{Name: "constant", Value: uint64(5), FailOnMissing: true, ProbeIdentificationPairs: []ProbeIdentificationPair{probeID}},
+			// missing constants are ignored unless FailOnMissing is set
+			{Name: "missing", Value: uint32(1)},
+		},
+	}
+	if err = m.InitWithOptions(elf, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	progs, _, err := m.GetProgram(probeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, _, err := progs[0].Test(make([]byte, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 5 {
+		t.Errorf("expected the program to return the edited constant, got %d", ret)
+	}
+
+	if _, err = elf.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	m = &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: probeID.EbpfFuncName}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	options.ConstantEditors = []ConstantEditor{{Name: "missing", Value: uint64(1), FailOnMissing: true}}
+	if err = m.InitWithOptions(elf, options); err == nil {
+		_ = m.Stop(CleanAll)
+		t.Error("expected an error for a missing constant with FailOnMissing")
+	}
+}
+
+func TestEditGlobalConstants(t *testing.T) {
+	m := &Manager{
+		collectionSpec: &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				".rodata": {
+					Type:       ebpf.Array,
+					KeySize:    4,
+					ValueSize:  4,
+					MaxEntries: 1,
+					Value: &btf.Datasec{
+						Name: ".rodata",
+						Size: 4,
+						Vars: []btf.VarSecinfo{
+							{Type: &btf.Var{Name: "target_pid", Type: &btf.Int{Name: "u32", Size: 4}}, Size: 4},
+						},
+					},
+					Contents: []ebpf.MapKV{{Key: uint32(0), Value: make([]byte, 4)}},
+				},
+			},
+			Programs: map[string]*ebpf.ProgramSpec{},
+		},
+		options: Options{
+			ConstantEditors: []ConstantEditor{
+				{Name: "target_pid", Value: uint32(42)},
+				{Name: "asm_constant", Value: uint64(1)},
+			},
+		},
+	}
+	if err := m.editConstants(); err != nil {
+		t.Fatal(err)
+	}
+	value := m.collectionSpec.Maps[".rodata"].Contents[0].Value.([]byte)
+	if value[0] != 42 {
+		t.Errorf("expected target_pid to be rewritten, got %v", value)
+	}
+}
+
+func TestSkipUnsupportedProbes(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	version, err := CurrentKernelVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	errFeature := errors.New("missing feature")
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "socket", EbpfFuncName: "rewrite", SocketFD: fds[0], KernelVersionMin: version + 1},
+			{Section: "socket/map", EbpfFuncName: "rewrite_map", SocketFD: fds[1], KernelVersionMax: version},
+			{Section: "socket/map", EbpfFuncName: "rewrite_map", UID: "feature", SocketFD: fds[1], FeatureCheck: func() error {
+				return errFeature
+			}},
+			{Section: "socket/map", EbpfFuncName: "rewrite_map", UID: "copy", SocketFD: fds[1], CopyProgram: true, KernelVersionMin: version + 1},
+			{Section: "socket/map", EbpfFuncName: "rewrite_map", UID: "supported", SocketFD: fds[1], KernelVersionMin: version},
+		},
+		Maps: []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, status := range m.GetProbesStatus() {
+		skipped := i < 4
+		if skipped != errors.Is(status.SkipReason, ErrProbeUnsupported) || skipped == status.Running {
+			t.Errorf("unexpected status for probe %s: %+v", status.ProbeIdentificationPair, status)
+		}
+	}
+	if _, found := m.collection.Programs["rewrite"]; found {
+		t.Error("expected the program of the skipped probe not to be loaded")
+	}
+	if _, found := m.collection.Programs["rewrite_mapcopy"]; found {
+		t.Error("expected the copy of the program of the skipped probe not to be loaded")
+	}
+}
+
+func TestMapEditorsShareMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newManager := func(options Options) (*Manager, error) {
+		elf, err := os.Open("testdata/rewrite.elf")
+		if err != nil {
+			return nil, err
+		}
+		defer elf.Close()
+		m := &Manager{
+			Probes: []*Probe{{Section: "socket/map", EbpfFuncName: "rewrite_map"}},
+			Maps:   []*Map{{Name: "map_val"}},
+		}
+		return m, m.InitWithOptions(elf, options)
+	}
+
+	owner, err := newManager(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Stop(CleanAll)
+	shared, _, err := owner.GetMap("map_val")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = shared.Put(uint32(0), uint32(7)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := newManager(Options{MapEditors: map[string]*ebpf.Map{"map_val": shared}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if array, _, _ := m.GetMap("map_val"); array != shared {
+		t.Error("expected the manager to use the shared map")
+	}
+	_, ret, _, err := m.TestRunProgram("rewrite_map", make([]byte, 14), 1)
+	if err != nil {
+		t.Skipf("test run not supported: %v", err)
+	}
+	if ret != 7 {
+		t.Errorf("expected the program to read the shared map, got %d", ret)
+	}
+
+	incompatible, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer incompatible.Close()
+	if _, err = newManager(Options{MapEditors: map[string]*ebpf.Map{"map_val": incompatible}}); !errors.Is(err, ErrIncompatibleMapEditor) {
+		t.Errorf("expected ErrIncompatibleMapEditor, got %v", err)
+	}
+}
+
+func TestDetachHookSharedProgram(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	var sockets [2][2]int
+	for i := range sockets {
+		if sockets[i], err = unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(sockets[i][0])
+		defer unix.Close(sockets[i][1])
+	}
+
+	// the same program is attached to both sockets, and drops all the packets they receive
+	first := ProbeIdentificationPair{UID: "first", EbpfFuncName: "rewrite"}
+	second := ProbeIdentificationPair{UID: "second", EbpfFuncName: "rewrite"}
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "socket", UID: first.UID, EbpfFuncName: first.EbpfFuncName, SocketFD: sockets[0][1]},
+			{Section: "socket", UID: second.UID, EbpfFuncName: second.EbpfFuncName, SocketFD: sockets[1][1]},
+		},
+		Maps: []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	received := func(fds [2]int) bool {
+		if _, err := unix.Write(fds[0], []byte("packet")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := unix.Read(fds[1], make([]byte, 16))
+		return err == nil
+	}
+
+	if err = m.DetachHook(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := m.GetProbe(second); found {
+		t.Error("expected the second probe to be removed")
+	}
+	if !received(sockets[1]) {
+		t.Error("expected the second socket to be unfiltered")
+	}
+	if received(sockets[0]) {
+		t.Error("expected the first probe to keep filtering its socket")
+	}
+	probe, _ := m.GetProbe(first)
+	if _, err = probe.ProgramID(); err != nil {
+		t.Errorf("expected the shared program to stay open: %v", err)
+	}
+}
+
+func TestCopyProgramConstants(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// the filter accepts the number of bytes stored in the "accepted" global constant
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	datasec := &btf.Datasec{
+		Name: ".rodata",
+		Size: 4,
+		Vars: []btf.VarSecinfo{{Type: &btf.Var{Name: "accepted", Type: u32, Linkage: btf.GlobalVar}, Offset: 0, Size: 4}},
+	}
+	spec := &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			".rodata": {
+				Name:       ".rodata",
+				Type:       ebpf.Array,
+				KeySize:    4,
+				ValueSize:  4,
+				MaxEntries: 1,
+				Flags:      unix.BPF_F_RDONLY_PROG,
+				Freeze:     true,
+				Key:        u32,
+				Value:      datasec,
+				Contents:   []ebpf.MapKV{{Key: uint32(0), Value: make([]byte, 4)}},
+			},
+		},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"filter": {
+				Name:    "filter",
+				Type:    ebpf.SocketFilter,
+				License: "MIT",
+				Instructions: asm.Instructions{
+					asm.LoadMapValue(asm.R1, 0, 0).WithReference(".rodata"),
+					asm.LoadMem(asm.R0, asm.R1, 0, asm.Word),
+					asm.Return(),
+				},
+			},
+		},
+		ByteOrder: binary.LittleEndian,
+	}
+
+	var sockets [2][2]int
+	for i := range sockets {
+		var err error
+		if sockets[i], err = unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(sockets[i][0])
+		defer unix.Close(sockets[i][1])
+	}
+	dropping := ProbeIdentificationPair{UID: "dropping", EbpfFuncName: "filter"}
+	accepting := ProbeIdentificationPair{UID: "accepting", EbpfFuncName: "filter"}
+	m := &Manager{
+		Probes: []*Probe{
+			{Section: "socket", UID: dropping.UID, EbpfFuncName: dropping.EbpfFuncName, SocketFD: sockets[0][1], CopyProgram: true},
+			{Section: "socket", UID: accepting.UID, EbpfFuncName: accepting.EbpfFuncName, SocketFD: sockets[1][1], CopyProgram: true},
+		},
+	}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{
+		ConstantEditors: []ConstantEditor{
+			{Name: "accepted", Value: uint32(0), ProbeIdentificationPairs: []ProbeIdentificationPair{dropping}},
+			{Name: "accepted", Value: uint32(16), ProbeIdentificationPairs: []ProbeIdentificationPair{accepting}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := func(fds [2]int) bool {
+		if _, err := unix.Write(fds[0], []byte("packet")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := unix.Read(fds[1], make([]byte, 16))
+		return err == nil
+	}
+	if received(sockets[0]) {
+		t.Error("expected the packet to be dropped by the first copy")
+	}
+	if !received(sockets[1]) {
+		t.Error("expected the packet to be accepted by the second copy")
+	}
+}
+
+func TestMapSpecEditors(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newSpec := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+			"sessions": {Name: "sessions", Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 16},
+		}}
+	}
+	cpus, err := possibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{}
+	err = m.InitWithAssets([]CollectionAsset{{Spec: newSpec()}}, Options{MapSpecEditors: map[string]MapSpecEditor{
+		"sessions": {
+			Type:             ebpf.LRUHash,
+			MaxEntriesPerCPU: 8,
+			KeySize:          8,
+			ValueSize:        16,
+			Name:             "sessions_lru",
+			EditorFlag:       EditType | EditMaxEntries | EditKeyValueSize | EditName,
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	sessions, _, _ := m.GetMap("sessions")
+	info, err := sessions.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != ebpf.LRUHash || info.MaxEntries != uint32(8*cpus) || info.KeySize != 8 || info.ValueSize != 16 || info.Name != "sessions_lru" {
+		t.Errorf("unexpected map %+v", info)
+	}
+
+	// the edited spec must fit the type of the map
+	for _, editor := range []MapSpecEditor{
+		{Type: ebpf.RingBuf, EditorFlag: EditType},
+		{Type: ebpf.HashOfMaps, EditorFlag: EditType},
+		{MaxEntries: 0, EditorFlag: EditMaxEntries},
+		{Name: "a_very_long_map_name", EditorFlag: EditName},
+	} {
+		err = (&Manager{}).InitWithAssets([]CollectionAsset{{Spec: newSpec()}}, Options{MapSpecEditors: map[string]MapSpecEditor{"sessions": editor}})
+		if !errors.Is(err, ErrIncompatibleMapSpec) {
+			t.Errorf("expected ErrIncompatibleMapSpec for %+v, got %v", editor, err)
+		}
+	}
+}
+
+func TestMapTypeFallbacks(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// pretend the kernel doesn't support LRU hash maps nor ring buffers
+	haveMapType = func(mapType ebpf.MapType) error {
+		if mapType == ebpf.LRUHash || mapType == ebpf.LRUCPUHash || mapType == ebpf.RingBuf {
+			return ebpf.ErrNotSupported
+		}
+		return nil
+	}
+	defer func() {
+		haveMapType = features.HaveMapType
+	}()
+
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"sessions": {Name: "sessions", Type: ebpf.LRUCPUHash, KeySize: 4, ValueSize: 4, MaxEntries: 16, Flags: unix.BPF_F_NO_COMMON_LRU},
+		"events":   {Name: "events", Type: ebpf.RingBuf, MaxEntries: uint32(os.Getpagesize())},
+		"counters": {Name: "counters", Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 4},
+	}}
+	m := &Manager{RingBuffers: []*RingBuffer{{
+		Map:               Map{Name: "events"},
+		RingBufferOptions: RingBufferOptions{DataHandler: func([]byte, *RingBuffer, *Manager) {}},
+	}}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{MapTypeFallbacks: DefaultMapTypeFallbacks}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	for name, expected := range map[string]ebpf.MapType{"sessions": ebpf.Hash, "events": ebpf.PerfEventArray, "counters": ebpf.Array} {
+		array, _, _ := m.GetMap(name)
+		if array.Type() != expected {
+			t.Errorf("expected map %s of type %s, got %s", name, expected, array.Type())
+		}
+	}
+	expected := []MapTypeSubstitution{
+		{Map: "events", Requested: ebpf.RingBuf.String(), Selected: ebpf.PerfEventArray.String()},
+		{Map: "sessions", Requested: ebpf.LRUCPUHash.String(), Selected: ebpf.Hash.String()},
+	}
+	if substitutions := m.MapTypeSubstitutions(); !reflect.DeepEqual(substitutions, expected) {
+		t.Errorf("unexpected substitutions %+v", substitutions)
+	}
+
+	// the ring buffer reads the substituted perf event array
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := m.DumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "Map substitution: sessions: "+ebpf.LRUCPUHash.String()+" -> "+ebpf.Hash.String()) {
+		t.Errorf("substitution missing from the dump:\n%s", output)
+	}
+}
+
+func TestProbeFailureHandlerCallsManager(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	// the socket filter can't be attached to a closed socket
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix.Close(fds[0])
+	unix.Close(fds[1])
+
+	found := make(chan bool, 1)
+	m := &Manager{Maps: []*Map{{Name: "map_val"}}}
+	if err = m.InitWithOptions(elf, Options{
+		ProbeFailureHandler: func(probe *Probe, err error) {
+			// the manager is still locked by AddProbe when the failure is reported
+			_, ok, _ := m.GetMap("map_val")
+			found <- ok
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.AddProbe(&Probe{Section: "socket", EbpfFuncName: "rewrite", SocketFD: fds[1]}); err == nil {
+		t.Fatal("expected the probe to fail to attach")
+	}
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Error("expected the failure handler to find map_val")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the failure handler couldn't call the manager")
+	}
+}
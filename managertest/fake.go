@@ -0,0 +1,155 @@
+// Package managertest fakes the perf maps and the ring buffers of an eBPF manager, so that the event processing code
+// of its consumers can be unit tested without a kernel. The samples captured by a RecordSink are replayed through the
+// real dispatch path of the manager (decoders, batches, coalescing...) into the handlers, at their original pace,
+// accelerated, or as fast as possible.
+package managertest
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+const (
+	// AsFastAsPossible - Replay speed at which the recorded samples are replayed without waiting
+	AsFastAsPossible = 0
+	// OriginalPace - Replay speed at which the recorded samples are replayed with the delays they were read with
+	OriginalPace = 1
+)
+
+// FakePerfMap - Perf map in TestMode owned by its own manager, see NewFakePerfMap
+type FakePerfMap struct {
+	// PerfMap - Perf map whose handlers receive the injected samples
+	PerfMap *manager.PerfMap
+
+	// Manager - Manager of the perf map, passed to its handlers
+	Manager *manager.Manager
+
+	// Sleep - Waits between two replayed samples, see Replay. Defaults to time.Sleep, replace it to replay
+	// deterministically.
+	Sleep func(d time.Duration)
+}
+
+// NewFakePerfMap - Creates and starts a perf map in TestMode with the provided name and options
+func NewFakePerfMap(name string, options manager.PerfMapOptions) (*FakePerfMap, error) {
+	options.TestMode = true
+	perfMap := &manager.PerfMap{Map: manager.Map{Name: name}, PerfMapOptions: options}
+	m := &manager.Manager{PerfMaps: []*manager.PerfMap{perfMap}}
+	if err := m.InitTestMode(manager.Options{}); err != nil {
+		return nil, err
+	}
+	if err := perfMap.Start(); err != nil {
+		return nil, err
+	}
+	return &FakePerfMap{PerfMap: perfMap, Manager: m, Sleep: time.Sleep}, nil
+}
+
+// Inject - Feeds a sample to the handlers of the perf map, as if it was read from the perf ring buffer of the
+// provided CPU
+func (f *FakePerfMap) Inject(CPU int, data []byte) error {
+	return f.PerfMap.InjectSample(CPU, data)
+}
+
+// InjectLost - Reports lost samples to the handlers of the perf map, as if the kernel dropped count samples on the
+// provided CPU
+func (f *FakePerfMap) InjectLost(CPU int, count uint64) error {
+	return f.PerfMap.InjectLostSamples(CPU, count)
+}
+
+// Replay - Feeds the samples recorded in the provided reader to the handlers of the perf map. speed scales the delays
+// between the samples: OriginalPace waits as long as when they were recorded, 10 replays ten times faster, and
+// AsFastAsPossible doesn't wait. The samples of a ring buffer are replayed on CPU 0.
+func (f *FakePerfMap) Replay(r io.Reader, speed float64) error {
+	return replay(r, speed, f.Sleep, func(sample manager.RecordedSample) error {
+		if sample.CPU < 0 {
+			sample.CPU = 0
+		}
+		if sample.LostSamples > 0 {
+			return f.InjectLost(sample.CPU, sample.LostSamples)
+		}
+		return f.Inject(sample.CPU, sample.Data)
+	})
+}
+
+// Close - Stops the perf map, once the pending batches and coalesced samples were handled
+func (f *FakePerfMap) Close() error {
+	return f.Manager.Stop(manager.CleanAll)
+}
+
+// FakeRingBuffer - Ring buffer in TestMode owned by its own manager, see NewFakeRingBuffer
+type FakeRingBuffer struct {
+	// RingBuffer - Ring buffer whose handlers receive the injected samples
+	RingBuffer *manager.RingBuffer
+
+	// Manager - Manager of the ring buffer, passed to its handlers
+	Manager *manager.Manager
+
+	// Sleep - Waits between two replayed samples, see Replay. Defaults to time.Sleep, replace it to replay
+	// deterministically.
+	Sleep func(d time.Duration)
+}
+
+// NewFakeRingBuffer - Creates and starts a ring buffer in TestMode with the provided name and options
+func NewFakeRingBuffer(name string, options manager.RingBufferOptions) (*FakeRingBuffer, error) {
+	options.TestMode = true
+	ringBuffer := &manager.RingBuffer{Map: manager.Map{Name: name}, RingBufferOptions: options}
+	m := &manager.Manager{RingBuffers: []*manager.RingBuffer{ringBuffer}}
+	if err := m.InitTestMode(manager.Options{}); err != nil {
+		return nil, err
+	}
+	if err := ringBuffer.Start(); err != nil {
+		return nil, err
+	}
+	return &FakeRingBuffer{RingBuffer: ringBuffer, Manager: m, Sleep: time.Sleep}, nil
+}
+
+// Inject - Feeds a sample to the handlers of the ring buffer, as if it was read from the ring buffer
+func (f *FakeRingBuffer) Inject(data []byte) error {
+	return f.RingBuffer.InjectSample(data)
+}
+
+// Replay - Feeds the samples recorded in the provided reader to the handlers of the ring buffer, see
+// FakePerfMap.Replay. The lost samples reports of a perf map are ignored.
+func (f *FakeRingBuffer) Replay(r io.Reader, speed float64) error {
+	return replay(r, speed, f.Sleep, func(sample manager.RecordedSample) error {
+		if sample.LostSamples > 0 {
+			return nil
+		}
+		return f.Inject(sample.Data)
+	})
+}
+
+// Close - Stops the ring buffer, once the handlers are done
+func (f *FakeRingBuffer) Close() error {
+	return f.Manager.Stop(manager.CleanAll)
+}
+
+// replay - Reads the samples recorded in the provided reader and injects them, waiting between two samples for the
+// delay they were recorded with, divided by speed
+func replay(r io.Reader, speed float64, sleep func(d time.Duration), inject func(sample manager.RecordedSample) error) error {
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	reader := manager.NewRecordReader(r)
+	var previous time.Time
+	for {
+		sample, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if speed > 0 && !previous.IsZero() {
+			if delay := time.Duration(float64(sample.Timestamp.Sub(previous)) / speed); delay > 0 {
+				sleep(delay)
+			}
+		}
+		previous = sample.Timestamp
+		if err = inject(sample); err != nil {
+			return err
+		}
+	}
+}
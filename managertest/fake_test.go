@@ -0,0 +1,97 @@
+package managertest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+// writeRecord - Appends a sample framed like the samples written to a RecordSink
+func writeRecord(buf *bytes.Buffer, CPU int, timestamp time.Duration, data []byte, lostSamples uint64) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(int32(CPU)))
+	binary.LittleEndian.PutUint64(header[8:], uint64(time.Unix(1700000000, 0).Add(timestamp).UnixNano()))
+	binary.LittleEndian.PutUint64(header[16:], lostSamples)
+	buf.Write(header)
+	buf.Write(data)
+}
+
+func TestFakePerfMap(t *testing.T) {
+	var capture bytes.Buffer
+	writeRecord(&capture, 1, 0, []byte("open"), 0)
+	writeRecord(&capture, 2, 100*time.Millisecond, nil, 3)
+	writeRecord(&capture, -1, 300*time.Millisecond, []byte("exec"), 0)
+
+	var samples []string
+	var lost uint64
+	fake, err := NewFakePerfMap("events", manager.PerfMapOptions{
+		DataHandler: func(CPU int, data []byte, perfMap *manager.PerfMap, m *manager.Manager) {
+			samples = append(samples, string(data))
+		},
+		LostHandler: func(CPU int, count uint64, perfMap *manager.PerfMap, m *manager.Manager) {
+			lost += count
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delays []time.Duration
+	fake.Sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+
+	if err = fake.Replay(&capture, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"open", "exec"}; !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected samples %v, got %v", expected, samples)
+	}
+	if lost != 3 {
+		t.Errorf("expected 3 lost samples, got %d", lost)
+	}
+	if expected := []time.Duration{50 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected the delays to be halved to %v, got %v", expected, delays)
+	}
+}
+
+func TestFakeRingBuffer(t *testing.T) {
+	var capture bytes.Buffer
+	writeRecord(&capture, -1, 0, []byte{1}, 0)
+	writeRecord(&capture, -1, time.Hour, []byte{2}, 0)
+
+	var samples [][]byte
+	fake, err := NewFakeRingBuffer("events", manager.RingBufferOptions{
+		DataHandler: func(data []byte, ringBuffer *manager.RingBuffer, m *manager.Manager) {
+			samples = append(samples, data)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Sleep = func(d time.Duration) {
+		t.Errorf("unexpected wait of %v", d)
+	}
+	if err = fake.Replay(&capture, AsFastAsPossible); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Inject([]byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][]byte{{1}, {2}, {3}}; !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected samples %v, got %v", expected, samples)
+	}
+	if err = fake.Inject([]byte{4}); err == nil {
+		t.Error("expected an error once the ring buffer is stopped")
+	}
+}
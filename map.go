@@ -36,6 +36,34 @@ const (
 	CleanInternal                MapCleanupType = CleanInternalPinned | CleanInternalNotPinned
 	CleanExternal                MapCleanupType = CleanExternalPinned | CleanExternalPinnedAndEdited | CleanExternalEdited
 	CleanAll                     MapCleanupType = CleanInternal | CleanExternal
+	// CleanKeepPinned - Cleans up everything but the pinned maps, so that a new instance of the manager can reuse them.
+	// See MapOptions.LoadPinPath.
+	CleanKeepPinned MapCleanupType = CleanInternalNotPinned | CleanExternalEdited
+)
+
+// CleanupType - Overrides, for a single probe or map, how it is cleaned up when the manager stops, so that objects
+// with different lifecycles can live in the same manager: for example an XDP program left attached along with its
+// pinned maps, while the tracing probes are torn down. See Probe.Cleanup and MapOptions.Cleanup.
+type CleanupType int
+
+const (
+	// CleanupDefault - Probes are detached and their pins removed, maps are cleaned up according to the
+	// MapCleanupType given to Stop.
+	CleanupDefault CleanupType = iota
+	// CleanupLeaveAttached - (probes) The probe isn't detached and its pins are left, only the file descriptors of the
+	// manager are closed. The attachments that outlive the process stay: XDP and TC programs attached through netlink,
+	// cgroup programs attached without a bpf_link, socket filters, sk_msg and sk_skb programs, and the bpf_links pinned
+	// at LinkPinPath. The other attachments (kprobes, uprobes, tracepoints, unpinned bpf_links) are released with
+	// their file descriptors.
+	CleanupLeaveAttached
+	// CleanupDetachOnly - (probes) The probe is detached, but the pin of its program is left, so that another process
+	// can attach it again.
+	CleanupDetachOnly
+	// CleanupCloseFD - (maps) The map is closed but its pin is left, whatever the MapCleanupType.
+	CleanupCloseFD
+	// CleanupUnpin - The probe is detached, the map is closed, and their pins are removed, whatever the MapCleanupType
+	// and even if the state of the manager was saved (see Manager.SaveState).
+	CleanupUnpin
 )
 
 // MapOptions - Generic Map options that are not shared with the MapSpec definition
@@ -44,12 +72,48 @@ type MapOptions struct {
 	// already present in the kernel, then it will be loaded from this path.
 	PinPath string
 
+	// LoadPinPath - Path of the map pinned by a previous instance of the manager. If a map is pinned at this path, it
+	// is reused instead of creating a new map so that its content survives upgrades, provided that it matches the map
+	// spec (type, key size, value size, max entries and flags). The reused map is then pinned at PinPath, if set. Stop
+	// the manager with CleanKeepPinned to leave the pinned maps intact.
+	LoadPinPath string
+
 	// AlwaysCleanup - Overrides the clean up type given to the manager. See CleanupType for more.
 	AlwaysCleanup bool
 
+	// Cleanup - Overrides the MapCleanupType given to the manager for this map: CleanupCloseFD leaves its pin, and
+	// CleanupUnpin removes it. See CleanupType.
+	Cleanup CleanupType
+
 	// DumpHandler - Callback function called when manager.Dump() is called
 	// and dump the current state (human readable)
 	DumpHandler func(currentMap *Map, manager *Manager) string
+
+	// FreezeAfterInit - Freezes the map (BPF_MAP_FREEZE) once the manager is done populating it, that is to say at the
+	// end of Manager.Start, after the map editors, the map routes and the tail call routes were applied. A frozen map
+	// can no longer be written from user space. Requires kernel 5.2+.
+	FreezeAfterInit bool
+
+	// InnerMapSpec - (map of maps) Spec of the inner maps of an array or a hash of maps. It is set as the InnerMap of
+	// the outer map spec, unless the spec already defines one.
+	InnerMapSpec *ebpf.MapSpec
+
+	// InnerMaps - (map of maps) Inner maps created and inserted in the outer map at Init time. The inner maps are only
+	// referenced by the outer map, they are released along with it.
+	InnerMaps []InnerMap
+
+	// Poller - Polls the entries of the map from user space once the manager is started, and reports the changes to
+	// its callbacks. See MapPoller.
+	Poller *MapPoller
+}
+
+// InnerMap - Inner map of an array or a hash of maps, see MapOptions.InnerMaps
+type InnerMap struct {
+	// Key - Key of the inner map in the outer map, usually a uint32 index for an array of maps
+	Key interface{}
+
+	// Spec - Spec of the inner map. Defaults to the InnerMap of the outer map spec.
+	Spec *ebpf.MapSpec
 }
 
 type Map struct {
@@ -64,6 +128,11 @@ type Map struct {
 	externalMap bool
 	// editedMap - Indicates that the map was edited at runtime
 	editedMap bool
+	// reused - Indicates that the map was reused from MapOptions.LoadPinPath
+	reused bool
+	// received - Indicates that the map was received from another process, see ReceiveMapFD. Only its file descriptor
+	// is closed at cleanup.
+	received bool
 
 	// Name - Name of the map as defined in its section SEC("maps/[name]")
 	Name string
@@ -78,30 +147,51 @@ type Map struct {
 	MapOptions
 }
 
+// isPerCPUMapType - Returns true if maps of the provided type store one value per CPU
+func isPerCPUMapType(mapType ebpf.MapType) bool {
+	switch mapType {
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash, ebpf.PerCPUCGroupStorage:
+		return true
+	default:
+		return false
+	}
+}
+
 // loadNewMap - Creates a new map instance, loads it and returns a pointer to the Map structure
 func loadNewMap(spec ebpf.MapSpec, options MapOptions) (*Map, error) {
-	// Create new map
-	managerMap := Map{
-		arraySpec:  &spec,
-		Name:       spec.Name,
-		Contents:   spec.Contents,
-		Freeze:     spec.Freeze,
-		MapOptions: options,
+	var managerMap Map
+	if err := loadMap(&managerMap, spec, options); err != nil {
+		return nil, err
 	}
+	return &managerMap, nil
+}
+
+// loadMap - Creates and loads a new map into the provided Map, which can be embedded in a PerfMap or a RingBuffer
+func loadMap(managerMap *Map, spec ebpf.MapSpec, options MapOptions) error {
+	managerMap.arraySpec = &spec
+	managerMap.Name = spec.Name
+	managerMap.Contents = spec.Contents
+	managerMap.Freeze = spec.Freeze
+	managerMap.MapOptions = options
 
 	// Load map
 	var err error
+	if spec.InnerMap == nil && options.InnerMapSpec != nil {
+		spec.InnerMap = options.InnerMapSpec
+	}
 	if managerMap.array, err = ebpf.NewMap(&spec); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Pin map if need be
 	if managerMap.PinPath != "" {
 		if err := managerMap.array.Pin(managerMap.PinPath); err != nil {
-			return nil, errors.New(fmt.Sprintf("error:%v , couldn't pin map %s at %s", err, managerMap.Name, managerMap.PinPath))
+			_ = managerMap.array.Close()
+			managerMap.array = nil
+			return errors.New(fmt.Sprintf("error:%v , couldn't pin map %s at %s", err, managerMap.Name, managerMap.PinPath))
 		}
 	}
-	return &managerMap, nil
+	return nil
 }
 
 // Init - Initialize a map
@@ -127,10 +217,167 @@ func (m *Map) Init(manager *Manager) error {
 			}
 		}
 	}
+
+	// Populate the outer map with its inner maps, unless it was loaded from an external source
+	if !m.externalMap {
+		if err := m.populateInnerMaps(); err != nil {
+			return err
+		}
+	}
+	if m.Poller != nil {
+		if err := m.Poller.init(m, manager); err != nil {
+			return err
+		}
+	}
 	m.state = initialized
 	return nil
 }
 
+// populateInnerMaps - (map of maps) creates the inner maps of the map and inserts them in the outer map. The inner
+// maps are closed once inserted, the outer map holds a reference on them.
+func (m *Map) populateInnerMaps() error {
+	for _, innerMap := range m.InnerMaps {
+		spec := innerMap.Spec
+		if spec == nil && m.arraySpec != nil {
+			spec = m.arraySpec.InnerMap
+		}
+		if spec == nil {
+			return fmt.Errorf("error:%v , no spec for the inner map %v of %s", ErrUnknownMap, innerMap.Key, m.Name)
+		}
+		array, err := ebpf.NewMap(spec.Copy())
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't create the inner map %v of %s", err, innerMap.Key, m.Name))
+		}
+		err = m.array.Put(innerMap.Key, array)
+		_ = array.Close()
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't insert the inner map %v of %s", err, innerMap.Key, m.Name))
+		}
+	}
+	return nil
+}
+
+// GetInnerMaps - (map of maps) Returns the inner maps created at Init time, in the order of MapOptions.InnerMaps. The
+// inner maps are looked up in the outer map: the caller owns the returned maps and must close them.
+func (m *Map) GetInnerMaps() ([]*ebpf.Map, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return nil, ErrMapNotInitialized
+	}
+	innerMaps := make([]*ebpf.Map, 0, len(m.InnerMaps))
+	for _, innerMap := range m.InnerMaps {
+		var array *ebpf.Map
+		if err := m.array.Lookup(innerMap.Key, &array); err != nil {
+			for _, previous := range innerMaps {
+				_ = previous.Close()
+			}
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't look up the inner map %v of %s", err, innerMap.Key, m.Name))
+		}
+		innerMaps = append(innerMaps, array)
+	}
+	return innerMaps, nil
+}
+
+// Reused - Returns true if the map was reused from the map pinned at MapOptions.LoadPinPath instead of being created
+func (m *Map) Reused() bool {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.reused
+}
+
+// FreezeMap - Freezes the underlying eBPF map (BPF_MAP_FREEZE) so that it can no longer be written from user space.
+// The map should be populated first. Requires kernel 5.2+.
+func (m *Map) FreezeMap() error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	if err := m.array.Freeze(); err != nil {
+		return fmt.Errorf("error:%w , couldn't freeze map %s", err, m.Name)
+	}
+	return nil
+}
+
+// ID - Returns the kernel ID of the underlying eBPF map
+func (m *Map) ID() (ebpf.MapID, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return 0, fmt.Errorf("error:%w , map %s", ErrMapNotInitialized, m.Name)
+	}
+	info, err := m.array.Info()
+	if err != nil {
+		return 0, fmt.Errorf("error:%w , couldn't get info of map %s", err, m.Name)
+	}
+	id, ok := info.ID()
+	if !ok {
+		return 0, fmt.Errorf("couldn't get the ID of map %s: not supported by the kernel", m.Name)
+	}
+	return id, nil
+}
+
+// Put - Inserts or updates the provided key with the provided value. Keys and values are marshaled by the underlying
+// eBPF map, see ebpf.Map.Put for the supported types.
+func (m *Map) Put(key, value interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	return m.array.Put(key, value)
+}
+
+// Get - Looks up the provided key and unmarshals the value into valueOut, which should be a pointer. ErrKeyNotExist
+// is returned if the key doesn't exist.
+func (m *Map) Get(key, valueOut interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	return m.array.Lookup(key, valueOut)
+}
+
+// Delete - Removes the provided key from the map. ErrKeyNotExist is returned if the key doesn't exist.
+func (m *Map) Delete(key interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	return m.array.Delete(key)
+}
+
+// PutPerCPU - (per-CPU maps) Inserts or updates the provided key with one value per possible CPU. values should be a
+// slice with one entry per possible CPU.
+func (m *Map) PutPerCPU(key, values interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	if !isPerCPUMapType(m.array.Type()) {
+		return fmt.Errorf("error:%w , map %s has type %s", ErrNotPerCPUMap, m.Name, m.array.Type())
+	}
+	return m.array.Put(key, values)
+}
+
+// GetPerCPU - (per-CPU maps) Looks up the provided key and unmarshals the values of all the possible CPUs into
+// valuesOut, which should be a pointer to a slice. ErrKeyNotExist is returned if the key doesn't exist.
+func (m *Map) GetPerCPU(key, valuesOut interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return ErrMapNotInitialized
+	}
+	if !isPerCPUMapType(m.array.Type()) {
+		return fmt.Errorf("error:%w , map %s has type %s", ErrNotPerCPUMap, m.Name, m.array.Type())
+	}
+	return m.array.Lookup(key, valuesOut)
+}
+
 // Close - Close underlying eBPF map. When externalCleanup is set to true, even if the map was recovered from an external
 // source (pinned or rewritten from another manager), the map is cleaned up.
 func (m *Map) Close(cleanup MapCleanupType) error {
@@ -144,9 +391,17 @@ func (m *Map) Close(cleanup MapCleanupType) error {
 
 // close - (not thread safe) close
 func (m *Map) close(cleanup MapCleanupType) error {
-	var shouldClose bool
-	if m.AlwaysCleanup {
-		shouldClose = true
+	if m.received {
+		if err := m.array.Close(); err != nil {
+			return err
+		}
+		m.reset()
+		return nil
+	}
+	shouldClose := m.AlwaysCleanup || m.Cleanup == CleanupCloseFD || m.Cleanup == CleanupUnpin
+	if m.Cleanup != CleanupDefault {
+		// the clean up type of the map overrides the one of the manager
+		cleanup = 0
 	}
 	if cleanup&CleanInternalPinned == CleanInternalPinned {
 		if !m.externalMap && m.PinPath != "" {
@@ -175,8 +430,10 @@ func (m *Map) close(cleanup MapCleanupType) error {
 	}
 	if shouldClose {
 		var err error
-		// Remove pin if needed
-		if m.PinPath != "" {
+		// Remove pin if needed, the pins of a saved state are left to the next instance of the manager, unless the map
+		// is always unpinned
+		removePin := m.manager == nil || !m.manager.stateSaved || m.Cleanup == CleanupUnpin
+		if m.PinPath != "" && m.Cleanup != CleanupCloseFD && removePin {
 			err = ConcatErrors(err, os.Remove(m.PinPath))
 		}
 		err = ConcatErrors(err, m.array.Close())
@@ -196,4 +453,6 @@ func (m *Map) reset() {
 	m.state = reset
 	m.externalMap = false
 	m.editedMap = false
+	m.reused = false
+	m.received = false
 }
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cilium/ebpf"
+)
+
+// MapBatchResult - Result of Manager.UpdateMapBatch and Manager.DeleteMapBatch
+type MapBatchResult struct {
+	// Processed - Number of entries that were updated or deleted
+	Processed int
+
+	// Batched - True if the entries were processed with the batch syscalls of the kernel (kernel 5.6+), false if they
+	// were processed one by one
+	Batched bool
+
+	// Failed - Errors of the entries that couldn't be updated or deleted, indexed by their position in the provided
+	// slices
+	Failed map[int]error
+}
+
+// UpdateMapBatch - Updates the provided map with the provided keys and values, which should be slices of the same
+// length, according to flags. The entries are written with BPF_MAP_UPDATE_BATCH when it is supported by the kernel and
+// by the type of the map, and one by one otherwise (per-CPU maps, keys or values without a fixed size, UpdateNoExist
+// and UpdateExist which the batch syscall doesn't support). Every entry is attempted: the returned error wraps ErrMapBatchFailed when some of them failed, see MapBatchResult.Failed.
+func (m *Manager) UpdateMapBatch(name string, keys, values interface{}, flags ebpf.MapUpdateFlags) (*MapBatchResult, error) {
+	array, err := m.lookupMap(name)
+	if err != nil {
+		return nil, err
+	}
+	keysValue, valuesValue := reflect.ValueOf(keys), reflect.ValueOf(values)
+	if keysValue.Kind() != reflect.Slice || valuesValue.Kind() != reflect.Slice || keysValue.Len() != valuesValue.Len() {
+		return nil, fmt.Errorf("error:%w , map %s", ErrInvalidMapBatch, name)
+	}
+	opts := &ebpf.BatchOptions{ElemFlags: uint64(flags)}
+	batchable := flags&^ebpf.UpdateLock == 0 && fixedSizeElems(keysValue) && fixedSizeElems(valuesValue)
+	return runMapBatch(name, keysValue.Len(), batchable, func(start, end int) (int, error) {
+		return array.BatchUpdate(keysValue.Slice(start, end).Interface(), valuesValue.Slice(start, end).Interface(), opts)
+	}, func(i int) error {
+		return array.Update(keysValue.Index(i).Interface(), valuesValue.Index(i).Interface(), flags)
+	})
+}
+
+// DeleteMapBatch - Deletes the provided keys, which should be a slice, from the provided map. The entries are deleted
+// with BPF_MAP_DELETE_BATCH when it is supported by the kernel and by the type of the map, and one by one otherwise.
+// Every key is attempted: the returned error wraps ErrMapBatchFailed when some of them failed, for example with
+// ErrKeyNotExist, see MapBatchResult.Failed.
+func (m *Manager) DeleteMapBatch(name string, keys interface{}) (*MapBatchResult, error) {
+	array, err := m.lookupMap(name)
+	if err != nil {
+		return nil, err
+	}
+	keysValue := reflect.ValueOf(keys)
+	if keysValue.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("error:%w , map %s", ErrInvalidMapBatch, name)
+	}
+	return runMapBatch(name, keysValue.Len(), fixedSizeElems(keysValue), func(start, end int) (int, error) {
+		return array.BatchDelete(keysValue.Slice(start, end).Interface(), nil)
+	}, func(i int) error {
+		return array.Delete(keysValue.Index(i).Interface())
+	})
+}
+
+// fixedSizeElems - Returns true if the elements of the provided slice have a fixed size, see binary.Size
+func fixedSizeElems(slice reflect.Value) bool {
+	return slice.Len() > 0 && binary.Size(slice.Index(0).Interface()) > 0
+}
+
+// runMapBatch - Processes count entries with batch, which returns the number of entries processed before the first
+// failing one, and falls back to single when the batch syscalls aren't supported. The batch is resumed after each
+// failing entry.
+func runMapBatch(name string, count int, batchable bool, batch func(start, end int) (int, error), single func(i int) error) (*MapBatchResult, error) {
+	result := &MapBatchResult{Batched: batchable, Failed: make(map[int]error)}
+	for start := 0; result.Batched && start < count; {
+		processed, err := batch(start, count)
+		if err == nil {
+			result.Processed += count - start
+			break
+		}
+		if start == 0 && processed == 0 && errors.Is(err, ebpf.ErrNotSupported) {
+			result.Batched = false
+			break
+		}
+		if processed >= count-start {
+			return result, errors.New(fmt.Sprintf("error:%v , batch operation on map %s failed", err, name))
+		}
+		result.Processed += processed
+		result.Failed[start+processed] = err
+		start += processed + 1
+	}
+	if !result.Batched {
+		for i := 0; i < count; i++ {
+			if err := single(i); err != nil {
+				result.Failed[i] = err
+				continue
+			}
+			result.Processed++
+		}
+	}
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("error:%w , %d of the %d entries of map %s failed", ErrMapBatchFailed, len(result.Failed), count, name)
+	}
+	return result, nil
+}
@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// PerCPUCombiner - Merges the value of a per-CPU map entry on one CPU into the aggregated value of the entry, see
+// Map.GetAggregated. aggregated and value are pointers to the value type of the map; aggregated holds the value of the
+// first possible CPU before the first call.
+type PerCPUCombiner func(aggregated, value interface{}) error
+
+const (
+	combineSum = iota
+	combineMax
+	combineMin
+)
+
+var (
+	// SumPerCPU - PerCPUCombiner that adds up the values of the CPUs. The integers and floats of structs and arrays
+	// are added up field by field.
+	SumPerCPU PerCPUCombiner = func(aggregated, value interface{}) error {
+		return combineNumbers(aggregated, value, combineSum)
+	}
+
+	// MaxPerCPU - PerCPUCombiner that keeps the largest value of the CPUs, field by field for structs and arrays
+	MaxPerCPU PerCPUCombiner = func(aggregated, value interface{}) error {
+		return combineNumbers(aggregated, value, combineMax)
+	}
+
+	// MinPerCPU - PerCPUCombiner that keeps the smallest value of the CPUs, field by field for structs and arrays
+	MinPerCPU PerCPUCombiner = func(aggregated, value interface{}) error {
+		return combineNumbers(aggregated, value, combineMin)
+	}
+)
+
+// combineNumbers - Merges the numbers of value into the numbers of aggregated, which are pointers to the same type,
+// according to op
+func combineNumbers(aggregated, value interface{}, op int) error {
+	out, in := reflect.ValueOf(aggregated), reflect.ValueOf(value)
+	if out.Kind() != reflect.Ptr || in.Kind() != reflect.Ptr || out.Type() != in.Type() {
+		return fmt.Errorf("error:%w , %T and %T", ErrNotAggregatable, aggregated, value)
+	}
+	return combineValues(out.Elem(), in.Elem(), op)
+}
+
+// combineValues - Recursive version of combineNumbers
+func combineValues(out, in reflect.Value, op int) error {
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := out.Int(), in.Int()
+		if op == combineSum {
+			out.SetInt(a + b)
+		} else if (op == combineMax && b > a) || (op == combineMin && b < a) {
+			out.SetInt(b)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a, b := out.Uint(), in.Uint()
+		if op == combineSum {
+			out.SetUint(a + b)
+		} else if (op == combineMax && b > a) || (op == combineMin && b < a) {
+			out.SetUint(b)
+		}
+	case reflect.Float32, reflect.Float64:
+		a, b := out.Float(), in.Float()
+		if op == combineSum {
+			out.SetFloat(a + b)
+		} else if (op == combineMax && b > a) || (op == combineMin && b < a) {
+			out.SetFloat(b)
+		}
+	case reflect.Array:
+		for i := 0; i < out.Len(); i++ {
+			if err := combineValues(out.Index(i), in.Index(i), op); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < out.NumField(); i++ {
+			// padding and unexported fields are left as they are on the first CPU
+			if !out.Field(i).CanSet() {
+				continue
+			}
+			if err := combineValues(out.Field(i), in.Field(i), op); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("error:%w , %s", ErrNotAggregatable, out.Type())
+	}
+	return nil
+}
+
+// aggregatePerCPU - Merges the values of the provided slice into valueOut with combine
+func aggregatePerCPU(values reflect.Value, valueOut reflect.Value, combine PerCPUCombiner) error {
+	if values.Len() == 0 {
+		valueOut.Elem().Set(reflect.Zero(valueOut.Elem().Type()))
+		return nil
+	}
+	valueOut.Elem().Set(values.Index(0))
+	for i := 1; i < values.Len(); i++ {
+		if err := combine(valueOut.Interface(), values.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// perCPUValues - Returns a pointer to an empty slice of the type pointed to by valueOut, to look up the values of a
+// per-CPU entry
+func (m *Map) perCPUValues(valueOut interface{}) (reflect.Value, error) {
+	if m.state < initialized {
+		return reflect.Value{}, ErrMapNotInitialized
+	}
+	if !isPerCPUMapType(m.array.Type()) {
+		return reflect.Value{}, fmt.Errorf("error:%w , map %s has type %s", ErrNotPerCPUMap, m.Name, m.array.Type())
+	}
+	out := reflect.ValueOf(valueOut)
+	if out.Kind() != reflect.Ptr || out.IsNil() {
+		return reflect.Value{}, fmt.Errorf("error:%w , map %s: %T isn't a pointer", ErrNotAggregatable, m.Name, valueOut)
+	}
+	return reflect.New(reflect.SliceOf(out.Elem().Type())), nil
+}
+
+// GetAggregated - (per-CPU maps) Looks up the provided key and merges the values of all the possible CPUs into
+// valueOut with combine, see SumPerCPU, MaxPerCPU and MinPerCPU. valueOut should be a pointer to the value type of the
+// map. ErrKeyNotExist is returned if the key doesn't exist.
+func (m *Map) GetAggregated(key, valueOut interface{}, combine PerCPUCombiner) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	values, err := m.perCPUValues(valueOut)
+	if err != nil {
+		return err
+	}
+	if err = m.array.Lookup(key, values.Interface()); err != nil {
+		return err
+	}
+	return aggregatePerCPU(values.Elem(), reflect.ValueOf(valueOut), combine)
+}
+
+// GetSum - (per-CPU maps) Looks up the provided key and adds up the values of all the possible CPUs into valueOut, see
+// GetAggregated and SumPerCPU
+func (m *Map) GetSum(key, valueOut interface{}) error {
+	return m.GetAggregated(key, valueOut, SumPerCPU)
+}
+
+// GetMax - (per-CPU maps) Looks up the provided key and returns the largest value of all the possible CPUs into
+// valueOut, see GetAggregated and MaxPerCPU
+func (m *Map) GetMax(key, valueOut interface{}) error {
+	return m.GetAggregated(key, valueOut, MaxPerCPU)
+}
+
+// IterateAggregated - (per-CPU maps) Walks the entries of the map: for each entry, the key is unmarshaled into keyPtr,
+// the values of all the possible CPUs are merged into valueOut with combine, then fn is called. The iteration stops
+// at the first error returned by fn or combine, which is returned.
+func (m *Map) IterateAggregated(keyPtr, valueOut interface{}, combine PerCPUCombiner, fn func() error) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	values, err := m.perCPUValues(valueOut)
+	if err != nil {
+		return err
+	}
+	iterator := m.array.Iterate()
+	for iterator.Next(keyPtr, values.Interface()) {
+		if err = aggregatePerCPU(values.Elem(), reflect.ValueOf(valueOut), combine); err != nil {
+			return err
+		}
+		if err = fn(); err != nil {
+			return err
+		}
+	}
+	if err = iterator.Err(); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't iterate over map %s", err, m.Name))
+	}
+	return nil
+}
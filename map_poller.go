@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// DefaultMapPollInterval - Default interval between two polls of a map, see MapPoller
+const DefaultMapPollInterval = time.Second
+
+// MapPoller - Polls a hash or an array map from user space, for the programs that share their state through plain
+// maps rather than perf maps or ring buffers, see MapOptions.Poller. The entries are read every Interval and compared
+// with the previous poll: OnUpdate is called for the new entries and the entries whose value changed, OnDelete for
+// the entries that are gone. The first poll reports all the entries of the map, including the zeroed entries of an
+// array. The changes made between two polls are coalesced, a value that changed and changed back isn't reported.
+type MapPoller struct {
+	// Interval - Interval between two polls of the map. Defaults to DefaultMapPollInterval.
+	Interval time.Duration
+
+	// KeyDecoder - Decodes the keys of the map, see NewStructDecoder and NewBTFDecoder
+	KeyDecoder Decoder
+
+	// KeyType - Name of a type of the BTF of the manager. When KeyDecoder isn't set, the keys are decoded according to
+	// the layout of this type. Defaults to the BTF key type of the map, if any, otherwise the keys are left as []byte.
+	KeyType string
+
+	// ValueDecoder - Decodes the values of the map, see NewStructDecoder and NewBTFDecoder
+	ValueDecoder Decoder
+
+	// ValueType - Name of a type of the BTF of the manager. When ValueDecoder isn't set, the values are decoded
+	// according to the layout of this type. Defaults to the BTF value type of the map, if any, otherwise the values
+	// are left as []byte. The values of the per-CPU maps are decoded for each possible CPU into a []interface{}.
+	ValueType string
+
+	// OnUpdate - Callback function called with the decoded key and value of a new or updated entry, previous is the
+	// value seen by the previous poll, nil for a new entry
+	OnUpdate func(key, value, previous interface{}, m *Map, manager *Manager)
+
+	// OnDelete - Callback function called with the decoded key and the last seen value of a deleted entry
+	OnDelete func(key, previous interface{}, m *Map, manager *Manager)
+
+	entries map[string][]byte
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// isPollableMapType - Returns true if the entries of maps of the provided type can be polled
+func isPollableMapType(mapType ebpf.MapType) bool {
+	switch mapType {
+	case ebpf.Hash, ebpf.Array, ebpf.LRUHash, ebpf.LPMTrie, ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash:
+		return true
+	default:
+		return false
+	}
+}
+
+// init - Checks the poller of the provided map and resolves its decoders
+func (p *MapPoller) init(m *Map, manager *Manager) error {
+	if m.array != nil && !isPollableMapType(m.array.Type()) {
+		return fmt.Errorf("error:%w , map %s of type %s can't be polled", ErrInvalidMapPoller, m.Name, m.array.Type())
+	}
+	if p.OnUpdate == nil && p.OnDelete == nil {
+		return fmt.Errorf("error:%w , map %s: no OnUpdate or OnDelete callback", ErrInvalidMapPoller, m.Name)
+	}
+	if p.Interval < 0 {
+		return fmt.Errorf("error:%w , map %s: negative interval", ErrInvalidMapPoller, m.Name)
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultMapPollInterval
+	}
+
+	resolve := func(decoder Decoder, name string, typ btf.Type) (Decoder, error) {
+		if decoder != nil {
+			return decoder, nil
+		}
+		if name != "" {
+			return manager.newEventTypeDecoder(name)
+		}
+		if typ == nil || manager.collectionSpec == nil {
+			return nil, nil
+		}
+		if _, isVoid := typ.(*btf.Void); isVoid {
+			return nil, nil
+		}
+		return NewBTFDecoder(typ, manager.collectionSpec.ByteOrder), nil
+	}
+	var keyType, valueType btf.Type
+	if m.arraySpec != nil {
+		keyType, valueType = m.arraySpec.Key, m.arraySpec.Value
+	}
+	var err error
+	if p.KeyDecoder, err = resolve(p.KeyDecoder, p.KeyType, keyType); err != nil {
+		return err
+	}
+	if p.ValueDecoder, err = resolve(p.ValueDecoder, p.ValueType, valueType); err != nil {
+		return err
+	}
+	p.entries = nil
+	return nil
+}
+
+// start - Starts polling the provided map
+func (p *MapPoller) start(m *Map, manager *Manager) {
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	stop := p.stop
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			if err := p.poll(m, manager); err != nil {
+				manager.reportError(fmt.Errorf("error:%w , couldn't poll map %s", err, m.Name))
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopPolling - Stops polling the map and waits until the poll in progress is done
+func (p *MapPoller) stopPolling() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.done.Wait()
+	p.stop = nil
+}
+
+// poll - Reads the entries of the map and calls the callbacks of the entries that changed since the previous poll
+func (p *MapPoller) poll(m *Map, manager *Manager) error {
+	array := m.array
+	byteArray := func(size uint32) reflect.Type {
+		return reflect.ArrayOf(int(size), reflect.TypeOf(byte(0)))
+	}
+	perCPU := isPerCPUMapType(array.Type())
+	key := reflect.New(byteArray(array.KeySize()))
+	valueType := byteArray(array.ValueSize())
+	if perCPU {
+		valueType = reflect.SliceOf(valueType)
+	}
+	value := reflect.New(valueType)
+	raw := func(data reflect.Value) []byte {
+		out := make([]byte, data.Len())
+		reflect.Copy(reflect.ValueOf(out), data)
+		return out
+	}
+
+	entries := make(map[string][]byte, len(p.entries))
+	err := iterateArray(m.Name, array, key.Interface(), value.Interface(), func() error {
+		var rawValue []byte
+		if !perCPU {
+			rawValue = raw(value.Elem())
+		} else {
+			for cpu := 0; cpu < value.Elem().Len(); cpu++ {
+				rawValue = append(rawValue, raw(value.Elem().Index(cpu))...)
+			}
+		}
+		entries[string(raw(key.Elem()))] = rawValue
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for rawKey, rawValue := range entries {
+		previous, seen := p.entries[rawKey]
+		if seen && bytes.Equal(previous, rawValue) || p.OnUpdate == nil {
+			continue
+		}
+		decodedKey, decodedValue, err := p.decode(array, rawKey, rawValue)
+		if err != nil {
+			errs = ConcatErrors(errs, err)
+			continue
+		}
+		var decodedPrevious interface{}
+		if seen {
+			if _, decodedPrevious, err = p.decode(array, rawKey, previous); err != nil {
+				errs = ConcatErrors(errs, err)
+				continue
+			}
+		}
+		p.OnUpdate(decodedKey, decodedValue, decodedPrevious, m, manager)
+	}
+	for rawKey, previous := range p.entries {
+		if _, ok := entries[rawKey]; ok || p.OnDelete == nil {
+			continue
+		}
+		decodedKey, decodedPrevious, err := p.decode(array, rawKey, previous)
+		if err != nil {
+			errs = ConcatErrors(errs, err)
+			continue
+		}
+		p.OnDelete(decodedKey, decodedPrevious, m, manager)
+	}
+	p.entries = entries
+	return errs
+}
+
+// decode - Decodes the provided raw key and value
+func (p *MapPoller) decode(array *ebpf.Map, rawKey string, rawValue []byte) (interface{}, interface{}, error) {
+	decode := func(decoder Decoder, data []byte) (interface{}, error) {
+		if decoder == nil {
+			return data, nil
+		}
+		return decoder.Decode(data)
+	}
+	key, err := decode(p.KeyDecoder, []byte(rawKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isPerCPUMapType(array.Type()) {
+		value, err := decode(p.ValueDecoder, rawValue)
+		return key, value, err
+	}
+	size := int(array.ValueSize())
+	var values []interface{}
+	for offset := 0; offset+size <= len(rawValue); offset += size {
+		value, err := decode(p.ValueDecoder, rawValue[offset:offset+size])
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+	}
+	return key, values, nil
+}
+
+// startMapPollers - Starts the pollers of the maps of the manager
+func (m *Manager) startMapPollers() {
+	for _, managerMap := range m.Maps {
+		if managerMap.Poller != nil && managerMap.array != nil {
+			managerMap.Poller.start(managerMap, m)
+		}
+	}
+}
+
+// stopMapPollers - Stops the pollers of the maps of the manager
+func (m *Manager) stopMapPollers() {
+	for _, managerMap := range m.Maps {
+		if managerMap.Poller != nil {
+			managerMap.Poller.stopPolling()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestMapPoller(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	type connection struct {
+		Packets uint32
+		Bytes   uint32
+	}
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	spec := &ebpf.MapSpec{Name: "connections", Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 16, Key: u32}
+	array, err := ebpf.NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	var updates, deletes []string
+	poller := &MapPoller{
+		Interval:     10 * time.Millisecond,
+		ValueDecoder: NewStructDecoder(connection{}, binary.LittleEndian),
+		OnUpdate: func(key, value, previous interface{}, m *Map, manager *Manager) {
+			entry := value.(*connection)
+			if previous == nil {
+				updates = append(updates, "new")
+			} else if previous.(*connection).Bytes < entry.Bytes {
+				updates = append(updates, "grew")
+			}
+			if key.(uint64) != 1 {
+				t.Errorf("unexpected key %v", key)
+			}
+		},
+		OnDelete: func(key, previous interface{}, m *Map, manager *Manager) {
+			deletes = append(deletes, "deleted")
+		},
+	}
+	m := &Map{array: array, arraySpec: spec, Name: spec.Name, MapOptions: MapOptions{Poller: poller}}
+	manager := &Manager{collectionSpec: &ebpf.CollectionSpec{ByteOrder: binary.LittleEndian}}
+	if err = poller.init(m, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	poll := func() {
+		t.Helper()
+		if err := poller.poll(m, manager); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = array.Put(uint32(1), connection{Packets: 1, Bytes: 100}); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	poll()
+	if err = array.Put(uint32(1), connection{Packets: 2, Bytes: 200}); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	if err = array.Delete(uint32(1)); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	poll()
+	if len(updates) != 2 || updates[0] != "new" || updates[1] != "grew" || len(deletes) != 1 {
+		t.Errorf("unexpected changes %v %v", updates, deletes)
+	}
+
+	// the poller runs in the background once started
+	received := make(chan interface{}, 1)
+	poller.OnUpdate = func(key, value, previous interface{}, m *Map, manager *Manager) {
+		received <- value
+	}
+	poller.start(m, manager)
+	if err = array.Put(uint32(1), connection{Packets: 3, Bytes: 300}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case value := <-received:
+		if value.(*connection).Packets != 3 {
+			t.Errorf("unexpected value %+v", value)
+		}
+	case <-time.After(time.Second):
+		t.Error("the update wasn't polled")
+	}
+	poller.stopPolling()
+
+	ringSpec := &ebpf.MapSpec{Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096}
+	ring, err := ebpf.NewMap(ringSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	invalid := &Map{array: ring, arraySpec: ringSpec, Name: ringSpec.Name}
+	if err = poller.init(invalid, manager); !errors.Is(err, ErrInvalidMapPoller) {
+		t.Errorf("expected ErrInvalidMapPoller, got %v", err)
+	}
+}
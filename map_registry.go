@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// CreateMap - Creates a new map from the provided spec once the manager is initialized, for example to shard a state
+// map on demand. The map is added to the maps of the manager: it is pinned according to the pinning strategy of the
+// manager (or at options.PinPath), closed with the manager according to its map cleanup type, and reported by the
+// dumps. The programs of the manager can reach it through a map of maps, see MapRoute. See RemoveMap.
+func (m *Manager) CreateMap(spec ebpf.MapSpec, options MapOptions) (*Map, error) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	if _, exists := m.getMap(spec.Name); exists {
+		return nil, fmt.Errorf("error:%w , couldn't create map %s", ErrMapNameInUse, spec.Name)
+	}
+	switch m.options.PinningStrategy {
+	case PinByName:
+		options.PinPath = m.pinName("map", spec.Name)
+	case PinNone:
+		options.PinPath = ""
+	}
+
+	managerMap, err := loadNewMap(spec, options)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't create map %s", err, spec.Name))
+	}
+	if err = managerMap.Init(m); err != nil {
+		// Clean up
+		_ = managerMap.close(CleanAll)
+		return nil, err
+	}
+	m.Maps = append(m.Maps, managerMap)
+	return managerMap, nil
+}
+
+// RemoveMap - Closes the provided map, removes its pin and removes it from the maps of the manager. The programs that
+// still reference the map keep it alive in the kernel. See CreateMap.
+func (m *Manager) RemoveMap(name string) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	for i, managerMap := range m.Maps {
+		if managerMap.Name != name {
+			continue
+		}
+		if err := managerMap.Close(CleanAll); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't remove map %s", err, name))
+		}
+		delete(m.collection.Maps, name)
+		m.Maps = append(m.Maps[:i], m.Maps[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("error:%w , couldn't find map %s", ErrUnknownMap, name)
+}
@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMapBatchSize - Default number of entries looked up at once by IterateMap and SnapshotMap
+const DefaultMapBatchSize = 256
+
+// MapEntry - Entry of a map, see Manager.SnapshotMap. The keys and values are decoded with the BTF of the map when
+// it has one (see NewBTFDecoder), and are left as []byte otherwise.
+type MapEntry struct {
+	// Key - Key of the entry
+	Key interface{}
+
+	// Value - Value of the entry, nil for per-CPU maps
+	Value interface{}
+
+	// Values - (per-CPU maps) Value of the entry on each possible CPU
+	Values []interface{}
+}
+
+// IterateMap - Walks the entries of the provided map: for each entry, the key and the value are unmarshaled into
+// keyPtr and valuePtr, then fn is called. The iteration stops at the first error returned by fn, which is returned.
+// For per-CPU maps, valuePtr should be a pointer to a slice, which receives the value of the entry on each possible
+// CPU. When the keys and values have a fixed size (see binary.Size), the entries are looked up in batches with
+// BPF_MAP_LOOKUP_BATCH (kernel 5.6+), and one by one otherwise. The map may be updated during the iteration, in which
+// case entries can be skipped or seen twice.
+func (m *Manager) IterateMap(name string, keyPtr, valuePtr interface{}, fn func() error) error {
+	array, err := m.lookupMap(name)
+	if err != nil {
+		return err
+	}
+	return iterateArray(name, array, keyPtr, valuePtr, fn)
+}
+
+// iterateArray - Walks the entries of the provided eBPF map, see IterateMap
+func iterateArray(name string, array *ebpf.Map, keyPtr, valuePtr interface{}, fn func() error) error {
+	if batchable(array, keyPtr, valuePtr) {
+		supported, err := iterateMapBatch(name, array, keyPtr, valuePtr, fn)
+		if supported {
+			return err
+		}
+	}
+	iterator := array.Iterate()
+	for iterator.Next(keyPtr, valuePtr) {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't iterate over map %s", err, name))
+	}
+	return nil
+}
+
+// SnapshotMap - Returns all the entries of the provided map, see MapEntry and IterateMap
+func (m *Manager) SnapshotMap(name string) ([]MapEntry, error) {
+	array, err := m.lookupMap(name)
+	if err != nil {
+		return nil, err
+	}
+	keyDecoder, valueDecoder := m.mapDecoders(name)
+	perCPU := isPerCPUMapType(array.Type())
+
+	byteArray := func(size uint32) reflect.Type {
+		return reflect.ArrayOf(int(size), reflect.TypeOf(byte(0)))
+	}
+	key := reflect.New(byteArray(array.KeySize()))
+	valueType := byteArray(array.ValueSize())
+	if perCPU {
+		valueType = reflect.SliceOf(valueType)
+	}
+	value := reflect.New(valueType)
+
+	decode := func(decoder Decoder, data reflect.Value) (interface{}, error) {
+		raw := make([]byte, data.Len())
+		reflect.Copy(reflect.ValueOf(raw), data)
+		if decoder == nil {
+			return raw, nil
+		}
+		return decoder.Decode(raw)
+	}
+
+	var entries []MapEntry
+	err = m.IterateMap(name, key.Interface(), value.Interface(), func() error {
+		var entry MapEntry
+		var err error
+		if entry.Key, err = decode(keyDecoder, key.Elem()); err != nil {
+			return err
+		}
+		if !perCPU {
+			entry.Value, err = decode(valueDecoder, value.Elem())
+			entries = append(entries, entry)
+			return err
+		}
+		for cpu := 0; cpu < value.Elem().Len(); cpu++ {
+			cpuValue, err := decode(valueDecoder, value.Elem().Index(cpu))
+			if err != nil {
+				return err
+			}
+			entry.Values = append(entry.Values, cpuValue)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// lookupMap - Returns the provided map of the manager
+func (m *Manager) lookupMap(name string) (*ebpf.Map, error) {
+	array, found, err := m.GetMap(name)
+	if err != nil {
+		return nil, err
+	}
+	if !found || array == nil {
+		return nil, fmt.Errorf("error:%w , couldn't find map %s", ErrUnknownMap, name)
+	}
+	return array, nil
+}
+
+// mapDecoders - Returns the BTF decoders of the keys and values of the provided map, nil if the map has no BTF
+func (m *Manager) mapDecoders(name string) (Decoder, Decoder) {
+	spec, found, err := m.GetMapSpec(name)
+	if err != nil || !found {
+		return nil, nil
+	}
+	decoder := func(typ btf.Type) Decoder {
+		if typ == nil {
+			return nil
+		}
+		if _, isVoid := typ.(*btf.Void); isVoid {
+			return nil
+		}
+		return NewBTFDecoder(typ, m.collectionSpec.ByteOrder)
+	}
+	return decoder(spec.Key), decoder(spec.Value)
+}
+
+// batchable - Returns true if the entries of the map can be looked up in batches into keyPtr and valuePtr
+func batchable(array *ebpf.Map, keyPtr, valuePtr interface{}) bool {
+	if isPerCPUMapType(array.Type()) {
+		return false
+	}
+	fixedSize := func(ptr interface{}) bool {
+		value := reflect.ValueOf(ptr)
+		return value.Kind() == reflect.Ptr && !value.IsNil() && binary.Size(value.Elem().Interface()) > 0
+	}
+	return fixedSize(keyPtr) && fixedSize(valuePtr)
+}
+
+// iterateMapBatch - Walks the entries of the map with BPF_MAP_LOOKUP_BATCH, see IterateMap. Returns false if batch
+// lookups aren't supported by the kernel or by the type of the map, before any entry was walked.
+func iterateMapBatch(name string, array *ebpf.Map, keyPtr, valuePtr interface{}, fn func() error) (bool, error) {
+	keyOut, valueOut := reflect.ValueOf(keyPtr).Elem(), reflect.ValueOf(valuePtr).Elem()
+	size := DefaultMapBatchSize
+	if maxEntries := int(array.MaxEntries()); maxEntries > 0 && maxEntries < size {
+		size = maxEntries
+	}
+	nextKey := reflect.New(keyOut.Type())
+	var prevKey interface{}
+	for {
+		keys := reflect.MakeSlice(reflect.SliceOf(keyOut.Type()), size, size)
+		values := reflect.MakeSlice(reflect.SliceOf(valueOut.Type()), size, size)
+		count, err := array.BatchLookup(prevKey, nextKey.Interface(), keys.Interface(), values.Interface(), nil)
+		if prevKey == nil && errors.Is(err, ebpf.ErrNotSupported) {
+			return false, nil
+		}
+		if errors.Is(err, unix.ENOSPC) && size < int(array.MaxEntries()) {
+			// a bucket of the hash map holds more entries than the batch
+			size *= 2
+			continue
+		}
+		done := errors.Is(err, ebpf.ErrKeyNotExist)
+		if err != nil && !done {
+			return true, errors.New(fmt.Sprintf("error:%v , couldn't look up a batch of entries of map %s", err, name))
+		}
+		for i := 0; i < count; i++ {
+			keyOut.Set(keys.Index(i))
+			valueOut.Set(values.Index(i))
+			if err = fn(); err != nil {
+				return true, err
+			}
+		}
+		if done {
+			return true, nil
+		}
+		prevKey = nextKey.Elem().Interface()
+	}
+}
@@ -0,0 +1,149 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestIterateMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	spec := &ebpf.MapSpec{Name: "values", Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1000, Key: u32, Value: u32}
+	perCPUSpec := &ebpf.MapSpec{Name: "counters", Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 2}
+	values, err := ebpf.NewMap(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer values.Close()
+	counters, err := ebpf.NewMap(perCPUSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counters.Close()
+	for i := uint32(0); i < 600; i++ {
+		if err = values.Put(i, i*2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &Manager{
+		collectionSpec: &ebpf.CollectionSpec{
+			Maps:      map[string]*ebpf.MapSpec{"values": spec, "counters": perCPUSpec},
+			ByteOrder: binary.LittleEndian,
+		},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"values": values, "counters": counters}},
+		state:      initialized,
+	}
+
+	var key, value uint32
+	seen := make(map[uint32]uint32)
+	if err = m.IterateMap("values", &key, &value, func() error {
+		seen[key] = value
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 600 || seen[599] != 1198 {
+		t.Errorf("expected 600 entries, got %d (599: %d)", len(seen), seen[599])
+	}
+
+	stop := errors.New("stop")
+	if err = m.IterateMap("values", &key, &value, func() error {
+		return stop
+	}); !errors.Is(err, stop) {
+		t.Errorf("expected the error of the callback, got %v", err)
+	}
+
+	entries, err := m.SnapshotMap("values")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 600 {
+		t.Fatalf("expected 600 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Value.(uint64) != entry.Key.(uint64)*2 {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	}
+
+	entries, err = m.SnapshotMap("counters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(entries[0].Values) == 0 || len(entries[0].Values[0].([]byte)) != 8 {
+		t.Errorf("unexpected per-CPU entries %+v", entries)
+	}
+
+	if _, err = m.SnapshotMap("unknown"); !errors.Is(err, ErrUnknownMap) {
+		t.Errorf("expected ErrUnknownMap, got %v", err)
+	}
+}
+
+func TestUpdateMapBatch(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	values, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer values.Close()
+	counters, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counters.Close()
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"values": values, "counters": counters}},
+		state:      initialized,
+	}
+
+	keys := make([]uint32, 50)
+	for i := range keys {
+		keys[i] = uint32(i)
+	}
+	result, err := m.UpdateMapBatch("values", keys, keys, ebpf.UpdateAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Processed != 50 || !result.Batched {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// keys 40 to 49 already exist
+	newKeys := []uint32{40, 50, 51, 45, 52}
+	result, err = m.UpdateMapBatch("values", newKeys, newKeys, ebpf.UpdateNoExist)
+	if !errors.Is(err, ErrMapBatchFailed) {
+		t.Fatalf("expected ErrMapBatchFailed, got %v", err)
+	}
+	if result.Processed != 3 || result.Batched || len(result.Failed) != 2 || !errors.Is(result.Failed[0], ebpf.ErrKeyExist) || result.Failed[3] == nil {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	result, err = m.DeleteMapBatch("values", []uint32{1, 99, 2})
+	if !errors.Is(err, ErrMapBatchFailed) || result.Processed != 2 || !result.Batched || !errors.Is(result.Failed[1], ErrKeyNotExist) {
+		t.Errorf("unexpected result %+v (%v)", result, err)
+	}
+
+	// array entries always exist, use the first one to find out the number of possible CPUs
+	var perCPU []uint64
+	if err = counters.Lookup(uint32(0), &perCPU); err != nil {
+		t.Fatal(err)
+	}
+	result, err = m.UpdateMapBatch("counters", []uint32{1, 2}, [][]uint64{perCPU, perCPU}, ebpf.UpdateAny)
+	if err != nil || result.Processed != 2 || result.Batched {
+		t.Errorf("expected the per-CPU entries to be updated one by one, got %+v (%v)", result, err)
+	}
+
+	if _, err = m.UpdateMapBatch("values", keys, keys[:1], ebpf.UpdateAny); !errors.Is(err, ErrInvalidMapBatch) {
+		t.Errorf("expected ErrInvalidMapBatch, got %v", err)
+	}
+}
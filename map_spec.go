@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+// maxMapNameLength - BPF_OBJ_NAME_LEN without the trailing NUL
+const maxMapNameLength = 15
+
+// isMapOfMaps - Returns true if the maps of the provided type hold maps
+func isMapOfMaps(mapType ebpf.MapType) bool {
+	return mapType == ebpf.ArrayOfMaps || mapType == ebpf.HashOfMaps
+}
+
+// checkMapSpecEdit - Checks that the map spec edited by a MapSpecEditor can be loaded in place of the original spec:
+// the key and value sizes and the max entries must fit the type of the map, and the programs expecting a map of maps
+// must still get one
+func checkMapSpecEdit(original *ebpf.MapSpec, edited *ebpf.MapSpec) error {
+	if isMapOfMaps(original.Type) != isMapOfMaps(edited.Type) {
+		return fmt.Errorf("type %s can't replace type %s", edited.Type, original.Type)
+	}
+	if len(edited.Name) > maxMapNameLength {
+		return fmt.Errorf("name %s is longer than %d characters", edited.Name, maxMapNameLength)
+	}
+
+	switch edited.Type {
+	case ebpf.Array, ebpf.PerCPUArray, ebpf.ArrayOfMaps, ebpf.ProgramArray, ebpf.CGroupArray, ebpf.DevMap, ebpf.CPUMap, ebpf.XSKMap:
+		if edited.KeySize != 4 {
+			return fmt.Errorf("type %s requires 4 bytes keys, got %d", edited.Type, edited.KeySize)
+		}
+	case ebpf.PerfEventArray:
+		if edited.KeySize != 4 || edited.ValueSize != 4 {
+			return fmt.Errorf("type %s requires 4 bytes keys and values, got %d and %d", edited.Type, edited.KeySize, edited.ValueSize)
+		}
+		// the max entries default to the number of possible CPUs
+		return nil
+	case ebpf.RingBuf:
+		if edited.KeySize != 0 || edited.ValueSize != 0 {
+			return fmt.Errorf("type %s doesn't have keys nor values, got %d and %d", edited.Type, edited.KeySize, edited.ValueSize)
+		}
+		pageSize := uint32(os.Getpagesize())
+		if edited.MaxEntries < pageSize || edited.MaxEntries%pageSize != 0 || edited.MaxEntries&(edited.MaxEntries-1) != 0 {
+			return fmt.Errorf("type %s requires a size that is a power of 2 multiple of the page size, got %d", edited.Type, edited.MaxEntries)
+		}
+		return nil
+	case ebpf.CGroupStorage, ebpf.PerCPUCGroupStorage, ebpf.SkStorage, ebpf.InodeStorage, ebpf.TaskStorage:
+		// the local storages don't have max entries
+		return nil
+	case ebpf.Hash, ebpf.PerCPUHash, ebpf.LRUHash, ebpf.LRUCPUHash, ebpf.HashOfMaps, ebpf.LPMTrie:
+		if edited.KeySize == 0 {
+			return fmt.Errorf("type %s requires keys", edited.Type)
+		}
+	}
+	if edited.MaxEntries == 0 {
+		return fmt.Errorf("type %s requires max entries", edited.Type)
+	}
+	return nil
+}
+
+// DefaultMapTypeFallbacks - Map types loaded in place of the types the running kernel doesn't support, see
+// Options.MapTypeFallbacks. A ring buffer falls back to a perf event array: the RingBuffer reader transparently reads
+// it, but the eBPF programs must then write their samples with bpf_perf_event_output.
+var DefaultMapTypeFallbacks = map[ebpf.MapType]ebpf.MapType{
+	ebpf.LRUHash:     ebpf.Hash,
+	ebpf.LRUCPUHash:  ebpf.LRUHash,
+	ebpf.PerCPUHash:  ebpf.Hash,
+	ebpf.PerCPUArray: ebpf.Array,
+	ebpf.RingBuf:     ebpf.PerfEventArray,
+}
+
+// MapTypeSubstitution - Map type substituted by Options.MapTypeFallbacks because the running kernel doesn't support
+// the requested type
+type MapTypeSubstitution struct {
+	Map       string `json:"map"`
+	Requested string `json:"requested"`
+	Selected  string `json:"selected"`
+}
+
+// haveMapType - Returns nil if the running kernel supports the provided map type
+var haveMapType = features.HaveMapType
+
+// selectMapType - Follows the provided fallbacks until a map type supported by the running kernel is found. The
+// requested type is kept when the support can't be probed, or when none of its fallbacks is supported.
+func selectMapType(requested ebpf.MapType, fallbacks map[ebpf.MapType]ebpf.MapType) ebpf.MapType {
+	selected := requested
+	for i := 0; i <= len(fallbacks); i++ {
+		if err := haveMapType(selected); !errors.Is(err, ebpf.ErrNotSupported) {
+			if err == nil {
+				return selected
+			}
+			return requested
+		}
+		next, ok := fallbacks[selected]
+		if !ok {
+			return requested
+		}
+		selected = next
+	}
+	return requested
+}
+
+// fallbackMapSpec - Changes the type of the provided map spec to the provided fallback type, along with the
+// attributes specific to the original type
+func fallbackMapSpec(spec *ebpf.MapSpec, mapType ebpf.MapType) {
+	switch spec.Type {
+	case ebpf.LRUHash, ebpf.LRUCPUHash:
+		if mapType != ebpf.LRUHash && mapType != ebpf.LRUCPUHash {
+			spec.Flags &^= unix.BPF_F_NO_COMMON_LRU
+		}
+	}
+	if mapType == ebpf.PerfEventArray {
+		// the max entries default to the number of possible CPUs
+		spec.KeySize, spec.ValueSize, spec.MaxEntries = 4, 4, 0
+		spec.Key, spec.Value = nil, nil
+	}
+	spec.Type = mapType
+}
+
+// applyMapTypeFallbacks - Substitutes the map types that the running kernel doesn't support, according to
+// Options.MapTypeFallbacks, and records the substitutions
+func (m *Manager) applyMapTypeFallbacks() {
+	m.mapSubstitutions = nil
+	if m.options.MapTypeFallbacks == nil {
+		return
+	}
+	names := make([]string, 0, len(m.collectionSpec.Maps))
+	for name := range m.collectionSpec.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := m.collectionSpec.Maps[name]
+		selected := selectMapType(spec.Type, m.options.MapTypeFallbacks)
+		if selected == spec.Type {
+			continue
+		}
+		m.mapSubstitutions = append(m.mapSubstitutions, MapTypeSubstitution{
+			Map:       name,
+			Requested: spec.Type.String(),
+			Selected:  selected.String(),
+		})
+		fallbackMapSpec(spec, selected)
+	}
+}
+
+// MapTypeSubstitutions - Returns the map types substituted at Init because the running kernel doesn't support them,
+// see Options.MapTypeFallbacks
+func (m *Manager) MapTypeSubstitutions() []MapTypeSubstitution {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return append([]MapTypeSubstitution{}, m.mapSubstitutions...)
+}
+
+// mapSubstitution - Returns the substitution of the type of the provided map, if any
+func (m *Manager) mapSubstitution(name string) (MapTypeSubstitution, bool) {
+	for _, substitution := range m.mapSubstitutions {
+		if substitution.Map == name {
+			return substitution, true
+		}
+	}
+	return MapTypeSubstitution{}, false
+}
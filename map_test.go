@@ -0,0 +1,308 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// newTestMap - Creates a new initialized Map for the provided spec
+func newTestMap(t *testing.T, spec ebpf.MapSpec) *Map {
+	t.Helper()
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	managerMap, err := loadNewMap(spec, MapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	managerMap.state = initialized
+	t.Cleanup(func() {
+		_ = managerMap.Close(CleanAll)
+	})
+	return managerMap
+}
+
+func TestMapFreeze(t *testing.T) {
+	managerMap := newTestMap(t, ebpf.MapSpec{
+		Name:       "frozen_map",
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 1,
+	})
+
+	if err := managerMap.array.Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err := managerMap.FreezeMap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := managerMap.array.Put(uint32(0), uint32(43)); err == nil {
+		t.Error("expected user space writes to fail once the map is frozen")
+	}
+
+	var value uint32
+	if err := managerMap.array.Lookup(uint32(0), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Errorf("expected 42, got %d", value)
+	}
+}
+
+func TestMapFreezeNotInitialized(t *testing.T) {
+	managerMap := &Map{Name: "not_initialized"}
+	if err := managerMap.FreezeMap(); err != ErrMapNotInitialized {
+		t.Errorf("expected ErrMapNotInitialized, got %v", err)
+	}
+}
+
+func TestMapHelpers(t *testing.T) {
+	for _, mapType := range []ebpf.MapType{ebpf.Hash, ebpf.Array} {
+		t.Run(mapType.String(), func(t *testing.T) {
+			managerMap := newTestMap(t, ebpf.MapSpec{
+				Type:       mapType,
+				KeySize:    4,
+				ValueSize:  8,
+				MaxEntries: 2,
+			})
+
+			if err := managerMap.Put(uint32(1), uint64(42)); err != nil {
+				t.Fatal(err)
+			}
+			var value uint64
+			if err := managerMap.Get(uint32(1), &value); err != nil {
+				t.Fatal(err)
+			}
+			if value != 42 {
+				t.Errorf("expected 42, got %d", value)
+			}
+
+			if mapType == ebpf.Hash {
+				if err := managerMap.Delete(uint32(1)); err != nil {
+					t.Fatal(err)
+				}
+				if err := managerMap.Get(uint32(1), &value); !errors.Is(err, ErrKeyNotExist) {
+					t.Errorf("expected ErrKeyNotExist, got %v", err)
+				}
+				if err := managerMap.Delete(uint32(1)); !errors.Is(err, ErrKeyNotExist) {
+					t.Errorf("expected ErrKeyNotExist, got %v", err)
+				}
+			}
+
+			if err := managerMap.GetPerCPU(uint32(1), &[]uint64{}); !errors.Is(err, ErrNotPerCPUMap) {
+				t.Errorf("expected ErrNotPerCPUMap, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMapPerCPUHelpers(t *testing.T) {
+	managerMap := newTestMap(t, ebpf.MapSpec{
+		Type:       ebpf.PerCPUArray,
+		KeySize:    4,
+		ValueSize:  8,
+		MaxEntries: 2,
+	})
+
+	// array entries always exist, use the first one to find out the number of possible CPUs
+	var values []uint64
+	if err := managerMap.GetPerCPU(uint32(0), &values); err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		values[i] = uint64(i + 1)
+	}
+	if err := managerMap.PutPerCPU(uint32(1), values); err != nil {
+		t.Fatal(err)
+	}
+	var valuesOut []uint64
+	if err := managerMap.GetPerCPU(uint32(1), &valuesOut); err != nil {
+		t.Fatal(err)
+	}
+	if !equalUint64(values, valuesOut) {
+		t.Errorf("expected %v, got %v", values, valuesOut)
+	}
+	if err := managerMap.GetPerCPU(uint32(2), &valuesOut); !errors.Is(err, ErrKeyNotExist) {
+		t.Errorf("expected ErrKeyNotExist, got %v", err)
+	}
+	if err := managerMap.PutPerCPU(uint32(1), uint64(1)); err == nil {
+		t.Error("expected an error when a single value is provided to a per-CPU map")
+	}
+}
+
+func TestMapPerCPUAggregation(t *testing.T) {
+	type stats struct {
+		Packets uint64
+		Bytes   uint64
+	}
+	managerMap := newTestMap(t, ebpf.MapSpec{
+		Type:       ebpf.PerCPUHash,
+		KeySize:    4,
+		ValueSize:  16,
+		MaxEntries: 2,
+	})
+	cpus, err := possibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]stats, cpus)
+	var expectedSum, expectedMax stats
+	for i := range values {
+		values[i] = stats{Packets: uint64(i + 1), Bytes: uint64(100 * (cpus - i))}
+		expectedSum.Packets += values[i].Packets
+		expectedSum.Bytes += values[i].Bytes
+	}
+	expectedMax = stats{Packets: uint64(cpus), Bytes: uint64(100 * cpus)}
+	for _, key := range []uint32{1, 2} {
+		if err = managerMap.PutPerCPU(key, values); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var value stats
+	if err = managerMap.GetSum(uint32(1), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != expectedSum {
+		t.Errorf("expected the sum %+v, got %+v", expectedSum, value)
+	}
+	if err = managerMap.GetMax(uint32(1), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != expectedMax {
+		t.Errorf("expected the maximum %+v, got %+v", expectedMax, value)
+	}
+	if err = managerMap.GetAggregated(uint32(1), &value, MinPerCPU); err != nil || value != (stats{Packets: 1, Bytes: 100}) {
+		t.Errorf("unexpected minimum %+v: %v", value, err)
+	}
+	if err = managerMap.GetSum(uint32(3), &value); !errors.Is(err, ErrKeyNotExist) {
+		t.Errorf("expected ErrKeyNotExist, got %v", err)
+	}
+
+	// custom combiner
+	var calls int
+	countCPUs := func(aggregated, value interface{}) error {
+		calls++
+		aggregated.(*stats).Packets++
+		return nil
+	}
+	if err = managerMap.GetAggregated(uint32(1), &value, countCPUs); err != nil || calls != cpus-1 {
+		t.Errorf("expected the combiner to be called %d times, got %d: %v", cpus-1, calls, err)
+	}
+
+	var key uint32
+	seen := 0
+	if err = managerMap.IterateAggregated(&key, &value, SumPerCPU, func() error {
+		seen++
+		if value != expectedSum {
+			t.Errorf("expected the sum %+v for key %d, got %+v", expectedSum, key, value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 2 {
+		t.Errorf("expected 2 entries, got %d", seen)
+	}
+
+	var invalid struct{ Name string }
+	if err = managerMap.GetSum(uint32(1), &invalid); err == nil {
+		t.Error("expected an error for a value type that can't be aggregated")
+	}
+}
+
+func TestMapHelpersNotInitialized(t *testing.T) {
+	managerMap := &Map{Name: "not_initialized"}
+	if err := managerMap.Put(uint32(1), uint64(1)); err != ErrMapNotInitialized {
+		t.Errorf("expected ErrMapNotInitialized, got %v", err)
+	}
+	var value uint64
+	if err := managerMap.Get(uint32(1), &value); err != ErrMapNotInitialized {
+		t.Errorf("expected ErrMapNotInitialized, got %v", err)
+	}
+	if err := managerMap.Delete(uint32(1)); err != ErrMapNotInitialized {
+		t.Errorf("expected ErrMapNotInitialized, got %v", err)
+	}
+}
+
+func TestMapOfMaps(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	innerSpec := &ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1}
+	m := &Manager{}
+	outer, err := m.NewMap(ebpf.MapSpec{
+		Name:       "outer_map",
+		Type:       ebpf.ArrayOfMaps,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 4,
+	}, MapOptions{
+		InnerMapSpec: innerSpec,
+		InnerMaps: []InnerMap{
+			{Key: uint32(0)},
+			{Key: uint32(2), Spec: &ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	managerMap := m.Maps[0]
+	defer managerMap.Close(CleanAll)
+
+	innerMaps, err := managerMap.GetInnerMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(innerMaps) != 2 {
+		t.Fatalf("expected 2 inner maps, got %d", len(innerMaps))
+	}
+	innerInfo, err := innerMaps[1].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerID, _ := innerInfo.ID()
+	if err = innerMaps[1].Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+	for _, innerMap := range innerMaps {
+		innerMap.Close()
+	}
+
+	// the inner map is reachable through the outer map
+	var inner *ebpf.Map
+	if err = outer.Lookup(uint32(2), &inner); err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+	var value uint32
+	if err = inner.Lookup(uint32(0), &value); err != nil || value != 42 {
+		t.Errorf("expected to read 42 through the outer map, got %d (%v)", value, err)
+	}
+	if err = outer.Lookup(uint32(1), &inner); !errors.Is(err, ebpf.ErrKeyNotExist) {
+		t.Errorf("expected slot 1 to be empty, got %v", err)
+	}
+
+	// the inner maps are only referenced by the outer map, they are released along with it. The kernel frees maps
+	// asynchronously.
+	inner.Close()
+	if err = managerMap.Close(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		innerMap, err := ebpf.NewMapFromID(innerID)
+		if err != nil {
+			break
+		}
+		innerMap.Close()
+		if time.Since(start) > time.Second {
+			t.Error("expected the inner map to be released along with the outer map")
+			break
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// memcgKernelVersion - First kernel version accounting the memory of the maps and programs to the memory cgroup of
+// their creator, instead of RLIMIT_MEMLOCK
+var memcgKernelVersion = NewKernelVersion(5, 11, 0)
+
+// MemoryFootprint - Kernel memory used by the maps, programs and perf ring buffers of a manager, see
+// Manager.GetMemoryFootprint. The sizes are in bytes.
+type MemoryFootprint struct {
+	// Maps - Memory of each map, perf map and ring buffer, by name
+	Maps map[string]uint64 `json:"maps"`
+
+	// Programs - Memory of the program of each probe, by identification pair
+	Programs map[string]uint64 `json:"programs"`
+
+	// PerfBuffers - Memory of the perf ring buffers of each perf map, by name
+	PerfBuffers map[string]uint64 `json:"perf_buffers"`
+
+	// Total - Sum of the memory of the maps, programs and perf ring buffers
+	Total uint64 `json:"total"`
+
+	// MemcgAccounting - True if the kernel accounts this memory to the memory cgroup of the process (kernel 5.11+),
+	// false if it is accounted to RLIMIT_MEMLOCK
+	MemcgAccounting bool `json:"memcg_accounting"`
+}
+
+// HaveMemcgAccounting - Returns true if the kernel accounts the memory of the maps and programs to the memory cgroup
+// of their creator (kernel 5.11+), in which case RLIMIT_MEMLOCK doesn't need to be raised
+func HaveMemcgAccounting() bool {
+	version, err := CurrentKernelVersion()
+	return err == nil && version >= memcgKernelVersion
+}
+
+// raiseMemlock - (Options.RemoveMemlock) Removes the RLIMIT_MEMLOCK limit of the process, unless the kernel uses the
+// memcg based accounting
+func raiseMemlock() error {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't remove RLIMIT_MEMLOCK", err))
+	}
+	return nil
+}
+
+// GetMemoryFootprint - Returns the kernel memory used by the maps, programs and perf ring buffers of the manager, to
+// budget the memory of an agent. The memory of the maps and programs is the one reported by the kernel (memlock of
+// their fdinfo), or an estimation based on their specs on old kernels. The memory of the perf ring buffers is
+// estimated from their sizes.
+func (m *Manager) GetMemoryFootprint() (*MemoryFootprint, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	footprint := &MemoryFootprint{
+		Maps:            make(map[string]uint64),
+		Programs:        make(map[string]uint64),
+		PerfBuffers:     make(map[string]uint64),
+		MemcgAccounting: HaveMemcgAccounting(),
+	}
+	for name, array := range m.collection.Maps {
+		footprint.Maps[name] = mapMemory(array)
+	}
+	for _, managerMap := range m.Maps {
+		if array := managerMap.array; array != nil {
+			footprint.Maps[managerMap.Name] = mapMemory(array)
+		}
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		if array := ringBuffer.array; array != nil {
+			footprint.Maps[ringBuffer.Name] = mapMemory(array)
+		}
+	}
+	for _, perfMap := range m.PerfMaps {
+		perfMap.stateLock.RLock()
+		if array := perfMap.array; array != nil {
+			footprint.Maps[perfMap.Name] = mapMemory(array)
+			if perfMap.state >= running && array.Type() == ebpf.PerfEventArray && !perfMap.TestMode {
+				footprint.PerfBuffers[perfMap.Name] += perfMap.ringsMemory()
+			}
+		}
+		perfMap.stateLock.RUnlock()
+	}
+
+	seen := make(map[*ebpf.Program]bool)
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		prog := probe.program
+		probe.stateLock.RUnlock()
+		if prog == nil || seen[prog] {
+			continue
+		}
+		seen[prog] = true
+		footprint.Programs[probe.GetIdentificationPair().String()] = programMemory(prog)
+	}
+
+	for _, size := range footprint.Maps {
+		footprint.Total += size
+	}
+	for _, size := range footprint.Programs {
+		footprint.Total += size
+	}
+	for _, size := range footprint.PerfBuffers {
+		footprint.Total += size
+	}
+	return footprint, nil
+}
+
+// mapMemory - Returns the memory of the provided map: the memlock reported by the kernel, or max_entries times the
+// size of an entry
+func mapMemory(array *ebpf.Map) uint64 {
+	if memlock, ok := fdinfoMemlock(array.FD()); ok {
+		return memlock
+	}
+	valueSize := uint64(array.ValueSize())
+	if isPerCPUMapType(array.Type()) {
+		if cpus, err := possibleCPUs(); err == nil {
+			valueSize *= uint64(cpus)
+		}
+	}
+	return uint64(array.MaxEntries()) * (uint64(array.KeySize()) + valueSize)
+}
+
+// programMemory - Returns the memory of the provided program: the memlock reported by the kernel, or the size of its
+// instructions
+func programMemory(prog *ebpf.Program) uint64 {
+	if memlock, ok := fdinfoMemlock(prog.FD()); ok {
+		return memlock
+	}
+	info, err := prog.Info()
+	if err != nil {
+		return 0
+	}
+	instructions, err := info.Instructions()
+	if err != nil {
+		return 0
+	}
+	return uint64(instructions.Size())
+}
+
+// ringsMemory - (not thread safe) Returns the estimated memory of the perf ring buffers of the perf map: each ring is
+// rounded up to a power of 2 number of pages, plus a metadata page
+func (m *PerfMap) ringsMemory() uint64 {
+	cpus, err := m.readerCPUs()
+	if err != nil {
+		return 0
+	}
+	pageSize := os.Getpagesize()
+	var total uint64
+	for _, cpu := range cpus {
+		size := m.PerfRingBufferSize
+		if perCPUSize, ok := m.PerfRingBufferSizePerCPU[cpu]; ok && perCPUSize > 0 {
+			size = perCPUSize
+		}
+		pages := 1
+		for pages*pageSize < size {
+			pages *= 2
+		}
+		total += uint64((pages + 1) * pageSize)
+	}
+	return total
+}
+
+// fdinfoMemlock - Returns the memlock field of the fdinfo of the provided map or program file descriptor
+func fdinfoMemlock(fd int) (uint64, bool) {
+	file, err := os.Open(fmt.Sprintf("/proc/self/fdinfo/%d", fd))
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "memlock:"); value != scanner.Text() {
+			memlock, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			return memlock, err == nil
+		}
+	}
+	return 0, false
+}
+
+// possibleCPUs - Returns the number of possible CPUs
+func possibleCPUs() (int, error) {
+	list, err := os.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return 0, err
+	}
+	cpus, err := parseCPUList(string(list))
+	if err != nil {
+		return 0, err
+	}
+	return len(cpus), nil
+}
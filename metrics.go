@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// ProbeMetrics - Telemetry of a probe
+type ProbeMetrics struct {
+	ProbeIdentificationPair
+
+	// Section - Section of the program of the probe
+	Section string
+
+	// Attached - True if the probe is currently attached to its hook point
+	Attached bool
+
+	// LastError - Last error that the probe encountered
+	LastError error
+
+	// RunCount - Number of times the program of the probe ran. Only reported when the statistics of the programs are
+	// enabled, see Manager.EnableProgramStats.
+	RunCount uint64
+
+	// RunTime - Cumulated run time of the program of the probe. Only reported when the statistics of the programs are
+	// enabled, see Manager.EnableProgramStats.
+	RunTime time.Duration
+}
+
+// PerfMapMetrics - Telemetry of a perf map. The statistics are only reported if PerfMapStats is set.
+type PerfMapMetrics struct {
+	// Name - Name of the perf map
+	Name string
+
+	// Running - True if the perf ring reader is running
+	Running bool
+
+	// ReadErrors - Number of errors returned by the perf ring reader
+	ReadErrors uint64
+
+	// RawSamples - Number of bytes read, per CPU
+	RawSamples map[int]uint64
+
+	// LostSamples - Number of samples dropped by the kernel, per CPU
+	LostSamples map[int]uint64
+
+	// UserspaceDrops - Number of samples dropped by the event worker pool of the manager, see Options.EventConcurrency
+	UserspaceDrops uint64
+}
+
+// ManagerMetrics - Telemetry of a manager, see Manager.Metrics
+type ManagerMetrics struct {
+	// Probes - Telemetry of the probes of the manager
+	Probes []ProbeMetrics
+
+	// PerfMaps - Telemetry of the perf maps of the manager
+	PerfMaps []PerfMapMetrics
+
+	// OpenMaps - Number of eBPF maps currently held open by the manager, that is to say the number of map file
+	// descriptors it owns
+	OpenMaps int
+
+	// DroppedEvents - Number of samples dropped by the event worker pool of the manager, see Options.EventConcurrency
+	DroppedEvents uint64
+
+	// ProgramStatsEnabled - True if the runtime statistics of the programs are collected, either because
+	// Manager.EnableProgramStats was called or because the kernel.bpf_stats_enabled sysctl is set
+	ProgramStatsEnabled bool
+}
+
+// MetricsCollector - Pluggable telemetry backend. Use Manager.ReportMetrics to push the telemetry of a manager to a
+// collector, or see the prometheus sub-package for a prometheus.Collector scraping a manager.
+type MetricsCollector interface {
+	ReportMetrics(metrics ManagerMetrics)
+}
+
+// Metrics - Returns a snapshot of the telemetry of the manager
+func (m *Manager) Metrics() ManagerMetrics {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+
+	var metrics ManagerMetrics
+	for _, probe := range m.Probes {
+		probeMetrics := ProbeMetrics{
+			ProbeIdentificationPair: probe.GetIdentificationPair(),
+			Section:                 probe.Section,
+			Attached:                probe.IsRunning(),
+			LastError:               probe.GetLastError(),
+		}
+		probe.stateLock.RLock()
+		prog := probe.program
+		probe.stateLock.RUnlock()
+		if prog != nil {
+			if info, err := prog.Info(); err == nil {
+				probeMetrics.RunCount, _ = info.RunCount()
+				probeMetrics.RunTime, _ = info.Runtime()
+			}
+		}
+		metrics.Probes = append(metrics.Probes, probeMetrics)
+	}
+
+	for _, perfMap := range m.PerfMaps {
+		perfMap.stateLock.RLock()
+		perfMapMetrics := PerfMapMetrics{
+			Name:    perfMap.Name,
+			Running: perfMap.state == running,
+		}
+		perfMap.stateLock.RUnlock()
+		if perfMap.PerfMapStats != nil {
+			stats := perfMap.PerfMapStats.Snapshot()
+			perfMapMetrics.ReadErrors = stats.ReadErrors
+			perfMapMetrics.RawSamples = stats.RawSamples
+			perfMapMetrics.LostSamples = stats.LostSamples
+			perfMapMetrics.UserspaceDrops = stats.UserspaceDrops
+		}
+		metrics.PerfMaps = append(metrics.PerfMaps, perfMapMetrics)
+	}
+
+	// the maps of the manager are usually the ones of the collection, count each of them once
+	openMaps := make(map[*ebpf.Map]struct{})
+	if m.collection != nil {
+		for _, array := range m.collection.Maps {
+			openMaps[array] = struct{}{}
+		}
+	}
+	for _, managerMap := range m.Maps {
+		if managerMap.array != nil {
+			openMaps[managerMap.array] = struct{}{}
+		}
+	}
+	for _, perfMap := range m.PerfMaps {
+		if perfMap.array != nil {
+			openMaps[perfMap.array] = struct{}{}
+		}
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		if ringBuffer.array != nil {
+			openMaps[ringBuffer.array] = struct{}{}
+		}
+	}
+	metrics.OpenMaps = len(openMaps)
+	metrics.DroppedEvents = m.DroppedEvents()
+	metrics.ProgramStatsEnabled = m.programStats != nil || programStatsSysctl()
+	return metrics
+}
+
+// ReportMetrics - Pushes a snapshot of the telemetry of the manager to the provided collector
+func (m *Manager) ReportMetrics(collector MetricsCollector) {
+	collector.ReportMetrics(m.Metrics())
+}
@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+type recordingCollector struct {
+	reports []ManagerMetrics
+}
+
+func (c *recordingCollector) ReportMetrics(metrics ManagerMetrics) {
+	c.reports = append(c.reports, metrics)
+}
+
+func TestManagerMetrics(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	stats := NewPerfMapStats()
+	stats.RawSamples[1] = 42
+	stats.LostSamples[0] = 3
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite"}},
+		Maps:   []*Map{{Name: "map_val"}},
+		PerfMaps: []*PerfMap{{
+			Map: Map{Name: "events"},
+			PerfMapOptions: PerfMapOptions{
+				TestMode:     true,
+				DataHandler:  func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+				PerfMapStats: stats,
+			},
+		}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	collector := &recordingCollector{}
+	m.ReportMetrics(collector)
+	if len(collector.reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(collector.reports))
+	}
+	metrics := collector.reports[0]
+
+	if len(metrics.Probes) != 1 || metrics.Probes[0].EbpfFuncName != "rewrite" || metrics.Probes[0].Attached {
+		t.Errorf("unexpected probe metrics %+v", metrics.Probes)
+	}
+	if len(metrics.PerfMaps) != 1 || metrics.PerfMaps[0].RawSamples[1] != 42 || metrics.PerfMaps[0].LostSamples[0] != 3 {
+		t.Errorf("unexpected perf map metrics %+v", metrics.PerfMaps)
+	}
+	if metrics.OpenMaps != len(m.collection.Maps) {
+		t.Errorf("expected %d open maps, got %d", len(m.collection.Maps), metrics.OpenMaps)
+	}
+
+	// the reported statistics are a snapshot
+	stats.RawSamples[1] = 0
+	if metrics.PerfMaps[0].RawSamples[1] != 42 {
+		t.Error("the reported statistics should be a copy")
+	}
+}
+
+func TestManagerMetricsConcurrentSamples(t *testing.T) {
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:     true,
+			DataHandler:  func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}, PerfMaps: []*PerfMap{perfMap}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer perfMap.Stop(CleanAll)
+
+	// the statistics are scraped while the samples of new CPUs are counted, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			perfMap.handleRecord(perf.Record{CPU: i % 64, RawSample: []byte{1}})
+			perfMap.handleRecord(perf.Record{CPU: i % 64, LostSamples: 1})
+		}
+	}()
+	for scraping := true; scraping; {
+		select {
+		case <-done:
+			scraping = false
+		default:
+		}
+		m.Metrics()
+	}
+
+	metrics := m.Metrics().PerfMaps[0]
+	var raw, lost uint64
+	for cpu := range metrics.RawSamples {
+		raw += metrics.RawSamples[cpu]
+		lost += metrics.LostSamples[cpu]
+	}
+	if raw != 1000 || lost != 1000 {
+		t.Errorf("expected 1000 bytes and 1000 lost samples, got %d and %d", raw, lost)
+	}
+}
+
+func TestGetMemoryFootprint(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "counters", Type: ebpf.Hash, KeySize: 4, ValueSize: 64, MaxEntries: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+	events, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+
+	perfMap := &PerfMap{Map: Map{Name: "events", array: events, state: running}, PerfMapOptions: PerfMapOptions{PerfRingBufferSize: 3 * os.Getpagesize()}}
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"counters": array, "events": events}},
+		state:      initialized,
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if _, err = (&Manager{}).GetMemoryFootprint(); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized, got %v", err)
+	}
+	footprint, err := m.GetMemoryFootprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if footprint.Maps["counters"] == 0 {
+		t.Errorf("expected the memory of the hash map, got %v", footprint.Maps)
+	}
+	// 4 pages per ring, plus the metadata page
+	if expected := uint64(events.MaxEntries()) * uint64(5*os.Getpagesize()); footprint.PerfBuffers["events"] != expected {
+		t.Errorf("expected %d bytes of perf ring buffers, got %d", expected, footprint.PerfBuffers["events"])
+	}
+	if footprint.Total < footprint.Maps["counters"]+footprint.PerfBuffers["events"] {
+		t.Errorf("unexpected total %d", footprint.Total)
+	}
+}
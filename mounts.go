@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultTraceFSRoot - Default mount point of the tracefs mounted by the manager, see Options.MountTraceFS
+const DefaultTraceFSRoot = "/sys/kernel/tracing"
+
+// isFilesystem - Returns true if the provided path is on a filesystem with the provided magic number
+func isFilesystem(path string, magic int64) bool {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return false
+	}
+	return int64(statfs.Type) == magic
+}
+
+// mountFilesystem - Mounts a filesystem of the provided type at the provided path
+func mountFilesystem(fstype string, target string) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("error:%w , couldn't create the mount point of %s at %s: %v", ErrMountFailed, fstype, target, err)
+	}
+	if err := unix.Mount(fstype, target, fstype, 0, ""); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("error:%w , couldn't mount %s at %s: CAP_SYS_ADMIN is required", ErrMountFailed, fstype, target)
+		}
+		return fmt.Errorf("error:%w , couldn't mount %s at %s: %v", ErrMountFailed, fstype, target, err)
+	}
+	return nil
+}
+
+// pinPaths - Returns the paths at which the maps, programs and links of the manager are pinned or loaded from
+func (m *Manager) pinPaths() []string {
+	var paths []string
+	addMap := func(managerMap *Map) {
+		for _, path := range []string{managerMap.PinPath, managerMap.LoadPinPath} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	for _, managerMap := range m.Maps {
+		addMap(managerMap)
+	}
+	for _, perfMap := range m.PerfMaps {
+		addMap(&perfMap.Map)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		addMap(&ringBuffer.Map)
+	}
+	for _, probe := range m.Probes {
+		for _, path := range []string{probe.PinPath, probe.linkPinPath} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// mountBPFFS - (Options.MountBPFFS) Mounts a BPF filesystem at BPFFSRoot if the manager pins objects there and no BPF
+// filesystem is mounted yet, and creates the directories of the pins
+func (m *Manager) mountBPFFS() error {
+	paths := m.pinPaths()
+	if len(paths) == 0 {
+		return nil
+	}
+	root := m.bpffsRoot()
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if isFilesystem(dir, unix.BPF_FS_MAGIC) {
+			continue
+		}
+		// only BPFFSRoot is mounted by the manager, the other pins fail as usual
+		if dir != root && !strings.HasPrefix(dir, root+"/") {
+			continue
+		}
+		if !isFilesystem(root, unix.BPF_FS_MAGIC) {
+			if err := mountFilesystem("bpf", root); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't create pin directory %s", err, dir))
+		}
+	}
+	return nil
+}
+
+// hasTracefsProbes - Returns true if the manager has kprobes, uprobes or tracepoints, which may rely on tracefs
+func (m *Manager) hasTracefsProbes() bool {
+	for _, probe := range m.Probes {
+		if probe.Enabled && probe.programSpec != nil && (probe.programSpec.Type == ebpf.Kprobe || probe.programSpec.Type == ebpf.TracePoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountTraceFS - (Options.MountTraceFS) Mounts tracefs at TraceFSRoot if the manager has kprobes or tracepoints and
+// tracefs isn't mounted yet
+func (m *Manager) mountTraceFS() error {
+	if !m.hasTracefsProbes() {
+		return nil
+	}
+	if _, err := tracefsRoot(); err == nil {
+		return nil
+	}
+	root := m.options.TraceFSRoot
+	if root == "" {
+		root = DefaultTraceFSRoot
+	}
+	if !isFilesystem(root, unix.TRACEFS_MAGIC) {
+		if err := mountFilesystem("tracefs", root); err != nil {
+			return err
+		}
+	}
+
+	tracefsLock.Lock()
+	defer tracefsLock.Unlock()
+	for _, known := range tracefsRoots {
+		if known == root {
+			return nil
+		}
+	}
+	tracefsRoots = append([]string{root}, tracefsRoots...)
+	return nil
+}
+
+// mountFilesystems - Mounts the filesystems required by the manager, according to Options.MountBPFFS and
+// Options.MountTraceFS
+func (m *Manager) mountFilesystems() error {
+	if m.options.MountBPFFS {
+		if err := m.mountBPFFS(); err != nil {
+			return err
+		}
+	}
+	if m.options.MountTraceFS {
+		if err := m.mountTraceFS(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
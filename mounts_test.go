@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestMountBPFFS(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(t.TempDir(), "bpf")
+	t.Cleanup(func() {
+		_ = unix.Unmount(root, unix.MNT_DETACH)
+	})
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	pinPath := filepath.Join(root, "app", "map_val")
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite"}},
+		Maps:   []*Map{{Name: "map_val", MapOptions: MapOptions{PinPath: pinPath}}},
+	}
+	if err = m.InitWithOptions(elf, Options{BPFFSRoot: root, MountBPFFS: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if !isFilesystem(root, unix.BPF_FS_MAGIC) {
+		t.Fatalf("expected a BPF filesystem to be mounted at %s", root)
+	}
+	if _, err = os.Stat(pinPath); err != nil {
+		t.Errorf("expected map_val to be pinned: %v", err)
+	}
+}
+
+func TestMountFilesystemTraceFS(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "tracing")
+	if err := mountFilesystem("tracefs", root); err != nil {
+		t.Skipf("couldn't mount tracefs: %v", err)
+	}
+	defer unix.Unmount(root, unix.MNT_DETACH)
+	if !isFilesystem(root, unix.TRACEFS_MAGIC) {
+		t.Errorf("expected tracefs to be mounted at %s", root)
+	}
+	if isFilesystem(filepath.Dir(root), unix.TRACEFS_MAGIC) {
+		t.Error("expected the parent directory not to be on tracefs")
+	}
+}
@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// runInNetns - Runs fn in the network namespace at the provided path (for example /proc/[pid]/ns/net or
+// /var/run/netns/[name]). The namespace is entered by a dedicated OS thread, so that the other goroutines are not
+// affected. fn runs in the current network namespace if the path is empty.
+func runInNetns(path string, fn func() error) error {
+	if path == "" {
+		return fn()
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errChan <- errors.New(fmt.Sprintf("error:%v , couldn't open the current network namespace", err))
+			return
+		}
+		defer current.Close()
+		target, err := os.Open(path)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errChan <- errors.New(fmt.Sprintf("error:%v , couldn't open network namespace %s", err, path))
+			return
+		}
+		defer target.Close()
+
+		if err = unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errChan <- errors.New(fmt.Sprintf("error:%v , couldn't enter network namespace %s", err, path))
+			return
+		}
+		err = fn()
+		if errRestore := unix.Setns(int(current.Fd()), unix.CLONE_NEWNET); errRestore != nil {
+			// the thread is stuck in the namespace, leave it locked so that it exits with the goroutine
+			errChan <- ConcatErrors(err, errors.New(fmt.Sprintf("error:%v , couldn't leave network namespace %s", errRestore, path)))
+			return
+		}
+		runtime.UnlockOSThread()
+		errChan <- err
+	}()
+	return <-errChan
+}
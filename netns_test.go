@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// newNetns - Creates a network namespace bound to a file of a temporary directory
+func newNetns(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "netns")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		// the thread is left locked in the new namespace, it exits with the goroutine
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- unix.Mount(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), path, "", unix.MS_BIND, "")
+	}()
+	if err := <-errChan; err != nil {
+		t.Skipf("couldn't create a network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = unix.Unmount(path, unix.MNT_DETACH)
+	})
+	return path
+}
+
+func TestRunInNetns(t *testing.T) {
+	path := newNetns(t)
+	// give the loopback interface of the namespace a name that doesn't exist in the current namespace
+	const name = "netnstest0"
+	if err := runInNetns(path, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetName(lo, name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.InterfaceByName(name); err == nil {
+		t.Fatalf("expected %s not to exist in the current namespace", name)
+	}
+
+	p := &Probe{Ifname: name, NetnsPath: path}
+	if err := p.resolveIfindex(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Ifindex == 0 {
+		t.Errorf("expected %s to be resolved in its namespace", name)
+	}
+}
+
+func TestTCCleanupFilters(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	m := &Manager{
+		netlinkCache: make(map[netlinkCacheKey]*netlinkCacheValue),
+		options:      Options{TCCleanupStrategy: TCCleanupFilters},
+	}
+	newProbe := func(handle uint32, prio uint16) *Probe {
+		return &Probe{
+			manager:          m,
+			program:          prog,
+			programSpec:      &ebpf.ProgramSpec{Type: ebpf.SchedCLS},
+			Section:          "classifier",
+			Ifindex:          1,
+			NetnsPath:        path,
+			NetworkDirection: Ingress,
+			TCFilterHandle:   handle,
+			TCFilterPrio:     prio,
+		}
+	}
+	first, second := newProbe(0, 0), newProbe(7, 42)
+	for _, p := range []*Probe{first, second} {
+		if err = p.attachTCCLS(); err != nil {
+			t.Skipf("couldn't attach TC classifier: %v", err)
+		}
+		if p.tcFilterObject == nil {
+			t.Fatal("expected the TC filter to be found")
+		}
+	}
+	if handle, prio := second.tcFilterObject.Handle, second.tcFilterObject.Info>>16; handle != 7 || prio != 42 {
+		t.Errorf("expected handle 7 and priority 42, got %d and %d", handle, prio)
+	}
+
+	countFilters := func() int {
+		ntl := m.netlinkCache[netlinkCacheKey{1, 0, path}]
+		filters, err := ntl.rtNetlink.Filter().Get(&tc.Msg{
+			Family:  unix.AF_UNSPEC,
+			Ifindex: 1,
+			Parent:  core.BuildHandle(tc.HandleRoot, uint32(Ingress)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the dump also lists the head of each priority, only count the BPF filters
+		count := 0
+		for _, filter := range filters {
+			if filter.BPF != nil && filter.BPF.ID != nil {
+				count++
+			}
+		}
+		return count
+	}
+	if count := countFilters(); count != 2 {
+		t.Fatalf("expected 2 filters, got %d", count)
+	}
+	if err = first.detachTCCLS(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countFilters(); count != 1 {
+		t.Errorf("expected only the filter of the detached probe to be deleted, got %d filters", count)
+	}
+	if err = second.detachTCCLS(); err != nil {
+		t.Fatal(err)
+	}
+}
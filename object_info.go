@@ -0,0 +1,300 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// ObjectInfo - Kernel information of a program, a map or a link of the manager, see Manager.GetObjectInfos
+type ObjectInfo struct {
+	// Kind - Kind of the object: "prog", "map" or "link", like the {kind} placeholder of Options.PinPathTemplate
+	Kind string `json:"kind"`
+
+	// Name - Name of the object in the manager: the EbpfFuncName of the probe of the programs and links, the name of
+	// the maps
+	Name string `json:"name"`
+
+	// UID - (programs & links) UID of the probe, if any
+	UID string `json:"uid,omitempty"`
+
+	// ID - ID assigned to the object by the kernel
+	ID uint32 `json:"id"`
+
+	// Type - Type of the object, for example "Kprobe" for a program or "Hash" for a map
+	Type string `json:"type"`
+
+	// KernelName - (programs & maps) Name of the object in the kernel, truncated to 15 characters
+	KernelName string `json:"kernel_name,omitempty"`
+
+	// Tag - (programs) Hash of the instructions of the program, as reported by bpftool
+	Tag string `json:"tag,omitempty"`
+
+	// LoadTime - (programs) Time at which the program was loaded
+	LoadTime time.Time `json:"load_time,omitempty"`
+
+	// CreatedByUID - (programs) User that loaded the program
+	CreatedByUID uint32 `json:"created_by_uid,omitempty"`
+
+	// MapIDs - (programs) IDs of the maps used by the program
+	MapIDs []uint32 `json:"map_ids,omitempty"`
+
+	// BTFID - (programs & maps) ID of the BTF object of the program or of the map, 0 if it has none
+	BTFID uint32 `json:"btf_id,omitempty"`
+
+	// BTFKeyTypeID, BTFValueTypeID - (maps) IDs of the types of the keys and of the values in the BTF object of the map
+	BTFKeyTypeID   uint32 `json:"btf_key_type_id,omitempty"`
+	BTFValueTypeID uint32 `json:"btf_value_type_id,omitempty"`
+
+	// ProgramID - (links) ID of the program attached by the link
+	ProgramID uint32 `json:"program_id,omitempty"`
+
+	// PinPath - Path at which the object is pinned, if any
+	PinPath string `json:"pin_path,omitempty"`
+}
+
+// bpfProgInfo - struct bpf_prog_info, up to btf_id
+type bpfProgInfo struct {
+	progType        uint32
+	id              uint32
+	tag             [8]byte
+	jitedProgLen    uint32
+	xlatedProgLen   uint32
+	jitedProgInsns  uint64
+	xlatedProgInsns uint64
+	loadTime        uint64
+	createdByUID    uint32
+	nrMapIDs        uint32
+	mapIDs          uint64
+	name            [16]byte
+	ifindex         uint32
+	gplCompatible   uint32
+	netnsDev        uint64
+	netnsIno        uint64
+	nrJitedKsyms    uint32
+	nrJitedFuncLens uint32
+	jitedKsyms      uint64
+	jitedFuncLens   uint64
+	btfID           uint32
+	_               uint32
+}
+
+// bpfMapInfo - struct bpf_map_info, up to btf_value_type_id
+type bpfMapInfo struct {
+	mapType               uint32
+	id                    uint32
+	keySize               uint32
+	valueSize             uint32
+	maxEntries            uint32
+	mapFlags              uint32
+	name                  [16]byte
+	ifindex               uint32
+	btfVmlinuxValueTypeID uint32
+	netnsDev              uint64
+	netnsIno              uint64
+	btfID                 uint32
+	btfKeyTypeID          uint32
+	btfValueTypeID        uint32
+	_                     uint32
+}
+
+// objectInfoByFD - Fills the bpf_prog_info or bpf_map_info structure pointed to by info with the information of the
+// object of the provided fd
+func objectInfoByFD(fd int, info unsafe.Pointer, size uintptr) error {
+	// union bpf_attr, info variant
+	attr := struct {
+		bpfFD   uint32
+		infoLen uint32
+		info    uint64
+	}{
+		bpfFD:   uint32(fd),
+		infoLen: uint32(size),
+		info:    uint64(uintptr(info)),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cString - Returns the NUL terminated string of the provided buffer
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		return string(buf[:i])
+	}
+	return string(buf)
+}
+
+// programObjectInfo - Returns the kernel information of the provided program
+func programObjectInfo(prog *ebpf.Program) (ObjectInfo, error) {
+	var info bpfProgInfo
+	if err := objectInfoByFD(prog.FD(), unsafe.Pointer(&info), unsafe.Sizeof(info)); err != nil {
+		return ObjectInfo{}, err
+	}
+	object := ObjectInfo{
+		Kind:         "prog",
+		ID:           info.id,
+		Type:         ebpf.ProgramType(info.progType).String(),
+		KernelName:   cString(info.name[:]),
+		Tag:          hex.EncodeToString(info.tag[:]),
+		CreatedByUID: info.createdByUID,
+		BTFID:        info.btfID,
+	}
+	// load_time is the boot time of the load, in nanoseconds
+	var bootTime unix.Timespec
+	if info.loadTime > 0 && unix.ClockGettime(unix.CLOCK_BOOTTIME, &bootTime) == nil {
+		object.LoadTime = time.Now().Add(-time.Duration(bootTime.Nano() - int64(info.loadTime)))
+	}
+	if info.nrMapIDs > 0 {
+		// the other fields are left empty, the kernel would copy the instructions they point to
+		object.MapIDs = make([]uint32, info.nrMapIDs)
+		mapsInfo := bpfProgInfo{nrMapIDs: info.nrMapIDs, mapIDs: uint64(uintptr(unsafe.Pointer(&object.MapIDs[0])))}
+		if err := objectInfoByFD(prog.FD(), unsafe.Pointer(&mapsInfo), unsafe.Sizeof(mapsInfo)); err != nil {
+			return ObjectInfo{}, err
+		}
+		// the kernel reports the number of IDs it copied
+		if mapsInfo.nrMapIDs < info.nrMapIDs {
+			object.MapIDs = object.MapIDs[:mapsInfo.nrMapIDs]
+		}
+	}
+	return object, nil
+}
+
+// mapObjectInfo - Returns the kernel information of the provided map
+func mapObjectInfo(array *ebpf.Map) (ObjectInfo, error) {
+	var info bpfMapInfo
+	if err := objectInfoByFD(array.FD(), unsafe.Pointer(&info), unsafe.Sizeof(info)); err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Kind:           "map",
+		ID:             info.id,
+		Type:           ebpf.MapType(info.mapType).String(),
+		KernelName:     cString(info.name[:]),
+		BTFID:          info.btfID,
+		BTFKeyTypeID:   info.btfKeyTypeID,
+		BTFValueTypeID: info.btfValueTypeID,
+	}, nil
+}
+
+// GetObjectInfos - Returns the kernel information of the programs, maps and links of the manager: their IDs, the tags
+// and load times of the programs, their BTF IDs and their pin paths, so that external tooling can correlate the
+// objects listed by bpftool with the configuration of the manager. The programs and links of the probes come first,
+// followed by the programs of the collection that aren't used by a probe (tail calls for example), then by the maps,
+// perf maps and ring buffers, and finally by the internal maps of the collection (.rodata, .bss...). A program shared
+// by several probes is reported once, for its first probe.
+func (m *Manager) GetObjectInfos() ([]ObjectInfo, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	var infos []ObjectInfo
+	programs := make(map[*ebpf.Program]struct{})
+	addProgram := func(name, uid, pinPath string, prog *ebpf.Program) error {
+		if prog == nil {
+			return nil
+		}
+		if _, ok := programs[prog]; ok {
+			return nil
+		}
+		programs[prog] = struct{}{}
+		info, err := programObjectInfo(prog)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't get the info of program %s", err, name))
+		}
+		info.Name, info.UID, info.PinPath = name, uid, pinPath
+		infos = append(infos, info)
+		return nil
+	}
+	maps := make(map[*ebpf.Map]struct{})
+	addMap := func(name, pinPath string, array *ebpf.Map) error {
+		if array == nil {
+			return nil
+		}
+		if _, ok := maps[array]; ok {
+			return nil
+		}
+		maps[array] = struct{}{}
+		info, err := mapObjectInfo(array)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't get the info of map %s", err, name))
+		}
+		info.Name, info.PinPath = name, pinPath
+		infos = append(infos, info)
+		return nil
+	}
+
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		prog, l, pinPath, linkPinPath := probe.program, probe.link, probe.PinPath, probe.linkPinPath
+		probe.stateLock.RUnlock()
+		if err := addProgram(probe.EbpfFuncName, probe.UID, pinPath, prog); err != nil {
+			return nil, err
+		}
+		if l == nil {
+			continue
+		}
+		// the probes attached without a bpf_link have no link info
+		if info, err := l.Info(); err == nil {
+			infos = append(infos, ObjectInfo{
+				Kind:      "link",
+				Name:      probe.EbpfFuncName,
+				UID:       probe.UID,
+				ID:        uint32(info.ID),
+				Type:      linkTypeName(info.Type),
+				ProgramID: uint32(info.Program),
+				PinPath:   linkPinPath,
+			})
+		}
+	}
+	names := make([]string, 0, len(m.collection.Programs))
+	for name := range m.collection.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := addProgram(name, "", "", m.collection.Programs[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	mapOf := func(managerMap *Map) (string, string, *ebpf.Map) {
+		managerMap.stateLock.RLock()
+		defer managerMap.stateLock.RUnlock()
+		return managerMap.Name, managerMap.PinPath, managerMap.array
+	}
+	var managerMaps []*Map
+	managerMaps = append(managerMaps, m.Maps...)
+	for _, perfMap := range m.PerfMaps {
+		managerMaps = append(managerMaps, &perfMap.Map)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		managerMaps = append(managerMaps, &ringBuffer.Map)
+	}
+	for _, managerMap := range managerMaps {
+		if err := addMap(mapOf(managerMap)); err != nil {
+			return nil, err
+		}
+	}
+	names = names[:0]
+	for name := range m.collection.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := addMap(name, "", m.collection.Maps[name]); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestGetObjectInfos(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Manager{}).GetObjectInfos(); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized, got %v", err)
+	}
+
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "values", Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bss, err := ebpf.NewMap(&ebpf.MapSpec{Name: ".bss", Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Second)
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:    "socket_test",
+		Type:    ebpf.SocketFilter,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, array.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{
+		collection: &ebpf.Collection{
+			Programs: map[string]*ebpf.Program{"socket_test": prog},
+			Maps:     map[string]*ebpf.Map{"values": array, ".bss": bss},
+		},
+		state:  initialized,
+		Probes: []*Probe{{UID: "test", Section: "socket/test", EbpfFuncName: "socket_test", program: prog, state: initialized}},
+		Maps:   []*Map{{Name: "values", array: array, state: initialized, MapOptions: MapOptions{PinPath: "/sys/fs/bpf/values"}}},
+	}
+	defer m.collection.Close()
+
+	infos, err := m.GetObjectInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the program is shared by the probe and the collection, the map by the manager and the collection
+	if len(infos) != 3 {
+		t.Fatalf("expected a program and two maps, got %+v", infos)
+	}
+	progInfo, mapInfo, bssInfo := infos[0], infos[1], infos[2]
+
+	if progInfo.Kind != "prog" || progInfo.Name != "socket_test" || progInfo.UID != "test" || progInfo.ID == 0 {
+		t.Errorf("unexpected program info %+v", progInfo)
+	}
+	if stdInfo, err := prog.Info(); err == nil {
+		if id, _ := stdInfo.ID(); uint32(id) != progInfo.ID {
+			t.Errorf("expected program ID %d, got %d", id, progInfo.ID)
+		}
+		if stdInfo.Tag != "" && stdInfo.Tag != progInfo.Tag {
+			t.Errorf("expected program tag %s, got %s", stdInfo.Tag, progInfo.Tag)
+		}
+	}
+	if progInfo.LoadTime.Before(before) || progInfo.LoadTime.After(time.Now().Add(time.Second)) {
+		t.Errorf("unexpected load time %v", progInfo.LoadTime)
+	}
+	if len(progInfo.MapIDs) != 1 || progInfo.MapIDs[0] != mapInfo.ID {
+		t.Errorf("expected the program to use map %d, got %v", mapInfo.ID, progInfo.MapIDs)
+	}
+
+	if mapInfo.Kind != "map" || mapInfo.Name != "values" || mapInfo.Type != ebpf.Array.String() || mapInfo.PinPath != "/sys/fs/bpf/values" {
+		t.Errorf("unexpected map info %+v", mapInfo)
+	}
+	if bssInfo.Name != ".bss" || bssInfo.ID == 0 || bssInfo.ID == mapInfo.ID {
+		t.Errorf("unexpected internal map info %+v", bssInfo)
+	}
+}
@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// startOrderedStream - Starts the reorder buffer that merges the samples of the perf maps and ring buffers that enable
+// OrderedStream, before their readers are started
+func (m *Manager) startOrderedStream() {
+	if m.options.OrderedDataHandler == nil {
+		return
+	}
+	m.orderedStream = newReorderBuffer(m.options.OrderedStreamWindow, m.options.OrderedStreamBufferSize, m.options.OrderedStreamLatePolicy, func(source string, CPU int, data []byte) {
+		m.dispatchEvent(func() {
+			m.options.OrderedDataHandler(source, CPU, data, m)
+		}, func() {
+			atomic.AddUint64(&m.orderedStreamDrops, 1)
+		})
+	}, func() {
+		atomic.AddUint64(&m.orderedStreamDrops, 1)
+	})
+	m.orderedStreamStop = make(chan struct{})
+	m.wg.Add(1)
+	go m.flushOrderedStream(m.orderedStream, m.orderedStreamStop)
+}
+
+// flushOrderedStream - Delivers the samples held in the ordered stream when the readers are idle, and all of them once
+// the ordered stream is stopped
+func (m *Manager) flushOrderedStream(stream *reorderBuffer, stop chan struct{}) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(stream.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			stream.flushIdle(now)
+		case <-stop:
+			stream.flushAll()
+			return
+		}
+	}
+}
+
+// stopOrderedStream - Delivers the samples left in the ordered stream, once the readers are stopped
+func (m *Manager) stopOrderedStream() {
+	if m.orderedStreamStop == nil {
+		return
+	}
+	close(m.orderedStreamStop)
+	m.orderedStreamStop = nil
+}
+
+// GetOrderedStreamDrops - Returns the number of samples of the ordered stream that were dropped, because they arrived
+// after a younger sample was delivered or because the event queue was full. See Options.OrderedDataHandler.
+func (m *Manager) GetOrderedStreamDrops() uint64 {
+	return atomic.LoadUint64(&m.orderedStreamDrops)
+}
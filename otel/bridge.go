@@ -0,0 +1,381 @@
+// Package otel exports the events decoded by the perf maps and the ring buffers of an eBPF manager as OpenTelemetry
+// log records or spans. The records are batched to an Exporter, which hands them over to an OpenTelemetry SDK or an
+// OTLP client: the bridge doesn't depend on the OpenTelemetry modules, so that their versions are left to the
+// application.
+package otel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+const (
+	// DefaultBatchSize - Default maximum number of records exported at once
+	DefaultBatchSize = 512
+	// DefaultQueueSize - Default number of records buffered before the events are dropped
+	DefaultQueueSize = 2048
+	// DefaultFlushInterval - Default maximum delay before the buffered records are exported
+	DefaultFlushInterval = 5 * time.Second
+	// DefaultExportTimeout - Default timeout of an export
+	DefaultExportTimeout = 30 * time.Second
+)
+
+const (
+	// SourceAttribute - Attribute holding the name of the perf map or the ring buffer of the event
+	SourceAttribute = "ebpf.source"
+	// CPUAttribute - Attribute holding the CPU of the events of the perf maps
+	CPUAttribute = "ebpf.cpu"
+)
+
+// ErrBridgeClosed - Returned by Flush when the bridge is closed
+var ErrBridgeClosed = errors.New("the bridge is closed")
+
+// Mode - Kind of OpenTelemetry records the events are converted into
+type Mode int
+
+const (
+	// Logs - Each event is exported as a log record
+	Logs Mode = iota
+	// Spans - Each event is exported as a span, see Options.TimestampField and Options.EndTimestampField
+	Spans
+)
+
+// LogRecord - Log record of an event, mirrors the OpenTelemetry log data model
+type LogRecord struct {
+	// Timestamp - Time of the event, see Options.TimestampField. Zero if unknown.
+	Timestamp time.Time
+
+	// ObservedTimestamp - Time at which the event was read from the kernel
+	ObservedTimestamp time.Time
+
+	// SeverityNumber - OpenTelemetry severity number of the record, see Options.SeverityNumber
+	SeverityNumber int
+
+	// SeverityText - Severity of the record, see Options.SeverityText
+	SeverityText string
+
+	// Body - Body of the record, see Options.BodyField
+	Body interface{}
+
+	// Attributes - Attributes of the record, see Options.AttributeMapping
+	Attributes map[string]interface{}
+}
+
+// Span - Span of an event, mirrors the OpenTelemetry span data model
+type Span struct {
+	// Name - Name of the span, see Options.SpanName
+	Name string
+
+	// StartTime - Start of the span, see Options.TimestampField
+	StartTime time.Time
+
+	// EndTime - End of the span, see Options.EndTimestampField
+	EndTime time.Time
+
+	// Attributes - Attributes of the span, see Options.AttributeMapping
+	Attributes map[string]interface{}
+}
+
+// Exporter - Exports batches of records, usually by converting them to the records of an OpenTelemetry SDK or to
+// OTLP requests. The calls are serialized.
+type Exporter interface {
+	ExportLogs(ctx context.Context, records []LogRecord) error
+	ExportSpans(ctx context.Context, spans []Span) error
+}
+
+// Options - Options of a Bridge
+type Options struct {
+	// Mode - Kind of records the events are converted into, defaults to Logs
+	Mode Mode
+
+	// AttributeMapping - Fields of the events exported as attributes, indexed by field name, with the name of their
+	// attribute as value. All the fields are exported under their own name when the mapping is empty. See
+	// EventFields for the fields of an event.
+	AttributeMapping map[string]string
+
+	// Attributes - Attributes added to every record, for example the name of the service
+	Attributes map[string]interface{}
+
+	// TimestampField - Field holding the bpf_ktime_get_ns() time of the event, used as the timestamp of the log
+	// records and as the start of the spans. The time the event was read at is used when it is unset.
+	TimestampField string
+
+	// EndTimestampField - (Spans) Field holding the bpf_ktime_get_ns() time of the end of the event. The spans end at
+	// their start when it is unset.
+	EndTimestampField string
+
+	// SpanName - (Spans) Returns the name of the span of an event. Defaults to the name of the perf map or the ring
+	// buffer of the event.
+	SpanName func(event interface{}) string
+
+	// BodyField - (Logs) Field used as the body of the log records. The body is empty when it is unset.
+	BodyField string
+
+	// SeverityNumber - (Logs) Severity number of the log records, defaults to 9 (INFO)
+	SeverityNumber int
+
+	// SeverityText - (Logs) Severity of the log records, defaults to INFO
+	SeverityText string
+
+	// BatchSize - Maximum number of records exported at once, defaults to DefaultBatchSize
+	BatchSize int
+
+	// QueueSize - Number of records buffered before the events are dropped, defaults to DefaultQueueSize. The event
+	// handlers never block on the exporter.
+	QueueSize int
+
+	// FlushInterval - Maximum delay before the buffered records are exported, defaults to DefaultFlushInterval
+	FlushInterval time.Duration
+
+	// ExportTimeout - Timeout of an export, defaults to DefaultExportTimeout
+	ExportTimeout time.Duration
+
+	// ErrorHandler - Called with the errors of the exporter
+	ErrorHandler func(err error)
+}
+
+// record - Log record or span waiting to be exported
+type record struct {
+	log  LogRecord
+	span Span
+}
+
+// Bridge - Converts the events decoded by perf maps and ring buffers into OpenTelemetry records, and batches them to
+// an Exporter. Set PerfMapEventHandler or RingBufferEventHandler as the EventHandler of the perf maps and the ring
+// buffers, with a Decoder.
+type Bridge struct {
+	exporter Exporter
+	options  Options
+
+	queue   chan record
+	flushes chan chan error
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// NewBridge - Creates a bridge exporting the records to the provided exporter, and starts its batching goroutine.
+// Close stops it.
+func NewBridge(exporter Exporter, options Options) *Bridge {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+	if options.ExportTimeout <= 0 {
+		options.ExportTimeout = DefaultExportTimeout
+	}
+	if options.SeverityNumber == 0 {
+		options.SeverityNumber = 9
+	}
+	if options.SeverityText == "" {
+		options.SeverityText = "INFO"
+	}
+	b := &Bridge{
+		exporter: exporter,
+		options:  options,
+		queue:    make(chan record, options.QueueSize),
+		flushes:  make(chan chan error),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// PerfMapEventHandler - Returns an EventHandler for perf maps, see manager.PerfMapOptions.EventHandler
+func (b *Bridge) PerfMapEventHandler() func(CPU int, event interface{}, perfMap *manager.PerfMap, m *manager.Manager) {
+	return func(CPU int, event interface{}, perfMap *manager.PerfMap, m *manager.Manager) {
+		b.Handle(event, perfMap.Name, map[string]interface{}{CPUAttribute: int64(CPU)})
+	}
+}
+
+// RingBufferEventHandler - Returns an EventHandler for ring buffers, see manager.RingBufferOptions.EventHandler
+func (b *Bridge) RingBufferEventHandler() func(event interface{}, ringBuffer *manager.RingBuffer, m *manager.Manager) {
+	return func(event interface{}, ringBuffer *manager.RingBuffer, m *manager.Manager) {
+		b.Handle(event, ringBuffer.Name, nil)
+	}
+}
+
+// Handle - Converts the provided event of the provided source into a record with the provided extra attributes, and
+// queues it. The event is dropped when the queue is full, see Dropped.
+func (b *Bridge) Handle(event interface{}, source string, attributes map[string]interface{}) {
+	observed := time.Now().Round(0)
+	fields := EventFields(event)
+	attrs := make(map[string]interface{}, len(fields)+len(b.options.Attributes)+len(attributes)+1)
+	for key, value := range b.options.Attributes {
+		attrs[key] = value
+	}
+	if len(b.options.AttributeMapping) == 0 {
+		for name, value := range fields {
+			attrs[name] = value
+		}
+	} else {
+		for name, key := range b.options.AttributeMapping {
+			if value, ok := fields[name]; ok {
+				attrs[key] = value
+			}
+		}
+	}
+	for key, value := range attributes {
+		attrs[key] = value
+	}
+	attrs[SourceAttribute] = source
+
+	var r record
+	origin := monotonicOrigin()
+	timestamp := fieldTime(fields, b.options.TimestampField, origin, observed)
+	if b.options.Mode == Spans {
+		r.span = Span{Name: source, StartTime: timestamp, EndTime: fieldTime(fields, b.options.EndTimestampField, origin, timestamp), Attributes: attrs}
+		if b.options.SpanName != nil {
+			r.span.Name = b.options.SpanName(event)
+		}
+	} else {
+		r.log = LogRecord{
+			Timestamp:         timestamp,
+			ObservedTimestamp: observed,
+			SeverityNumber:    b.options.SeverityNumber,
+			SeverityText:      b.options.SeverityText,
+			Body:              fields[b.options.BodyField],
+			Attributes:        attrs,
+		}
+	}
+	select {
+	case b.queue <- r:
+	default:
+		atomic.AddUint64(&b.dropped, 1)
+	}
+}
+
+// fieldTime - Returns the wall clock time of the bpf_ktime_get_ns() value of the provided field, from the provided
+// origin of CLOCK_MONOTONIC, or fallback if the field is unset or missing
+func fieldTime(fields map[string]interface{}, field string, origin time.Time, fallback time.Time) time.Time {
+	if field == "" {
+		return fallback
+	}
+	switch ktime := fields[field].(type) {
+	case int64:
+		return origin.Add(time.Duration(ktime))
+	case uint64:
+		return origin.Add(time.Duration(ktime))
+	}
+	return fallback
+}
+
+// Dropped - Returns the number of events dropped because the queue was full
+func (b *Bridge) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Flush - Exports the records queued so far, and returns the error of the exporter
+func (b *Bridge) Flush(ctx context.Context) error {
+	result := make(chan error, 1)
+	select {
+	case b.flushes <- result:
+	case <-b.done:
+		return ErrBridgeClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close - Exports the queued records and stops the bridge. The event handlers must no longer be called.
+func (b *Bridge) Close(ctx context.Context) error {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run - Batches the queued records until the bridge is closed
+func (b *Bridge) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.options.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]record, 0, b.options.BatchSize)
+	for {
+		select {
+		case r := <-b.queue:
+			if batch = append(batch, r); len(batch) >= b.options.BatchSize {
+				b.handleError(b.export(batch))
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.handleError(b.export(batch))
+			batch = batch[:0]
+		case result := <-b.flushes:
+			result <- b.flush(batch)
+			batch = batch[:0]
+		case <-b.stop:
+			b.handleError(b.flush(batch))
+			return
+		}
+	}
+}
+
+// flush - Exports the provided batch and the records queued so far. Returns the last export error.
+func (b *Bridge) flush(batch []record) error {
+	var err error
+	pending := len(b.queue)
+	for {
+		for ; pending > 0 && len(batch) < b.options.BatchSize; pending-- {
+			batch = append(batch, <-b.queue)
+		}
+		if len(batch) == 0 {
+			return err
+		}
+		if errExport := b.export(batch); errExport != nil {
+			err = errExport
+		}
+		batch = batch[:0]
+	}
+}
+
+// export - Exports the provided batch
+func (b *Bridge) export(batch []record) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.options.ExportTimeout)
+	defer cancel()
+	if b.options.Mode == Spans {
+		spans := make([]Span, len(batch))
+		for i, r := range batch {
+			spans[i] = r.span
+		}
+		return b.exporter.ExportSpans(ctx, spans)
+	}
+	records := make([]LogRecord, len(batch))
+	for i, r := range batch {
+		records[i] = r.log
+	}
+	return b.exporter.ExportLogs(ctx, records)
+}
+
+// handleError - Hands the provided export error over to the error handler
+func (b *Bridge) handleError(err error) {
+	if err != nil && b.options.ErrorHandler != nil {
+		b.options.ErrorHandler(err)
+	}
+}
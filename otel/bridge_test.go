@@ -0,0 +1,153 @@
+package otel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+type testExporter struct {
+	sync.Mutex
+	logs    []LogRecord
+	spans   []Span
+	batches int
+}
+
+func (e *testExporter) ExportLogs(ctx context.Context, records []LogRecord) error {
+	e.Lock()
+	defer e.Unlock()
+	e.logs = append(e.logs, records...)
+	e.batches++
+	return nil
+}
+
+func (e *testExporter) ExportSpans(ctx context.Context, spans []Span) error {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, spans...)
+	e.batches++
+	return nil
+}
+
+type execEvent struct {
+	Timestamp uint64
+	PID       uint32
+	Comm      [16]byte
+	Parent    struct {
+		PID uint32
+	}
+	padding uint32
+}
+
+func TestEventFields(t *testing.T) {
+	event := &execEvent{PID: 42}
+	copy(event.Comm[:], "bash")
+	event.Parent.PID = 1
+	fields := EventFields(event)
+	for name, expected := range map[string]interface{}{"PID": int64(42), "Comm": "bash", "Parent.PID": int64(1), "Timestamp": int64(0)} {
+		if fields[name] != expected {
+			t.Errorf("expected %s to be %v, got %v", name, expected, fields[name])
+		}
+	}
+	if _, ok := fields["padding"]; ok {
+		t.Error("expected the unexported fields to be skipped")
+	}
+
+	fields = EventFields(map[string]interface{}{"pid": uint32(7), "sock": map[string]interface{}{"sport": uint16(80)}})
+	if fields["pid"] != int64(7) || fields["sock.sport"] != int64(80) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if fields = EventFields([]byte("raw\x00")); fields["value"] != "raw" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestBridgeLogs(t *testing.T) {
+	exporter := &testExporter{}
+	bridge := NewBridge(exporter, Options{
+		AttributeMapping: map[string]string{"PID": "process.pid", "Comm": "process.command"},
+		Attributes:       map[string]interface{}{"service.name": "agent"},
+		TimestampField:   "Timestamp",
+		BodyField:        "Comm",
+		BatchSize:        2,
+	})
+	handler := bridge.PerfMapEventHandler()
+	perfMap := &manager.PerfMap{Map: manager.Map{Name: "exec_events"}}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		event := &execEvent{PID: uint32(i)}
+		copy(event.Comm[:], "bash")
+		handler(1, event, perfMap, nil)
+	}
+	if err := bridge.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter.Lock()
+	defer exporter.Unlock()
+	if len(exporter.logs) != 3 || exporter.batches != 2 {
+		t.Fatalf("expected 3 records in 2 batches, got %d in %d", len(exporter.logs), exporter.batches)
+	}
+	record := exporter.logs[2]
+	for key, expected := range map[string]interface{}{
+		"process.pid":     int64(2),
+		"process.command": "bash",
+		"service.name":    "agent",
+		SourceAttribute:   "exec_events",
+		CPUAttribute:      int64(1),
+	} {
+		if record.Attributes[key] != expected {
+			t.Errorf("expected attribute %s to be %v, got %v", key, expected, record.Attributes[key])
+		}
+	}
+	if len(record.Attributes) != 5 {
+		t.Errorf("expected only the mapped attributes, got %v", record.Attributes)
+	}
+	if record.Body != "bash" || record.SeverityText != "INFO" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	// a zero ktime is the boot time
+	if !record.Timestamp.Before(start) || record.ObservedTimestamp.Before(start) {
+		t.Errorf("unexpected timestamps %v and %v", record.Timestamp, record.ObservedTimestamp)
+	}
+	if err := bridge.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.Flush(context.Background()); err != ErrBridgeClosed {
+		t.Errorf("expected ErrBridgeClosed, got %v", err)
+	}
+}
+
+func TestBridgeSpans(t *testing.T) {
+	type span struct {
+		Start uint64
+		End   uint64
+	}
+	exporter := &testExporter{}
+	bridge := NewBridge(exporter, Options{
+		Mode:              Spans,
+		TimestampField:    "Start",
+		EndTimestampField: "End",
+		SpanName:          func(event interface{}) string { return "syscall" },
+		QueueSize:         1,
+	})
+	handler := bridge.RingBufferEventHandler()
+	ringBuffer := &manager.RingBuffer{Map: manager.Map{Name: "spans"}}
+	handler(&span{Start: 1000, End: 1500}, ringBuffer, nil)
+	if err := bridge.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter.Lock()
+	defer exporter.Unlock()
+	if len(exporter.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(exporter.spans))
+	}
+	exported := exporter.spans[0]
+	if exported.Name != "syscall" || exported.EndTime.Sub(exported.StartTime) != 500*time.Nanosecond {
+		t.Errorf("unexpected span %+v", exported)
+	}
+}
@@ -0,0 +1,111 @@
+package otel
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventFields - Returns the fields of a decoded event as OpenTelemetry attribute values, indexed by field name: the
+// entries of the maps returned by manager.NewBTFDecoder, or the exported fields of the structs returned by
+// manager.NewStructDecoder. The nested structs are flattened with dotted names (sock.sport). The integers are
+// converted to int64, except the uint64 above the int64 range, the floats to float64, the char and byte arrays to
+// strings up to their first NUL byte, and the other values to their fmt representation. Other events are returned
+// as a single "value" field.
+func EventFields(event interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	value := reflect.ValueOf(event)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Map, reflect.Struct:
+		flattenFields(fields, "", value)
+	case reflect.Invalid:
+	default:
+		fields["value"] = attributeValue(value)
+	}
+	return fields
+}
+
+// flattenFields - Adds the fields of the provided map or struct to fields, prefixed by prefix
+func flattenFields(fields map[string]interface{}, prefix string, value reflect.Value) {
+	add := func(name string, field reflect.Value) {
+		for (field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface) && !field.IsNil() {
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Map || field.Kind() == reflect.Struct {
+			flattenFields(fields, prefix+name+".", field)
+			return
+		}
+		fields[prefix+name] = attributeValue(field)
+	}
+	switch value.Kind() {
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			add(fmt.Sprint(iter.Key().Interface()), iter.Value())
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.IsExported() {
+				add(field.Name, value.Field(i))
+			}
+		}
+	}
+}
+
+// attributeValue - Converts the provided value into an OpenTelemetry attribute value, see EventFields
+func attributeValue(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := value.Uint(); u > 1<<63-1 {
+			return u
+		}
+		return int64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.String:
+		return value.String()
+	case reflect.Array, reflect.Slice:
+		if kind := value.Type().Elem().Kind(); kind == reflect.Uint8 || kind == reflect.Int8 {
+			raw := make([]byte, value.Len())
+			for i := range raw {
+				if kind == reflect.Int8 {
+					raw[i] = byte(value.Index(i).Int())
+				} else {
+					raw[i] = byte(value.Index(i).Uint())
+				}
+			}
+			if i := bytes.IndexByte(raw, 0); i >= 0 {
+				raw = raw[:i]
+			}
+			return string(raw)
+		}
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// KtimeToTime - Converts a bpf_ktime_get_ns() value, the CLOCK_MONOTONIC time in nanoseconds, into wall clock time
+func KtimeToTime(ktime uint64) time.Time {
+	return monotonicOrigin().Add(time.Duration(ktime))
+}
+
+// monotonicOrigin - Returns the wall clock time of the origin of CLOCK_MONOTONIC
+func monotonicOrigin() time.Time {
+	var ts unix.Timespec
+	now := time.Now().Round(0)
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return now
+	}
+	return now.Add(-time.Duration(ts.Nano()))
+}
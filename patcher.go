@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// InstructionPatcher - Pre-loading program editor. It can rewrite the instructions of the provided ProgramSpec in
+// place, for example to change map references, to strip the helpers that aren't supported by the running kernel or to
+// inject NOPs, and is the arbitrary counterpart of the constant editors.
+type InstructionPatcher func(spec *ebpf.ProgramSpec) error
+
+// patchInstructions - Runs the instruction patchers of the probes on their program specs, and the instruction patchers
+// of the manager on every program spec of the CollectionSpec. The probe patchers run first.
+func (m *Manager) patchInstructions() error {
+	for _, probe := range m.Probes {
+		if probe.InstructionPatcher == nil || probe.skipReason != nil || probe.programSpec == nil {
+			continue
+		}
+		if err := probe.InstructionPatcher(probe.programSpec); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't patch the instructions of %v", err, probe.GetIdentificationPair()))
+		}
+	}
+
+	for _, patcher := range m.options.InstructionPatchers {
+		for name, spec := range m.collectionSpec.Programs {
+			if err := patcher(spec); err != nil {
+				return errors.New(fmt.Sprintf("error:%v , couldn't patch the instructions of %s", err, name))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestInstructionPatchers(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	var patched []string
+	m := &Manager{
+		Probes: []*Probe{{
+			Section:      "socket",
+			EbpfFuncName: "rewrite",
+			InstructionPatcher: func(spec *ebpf.ProgramSpec) error {
+				spec.Instructions = asm.Instructions{
+					asm.LoadImm(asm.R0, 42, asm.DWord),
+					asm.Return(),
+				}
+				return nil
+			},
+		}},
+		Maps: []*Map{{Name: "map_val"}},
+	}
+	options := Options{
+		InstructionPatchers: []InstructionPatcher{func(spec *ebpf.ProgramSpec) error {
+			patched = append(patched, spec.Name)
+			return nil
+		}},
+	}
+	if err = m.InitWithOptions(elf, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	if len(patched) != len(m.collectionSpec.Programs) {
+		t.Errorf("expected the manager patcher to run on %d programs, got %v", len(m.collectionSpec.Programs), patched)
+	}
+	_, ret, _, err := m.TestRunProgram("rewrite", make([]byte, 14), 1)
+	if err != nil {
+		t.Skipf("test run not supported: %v", err)
+	}
+	if ret != 42 {
+		t.Errorf("expected the patched program to return 42, got %d", ret)
+	}
+}
+
+func TestInstructionPatcherError(t *testing.T) {
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	patchErr := errors.New("unsupported helper")
+	m := &Manager{}
+	options := Options{
+		InstructionPatchers: []InstructionPatcher{func(spec *ebpf.ProgramSpec) error {
+			return patchErr
+		}},
+	}
+	if err = m.InitWithOptions(elf, options); err == nil {
+		t.Fatal("expected the error of the instruction patcher")
+	}
+}
@@ -0,0 +1,195 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// PcapLinkTypeEthernet - LINKTYPE_ETHERNET, packets starting with an Ethernet header (XDP, TC)
+	PcapLinkTypeEthernet uint16 = 1
+	// PcapLinkTypeRaw - LINKTYPE_RAW, packets starting with an IPv4 or IPv6 header
+	PcapLinkTypeRaw uint16 = 101
+
+	// DefaultPcapSnapLen - Default maximum number of bytes captured per packet
+	DefaultPcapSnapLen uint32 = 65535
+)
+
+// pcapng block types and options
+const (
+	pcapngSectionHeader     uint32 = 0x0a0d0d0a
+	pcapngInterfaceDesc     uint32 = 0x00000001
+	pcapngEnhancedPacket    uint32 = 0x00000006
+	pcapngByteOrderMagic    uint32 = 0x1a2b3c4d
+	pcapngOptionEnd         uint16 = 0
+	pcapngOptionComment     uint16 = 1
+	pcapngOptionIfName      uint16 = 2
+	pcapngOptionIfTSResol   uint16 = 9
+	pcapngTSResolNanosecond byte   = 9
+)
+
+// PcapOptions - Options of a PcapWriter
+type PcapOptions struct {
+	// LinkType - Link layer type of the packets, see PcapLinkTypeEthernet and PcapLinkTypeRaw. Defaults to
+	// PcapLinkTypeEthernet.
+	LinkType uint16
+
+	// SnapLen - Maximum number of bytes captured per packet, the packets are truncated beyond. Defaults to
+	// DefaultPcapSnapLen.
+	SnapLen uint32
+
+	// InterfaceName - Name of the capture interface written in the file, usually the interface of the probe
+	InterfaceName string
+
+	// PayloadOffset - Offset of the packet in the samples, when the eBPF program writes metadata before the packet
+	PayloadOffset int
+
+	// OriginalLength - Callback function used to extract the length of the packet on the wire from a sample, when
+	// the eBPF program only copied the beginning of the packet. Defaults to the length of the payload.
+	OriginalLength func(data []byte) int
+}
+
+// PcapWriter - Writes packets in the pcapng format, so that captured traffic opens directly in Wireshark or tcpdump.
+// A PcapWriter is a RecordSink: set it as the RecordSink of a perf map or a ring buffer whose samples are packets
+// (XDP, TC, socket filter probes), and the recorded samples are written as packets timestamped with the time they
+// were read. The reports of lost samples are counted in Dropped. PcapWriter is safe for concurrent use.
+type PcapWriter struct {
+	lock    sync.Mutex
+	w       io.Writer
+	options PcapOptions
+	dropped uint64
+}
+
+// NewPcapWriter - Writes the pcapng section header and the interface description to the provided writer, and returns
+// a PcapWriter writing packets to it
+func NewPcapWriter(w io.Writer, options PcapOptions) (*PcapWriter, error) {
+	if options.LinkType == 0 {
+		options.LinkType = PcapLinkTypeEthernet
+	}
+	if options.SnapLen == 0 {
+		options.SnapLen = DefaultPcapSnapLen
+	}
+	pw := &PcapWriter{w: w, options: options}
+
+	// section header block
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	if err := pw.writeBlock(pcapngSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	// interface description block, timestamps are written in nanoseconds
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], options.LinkType)
+	binary.LittleEndian.PutUint32(idb[4:], options.SnapLen)
+	if options.InterfaceName != "" {
+		idb = appendPcapngOption(idb, pcapngOptionIfName, []byte(options.InterfaceName))
+	}
+	idb = appendPcapngOption(idb, pcapngOptionIfTSResol, []byte{pcapngTSResolNanosecond})
+	idb = appendPcapngOption(idb, pcapngOptionEnd, nil)
+	if err := pw.writeBlock(pcapngInterfaceDesc, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// appendPcapngOption - Appends the provided option to the options of a block, padded to 32 bits
+func appendPcapngOption(block []byte, code uint16, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:], code)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+	block = append(block, header...)
+	block = append(block, value...)
+	return append(block, make([]byte, pcapngPadding(len(value)))...)
+}
+
+// pcapngPadding - Returns the number of bytes needed to align the provided length on 32 bits
+func pcapngPadding(length int) int {
+	return (4 - length%4) % 4
+}
+
+// writeBlock - Writes a pcapng block with the provided type and body, the body must be aligned on 32 bits
+func (pw *PcapWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, length)
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], length)
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[length-4:], length)
+	if _, err := pw.w.Write(block); err != nil {
+		return fmt.Errorf("error:%w , couldn't write pcapng block: %v", ErrRecordSink, err)
+	}
+	return nil
+}
+
+// WritePacket - Writes the provided packet, captured at the provided time, with a comment (the CPU of the sample for
+// instance) if not empty
+func (pw *PcapWriter) WritePacket(timestamp time.Time, packet []byte, originalLength int, comment string) error {
+	if originalLength < len(packet) {
+		originalLength = len(packet)
+	}
+	if uint32(len(packet)) > pw.options.SnapLen {
+		packet = packet[:pw.options.SnapLen]
+	}
+	ts := uint64(timestamp.UnixNano())
+	epb := make([]byte, 20, 20+len(packet)+4)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(originalLength))
+	epb = append(epb, packet...)
+	epb = append(epb, make([]byte, pcapngPadding(len(packet)))...)
+	if comment != "" {
+		epb = appendPcapngOption(epb, pcapngOptionComment, []byte(comment))
+		epb = appendPcapngOption(epb, pcapngOptionEnd, nil)
+	}
+
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	return pw.writeBlock(pcapngEnhancedPacket, epb)
+}
+
+// Write - Writes the sample framed by a perf map or a ring buffer as a packet, see RecordSink. The payload starts at
+// PayloadOffset, samples shorter than that are dropped.
+func (pw *PcapWriter) Write(p []byte) (int, error) {
+	sample, err := NewRecordReader(bytes.NewReader(p)).Read()
+	if err != nil {
+		return 0, err
+	}
+	if sample.LostSamples > 0 || len(sample.Data) < pw.options.PayloadOffset {
+		pw.lock.Lock()
+		pw.dropped += sample.LostSamples
+		if sample.LostSamples == 0 {
+			pw.dropped++
+		}
+		pw.lock.Unlock()
+		return len(p), nil
+	}
+	packet := sample.Data[pw.options.PayloadOffset:]
+	originalLength := len(packet)
+	if pw.options.OriginalLength != nil {
+		originalLength = pw.options.OriginalLength(sample.Data)
+	}
+	var comment string
+	if sample.CPU >= 0 {
+		comment = fmt.Sprintf("cpu %d", sample.CPU)
+	}
+	if err = pw.WritePacket(sample.Timestamp, packet, originalLength, comment); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Dropped - Returns the number of packets lost by the kernel, or too short to hold a packet
+func (pw *PcapWriter) Dropped() uint64 {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	return pw.dropped
+}
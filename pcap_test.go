@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestPcapWriter(t *testing.T) {
+	var capture bytes.Buffer
+	pcap, err := NewPcapWriter(&capture, PcapOptions{InterfaceName: "lo", SnapLen: 4, PayloadOffset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	perfMap := &PerfMap{Map: Map{Name: "packets"}, PerfMapOptions: PerfMapOptions{TestMode: true, RecordSink: pcap}}
+	if err = perfMap.Init(&Manager{wg: &sync.WaitGroup{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// the first byte of the samples is metadata, the packets are truncated to the snaplen
+	for _, sample := range [][]byte{{0, 1, 2, 3}, {0, 1, 2, 3, 4, 5}} {
+		if err = perfMap.InjectSample(2, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = perfMap.InjectLostSamples(2, 5); err != nil {
+		t.Fatal(err)
+	}
+	if pcap.Dropped() != 5 {
+		t.Errorf("expected 5 dropped packets, got %d", pcap.Dropped())
+	}
+
+	// walk the pcapng blocks
+	data := capture.Bytes()
+	var types []uint32
+	var captured, original []uint32
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %v", data)
+		}
+		blockType, length := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block of type %d and length %d", blockType, length)
+		}
+		types = append(types, blockType)
+		switch blockType {
+		case pcapngSectionHeader:
+			if binary.LittleEndian.Uint32(data[8:]) != pcapngByteOrderMagic {
+				t.Error("invalid byte order magic")
+			}
+		case pcapngInterfaceDesc:
+			if binary.LittleEndian.Uint16(data[8:]) != PcapLinkTypeEthernet || binary.LittleEndian.Uint32(data[12:]) != 4 {
+				t.Error("invalid interface description")
+			}
+			if !bytes.Contains(data[:length], []byte("lo")) {
+				t.Error("expected the interface name in the interface description")
+			}
+		case pcapngEnhancedPacket:
+			captured = append(captured, binary.LittleEndian.Uint32(data[20:]))
+			original = append(original, binary.LittleEndian.Uint32(data[24:]))
+			if !bytes.Contains(data[:length], []byte("cpu 2")) {
+				t.Error("expected the CPU of the sample in the packet comment")
+			}
+		}
+		data = data[length:]
+	}
+	if len(types) != 4 || types[0] != pcapngSectionHeader || types[1] != pcapngInterfaceDesc {
+		t.Fatalf("unexpected blocks %v", types)
+	}
+	if captured[0] != 3 || original[0] != 3 || captured[1] != 4 || original[1] != 5 {
+		t.Errorf("unexpected packet lengths: captured %v, original %v", captured, original)
+	}
+}
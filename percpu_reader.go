@@ -0,0 +1,413 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// onlineCPUsPath - List of the online CPUs
+	onlineCPUsPath = "/sys/devices/system/cpu/online"
+
+	// perfRecordLost, perfRecordSample - PERF_RECORD_LOST and PERF_RECORD_SAMPLE
+	perfRecordLost   = 2
+	perfRecordSample = 9
+
+	// perfDataHeadOffset, perfDataTailOffset - Offsets of data_head and data_tail in struct perf_event_mmap_page
+	perfDataHeadOffset = 1024
+	perfDataTailOffset = 1032
+)
+
+// parseCPUList - Parses a list of CPUs in the format of the kernel, for example "0-3,8,10-11"
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list %q: %w", list, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid CPU list %q: %w", list, err)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// OnlineCPUs - Returns the list of the online CPUs
+func OnlineCPUs() ([]int, error) {
+	list, err := os.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(string(list))
+}
+
+// usePerCPUReader - Returns true if the perf ring buffers of the perf map must be read with a perCPURecordReader
+func (m *PerfMap) usePerCPUReader() bool {
+	return len(m.PerfRingBufferSizePerCPU) > 0 || len(m.CPUs) > 0 || m.OnlineCPUsOnly || m.ExternalPolling || m.pooled ||
+		(m.FlushOnDrain && !m.Overwritable)
+}
+
+// readerCPUs - Returns the CPUs on which the perf ring buffers of the perf map are opened
+func (m *PerfMap) readerCPUs() ([]int, error) {
+	cpus := m.CPUs
+	if len(cpus) == 0 {
+		cpus = make([]int, m.array.MaxEntries())
+		for i := range cpus {
+			cpus[i] = i
+		}
+	}
+	if m.OnlineCPUsOnly {
+		online, err := OnlineCPUs()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("error:%v , couldn't list the online CPUs", err))
+		}
+		isOnline := make(map[int]bool, len(online))
+		for _, cpu := range online {
+			isOnline[cpu] = true
+		}
+		var onlineCPUs []int
+		for _, cpu := range cpus {
+			if isOnline[cpu] {
+				onlineCPUs = append(onlineCPUs, cpu)
+			}
+		}
+		cpus = onlineCPUs
+	}
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= int(m.array.MaxEntries()) {
+			return nil, fmt.Errorf("error:%w , CPU %d of perf map %s", ErrInvalidPerfCPU, cpu, m.Name)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("error:%w , no CPU selected for perf map %s", ErrInvalidPerfCPU, m.Name)
+	}
+	return cpus, nil
+}
+
+// newPerCPUReader - Creates the perCPURecordReader of the perf map
+func (m *PerfMap) newPerCPUReader() (*perCPURecordReader, error) {
+	if m.Overwritable {
+		return nil, fmt.Errorf("error:%w , perf map %s", ErrOverwritablePerCPU, m.Name)
+	}
+	cpus, err := m.readerCPUs()
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[int]int, len(cpus))
+	for _, cpu := range cpus {
+		sizes[cpu] = m.PerfRingBufferSize
+		if size, ok := m.PerfRingBufferSizePerCPU[cpu]; ok && size > 0 {
+			sizes[cpu] = size
+		}
+	}
+	return newPerCPURecordReader(m.array, sizes, m.Watermark, m.WakeupEvents)
+}
+
+// perCPURing - Perf ring buffer of one CPU, read by a perCPURecordReader
+type perCPURing struct {
+	cpu  int
+	fd   int
+	mmap []byte
+	data []byte
+}
+
+// perCPURecordReader - recordReader opening the perf ring buffers of a subset of the CPUs, each with its own size. The
+// samples written on the other CPUs are dropped by the kernel, since their slot of the perf event array is empty.
+type perCPURecordReader struct {
+	array    *ebpf.Map
+	rings    []*perCPURing
+	epollFD  int
+	closeFD  int
+	closed   int32
+	flushing int32
+	lock     sync.Mutex
+	events   []unix.EpollEvent
+	pending  []perf.Record
+	deadline time.Time
+}
+
+// newPerCPURecordReader - Opens a perf ring buffer of the provided size on each of the provided CPUs, and inserts
+// them in the provided perf event array. sizes are rounded up to a power of 2 number of pages.
+func newPerCPURecordReader(array *ebpf.Map, sizes map[int]int, watermark int, wakeupEvents int) (_ *perCPURecordReader, err error) {
+	r := &perCPURecordReader{array: array, epollFD: -1, closeFD: -1}
+	defer func() {
+		if err != nil {
+			_ = r.Pause()
+			_ = r.cleanup()
+		}
+	}()
+
+	if r.epollFD, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		return nil, fmt.Errorf("couldn't create epoll instance: %w", err)
+	}
+	if r.closeFD, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK); err != nil {
+		return nil, fmt.Errorf("couldn't create eventfd: %w", err)
+	}
+	if err = unix.EpollCtl(r.epollFD, unix.EPOLL_CTL_ADD, r.closeFD, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: -1}); err != nil {
+		return nil, err
+	}
+
+	cpus := make([]int, 0, len(sizes))
+	for cpu := range sizes {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	for _, cpu := range cpus {
+		ring, err := openPerCPURing(cpu, sizes[cpu], watermark, wakeupEvents)
+		if err != nil {
+			return nil, err
+		}
+		r.rings = append(r.rings, ring)
+		if err = unix.EpollCtl(r.epollFD, unix.EPOLL_CTL_ADD, ring.fd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(len(r.rings) - 1)}); err != nil {
+			return nil, err
+		}
+	}
+	r.events = make([]unix.EpollEvent, len(r.rings)+1)
+	if err = r.Resume(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// openPerCPURing - Opens and maps a perf ring buffer on the provided CPU
+func openPerCPURing(cpu int, size int, watermark int, wakeupEvents int) (*perCPURing, error) {
+	pageSize := os.Getpagesize()
+	pages := 1
+	for pages*pageSize < size {
+		pages *= 2
+	}
+
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_SOFTWARE,
+		Config:      unix.PERF_COUNT_SW_BPF_OUTPUT,
+		Sample_type: unix.PERF_SAMPLE_RAW,
+		Wakeup:      1,
+	}
+	if wakeupEvents > 0 {
+		attr.Wakeup = uint32(wakeupEvents)
+	} else if watermark > 0 {
+		attr.Bits = unix.PerfBitWatermark
+		attr.Wakeup = uint32(watermark)
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the perf event of CPU %d: %w", cpu, err)
+	}
+	mmap, err := unix.Mmap(fd, 0, (pages+1)*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("couldn't map the perf ring buffer of CPU %d: %w", cpu, err)
+	}
+	return &perCPURing{cpu: cpu, fd: fd, mmap: mmap, data: mmap[pageSize:]}, nil
+}
+
+// readRecords - Returns the records written in the ring since the last call
+func (ring *perCPURing) readRecords() []perf.Record {
+	head := atomic.LoadUint64((*uint64)(unsafe.Pointer(&ring.mmap[perfDataHeadOffset])))
+	tail := atomic.LoadUint64((*uint64)(unsafe.Pointer(&ring.mmap[perfDataTailOffset])))
+	size := uint64(len(ring.data))
+
+	var records []perf.Record
+	for tail < head {
+		// struct perf_event_header: type, misc, size
+		header := ring.copyAt(tail, 8)
+		recordType := nativeEndian.Uint32(header[0:4])
+		recordSize := uint64(nativeEndian.Uint16(header[6:8]))
+		if recordSize < 8 || recordSize > size {
+			break
+		}
+		body := ring.copyAt(tail+8, recordSize-8)
+		switch recordType {
+		case perfRecordSample:
+			if len(body) >= 4 {
+				sampleSize := nativeEndian.Uint32(body[0:4])
+				if uint64(sampleSize) <= uint64(len(body)-4) {
+					records = append(records, perf.Record{CPU: ring.cpu, RawSample: body[4 : 4+sampleSize]})
+				}
+			}
+		case perfRecordLost:
+			// id, lost
+			if len(body) >= 16 {
+				records = append(records, perf.Record{CPU: ring.cpu, LostSamples: nativeEndian.Uint64(body[8:16])})
+			}
+		}
+		tail += recordSize
+	}
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&ring.mmap[perfDataTailOffset])), tail)
+	return records
+}
+
+// copyAt - Copies length bytes of the ring from the provided position, which wraps around
+func (ring *perCPURing) copyAt(position uint64, length uint64) []byte {
+	out := make([]byte, length)
+	start := position % uint64(len(ring.data))
+	n := copy(out, ring.data[start:])
+	copy(out[n:], ring.data)
+	return out
+}
+
+// close - Unmaps and closes the ring
+func (ring *perCPURing) close() error {
+	return ConcatErrors(unix.Munmap(ring.mmap), unix.Close(ring.fd))
+}
+
+func (r *perCPURecordReader) Read() (perf.Record, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for len(r.pending) == 0 {
+		if atomic.LoadInt32(&r.closed) == 1 {
+			return perf.Record{}, perf.ErrClosed
+		}
+		timeout := -1
+		if !r.deadline.IsZero() {
+			remaining := time.Until(r.deadline)
+			if remaining <= 0 {
+				return perf.Record{}, os.ErrDeadlineExceeded
+			}
+			timeout = int((remaining + time.Millisecond - 1) / time.Millisecond)
+		}
+		n, err := unix.EpollWait(r.epollFD, r.events, timeout)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return perf.Record{}, err
+		}
+		for _, event := range r.events[:n] {
+			if event.Fd < 0 {
+				if atomic.CompareAndSwapInt32(&r.flushing, 1, 0) {
+					var count [8]byte
+					_, _ = unix.Read(r.closeFD, count[:])
+					for _, ring := range r.rings {
+						r.pending = append(r.pending, ring.readRecords()...)
+					}
+				}
+				continue
+			}
+			r.pending = append(r.pending, r.rings[event.Fd].readRecords()...)
+		}
+	}
+	record := r.pending[0]
+	r.pending = r.pending[1:]
+	return record, nil
+}
+
+// readAvailable - Returns the records available in the perf ring buffers, without waiting for new ones
+func (r *perCPURecordReader) readAvailable() ([]perf.Record, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if atomic.LoadInt32(&r.closed) == 1 {
+		return nil, perf.ErrClosed
+	}
+	records := r.pending
+	r.pending = nil
+	for _, ring := range r.rings {
+		records = append(records, ring.readRecords()...)
+	}
+	return records, nil
+}
+
+// flush - Wakes up Read so that it returns the records left in the perf ring buffers, even if their Watermark or
+// WakeupEvents threshold wasn't reached
+func (r *perCPURecordReader) flush() {
+	atomic.StoreInt32(&r.flushing, 1)
+	var one [8]byte
+	nativeEndian.PutUint64(one[:], 1)
+	_, _ = unix.Write(r.closeFD, one[:])
+}
+
+func (r *perCPURecordReader) SetDeadline(t time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.deadline = t
+}
+
+// Pause - Removes the perf ring buffers from the perf event array, the kernel drops the new samples
+func (r *perCPURecordReader) Pause() error {
+	for _, ring := range r.rings {
+		if err := r.array.Delete(uint32(ring.cpu)); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("couldn't remove the perf ring buffer of CPU %d: %w", ring.cpu, err)
+		}
+	}
+	return nil
+}
+
+// Resume - Inserts the perf ring buffers in the perf event array
+func (r *perCPURecordReader) Resume() error {
+	for _, ring := range r.rings {
+		if err := r.array.Put(uint32(ring.cpu), uint32(ring.fd)); err != nil {
+			return fmt.Errorf("couldn't insert the perf ring buffer of CPU %d: %w", ring.cpu, err)
+		}
+	}
+	return nil
+}
+
+func (r *perCPURecordReader) Close() error {
+	return r.close(true)
+}
+
+// closeReplaced - Closes the reader once its perf ring buffers were replaced in the perf event array by the rings of
+// another reader: unlike Close, the slots of the perf event array are left untouched, they hold the new rings
+func (r *perCPURecordReader) closeReplaced() error {
+	return r.close(false)
+}
+
+// close - Wakes up Read, waits for it to return and releases the resources of the reader. The perf ring buffers are
+// removed from the perf event array when pause is set.
+func (r *perCPURecordReader) close(pause bool) error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+	var one [8]byte
+	nativeEndian.PutUint64(one[:], 1)
+	_, _ = unix.Write(r.closeFD, one[:])
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if pause {
+		_ = r.Pause()
+	}
+	return r.cleanup()
+}
+
+// cleanup - Releases the resources of the reader
+func (r *perCPURecordReader) cleanup() error {
+	var err error
+	for _, ring := range r.rings {
+		err = ConcatErrors(err, ring.close())
+	}
+	r.rings = nil
+	if r.closeFD >= 0 {
+		_ = unix.Close(r.closeFD)
+		r.closeFD = -1
+	}
+	if r.epollFD >= 0 {
+		_ = unix.Close(r.epollFD)
+		r.epollFD = -1
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-3,8,10-11\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{0, 1, 2, 3, 8, 10, 11}; !reflect.DeepEqual(cpus, expected) {
+		t.Errorf("expected %v, got %v", expected, cpus)
+	}
+	if _, err = parseCPUList("0-a"); err == nil {
+		t.Error("expected an error for an invalid CPU list")
+	}
+}
+
+func TestPerCPURecordReader(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+
+	perfMap := &PerfMap{Map: Map{Name: "events", array: events}, PerfMapOptions: PerfMapOptions{
+		PerfRingBufferSize:       4096,
+		PerfRingBufferSizePerCPU: map[int]int{0: 3 * 4096},
+		CPUs:                     []int{0},
+	}}
+	reader, err := perfMap.newPerCPUReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if len(reader.rings[0].data) != 4*4096 {
+		t.Errorf("expected the size of the ring to be rounded up to 4 pages, got %d", len(reader.rings[0].data))
+	}
+
+	// xdp: writes the 4 bytes 0x01020304 on the perf ring buffer of the current CPU
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -8, 0x01020304, asm.Word),
+			asm.LoadMapPtr(asm.R2, events.FD()),
+			asm.LoadImm(asm.R3, 0xffffffff, asm.DWord),
+			asm.Mov.Reg(asm.R4, asm.RFP),
+			asm.Add.Imm(asm.R4, -8),
+			asm.Mov.Imm(asm.R5, 4),
+			asm.FnPerfEventOutput.Call(),
+			asm.Mov.Imm(asm.R0, 2),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var cpuSet unix.CPUSet
+	cpuSet.Set(0)
+	if err = unix.SchedSetaffinity(0, &cpuSet); err != nil {
+		t.Skipf("couldn't run on CPU 0: %v", err)
+	}
+	if _, _, err = prog.Benchmark(make([]byte, 14), 1, nil); err != nil {
+		t.Skipf("couldn't run the program: %v", err)
+	}
+
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.CPU != 0 || len(record.RawSample) < 4 || nativeEndian.Uint32(record.RawSample) != 0x01020304 {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	// Close wakes up a blocked Read
+	readErr := make(chan error)
+	go func() {
+		_, err := reader.Read()
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-readErr; !errors.Is(err, perf.ErrClosed) {
+		t.Errorf("expected perf.ErrClosed, got %v", err)
+	}
+
+	perfMap.CPUs = []int{int(events.MaxEntries())}
+	if _, err = perfMap.readerCPUs(); !errors.Is(err, ErrInvalidPerfCPU) {
+		t.Errorf("expected ErrInvalidPerfCPU, got %v", err)
+	}
+}
@@ -1,11 +1,19 @@
 package manager
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/perf"
+	"golang.org/x/sys/unix"
 )
 
 // PerfMapOptions - Perf map specific options
@@ -13,10 +21,39 @@ type PerfMapOptions struct {
 	// PerfRingBufferSize - Size in bytes of the perf ring buffer. Defaults to the manager value if not set.
 	PerfRingBufferSize int
 
+	// PerfRingBufferSizePerCPU - Size in bytes of the perf ring buffer of specific CPUs, the other CPUs use
+	// PerfRingBufferSize. Sizes are rounded up to a power of 2 number of pages.
+	PerfRingBufferSizePerCPU map[int]int
+
+	// CPUs - When set, the perf ring buffers are only opened on these CPUs. The samples written on the other CPUs are
+	// dropped by the kernel (bpf_perf_event_output returns -ENOENT) and aren't reported as lost. This saves memory on
+	// machines with hundreds of CPUs, when the probes only run on some of them.
+	CPUs []int
+
+	// OnlineCPUsOnly - When enabled, the perf ring buffers are only opened on the CPUs online when the perf map starts
+	// (in CPUs if set). Mutually exclusive with Overwritable, like PerfRingBufferSizePerCPU and CPUs.
+	OnlineCPUsOnly bool
+
 	// Watermark - The reader will start processing samples once their sizes in the perf ring buffer
 	// exceed this value. Must be smaller than PerfRingBufferSize. Defaults to the manager value if not set.
 	Watermark int
 
+	// WakeupEvents - The reader will be woken up every WakeupEvents samples, instead of using a byte Watermark. This is
+	// more predictable for fixed-size events. A large value reduces the number of wake ups (and therefore of syscalls)
+	// at the cost of latency: samples stay in the perf ring buffer until enough of them were written. Mutually
+	// exclusive with Watermark.
+	WakeupEvents int
+
+	// FlushOnDrain - (Watermark, WakeupEvents) Reads the perf ring buffers with the reader of the manager instead of
+	// perf.Reader, which can't read the samples below the threshold: they are then read when the perf map is drained
+	// (see Manager.StopWithContext) or resized. Ignored for Overwritable perf maps.
+	FlushOnDrain bool
+
+	// Overwritable - When enabled, the perf ring buffers are created in overwrite mode: once a ring is full, the kernel
+	// overwrites its oldest samples instead of dropping the new ones, and no lost samples are reported. This is meant
+	// for flight recorder style capture, Pause the perf map to read a consistent snapshot of the latest samples.
+	Overwritable bool
+
 	// PerfErrChan - Perf reader error channel
 	PerfErrChan chan error
 
@@ -24,10 +61,107 @@ type PerfMapOptions struct {
 	// ring buffer.
 	DataHandler func(CPU int, data []byte, perfMap *PerfMap, manager *Manager)
 
+	// BatchDataHandler - Callback function called with a batch of samples retrieved from the perf ring buffer of the
+	// same CPU. This reduces the callback overhead of high throughput perf maps. When set, DataHandler is ignored.
+	BatchDataHandler func(CPU int, samples [][]byte, perfMap *PerfMap, manager *Manager)
+
+	// BatchSize - (BatchDataHandler) Maximum number of samples passed to BatchDataHandler at once. Defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// BatchFlushInterval - (BatchDataHandler) Maximum amount of time a sample is held before its batch is delivered,
+	// even if it isn't full. Defaults to DefaultBatchFlushInterval.
+	BatchFlushInterval time.Duration
+
+	// CoalesceWindow - When set, the samples of the same key seen within CoalesceWindow are merged into the first one,
+	// which is delivered once the window expired, to cut the cost of the extremely chatty probes (page faults, packet
+	// drops...). The samples are keyed by CoalesceKey. Disabled when 0.
+	CoalesceWindow time.Duration
+
+	// CoalesceKey - (CoalesceWindow) Callback function returning the key of a sample, the samples of the same key are
+	// merged. Defaults to the content of the sample, so that only the identical samples are merged.
+	CoalesceKey func(CPU int, data []byte) string
+
+	// CoalesceMaxKeys - (CoalesceWindow) Maximum number of keys held at once, the oldest key is delivered early once
+	// this limit is reached. Defaults to DefaultCoalesceMaxKeys.
+	CoalesceMaxKeys int
+
+	// CoalescedDataHandler - (CoalesceWindow) Callback function called with the first sample of a key and the number of
+	// samples of this key seen within the window. When set, DataHandler, BatchDataHandler and EventHandler are ignored,
+	// otherwise the first sample is passed to them and the count is dropped.
+	CoalescedDataHandler func(CPU int, data []byte, count uint64, perfMap *PerfMap, manager *Manager)
+
+	// EventHandler - Callback function called with the structured event decoded from a new sample by Decoder. When set,
+	// DataHandler is ignored. Ignored if BatchDataHandler is set. The samples that can't be decoded are counted in
+	// PerfMapStats.DecodeErrors and reported on PerfErrChan.
+	EventHandler func(CPU int, event interface{}, perfMap *PerfMap, manager *Manager)
+
+	// Decoder - (EventHandler) Decodes the samples of the perf map, see NewStructDecoder and NewBTFDecoder
+	Decoder Decoder
+
+	// EventType - (EventHandler) Name of a type of the BTF of the manager. When Decoder isn't set, the samples are
+	// decoded according to the layout of this type, see NewBTFDecoder.
+	EventType string
+
+	// PollTimeout - Maximum amount of time the reader waits for a new sample before calling OnIdle. Disabled when 0.
+	PollTimeout time.Duration
+
+	// OnIdle - (PollTimeout) Callback function called when no sample was retrieved from the perf ring buffer within
+	// PollTimeout, and then every PollTimeout until a sample arrives. This is a natural flush trigger for the consumers
+	// that aggregate the samples. With Options.EventConcurrency, the handlers of the previous samples might still be
+	// running.
+	OnIdle func(perfMap *PerfMap, manager *Manager)
+
+	// ExternalPolling - When enabled, Start doesn't start a goroutine to read the perf ring buffers: they are opened
+	// with a reader whose epoll fd is returned by GetEpollFD, to be watched by the event loop of the caller, and their
+	// samples are dispatched when ReadAvailable is called. PollTimeout and OnIdle are ignored. The epoll fd changes
+	// when the perf ring buffers are resized, see AutoResizeLostThreshold. Not supported by the perf maps defined on
+	// BPF ring buffers.
+	ExternalPolling bool
+
 	// LostHandler - Callback function called when one or more events where dropped by the kernel
 	// because the perf ring buffer was full.
 	LostHandler func(CPU int, count uint64, perfMap *PerfMap, manager *Manager)
 
+	// RecordSink - The raw samples and the lost samples reports of the perf map are written to this writer, framed
+	// with their CPU and a timestamp, to capture now and analyze later, see RecordReader, PerfMap.Replay and
+	// RotatingFile. When no handler is set, the samples are only recorded. Must be safe for concurrent use if shared.
+	RecordSink io.Writer
+
+	// AutoResizeLostThreshold - When more than AutoResizeLostThreshold samples are lost within AutoResizeWindow, the
+	// perf ring buffers are recreated with twice their size, up to AutoResizeMaxSize. The probes stay attached, the
+	// samples of the previous rings are read before they are closed, except the ones below the Watermark or
+	// WakeupEvents threshold when the rings are read by perf.Reader (see FlushOnDrain).
+	// Disabled when 0. Ignored for perf maps defined on BPF ring buffers.
+	AutoResizeLostThreshold uint64
+
+	// AutoResizeWindow - (AutoResizeLostThreshold) Time window over which the lost samples are counted. Defaults to
+	// DefaultAutoResizeWindow.
+	AutoResizeWindow time.Duration
+
+	// AutoResizeMaxSize - (AutoResizeLostThreshold) Maximum size in bytes of the perf ring buffers. Defaults to
+	// DefaultAutoResizeFactor times PerfRingBufferSize.
+	AutoResizeMaxSize int
+
+	// ResizeHandler - (AutoResizeLostThreshold) Callback function called once the perf ring buffers were resized,
+	// with their previous and new sizes
+	ResizeHandler func(oldSize int, newSize int, perfMap *PerfMap, manager *Manager)
+
+	// ResyncLostThreshold - When more than ResyncLostThreshold samples are lost within ResyncWindow, the state built
+	// from the samples of the perf map can't be trusted anymore: the perf map is paused, ResyncHandler is called to
+	// rebuild this state from a source of truth (rescan /proc, dump a state map...) and the perf map is resumed.
+	// Disabled when 0.
+	ResyncLostThreshold uint64
+
+	// ResyncWindow - (ResyncLostThreshold) Time window over which the lost samples are counted. Defaults to
+	// DefaultResyncWindow.
+	ResyncWindow time.Duration
+
+	// ResyncHandler - (ResyncLostThreshold) Callback function called with the number of lost samples that triggered
+	// the resync, while the perf map is paused. It runs on the reader of the perf map: the samples of the other CPUs
+	// wait, or are lost, until it returns. Its errors are reported on PerfErrChan and Options.ErrorChan.
+	ResyncHandler func(lost uint64, perfMap *PerfMap, manager *Manager) error
+
 	// PerfMapStats - Perf map statistics event like nr Read errors, lost samples,
 	// RawSamples bytes count. Need to be initialized via manager.NewPerfMapStats()
 	PerfMapStats *PerfMapStats
@@ -35,24 +169,137 @@ type PerfMapOptions struct {
 	// DumpHandler - Callback function called when manager.Dump() is called
 	// and dump the current state (human readable)
 	DumpHandler func(perfMap *PerfMap, manager *Manager) string
+
+	// TestMode - When enabled, the perf map doesn't need a kernel: Init doesn't look for the underlying eBPF map and
+	// Start doesn't open a perf ring reader. Use InjectSample and InjectLostSamples to feed synthetic records through
+	// the normal dispatch path. This is meant to unit test DataHandler and LostHandler without CAP_BPF.
+	TestMode bool
+
+	// KeepRecentSamples - Number of recent raw samples kept in memory for debugging purposes. When DumpHandler isn't
+	// set, manager.DumpMaps() hex-dumps them. Disabled when 0.
+	KeepRecentSamples int
+
+	// OrderedDelivery - When enabled, samples are held in a bounded reorder buffer for ReorderWindow and delivered to
+	// DataHandler in timestamp order, across all CPUs. See SampleTimestamp and TimestampOffset.
+	OrderedDelivery bool
+
+	// OrderedStream - When enabled, the samples are delivered to Options.OrderedDataHandler of the manager, merged in
+	// timestamp order with the samples of the other perf maps and ring buffers of the manager that enable
+	// OrderedStream. DataHandler isn't required in this mode. See SampleTimestamp and TimestampOffset.
+	OrderedStream bool
+
+	// SampleTimestamp - (OrderedDelivery, OrderedStream) Callback function used to extract the timestamp of a sample,
+	// usually the bpf_ktime_get_ns() value written by the eBPF program at the beginning of the event. Use it to decode
+	// the timestamp of a structured event.
+	SampleTimestamp func(CPU int, data []byte) uint64
+
+	// TimestampOffset - (OrderedDelivery, OrderedStream) Offset of the 64 bits timestamp in the samples, in the host
+	// byte order. Used when SampleTimestamp isn't set, defaults to the beginning of the samples.
+	TimestampOffset int
+
+	// ReorderWindow - (OrderedDelivery) Amount of time a sample is held before being delivered. A larger window
+	// tolerates more skew between CPUs, at the cost of latency. Defaults to DefaultReorderWindow.
+	ReorderWindow time.Duration
+
+	// ReorderBufferSize - (OrderedDelivery) Maximum number of samples held in the reorder buffer. The oldest samples
+	// are delivered early when the buffer is full. Defaults to DefaultReorderBufferSize.
+	ReorderBufferSize int
+
+	// ReorderLatePolicy - (OrderedDelivery) Defines what happens to a sample older than a sample that was already
+	// delivered. Defaults to ReorderDropLate.
+	ReorderLatePolicy ReorderLatePolicy
 }
 
 // PerfMap - Perf ring buffer reader wrapper
+//
+// Multiple perf maps can be registered with the same name to consume the same perf event array, each with its own
+// handlers and statistics, and its own Start, Stop, Pause and Resume. The perf ring buffers are opened once, by the
+// perf map started first, and each sample is delivered to all the running perf maps of the array. When the perf map
+// reading the perf ring buffers is stopped, the next one opens them again. The perf maps of an array must therefore
+// have the same reader options (see sameReaderOptions), Init returns ErrSharedPerfMapOptions otherwise. The handlers
+// of all the perf maps receive the same data slice: they must not modify it, and must copy it to retain it. The
+// underlying map is closed along with the last perf map.
+//
+// A PerfMap defined on a BPF_MAP_TYPE_RINGBUF map is read with a ring buffer reader, all its samples are reported on
+// CPU 0. See RingBuffer for more.
 type PerfMap struct {
-	manager    *Manager
-	perfReader *perf.Reader
+	manager      *Manager
+	perfReader   recordReader
+	activity     readerActivity
+	reorder      *reorderBuffer
+	reorderStop  chan struct{}
+	reorderDone  chan struct{}
+	batch        *sampleBatcher
+	batchStop    chan struct{}
+	batchDone    chan struct{}
+	coalescer    *sampleCoalescer
+	coalesceStop chan struct{}
+	coalesceDone chan struct{}
+	events       eventCounters
+
+	// pooled, poolSource - (Options.ReaderPoolSize) The perf ring buffers are read by the reader pool of the manager
+	pooled     bool
+	poolSource *readerPoolSource
+
+	// followers - ([]*PerfMap) The other perf maps sharing the perf event array, if this perf map reads the perf ring
+	// buffers for them. Updated by the manager under perfMapRefLock, loaded by the reader without lock.
+	followers atomic.Value
+
+	// dispatchPaused - Set while the perf map is paused, if it shares its perf event array with other perf maps: the
+	// perf ring buffers are still read for them, but the samples aren't delivered to this perf map. Updated atomically.
+	dispatchPaused int32
+
+	// lostWindowStart, lostInWindow - (AutoResizeLostThreshold) Lost samples counted in the current window, only
+	// accessed by the reader
+	lostWindowStart time.Time
+	lostInWindow    uint64
+
+	// resyncWindowStart, lostSinceResync - (ResyncLostThreshold) Lost samples counted in the current window, only
+	// accessed by the reader
+	resyncWindowStart time.Time
+	lostSinceResync   uint64
+
+	recentSamples     [][]byte
+	recentSamplesNext int
+	recentSamplesLock sync.Mutex
 
 	// Map - A PerfMap has the same features as a normal Map
 	Map
 	PerfMapOptions
 }
 
+// recordReader - Perf ring buffer reader used by a PerfMap, usually a *perf.Reader
+type recordReader interface {
+	Read() (perf.Record, error)
+	Pause() error
+	Resume() error
+	Close() error
+	// SetDeadline - Read returns os.ErrDeadlineExceeded once the provided deadline expired, a zero value disables
+	// the deadline. Must not be called concurrently with Read.
+	SetDeadline(t time.Time)
+}
+
 // PerfMapStats contain perf map read/errors statistics
-// including per CPU map bytes and lost bytes
+// including per CPU map bytes and lost bytes. See Manager.GetEventStats for the accounting of the samples of all the
+// perf maps and ring buffers, which doesn't need to be enabled. The statistics are updated while the perf map is
+// running, use Snapshot to read them.
 type PerfMapStats struct {
 	ReadErrors  uint64
 	RawSamples  map[int]uint64
 	LostSamples map[int]uint64
+
+	// ReorderDrops - (OrderedDelivery) Number of samples that arrived after a younger sample was already delivered
+	ReorderDrops uint64
+
+	// UserspaceDrops - Number of samples dropped by the event worker pool of the manager because its queue was full,
+	// see Options.EventConcurrency. Updated atomically.
+	UserspaceDrops uint64
+
+	// DecodeErrors - (EventHandler) Number of samples that couldn't be decoded. Updated atomically.
+	DecodeErrors uint64
+
+	// lock - Protects the statistics that aren't updated atomically
+	lock sync.Mutex
 }
 
 // NewPerfMapStats create/enable counting the perf map statistics performance/debug information
@@ -63,12 +310,62 @@ func NewPerfMapStats() *PerfMapStats {
 	}
 }
 
+// Snapshot - Returns a copy of the statistics, consistent even if the perf map is running
+func (stats *PerfMapStats) Snapshot() *PerfMapStats {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	snapshot := &PerfMapStats{
+		ReadErrors:     stats.ReadErrors,
+		RawSamples:     make(map[int]uint64, len(stats.RawSamples)),
+		LostSamples:    make(map[int]uint64, len(stats.LostSamples)),
+		ReorderDrops:   stats.ReorderDrops,
+		UserspaceDrops: atomic.LoadUint64(&stats.UserspaceDrops),
+		DecodeErrors:   atomic.LoadUint64(&stats.DecodeErrors),
+	}
+	for cpu, value := range stats.RawSamples {
+		snapshot.RawSamples[cpu] = value
+	}
+	for cpu, value := range stats.LostSamples {
+		snapshot.LostSamples[cpu] = value
+	}
+	return snapshot
+}
+
+// countRecord - Counts the bytes of the raw sample, or the lost samples, of the provided record
+func (stats *PerfMapStats) countRecord(record perf.Record) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	if record.LostSamples > 0 {
+		stats.LostSamples[record.CPU] += record.LostSamples
+		return
+	}
+	stats.RawSamples[record.CPU] += uint64(len(record.RawSample))
+}
+
+// countReadError - Counts an error returned by the perf ring reader
+func (stats *PerfMapStats) countReadError() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.ReadErrors++
+}
+
+// countReorderDrop - (OrderedDelivery) Counts a late sample
+func (stats *PerfMapStats) countReorderDrop() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.ReorderDrops++
+}
+
 func (new *PerfMapStats) Diff(old *PerfMapStats) (diff *PerfMapStats) {
 	if new == nil || old == nil {
 		return nil
 	}
+	new, old = new.Snapshot(), old.Snapshot()
 	diff = NewPerfMapStats()
 	diff.ReadErrors = new.ReadErrors - old.ReadErrors
+	diff.ReorderDrops = new.ReorderDrops - old.ReorderDrops
+	diff.UserspaceDrops = atomic.LoadUint64(&new.UserspaceDrops) - atomic.LoadUint64(&old.UserspaceDrops)
+	diff.DecodeErrors = atomic.LoadUint64(&new.DecodeErrors) - atomic.LoadUint64(&old.DecodeErrors)
 
 	for cpu := range new.RawSamples {
 		rawOld, found := old.RawSamples[cpu]
@@ -89,17 +386,13 @@ func (new *PerfMapStats) Diff(old *PerfMapStats) (diff *PerfMapStats) {
 
 // loadNewPerfMap - Creates a new perf map instance, loads it and setup the perf ring buffer reader
 func loadNewPerfMap(spec ebpf.MapSpec, options MapOptions, perfOptions PerfMapOptions) (*PerfMap, error) {
-	// Create underlying map
-	innerMap, err := loadNewMap(spec, options)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create the new map
+	// Create the new map and its underlying map
 	perfMap := PerfMap{
-		Map:            *innerMap,
 		PerfMapOptions: perfOptions,
 	}
+	if err := loadMap(&perfMap.Map, spec, options); err != nil {
+		return nil, err
+	}
 	return &perfMap, nil
 }
 
@@ -107,19 +400,67 @@ func loadNewPerfMap(spec ebpf.MapSpec, options MapOptions, perfOptions PerfMapOp
 func (m *PerfMap) Init(manager *Manager) error {
 	m.manager = manager
 
-	if m.DataHandler == nil {
+	if m.recordOnly() && m.RecordSink == nil {
 		return fmt.Errorf("no DataHandler set for %s", m.Name)
 	}
+	if m.OrderedStream && manager.options.OrderedDataHandler == nil {
+		return fmt.Errorf("error:%w , perf map %s", ErrNoOrderedDataHandler, m.Name)
+	}
+	if m.EventHandler != nil && m.Decoder == nil {
+		if m.EventType == "" {
+			return fmt.Errorf("error:%w , perf map %s", ErrMissingDecoder, m.Name)
+		}
+		decoder, err := manager.newEventTypeDecoder(m.EventType)
+		if err != nil {
+			return err
+		}
+		m.Decoder = decoder
+	}
 
 	// Set default values if not already set
 	if m.PerfRingBufferSize == 0 {
 		m.PerfRingBufferSize = manager.options.DefaultPerfRingBufferSize
 	}
-	if m.Watermark == 0 {
+	if m.Watermark == 0 && m.WakeupEvents == 0 {
 		m.Watermark = manager.options.DefaultWatermark
 	}
+	if m.AutoResizeLostThreshold > 0 {
+		if m.AutoResizeWindow == 0 {
+			m.AutoResizeWindow = DefaultAutoResizeWindow
+		}
+		if m.AutoResizeMaxSize == 0 {
+			m.AutoResizeMaxSize = DefaultAutoResizeFactor * m.PerfRingBufferSize
+		}
+	}
+
+	if m.ResyncLostThreshold > 0 && m.ResyncWindow == 0 {
+		m.ResyncWindow = DefaultResyncWindow
+	}
+
+	// the perf ring buffers of a shared perf event array are opened with the options of any of its perf maps
+	for _, other := range manager.PerfMaps {
+		if other == m {
+			break
+		}
+		if other.Name == m.Name && !other.TestMode && !m.TestMode {
+			if !m.sameReaderOptions(other) {
+				return fmt.Errorf("error:%w , perf map %s", ErrSharedPerfMapOptions, m.Name)
+			}
+			break
+		}
+	}
 
 	// Initialize the underlying map structure
+	if m.TestMode {
+		m.stateLock.Lock()
+		defer m.stateLock.Unlock()
+		if m.state >= initialized {
+			return ErrMapInitialized
+		}
+		m.Map.manager = manager
+		m.state = initialized
+		return nil
+	}
 	if err := m.Map.Init(manager); err != nil {
 		return err
 	}
@@ -139,52 +480,464 @@ func (m *PerfMap) Start() error {
 	}
 
 	// Create and start the perf map
-	var err error
-	opt := perf.ReaderOptions{
-		Watermark: m.Watermark,
+	if m.Watermark != 0 && m.WakeupEvents != 0 {
+		return fmt.Errorf("error:%w , perf map %s", ErrWatermarkConflict, m.Name)
 	}
-	if m.perfReader, err = perf.NewReaderWithOptions(m.array, m.PerfRingBufferSize, opt, perf.ExtraPerfOptions{}); err != nil {
-		return err
+	if m.ExternalPolling && !m.TestMode && m.array.Type() == ebpf.RingBuf {
+		return fmt.Errorf("error:%w , perf map %s is defined on a BPF ring buffer and can't be polled externally", ErrFDUnavailable, m.Name)
 	}
+	// the perf ring buffers are opened by the first perf map of the perf event array
+	m.perfReader, m.pooled = nil, false
+	if !m.TestMode && (m.manager == nil || m.manager.joinPerfMap(m)) {
+		if err := m.openReader(); err != nil {
+			if m.manager != nil {
+				m.manager.leavePerfMap(m)
+			}
+			return err
+		}
+	}
+	atomic.StoreInt32(&m.dispatchPaused, 0)
 
-	// Start listening for data
-	go func() {
-		var record perf.Record
-		var err error
+	// Set up the batches if requested
+	if m.BatchDataHandler != nil {
+		m.batch = newSampleBatcher(m.BatchSize, m.BatchFlushInterval, func(CPU int, samples [][]byte) {
+			m.manager.fenced(func() {
+				m.manager.runHandler(&m.events, m.Name, "perf_map", CPU, len(samples), func() {
+					m.BatchDataHandler(CPU, samples, m, m.manager)
+				})
+			})
+		})
+		m.batchStop, m.batchDone = make(chan struct{}), make(chan struct{})
+		m.manager.wg.Add(1)
+		go m.flushBatches(m.batch, m.batchStop, m.batchDone)
+	}
+
+	// Set up the coalescer if requested
+	if m.CoalesceWindow > 0 {
+		m.coalescer = newSampleCoalescer(m.CoalesceWindow, m.CoalesceMaxKeys, m.CoalesceKey, m.deliverCoalesced)
+		m.coalesceStop, m.coalesceDone = make(chan struct{}), make(chan struct{})
+		m.manager.wg.Add(1)
+		go m.flushCoalescer(m.coalescer, m.coalesceStop, m.coalesceDone)
+	}
+
+	// Set up the reorder buffer if requested
+	if m.OrderedDelivery {
+		m.reorder = newReorderBuffer(m.ReorderWindow, m.ReorderBufferSize, m.ReorderLatePolicy, func(_ string, CPU int, data []byte) {
+			m.deliver(CPU, data)
+		}, func() {
+			if m.PerfMapStats != nil {
+				m.PerfMapStats.countReorderDrop()
+			}
+		})
+		m.reorderStop, m.reorderDone = make(chan struct{}), make(chan struct{})
 		m.manager.wg.Add(1)
-		for {
-			record, err = m.perfReader.Read()
-			if err != nil {
-				if errors.Is(err, perf.ErrClosed) {
-					m.manager.wg.Done()
-					return
-				}
-				if m.PerfMapStats != nil {
-					m.PerfMapStats.ReadErrors++
-				}
-				if m.PerfErrChan != nil {
-					m.PerfErrChan <- err
-				}
-				continue
+		go m.flushReorderBuffer(m.reorder, m.reorderStop, m.reorderDone)
+	}
+
+	// Start listening for data
+	if !m.TestMode {
+		m.startReading()
+	}
+
+	m.events.start()
+	m.state = running
+	return nil
+}
+
+// openReader - Opens the perf ring buffers of the perf map, registered in the reader pool of the manager if it has one
+func (m *PerfMap) openReader() error {
+	m.pooled = m.usePool()
+	reader, err := m.newRecordReader()
+	if err != nil {
+		return err
+	}
+	if m.pooled {
+		m.activity.beginRead()
+		source, err := m.manager.readerPool.add(reader.(*perCPURecordReader).epollFD, m.pooledFD, m.readPooled)
+		if err != nil {
+			_ = reader.Close()
+			return err
+		}
+		m.poolSource = source
+	}
+	m.perfReader = reader
+	return nil
+}
+
+// startReading - Starts the goroutine reading the perf ring buffers, unless they are read by the reader pool, by the
+// caller (ExternalPolling) or by another perf map sharing the perf event array
+func (m *PerfMap) startReading() {
+	if m.perfReader == nil || m.ExternalPolling || m.pooled {
+		return
+	}
+	m.manager.wg.Add(1)
+	go m.read()
+}
+
+// takeOver - Opens the perf ring buffers of the perf map once the perf map that was reading them for all the perf maps
+// sharing their perf event array was stopped
+func (m *PerfMap) takeOver() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.state < paused || m.perfReader != nil {
+		return nil
+	}
+	if err := m.openReader(); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , perf map %s couldn't open the perf ring buffers of the shared perf event array", err, m.Name))
+	}
+	m.startReading()
+	return nil
+}
+
+// sameReaderOptions - Returns true if the provided perf map opens and reads the perf ring buffers with the same options
+// as this perf map
+func (m *PerfMap) sameReaderOptions(other *PerfMap) bool {
+	if m.PerfRingBufferSize != other.PerfRingBufferSize ||
+		m.OnlineCPUsOnly != other.OnlineCPUsOnly ||
+		m.Watermark != other.Watermark ||
+		m.WakeupEvents != other.WakeupEvents ||
+		m.FlushOnDrain != other.FlushOnDrain ||
+		m.Overwritable != other.Overwritable ||
+		m.ExternalPolling != other.ExternalPolling ||
+		m.PollTimeout != other.PollTimeout ||
+		m.AutoResizeLostThreshold != other.AutoResizeLostThreshold ||
+		m.AutoResizeWindow != other.AutoResizeWindow ||
+		m.AutoResizeMaxSize != other.AutoResizeMaxSize ||
+		m.ResyncLostThreshold != other.ResyncLostThreshold ||
+		m.ResyncWindow != other.ResyncWindow ||
+		len(m.PerfRingBufferSizePerCPU) != len(other.PerfRingBufferSizePerCPU) ||
+		len(m.CPUs) != len(other.CPUs) {
+		return false
+	}
+	for cpu, size := range m.PerfRingBufferSizePerCPU {
+		if otherSize, ok := other.PerfRingBufferSizePerCPU[cpu]; !ok || otherSize != size {
+			return false
+		}
+	}
+	for i, cpu := range m.CPUs {
+		if other.CPUs[i] != cpu {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecordReader - Opens the perf ring buffers of the perf map, with their current sizes
+func (m *PerfMap) newRecordReader() (recordReader, error) {
+	if m.array.Type() == ebpf.RingBuf {
+		// a perf map defined on a BPF ring buffer is transparently read with a ring buffer reader
+		return newRingbufRecordReader(m.array)
+	}
+	if m.usePerCPUReader() {
+		return m.newPerCPUReader()
+	}
+	opt := perf.ReaderOptions{
+		Watermark:    m.Watermark,
+		WakeupEvents: m.WakeupEvents,
+		Overwritable: m.Overwritable,
+	}
+	return perf.NewReaderWithOptions(m.array, m.PerfRingBufferSize, opt, perf.ExtraPerfOptions{})
+}
+
+// read - Reads the perf ring buffer until the reader is closed or a fatal error occurs
+func (m *PerfMap) read() {
+	defer m.manager.wg.Done()
+	var record perf.Record
+	var err error
+	for {
+		m.activity.beginRead()
+		if m.PollTimeout > 0 {
+			m.perfReader.SetDeadline(time.Now().Add(m.PollTimeout))
+		}
+		record, err = m.perfReader.Read()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if m.OnIdle != nil {
+				m.OnIdle(m, m.manager)
 			}
-			if record.LostSamples > 0 {
-				if m.PerfMapStats != nil {
-					m.PerfMapStats.LostSamples[record.CPU] += record.LostSamples
-				}
-				if m.LostHandler != nil {
-					m.LostHandler(record.CPU, record.LostSamples, m, m.manager)
-				}
-				continue
+			continue
+		}
+		m.activity.endRead()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
 			}
 			if m.PerfMapStats != nil {
-				m.PerfMapStats.RawSamples[record.CPU] += uint64(len(record.RawSample))
+				m.PerfMapStats.countReadError()
+			}
+			if isFatalReadError(err) {
+				m.manager.dispatchFailure(nil, &PerfReadError{Map: m.Name, Fatal: true, Err: err})
+				return
 			}
-			m.DataHandler(record.CPU, record.RawSample, m, m.manager)
+			m.manager.reportError(&PerfReadError{Map: m.Name, Err: err})
+			if m.PerfErrChan != nil {
+				m.PerfErrChan <- err
+			}
+			continue
 		}
-	}()
+		m.processRecord(record)
+	}
+}
 
-	m.state = running
-	return nil
+// processRecord - Dispatches the provided record, then resizes the perf ring buffers or resyncs the perf map if too
+// many samples were lost
+func (m *PerfMap) processRecord(record perf.Record) {
+	m.handleRecord(record)
+	if err := m.autoResize(record); err != nil {
+		m.manager.reportError(&MapUpdateError{Map: m.Name, Err: err})
+		if m.PerfErrChan != nil {
+			m.PerfErrChan <- err
+		}
+	}
+	if err := m.resync(record); err != nil {
+		m.manager.reportError(err)
+		if m.PerfErrChan != nil {
+			m.PerfErrChan <- err
+		}
+	}
+}
+
+// handleRecord - Dispatches the provided record to the perf map, and to the other perf maps sharing its perf event array
+func (m *PerfMap) handleRecord(record perf.Record) {
+	if atomic.LoadInt32(&m.dispatchPaused) == 0 {
+		m.handleOwnRecord(record)
+	}
+	if m.manager == nil || m.TestMode {
+		return
+	}
+	followers, _ := m.followers.Load().([]*PerfMap)
+	for _, shared := range followers {
+		if atomic.LoadInt32(&shared.dispatchPaused) == 0 {
+			shared.handleOwnRecord(record)
+		}
+	}
+}
+
+// handleOwnRecord - Updates the statistics of the perf map and dispatches the provided record to the right handler
+func (m *PerfMap) handleOwnRecord(record perf.Record) {
+	if m.RecordSink != nil {
+		m.record(record)
+	}
+	if record.LostSamples > 0 {
+		atomic.AddUint64(&m.events.kernelDrops, record.LostSamples)
+		if m.PerfMapStats != nil {
+			m.PerfMapStats.countRecord(record)
+		}
+		if m.LostHandler != nil {
+			m.LostHandler(record.CPU, record.LostSamples, m, m.manager)
+		}
+		return
+	}
+	m.events.receive(len(record.RawSample))
+	if m.PerfMapStats != nil {
+		m.PerfMapStats.countRecord(record)
+	}
+	if m.recordOnly() {
+		return
+	}
+	m.handleSample(record.CPU, record.RawSample)
+}
+
+// recordOnly - Returns true if the perf map doesn't have any handler, its samples are only written to RecordSink
+func (m *PerfMap) recordOnly() bool {
+	return m.DataHandler == nil && m.BatchDataHandler == nil && m.EventHandler == nil && m.CoalescedDataHandler == nil && !m.OrderedStream
+}
+
+// record - Writes the provided record to the RecordSink of the perf map
+func (m *PerfMap) record(record perf.Record) {
+	if err := writeRecord(m.RecordSink, record.CPU, record.RawSample, record.LostSamples); err != nil {
+		err = fmt.Errorf("error:%w , perf map %s: %v", ErrRecordSink, m.Name, err)
+		m.manager.reportError(err)
+		if m.PerfErrChan != nil {
+			m.PerfErrChan <- err
+		}
+	}
+}
+
+// InjectSample - (TestMode) Feeds a synthetic sample through the dispatch path of the perf map, as if it was read
+// from the perf ring buffer of the provided CPU.
+func (m *PerfMap) InjectSample(CPU int, data []byte) error {
+	return m.inject(perf.Record{CPU: CPU, RawSample: data})
+}
+
+// InjectLostSamples - (TestMode) Reports synthetic lost samples through the dispatch path of the perf map, as if the
+// kernel dropped count samples on the provided CPU.
+func (m *PerfMap) InjectLostSamples(CPU int, count uint64) error {
+	return m.inject(perf.Record{CPU: CPU, LostSamples: count})
+}
+
+// inject - (TestMode) dispatches the provided record
+func (m *PerfMap) inject(record perf.Record) error {
+	if !m.TestMode {
+		return fmt.Errorf("error:%w , perf map %s", ErrNotTestMode, m.Name)
+	}
+	m.stateLock.RLock()
+	if m.state != running {
+		m.stateLock.RUnlock()
+		return ErrMapNotRunning
+	}
+	m.handleRecord(record)
+	m.stateLock.RUnlock()
+	if err := m.autoResize(record); err != nil {
+		return err
+	}
+	return m.resync(record)
+}
+
+// isFatalReadError - Returns true if the provided read error means that the reader can't be used anymore
+func isFatalReadError(err error) bool {
+	return errors.Is(err, unix.EBADF) || errors.Is(err, unix.EINVAL)
+}
+
+// handleSample - Dispatches a sample retrieved from the perf ring buffer
+func (m *PerfMap) handleSample(CPU int, data []byte) {
+	if m.KeepRecentSamples > 0 {
+		m.keepRecentSample(data)
+	}
+	if m.OrderedStream {
+		m.manager.orderedStream.pushFrom(m.Name, m.sampleTimestamp(CPU, data), CPU, data)
+		return
+	}
+	if m.reorder != nil {
+		m.reorder.push(m.sampleTimestamp(CPU, data), CPU, data)
+		return
+	}
+	m.deliver(CPU, data)
+}
+
+// sampleTimestamp - Returns the timestamp of the provided sample, see SampleTimestamp and TimestampOffset
+func (m *PerfMap) sampleTimestamp(CPU int, data []byte) uint64 {
+	if m.SampleTimestamp != nil {
+		return m.SampleTimestamp(CPU, data)
+	}
+	return sampleTimestampAt(data, m.TimestampOffset)
+}
+
+// deliver - Hands the provided sample over to the coalescer of the perf map, if any, or to its data handler
+func (m *PerfMap) deliver(CPU int, data []byte) {
+	if m.coalescer != nil {
+		m.coalescer.push(CPU, data)
+		return
+	}
+	m.dispatch(CPU, data)
+}
+
+// dispatch - Hands the provided sample over to the data handler of the perf map
+func (m *PerfMap) dispatch(CPU int, data []byte) {
+	if m.batch != nil {
+		m.batch.push(CPU, data)
+		return
+	}
+	m.manager.dispatchEvent(func() {
+		m.handleData(CPU, data)
+	}, m.countUserspaceDrop)
+}
+
+// countUserspaceDrop - Counts a sample dropped because the event workers were saturated
+func (m *PerfMap) countUserspaceDrop() {
+	atomic.AddUint64(&m.events.userspaceDrops, 1)
+	if m.PerfMapStats != nil {
+		atomic.AddUint64(&m.PerfMapStats.UserspaceDrops, 1)
+	}
+}
+
+// handleData - Calls the event handler of the perf map with the decoded sample, or its data handler with the raw
+// sample
+func (m *PerfMap) handleData(CPU int, data []byte) {
+	if m.EventHandler == nil {
+		m.manager.runHandler(&m.events, m.Name, "perf_map", CPU, 1, func() {
+			m.DataHandler(CPU, data, m, m.manager)
+		})
+		return
+	}
+	event, err := m.Decoder.Decode(data)
+	if err != nil {
+		atomic.AddUint64(&m.events.decodeErrors, 1)
+		if m.PerfMapStats != nil {
+			atomic.AddUint64(&m.PerfMapStats.DecodeErrors, 1)
+		}
+		if m.PerfErrChan != nil {
+			m.PerfErrChan <- err
+		}
+		return
+	}
+	m.manager.runHandler(&m.events, m.Name, "perf_map", CPU, 1, func() {
+		m.EventHandler(CPU, event, m, m.manager)
+	})
+}
+
+// keepRecentSample - Copies the provided sample in the ring of recent samples
+func (m *PerfMap) keepRecentSample(data []byte) {
+	sample := make([]byte, len(data))
+	copy(sample, data)
+
+	m.recentSamplesLock.Lock()
+	defer m.recentSamplesLock.Unlock()
+	if len(m.recentSamples) < m.KeepRecentSamples {
+		m.recentSamples = append(m.recentSamples, sample)
+		return
+	}
+	m.recentSamples[m.recentSamplesNext] = sample
+	m.recentSamplesNext = (m.recentSamplesNext + 1) % len(m.recentSamples)
+}
+
+// RecentSamples - Returns a copy of the recent samples kept by the perf map, from the oldest to the most recent one.
+// See KeepRecentSamples.
+func (m *PerfMap) RecentSamples() [][]byte {
+	m.recentSamplesLock.Lock()
+	defer m.recentSamplesLock.Unlock()
+	samples := make([][]byte, 0, len(m.recentSamples))
+	for i := range m.recentSamples {
+		samples = append(samples, m.recentSamples[(m.recentSamplesNext+i)%len(m.recentSamples)])
+	}
+	return samples
+}
+
+// dumpRecentSamples - Default DumpHandler of the perf maps that keep recent samples: hex dump of the recent samples
+func dumpRecentSamples(perfMap *PerfMap, _ *Manager) string {
+	var output strings.Builder
+	samples := perfMap.RecentSamples()
+	output.WriteString(fmt.Sprintf("perf map %s: %d recent samples\n", perfMap.Name, len(samples)))
+	for i, sample := range samples {
+		output.WriteString(fmt.Sprintf("sample #%d (%d bytes):\n", i, len(sample)))
+		output.WriteString(hex.Dump(sample))
+	}
+	return output.String()
+}
+
+// flushReorderBuffer - Delivers the samples held in the reorder buffer when the perf ring buffer is idle, and all of
+// them once the perf map is stopped. done is closed once the last samples were handed over to the next stages.
+func (m *PerfMap) flushReorderBuffer(reorder *reorderBuffer, stop chan struct{}, done chan struct{}) {
+	defer m.manager.wg.Done()
+	defer close(done)
+	ticker := time.NewTicker(reorder.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			reorder.flushIdle(now)
+		case <-stop:
+			reorder.flushAll()
+			return
+		}
+	}
+}
+
+// flushBatches - Periodically delivers the pending batches, until the perf map is stopped. done is closed once the
+// last batches were delivered.
+func (m *PerfMap) flushBatches(batch *sampleBatcher, stop chan struct{}, done chan struct{}) {
+	defer m.manager.wg.Done()
+	defer close(done)
+	ticker := time.NewTicker(batch.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			batch.flushAll()
+		case <-stop:
+			batch.flushAll()
+			return
+		}
+	}
 }
 
 // Stop - Stops the perf ring buffer
@@ -196,9 +949,32 @@ func (m *PerfMap) Stop(cleanup MapCleanupType) error {
 	}
 
 	// close perf reader
-	err := m.perfReader.Close()
+	var err error
+	if m.poolSource != nil {
+		m.poolSource.remove()
+		m.poolSource = nil
+	}
+	if m.perfReader != nil {
+		err = m.perfReader.Close()
+	}
 
-	// close underlying map
+	// tear the stages down in the order of the samples: the samples flushed by a stage are handed over to the next
+	// ones, which must still be running
+	m.stopStage(&m.reorderStop, &m.reorderDone)
+	m.stopStage(&m.coalesceStop, &m.coalesceDone)
+	m.stopStage(&m.batchStop, &m.batchDone)
+
+	// close underlying map, unless it is still shared with other perf maps
+	if !m.TestMode && m.manager != nil {
+		next, last := m.manager.leavePerfMap(m)
+		if next != nil {
+			err = ConcatErrors(err, next.takeOver())
+		}
+		if !last {
+			m.Map.reset()
+			return err
+		}
+	}
 	if errTmp := m.Map.close(cleanup); errTmp != nil {
 		if err == nil {
 			err = errTmp
@@ -209,6 +985,17 @@ func (m *PerfMap) Stop(cleanup MapCleanupType) error {
 	return err
 }
 
+// stopStage - Stops the goroutine of a stage of the perf map (reorder buffer, coalescer or batcher), and waits until
+// it delivered its pending samples
+func (m *PerfMap) stopStage(stop *chan struct{}, done *chan struct{}) {
+	if *stop == nil {
+		return
+	}
+	close(*stop)
+	<-*done
+	*stop, *done = nil, nil
+}
+
 // Pause - Pauses a perf ring buffer reader
 func (m *PerfMap) Pause() error {
 	m.stateLock.RLock()
@@ -216,8 +1003,13 @@ func (m *PerfMap) Pause() error {
 	if m.state < running {
 		return ErrMapNotRunning
 	}
-	if err := m.perfReader.Pause(); err != nil {
-		return err
+	// the perf ring buffers of a shared perf event array are still read for the other perf maps
+	if m.manager != nil && !m.TestMode && m.manager.isSharedPerfMap(m) {
+		atomic.StoreInt32(&m.dispatchPaused, 1)
+	} else if m.perfReader != nil {
+		if err := m.perfReader.Pause(); err != nil {
+			return err
+		}
 	}
 	m.state = paused
 	return nil
@@ -228,8 +1020,11 @@ func (m *PerfMap) Resume() error {
 	if m.state < paused {
 		return ErrMapNotRunning
 	}
-	if err := m.perfReader.Resume(); err != nil {
-		return err
+	atomic.StoreInt32(&m.dispatchPaused, 0)
+	if m.perfReader != nil {
+		if err := m.perfReader.Resume(); err != nil {
+			return err
+		}
 	}
 	m.state = running
 	return nil
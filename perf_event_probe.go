@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// perfEventAttachment - (perf_event) Program attached to one sampling perf event per CPU
+type perfEventAttachment struct {
+	fds   []int
+	links []*link.RawLink
+}
+
+// Close - Detaches the program and closes the perf events
+func (a *perfEventAttachment) Close() error {
+	var err error
+	for _, l := range a.links {
+		err = ConcatErrors(err, l.Close())
+	}
+	for _, fd := range a.fds {
+		err = ConcatErrors(err, unix.Close(fd))
+	}
+	return err
+}
+
+// isSamplingPerfEvent - Returns true if the probe opens its own sampling perf events, see SampleFrequency,
+// SamplePeriod, SampleRate and MaxEventsPerSec
+func (p *Probe) isSamplingPerfEvent() bool {
+	return p.SampleFrequency != 0 || p.SamplePeriod != 0 || p.isThrottled()
+}
+
+// perfEventCPUs - Returns the CPUs on which the perf events of the probe are opened
+func (p *Probe) perfEventCPUs() ([]int, error) {
+	if len(p.PerfEventCPUs) == 0 {
+		return OnlineCPUs()
+	}
+	return p.PerfEventCPUs, nil
+}
+
+// attachSamplingPerfEvent - Opens a sampling perf event of PerfEventType and PerfEventConfig on each CPU of the probe,
+// and attaches the program of the probe to them. The program runs on every sample, in the context of the interrupted
+// task.
+func (p *Probe) attachSamplingPerfEvent() error {
+	sample, freq, err := p.perfEventSample()
+	if err != nil {
+		return err
+	}
+	cpus, err := p.perfEventCPUs()
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't list the online CPUs", err))
+	}
+
+	attr := unix.PerfEventAttr{
+		Type:   p.PerfEventType,
+		Size:   uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+		Config: p.PerfEventConfig,
+		Sample: sample,
+		Bits:   unix.PerfBitDisabled,
+	}
+	if freq {
+		attr.Bits |= unix.PerfBitFreq
+	}
+
+	attachment := &perfEventAttachment{}
+	for _, cpu := range cpus {
+		fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			_ = attachment.Close()
+			return errors.New(fmt.Sprintf("error:%v , couldn't open perf event %d/%d on CPU %d for %s", err, p.PerfEventType, p.PerfEventConfig, cpu, p.EbpfFuncName))
+		}
+		attachment.fds = append(attachment.fds, fd)
+		if err = attachment.attach(fd, p.program); err != nil {
+			_ = attachment.Close()
+			return errors.New(fmt.Sprintf("error:%v , couldn't attach %s to the perf event of CPU %d", err, p.EbpfFuncName, cpu))
+		}
+	}
+	p.perfEventAttachment = attachment
+	return nil
+}
+
+// attach - Attaches the program to the perf event with a bpf_link (kernel 5.15+), or with the PERF_EVENT_IOC_SET_BPF
+// ioctl, and enables the perf event
+func (a *perfEventAttachment) attach(fd int, prog *ebpf.Program) error {
+	l, err := link.AttachRawLink(link.RawLinkOptions{Target: fd, Program: prog, Attach: ebpf.AttachPerfEvent})
+	if err == nil {
+		a.links = append(a.links, l)
+	} else if err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, prog.FD()); err != nil {
+		return err
+	}
+	return unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0)
+}
+
+// detachSamplingPerfEvent - Detaches the probe from its sampling perf events
+func (p *Probe) detachSamplingPerfEvent() error {
+	if p.perfEventAttachment == nil {
+		return nil
+	}
+	err := p.perfEventAttachment.Close()
+	p.perfEventAttachment = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+// samplingSpec - Returns a CollectionSpec with a perf_event program that counts its samples
+func samplingSpec() *ebpf.CollectionSpec {
+	return &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			"samples": {Name: "samples", Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+		},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"count_samples": {
+				Name:        "count_samples",
+				Type:        ebpf.PerfEvent,
+				SectionName: "perf_event",
+				License:     "GPL",
+				Instructions: asm.Instructions{
+					asm.StoreImm(asm.RFP, -4, 0, asm.Word),
+					asm.LoadMapPtr(asm.R1, 0).WithReference("samples"),
+					asm.Mov.Reg(asm.R2, asm.RFP),
+					asm.Add.Imm(asm.R2, -4),
+					asm.FnMapLookupElem.Call(),
+					asm.JEq.Imm(asm.R0, 0, "exit"),
+					asm.Mov.Imm(asm.R1, 1),
+					asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+					asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+					asm.Return(),
+				},
+			},
+		},
+	}
+}
+
+func TestSamplingPerfEvent(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{
+		Probes: []*Probe{{
+			Section:         "perf_event",
+			EbpfFuncName:    "count_samples",
+			PerfEventType:   unix.PERF_TYPE_SOFTWARE,
+			PerfEventConfig: unix.PERF_COUNT_SW_CPU_CLOCK,
+			SampleFrequency: 1000,
+		}},
+		Maps: []*Map{{Name: "samples"}},
+	}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: samplingSpec()}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err := m.Start(); err != nil {
+		t.Skipf("sampling perf events not supported: %v", err)
+	}
+
+	// keep the CPUs busy to be sampled
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+	}
+	samples, _, err := m.GetMap("samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count uint64
+	if err = samples.Lookup(uint32(0), &count); err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Error("expected the program to run on the samples of the perf events")
+	}
+
+	if err = m.Probes[0].Detach(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Probes[0].perfEventAttachment != nil {
+		t.Error("expected the perf events to be closed")
+	}
+}
+
+func TestSamplingPerfEventExclusiveOptions(t *testing.T) {
+	p := &Probe{SampleFrequency: 99, SamplePeriod: 1000}
+	if err := p.attachSamplingPerfEvent(); !errors.Is(err, ErrInvalidPerfEvent) {
+		t.Errorf("expected ErrInvalidPerfEvent, got %v", err)
+	}
+}
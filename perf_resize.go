@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+)
+
+const (
+	// DefaultAutoResizeWindow - Default time window over which the lost samples of a PerfMap are counted, see
+	// PerfMapOptions.AutoResizeLostThreshold
+	DefaultAutoResizeWindow = 10 * time.Second
+	// DefaultAutoResizeFactor - Default maximum size of the perf ring buffers of a PerfMap, relative to their initial
+	// size, see PerfMapOptions.AutoResizeMaxSize
+	DefaultAutoResizeFactor = 16
+)
+
+// autoResize - (AutoResizeLostThreshold) Counts the samples lost in the provided record, and grows the perf ring
+// buffers once too many samples were lost within AutoResizeWindow
+func (m *PerfMap) autoResize(record perf.Record) error {
+	if m.AutoResizeLostThreshold == 0 || record.LostSamples == 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(m.lostWindowStart) > m.AutoResizeWindow {
+		m.lostWindowStart = now
+		m.lostInWindow = 0
+	}
+	m.lostInWindow += record.LostSamples
+	if m.lostInWindow <= m.AutoResizeLostThreshold {
+		return nil
+	}
+	m.lostWindowStart = now
+	m.lostInWindow = 0
+	return m.resize()
+}
+
+// resize - Recreates the perf ring buffers with twice their size, up to AutoResizeMaxSize. The new rings replace the
+// previous ones in the perf event array, so the probes keep writing to the perf map. The previous reader is drained
+// then closed, once the lock of the perf map is released since the handlers of the drained records may use it.
+func (m *PerfMap) resize() error {
+	m.stateLock.Lock()
+	if m.state != running || (!m.TestMode && m.array.Type() == ebpf.RingBuf) {
+		m.stateLock.Unlock()
+		return nil
+	}
+	grow := func(size int) int {
+		if size*2 > m.AutoResizeMaxSize {
+			return m.AutoResizeMaxSize
+		}
+		return size * 2
+	}
+	oldSize, oldSizePerCPU := m.PerfRingBufferSize, m.PerfRingBufferSizePerCPU
+	if grow(oldSize) <= oldSize {
+		// the maximum size was reached
+		m.stateLock.Unlock()
+		return nil
+	}
+	m.PerfRingBufferSize = grow(oldSize)
+	if len(oldSizePerCPU) > 0 {
+		m.PerfRingBufferSizePerCPU = make(map[int]int, len(oldSizePerCPU))
+		for cpu, size := range oldSizePerCPU {
+			m.PerfRingBufferSizePerCPU[cpu] = grow(size)
+		}
+	}
+
+	var previous recordReader
+	if !m.TestMode {
+		reader, err := m.newRecordReader()
+		if err != nil {
+			m.PerfRingBufferSize, m.PerfRingBufferSizePerCPU = oldSize, oldSizePerCPU
+			m.stateLock.Unlock()
+			return errors.New(fmt.Sprintf("error:%v , couldn't resize the perf ring buffers of %s to %d bytes", err, m.Name, grow(oldSize)))
+		}
+		previous = m.perfReader
+		m.perfReader = reader
+	}
+	newSize := m.PerfRingBufferSize
+	m.stateLock.Unlock()
+
+	var err error
+	if previous != nil {
+		// the probes now write to the new rings, read what is left in the previous ones
+		err = m.drainReplacedReader(previous)
+	}
+	if m.ResizeHandler != nil {
+		m.ResizeHandler(oldSize, newSize, m, m.manager)
+	}
+	return err
+}
+
+// drainReplacedReader - Dispatches the records left in the perf ring buffers of a reader replaced by resize, then
+// closes it without removing the new rings from the perf event array
+func (m *PerfMap) drainReplacedReader(previous recordReader) error {
+	if reader, ok := previous.(*perCPURecordReader); ok {
+		// the records below the Watermark or WakeupEvents threshold of the rings are read as well
+		records, err := reader.readAvailable()
+		for _, record := range records {
+			m.handleRecord(record)
+		}
+		return ConcatErrors(err, reader.closeReplaced())
+	}
+	previous.SetDeadline(time.Now())
+	for {
+		record, err := previous.Read()
+		if err != nil {
+			break
+		}
+		m.handleRecord(record)
+	}
+	return previous.Close()
+}
@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf/perf"
+)
+
+// DefaultResyncWindow - Default time window over which the lost samples of a PerfMap are counted, see
+// PerfMapOptions.ResyncLostThreshold
+const DefaultResyncWindow = 10 * time.Second
+
+// resync - (ResyncLostThreshold) Counts the samples lost in the provided record and, once more than
+// ResyncLostThreshold samples were lost within ResyncWindow, pauses the perf map, calls ResyncHandler and resumes the
+// perf map. Called by the reader of the perf map.
+func (m *PerfMap) resync(record perf.Record) error {
+	if m.ResyncLostThreshold == 0 || m.ResyncHandler == nil || record.LostSamples == 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(m.resyncWindowStart) > m.ResyncWindow {
+		m.resyncWindowStart = now
+		m.lostSinceResync = 0
+	}
+	m.lostSinceResync += record.LostSamples
+	if m.lostSinceResync <= m.ResyncLostThreshold {
+		return nil
+	}
+	lost := m.lostSinceResync
+	m.lostSinceResync = 0
+
+	if err := m.Pause(); err != nil {
+		// the perf map is stopping
+		return nil
+	}
+	resyncErr := m.ResyncHandler(lost, m, m.manager)
+	// the window starts once the state was rebuilt, the samples lost in the meantime are expected
+	m.resyncWindowStart = time.Now()
+	if err := m.Resume(); err != nil {
+		return err
+	}
+	if resyncErr != nil {
+		return fmt.Errorf("error:%w , perf map %s: %v", ErrResyncFailed, m.Name, resyncErr)
+	}
+	return nil
+}
@@ -0,0 +1,977 @@
+package manager
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestReorderBufferShuffledTimestamps(t *testing.T) {
+	var delivered []uint64
+	var late int
+	rb := newReorderBuffer(time.Hour, 1000, ReorderDropLate, func(_ string, CPU int, data []byte) {
+		delivered = append(delivered, uint64(data[0]))
+	}, func() {
+		late++
+	})
+
+	timestamps := make([]uint64, 200)
+	for i := range timestamps {
+		timestamps[i] = uint64(i)
+	}
+	rand.New(rand.NewSource(42)).Shuffle(len(timestamps), func(i, j int) {
+		timestamps[i], timestamps[j] = timestamps[j], timestamps[i]
+	})
+	for i, ts := range timestamps {
+		rb.push(ts, i%4, []byte{byte(ts)})
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("expected samples to be held for the reorder window, got %d delivered samples", len(delivered))
+	}
+	rb.flushAll()
+
+	if len(delivered) != len(timestamps) || late != 0 {
+		t.Fatalf("expected %d delivered samples and no late sample, got %d and %d", len(timestamps), len(delivered), late)
+	}
+	if !sort.SliceIsSorted(delivered, func(i, j int) bool { return delivered[i] < delivered[j] }) {
+		t.Errorf("samples weren't delivered in timestamp order: %v", delivered)
+	}
+}
+
+func TestReorderBufferWindowAndLateSamples(t *testing.T) {
+	window := 10 * time.Millisecond
+	var delivered []uint64
+	var late int
+	rb := newReorderBuffer(window, 1000, ReorderDropLate, func(_ string, CPU int, data []byte) {
+		delivered = append(delivered, uint64(data[0]))
+	}, func() {
+		late++
+	})
+
+	// timestamps are in nanoseconds, push samples spanning more than one window
+	ms := uint64(time.Millisecond)
+	for _, ts := range []uint64{3, 1, 2, 15, 12} {
+		rb.push(ts*ms, 0, []byte{byte(ts)})
+	}
+	// 1, 2 and 3 are older than the youngest sample minus the window
+	if expected := []uint64{1, 2, 3}; !equalUint64(delivered, expected) {
+		t.Fatalf("expected %v to be delivered, got %v", expected, delivered)
+	}
+
+	// 2 is older than the already delivered watermark, it should be dropped
+	rb.push(2*ms, 0, []byte{2})
+	if late != 1 {
+		t.Errorf("expected 1 late sample, got %d", late)
+	}
+
+	// the buffer is idle for a whole window, everything should be flushed
+	rb.flushIdle(time.Now().Add(window))
+	if expected := []uint64{1, 2, 3, 12, 15}; !equalUint64(delivered, expected) {
+		t.Errorf("expected %v to be delivered, got %v", expected, delivered)
+	}
+}
+
+func TestReorderBufferSize(t *testing.T) {
+	var delivered []uint64
+	rb := newReorderBuffer(time.Hour, 2, ReorderDropLate, func(_ string, CPU int, data []byte) {
+		delivered = append(delivered, uint64(data[0]))
+	}, nil)
+	for _, ts := range []uint64{5, 3, 4, 6} {
+		rb.push(ts, 0, []byte{byte(ts)})
+	}
+	// the buffer can only hold 2 samples, the oldest ones are delivered early
+	if expected := []uint64{3, 4}; !equalUint64(delivered, expected) {
+		t.Errorf("expected %v to be delivered, got %v", expected, delivered)
+	}
+}
+
+func equalUint64(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeRecordReader - recordReader returning a predefined list of records and errors
+type fakeRecordReader struct {
+	records   []perf.Record
+	errs      []error
+	deadlines int
+}
+
+func (r *fakeRecordReader) Read() (perf.Record, error) {
+	if len(r.records) == 0 {
+		return perf.Record{}, perf.ErrClosed
+	}
+	record, err := r.records[0], r.errs[0]
+	r.records, r.errs = r.records[1:], r.errs[1:]
+	return record, err
+}
+
+func (r *fakeRecordReader) Pause() error  { return nil }
+func (r *fakeRecordReader) Resume() error { return nil }
+func (r *fakeRecordReader) Close() error  { return nil }
+
+func (r *fakeRecordReader) SetDeadline(t time.Time) {
+	if !t.IsZero() {
+		r.deadlines++
+	}
+}
+
+func TestPerfMapFatalReadError(t *testing.T) {
+	failures := make(chan error, 2)
+	m := &Manager{
+		wg: &sync.WaitGroup{},
+		options: Options{
+			ErrorChan: make(chan error, 1),
+			ProbeFailureHandler: func(probe *Probe, err error) {
+				if probe != nil {
+					t.Errorf("expected no probe for a perf ring reader failure, got %v", probe.GetIdentificationPair())
+				}
+				failures <- err
+			},
+		},
+	}
+	var samples int
+	perfMap := &PerfMap{
+		manager: m,
+		perfReader: &fakeRecordReader{
+			records: []perf.Record{{RawSample: []byte{1}}, {}, {RawSample: []byte{2}}},
+			errs:    []error{nil, unix.EBADF, nil},
+		},
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				samples++
+			},
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+
+	m.wg.Add(1)
+	perfMap.read()
+
+	if samples != 1 {
+		t.Errorf("expected the reader to stop after the fatal error, got %d samples", samples)
+	}
+	select {
+	case err := <-failures:
+		if !errors.Is(err, unix.EBADF) || !strings.Contains(err.Error(), perfMap.Name) {
+			t.Errorf("expected a failure naming %s, got %v", perfMap.Name, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the failure handler to fire")
+	}
+	select {
+	case err := <-failures:
+		t.Errorf("expected the failure handler to fire once, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if perfMap.PerfMapStats.ReadErrors != 1 {
+		t.Errorf("expected 1 read error, got %d", perfMap.PerfMapStats.ReadErrors)
+	}
+	var readErr *PerfReadError
+	if err := <-m.options.ErrorChan; !errors.As(err, &readErr) || !readErr.Fatal || readErr.Map != perfMap.Name {
+		t.Errorf("expected a fatal PerfReadError on ErrorChan, got %v", err)
+	}
+
+	// the errors that don't fit in ErrorChan are dropped
+	m.reportError(&MapUpdateError{Map: perfMap.Name, Err: unix.EINVAL})
+	m.reportError(&MapUpdateError{Map: perfMap.Name, Err: unix.EINVAL})
+	if m.DroppedErrors() != 1 {
+		t.Errorf("expected 1 dropped error, got %d", m.DroppedErrors())
+	}
+}
+
+func TestPerfMapOnIdle(t *testing.T) {
+	reader := &fakeRecordReader{
+		records: []perf.Record{{}, {RawSample: []byte{1}}, {}, {}},
+		errs:    []error{os.ErrDeadlineExceeded, nil, os.ErrDeadlineExceeded, os.ErrDeadlineExceeded},
+	}
+	var events []string
+	perfMap := &PerfMap{
+		manager:    &Manager{wg: &sync.WaitGroup{}},
+		perfReader: reader,
+		Map:        Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			PollTimeout: time.Second,
+			OnIdle: func(perfMap *PerfMap, manager *Manager) {
+				events = append(events, "idle")
+			},
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				events = append(events, "sample")
+			},
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+
+	perfMap.manager.wg.Add(1)
+	perfMap.read()
+
+	if expected := []string{"idle", "sample", "idle", "idle"}; strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+	if reader.deadlines != 5 {
+		t.Errorf("expected a deadline before each read, got %d", reader.deadlines)
+	}
+	if perfMap.PerfMapStats.ReadErrors != 0 {
+		t.Errorf("expected the timeouts not to be counted as read errors, got %d", perfMap.PerfMapStats.ReadErrors)
+	}
+}
+
+func TestPerfMapWatermarkAndWakeupEvents(t *testing.T) {
+	perfMap := &PerfMap{
+		Map: Map{Name: "events", state: initialized},
+		PerfMapOptions: PerfMapOptions{
+			Watermark:    4096,
+			WakeupEvents: 16,
+		},
+	}
+	if err := perfMap.Start(); !errors.Is(err, ErrWatermarkConflict) {
+		t.Errorf("expected ErrWatermarkConflict, got %v", err)
+	}
+}
+
+func TestPerfMapDeliveryCadence(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var cpuSet unix.CPUSet
+	cpuSet.Set(0)
+	if err := unix.SchedSetaffinity(0, &cpuSet); err != nil {
+		t.Skipf("couldn't run on CPU 0: %v", err)
+	}
+
+	// each sample of newPerfOutputProgram takes 16 bytes in the perf ring buffer: the reader is woken up by the 4th one.
+	// The perf ring buffer is opened by the manager, on CPU 0.
+	for name, options := range map[string]PerfMapOptions{
+		"wakeup_events": {WakeupEvents: 4},
+		"watermark":     {Watermark: 56},
+	} {
+		t.Run(name, func(t *testing.T) {
+			array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples := make(chan []byte, 10)
+			m := &Manager{wg: &sync.WaitGroup{}, collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}}}
+			options.PerfRingBufferSize = os.Getpagesize()
+			options.CPUs = []int{0}
+			options.DataHandler = func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				samples <- data
+			}
+			perfMap := &PerfMap{Map: Map{Name: "events"}, PerfMapOptions: options}
+			if err = perfMap.Init(m); err != nil {
+				t.Fatal(err)
+			}
+			if err = perfMap.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer perfMap.Stop(CleanAll)
+			prog := newPerfOutputProgram(t, array)
+			defer prog.Close()
+
+			if _, _, err = prog.Benchmark(make([]byte, 14), 3, nil); err != nil {
+				t.Skipf("couldn't run the program: %v", err)
+			}
+			select {
+			case <-samples:
+				t.Fatal("expected the samples not to be delivered below the threshold")
+			case <-time.After(100 * time.Millisecond):
+			}
+			if _, _, err = prog.Benchmark(make([]byte, 14), 1, nil); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 4; i++ {
+				select {
+				case <-samples:
+				case <-time.After(time.Second):
+					t.Fatalf("expected 4 samples once the threshold was reached, got %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPerfMapDumpRecentSamples(t *testing.T) {
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			KeepRecentSamples: 2,
+			DataHandler:       func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+		},
+	}
+	for _, sample := range []string{"first_sample", "second_sample", "third_sample"} {
+		perfMap.handleSample(0, []byte(sample))
+	}
+
+	samples := perfMap.RecentSamples()
+	if len(samples) != 2 || string(samples[0]) != "second_sample" || string(samples[1]) != "third_sample" {
+		t.Fatalf("expected the 2 most recent samples, got %q", samples)
+	}
+
+	m := &Manager{
+		collection: &ebpf.Collection{},
+		state:      initialized,
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	dump, err := m.DumpMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range samples {
+		if !strings.Contains(dump, hex.Dump(sample)) {
+			t.Errorf("expected the dump to contain %q, got:\n%s", sample, dump)
+		}
+	}
+	if strings.Contains(dump, hex.Dump([]byte("first_sample"))) {
+		t.Errorf("expected the oldest sample to be evicted, got:\n%s", dump)
+	}
+}
+
+func TestPerfMapTestMode(t *testing.T) {
+	var samples [][]byte
+	var lost uint64
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				samples = append(samples, data)
+			},
+			LostHandler: func(CPU int, count uint64, perfMap *PerfMap, manager *Manager) {
+				lost += count
+			},
+			PerfMapStats: NewPerfMapStats(),
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+
+	if err := perfMap.InjectSample(0, []byte("sample")); !errors.Is(err, ErrMapNotRunning) {
+		t.Errorf("expected ErrMapNotRunning before Start, got %v", err)
+	}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.InjectSample(1, []byte("sample")); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.InjectLostSamples(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || string(samples[0]) != "sample" || lost != 3 {
+		t.Errorf("expected 1 sample and 3 lost samples, got %q and %d", samples, lost)
+	}
+	if perfMap.PerfMapStats.RawSamples[1] != uint64(len("sample")) || perfMap.PerfMapStats.LostSamples[1] != 3 {
+		t.Errorf("the statistics of the perf map weren't updated")
+	}
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+
+	perfMap.TestMode = false
+	if err := perfMap.InjectSample(0, nil); !errors.Is(err, ErrNotTestMode) {
+		t.Errorf("expected ErrNotTestMode, got %v", err)
+	}
+}
+
+func TestSharedPerfEventArray(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	samples := make(chan int, 10)
+	newReader := func(id int) *PerfMap {
+		return &PerfMap{
+			Map: Map{Name: "events"},
+			PerfMapOptions: PerfMapOptions{
+				PerfRingBufferSize: os.Getpagesize(),
+				DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+					samples <- id
+				},
+				PerfMapStats: NewPerfMapStats(),
+			},
+		}
+	}
+	first, second := newReader(1), newReader(2)
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+		PerfMaps:   []*PerfMap{first, second},
+	}
+	if err = m.sanityCheck(); err != nil {
+		t.Fatalf("expected two readers on the same perf event array to be accepted: %v", err)
+	}
+	for _, perfMap := range m.PerfMaps {
+		if err = perfMap.Init(m); err != nil {
+			t.Fatal(err)
+		}
+		if err = perfMap.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if second.perfReader != nil {
+		t.Fatal("expected the perf ring buffers to be opened once")
+	}
+	if shares := m.perfMapShares[array]; len(shares) != 2 {
+		t.Fatalf("expected 2 perf maps on the perf event array, got %d", len(shares))
+	}
+
+	prog := newPerfOutputProgram(t, array)
+	defer prog.Close()
+	expect := func(ids ...int) {
+		t.Helper()
+		if _, _, err := prog.Benchmark(make([]byte, 14), 1, nil); err != nil {
+			t.Skipf("couldn't run the program: %v", err)
+		}
+		seen := make(map[int]bool)
+		for len(seen) < len(ids) {
+			select {
+			case id := <-samples:
+				seen[id] = true
+			case <-time.After(time.Second):
+				t.Fatalf("expected the sample to be delivered to %v, got %v", ids, seen)
+			}
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				t.Fatalf("expected the sample to be delivered to %v, got %v", ids, seen)
+			}
+		}
+		select {
+		case id := <-samples:
+			t.Fatalf("unexpected sample delivered to %d", id)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	// each sample is delivered to both perf maps
+	expect(1, 2)
+
+	// a paused perf map doesn't stop the delivery to the other one
+	if err = first.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	expect(2)
+	if err = first.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	expect(1, 2)
+
+	// stopping the first reader shouldn't close the shared map, the second one reads the perf ring buffers
+	if err = first.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = array.Info(); err != nil {
+		t.Fatalf("the shared perf event array was closed with the first reader: %v", err)
+	}
+	expect(2)
+
+	if err = second.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.perfMapShares[array]; ok {
+		t.Error("expected the perf event array to be released with the last reader")
+	}
+	if second.array != nil {
+		t.Error("expected the perf event array to be closed with the last reader")
+	}
+}
+
+func TestSharedPerfEventArrayOptions(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	handler := func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {}
+	first := &PerfMap{Map: Map{Name: "events"}, PerfMapOptions: PerfMapOptions{PerfRingBufferSize: os.Getpagesize(), Watermark: 1, DataHandler: handler}}
+	second := &PerfMap{Map: Map{Name: "events"}, PerfMapOptions: PerfMapOptions{PerfRingBufferSize: os.Getpagesize(), Watermark: 2, DataHandler: handler}}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+		PerfMaps:   []*PerfMap{first, second},
+	}
+	if err = first.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.Init(m); !errors.Is(err, ErrSharedPerfMapOptions) {
+		t.Fatalf("expected ErrSharedPerfMapOptions, got %v", err)
+	}
+	second.Watermark = 1
+	if err = second.Init(m); err != nil {
+		t.Fatalf("expected the perf maps with the same reader options to be accepted: %v", err)
+	}
+}
+
+func TestPerfMapBatchDataHandler(t *testing.T) {
+	var lock sync.Mutex
+	batches := map[int][][][]byte{}
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			BatchDataHandler: func(CPU int, samples [][]byte, perfMap *PerfMap, manager *Manager) {
+				lock.Lock()
+				defer lock.Unlock()
+				batches[CPU] = append(batches[CPU], samples)
+			},
+			BatchSize:          3,
+			BatchFlushInterval: time.Hour,
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := perfMap.InjectSample(0, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := perfMap.InjectSample(1, []byte{42}); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	if len(batches[0]) != 1 || len(batches[0][0]) != 3 || len(batches[1]) != 0 {
+		t.Errorf("expected a single full batch on CPU 0, got %v", batches)
+	}
+	lock.Unlock()
+
+	// the pending batches are delivered when the perf map is stopped
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+	if len(batches[0]) != 2 || len(batches[0][1]) != 1 || batches[0][1][0][0] != 3 {
+		t.Errorf("expected the pending batch of CPU 0 to be flushed, got %v", batches[0])
+	}
+	if len(batches[1]) != 1 || batches[1][0][0][0] != 42 {
+		t.Errorf("expected the pending batch of CPU 1 to be flushed, got %v", batches[1])
+	}
+}
+
+func TestOrderedStream(t *testing.T) {
+	type orderedSample struct {
+		source    string
+		timestamp uint64
+	}
+	var delivered []orderedSample
+	m := &Manager{
+		wg: &sync.WaitGroup{},
+		options: Options{
+			OrderedDataHandler: func(source string, CPU int, data []byte, manager *Manager) {
+				offset := 0
+				if source == "b" {
+					offset = 4
+				}
+				delivered = append(delivered, orderedSample{source, sampleTimestampAt(data, offset)})
+			},
+			OrderedStreamWindow: time.Hour,
+		},
+	}
+	newPerfMap := func(name string, offset int) *PerfMap {
+		perfMap := &PerfMap{
+			Map:            Map{Name: name},
+			PerfMapOptions: PerfMapOptions{TestMode: true, OrderedStream: true, TimestampOffset: offset},
+		}
+		if err := perfMap.Init(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := perfMap.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return perfMap
+	}
+	m.startOrderedStream()
+	a, b := newPerfMap("a", 0), newPerfMap("b", 4)
+
+	sample := func(offset int, timestamp uint64) []byte {
+		data := make([]byte, offset+8)
+		nativeEndian.PutUint64(data[offset:], timestamp)
+		return data
+	}
+	for _, timestamp := range []uint64{5, 1, 9} {
+		if err := a.InjectSample(0, sample(0, timestamp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, timestamp := range []uint64{4, 8, 2} {
+		if err := b.InjectSample(1, sample(4, timestamp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.stopOrderedStream()
+	m.wg.Wait()
+
+	expected := []orderedSample{{"a", 1}, {"b", 2}, {"b", 4}, {"a", 5}, {"b", 8}, {"a", 9}}
+	if len(delivered) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, delivered)
+	}
+	for i := range expected {
+		if delivered[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, delivered)
+		}
+	}
+
+	withoutHandler := &PerfMap{Map: Map{Name: "c"}, PerfMapOptions: PerfMapOptions{TestMode: true, OrderedStream: true}}
+	if err := withoutHandler.Init(&Manager{}); !errors.Is(err, ErrNoOrderedDataHandler) {
+		t.Errorf("expected ErrNoOrderedDataHandler, got %v", err)
+	}
+}
+
+func TestPerfMapAutoResize(t *testing.T) {
+	var resizes [][2]int
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:                true,
+			PerfRingBufferSize:      4096,
+			AutoResizeLostThreshold: 10,
+			AutoResizeMaxSize:       3 * 4096,
+			DataHandler:             func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+			ResizeHandler: func(oldSize int, newSize int, perfMap *PerfMap, manager *Manager) {
+				resizes = append(resizes, [2]int{oldSize, newSize})
+			},
+		},
+	}
+	m := &Manager{wg: &sync.WaitGroup{}}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if perfMap.AutoResizeWindow != DefaultAutoResizeWindow {
+		t.Errorf("expected the default window, got %v", perfMap.AutoResizeWindow)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, count := range []uint64{6, 4, 11, 20} {
+		if err := perfMap.InjectLostSamples(0, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 6+4 doesn't exceed the threshold, 11 doubles the size and 20 reaches the maximum size
+	if len(resizes) != 2 || resizes[0] != [2]int{4096, 8192} || resizes[1] != [2]int{8192, 3 * 4096} {
+		t.Errorf("unexpected resizes %v", resizes)
+	}
+	if err := perfMap.InjectLostSamples(0, 20); err != nil || len(resizes) != 2 {
+		t.Errorf("expected no resize past the maximum size, got %v (%v)", resizes, err)
+	}
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfMapResizeReader(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			PerfRingBufferSize:      os.Getpagesize(),
+			AutoResizeLostThreshold: 1,
+			DataHandler:             func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if err = perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	// resize runs on the goroutine of the reader, open the reader without starting this goroutine
+	if perfMap.perfReader, err = perfMap.newRecordReader(); err != nil {
+		t.Fatal(err)
+	}
+	perfMap.state = running
+	previous := perfMap.perfReader
+	if err = perfMap.resize(); err != nil {
+		t.Fatal(err)
+	}
+	if perfMap.perfReader == previous || perfMap.PerfRingBufferSize != 2*os.Getpagesize() {
+		t.Errorf("expected a new reader of %d bytes, got %d bytes", 2*os.Getpagesize(), perfMap.PerfRingBufferSize)
+	}
+	if err = perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfMapResizePerCPUReader(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var cpuSet unix.CPUSet
+	cpuSet.Set(0)
+	if err := unix.SchedSetaffinity(0, &cpuSet); err != nil {
+		t.Skipf("couldn't run on CPU 0: %v", err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.PerfEventArray})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	var handled int
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			PerfRingBufferSize:      os.Getpagesize(),
+			CPUs:                    []int{0},
+			WakeupEvents:            4,
+			AutoResizeLostThreshold: 1,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				// the lock of the perf map isn't held while the records of the previous rings are dispatched
+				perfMap.stateLock.RLock()
+				handled++
+				perfMap.stateLock.RUnlock()
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if err = perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	// resize runs on the goroutine of the reader, open the reader without starting this goroutine
+	if perfMap.perfReader, err = perfMap.newRecordReader(); err != nil {
+		t.Fatal(err)
+	}
+	perfMap.state = running
+	prog := newPerfOutputProgram(t, array)
+	defer prog.Close()
+
+	// the samples below the WakeupEvents threshold of the previous rings are delivered
+	if _, _, err = prog.Benchmark(make([]byte, 14), 3, nil); err != nil {
+		t.Skipf("couldn't run the program: %v", err)
+	}
+	if err = perfMap.resize(); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 3 {
+		t.Errorf("expected the 3 samples of the previous rings to be delivered, got %d", handled)
+	}
+
+	// the new rings are still in the perf event array once the previous reader is closed
+	if _, _, err = prog.Benchmark(make([]byte, 14), 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	records, err := perfMap.perfReader.(*perCPURecordReader).readAvailable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected the 2 samples written after the resize to be in the new rings, got %d", len(records))
+	}
+	if err = perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfMapEventStats(t *testing.T) {
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			Decoder: DecoderFunc(func(data []byte) (interface{}, error) {
+				if len(data) == 0 {
+					return nil, errors.New("empty sample")
+				}
+				return string(data), nil
+			}),
+			EventHandler: func(CPU int, event interface{}, perfMap *PerfMap, manager *Manager) {},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{},
+		state:      initialized,
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range [][]byte{[]byte("first"), []byte("second"), {}} {
+		if err := perfMap.InjectSample(0, sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := perfMap.InjectLostSamples(1, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.GetEventStats()
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("expected the stats of one perf map, got %v (%v)", stats, err)
+	}
+	s := stats[0]
+	if s.Name != "events" || s.Kind != "perf_map" || s.Received != 3 || s.ReceivedBytes != 11 || s.KernelDrops != 4 ||
+		s.DecodeErrors != 1 || s.Handled != 2 || s.HandlerLatency.Count != 2 || s.Lost() != 5 {
+		t.Errorf("unexpected event stats %+v", s)
+	}
+	if len(s.HandlerLatency.Counts) != len(s.HandlerLatency.Buckets)+1 {
+		t.Errorf("unexpected latency histogram %+v", s.HandlerLatency)
+	}
+	dump, err := m.DumpEventStats()
+	if err != nil || !strings.Contains(dump, "events") {
+		t.Errorf("unexpected event stats dump %q (%v)", dump, err)
+	}
+	if err = perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfMapSlowHandler(t *testing.T) {
+	var warnings []SlowHandlerWarning
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode: true,
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				if string(data) == "slow" {
+					time.Sleep(5 * time.Millisecond)
+				}
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{},
+		state:      initialized,
+		PerfMaps:   []*PerfMap{perfMap},
+		options: Options{
+			SlowHandlerThreshold: 2 * time.Millisecond,
+			SlowHandlerCallback: func(warning SlowHandlerWarning) {
+				warnings = append(warnings, warning)
+			},
+			HandlerProfileLabels: true,
+		},
+	}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []string{"fast", "slow", "fast"} {
+		if err := perfMap.InjectSample(3, []byte(sample)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 slow handler warning, got %v", warnings)
+	}
+	if w := warnings[0]; w.Map != "events" || w.Kind != "perf_map" || w.CPU != 3 || w.Samples != 1 || w.Duration < 5*time.Millisecond {
+		t.Errorf("unexpected warning %s", w)
+	}
+	stats, err := m.GetEventStats()
+	if err != nil || stats[0].SlowHandlers != 1 || stats[0].Handled != 3 {
+		t.Errorf("unexpected event stats %+v (%v)", stats, err)
+	}
+	if err = perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfMapResync(t *testing.T) {
+	var resyncs []uint64
+	var states []state
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			TestMode:            true,
+			DataHandler:         func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {},
+			ResyncLostThreshold: 10,
+			ResyncHandler: func(lost uint64, perfMap *PerfMap, manager *Manager) error {
+				resyncs = append(resyncs, lost)
+				states = append(states, perfMap.state)
+				if len(resyncs) > 1 {
+					return errors.New("state map unavailable")
+				}
+				return nil
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{},
+		state:      initialized,
+		PerfMaps:   []*PerfMap{perfMap},
+	}
+	if err := perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if perfMap.ResyncWindow != DefaultResyncWindow {
+		t.Errorf("expected the default resync window, got %s", perfMap.ResyncWindow)
+	}
+	if err := perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, lost := range []uint64{4, 4, 4} {
+		if err := perfMap.InjectLostSamples(0, lost); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(resyncs) != 1 || resyncs[0] != 12 || states[0] != paused {
+		t.Fatalf("expected a resync of 12 lost samples while paused, got %v %v", resyncs, states)
+	}
+	if perfMap.state != running {
+		t.Errorf("expected the perf map to be resumed, got %v", perfMap.state)
+	}
+	if err := perfMap.InjectLostSamples(1, 11); !errors.Is(err, ErrResyncFailed) {
+		t.Errorf("expected ErrResyncFailed, got %v", err)
+	}
+	if err := perfMap.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
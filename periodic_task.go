@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PeriodicTask - Task run periodically while the manager is running, for example to flush an aggregation map or to
+// publish the statistics of the event streams, see Options.PeriodicTasks and Manager.AddPeriodicTask. The tasks start
+// with Start and stop with Stop, before the probes are detached and the maps are closed.
+type PeriodicTask struct {
+	// Name - Name of the task, reported in its errors
+	Name string
+
+	// Interval - Interval between two runs of the task. The first run happens one Interval after the manager started.
+	Interval time.Duration
+
+	// Run - Runs the task. The context is cancelled when the manager stops. The errors are reported on
+	// Options.ErrorChan as *PeriodicTaskError. Run must not start nor stop the manager.
+	Run func(ctx context.Context, manager *Manager) error
+
+	// RunOnStop - Runs the task one last time when the manager stops, while the maps are still open, so that the data
+	// aggregated since the last run isn't lost
+	RunOnStop bool
+}
+
+// PeriodicTaskError - Reported on Options.ErrorChan when a PeriodicTask fails
+type PeriodicTaskError struct {
+	// Task - Name of the task
+	Task string
+
+	// Err - Error returned by the task
+	Err error
+}
+
+func (e *PeriodicTaskError) Error() string {
+	return fmt.Sprintf("periodic task %s failed: %v", e.Task, e.Err)
+}
+
+// Unwrap - Returns the error returned by the task
+func (e *PeriodicTaskError) Unwrap() error {
+	return e.Err
+}
+
+// validate - Checks the task
+func (t PeriodicTask) validate() error {
+	if t.Interval <= 0 || t.Run == nil {
+		return fmt.Errorf("error:%w , periodic task %s needs an interval and a Run function", ErrInvalidPeriodicTask, t.Name)
+	}
+	return nil
+}
+
+// run - Runs the task and reports its error
+func (t PeriodicTask) run(ctx context.Context, m *Manager) {
+	if err := t.Run(ctx, m); err != nil {
+		m.reportError(&PeriodicTaskError{Task: t.Name, Err: err})
+	}
+}
+
+// setPeriodicTasks - Checks and registers the provided tasks, replacing the tasks of a previous Init
+func (m *Manager) setPeriodicTasks(tasks []PeriodicTask) error {
+	for _, task := range tasks {
+		if err := task.validate(); err != nil {
+			return err
+		}
+	}
+	m.tasksLock.Lock()
+	defer m.tasksLock.Unlock()
+	m.tasks = append([]PeriodicTask(nil), tasks...)
+	return nil
+}
+
+// AddPeriodicTask - Registers a task run every task.Interval while the manager is running, in addition to
+// Options.PeriodicTasks. The task starts right away if the manager is running, with the next Start otherwise. Tasks
+// added before Init are replaced by Options.PeriodicTasks.
+func (m *Manager) AddPeriodicTask(task PeriodicTask) error {
+	if err := task.validate(); err != nil {
+		return err
+	}
+	m.tasksLock.Lock()
+	defer m.tasksLock.Unlock()
+	m.tasks = append(m.tasks, task)
+	if m.tasksCancel != nil {
+		m.startPeriodicTask(task)
+	}
+	return nil
+}
+
+// startPeriodicTasks - Starts the periodic tasks of the manager
+func (m *Manager) startPeriodicTasks() {
+	m.tasksLock.Lock()
+	defer m.tasksLock.Unlock()
+	if m.tasksCancel != nil {
+		return
+	}
+	m.tasksCtx, m.tasksCancel = context.WithCancel(context.Background())
+	for _, task := range m.tasks {
+		m.startPeriodicTask(task)
+	}
+}
+
+// startPeriodicTask - Starts the goroutine of the provided task, the caller must hold tasksLock
+func (m *Manager) startPeriodicTask(task PeriodicTask) {
+	ctx := m.tasksCtx
+	m.tasksGroup.Add(1)
+	go func() {
+		defer m.tasksGroup.Done()
+		ticker := time.NewTicker(task.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			task.run(ctx, m)
+		}
+	}()
+}
+
+// stopPeriodicTasks - Stops the periodic tasks of the manager, waits until the runs in progress are done, then runs
+// the tasks that enable RunOnStop a last time
+func (m *Manager) stopPeriodicTasks() {
+	m.tasksLock.Lock()
+	defer m.tasksLock.Unlock()
+	if m.tasksCancel == nil {
+		return
+	}
+	m.tasksCancel()
+	m.tasksCancel = nil
+	m.tasksGroup.Wait()
+	for _, task := range m.tasks {
+		if task.RunOnStop {
+			task.run(context.Background(), m)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeriodicTasks(t *testing.T) {
+	var flushes, publishes, added int32
+	m := &Manager{options: Options{ErrorChan: make(chan error, 100)}}
+	err := m.setPeriodicTasks([]PeriodicTask{
+		{
+			Name:     "flush",
+			Interval: 5 * time.Millisecond,
+			Run: func(ctx context.Context, manager *Manager) error {
+				atomic.AddInt32(&flushes, 1)
+				return nil
+			},
+			RunOnStop: true,
+		},
+		{
+			Name:     "publish",
+			Interval: 5 * time.Millisecond,
+			Run: func(ctx context.Context, manager *Manager) error {
+				atomic.AddInt32(&publishes, 1)
+				return errors.New("collector unavailable")
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.AddPeriodicTask(PeriodicTask{Name: "invalid"}); !errors.Is(err, ErrInvalidPeriodicTask) {
+		t.Errorf("expected ErrInvalidPeriodicTask, got %v", err)
+	}
+
+	m.startPeriodicTasks()
+	err = m.AddPeriodicTask(PeriodicTask{
+		Name:     "added",
+		Interval: 5 * time.Millisecond,
+		Run: func(ctx context.Context, manager *Manager) error {
+			atomic.AddInt32(&added, 1)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	m.stopPeriodicTasks()
+
+	running := atomic.LoadInt32(&flushes)
+	if running < 2 || atomic.LoadInt32(&publishes) < 2 || atomic.LoadInt32(&added) < 2 {
+		t.Fatalf("expected the tasks to run periodically, got %d %d %d", flushes, publishes, added)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&flushes) != running {
+		t.Errorf("expected the tasks to be stopped, got %d runs instead of %d", flushes, running)
+	}
+	var taskErr *PeriodicTaskError
+	if err = <-m.options.ErrorChan; !errors.As(err, &taskErr) || taskErr.Task != "publish" {
+		t.Errorf("expected a PeriodicTaskError of publish, got %v", err)
+	}
+}
+
+func TestEventStatsDiff(t *testing.T) {
+	old := EventStats{Received: 10, ReceivedBytes: 1000, KernelDrops: 1, Handled: 9, HandlerLatency: LatencyHistogram{Counts: []uint64{5, 4}, Count: 9, Sum: 9 * time.Microsecond}}
+	current := EventStats{Received: 30, ReceivedBytes: 3000, KernelDrops: 4, Handled: 26, HandlerLatency: LatencyHistogram{Counts: []uint64{15, 11}, Count: 26, Sum: 30 * time.Microsecond}}
+	diff := current.Diff(old, 2*time.Second)
+	if diff.Received != 20 || diff.KernelDrops != 3 || diff.Handled != 17 || diff.BytesPerSecond != 1000 {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+	if diff.HandlerLatency.Counts[0] != 10 || diff.HandlerLatency.Counts[1] != 7 || diff.HandlerLatency.Average() != 21*time.Microsecond/17 {
+		t.Errorf("unexpected latency diff %+v", diff.HandlerLatency)
+	}
+	if current.HandlerLatency.Counts[0] != 15 {
+		t.Error("expected the snapshot to be left untouched")
+	}
+}
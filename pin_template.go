@@ -0,0 +1,257 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultPinPathTemplate - Default template of the pin paths of the PinByTemplate strategy, see
+// Options.PinPathTemplate
+const DefaultPinPathTemplate = "{root}/{manager}/{version}/{kind}_{name}"
+
+// pinVersionMarker - Version rendered in the pin path template to find the directory of the versions
+const pinVersionMarker = "\x00"
+
+// pinPathTemplate - Returns the template of the pin paths of the manager
+func (m *Manager) pinPathTemplate() string {
+	if m.options.PinPathTemplate != "" {
+		return m.options.PinPathTemplate
+	}
+	return DefaultPinPathTemplate
+}
+
+// renderPinPath - Renders the pin path template of the manager for the provided version, kind and name
+func (m *Manager) renderPinPath(version string, kind string, name string) string {
+	return filepath.Clean(strings.NewReplacer(
+		"{root}", m.bpffsRoot(),
+		"{manager}", m.options.ManagerName,
+		"{version}", version,
+		"{kind}", kind,
+		"{name}", name,
+	).Replace(m.pinPathTemplate()))
+}
+
+// specVersion - Returns a short hash of the programs and of the maps of the provided CollectionSpec
+func specVersion(spec *ebpf.CollectionSpec) string {
+	hash := sha256.New()
+	names := make([]string, 0, len(spec.Programs))
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prog := spec.Programs[name]
+		_, _ = fmt.Fprintf(hash, "prog %s %s %s\n", name, prog.Type, prog.SectionName)
+		for _, ins := range prog.Instructions {
+			_, _ = fmt.Fprintf(hash, "%v %s\n", ins, ins.Reference())
+		}
+	}
+	names = names[:0]
+	for name := range spec.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mapSpec := spec.Maps[name]
+		_, _ = fmt.Fprintf(hash, "map %s %s %d %d %d %d\n", name, mapSpec.Type, mapSpec.KeySize, mapSpec.ValueSize, mapSpec.MaxEntries, mapSpec.Flags)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// applyPinTemplate - (PinByTemplate) Sets the pin paths of the maps, programs and links of the manager from the pin
+// path template, once the version of the programs is known, and creates their directories
+func (m *Manager) applyPinTemplate() error {
+	if m.options.PinningStrategy != PinByTemplate {
+		return nil
+	}
+	if m.options.ManagerName == "" && strings.Contains(m.pinPathTemplate(), "{manager}") {
+		return fmt.Errorf("error:%w , a ManagerName is required", ErrInvalidPinTemplate)
+	}
+	if !strings.Contains(m.pinPathTemplate(), "{name}") {
+		return fmt.Errorf("error:%w , %s doesn't contain {name}", ErrInvalidPinTemplate, m.pinPathTemplate())
+	}
+	m.pinVersion = m.options.PinVersion
+	if m.pinVersion == "" {
+		m.pinVersion = specVersion(m.collectionSpec)
+	}
+
+	var paths []string
+	pin := func(kind string, name string) string {
+		path := m.renderPinPath(m.pinVersion, kind, name)
+		paths = append(paths, path)
+		return path
+	}
+	for _, managerMap := range m.Maps {
+		managerMap.PinPath = pin("map", managerMap.Name)
+	}
+	for _, perfMap := range m.PerfMaps {
+		perfMap.PinPath = pin("map", perfMap.Name)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		ringBuffer.PinPath = pin("map", ringBuffer.Name)
+	}
+	for _, probe := range m.Probes {
+		probe.PinPath = pin("prog", probePinName(probe))
+		probe.linkPinPath = pin("link", probePinName(probe))
+	}
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't create the pin directory of %s", err, path))
+		}
+	}
+	return nil
+}
+
+// GetPinVersion - (PinByTemplate) Returns the version of the pins of the manager: Options.PinVersion, or a hash of its
+// programs and maps
+func (m *Manager) GetPinVersion() string {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.pinVersion
+}
+
+// pinVersionsDir - Returns the directory holding one directory per version of the pins of the manager
+func (m *Manager) pinVersionsDir() (string, error) {
+	rendered := m.renderPinPath(pinVersionMarker, "kind", "name")
+	i := strings.Index(rendered, pinVersionMarker)
+	if i <= 0 || rendered[i-1] != filepath.Separator || !strings.HasPrefix(rendered[i+len(pinVersionMarker):], string(filepath.Separator)) {
+		return "", fmt.Errorf("error:%w , {version} isn't a directory of %s", ErrInvalidPinTemplate, m.pinPathTemplate())
+	}
+	return rendered[:i-1], nil
+}
+
+// CollectPinGarbage - (PinByTemplate) Removes the pins of the versions of the manager that aren't running anymore:
+// the version directories, other than the one of the manager, whose maps and programs aren't used by any other
+// process. This reclaims the pins left by the previous versions of a blue / green deployment once they stopped. The
+// links pinned by a stopped version are removed too, which detaches their programs. Returns the removed directories.
+func (m *Manager) CollectPinGarbage() ([]string, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.collectPinGarbage()
+}
+
+// collectPinGarbage - (not thread safe) See CollectPinGarbage
+func (m *Manager) collectPinGarbage() ([]string, error) {
+	if m.options.PinningStrategy != PinByTemplate {
+		return nil, fmt.Errorf("error:%w , pin garbage collection requires the PinByTemplate strategy", ErrInvalidPinTemplate)
+	}
+	dir, err := m.pinVersionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't list the pin versions of %s", err, dir))
+	}
+	var removed []string
+	var errs error
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == m.pinVersion {
+			continue
+		}
+		versionDir := filepath.Join(dir, entry.Name())
+		inUse, err := pinsInUse(versionDir)
+		if err != nil {
+			errs = ConcatErrors(errs, err)
+			continue
+		}
+		if inUse {
+			continue
+		}
+		if err = os.RemoveAll(versionDir); err != nil {
+			errs = ConcatErrors(errs, err)
+			continue
+		}
+		removed = append(removed, versionDir)
+	}
+	return removed, errs
+}
+
+// pinsInUse - Returns true if a map or a program pinned in the provided directory is used by another process
+func pinsInUse(dir string) (bool, error) {
+	mapIDs, progIDs := make(map[uint64]bool), make(map[uint64]bool)
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if pinnedMap, err := ebpf.LoadPinnedMap(path, nil); err == nil {
+			if info, err := pinnedMap.Info(); err == nil {
+				if id, ok := info.ID(); ok {
+					mapIDs[uint64(id)] = true
+				}
+			}
+			_ = pinnedMap.Close()
+		} else if prog, err := ebpf.LoadPinnedProgram(path, nil); err == nil {
+			if info, err := prog.Info(); err == nil {
+				if id, ok := info.ID(); ok {
+					progIDs[uint64(id)] = true
+				}
+			}
+			_ = prog.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("error:%v , couldn't list the pins of %s", err, dir))
+	}
+	if len(mapIDs) == 0 && len(progIDs) == 0 {
+		return false, nil
+	}
+	return bpfObjectsOpenElsewhere(mapIDs, progIDs), nil
+}
+
+// bpfObjectsOpenElsewhere - Returns true if a process other than the current one holds a file descriptor to one of the
+// provided maps or programs, according to the fdinfo of its BPF file descriptors
+func bpfObjectsOpenElsewhere(mapIDs map[uint64]bool, progIDs map[uint64]bool) bool {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return true
+	}
+	self := os.Getpid()
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join("/proc", proc.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join("/proc", proc.Name(), "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "anon_inode:bpf-") {
+				continue
+			}
+			info, err := os.ReadFile(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name()))
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(info), "\n") {
+				key, value, ok := strings.Cut(line, ":")
+				if !ok {
+					continue
+				}
+				id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+				if err != nil {
+					continue
+				}
+				if (key == "map_id" && mapIDs[id]) || (key == "prog_id" && progIDs[id]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBPFFSRoot - Default mount point of the BPF filesystem
+const DefaultBPFFSRoot = "/sys/fs/bpf"
+
+// PinningStrategy - Defines how the maps, programs and links of a manager are pinned
+type PinningStrategy int
+
+const (
+	// PinAbsolute - Maps, programs and links are pinned at their PinPath and LinkPinPath, if one is provided. This is
+	// the default strategy.
+	PinAbsolute PinningStrategy = iota
+	// PinByName - Maps, programs and links are pinned in Options.BPFFSRoot under a name derived from their map name or
+	// probe identification pair, prefixed with Options.PinPrefix. The provided PinPaths are overridden.
+	PinByName
+	// PinNone - Nothing is pinned, the provided PinPaths are ignored
+	PinNone
+	// PinByTemplate - Maps, programs and links are pinned at the paths rendered from Options.PinPathTemplate, which
+	// contain the name of the manager and the version of its programs (DefaultPinPathTemplate), so that several
+	// versions of an application can run side by side. The provided PinPaths are overridden. See
+	// Manager.CollectPinGarbage to remove the pins of the versions that stopped.
+	PinByTemplate
+)
+
+func (ps PinningStrategy) String() string {
+	switch ps {
+	case PinAbsolute:
+		return "PinAbsolute"
+	case PinByName:
+		return "PinByName"
+	case PinNone:
+		return "PinNone"
+	case PinByTemplate:
+		return "PinByTemplate"
+	default:
+		return fmt.Sprintf("PinningStrategy(%d)", int(ps))
+	}
+}
+
+// bpffsRoot - Returns the BPF filesystem root used by the manager
+func (m *Manager) bpffsRoot() string {
+	if m.options.BPFFSRoot != "" {
+		return m.options.BPFFSRoot
+	}
+	return DefaultBPFFSRoot
+}
+
+// pinName - Returns the path at which an object of the provided kind is pinned with the PinByName strategy
+func (m *Manager) pinName(kind string, name string) string {
+	return filepath.Join(m.bpffsRoot(), m.options.PinPrefix+kind+"_"+name)
+}
+
+// probePinName - Returns the name under which the program and the link of a probe are pinned
+func probePinName(p *Probe) string {
+	if p.UID == "" {
+		return p.EbpfFuncName
+	}
+	return p.EbpfFuncName + "_" + p.UID
+}
+
+// applyPinningStrategy - Sets the pin paths of the maps, programs and links of the manager according to the pinning
+// strategy of the manager
+func (m *Manager) applyPinningStrategy() error {
+	switch m.options.PinningStrategy {
+	case PinAbsolute:
+		for _, probe := range m.Probes {
+			probe.linkPinPath = probe.LinkPinPath
+		}
+	case PinByName:
+		for _, managerMap := range m.Maps {
+			managerMap.PinPath = m.pinName("map", managerMap.Name)
+		}
+		for _, perfMap := range m.PerfMaps {
+			perfMap.PinPath = m.pinName("map", perfMap.Name)
+		}
+		for _, ringBuffer := range m.RingBuffers {
+			ringBuffer.PinPath = m.pinName("map", ringBuffer.Name)
+		}
+		for _, probe := range m.Probes {
+			probe.PinPath = m.pinName("prog", probePinName(probe))
+			probe.linkPinPath = m.pinName("link", probePinName(probe))
+		}
+	case PinByTemplate:
+		// the pin paths depend on the version of the programs, see applyPinTemplate
+	case PinNone:
+		for _, managerMap := range m.Maps {
+			managerMap.PinPath = ""
+		}
+		for _, perfMap := range m.PerfMaps {
+			perfMap.PinPath = ""
+		}
+		for _, ringBuffer := range m.RingBuffers {
+			ringBuffer.PinPath = ""
+		}
+		for _, probe := range m.Probes {
+			probe.PinPath = ""
+			probe.linkPinPath = ""
+		}
+	default:
+		return errors.New(fmt.Sprintf("error:%v , %s", ErrUnknownPinning, m.options.PinningStrategy))
+	}
+	return nil
+}
+
+// CleanupPinnedObjects - Removes the pins left in Options.BPFFSRoot by a previous instance of the manager, for example
+// after a crash. Only the pins named with Options.PinPrefix are removed, a PinPrefix is therefore required. The
+// manager must not be running. See Options.CleanupStalePins to clean up the stale pins when the manager is
+// initialized.
+func (m *Manager) CleanupPinnedObjects() error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state >= running {
+		return ErrManagerRunning
+	}
+	return m.cleanupPinnedObjects()
+}
+
+// cleanupPinnedObjects - (not thread safe) Removes the pins of the BPF filesystem root starting with the pin prefix
+func (m *Manager) cleanupPinnedObjects() error {
+	if m.options.PinPrefix == "" {
+		return ErrMissingPinPrefix
+	}
+	root := m.bpffsRoot()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't list the pins of %s", err, root))
+	}
+	var errs error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), m.options.PinPrefix) {
+			continue
+		}
+		errs = ConcatErrors(errs, os.Remove(filepath.Join(root, entry.Name())))
+	}
+	return errs
+}
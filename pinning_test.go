@@ -0,0 +1,296 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+// mountBPFFS - Mounts a BPF filesystem in a temporary directory
+func mountBPFFS(t *testing.T) string {
+	root := t.TempDir()
+	if err := unix.Mount("bpf", root, "bpf", 0, ""); err != nil {
+		t.Skipf("couldn't mount a BPF filesystem: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = unix.Unmount(root, 0)
+	})
+	return root
+}
+
+func TestPinByName(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+
+	// pins left by a crashed instance, and by another application
+	for _, name := range []string{"test_map_stale", "other_map"} {
+		array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = array.Pin(filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+		array.Close()
+	}
+
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite", PinPath: "/unused"}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	options := Options{
+		BPFFSRoot:        root,
+		PinningStrategy:  PinByName,
+		PinPrefix:        "test_",
+		CleanupStalePins: true,
+	}
+	if err = m.InitWithOptions(elf, options); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"test_map_map_val", "test_prog_rewrite", "other_map"} {
+		if _, err = os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("expected %s to be pinned: %v", name, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(root, "test_map_stale")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the stale pin to be removed, got %v", err)
+	}
+
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "test_map_map_val")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the pin of map_val to be removed, got %v", err)
+	}
+	if err = m.CleanupPinnedObjects(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "other_map")); err != nil {
+		t.Errorf("expected the pin of another application to be kept: %v", err)
+	}
+}
+
+func TestCleanupPinnedObjectsWithoutPrefix(t *testing.T) {
+	m := &Manager{}
+	if err := m.CleanupPinnedObjects(); !errors.Is(err, ErrMissingPinPrefix) {
+		t.Errorf("expected ErrMissingPinPrefix, got %v", err)
+	}
+}
+
+func TestReusePinnedMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+	pinPath := filepath.Join(root, "map_val")
+
+	newManager := func(options MapOptions) (*Manager, error) {
+		elf, err := os.Open("testdata/rewrite.elf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer elf.Close()
+		m := &Manager{
+			Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite"}},
+			Maps:   []*Map{{Name: "map_val", MapOptions: options}},
+		}
+		return m, m.Init(elf)
+	}
+
+	// the first instance creates and pins the map
+	m, err := newManager(MapOptions{PinPath: pinPath, LoadPinPath: pinPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Maps[0].Reused() {
+		t.Error("expected the map of the first instance to be created")
+	}
+	if err = m.Maps[0].Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Stop(CleanKeepPinned); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second instance reuses it
+	m, err = newManager(MapOptions{PinPath: pinPath, LoadPinPath: pinPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if !m.Maps[0].Reused() {
+		t.Error("expected the pinned map to be reused")
+	}
+	var value uint32
+	if err = m.Maps[0].Get(uint32(0), &value); err != nil || value != 42 {
+		t.Errorf("expected the content of the map to be kept, got %d (%v)", value, err)
+	}
+
+	// a pinned map which doesn't match the spec is rejected
+	incompatible, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer incompatible.Close()
+	incompatiblePath := filepath.Join(root, "incompatible")
+	if err = incompatible.Pin(incompatiblePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newManager(MapOptions{LoadPinPath: incompatiblePath}); !errors.Is(err, ErrIncompatiblePinnedMap) {
+		t.Errorf("expected ErrIncompatiblePinnedMap, got %v", err)
+	}
+}
+
+func TestCreateMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{}},
+		state:      running,
+		options:    Options{PinningStrategy: PinByName, BPFFSRoot: root, PinPrefix: "test_"},
+	}
+	spec := ebpf.MapSpec{Name: "tenant_1", Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 16}
+	tenant, err := m.CreateMap(spec, MapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tenant.Put(uint32(1), uint32(2)); err != nil {
+		t.Fatal(err)
+	}
+	pinPath := filepath.Join(root, "test_map_tenant_1")
+	if tenant.PinPath != pinPath {
+		t.Errorf("expected the map to be pinned at %s, got %s", pinPath, tenant.PinPath)
+	}
+	if _, err = os.Stat(pinPath); err != nil {
+		t.Error(err)
+	}
+	if array, found, err := m.GetMap("tenant_1"); err != nil || !found || array == nil {
+		t.Errorf("expected the new map to be managed, got %v (%v)", found, err)
+	}
+	if _, err = m.CreateMap(spec, MapOptions{}); !errors.Is(err, ErrMapNameInUse) {
+		t.Errorf("expected ErrMapNameInUse, got %v", err)
+	}
+
+	if err = m.RemoveMap("tenant_1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(pinPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the pin to be removed, got %v", err)
+	}
+	if len(m.Maps) != 0 {
+		t.Errorf("expected the map to be removed from the manager")
+	}
+	if err = m.RemoveMap("tenant_1"); !errors.Is(err, ErrUnknownMap) {
+		t.Errorf("expected ErrUnknownMap, got %v", err)
+	}
+}
+
+func TestPinByTemplate(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+
+	// pins of a version that stopped, and of a version that is still running in another process
+	pinMap := func(version string) *ebpf.Map {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(root, "agent", version), 0700); err != nil {
+			t.Fatal(err)
+		}
+		array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = array.Pin(filepath.Join(root, "agent", version, "map_map_val")); err != nil {
+			t.Fatal(err)
+		}
+		return array
+	}
+	pinMap("stopped").Close()
+	running := pinMap("running")
+	defer running.Close()
+	// the *os.File owns a duplicate of the fd, so that it can close it without closing the map
+	fd, err := unix.Dup(running.FD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := os.NewFile(uintptr(fd), "map")
+	defer file.Close()
+	process := exec.Command("sleep", "60")
+	process.ExtraFiles = []*os.File{file}
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = process.Process.Kill()
+		_ = process.Wait()
+	}()
+
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite"}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	options := Options{
+		BPFFSRoot:         root,
+		PinningStrategy:   PinByTemplate,
+		ManagerName:       "agent",
+		CollectPinGarbage: true,
+	}
+	if err = m.InitWithOptions(elf, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+
+	version := m.GetPinVersion()
+	if len(version) != 12 {
+		t.Fatalf("expected a hash of the programs as version, got %q", version)
+	}
+	for _, name := range []string{"map_map_val", "prog_rewrite"} {
+		if _, err = os.Stat(filepath.Join(root, "agent", version, name)); err != nil {
+			t.Errorf("expected %s to be pinned: %v", name, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(root, "agent", "stopped")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the pins of the stopped version to be removed, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "agent", "running", "map_map_val")); err != nil {
+		t.Errorf("expected the pins of the running version to be kept: %v", err)
+	}
+
+	// the running version stopped
+	_ = process.Process.Kill()
+	_ = process.Wait()
+	file.Close()
+	running.Close()
+	removed, err := m.CollectPinGarbage()
+	if err != nil || len(removed) != 1 || filepath.Base(removed[0]) != "running" {
+		t.Errorf("expected the pins of the running version to be removed, got %v (%v)", removed, err)
+	}
+
+	m.options.PinPathTemplate = "{root}/{manager}_{version}_{kind}_{name}"
+	if _, err = m.CollectPinGarbage(); !errors.Is(err, ErrInvalidPinTemplate) {
+		t.Errorf("expected ErrInvalidPinTemplate, got %v", err)
+	}
+}
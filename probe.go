@@ -21,6 +21,7 @@ import (
 	"golang.org/x/sys/unix"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
 )
 
 // XdpAttachMode selects a way how XDP program will be attached to interface
@@ -65,6 +66,10 @@ const (
 	RetProbeType     = "r"
 )
 
+// ProbeIdentificationPair - Identifies a probe of the manager: the eBPF function of its program and its UID. The same
+// function can be attached multiple times, with different options (interfaces, PIDs, offsets...), by declaring one
+// probe per attachment with a distinct UID. Those probes share the program of the function, unless CopyProgram is
+// set. The APIs of the manager that look up, edit or detach a probe take its identification pair.
 type ProbeIdentificationPair struct {
 	UID string
 	//Section string
@@ -75,28 +80,109 @@ func (pip ProbeIdentificationPair) String() string {
 	return fmt.Sprintf("{UID:%s, EbpfFuncName:%s}", pip.UID, pip.EbpfFuncName)
 }
 
-// Matches - Returns true if the identification pair (probe uid, probe section) matches.
+// Matches - Returns true if the identification pair (probe uid, probe function) matches.
 func (pip ProbeIdentificationPair) Matches(id ProbeIdentificationPair) bool {
 	return pip.UID == id.UID && pip.EbpfFuncName == id.EbpfFuncName
 }
 
+// TCCleanupStrategy - Defines how the TC classifiers of a manager are cleaned up when they are detached
+type TCCleanupStrategy int
+
+const (
+	// TCCleanupQdisc - The clsact qdisc of an interface is deleted along with all its filters when the last classifier
+	// of the manager on this interface is detached. This is the default strategy.
+	TCCleanupQdisc TCCleanupStrategy = iota
+	// TCCleanupFilters - Only the filters added by the manager are deleted. The clsact qdisc is deleted only if the
+	// manager created it and no other filter uses it, so that the filters of other tools are left intact.
+	TCCleanupFilters
+)
+
 // Probe - Main eBPF probe wrapper. This structure is used to store the required data to attach a loaded eBPF
 // program to its hook point.
 type Probe struct {
-	manager            *Manager
-	program            *ebpf.Program
-	programSpec        *ebpf.ProgramSpec
-	attachPID          int
-	link               link.Link
-	tcFilter           netlink.BpfFilter
-	tcClsActQdisc      netlink.Qdisc
-	state              state
-	stateLock          sync.RWMutex
-	manualLoadNeeded   bool
-	checkPin           bool
-	funcName           string //目标hook对象的函数名；uprobe中，若为空，则使用offset。
-	AttachPID          int    // pid to attach, only for uprobe .
-	attachRetryAttempt uint
+	manager     *Manager
+	program     *ebpf.Program
+	programSpec *ebpf.ProgramSpec
+	attachPID   int
+	link        link.Link
+	linkPinPath string
+	skipReason  error
+	// selectedVariant, variantErr - Program variant selected for the running kernel, and the reason why the probe is
+	// skipped when none matches, see Variants
+	selectedVariant string
+	variantErr      error
+	// privilegeErr - Reason why the probe is skipped when the process lacks privileges, see Options.DegradeUnprivileged
+	privilegeErr       error
+	kprobeEvent        *kprobeEvent
+	kprobeAttachMethod KprobeAttachMethod
+	tcAttachMode       TCAttachMode
+	// kprobeCandidates, kprobeOffset - (kprobes) Functions the kprobe can be attached to, in order, and offset of the
+	// kprobe in the function, see AttachToFuncCandidates and KprobeAddress
+	kprobeCandidates []string
+	kprobeOffset     uint64
+	// matchedFuncNames, matchedKprobes - (kprobes) Functions matching MatchFuncName and the kprobes attached to them,
+	// see attachKprobeMatching
+	matchedFuncNames []string
+	matchedKprobes   []*matchedKprobe
+	// ifindexResolved - (TC classifier & XDP) True if Ifindex was resolved from Ifname
+	ifindexResolved bool
+	// binaryIdentity - (uprobes) Identity of the binary when the probe was attached, see checkHealth
+	binaryIdentity binaryIdentity
+	// cgroupAttachment - (cgroup family programs) Attachment of the probe when CGroupAttachFlags is set
+	cgroupAttachment *cgroupAttachment
+	// sockMapAttachment - (sk_msg & sk_skb) Attachment of the probe to its SOCKMAP or SOCKHASH
+	sockMapAttachment *sockMapAttachment
+	// perfEventAttachment - (perf_event) Sampling perf events of the probe, see SampleFrequency
+	perfEventAttachment *perfEventAttachment
+	// processLock, matchedBinaries, processAttachments, execWatcher, matchingIsRet - (uprobes) Uprobes attached to the
+	// binaries matching UprobeAttachAllMatching, see attachUprobeMatching
+	processLock        sync.Mutex
+	matchedBinaries    map[binaryIdentity]*matchedBinary
+	processAttachments map[int][]UprobeProcessAttachment
+	execWatcher        *execWatcher
+	matchingIsRet      bool
+	// pidLinks - (uprobes) Uprobes opened for each process of AttachPIDs, see attachUprobePIDs
+	pidLinks map[int]link.Link
+	// goReturnSites - (uprobes) Uprobes attached at the return instructions of the function, see UprobeGoReturns
+	goReturnSites []goReturnSite
+
+	// uprobeMultiLinks - (uprobes) uprobe_multi links of the probe, see UprobeGoReturns
+	uprobeMultiLinks []*uprobeMultiLink
+	// exitCleanup - Cleanup of the probe while the manager stops it, see stopOnExit
+	exitCleanup CleanupType
+	// xdpExtension, xdpExtensionLink, xdpDispatcherPrefix - (XDP) Program extension of the probe, its link to the slot of
+	// the dispatcher of the interface, and the pin prefix of the dispatcher
+	xdpExtension        *ebpf.Program
+	xdpExtensionLink    link.Link
+	xdpDispatcherPrefix string
+	tcFilter            netlink.BpfFilter
+	tcClsActQdisc       netlink.Qdisc
+	state               state
+	stateLock           sync.RWMutex
+	manualLoadNeeded    bool
+	checkPin            bool
+	funcName            string //目标hook对象的函数名；uprobe中，若为空，则使用offset。
+	AttachPID           int    // pid to attach, only for uprobe .
+	attachRetryAttempt  uint
+	// targetProgram - (freplace, fentry / fexit on BPF) Program whose function is replaced by the program extension of
+	// the probe, or traced by the program of the probe
+	targetProgram *ebpf.Program
+	// replaced - Program of another application replaced by the probe when it was attached, see attachProbes
+	replaced *replacedProgram
+	// packetSocket - (socket filter) Raw packet socket created by the probe when SocketFD isn't set
+	packetSocket int
+	// interfaceLock, interfaceAttachments, interfaceEvents, interfaceWatchStop, interfaceWatchDone - (TC classifiers &
+	// XDP) Sub-probes attached to the interfaces matching IfnameMatcher, see attachInterfaceMatching, or to the veth
+	// pairs of the container of the probe, see attachContainerInterfaces
+	interfaceLock        sync.Mutex
+	interfaceAttachments map[int32]*InterfaceAttachment
+	interfaceEvents      *os.File
+	interfaceWatchStop   chan struct{}
+	interfaceWatchDone   chan struct{}
+	// retrying, retryAttempts, nextRetry - Progress of the background retries of the attachment, see RetryPolicy
+	retrying      bool
+	retryAttempts uint
+	nextRetry     time.Time
 
 	// TCFilterHandle - (TC classifier) defines the handle to use when loading the classifier. Leave unset to let the kernel decide which handle to use.
 	TCFilterHandle uint32
@@ -104,7 +190,15 @@ type Probe struct {
 	// TCFilterPrio - (TC classifier) defines the priority of the classifier added to the clsact qdisc. Defaults to DefaultTCFilterPriority.
 	TCFilterPrio uint16
 
+	// TCFilterReplace - (TC classifier) When set, the filter of the interface with the same TCFilterHandle and
+	// TCFilterPrio is replaced, instead of failing to attach the classifier. The replaced filter is restored if Start
+	// rolls back.
+	TCFilterReplace bool
+
 	// TCCleanupQDisc - (TC classifier) defines if the manager should cleanup the clsact qdisc when a probe is unloaded
+	//
+	// Deprecated: this field isn't read, the clean up of the classifiers of the manager is defined by
+	// Options.TCCleanupStrategy.
 	TCCleanupQDisc bool
 
 	// TCFilterProtocol - (TC classifier) defines the protocol to match in order to trigger the classifier. Defaults to
@@ -127,9 +221,20 @@ type Probe struct {
 	// 故，不能作为programSpec[]的索引来使用。索引改用MatchFuncName
 	Section string
 
-	// CopyProgram - When enabled, this option will make a unique copy of the program section for the current program
+	// CopyProgram - When enabled, this option will make a unique copy of the program section for the current program,
+	// loaded separately from the programs of the other probes of the same eBPF function. Its constants can then be
+	// edited independently, see ConstantEditor.ProbeIdentificationPairs.
 	CopyProgram bool
 
+	// InstructionPatcher - Pre-loading instruction patcher of the program of the probe, run before the instruction
+	// patchers of the manager. The program spec is shared by the probes of the same eBPF function, enable CopyProgram
+	// to patch it for this probe only. See InstructionPatcher for more.
+	InstructionPatcher InstructionPatcher
+
+	// DumpHandler - Callback function called when manager.DumpAll() or manager.GetDump() is called, to add the state
+	// of the probe to the dump
+	DumpHandler func(probe *Probe, manager *Manager) string
+
 	// EbpfFuncName - Name of the syscall on which the program should be hooked. As the exact kernel symbol may
 	// differ from one kernel version to the other, the right prefix will be computed automatically at runtime.
 	// If a syscall name is not provided, the section name (without its probe type prefix) is assumed to be the
@@ -146,17 +251,78 @@ type Probe struct {
 	// provided pattern will be used.
 	AttachToFuncName string
 
+	// AttachToFuncCandidates - (kprobes) Ordered list of kernel functions the kprobe can be attached to, when a function
+	// is renamed or inlined across kernel versions (for example "tcp_sendmsg_locked", "tcp_sendmsg"). The candidates that
+	// exist in the symbol file (see Options.SymFile) are tried in order, after AttachToFuncName if it is set, until
+	// one of them can be probed: candidates on the kprobe blacklist are skipped. See GetAttachedFuncName.
+	AttachToFuncCandidates []string
+
+	// SyscallName - (kprobes, tracepoints) Name of the syscall hooked by the probe, for example "openat", instead of a
+	// function in the section or in AttachToFuncName. Kprobes are attached to the syscall function of the running
+	// kernel and architecture (__x64_sys_openat, __arm64_sys_openat, sys_openat...), see SyscallSymbols. Tracepoints
+	// are attached to the syscalls/sys_enter_[name] tracepoint, or to syscalls/sys_exit_[name] when the tracepoint of
+	// the section starts with sys_exit (SEC("tracepoint/syscalls/sys_exit")). The syscall tracepoints don't depend on
+	// the architecture, they should be preferred when the arguments of the syscall are enough.
+	SyscallName string
+
+	// SyscallCompat - (kprobes) Attaches the kprobe of SyscallName to the compat syscall function, called by the 32-bit
+	// processes on 64-bit kernels (__ia32_compat_sys_openat, __arm64_compat_sys_openat, compat_sys_openat...)
+	SyscallCompat bool
+
+	// KprobeAddress - (kprobes) Kernel address the kprobe is attached to, instead of AttachToFuncName. The address is
+	// resolved into the symbol that contains it and an offset in this symbol, from the symbol file (see
+	// Options.SymFile), which requires the addresses not to be hidden by kernel.kptr_restrict.
+	KprobeAddress uint64
+
+	// KprobeOffset - (kprobes) Offset of the kprobe in the function it is attached to, to trace a return or a branch
+	// site in the middle of the function, where fexit can't be used. The offset must be on an instruction boundary, it
+	// is checked against the size of the function in the symbol file when the addresses aren't hidden. Kretprobes
+	// can't have an offset.
+	KprobeOffset uint64
+
+	// KprobeBodyOffset - (kprobes) KprobeOffset is relative to the body of the function, after the instructions
+	// inserted by the compiler at its entry (landing pads, ftrace call sites), whose size depends on the architecture
+	// and on the configuration of the kernel, see FunctionEntryPadding. Use it for the offsets computed from a kernel
+	// built without these instructions.
+	KprobeBodyOffset bool
+
+	// MatchFuncName - (kprobes) Regular expression matched against the text symbols of the symbol file (see
+	// Options.SymFile), instead of AttachToFuncName: the program is attached to every matching function, like the
+	// kprobe wildcards of bpftrace. The functions that can't be probed are skipped, see GetKprobeAttachments.
+	// AttachToFuncCandidates, KprobeAddress and KprobeOffset are ignored. On kernels supporting kprobe_multi links
+	// (5.18+, see HaveKprobeMulti), the program is attached to all the functions with a single link, unless
+	// Options.DisableKprobeMulti is set.
+	MatchFuncName string
+
+	// MatchFuncExclude - (kprobes) Regular expressions of the functions matching MatchFuncName that shouldn't be probed
+	MatchFuncExclude []string
+
+	// MatchFuncMaxCount - (kprobes) Maximum number of functions MatchFuncName can match, the probe fails to initialize
+	// beyond it. Defaults to DefaultMatchFuncMaxCount.
+	MatchFuncMaxCount int
+
 	// Enabled - Indicates if a probe should be enabled or not. This parameter can be set at runtime using the
 	// Manager options (see ActivatedProbes)
 	Enabled bool
 
+	// ProbeGroup - (optional) Group tags of the probe, for example "dns" or "tls". All the probes of a group can be
+	// attached and detached at runtime with Manager.ActivateGroup and Manager.DeactivateGroup.
+	ProbeGroup []string
+
 	// PinPath - Once loaded, the eBPF program will be pinned to this path. If the eBPF program has already been pinned
 	// and is already running in the kernel, then it will be loaded from this path.
 	PinPath string
 
+	// LinkPinPath - Once attached, the bpf_link of the probe is pinned at this path, so that the program stays attached
+	// if the process exits without stopping the manager. Detaching the probe removes the pin. Only the programs
+	// attached with a bpf_link (tracing, LSM, raw tracepoint, cgroup and iterator programs) can be pinned this way. See
+	// Options.AdoptPinnedLinks to reuse the link after a restart. Overridden by the PinByName and PinNone strategies.
+	LinkPinPath string
+
 	// KProbeMaxActive - (kretprobes) With kretprobes, you can configure the maximum number of instances of the function that can be
 	// probed simultaneously with maxactive. If maxactive is 0 it will be set to the default value: if CONFIG_PREEMPT is
 	// enabled, this is max(10, 2*NR_CPUS); otherwise, it is NR_CPUS. For kprobes, maxactive is ignored.
+	// A kretprobe with a maxactive is created through tracefs, see GetKretprobeMissCount to tune it.
 	KProbeMaxActive int
 
 	// ELF内嵌在某个文件中的情况下使用
@@ -172,12 +338,84 @@ type Probe struct {
 	// address参数也就是不需要类库再计算的绝对地址，即等于上面二者只和。 优先级最高。
 	UAddress uint64
 
+	// KprobeFallback - (fentry / fexit) When the kernel doesn't support BPF trampolines, the probe is loaded and
+	// attached as a kprobe (fentry) or a kretprobe (fexit) on the same function instead. The program receives a
+	// struct pt_regs context in that case, it must therefore be written to handle both contexts.
+	KprobeFallback bool
+
+	// FreplaceTarget - (freplace) EbpfFuncName of the program of the manager whose function is replaced by the program
+	// extension of the probe, for example a dispatcher calling plugin functions. The replaced function is
+	// AttachToFuncName, or the one of the section (freplace/[function]). The target program must have BTF.
+	FreplaceTarget string
+
+	// FreplaceTargetPinPath - (freplace) Path of a pinned program whose function is replaced, instead of FreplaceTarget
+	FreplaceTargetPinPath string
+
+	// FreplaceTargetFD - (freplace) File descriptor of a program whose function is replaced, instead of FreplaceTarget.
+	// The file descriptor is duplicated, it still belongs to the caller.
+	FreplaceTargetFD int
+
+	// AttachTarget - (fentry / fexit) EbpfFuncName of the program of the manager traced by the probe, instead of a
+	// kernel function, to measure the latency of an XDP program for example. The traced function is AttachToFuncName,
+	// or the one of the section (fentry/[function]), and defaults to the main function of the target program. The
+	// target program must have BTF. KprobeFallback doesn't apply.
+	AttachTarget string
+
+	// LazyLoad - If true, the program of the probe isn't loaded with the collection at Init, but when the probe is first
+	// activated (Start, UpdateActivatedProbes, ActivateGroup...). This saves the verification time and the memory of the
+	// programs of the probes that are rarely activated. The program is still loaded at Init if a probe without LazyLoad
+	// uses it. The lazy programs can't be used in the tail call routes of Options.TailCallRouter.
+	LazyLoad bool
+
+	// KernelModule - (kprobes, fentry / fexit, tracepoints) Name of the kernel module that defines the hook point of the
+	// probe. The probe fails to attach with ErrKernelModuleNotLoaded while the module isn't loaded. Kprobes are only
+	// attached to the functions of the module, which are looked up in its BTF (/sys/kernel/btf/[module]) or in
+	// SymFile. The fentry / fexit targets are resolved in the BTF of the loaded modules by the kernel.
+	KernelModule string
+
+	// WaitForModule - If true, the attachment of the probe is deferred until KernelModule is loaded: Start doesn't
+	// fail, and the probe is attached in the background once the module shows up, like with a RetryPolicy. The program
+	// of the probe is loaded at that point, as with LazyLoad. RetryPolicy overrides the policy used to wait.
+	WaitForModule bool
+
+	// Optional - If true, the failure of the probe to attach doesn't abort Manager.Start, and the probe isn't added to
+	// the default activation selectors. By default, Start detaches the probes attached so far and restores the
+	// programs they replaced when a probe fails to attach. The probes selected in a OneOf or a BestEffort selector of
+	// Options.ActivatedProbes don't abort Start either, the selector validates them once all the probes were attached.
+	Optional bool
+
+	// KernelVersionMin - The probe is skipped on kernels older than this version, see NewKernelVersion
+	KernelVersionMin KernelVersion
+
+	// KernelVersionMax - The probe is skipped on kernels newer than or equal to this version
+	KernelVersionMax KernelVersion
+
+	// FeatureCheck - The probe is skipped if FeatureCheck returns an error, for example HaveTrampolines or HaveBPFLSM
+	FeatureCheck func() error
+
+	// Variants - Program variants of the probe, for example one per layout of the kernel structures read by the
+	// program. At Init, the first variant that matches the running kernel replaces the program EbpfFuncName in the
+	// CollectionSpec, so that the probe keeps its identification pair, and the programs of the other variants aren't
+	// loaded. When no variant matches, the program EbpfFuncName is used if the CollectionSpec has one, otherwise the
+	// probe is skipped like an unsupported probe. The probes that share EbpfFuncName must declare the same variants.
+	// See GetSelectedVariant.
+	Variants []ProbeVariant
+
+	// Cookie - (kprobes, uprobes and tracepoints) Arbitrary value that the program can read with the
+	// bpf_get_attach_cookie helper. This allows attaching the same program to many hook points while telling them
+	// apart. Requires kernel 5.15+, see HaveAttachCookies.
+	Cookie uint64
+
 	// ProbeRetry - Defines the number of times that the probe will retry to attach / detach on error.
 	ProbeRetry uint
 
 	// ProbeRetryDelay - Defines the delay to wait before the probe should retry to attach / detach on error.
 	ProbeRetryDelay time.Duration
 
+	// RetryPolicy - Background retries of the attachment of the probe when it fails at Start with a transient error.
+	// Defaults to Options.DefaultRetryPolicy. See RetryPolicy.
+	RetryPolicy *RetryPolicy
+
 	// 用来处理 apk 内嵌 elf 的情况
 	RealFilePath string
 
@@ -185,28 +423,158 @@ type Probe struct {
 	// automatically computed for the symbol name provided in the uprobe section ( SEC("uprobe/[symbol_name]") ).
 	BinaryPath string
 
+	// BinaryRootPID - (uprobes) When set, BinaryPath is resolved in the root filesystem of this process,
+	// /proc/[pid]/root, so that the binaries of a container can be instrumented from the host. The debug files of the
+	// binary are looked up in this root filesystem first.
+	BinaryRootPID int
+
+	// UprobeAttachAllMatching - (uprobes) When set, the uprobe is attached to every executable file mapped by the
+	// running processes whose path matches this pattern (see filepath.Match), instead of BinaryPath. The mappings are
+	// read from /proc/[pid]/maps and resolved in the root filesystem of each process. The uprobe is attached once per
+	// distinct binary, without PID filter. See GetProcessAttachments for the per process results.
+	UprobeAttachAllMatching string
+
+	// UprobeWatchExec - (uprobes) When set with UprobeAttachAllMatching, the manager watches the new processes with a
+	// program attached to the sched/sched_process_exec tracepoint, and attaches the uprobe to their matching binaries.
+	UprobeWatchExec bool
+
+	// AttachPIDs - (uprobes) Processes the uprobe is scoped to, along with AttachPID: the uprobe is opened for each of
+	// them with perf_event_open(pid), instead of system wide, so that the other processes running the binary don't
+	// trigger the program. The uprobe fails to attach if one of the processes doesn't exist. Processes can be added and
+	// removed at runtime with AddAttachPID and RemoveAttachPID, except with UprobeGoReturns.
+	AttachPIDs []int
+
+	// UprobeGoReturns - (uprobes on Go binaries) When set, the program is attached by an entry uprobe at each return
+	// instruction of the function, found by disassembling the binary, instead of a uretprobe: the return address
+	// hijacked by a uretprobe breaks the stack copying and the stack unwinding of the Go runtime. At the return
+	// instructions, the results of the function are in the registers of the Go internal ABI. The probe is attached if at
+	// least one return instruction was attached, see GetGoReturnSites. Tail calls aren't followed. The uprobes are scoped
+	// to the processes of AttachPIDs and AttachPID, if any. On kernels supporting uprobe_multi links (6.6+, see
+	// HaveUprobeMulti), the program is attached to all the return instructions with a single link (one per process),
+	// unless Options.DisableUprobeMulti is set.
+	UprobeGoReturns bool
+
+	// Cleanup - Defines how the probe is cleaned up when the manager stops: CleanupLeaveAttached leaves it attached,
+	// CleanupDetachOnly leaves the pin of its program, and CleanupUnpin removes its pins even if the state of the
+	// manager was saved. See CleanupType.
+	Cleanup CleanupType
+
+	// USDTProvider - (USDT) Provider of the USDT marker to attach to, in the binary at BinaryPath. When USDTName is
+	// set, the uprobe is attached at the location of the marker read from the .note.stapsdt section of the binary.
+	USDTProvider string
+
+	// USDTName - (USDT) Name of the USDT marker to attach to. If the marker has a semaphore, it is reference counted
+	// by the kernel while the probe is attached (requires kernel 4.20+).
+	USDTName string
+
 	// CGrouPath - (cgroup family programs) All CGroup programs are attached to a CGroup (v2). This field provides the
-	// path to the CGroup to which the probe should be attached. The attach type is determined by the section.
+	// path to the CGroup to which the probe should be attached. The attach type is determined by the section. On the
+	// hybrid systems, a cgroup v1 path is translated to the same cgroup in the cgroup v2 hierarchy.
 	CGroupPath string
 
+	// CGroupAttachFlags - (cgroup family programs) When set, the program is attached with BPF_PROG_ATTACH and these
+	// flags instead of a bpf_link, see CGroupAttachAllowMulti.
+	CGroupAttachFlags CGroupAttachFlags
+
+	// CGroupReplaceProgramID - (cgroup family programs) ID of the program atomically replaced by the program of the
+	// probe, with CGroupAttachReplace
+	CGroupReplaceProgramID ebpf.ProgramID
+
+	// SockMap - (sk_msg & sk_skb) Name of the SOCKMAP or SOCKHASH map of the manager to which the probe should be
+	// attached. The attach type is determined by the section. See Manager.PutSocket to insert sockets in the map.
+	SockMap string
+
+	// IterMap - (bpf_iter) Name of the map of the manager iterated by a map element iterator, SEC("iter/bpf_map_elem")
+	// or SEC("iter/sockmap"). See Manager.RunIterator to run the iterator.
+	IterMap string
+
+	// SampleFrequency - (perf_event) Samples per second of the perf events opened by the probe on each CPU, for example
+	// 99 to build a CPU profiler with a software cpu-clock event. The program runs on every sample. Exclusive with
+	// SamplePeriod. When neither is set, the perf_event program is attached without opening any perf event.
+	SampleFrequency uint64
+
+	// SamplePeriod - (perf_event) Number of events between two samples of the perf events opened by the probe, for
+	// example 10000 LLC misses. Exclusive with SampleFrequency.
+	SamplePeriod uint64
+
+	// SampleRate - Only one out of SampleRate events is handled by the probe. The sample period of perf_event probes is
+	// multiplied by SampleRate (or their sample frequency divided by it), the other probes read SampleRateConstant.
+	SampleRate uint64
+
+	// MaxEventsPerSec - Maximum number of events handled per second by the probe. The sample frequency of perf_event
+	// probes is capped to MaxEventsPerSec, the other probes read MaxEventsPerSecConstant. Set CopyProgram when several
+	// probes sharing a program use different SampleRate or MaxEventsPerSec values.
+	MaxEventsPerSec uint64
+
+	// PerfEventType - (perf_event) Type of the perf events opened by the probe: unix.PERF_TYPE_SOFTWARE (default),
+	// unix.PERF_TYPE_HARDWARE, unix.PERF_TYPE_HW_CACHE...
+	PerfEventType uint32
+
+	// PerfEventConfig - (perf_event) Counter of the perf events opened by the probe, for the provided PerfEventType:
+	// unix.PERF_COUNT_SW_CPU_CLOCK (default), unix.PERF_COUNT_HW_CACHE_MISSES...
+	PerfEventConfig uint64
+
+	// PerfEventCPUs - (perf_event) CPUs on which the perf events of the probe are opened. Defaults to all the online
+	// CPUs.
+	PerfEventCPUs []int
+
 	// SocketFD - (socket filter) Socket filter programs are bound to a socket and filter the packets they receive
-	// before they reach user space. The probe will be bound to the provided file descriptor
+	// before they reach user space. The probe will be bound to the provided file descriptor. When not set, the probe
+	// creates a raw packet socket (AF_PACKET) bound to its interface (Ifindex or Ifname, in NetnsPath), which is
+	// closed when the probe is stopped, see SocketFilterFD.
 	SocketFD int
 
-	// Ifindex - (TC classifier & XDP) Interface index used to identify the interface on which the probe will be
-	// attached. If not set, fall back to Ifname.
+	// Ifindex - (TC classifier, XDP & socket filter) Interface index used to identify the interface on which the probe
+	// will be attached. If not set, fall back to Ifname.
 	Ifindex int32
 
-	// Ifname - (TC Classifier & XDP) Interface name on which the probe will be attached.
+	// Ifname - (TC Classifier, XDP & socket filter) Interface name on which the probe will be attached.
 	Ifname string
 
+	// IfnameMatcher - (TC Classifier & XDP) When set, the probe is attached to every interface whose name matches, instead
+	// of Ifindex and Ifname. The manager watches the link events of the network namespace of the probe, and attaches
+	// or detaches the probe as the matching interfaces are created, renamed and deleted (veth pairs of containers for
+	// example). See GetInterfaceAttachments.
+	IfnameMatcher *IfnameMatcher
+
+	// Container - (TC Classifier & XDP) When set, the probe is attached to the host side of the veth pairs of this
+	// container, instead of Ifindex and Ifname: the interfaces of the network namespace of the probe (NetnsPath) that
+	// are the peers of the veth interfaces of the network namespace of the container. The container is a container ID
+	// or the path of its cgroup, see ResolveContainer, and is resolved again every ContainerRefreshInterval so that the
+	// probe follows the container as it restarts. See GetInterfaceAttachments.
+	Container string
+
+	// ContainerPID - (TC Classifier & XDP) Same as Container, for the container of this process
+	ContainerPID int
+
+	// ContainerRefreshInterval - (Container, ContainerPID) Interval at which the veth pairs of the container are
+	// resolved again. Defaults to DefaultContainerRefreshInterval.
+	ContainerRefreshInterval time.Duration
+
 	// IfindexNetns - (TC Classifier & XDP) Network namespace in which the network interface lives
 	IfindexNetns uint64
 
+	// NetnsPath - (TC Classifier & XDP) Path to the network namespace in which the network interface lives, for example
+	// /proc/[pid]/ns/net or /var/run/netns/[name]. When set, the interface is resolved and the probe is attached from
+	// within this namespace, so that interfaces that only exist in a container can be instrumented.
+	// (sk_lookup) Network namespace the program is attached to, the network namespace of the process by default.
+	NetnsPath string
+
 	// XDPAttachMode - (XDP) XDP attach mode. If not provided the kernel will automatically select the best available
 	// mode.
 	XDPAttachMode XdpAttachMode
 
+	// XDPUseDispatcher - (XDP) Attaches the program to a slot of the XDP dispatcher of the interface instead of attaching
+	// it directly, so that several XDP programs of this manager or of other applications can share the interface. The
+	// dispatcher runs the programs in order of priority until one of them returns an action other than XDP_PASS. It
+	// is created with XDPAttachMode on the first attachment, and pinned in Options.BPFFSRoot so that it can be shared.
+	// The program must be compiled with BTF, as it is loaded as a program extension.
+	XDPUseDispatcher bool
+
+	// XDPPriority - (XDP) Slot of the program in the XDP dispatcher of the interface, between 0 and
+	// XDPDispatcherSlots - 1. Programs with a lower priority run first. See XDPUseDispatcher.
+	XDPPriority int
+
 	// NetworkDirection - (TC classifier) Network traffic direction of the classifier. Can be either Ingress or Egress. Keep
 	// in mind that if you are hooking on the host side of a virtuel ethernet pair, Ingress and Egress are inverted.
 	NetworkDirection TrafficType
@@ -216,28 +584,114 @@ type Probe struct {
 	// tcObject - (TC classifier) TC object created when the classifier was attached. It will be reused to delete it on
 	// exit.
 	tcObject *tc.Object
+	// tcFilterObject - (TC classifier) TC filter added when the classifier was attached, with the handle and the
+	// priority picked by the kernel
+	tcFilterObject *tc.Object
+
+	// TCDirectActionDisabled - (TC classifier) Attaches the TC filter without the direct action flag: the return value
+	// of the program is then a class ID instead of a TC action.
+	TCDirectActionDisabled bool
+
+	// TCAttachMode - (TC classifier) Defines how the classifier is attached to its interface. Defaults to
+	// TCAttachModeNetlink.
+	TCAttachMode TCAttachMode
+
+	// TCXOrder - (TC classifier, tcx) Position of the classifier in the list of the tcx programs of the interface
+	TCXOrder TCXOrder
+
+	// TCXRelativeTo - (TC classifier, tcx) Probe of the manager relative to which the classifier is placed, with the
+	// TCXOrderBefore and TCXOrderAfter orders. The relative probe must be attached first.
+	TCXRelativeTo ProbeIdentificationPair
+
+	// TCXRelativeProgramID - (TC classifier, tcx) ID of the program relative to which the classifier is placed, with the
+	// TCXOrderBefore and TCXOrderAfter orders, when this program was loaded by another application. Ignored if
+	// TCXRelativeTo is set.
+	TCXRelativeProgramID ebpf.ProgramID
 }
 
 // Copy - Returns a copy of the current probe instance. Only the exported fields are copied.
 func (p *Probe) Copy() *Probe {
 	return &Probe{
-		UID:              p.UID,
-		Section:          p.Section,
-		AttachToFuncName: p.AttachToFuncName,
-		EbpfFuncName:     p.EbpfFuncName,
-		Enabled:          p.Enabled,
-		PinPath:          p.PinPath,
-		KProbeMaxActive:  p.KProbeMaxActive,
-		BinaryPath:       p.BinaryPath,
-		CGroupPath:       p.CGroupPath,
-		SocketFD:         p.SocketFD,
-		Ifindex:          p.Ifindex,
-		Ifname:           p.Ifname,
-		IfindexNetns:     p.IfindexNetns,
-		XDPAttachMode:    p.XDPAttachMode,
-		NetworkDirection: p.NetworkDirection,
-		ProbeRetry:       p.ProbeRetry,
-		ProbeRetryDelay:  p.ProbeRetryDelay,
+		UID:                      p.UID,
+		Section:                  p.Section,
+		AttachToFuncName:         p.AttachToFuncName,
+		SyscallName:              p.SyscallName,
+		SyscallCompat:            p.SyscallCompat,
+		AttachToFuncCandidates:   append([]string(nil), p.AttachToFuncCandidates...),
+		KprobeAddress:            p.KprobeAddress,
+		KprobeOffset:             p.KprobeOffset,
+		KprobeBodyOffset:         p.KprobeBodyOffset,
+		MatchFuncName:            p.MatchFuncName,
+		MatchFuncExclude:         append([]string(nil), p.MatchFuncExclude...),
+		MatchFuncMaxCount:        p.MatchFuncMaxCount,
+		EbpfFuncName:             p.EbpfFuncName,
+		Enabled:                  p.Enabled,
+		ProbeGroup:               append([]string(nil), p.ProbeGroup...),
+		PinPath:                  p.PinPath,
+		LinkPinPath:              p.LinkPinPath,
+		KProbeMaxActive:          p.KProbeMaxActive,
+		BinaryPath:               p.BinaryPath,
+		BinaryRootPID:            p.BinaryRootPID,
+		UprobeAttachAllMatching:  p.UprobeAttachAllMatching,
+		UprobeWatchExec:          p.UprobeWatchExec,
+		AttachPIDs:               append([]int(nil), p.AttachPIDs...),
+		UprobeGoReturns:          p.UprobeGoReturns,
+		IfnameMatcher:            p.IfnameMatcher,
+		Container:                p.Container,
+		ContainerPID:             p.ContainerPID,
+		ContainerRefreshInterval: p.ContainerRefreshInterval,
+		USDTProvider:             p.USDTProvider,
+		USDTName:                 p.USDTName,
+		CGroupPath:               p.CGroupPath,
+		CGroupAttachFlags:        p.CGroupAttachFlags,
+		CGroupReplaceProgramID:   p.CGroupReplaceProgramID,
+		SockMap:                  p.SockMap,
+		IterMap:                  p.IterMap,
+		SampleFrequency:          p.SampleFrequency,
+		SamplePeriod:             p.SamplePeriod,
+		SampleRate:               p.SampleRate,
+		MaxEventsPerSec:          p.MaxEventsPerSec,
+		PerfEventType:            p.PerfEventType,
+		PerfEventConfig:          p.PerfEventConfig,
+		PerfEventCPUs:            append([]int(nil), p.PerfEventCPUs...),
+		SocketFD:                 p.SocketFD,
+		Ifindex:                  p.Ifindex,
+		Ifname:                   p.Ifname,
+		IfindexNetns:             p.IfindexNetns,
+		NetnsPath:                p.NetnsPath,
+		XDPAttachMode:            p.XDPAttachMode,
+		XDPUseDispatcher:         p.XDPUseDispatcher,
+		XDPPriority:              p.XDPPriority,
+		NetworkDirection:         p.NetworkDirection,
+		TCFilterHandle:           p.TCFilterHandle,
+		TCFilterPrio:             p.TCFilterPrio,
+		TCFilterReplace:          p.TCFilterReplace,
+		TCFilterProtocol:         p.TCFilterProtocol,
+		TCDirectActionDisabled:   p.TCDirectActionDisabled,
+		TCAttachMode:             p.TCAttachMode,
+		TCXOrder:                 p.TCXOrder,
+		TCXRelativeTo:            p.TCXRelativeTo,
+		TCXRelativeProgramID:     p.TCXRelativeProgramID,
+		ProbeRetry:               p.ProbeRetry,
+		ProbeRetryDelay:          p.ProbeRetryDelay,
+		RetryPolicy:              p.RetryPolicy,
+		KprobeFallback:           p.KprobeFallback,
+		FreplaceTarget:           p.FreplaceTarget,
+		FreplaceTargetPinPath:    p.FreplaceTargetPinPath,
+		FreplaceTargetFD:         p.FreplaceTargetFD,
+		AttachTarget:             p.AttachTarget,
+		Cookie:                   p.Cookie,
+		Optional:                 p.Optional,
+		LazyLoad:                 p.LazyLoad,
+		KernelModule:             p.KernelModule,
+		WaitForModule:            p.WaitForModule,
+		KernelVersionMin:         p.KernelVersionMin,
+		KernelVersionMax:         p.KernelVersionMax,
+		FeatureCheck:             p.FeatureCheck,
+		Variants:                 append([]ProbeVariant(nil), p.Variants...),
+		Cleanup:                  p.Cleanup,
+		InstructionPatcher:       p.InstructionPatcher,
+		DumpHandler:              p.DumpHandler,
 	}
 }
 
@@ -255,7 +709,8 @@ func (p *Probe) checkField() error {
 		return nil
 	}
 
-	if p.AttachToFuncName == "" {
+	if p.AttachToFuncName == "" && p.USDTName == "" && len(p.AttachToFuncCandidates) == 0 && p.KprobeAddress == 0 &&
+		p.MatchFuncName == "" {
 		return errors.New(fmt.Sprintf("AttachToFuncName:%s cant be null.", p.AttachToFuncName))
 	}
 	return nil
@@ -295,6 +750,41 @@ func (p *Probe) Test(in []byte) (uint32, []byte, error) {
 	return p.program.Test(in)
 }
 
+// checkKernelSupport - Returns an error wrapping ErrProbeUnsupported if the probe can't work on the running kernel, or
+// wrapping ErrMissingPrivileges if the process lacks the privileges it requires
+func (p *Probe) checkKernelSupport() error {
+	if p.variantErr != nil {
+		return p.variantErr
+	}
+	if p.privilegeErr != nil {
+		return p.privilegeErr
+	}
+	return checkKernelRange(p.KernelVersionMin, p.KernelVersionMax, p.FeatureCheck)
+}
+
+// checkKernelRange - Returns an error wrapping ErrProbeUnsupported if the running kernel is older than min or newer
+// than or equal to max, when they are set, or if featureCheck returns an error
+func checkKernelRange(min, max KernelVersion, featureCheck func() error) error {
+	if min != 0 || max != 0 {
+		version, err := CurrentKernelVersion()
+		if err != nil {
+			return fmt.Errorf("error:%w , %v", ErrProbeUnsupported, err)
+		}
+		if min != 0 && version < min {
+			return fmt.Errorf("error:%w , kernel %s is older than %s", ErrProbeUnsupported, version, min)
+		}
+		if max != 0 && version >= max {
+			return fmt.Errorf("error:%w , kernel %s is newer than %s", ErrProbeUnsupported, version, max)
+		}
+	}
+	if featureCheck != nil {
+		if err := featureCheck(); err != nil {
+			return fmt.Errorf("error:%w , %v", ErrProbeUnsupported, err)
+		}
+	}
+	return nil
+}
+
 // Benchmark - Benchmark runs the Program with the given input for a number of times and returns the time taken per
 // iteration.
 //
@@ -334,6 +824,24 @@ func (p *Probe) Program() *ebpf.Program {
 	return p.program
 }
 
+// ProgramID - Returns the kernel ID of the eBPF program of the probe
+func (p *Probe) ProgramID() (ebpf.ProgramID, error) {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	if p.state < initialized || p.program == nil {
+		return 0, fmt.Errorf("error:%w , probe %v", ErrProbeNotInitialized, p.GetIdentificationPair())
+	}
+	info, err := p.program.Info()
+	if err != nil {
+		return 0, fmt.Errorf("error:%w , couldn't get info of probe %v", err, p.GetIdentificationPair())
+	}
+	id, ok := info.ID()
+	if !ok {
+		return 0, fmt.Errorf("couldn't get the program ID of probe %v: not supported by the kernel", p.GetIdentificationPair())
+	}
+	return id, nil
+}
+
 // init - Internal initialization function
 func (p *Probe) init() error {
 	err := p.checkField()
@@ -341,11 +849,35 @@ func (p *Probe) init() error {
 		return err
 	}
 
+	// Lazy probes load their program on their own
+	if p.loadsLazily() && p.program == nil {
+		if err = p.prepareLazyLoad(); err != nil {
+			p.lastError = err
+			return err
+		}
+	}
+
+	// Program extensions and tracing programs of other programs are loaded against their target program
+	if p.isFreplaceSpec() {
+		if err = p.resolveFreplaceTarget(); err != nil {
+			p.lastError = err
+			return err
+		}
+	} else if p.isBPFTracingSpec() {
+		if err = p.resolveTracingTarget(); err != nil {
+			p.lastError = err
+			return err
+		}
+	}
+
 	// Load spec if necessary
 	if p.manualLoadNeeded {
 		prog, err := ebpf.NewProgramWithOptions(p.programSpec, p.manager.options.VerifierOptions.Programs)
 		if err != nil {
 			p.lastError = err
+			if loadErr := newProgramLoadError(p.EbpfFuncName, p.programSpec, err); loadErr != nil {
+				return loadErr
+			}
 			return errors.New(fmt.Sprintf("error:%v , couldn't load new probe %v", err, p.GetIdentificationPair()))
 		}
 		p.program = prog
@@ -387,33 +919,18 @@ func (p *Probe) init() error {
 
 	// Find function name match if required
 	if strings.HasPrefix(p.Section, "kretprobe/") || (strings.HasPrefix(p.Section, "kprobe/")) {
-		// Update syscall function name with the correct arch prefix
-		p.funcName, err = GetSyscallFnNameWithSymFile(p.AttachToFuncName, p.manager.options.SymFile)
-		if err != nil {
+		if err = p.resolveKprobeTarget(); err != nil {
 			p.lastError = err
-			p.funcName, err = FindFilterFunction(p.Section)
-			if err != nil {
-				p.lastError = err
-				return err
-			}
+			return err
 		}
 	}
 
 	// Resolve interface index if one is provided
-	if p.Ifindex == 0 && p.Ifname != "" {
-		inter, err := net.InterfaceByName(p.Ifname)
-		if err != nil {
-			p.lastError = err
-			return errors.New(fmt.Sprintf("error:%v , couldn't find interface %v", err, p.Ifname))
-		}
-
-		// Check if interface is loopback
-		isNetIfaceLo := inter.Flags&net.FlagLoopback == net.FlagLoopback
-		if isNetIfaceLo && p.SkipLoopback {
-			return fmt.Errorf("error:%v , interface %v is loopback and SkipLoopback is set", ErrLoopbackDisabled, p.Ifname)
+	if err := p.resolveIfindex(); err != nil {
+		// the interface is resolved again when the probe is attached, see RetryPolicy
+		if policy := p.attachRetryPolicy(); policy == nil || !policy.retryable(err) {
+			return err
 		}
-
-		p.Ifindex = int32(inter.Index)
 	}
 
 	// Default max active value
@@ -443,7 +960,12 @@ func (p *Probe) init() error {
 // Attach - Attaches the probe to the right hook point in the kernel depending on the program type and the provided
 // parameters.
 func (p *Probe) Attach() error {
-	return retry.Do(func() error {
+	// the lazy probes account for the initial attempt when they are initialized by it
+	attempts := p.ProbeRetry
+	if attempts == 0 {
+		attempts = 1
+	}
+	err := retry.Do(func() error {
 		p.attachRetryAttempt++
 		err := p.attach()
 		if err == nil {
@@ -456,7 +978,11 @@ func (p *Probe) Attach() error {
 		}
 
 		return err
-	}, retry.Attempts(p.ProbeRetry), retry.Delay(p.ProbeRetryDelay), retry.LastErrorOnly(true))
+	}, retry.Attempts(attempts), retry.Delay(p.ProbeRetryDelay), retry.LastErrorOnly(true))
+	if err != nil {
+		p.manager.dispatchFailure(p, &ProbeAttachError{Probe: p.GetIdentificationPair(), Err: err})
+	}
+	return err
 }
 
 // attach - Thread unsafe version of attach
@@ -466,6 +992,18 @@ func (p *Probe) attach() error {
 	if p.state >= running || !p.Enabled {
 		return nil
 	}
+	// The hook point may be defined by a kernel module that isn't loaded yet, see WaitForModule
+	if err := p.checkKernelModule(); err != nil {
+		p.lastError = err
+		return err
+	}
+	// Lazy probes are initialized when they are first attached
+	if p.state < initialized && p.loadsLazily() && p.manager != nil {
+		if err := p.init(); err != nil {
+			p.lastError = err
+			return err
+		}
+	}
 	if p.state < initialized {
 		if p.lastError == nil {
 			p.lastError = ErrProbeNotInitialized
@@ -473,7 +1011,34 @@ func (p *Probe) attach() error {
 		return ErrProbeNotInitialized
 	}
 
-	// Per program type start
+	// Resolve the interface that didn't exist yet when the probe was initialized
+	if err := p.resolveIfindex(); err != nil {
+		return err
+	}
+
+	// Reuse the link pinned by a previous instance of the manager, or per program type start
+	if !p.adoptPinnedLink() {
+		if err := p.attachHook(); err != nil {
+			p.lastError = err
+			// Clean up any progress made in the attach attempt
+			_ = p.stop(false)
+			return errors.New(fmt.Sprintf("error:%v , couldn't start probe %s", err, p.EbpfFuncName))
+		}
+		if err := p.pinLink(); err != nil {
+			p.lastError = err
+			_ = p.stop(false)
+			return err
+		}
+	}
+
+	// update probe state
+	p.state = running
+	p.attachRetryAttempt = p.ProbeRetry
+	return nil
+}
+
+// attachHook - (not thread safe) Attaches the program of the probe to its hook point depending on the program type
+func (p *Probe) attachHook() error {
 	var err error
 	switch p.programSpec.Type {
 	case ebpf.UnspecifiedProgram:
@@ -481,32 +1046,114 @@ func (p *Probe) attach() error {
 	case ebpf.Kprobe:
 		err = p.attachKprobe()
 	case ebpf.PerfEvent:
-		err = p.attachPerfEvent()
+		if p.isSamplingPerfEvent() {
+			err = p.attachSamplingPerfEvent()
+		} else {
+			err = p.attachPerfEvent()
+		}
 	case ebpf.TracePoint:
 		err = p.attachTracepoint()
 	case ebpf.CGroupDevice, ebpf.CGroupSKB, ebpf.CGroupSock, ebpf.SockOps, ebpf.CGroupSockAddr, ebpf.CGroupSockopt, ebpf.CGroupSysctl:
 		err = p.attachCGroup()
 	case ebpf.SocketFilter:
 		err = p.attachSocket()
-	case ebpf.SchedCLS:
-		err = p.attachTCCLS()
-	case ebpf.XDP:
-		err = p.attachXDP()
-	case ebpf.RawTracepoint:
+	case ebpf.SkMsg, ebpf.SkSKB:
+		err = p.attachSockMap()
+	case ebpf.SchedCLS, ebpf.XDP:
+		if p.IfnameMatcher != nil {
+			err = p.attachInterfaceMatching()
+		} else if p.isContainerScoped() {
+			err = p.attachContainerInterfaces()
+		} else if p.programSpec.Type == ebpf.SchedCLS {
+			err = p.attachTCCLS()
+		} else {
+			err = p.attachXDP()
+		}
+	case ebpf.RawTracepoint, ebpf.RawTracepointWritable:
 		err = p.attachRawTracepoint()
+	case ebpf.Tracing:
+		if p.isIterSpec() {
+			err = p.attachIter()
+		} else if p.isBPFTracingSpec() {
+			err = p.attachBPFTracing()
+		} else {
+			err = p.attachTracing()
+		}
+	case ebpf.LSM:
+		err = p.attachLSM()
+	case ebpf.Extension:
+		err = p.attachFreplace()
+	case ebpf.SkLookup:
+		err = p.attachSkLookup()
 	default:
 		err = fmt.Errorf("program type %s not implemented yet", p.programSpec.Type)
 	}
-	if err != nil {
-		p.lastError = err
-		// Clean up any progress made in the attach attempt
-		_ = p.stop(false)
-		return errors.New(fmt.Sprintf("error:%v , couldn't start probe %s", err, p.EbpfFuncName))
+	return err
+}
+
+// updateProgram - Replaces the program of the probe with the provided one, and returns the previous program. If the
+// probe is running, the attachment is updated atomically when the probe is attached through a BPF link, otherwise
+// the previous program is detached and the new one attached. The previous program is attached back on failure, and nil
+// is returned. If only the pinning of the new program or of its link failed, the new program stays attached and the
+// previous program is returned along with the error.
+func (p *Probe) updateProgram(prog *ebpf.Program, spec *ebpf.ProgramSpec) (*ebpf.Program, error) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	oldProg, oldSpec := p.program, p.programSpec
+	if p.state < running {
+		p.program, p.programSpec = prog, spec
+		return oldProg, nil
 	}
 
-	// update probe state
-	p.state = running
-	p.attachRetryAttempt = p.ProbeRetry
+	// BPF_LINK_UPDATE, not supported by perf event based links
+	if p.link != nil && p.link.Update(prog) == nil {
+		p.program, p.programSpec = prog, spec
+		return oldProg, p.repin()
+	}
+
+	if err := p.detach(); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't detach the previous program of probe %s", err, p.EbpfFuncName))
+	}
+	p.link = nil
+	p.program, p.programSpec = prog, spec
+	if err := p.attachHook(); err != nil {
+		_ = p.detach()
+		p.link = nil
+		p.program, p.programSpec = oldProg, oldSpec
+		if errRestore := p.attachHook(); errRestore != nil {
+			p.state = initialized
+			p.lastError = errRestore
+		}
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't attach the new program of probe %s", err, p.EbpfFuncName))
+	}
+	return oldProg, p.repin()
+}
+
+// repin - (not thread safe) pins the program and the link of the probe, replacing the previous pins
+func (p *Probe) repin() error {
+	if err := p.pinLink(); err != nil {
+		return err
+	}
+	if p.PinPath == "" {
+		return nil
+	}
+	_ = os.Remove(p.PinPath)
+	if err := p.program.Pin(p.PinPath); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't pin program %s at %s", err, p.EbpfFuncName, p.PinPath))
+	}
+	return nil
+}
+
+// pinLink - (not thread safe) pins the link of the probe when the PinByName strategy is used. Links that can't be
+// pinned (perf event based links, legacy attachments) are left unpinned.
+func (p *Probe) pinLink() error {
+	if p.linkPinPath == "" || p.link == nil {
+		return nil
+	}
+	_ = os.Remove(p.linkPinPath)
+	if err := p.link.Pin(p.linkPinPath); err != nil && !errors.Is(err, link.ErrNotSupported) {
+		return errors.New(fmt.Sprintf("error:%v , couldn't pin the link of probe %s at %s", err, p.EbpfFuncName, p.linkPinPath))
+	}
 	return nil
 }
 
@@ -540,28 +1187,64 @@ func (p *Probe) detachRetry() error {
 // detach - Thread unsafe version of Detach.
 func (p *Probe) detach() error {
 	var err error
-	// Remove pin if needed
-	if p.PinPath != "" {
+	// Remove pin if needed, the pins of a saved state are left to the next instance of the manager
+	saved := (p.manager != nil && p.manager.stateSaved) && p.exitCleanup != CleanupUnpin
+	leaveAttached := p.exitCleanup == CleanupLeaveAttached
+	if p.PinPath != "" && !saved && !leaveAttached && p.exitCleanup != CleanupDetachOnly {
 		err = ConcatErrors(err, os.Remove(p.PinPath))
 	}
+	if p.linkPinPath != "" && p.link != nil && !saved && !leaveAttached {
+		if errTmp := p.link.Unpin(); errTmp != nil && !errors.Is(errTmp, link.ErrNotSupported) {
+			err = ConcatErrors(err, errTmp)
+		}
+	}
 
 	// Shared with all probes: close the perf event file descriptor
 	if p.link != nil {
 		err = p.link.Close()
 	}
+	if leaveAttached && p.programSpec.Type != ebpf.Kprobe && p.programSpec.Type != ebpf.PerfEvent {
+		// the remaining attachments don't depend on a file descriptor of the process, leave them
+		return err
+	}
 	// Per program type cleanup
 	switch p.programSpec.Type {
 	case ebpf.UnspecifiedProgram:
 		// nothing to do
 		break
 	case ebpf.Kprobe:
-	case ebpf.CGroupDevice, ebpf.CGroupSKB, ebpf.CGroupSock, ebpf.CGroupSockAddr, ebpf.CGroupSockopt, ebpf.CGroupSysctl:
+		if p.kprobeEvent != nil {
+			err = ConcatErrors(err, p.kprobeEvent.Close())
+			p.kprobeEvent = nil
+		}
+		if p.UprobeAttachAllMatching != "" {
+			err = ConcatErrors(err, p.detachUprobeMatching())
+		}
+		if p.pidLinks != nil {
+			err = ConcatErrors(err, p.detachUprobePIDs())
+		}
+		if p.goReturnSites != nil {
+			err = ConcatErrors(err, p.detachGoReturns())
+		}
+		if p.matchedKprobes != nil {
+			err = ConcatErrors(err, p.detachKprobeMatching())
+		}
+	case ebpf.CGroupDevice, ebpf.CGroupSKB, ebpf.CGroupSock, ebpf.SockOps, ebpf.CGroupSockAddr, ebpf.CGroupSockopt, ebpf.CGroupSysctl:
+		err = ConcatErrors(err, p.detachCGroup())
 	case ebpf.SocketFilter:
 		err = ConcatErrors(err, p.detachSocket())
-	case ebpf.SchedCLS:
-		err = ConcatErrors(err, p.detachTCCLS())
-	case ebpf.XDP:
-		err = ConcatErrors(err, p.detachXDP())
+	case ebpf.SkMsg, ebpf.SkSKB:
+		err = ConcatErrors(err, p.detachSockMap())
+	case ebpf.PerfEvent:
+		err = ConcatErrors(err, p.detachSamplingPerfEvent())
+	case ebpf.SchedCLS, ebpf.XDP:
+		if p.IfnameMatcher != nil || p.isContainerScoped() {
+			err = ConcatErrors(err, p.detachInterfaceMatching())
+		} else if p.programSpec.Type == ebpf.SchedCLS {
+			err = ConcatErrors(err, p.detachTCCLS())
+		} else {
+			err = ConcatErrors(err, p.detachXDP())
+		}
 	default:
 		// unsupported section, nothing to do either
 		break
@@ -580,12 +1263,28 @@ func (p *Probe) Stop() error {
 	return p.stop(true)
 }
 
+// stopOnExit - Stops the probe when the manager stops, according to its Cleanup
+func (p *Probe) stopOnExit() error {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	if p.state < running || !p.Enabled {
+		p.reset()
+		return nil
+	}
+	p.exitCleanup = p.Cleanup
+	defer func() {
+		p.exitCleanup = CleanupDefault
+	}()
+	return p.stop(true)
+}
+
 func (p *Probe) stop(saveStopError bool) error {
 	// detach from hook point
 	err := p.detachRetry()
 
-	// close the loaded program
-	if p.attachRetryAttempt >= p.ProbeRetry {
+	// close the loaded program, unless a failed attachment is retried in the background
+	lastAttempt := p.attachRetryAttempt >= p.ProbeRetry && (saveStopError || p.attachRetryPolicy() == nil)
+	if lastAttempt {
 		err = ConcatErrors(err, p.program.Close())
 	}
 	// update state of the probe
@@ -595,7 +1294,7 @@ func (p *Probe) stop(saveStopError bool) error {
 
 	// Cleanup probe if stop was successful
 	if err == nil {
-		if p.attachRetryAttempt >= p.ProbeRetry {
+		if lastAttempt {
 			p.reset()
 		}
 		return nil
@@ -605,6 +1304,7 @@ func (p *Probe) stop(saveStopError bool) error {
 
 // reset - Cleans up the internal fields of the probe
 func (p *Probe) reset() {
+	_ = p.closeTargetProgram()
 	p.manager = nil
 	p.program = nil
 	p.programSpec = nil
@@ -614,15 +1314,46 @@ func (p *Probe) reset() {
 	p.manualLoadNeeded = false
 	p.checkPin = false
 	p.funcName = ""
+	p.kprobeCandidates = nil
+	p.kprobeOffset = 0
+	p.matchedFuncNames = nil
 	p.AttachPID = 0
 	p.attachRetryAttempt = 0
+	p.binaryIdentity = binaryIdentity{}
+	p.replaced = nil
+}
+
+// resolveIfindex - Resolves the index of the interface of the probe from its name, in the network namespace of the
+// probe
+func (p *Probe) resolveIfindex() error {
+	if p.Ifindex != 0 || p.Ifname == "" {
+		return nil
+	}
+	var inter *net.Interface
+	err := runInNetns(p.NetnsPath, func() error {
+		var err error
+		inter, err = net.InterfaceByName(p.Ifname)
+		return err
+	})
+	if err != nil {
+		p.lastError = fmt.Errorf("error:%w , couldn't find interface %v: %v", ErrInterfaceNotFound, p.Ifname, err)
+		return p.lastError
+	}
+
+	// Check if interface is loopback
+	isNetIfaceLo := inter.Flags&net.FlagLoopback == net.FlagLoopback
+	if isNetIfaceLo && p.SkipLoopback {
+		return fmt.Errorf("error:%v , interface %v is loopback and SkipLoopback is set", ErrLoopbackDisabled, p.Ifname)
+	}
+
+	p.Ifindex = int32(inter.Index)
+	p.ifindexResolved = true
+	return nil
 }
 
 // attachKprobe - Attaches the probe to its kprobe
 func (p *Probe) attachKprobe() error {
-	// Prepare kprobe_events line parameters
 	var err error
-	funcName := p.funcName
 	isRet := false
 	if strings.HasPrefix(p.Section, "kretprobe/") {
 		isRet = true
@@ -633,17 +1364,209 @@ func (p *Probe) attachKprobe() error {
 		return p.attachUprobe()
 	}
 
+	if err = p.checkCookie(); err != nil {
+		return err
+	}
+	if len(p.matchedFuncNames) > 0 {
+		return p.attachKprobeMatching(isRet)
+	}
+	candidates := p.kprobeCandidates
+	if len(candidates) == 0 {
+		candidates = []string{p.funcName}
+	}
+	if p.KernelModule != "" {
+		if candidates, err = kernelModuleFuncs(p.KernelModule, candidates, p.manager.options.SymFile); err != nil {
+			return err
+		}
+	}
+	// the candidates may exist but be on the kprobe blacklist, or be notrace
+	var errs error
+	for _, funcName := range candidates {
+		if err = p.attachKprobeTo(funcName, isRet); err == nil {
+			p.funcName = funcName
+			return nil
+		}
+		errs = ConcatErrors(errs, err)
+	}
+	return errs
+}
+
+// attachKprobeTo - Attaches the kprobe, or the kretprobe if isRet is set, to the provided function
+func (p *Probe) attachKprobeTo(funcName string, isRet bool) error {
+	kp, event, method, err := p.openKprobe(funcName, isRet)
+	if err != nil {
+		return err
+	}
+	p.link, p.kprobeEvent, p.kprobeAttachMethod = kp, event, method
+	return nil
+}
+
+// openKprobe - Opens a kprobe, or a kretprobe if isRet is set, on the provided function. Returns either the link of the
+// kprobe or its kprobe_events event, and the method used to attach it.
+func (p *Probe) openKprobe(funcName string, isRet bool) (link.Link, *kprobeEvent, KprobeAttachMethod, error) {
+	var err error
+	// perf_event_open on the kprobe PMU (or on a tracefs event if the PMU is missing), with a bpf_link if available
+	opts := &link.KprobeOptions{Cookie: p.Cookie, Offset: p.kprobeOffset}
 	var kp link.Link
 	if isRet {
-		kp, err = link.Kretprobe(funcName, p.program, nil)
+		// the kprobe PMU doesn't support maxactive, the kretprobe is then created through tracefs
+		opts.RetprobeMaxActive = p.KProbeMaxActive
+		kp, err = link.Kretprobe(funcName, p.program, opts)
 	} else {
-		kp, err = link.Kprobe(funcName, p.program, nil)
+		kp, err = link.Kprobe(funcName, p.program, opts)
+	}
+	if err == nil {
+		method := AttachKprobeWithPerfEventOpen
+		if _, errInfo := kp.Info(); errInfo == nil {
+			method = AttachKprobeWithBPFLink
+		}
+		return kp, nil, method, nil
+	}
+
+	// fall back to the legacy kprobe_events interface, which doesn't support cookies
+	if p.Cookie != 0 {
+		return nil, nil, AttachKprobeMethodNotSet, fmt.Errorf("opening Kprobe: %s, funcName:%s, isRet:%t, section:%s", err, funcName, isRet, p.Section)
+	}
+	symbol := funcName
+	if p.KernelModule != "" {
+		symbol = p.KernelModule + ":" + funcName
+	}
+	if p.kprobeOffset != 0 {
+		symbol = fmt.Sprintf("%s+0x%x", funcName, p.kprobeOffset)
 	}
+	event, errEvent := attachKprobeEvent(p.program, symbol, isRet, p.KProbeMaxActive)
+	if errEvent != nil {
+		return nil, nil, AttachKprobeMethodNotSet, fmt.Errorf("opening Kprobe: %s, kprobe_events fallback: %v, funcName:%s, isRet:%t, section:%s", err, errEvent, funcName, isRet, p.Section)
+	}
+	return nil, event, AttachKprobeWithKprobeEvents, nil
+}
+
+// resolveKprobeTarget - (kprobes) Resolves the function the kprobe is attached to, and the offset of the kprobe in
+// this function
+func (p *Probe) resolveKprobeTarget() error {
+	if p.MatchFuncName != "" {
+		return p.resolveKprobeMatching()
+	}
+	if err := p.resolveKprobeFunc(); err != nil {
+		return err
+	}
+	if p.KprobeOffset == 0 && !p.KprobeBodyOffset {
+		return nil
+	}
+	if strings.HasPrefix(p.Section, "kretprobe/") {
+		return fmt.Errorf("error:%w , kretprobes can't be placed at an offset", ErrInvalidKprobeOffset)
+	}
+	p.kprobeOffset += p.KprobeOffset
+	if p.KprobeBodyOffset {
+		padding, err := FunctionEntryPadding()
+		if err != nil {
+			return fmt.Errorf("error:%w , couldn't compute the entry padding of the kernel functions: %v", ErrInvalidKprobeOffset, err)
+		}
+		p.kprobeOffset += padding
+	}
+	return p.checkKprobeOffset()
+}
 
+// checkKprobeOffset - (kprobes) Drops the candidate functions that are smaller than the offset of the kprobe. The
+// sizes of the functions are deduced from the symbol file, the offset isn't checked if the addresses are hidden.
+func (p *Probe) checkKprobeOffset() error {
+	symbols, err := readKernelSymbols(p.manager.options.SymFile)
 	if err != nil {
-		return fmt.Errorf("opening Kprobe: %s, funcName:%s, isRet:%t, section:%s", err, funcName, isRet, p.Section)
+		return nil
+	}
+	candidates := p.kprobeCandidates
+	if len(candidates) == 0 {
+		candidates = []string{p.funcName}
+	}
+	var valid []string
+	for _, name := range candidates {
+		if size, ok := kernelSymbolSize(symbols, name); !ok || p.kprobeOffset < size {
+			valid = append(valid, name)
+		}
+	}
+	if len(valid) == 0 {
+		return fmt.Errorf("error:%w , 0x%x is outside of %s", ErrInvalidKprobeOffset, p.kprobeOffset, strings.Join(candidates, ", "))
+	}
+	p.funcName = valid[0]
+	if len(p.kprobeCandidates) > 0 {
+		p.kprobeCandidates = valid
+	}
+	return nil
+}
+
+// resolveKprobeFunc - (kprobes) Resolves the function the kprobe is attached to, from KprobeAddress, AttachToFuncName
+// and AttachToFuncCandidates
+func (p *Probe) resolveKprobeFunc() error {
+	var err error
+	symFile := p.manager.options.SymFile
+	p.funcName, p.kprobeCandidates, p.kprobeOffset = "", nil, 0
+	if p.KprobeAddress != 0 {
+		p.funcName, p.kprobeOffset, err = resolveKernelAddress(p.KprobeAddress, symFile)
+		return err
+	}
+	if p.SyscallName != "" && p.AttachToFuncName == "" && len(p.AttachToFuncCandidates) == 0 {
+		if p.kprobeCandidates, err = existingKernelSymbols(SyscallSymbols(p.SyscallName, p.SyscallCompat), symFile); err != nil {
+			return err
+		}
+		p.funcName = p.kprobeCandidates[0]
+		return nil
+	}
+	if p.AttachToFuncName != "" || len(p.AttachToFuncCandidates) == 0 {
+		// Update syscall function name with the correct arch prefix
+		p.funcName, err = GetSyscallFnNameWithSymFile(p.AttachToFuncName, symFile)
+		if err != nil {
+			p.lastError = err
+			if p.funcName, err = FindFilterFunction(p.Section); err != nil {
+				return err
+			}
+		}
+	}
+	if len(p.AttachToFuncCandidates) == 0 {
+		return nil
+	}
+	candidates, err := existingKernelSymbols(p.AttachToFuncCandidates, symFile)
+	if p.funcName == "" {
+		if err != nil {
+			return err
+		}
+		p.funcName = candidates[0]
+	} else {
+		// AttachToFuncName is tried first, even if it isn't in the symbol file
+		candidates = append([]string{p.funcName}, candidates...)
+	}
+	p.kprobeCandidates = candidates
+	return nil
+}
+
+// GetAttachedFuncName - (kprobes) Returns the kernel function the kprobe is attached to, see AttachToFuncCandidates
+func (p *Probe) GetAttachedFuncName() string {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return p.funcName
+}
+
+// GetKprobeAttachMethod - Returns the method that was used to attach the probe, if it is a kprobe or a kretprobe
+func (p *Probe) GetKprobeAttachMethod() KprobeAttachMethod {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return p.kprobeAttachMethod
+}
+
+// GetTCAttachMode - Returns the mode that was used to attach the probe, if it is a TC classifier
+func (p *Probe) GetTCAttachMode() TCAttachMode {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return p.tcAttachMode
+}
+
+// checkCookie - Returns a descriptive error if the probe has a cookie but the kernel doesn't support attach cookies
+func (p *Probe) checkCookie() error {
+	if p.Cookie == 0 {
+		return nil
+	}
+	if err := HaveAttachCookies(); err != nil {
+		return fmt.Errorf("error:%w , couldn't set cookie %d on probe %s", err, p.Cookie, p.EbpfFuncName)
 	}
-	p.link = kp
 	return nil
 }
 
@@ -665,8 +1588,17 @@ func (p *Probe) attachTracepoint() error {
 	}
 	category := traceGroup[1]
 	name := traceGroup[2]
+	if p.SyscallName != "" {
+		category, name = "syscalls", "sys_enter_"+p.SyscallName
+		if strings.HasPrefix(traceGroup[2], "sys_exit") {
+			name = "sys_exit_" + p.SyscallName
+		}
+	}
 
-	kp, err := link.Tracepoint(category, name, p.program, nil)
+	if err := p.checkCookie(); err != nil {
+		return err
+	}
+	kp, err := link.Tracepoint(category, name, p.program, &link.TracepointOptions{Cookie: p.Cookie})
 	if err != nil {
 		return errors.New(fmt.Sprintf("error:%v , couldn's activate tracepoint %s, matchFuncName:%s", err, p.Section, p.EbpfFuncName))
 	}
@@ -692,57 +1624,107 @@ func (p *Probe) attachUprobe() error {
 	// cilium/ebpf新版中不管怎么样都需要一个符号名 不然写入uprobe_events有问题
 	p.funcName = p.AttachToFuncName
 
-	ex, err := link.OpenExecutable(p.BinaryPath)
-	if err != nil {
-		return errors.New(fmt.Sprintf("error:%v , couldn't enable uprobe %s", err, p.EbpfFuncName))
+	if p.UprobeAttachAllMatching != "" {
+		return p.attachUprobeMatching(isRet)
 	}
-	// cilium/ebpf最新版中应当使用Address
-	opts := &link.UprobeOptions{
-		RealFilePath: p.RealFilePath,
-		Offset:       p.UprobeOffset + p.NonElfOffset,
-		Address:      p.UAddress,
-		PID:          p.AttachPID,
+
+	binaryPath := p.binaryPath()
+	if p.UprobeGoReturns {
+		if err := p.attachGoReturns(binaryPath); err != nil {
+			return err
+		}
+		p.binaryIdentity, _ = statBinary(binaryPath)
+		return nil
 	}
-	var kp link.Link
-	if isRet {
-		kp, err = ex.Uretprobe(p.funcName, p.program, opts)
-	} else {
-		kp, err = ex.Uprobe(p.funcName, p.program, opts)
+	if len(p.AttachPIDs) > 0 {
+		if err := p.attachUprobePIDs(binaryPath); err != nil {
+			return err
+		}
+		p.binaryIdentity, _ = statBinary(binaryPath)
+		return nil
 	}
+	kp, err := p.openUprobe(binaryPath, p.binaryRoot(), p.AttachPID, isRet)
 	if err != nil {
-		return fmt.Errorf("opening uprobe: %s , isRet:%t, opts:%v", err, isRet, opts)
+		return err
 	}
 	p.link = kp
+	// remember the binary to notice when it is replaced
+	p.binaryIdentity, _ = statBinary(binaryPath)
 	return nil
 }
 
-// attachCGroup - Attaches the probe to a cgroup hook point
-func (p *Probe) attachCGroup() error {
-	if p.CGroupPath == "" {
-		return errors.New("CGroupPath cant be empty.")
+// openUprobe - Attaches the program of the probe to the binary at the provided path, for the provided process only if
+// pid isn't 0. root is the root filesystem in which the debug files of the binary are looked up first.
+func (p *Probe) openUprobe(binaryPath string, root string, pid int, isRet bool) (link.Link, error) {
+	ex, err := link.OpenExecutable(binaryPath)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't enable uprobe %s", err, p.EbpfFuncName))
+	}
+	// cilium/ebpf最新版中应当使用Address
+	opts := &link.UprobeOptions{
+		RealFilePath: p.RealFilePath,
+		Offset:       p.UprobeOffset + p.NonElfOffset,
+		Address:      p.UAddress,
+		PID:          pid,
+		Cookie:       p.Cookie,
+	}
+	if err = p.checkCookie(); err != nil {
+		return nil, err
 	}
 
-	opts := link.CgroupOptions{
-		Path:    p.CGroupPath,
-		Attach:  p.programSpec.AttachType,
-		Program: p.program,
+	// Resolve the location and the semaphore of the USDT marker
+	if p.USDTName != "" {
+		note, err := FindUSDTNote(binaryPath, p.USDTProvider, p.USDTName)
+		if err != nil {
+			return nil, err
+		}
+		opts.Address = note.Location
+		opts.Offset = p.NonElfOffset
+		opts.RefCtrOffset = note.SemaphoreOffset
+		if p.funcName == "" {
+			p.funcName = fmt.Sprintf("%s_%s", note.Provider, note.Name)
+		}
+	}
+	attach := ex.Uprobe
+	if isRet {
+		attach = ex.Uretprobe
+	}
+	kp, err := attach(p.funcName, p.program, opts)
+	if err != nil && opts.Address == 0 && (errors.Is(err, link.ErrNoSymbol) || errors.Is(err, link.ErrNotSupported)) {
+		// the symbol is stripped or imported from a shared library, look it up in the debug file or in the PLT
+		address, errResolve := resolveUprobeSymbol(binaryPath, p.funcName, root, p.manager.options.DebugFileDirectories)
+		if errResolve != nil {
+			return nil, errResolve
+		}
+		opts.Address = address
+		kp, err = attach(p.funcName, p.program, opts)
 	}
-	kp, err := link.AttachCgroup(opts)
 	if err != nil {
-		return errors.New(fmt.Sprintf("error:%v , failed to attach probe %v to cgroup %s, attach type:%s", err, p.GetIdentificationPair(), p.CGroupPath, p.programSpec.AttachType.String()))
+		return nil, fmt.Errorf("opening uprobe: %s , isRet:%t, opts:%v", err, isRet, opts)
 	}
-
-	p.link = kp
-	return nil
+	return kp, nil
 }
 
-// attachSocket - Attaches the probe to the provided socket
+// attachSocket - Attaches the probe to the provided socket, or to a packet socket opened on its interface
 func (p *Probe) attachSocket() error {
+	if p.SocketFD == 0 && p.Ifindex != 0 {
+		fd, err := p.openPacketSocket()
+		if err != nil {
+			return err
+		}
+		p.packetSocket = fd
+		return nil
+	}
 	return sockAttach(p.SocketFD, p.program.FD())
 }
 
-// detachSocket - Detaches the probe from its socket
+// detachSocket - Detaches the probe from its socket, or closes the packet socket created by the probe
 func (p *Probe) detachSocket() error {
+	if p.packetSocket != 0 {
+		err := unix.Close(p.packetSocket)
+		p.packetSocket = 0
+		return err
+	}
 	return sockDetach(p.SocketFD, p.program.FD())
 }
 
@@ -793,11 +1775,20 @@ func (p *Probe) attachTCCLS() error {
 		return ErrInterfaceNotSet
 	}
 
+	if p.TCAttachMode == TCAttachModeTCX && HaveTCX() == nil {
+		if err = p.attachTCX(); err != nil {
+			return err
+		}
+		p.tcAttachMode = TCAttachModeTCX
+		return nil
+	}
+	p.tcAttachMode = TCAttachModeNetlink
+
 	// Recover the netlink socket of the interface from the manager
-	ntl, ok := p.manager.netlinkCache[netlinkCacheKey{p.Ifindex, p.IfindexNetns}]
+	ntl, ok := p.manager.getNetlinkConnection(netlinkCacheKey{p.Ifindex, p.IfindexNetns, p.NetnsPath})
 	if !ok {
 		// Set up new netlink connection
-		ntl, err = p.manager.newNetlinkConnection(p.Ifindex, p.IfindexNetns)
+		ntl, err = p.manager.newNetlinkConnection(p.Ifindex, p.IfindexNetns, p.NetnsPath)
 		if err != nil {
 			return err
 		}
@@ -823,18 +1814,28 @@ func (p *Probe) attachTCCLS() error {
 		if err.Error() != "netlink receive: file exists" {
 			return errors.New(fmt.Sprintf("error:%v , couldn't add a \", err clsact\" qdisc to interface %v", err, p.Ifindex))
 		}
+	} else {
+		ntl.qdiscCreated = true
 	}
 
 	// Create qdisc filter
 	fd := uint32(p.program.FD())
-	flag := uint32(1)
+	flag := uint32(tc.BpfActDirect)
+	if p.TCDirectActionDisabled {
+		flag = 0
+	}
+	protocol := p.TCFilterProtocol
+	if protocol == 0 {
+		protocol = unix.ETH_P_ALL
+	}
 	filter := tc.Object{
 		Msg: tc.Msg{
 			Family:  unix.AF_UNSPEC,
 			Ifindex: uint32(p.Ifindex),
-			Handle:  0,
+			Handle:  p.TCFilterHandle,
 			Parent:  core.BuildHandle(tc.HandleRoot, uint32(p.NetworkDirection)),
-			Info:    0x300,
+			// the protocol is in network byte order
+			Info: uint32(p.TCFilterPrio)<<16 | uint32(protocol>>8|protocol<<8),
 		},
 		Attribute: tc.Attribute{
 			Kind: "bpf",
@@ -846,23 +1847,70 @@ func (p *Probe) attachTCCLS() error {
 		},
 	}
 
-	// Add qdisc filter
-	err = ntl.rtNetlink.Filter().Add(&filter)
+	// Add qdisc filter, or replace the filter with the same handle and priority if requested
+	var replaced *replacedProgram
+	if p.TCFilterReplace {
+		replaced = p.replacedTCFilter(ntl, filter.Msg)
+		err = ntl.rtNetlink.Filter().Replace(&filter)
+	} else {
+		err = ntl.rtNetlink.Filter().Add(&filter)
+	}
 	if err == nil {
+		p.replaced = replaced
 		p.tcObject = qdisc
+		p.tcFilterObject = p.lookupTCFilter(ntl, filter.Msg)
 		ntl.schedClsCount += 1
 		return nil
 	}
 	return errors.New(fmt.Sprintf("error:%v , couldn't add a %v filter to interface %v: %v", err, p.NetworkDirection, p.Ifindex, err))
 }
 
+// lookupTCFilter - Returns the TC filter of the probe, identified by its program, so that it can be deleted later on.
+// The handle and the priority of the filter might have been picked by the kernel.
+func (p *Probe) lookupTCFilter(ntl *netlinkCacheValue, msg tc.Msg) *tc.Object {
+	info, err := p.program.Info()
+	if err != nil {
+		return nil
+	}
+	id, ok := info.ID()
+	if !ok {
+		return nil
+	}
+	filters, err := ntl.rtNetlink.Filter().Get(&tc.Msg{Family: unix.AF_UNSPEC, Ifindex: msg.Ifindex, Parent: msg.Parent})
+	if err != nil {
+		return nil
+	}
+	for _, filter := range filters {
+		if filter.BPF != nil && filter.BPF.ID != nil && *filter.BPF.ID == uint32(id) {
+			return &tc.Object{
+				Msg: tc.Msg{
+					Family:  unix.AF_UNSPEC,
+					Ifindex: msg.Ifindex,
+					Handle:  filter.Handle,
+					Parent:  msg.Parent,
+					Info:    filter.Info,
+				},
+				Attribute: tc.Attribute{Kind: "bpf", BPF: &tc.Bpf{}},
+			}
+		}
+	}
+	return nil
+}
+
 // detachTCCLS - Detaches the probe from its TC classifier hook point
 func (p *Probe) detachTCCLS() error {
+	if p.tcAttachMode == TCAttachModeTCX {
+		// the tcx link was closed with the other links
+		return nil
+	}
 	// Recover the netlink socket of the interface from the manager
-	ntl, ok := p.manager.netlinkCache[netlinkCacheKey{p.Ifindex, p.IfindexNetns}]
+	ntl, ok := p.manager.getNetlinkConnection(netlinkCacheKey{p.Ifindex, p.IfindexNetns, p.NetnsPath})
 	if !ok {
 		return fmt.Errorf("couldn't find qdisc from which the probe %v was meant to be detached", p.GetIdentificationPair())
 	}
+	if p.manager.options.TCCleanupStrategy == TCCleanupFilters {
+		return p.detachTCFilter(ntl)
+	}
 
 	if ntl.schedClsCount >= 2 {
 		ntl.schedClsCount -= 1
@@ -878,16 +1926,61 @@ func (p *Probe) detachTCCLS() error {
 	return errors.New(fmt.Sprintf("error:%v , couldn't detach TC classifier of probe %v", err, p.GetIdentificationPair()))
 }
 
+// detachTCFilter - Deletes the TC filter of the probe. The clsact qdisc is deleted only if the manager created it and
+// no filter is left on it.
+func (p *Probe) detachTCFilter(ntl *netlinkCacheValue) error {
+	if ntl.schedClsCount > 0 {
+		ntl.schedClsCount -= 1
+	}
+	if p.tcFilterObject == nil {
+		return fmt.Errorf("couldn't find the TC filter of probe %v", p.GetIdentificationPair())
+	}
+	if err := ntl.rtNetlink.Filter().Delete(p.tcFilterObject); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't delete the TC filter of probe %v", err, p.GetIdentificationPair()))
+	}
+	p.tcFilterObject = nil
+
+	if !ntl.qdiscCreated || ntl.schedClsCount > 0 {
+		return nil
+	}
+	for _, direction := range []TrafficType{Ingress, Egress} {
+		filters, err := ntl.rtNetlink.Filter().Get(&tc.Msg{
+			Family:  unix.AF_UNSPEC,
+			Ifindex: uint32(p.Ifindex),
+			Parent:  core.BuildHandle(tc.HandleRoot, uint32(direction)),
+		})
+		if err != nil || len(filters) > 0 {
+			// the qdisc is shared with another tool
+			return nil
+		}
+	}
+	if err := ntl.rtNetlink.Qdisc().Delete(p.tcObject); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't delete the clsact qdisc of interface %v", err, p.Ifindex))
+	}
+	ntl.qdiscCreated = false
+	return nil
+}
+
 // attachXDP - Attaches the probe to an interface with an XDP hook point
 func (p *Probe) attachXDP() error {
-	// Lookup interface
-	nlink, err := netlink.LinkByIndex(int(p.Ifindex))
-	if err != nil {
-		return errors.New(fmt.Sprintf("error:%v , couldn't retrieve interface %v", err, p.Ifindex))
+	if p.XDPUseDispatcher {
+		return p.attachXDPDispatcher()
 	}
+	err := runInNetns(p.NetnsPath, func() error {
+		// Lookup interface
+		nlink, err := netlink.LinkByIndex(int(p.Ifindex))
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't retrieve interface %v", err, p.Ifindex))
+		}
 
-	// Attach program
-	err = netlink.LinkSetXdpFdWithFlags(nlink, p.program.FD(), int(p.XDPAttachMode))
+		// Attach program, the program of the interface is replaced
+		replaced := p.replacedXDPProgram(nlink)
+		if err = netlink.LinkSetXdpFdWithFlags(nlink, p.program.FD(), int(p.XDPAttachMode)); err != nil {
+			return err
+		}
+		p.replaced = replaced
+		return nil
+	})
 	if err == nil {
 		return nil
 	}
@@ -896,23 +1989,32 @@ func (p *Probe) attachXDP() error {
 
 // detachXDP - Detaches the probe from its XDP hook point
 func (p *Probe) detachXDP() error {
-	// Lookup interface
-	nlink, err := netlink.LinkByIndex(int(p.Ifindex))
-	if err != nil {
-		return errors.New(fmt.Sprintf("error:%v , couldn't retrieve interface %v", err, p.Ifindex))
+	if p.xdpDispatcherPrefix != "" {
+		return p.detachXDPDispatcher()
 	}
+	err := runInNetns(p.NetnsPath, func() error {
+		// Lookup interface
+		nlink, err := netlink.LinkByIndex(int(p.Ifindex))
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't retrieve interface %v", err, p.Ifindex))
+		}
 
-	// Detach program
-	err = netlink.LinkSetXdpFdWithFlags(nlink, -1, int(p.XDPAttachMode))
+		// Detach program
+		return netlink.LinkSetXdpFdWithFlags(nlink, -1, int(p.XDPAttachMode))
+	})
 	if err == nil {
 		return nil
 	}
 	return errors.New(fmt.Sprintf("error:%v , couldn't detach XDP program %v from interface %v", err, p.GetIdentificationPair(), p.Ifindex))
 }
 
-// attachRawTracepoint - Attaches the probe to its raw_tracepoint
+// attachRawTracepoint - Attaches the probe to its raw tracepoint: AttachToFuncName if set, the name in the section of
+// the probe otherwise (raw_tracepoint/[name], raw_tp/[name] and their writable .w variants)
 func (p *Probe) attachRawTracepoint() error {
-	name := strings.TrimLeft(p.Section, "raw_tracepoint/")
+	name := p.AttachToFuncName
+	if name == "" {
+		name = p.Section[strings.Index(p.Section, "/")+1:]
+	}
 	link, err := link.AttachRawTracepoint(link.RawTracepointOptions{
 		Name:    name,
 		Program: p.program,
@@ -923,3 +2025,107 @@ func (p *Probe) attachRawTracepoint() error {
 	p.link = link
 	return nil
 }
+
+// isBTFRawTracepointSpec - Returns true if the program of the probe is a BTF-enabled raw tracepoint (tp_btf)
+func (p *Probe) isBTFRawTracepointSpec() bool {
+	return p.programSpec.Type == ebpf.Tracing && p.programSpec.AttachType == ebpf.AttachTraceRawTp
+}
+
+// matchBTFRawTracepointSpec - (tp_btf) Sets the tracepoint the program is attached to, or converts the program to a
+// raw tracepoint when the kernel doesn't support tp_btf programs. The program must then read the memory pointed to by
+// its arguments with bpf_probe_read, like the BPF_CORE_READ macros do.
+func (p *Probe) matchBTFRawTracepointSpec(haveBTFRawTracepoints bool) {
+	name := p.AttachToFuncName
+	if name == "" {
+		name = p.programSpec.AttachTo
+	}
+	if haveBTFRawTracepoints {
+		p.programSpec.AttachTo = name
+		return
+	}
+	p.programSpec.Type = ebpf.RawTracepoint
+	p.programSpec.AttachType = ebpf.AttachNone
+	p.programSpec.AttachTo = ""
+	p.AttachToFuncName = name
+	p.Section = "raw_tracepoint/" + name
+}
+
+// isTrampolineSpec - Returns true if the program of the probe is an fentry or fexit program
+func (p *Probe) isTrampolineSpec() bool {
+	if p.programSpec.Type != ebpf.Tracing {
+		return false
+	}
+	return p.programSpec.AttachType == ebpf.AttachTraceFEntry || p.programSpec.AttachType == ebpf.AttachTraceFExit
+}
+
+// matchTrampolineSpec - (fentry / fexit) Resolves the kernel function the program is attached to, or converts the
+// program to a kprobe / kretprobe when the kernel doesn't support BPF trampolines and KprobeFallback is set.
+func (p *Probe) matchTrampolineSpec(kernelTypes *btf.Spec, symFile string, haveTrampolines bool) error {
+	funcName := p.AttachToFuncName
+	if funcName == "" {
+		funcName = p.programSpec.AttachTo
+	}
+
+	// the functions of the target program are resolved once it is loaded, see AttachTarget
+	if p.AttachTarget != "" {
+		if !haveTrampolines {
+			return fmt.Errorf("error:%w , probe %s", ErrNoTrampolineSupport, p.GetIdentificationPair())
+		}
+		p.programSpec.AttachTo = funcName
+		return nil
+	}
+
+	if !haveTrampolines {
+		if !p.KprobeFallback {
+			return fmt.Errorf("error:%w , probe %s", ErrNoTrampolineSupport, p.GetIdentificationPair())
+		}
+		section := "kprobe/"
+		if p.programSpec.AttachType == ebpf.AttachTraceFExit {
+			section = "kretprobe/"
+		}
+		p.programSpec.Type = ebpf.Kprobe
+		p.programSpec.AttachType = ebpf.AttachNone
+		p.programSpec.AttachTo = ""
+		p.programSpec.Flags &^= unix.BPF_F_SLEEPABLE
+		p.AttachToFuncName = funcName
+		p.Section = section + funcName
+		return nil
+	}
+
+	// Look for the function in the kernel BTF, syscalls are tried with the arch prefix as well
+	if kernelTypes != nil {
+		var fn *btf.Func
+		if err := kernelTypes.TypeByName(funcName, &fn); err != nil {
+			syscallName, errSyscall := GetSyscallFnNameWithSymFile(funcName, symFile)
+			if errSyscall != nil || kernelTypes.TypeByName(syscallName, &fn) != nil {
+				return fmt.Errorf("error:%v , couldn't find function %s in the kernel BTF for probe %s", err, funcName, p.GetIdentificationPair())
+			}
+			funcName = syscallName
+		}
+	}
+	p.programSpec.AttachTo = funcName
+	return nil
+}
+
+// attachTracing - Attaches the probe to its BPF trampoline (fentry / fexit), or to its tracepoint (tp_btf)
+func (p *Probe) attachTracing() error {
+	l, err := link.AttachTracing(link.TracingOptions{Program: p.program})
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn's activate tracing program %s, matchFuncName:%s", err, p.Section, p.EbpfFuncName))
+	}
+	p.link = l
+	return nil
+}
+
+// attachLSM - Attaches the probe to its LSM hook
+func (p *Probe) attachLSM() error {
+	if err := HaveBPFLSM(); err != nil {
+		return err
+	}
+	l, err := link.AttachLSM(link.LSMOptions{Program: p.program})
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn's activate LSM program %s, matchFuncName:%s", err, p.Section, p.EbpfFuncName))
+	}
+	p.link = l
+	return nil
+}
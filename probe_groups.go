@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// hasGroup - Returns true if the probe carries the provided group tag, see Probe.ProbeGroup
+func (p *Probe) hasGroup(group string) bool {
+	for _, g := range p.ProbeGroup {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// groupProbes - Returns the probes of the manager that carry the provided group tag
+func (m *Manager) groupProbes(group string) ([]*Probe, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	var probes []*Probe
+	for _, probe := range m.Probes {
+		if probe.hasGroup(group) {
+			probes = append(probes, probe)
+		}
+	}
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("error:%w , group %s", ErrUnknownProbeGroup, group)
+	}
+	return probes, nil
+}
+
+// ActivateGroup - Enables and attaches all the probes that carry the provided group tag (see Probe.ProbeGroup). The
+// maps and the other probes of the manager are left untouched. If the manager isn't started yet, the probes are
+// attached by Start. The probes skipped on the running kernel stay disabled. The errors of the probes are collected,
+// the other probes of the group are activated anyway.
+func (m *Manager) ActivateGroup(group string) error {
+	probes, err := m.groupProbes(group)
+	if err != nil {
+		return err
+	}
+	m.stateLock.RLock()
+	isRunning := m.state == running
+	m.stateLock.RUnlock()
+
+	var errs error
+	for _, probe := range probes {
+		if probe.skipReason != nil {
+			continue
+		}
+		if !probe.Enabled {
+			probe.Enabled = true
+			if err = probe.Init(m); err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+		}
+		if !isRunning {
+			continue
+		}
+		if err = probe.Attach(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// DeactivateGroup - Detaches and disables all the probes that carry the provided group tag (see Probe.ProbeGroup).
+// Their programs stay loaded, so that ActivateGroup can attach them again. The maps and the other probes of the
+// manager are left untouched.
+func (m *Manager) DeactivateGroup(group string) error {
+	probes, err := m.groupProbes(group)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, probe := range probes {
+		if !probe.Enabled {
+			continue
+		}
+		if err = probe.Detach(); err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		probe.Enabled = false
+	}
+	return errs
+}
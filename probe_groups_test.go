@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestActivateGroup(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "MIT",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	m := &Manager{
+		netlinkCache: make(map[netlinkCacheKey]*netlinkCacheValue),
+		state:        running,
+	}
+	newProbe := func(name string, group string) *Probe {
+		return &Probe{
+			manager:          m,
+			program:          prog,
+			programSpec:      &ebpf.ProgramSpec{Type: ebpf.SchedCLS},
+			Section:          "classifier/" + name,
+			EbpfFuncName:     name,
+			ProbeGroup:       []string{group},
+			Ifindex:          1,
+			NetnsPath:        path,
+			NetworkDirection: Ingress,
+			ProbeRetry:       1,
+		}
+	}
+	dns := newProbe("dns", "dns")
+	tls := newProbe("tls", "tls")
+	m.Probes = []*Probe{dns, tls}
+	defer func() {
+		_ = dns.Detach()
+		_ = tls.Detach()
+	}()
+
+	if err = m.ActivateGroup("dns"); err != nil {
+		t.Skipf("couldn't attach TC classifier: %v", err)
+	}
+	if !dns.IsRunning() || tls.IsRunning() {
+		t.Fatalf("expected only the dns probe to run, dns: %v, tls: %v", dns.IsRunning(), tls.IsRunning())
+	}
+	if err = m.ActivateGroup("tls"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.DeactivateGroup("dns"); err != nil {
+		t.Fatal(err)
+	}
+	if dns.IsRunning() || dns.Enabled || !tls.IsRunning() {
+		t.Errorf("expected only the tls probe to run, dns: %v, tls: %v", dns.IsRunning(), tls.IsRunning())
+	}
+	if err = m.ActivateGroup("file-io"); !errors.Is(err, ErrUnknownProbeGroup) {
+		t.Errorf("expected ErrUnknownProbeGroup, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// AddProbe - Adds a probe to the manager after it was initialized, and attaches it if the manager is running. The probe
+// uses the loaded program of its eBPF function, or a new copy of the program, loaded from the CollectionSpec of the
+// manager, when CopyProgram is set. The probe is then handled like the probes provided at initialization: it is
+// stopped with the manager, reported by the dumps and selected by its identification pair. See RemoveProbe.
+func (m *Manager) AddProbe(probe *Probe) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	id := probe.GetIdentificationPair()
+	if _, exists := m.GetProbe(id); exists {
+		return fmt.Errorf("error:%w , couldn't add probe %v", ErrIdentificationPairInUse, id)
+	}
+	spec, ok := m.collectionSpec.Programs[probe.EbpfFuncName]
+	if !ok || spec == nil {
+		return fmt.Errorf("error:%w , couldn't find program %v", ErrUnknownMatchFuncName, id)
+	}
+	if probe.skipReason = probe.checkKernelSupport(); probe.skipReason != nil {
+		return errors.New(fmt.Sprintf("error:%v , probe %v can't be added", probe.skipReason, id))
+	}
+	probe.Enabled = true
+
+	// Copy the program if requested, or if it wasn't loaded with the collection
+	_, loaded := m.collection.Programs[probe.EbpfFuncName]
+	manualLoad := probe.CopyProgram || !loaded
+	if manualLoad {
+		probe.programSpec = spec.Copy()
+		for name, array := range m.collection.Maps {
+			if err := probe.programSpec.Instructions.AssociateMap(name, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+				return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with %v", err, name, id))
+			}
+		}
+		if probe.InstructionPatcher != nil {
+			if err := probe.InstructionPatcher(probe.programSpec); err != nil {
+				return errors.New(fmt.Sprintf("error:%v , couldn't patch the instructions of %v", err, id))
+			}
+		}
+		if probe.isTrampolineSpec() {
+			if err := probe.matchTrampolineSpec(m.kernelTypes(), m.options.SymFile, HaveTrampolines() == nil); err != nil {
+				return err
+			}
+		}
+		if probe.isBTFRawTracepointSpec() {
+			probe.matchBTFRawTracepointSpec(HaveBTFRawTracepoints() == nil)
+		}
+	} else {
+		probe.programSpec = spec
+	}
+
+	if err := probe.InitWithOptions(m, manualLoad, true); err != nil {
+		// clean up
+		_ = probe.Stop()
+		return errors.New(fmt.Sprintf("error:%v , failed to initialize new probe %v", err, id))
+	}
+	if m.state == running {
+		if err := probe.Attach(); err != nil {
+			// clean up
+			_ = m.releaseProbe(probe)
+			return errors.New(fmt.Sprintf("error:%v , failed to attach new probe %v", err, id))
+		}
+	}
+	m.Probes = append(m.Probes, probe)
+	return nil
+}
+
+// RemoveProbe - Detaches the requested probe and removes it from the manager. Its program is closed, unless it is the
+// program of the collection or it is shared with another probe. See AddProbe.
+func (m *Manager) RemoveProbe(id ProbeIdentificationPair) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	for i, probe := range m.Probes {
+		if !probe.IdentificationPairMatches(id) {
+			continue
+		}
+		if err := m.releaseProbe(probe); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't remove probe %v", err, id))
+		}
+		m.Probes = append(m.Probes[:i], m.Probes[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("error:%w , couldn't find probe %v", ErrUnknownMatchFuncName, id)
+}
+
+// releaseProbe - Detaches the provided probe, and closes its program if the probe owns it: the program isn't the
+// program of the collection, and no other probe of the manager shares it
+func (m *Manager) releaseProbe(probe *Probe) error {
+	prog := probe.program
+	if prog == nil || m.sharedProgram(prog) || m.collection.Programs[probe.EbpfFuncName] == prog {
+		return probe.Detach()
+	}
+	running := probe.IsRunning()
+	if err := probe.Stop(); err != nil {
+		return err
+	}
+	if !running {
+		// Stop only closes the programs of the running probes
+		_ = prog.Close()
+	}
+	// the program of a copied probe is also referenced by the collection
+	for name, collectionProg := range m.collection.Programs {
+		if collectionProg == prog {
+			delete(m.collection.Programs, name)
+		}
+	}
+	return nil
+}
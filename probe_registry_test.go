@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddRemoveProbe(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	elf, err := os.Open("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elf.Close()
+
+	var sockets [3][2]int
+	for i := range sockets {
+		if sockets[i], err = unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(sockets[i][0])
+		defer unix.Close(sockets[i][1])
+	}
+	received := func(fds [2]int) bool {
+		if _, err := unix.Write(fds[0], []byte("packet")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := unix.Read(fds[1], make([]byte, 16))
+		return err == nil
+	}
+
+	// the rewrite program drops all the packets received on the socket
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket", UID: "initial", EbpfFuncName: "rewrite", SocketFD: sockets[0][1]}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	if err = m.Init(elf); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	shared := &Probe{Section: "socket", UID: "shared", EbpfFuncName: "rewrite", SocketFD: sockets[1][1]}
+	copied := &Probe{Section: "socket", UID: "copied", EbpfFuncName: "rewrite", SocketFD: sockets[2][1], CopyProgram: true}
+	for _, probe := range []*Probe{shared, copied} {
+		if err = m.AddProbe(probe); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.AddProbe(&Probe{Section: "socket", UID: "shared", EbpfFuncName: "rewrite"}); !errors.Is(err, ErrIdentificationPairInUse) {
+		t.Errorf("expected ErrIdentificationPairInUse, got %v", err)
+	}
+	for i := range sockets {
+		if received(sockets[i]) {
+			t.Errorf("expected the packets of socket %d to be dropped", i)
+		}
+	}
+	if shared.program != m.Probes[0].program || copied.program == m.Probes[0].program {
+		t.Error("expected only the copied probe to load its own program")
+	}
+	if dump, err := m.GetDump(); err != nil || len(dump.Probes) != 3 {
+		t.Errorf("expected the added probes to be dumped, got %+v (%v)", dump, err)
+	}
+
+	for _, probe := range []*Probe{shared, copied} {
+		if err = m.RemoveProbe(probe.GetIdentificationPair()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !received(sockets[1]) || !received(sockets[2]) {
+		t.Error("expected the removed probes to be detached")
+	}
+	if received(sockets[0]) {
+		t.Error("expected the initial probe to keep filtering its socket")
+	}
+	if _, err = m.Probes[0].ProgramID(); err != nil {
+		t.Errorf("expected the program of the collection to stay open: %v", err)
+	}
+	if err = m.RemoveProbe(shared.GetIdentificationPair()); !errors.Is(err, ErrUnknownMatchFuncName) {
+		t.Errorf("expected ErrUnknownMatchFuncName, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func newTrampolineSpec(attachType ebpf.AttachType, attachTo string) *ebpf.ProgramSpec {
+	return &ebpf.ProgramSpec{
+		Type:       ebpf.Tracing,
+		AttachType: attachType,
+		AttachTo:   attachTo,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	}
+}
+
+func TestTrampolineKprobeFallback(t *testing.T) {
+	for attachType, section := range map[ebpf.AttachType]string{
+		ebpf.AttachTraceFEntry: "kprobe/do_unlinkat",
+		ebpf.AttachTraceFExit:  "kretprobe/do_unlinkat",
+	} {
+		p := &Probe{
+			Section:        "fentry/do_unlinkat",
+			EbpfFuncName:   "trace_unlinkat",
+			KprobeFallback: true,
+			programSpec:    newTrampolineSpec(attachType, "do_unlinkat"),
+		}
+		if err := p.matchTrampolineSpec(nil, "", false); err != nil {
+			t.Fatal(err)
+		}
+		if p.programSpec.Type != ebpf.Kprobe || p.Section != section || p.AttachToFuncName != "do_unlinkat" {
+			t.Errorf("expected a fallback to %s, got %s on %s", section, p.programSpec.Type, p.Section)
+		}
+	}
+
+	p := &Probe{programSpec: newTrampolineSpec(ebpf.AttachTraceFEntry, "do_unlinkat")}
+	if err := p.matchTrampolineSpec(nil, "", false); !errors.Is(err, ErrNoTrampolineSupport) {
+		t.Errorf("expected ErrNoTrampolineSupport without KprobeFallback, got %v", err)
+	}
+}
+
+func TestTrampolineAttachTo(t *testing.T) {
+	kernelTypes, err := btf.LoadKernelSpec()
+	if err != nil {
+		t.Skipf("kernel BTF not available: %v", err)
+	}
+
+	p := &Probe{
+		AttachToFuncName: "bpf_fentry_test1",
+		programSpec:      newTrampolineSpec(ebpf.AttachTraceFEntry, "unknown_function"),
+	}
+	if err = p.matchTrampolineSpec(kernelTypes, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if p.programSpec.AttachTo != "bpf_fentry_test1" {
+		t.Errorf("expected AttachToFuncName to override the section, got %s", p.programSpec.AttachTo)
+	}
+
+	p = &Probe{programSpec: newTrampolineSpec(ebpf.AttachTraceFEntry, "unknown_function")}
+	if err = p.matchTrampolineSpec(kernelTypes, "", true); err == nil {
+		t.Error("expected an error for a function missing from the kernel BTF")
+	}
+}
+
+func TestAttachTracing(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := HaveTrampolines(); err != nil {
+		t.Skip(err)
+	}
+
+	spec := newTrampolineSpec(ebpf.AttachTraceFEntry, "bpf_fentry_test1")
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Probe{
+		manager:      &Manager{},
+		program:      prog,
+		programSpec:  spec,
+		state:        initialized,
+		Section:      "fentry/bpf_fentry_test1",
+		EbpfFuncName: "trace_fentry_test1",
+		Enabled:      true,
+		ProbeRetry:   1,
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if p.link == nil {
+		t.Error("expected the tracing link to be kept")
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTFRawTracepointFallback(t *testing.T) {
+	p := &Probe{
+		Section:      "tp_btf/sched_switch",
+		EbpfFuncName: "trace_sched_switch",
+		programSpec:  newTrampolineSpec(ebpf.AttachTraceRawTp, "sched_switch"),
+	}
+	p.matchBTFRawTracepointSpec(false)
+	if p.programSpec.Type != ebpf.RawTracepoint || p.Section != "raw_tracepoint/sched_switch" {
+		t.Errorf("expected a fallback to a raw tracepoint, got %s on %s", p.programSpec.Type, p.Section)
+	}
+
+	p = &Probe{
+		AttachToFuncName: "sched_wakeup",
+		programSpec:      newTrampolineSpec(ebpf.AttachTraceRawTp, "sched_switch"),
+	}
+	p.matchBTFRawTracepointSpec(true)
+	if p.programSpec.Type != ebpf.Tracing || p.programSpec.AttachTo != "sched_wakeup" {
+		t.Errorf("expected AttachToFuncName to override the section, got %s", p.programSpec.AttachTo)
+	}
+}
+
+func TestAttachRawTracepoint(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	spec := &ebpf.ProgramSpec{
+		Type:    ebpf.RawTracepoint,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	}
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the section prefix must be trimmed, not the characters of the tracepoint name
+	p := &Probe{
+		manager:      &Manager{},
+		program:      prog,
+		programSpec:  spec,
+		state:        initialized,
+		Section:      "raw_tp/task_newtask",
+		EbpfFuncName: "trace_task_newtask",
+		Enabled:      true,
+		ProbeRetry:   1,
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKprobeEventName(t *testing.T) {
+	first := kprobeEventName("utimes_common.isra.0", false)
+	if strings.ContainsAny(first, ".-") || !strings.HasPrefix(first, "p_utimes_common_isra_0_") {
+		t.Errorf("unexpected kprobe event name %s", first)
+	}
+	if second := kprobeEventName("utimes_common.isra.0", false); second == first {
+		t.Errorf("expected unique kprobe event names, got %s twice", first)
+	}
+	if name := kprobeEventName("do_nanosleep", true); !strings.HasPrefix(name, "r_do_nanosleep_") {
+		t.Errorf("unexpected kretprobe event name %s", name)
+	}
+}
+
+func TestAttachKprobeEvent(t *testing.T) {
+	if _, err := tracefsRoot(); err != nil {
+		t.Skip(err)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.Kprobe,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	event, err := attachKprobeEvent(prog, "do_nanosleep", true, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idPath := filepath.Join(event.tracefs, "events", kprobeEventsGroup, event.name, "id")
+	if _, err = os.Stat(idPath); err != nil {
+		t.Errorf("expected the kprobe event to exist: %v", err)
+	}
+	if err = event.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(idPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the kprobe event to be removed, got %v", err)
+	}
+}
+
+func TestAttachSyscallTracepoint(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	spec := &ebpf.ProgramSpec{
+		Type:        ebpf.TracePoint,
+		SectionName: "tracepoint/syscalls/sys_exit",
+		License:     "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	}
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// syscalls/sys_exit doesn't exist, the probe is attached to syscalls/sys_exit_openat
+	p := &Probe{
+		manager:      &Manager{},
+		program:      prog,
+		programSpec:  spec,
+		state:        initialized,
+		Section:      "tracepoint/syscalls/sys_exit",
+		EbpfFuncName: "trace_openat_exit",
+		SyscallName:  "openat",
+		Enabled:      true,
+		ProbeRetry:   1,
+	}
+	if err = p.Attach(); err != nil || !p.IsRunning() {
+		t.Fatalf("expected the probe to be attached: %v", p.GetLastError())
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"fmt"
+)
+
+// ProbeVariant - Program variant of a probe, selected according to the running kernel, see Probe.Variants
+type ProbeVariant struct {
+	// EbpfFuncName - Name of the program of the variant
+	EbpfFuncName string
+
+	// KernelVersionMin - The variant is only selected on kernels newer than or equal to this version
+	KernelVersionMin KernelVersion
+
+	// KernelVersionMax - The variant is only selected on kernels older than this version
+	KernelVersionMax KernelVersion
+
+	// FeatureCheck - The variant is only selected if FeatureCheck returns nil
+	FeatureCheck func() error
+}
+
+// selectProbeVariants - Replaces the program of each probe that declares variants by the first variant that matches
+// the running kernel, and removes the programs of the variants from the CollectionSpec
+func (m *Manager) selectProbeVariants() error {
+	variants := make(map[string]bool)
+	selected := make(map[string]string)
+	for _, probe := range m.Probes {
+		if len(probe.Variants) == 0 {
+			continue
+		}
+		probe.selectedVariant, probe.variantErr = "", nil
+		var reasons []string
+		for _, variant := range probe.Variants {
+			if _, ok := m.collectionSpec.Programs[variant.EbpfFuncName]; !ok {
+				return fmt.Errorf("error:%w , variant %s of %s", ErrUnknownMatchFuncName, variant.EbpfFuncName, probe.GetIdentificationPair())
+			}
+			variants[variant.EbpfFuncName] = true
+			if probe.selectedVariant != "" {
+				continue
+			}
+			if err := checkKernelRange(variant.KernelVersionMin, variant.KernelVersionMax, variant.FeatureCheck); err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s: %v", variant.EbpfFuncName, err))
+				continue
+			}
+			probe.selectedVariant = variant.EbpfFuncName
+		}
+		if previous, ok := selected[probe.EbpfFuncName]; ok && previous != probe.selectedVariant {
+			return fmt.Errorf("error:%w , %s selected variant %s, another probe of %s selected %s", ErrInvalidProbeVariant,
+				probe.GetIdentificationPair(), probe.selectedVariant, probe.EbpfFuncName, previous)
+		}
+		selected[probe.EbpfFuncName] = probe.selectedVariant
+
+		switch _, hasDefault := m.collectionSpec.Programs[probe.EbpfFuncName]; {
+		case probe.selectedVariant != "":
+		case hasDefault:
+			continue
+		default:
+			// keep a placeholder so that the spec of the probe matches, the probe is skipped and its program isn't loaded
+			probe.selectedVariant = probe.Variants[0].EbpfFuncName
+			probe.variantErr = fmt.Errorf("error:%w , no variant matches: %v", ErrProbeUnsupported, reasons)
+		}
+		spec := m.collectionSpec.Programs[probe.selectedVariant].Copy()
+		m.collectionSpec.Programs[probe.EbpfFuncName] = spec
+		if probe.variantErr != nil {
+			probe.selectedVariant = ""
+		}
+	}
+
+	// the variants are loaded under the name of their probe
+	for _, probe := range m.Probes {
+		delete(variants, probe.EbpfFuncName)
+	}
+	for name := range variants {
+		delete(m.collectionSpec.Programs, name)
+	}
+	return nil
+}
+
+// GetSelectedVariant - Returns the name of the program variant selected for the running kernel, "" if the probe
+// doesn't declare variants or if none of them matched, see Variants
+func (p *Probe) GetSelectedVariant() string {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return p.selectedVariant
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestProbeVariants(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	version, err := CurrentKernelVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	initManager := func(options Options, variants ...ProbeVariant) *Manager {
+		t.Helper()
+		elf, err := os.Open("testdata/rewrite.elf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer elf.Close()
+		m := &Manager{
+			Probes: []*Probe{{Section: "socket", EbpfFuncName: "rewrite", Variants: variants}},
+			Maps:   []*Map{{Name: "map_val"}},
+		}
+		if err = m.InitWithOptions(elf, options); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = m.Stop(CleanAll)
+		})
+		return m
+	}
+
+	// the first matching variant is loaded under the name of the probe
+	m := initManager(Options{},
+		ProbeVariant{EbpfFuncName: "rewrite_map", KernelVersionMax: version},
+		ProbeVariant{EbpfFuncName: "rewrite_map", KernelVersionMin: version},
+	)
+	if variant := m.Probes[0].GetSelectedVariant(); variant != "rewrite_map" {
+		t.Errorf("expected variant rewrite_map, got %q", variant)
+	}
+	if spec := m.collectionSpec.Programs["rewrite"]; spec == nil || spec.Name != "rewrite_map" {
+		t.Errorf("expected the program of the probe to be replaced by its variant, got %v", spec)
+	}
+	if _, found := m.collection.Programs["rewrite_map"]; found {
+		t.Error("expected the variant to be loaded only once")
+	}
+
+	// the program of the probe is the default variant
+	m = initManager(Options{}, ProbeVariant{EbpfFuncName: "rewrite_map", FeatureCheck: func() error {
+		return errors.New("missing feature")
+	}})
+	if variant := m.Probes[0].GetSelectedVariant(); variant != "" || m.collectionSpec.Programs["rewrite"].Name != "rewrite" {
+		t.Errorf("expected the default program, got variant %q", variant)
+	}
+
+	// without a default program, the probe is skipped
+	m = initManager(Options{ExcludedEbpfFuncs: []string{"rewrite"}},
+		ProbeVariant{EbpfFuncName: "rewrite_map", KernelVersionMin: version + 1})
+	if status := m.Probes[0].status(); !errors.Is(status.SkipReason, ErrProbeUnsupported) {
+		t.Errorf("expected the probe to be skipped, got %+v", status)
+	}
+	if len(m.collection.Programs) != 0 {
+		t.Errorf("expected no program to be loaded, got %v", m.collection.Programs)
+	}
+}
@@ -0,0 +1,175 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// ProgramStats - Runtime statistics of a program of the manager, see Manager.GetProgramStats
+type ProgramStats struct {
+	ProbeIdentificationPair
+
+	// Section - Section of the program
+	Section string
+
+	// ProgramID - ID of the program in the kernel
+	ProgramID ebpf.ProgramID
+
+	// RunCount - Number of times the program ran while the statistics were enabled
+	RunCount uint64
+
+	// RunTime - Cumulated run time of the program while the statistics were enabled
+	RunTime time.Duration
+}
+
+// AverageRunTime - Returns the average run time of the program
+func (s ProgramStats) AverageRunTime() time.Duration {
+	if s.RunCount == 0 {
+		return 0
+	}
+	return s.RunTime / time.Duration(s.RunCount)
+}
+
+// EnableProgramStats - Enables the collection of the runtime statistics of the eBPF programs with BPF_ENABLE_STATS
+// (kernel 5.8+), see GetProgramStats. The statistics are collected for all the programs of the system, until
+// DisableProgramStats is called or the manager is stopped. Setting the kernel.bpf_stats_enabled sysctl has the same
+// effect.
+func (m *Manager) EnableProgramStats() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.programStats != nil {
+		return nil
+	}
+	stats, err := ebpf.EnableStats(unix.BPF_STATS_RUN_TIME)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't enable the runtime statistics of the programs", err))
+	}
+	m.programStats = stats
+	return nil
+}
+
+// DisableProgramStats - Stops the collection of the runtime statistics enabled by EnableProgramStats. The statistics
+// are still collected if the kernel.bpf_stats_enabled sysctl is set, or if another process enabled them.
+func (m *Manager) DisableProgramStats() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	return m.disableProgramStats()
+}
+
+// disableProgramStats - Closes the statistics file descriptor of the manager, the state lock must be held
+func (m *Manager) disableProgramStats() error {
+	if m.programStats == nil {
+		return nil
+	}
+	err := m.programStats.Close()
+	m.programStats = nil
+	return err
+}
+
+// programStatsSysctl - Returns true if the kernel.bpf_stats_enabled sysctl is set
+func programStatsSysctl() bool {
+	value, err := os.ReadFile("/proc/sys/kernel/bpf_stats_enabled")
+	return err == nil && strings.TrimSpace(string(value)) == "1"
+}
+
+// GetProgramStats - Returns the runtime statistics of the programs of the manager, from the hottest to the coldest
+// one. The programs that aren't loaded by a probe, like tail calls, are identified by their function name. The
+// statistics are only collected when EnableProgramStats was called or when the kernel.bpf_stats_enabled sysctl is set.
+func (m *Manager) GetProgramStats() ([]ProgramStats, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+
+	var stats []ProgramStats
+	seen := make(map[*ebpf.Program]struct{})
+	add := func(id ProbeIdentificationPair, section string, prog *ebpf.Program) error {
+		if prog == nil {
+			return nil
+		}
+		if _, ok := seen[prog]; ok {
+			return nil
+		}
+		seen[prog] = struct{}{}
+		info, err := prog.Info()
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't get the info of program %s", err, id))
+		}
+		programStats := ProgramStats{ProbeIdentificationPair: id, Section: section}
+		programStats.ProgramID, _ = info.ID()
+		programStats.RunCount, _ = info.RunCount()
+		programStats.RunTime, _ = info.Runtime()
+		stats = append(stats, programStats)
+		return nil
+	}
+
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		prog := probe.program
+		probe.stateLock.RUnlock()
+		if err := add(probe.GetIdentificationPair(), probe.Section, prog); err != nil {
+			return nil, err
+		}
+	}
+	for name, prog := range m.collection.Programs {
+		var section string
+		if m.collectionSpec != nil && m.collectionSpec.Programs[name] != nil {
+			section = m.collectionSpec.Programs[name].SectionName
+		}
+		if err := add(ProbeIdentificationPair{EbpfFuncName: name}, section, prog); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].RunTime != stats[j].RunTime {
+			return stats[i].RunTime > stats[j].RunTime
+		}
+		return stats[i].EbpfFuncName < stats[j].EbpfFuncName
+	})
+	return stats, nil
+}
+
+// DumpProgramStats - Returns a human readable table of the runtime statistics of the programs of the manager, see
+// GetProgramStats
+func (m *Manager) DumpProgramStats() (string, error) {
+	stats, err := m.GetProgramStats()
+	if err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	w := tabwriter.NewWriter(&output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PROGRAM\tUID\tSECTION\tID\tRUN_COUNT\tRUN_TIME\tAVG_RUN_TIME")
+	for _, s := range stats {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", s.EbpfFuncName, s.UID, s.Section, s.ProgramID, s.RunCount, s.RunTime, s.AverageRunTime())
+	}
+	_ = w.Flush()
+	return output.String(), nil
+}
+
+// Dump - Returns a human readable dump of the manager: the runtime statistics of its programs, the accounting of the
+// samples of its perf maps and ring buffers (see DumpEventStats), followed by the dump of its maps (see DumpMaps)
+func (m *Manager) Dump() (string, error) {
+	programs, err := m.DumpProgramStats()
+	if err != nil {
+		return "", err
+	}
+	events, err := m.DumpEventStats()
+	if err != nil {
+		return "", err
+	}
+	maps, err := m.DumpMaps()
+	if err != nil {
+		return "", err
+	}
+	return programs + events + maps, nil
+}
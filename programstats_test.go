@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestGetProgramStats(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	newProgram := func() *ebpf.Program {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Type:         ebpf.SocketFilter,
+			License:      "GPL",
+			Instructions: asm.Instructions{asm.Mov.Imm(asm.R0, 0), asm.Return()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+	hot, cold := newProgram(), newProgram()
+	defer hot.Close()
+	defer cold.Close()
+
+	m := &Manager{
+		collection: &ebpf.Collection{Programs: map[string]*ebpf.Program{"cold": cold}},
+		state:      initialized,
+		Probes:     []*Probe{{Section: "socket/hot", EbpfFuncName: "hot", program: hot}},
+	}
+	if err := m.EnableProgramStats(); err != nil {
+		t.Skip(err)
+	}
+	defer m.DisableProgramStats()
+	if _, _, _, err := m.TestRunProgram("hot", make([]byte, 64), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.GetProgramStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 programs, got %d", len(stats))
+	}
+	if stats[0].EbpfFuncName != "hot" || stats[0].RunCount != 100 {
+		t.Errorf("expected the hot program to run 100 times, got %+v", stats[0])
+	}
+	if stats[1].EbpfFuncName != "cold" || stats[1].RunCount != 0 {
+		t.Errorf("expected the cold program not to run, got %+v", stats[1])
+	}
+	if !m.Metrics().ProgramStatsEnabled {
+		t.Error("expected the statistics to be reported as enabled")
+	}
+}
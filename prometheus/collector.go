@@ -0,0 +1,107 @@
+// Package prometheus exports the telemetry of an eBPF manager as prometheus metrics. The package is a separate module,
+// so that the manager doesn't depend on the prometheus client.
+package prometheus
+
+import (
+	"strconv"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+// Collector - prometheus.Collector exporting the telemetry of an eBPF manager. The telemetry is collected at scrape
+// time, see manager.Manager.Metrics.
+type Collector struct {
+	manager *manager.Manager
+
+	probeAttached   *prom.Desc
+	probeRunCount   *prom.Desc
+	probeRunTime    *prom.Desc
+	perfReadErrors  *prom.Desc
+	perfRawSamples  *prom.Desc
+	perfLostSamples *prom.Desc
+	perfUserDrops   *prom.Desc
+	perfMapRunning  *prom.Desc
+	openMaps        *prom.Desc
+	droppedEvents   *prom.Desc
+	statsEnabled    *prom.Desc
+}
+
+// NewCollector - Creates a new collector for the provided manager. All the metrics are prefixed with namespace.
+func NewCollector(m *manager.Manager, namespace string) *Collector {
+	probeLabels := []string{"uid", "ebpf_func_name", "section"}
+	perfLabels := []string{"perf_map", "cpu"}
+	return &Collector{
+		manager: m,
+		probeAttached: prom.NewDesc(prom.BuildFQName(namespace, "probe", "attached"),
+			"1 if the probe is attached to its hook point, 0 otherwise", probeLabels, nil),
+		probeRunCount: prom.NewDesc(prom.BuildFQName(namespace, "probe", "run_count_total"),
+			"Number of times the program of the probe ran, requires the runtime statistics of the programs", probeLabels, nil),
+		probeRunTime: prom.NewDesc(prom.BuildFQName(namespace, "probe", "run_time_seconds_total"),
+			"Cumulated run time of the program of the probe, requires the runtime statistics of the programs", probeLabels, nil),
+		perfReadErrors: prom.NewDesc(prom.BuildFQName(namespace, "perf_map", "read_errors_total"),
+			"Number of errors returned by the perf ring reader", []string{"perf_map"}, nil),
+		perfRawSamples: prom.NewDesc(prom.BuildFQName(namespace, "perf_map", "raw_samples_bytes_total"),
+			"Number of bytes read from the perf ring buffer", perfLabels, nil),
+		perfLostSamples: prom.NewDesc(prom.BuildFQName(namespace, "perf_map", "lost_samples_total"),
+			"Number of samples dropped by the kernel because the perf ring buffer was full", perfLabels, nil),
+		perfUserDrops: prom.NewDesc(prom.BuildFQName(namespace, "perf_map", "userspace_drops_total"),
+			"Number of samples dropped in userspace because the event queue of the manager was full", []string{"perf_map"}, nil),
+		perfMapRunning: prom.NewDesc(prom.BuildFQName(namespace, "perf_map", "running"),
+			"1 if the perf ring reader is running, 0 otherwise", []string{"perf_map"}, nil),
+		openMaps: prom.NewDesc(prom.BuildFQName(namespace, "maps", "open"),
+			"Number of eBPF maps held open by the manager", nil, nil),
+		droppedEvents: prom.NewDesc(prom.BuildFQName(namespace, "events", "dropped_total"),
+			"Number of samples dropped in userspace because the event queue of the manager was full", nil, nil),
+		statsEnabled: prom.NewDesc(prom.BuildFQName(namespace, "program_stats", "enabled"),
+			"1 if the runtime statistics of the programs are collected, 0 otherwise", nil, nil),
+	}
+}
+
+// Describe - Implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.probeAttached
+	ch <- c.probeRunCount
+	ch <- c.probeRunTime
+	ch <- c.perfReadErrors
+	ch <- c.perfRawSamples
+	ch <- c.perfLostSamples
+	ch <- c.perfUserDrops
+	ch <- c.perfMapRunning
+	ch <- c.openMaps
+	ch <- c.droppedEvents
+	ch <- c.statsEnabled
+}
+
+// Collect - Implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	metrics := c.manager.Metrics()
+	for _, probe := range metrics.Probes {
+		labels := []string{probe.UID, probe.EbpfFuncName, probe.Section}
+		ch <- prom.MustNewConstMetric(c.probeAttached, prom.GaugeValue, boolToFloat(probe.Attached), labels...)
+		ch <- prom.MustNewConstMetric(c.probeRunCount, prom.CounterValue, float64(probe.RunCount), labels...)
+		ch <- prom.MustNewConstMetric(c.probeRunTime, prom.CounterValue, probe.RunTime.Seconds(), labels...)
+	}
+	for _, perfMap := range metrics.PerfMaps {
+		ch <- prom.MustNewConstMetric(c.perfMapRunning, prom.GaugeValue, boolToFloat(perfMap.Running), perfMap.Name)
+		ch <- prom.MustNewConstMetric(c.perfReadErrors, prom.CounterValue, float64(perfMap.ReadErrors), perfMap.Name)
+		ch <- prom.MustNewConstMetric(c.perfUserDrops, prom.CounterValue, float64(perfMap.UserspaceDrops), perfMap.Name)
+		for cpu, value := range perfMap.RawSamples {
+			ch <- prom.MustNewConstMetric(c.perfRawSamples, prom.CounterValue, float64(value), perfMap.Name, strconv.Itoa(cpu))
+		}
+		for cpu, value := range perfMap.LostSamples {
+			ch <- prom.MustNewConstMetric(c.perfLostSamples, prom.CounterValue, float64(value), perfMap.Name, strconv.Itoa(cpu))
+		}
+	}
+	ch <- prom.MustNewConstMetric(c.openMaps, prom.GaugeValue, float64(metrics.OpenMaps))
+	ch <- prom.MustNewConstMetric(c.droppedEvents, prom.CounterValue, float64(metrics.DroppedEvents))
+	ch <- prom.MustNewConstMetric(c.statsEnabled, prom.GaugeValue, boolToFloat(metrics.ProgramStatsEnabled))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	manager "github.com/gojue/ebpfmanager"
+)
+
+func TestCollector(t *testing.T) {
+	stats := manager.NewPerfMapStats()
+	stats.ReadErrors = 2
+	stats.LostSamples[0] = 3
+	m := &manager.Manager{
+		PerfMaps: []*manager.PerfMap{{
+			Map:            manager.Map{Name: "events"},
+			PerfMapOptions: manager.PerfMapOptions{PerfMapStats: stats},
+		}},
+	}
+
+	registry := prom.NewRegistry()
+	if err := registry.Register(NewCollector(m, "agent")); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[family.GetName()] += metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[family.GetName()] += metric.GetGauge().GetValue()
+			}
+		}
+	}
+	for name, expected := range map[string]float64{
+		"agent_perf_map_read_errors_total":     2,
+		"agent_perf_map_lost_samples_total":    3,
+		"agent_perf_map_running":               0,
+		"agent_maps_open":                      0,
+		"agent_perf_map_userspace_drops_total": 0,
+		"agent_events_dropped_total":           0,
+	} {
+		if value, ok := values[name]; !ok || value != expected {
+			t.Errorf("expected %s to be %v, got %v", name, expected, values[name])
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/sys/unix"
+)
+
+// readerPoolBatch - Maximum number of records a worker of the reader pool reads from a ring buffer before it moves on
+// to the other readable sources, so that a busy ring buffer doesn't starve them
+const readerPoolBatch = 1024
+
+// readerPoolSource - Perf map or ring buffer serviced by a reader pool: fd returns the fd that becomes readable when
+// records are available (-1 once the source is stopped), read dispatches them without blocking
+type readerPoolSource struct {
+	pool         *readerPool
+	id           int32
+	fd           func() int
+	read         func()
+	registeredFD int
+}
+
+// readerPool - Small pool of goroutines reading the perf maps and ring buffers of a manager, multiplexed over a shared
+// epoll instance, see Options.ReaderPoolSize. The fds are registered with EPOLLONESHOT so that a source is read by one
+// worker at a time, and re-armed once its records were dispatched.
+type readerPool struct {
+	epollFD int
+	closeFD int
+	lock    sync.Mutex
+	sources map[int32]*readerPoolSource
+	nextID  int32
+	workers sync.WaitGroup
+	report  func(err error)
+}
+
+// newReaderPool - Starts a reader pool of the provided number of workers. The errors of the workers are reported to
+// report.
+func newReaderPool(workers int, report func(err error)) (_ *readerPool, err error) {
+	p := &readerPool{epollFD: -1, closeFD: -1, sources: make(map[int32]*readerPoolSource), report: report}
+	defer func() {
+		if err != nil {
+			p.cleanup()
+		}
+	}()
+	if p.epollFD, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't create the epoll instance of the reader pool", err))
+	}
+	if p.closeFD, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't create the eventfd of the reader pool", err))
+	}
+	// the eventfd is never read: once written, it wakes up all the workers
+	if err = unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_ADD, p.closeFD, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: -1}); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't watch the eventfd of the reader pool", err))
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+// add - Registers a source in the pool, watching its current fd. Called by the source while it holds its lock, so fd
+// isn't called until the source was read.
+func (p *readerPool) add(current int, fd func() int, read func()) (*readerPoolSource, error) {
+	source := &readerPoolSource{pool: p, fd: fd, read: read, registeredFD: current}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.nextID++
+	source.id = p.nextID
+	event := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLONESHOT, Fd: source.id}
+	if err := unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_ADD, source.registeredFD, &event); err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't add fd %d to the reader pool", err, source.registeredFD))
+	}
+	p.sources[source.id] = source
+	return source, nil
+}
+
+// remove - Removes the source from its pool. A worker might still be reading it, in which case the source isn't
+// re-armed.
+func (s *readerPoolSource) remove() {
+	p := s.pool
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.sources[s.id] != s {
+		return
+	}
+	delete(p.sources, s.id)
+	_ = unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_DEL, s.registeredFD, nil)
+}
+
+// work - Reads the sources that become readable until the pool is closed
+func (p *readerPool) work() {
+	defer p.workers.Done()
+	// one event at a time, so that the readable sources are spread over the workers
+	events := make([]unix.EpollEvent, 1)
+	for {
+		n, err := unix.EpollWait(p.epollFD, events, -1)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			p.report(errors.New(fmt.Sprintf("error:%v , a worker of the reader pool stopped", err)))
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		if events[0].Fd < 0 {
+			return
+		}
+		p.lock.Lock()
+		source := p.sources[events[0].Fd]
+		p.lock.Unlock()
+		if source == nil {
+			continue
+		}
+		source.read()
+		p.rearm(source)
+	}
+}
+
+// rearm - Watches the source again once its records were dispatched. The fd of a perf map changes when its perf ring
+// buffers are resized, in which case the new fd replaces the previous one.
+func (p *readerPool) rearm(source *readerPoolSource) {
+	// fd takes the lock of the perf map or ring buffer, which is held while they remove themselves from the pool
+	fd := source.fd()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.sources[source.id] != source || fd < 0 {
+		return
+	}
+	event := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLONESHOT, Fd: source.id}
+	if fd == source.registeredFD {
+		if err := unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_MOD, fd, &event); err != nil {
+			p.report(errors.New(fmt.Sprintf("error:%v , couldn't re-arm fd %d in the reader pool", err, fd)))
+		}
+		return
+	}
+	// the previous fd may already be closed, which removed it from the epoll instance
+	_ = unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_DEL, source.registeredFD, nil)
+	source.registeredFD = fd
+	if err := unix.EpollCtl(p.epollFD, unix.EPOLL_CTL_ADD, fd, &event); err != nil {
+		p.report(errors.New(fmt.Sprintf("error:%v , couldn't add fd %d to the reader pool", err, fd)))
+	}
+}
+
+// close - Stops the workers once they are done with the records they are reading, and releases the pool
+func (p *readerPool) close() {
+	var one [8]byte
+	nativeEndian.PutUint64(one[:], 1)
+	_, _ = unix.Write(p.closeFD, one[:])
+	p.workers.Wait()
+	p.cleanup()
+}
+
+// cleanup - Closes the fds of the pool
+func (p *readerPool) cleanup() {
+	if p.closeFD >= 0 {
+		_ = unix.Close(p.closeFD)
+		p.closeFD = -1
+	}
+	if p.epollFD >= 0 {
+		_ = unix.Close(p.epollFD)
+		p.epollFD = -1
+	}
+}
+
+// startReaderPool - Starts the reader pool of the manager if Options.ReaderPoolSize is set
+func (m *Manager) startReaderPool() error {
+	if m.options.ReaderPoolSize <= 0 || m.readerPool != nil {
+		return nil
+	}
+	pool, err := newReaderPool(m.options.ReaderPoolSize, m.reportError)
+	if err != nil {
+		return err
+	}
+	m.readerPool = pool
+	return nil
+}
+
+// stopReaderPool - Stops the reader pool of the manager, once the perf maps and ring buffers were stopped
+func (m *Manager) stopReaderPool() {
+	if m.readerPool == nil {
+		return
+	}
+	m.readerPool.close()
+	m.readerPool = nil
+}
+
+// usePool - Returns true if the perf map can be read by the reader pool of its manager. The perf ring buffers are then
+// opened with a perCPURecordReader, whose epoll fd is watched by the pool.
+func (m *PerfMap) usePool() bool {
+	return m.manager != nil && m.manager.readerPool != nil && !m.TestMode && !m.ExternalPolling && !m.Overwritable &&
+		m.PollTimeout == 0 && m.array.Type() != ebpf.RingBuf
+}
+
+// pooledFD - Returns the epoll fd of the perf ring buffers of the perf map, -1 once it is stopped
+func (m *PerfMap) pooledFD() int {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if reader, ok := m.perfReader.(*perCPURecordReader); ok && m.state >= paused {
+		return reader.epollFD
+	}
+	return -1
+}
+
+// readPooled - Dispatches the samples available in the perf ring buffers, called by a worker of the reader pool
+func (m *PerfMap) readPooled() {
+	m.stateLock.RLock()
+	reader, ok := m.perfReader.(*perCPURecordReader)
+	isRunning := m.state >= paused
+	m.stateLock.RUnlock()
+	if !ok || !isRunning {
+		return
+	}
+	n, err := m.readAvailable(reader)
+	m.activity.addRecords(n)
+	if err == nil || errors.Is(err, perf.ErrClosed) {
+		return
+	}
+	if m.PerfMapStats != nil {
+		m.PerfMapStats.countReadError()
+	}
+	m.manager.reportError(&PerfReadError{Map: m.Name, Err: err})
+	if m.PerfErrChan != nil {
+		m.PerfErrChan <- err
+	}
+}
+
+// pooledFD - Returns the fd of the ring buffer, -1 once it is stopped
+func (rb *RingBuffer) pooledFD() int {
+	rb.stateLock.RLock()
+	defer rb.stateLock.RUnlock()
+	if rb.reader == nil || rb.state < paused {
+		return -1
+	}
+	return rb.array.FD()
+}
+
+// readPooled - Dispatches the samples available in the ring buffer, called by a worker of the reader pool. The
+// deadline of the reader is in the past, so that it doesn't wait for new samples.
+func (rb *RingBuffer) readPooled() {
+	rb.stateLock.RLock()
+	reader := rb.reader
+	isRunning := rb.state >= paused
+	rb.stateLock.RUnlock()
+	if reader == nil || !isRunning {
+		return
+	}
+	var record ringbuf.Record
+	for i := 0; i < readerPoolBatch; i++ {
+		err := reader.ReadInto(&record)
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, ringbuf.ErrClosed) {
+			return
+		}
+		if err != nil {
+			rb.manager.reportError(&PerfReadError{Map: rb.Name, Err: err})
+			if rb.ErrChan != nil {
+				rb.ErrChan <- err
+			}
+			return
+		}
+		rb.activity.addRecords(1)
+		rb.handleSample(record.RawSample)
+	}
+}
+
+// startPooled - Registers the ring buffer in the reader pool of its manager
+func (rb *RingBuffer) startPooled() error {
+	rb.reader.SetDeadline(time.Now())
+	rb.activity.beginRead()
+	source, err := rb.manager.readerPool.add(rb.array.FD(), rb.pooledFD, rb.readPooled)
+	if err != nil {
+		return err
+	}
+	rb.poolSource = source
+	return nil
+}
@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// newPerfOutputProgram - Returns an XDP program that writes the 4 bytes sample 0x01020304 on the perf ring buffer of
+// the current CPU
+func newPerfOutputProgram(t *testing.T, array *ebpf.Map) *ebpf.Program {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -8, 0x01020304, asm.Word),
+			asm.LoadMapPtr(asm.R2, array.FD()),
+			asm.LoadImm(asm.R3, 0xffffffff, asm.DWord),
+			asm.Mov.Reg(asm.R4, asm.RFP),
+			asm.Add.Imm(asm.R4, -8),
+			asm.Mov.Imm(asm.R5, 4),
+			asm.FnPerfEventOutput.Call(),
+			asm.Mov.Imm(asm.R0, 2),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestReaderPool(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	samples := make(chan string, 10)
+	resize := make(chan error, 1)
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		options:    Options{ReaderPoolSize: 1},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{}},
+	}
+	if err := m.startReaderPool(); err != nil {
+		t.Fatal(err)
+	}
+
+	// two perf maps and a ring buffer serviced by a single reader
+	var programs []*ebpf.Program
+	defer func() {
+		for _, prog := range programs {
+			prog.Close()
+		}
+	}()
+	for _, name := range []string{"events_a", "events_b"} {
+		array, err := ebpf.NewMap(&ebpf.MapSpec{Name: name, Type: ebpf.PerfEventArray})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.collection.Maps[name] = array
+		perfMap := &PerfMap{
+			Map: Map{Name: name},
+			PerfMapOptions: PerfMapOptions{
+				PerfRingBufferSize: os.Getpagesize(),
+				AutoResizeMaxSize:  4 * os.Getpagesize(),
+				DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+					if perfMap.Name == "events_a" && len(resize) == 0 && perfMap.PerfRingBufferSize == os.Getpagesize() {
+						// resized from the reader pool, like with AutoResizeLostThreshold
+						resize <- perfMap.resize()
+					}
+					samples <- perfMap.Name
+				},
+			},
+		}
+		m.PerfMaps = append(m.PerfMaps, perfMap)
+		if err = perfMap.Init(m); err != nil {
+			t.Fatal(err)
+		}
+		if err = perfMap.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if !perfMap.pooled {
+			t.Fatalf("expected perf map %s to be read by the reader pool", name)
+		}
+		programs = append(programs, newPerfOutputProgram(t, array))
+	}
+	ringBufferArray, err := m.NewRingBuffer(ebpf.MapSpec{Name: "events_c", Type: ebpf.RingBuf}, MapOptions{}, RingBufferOptions{
+		RingBufferSize: 4096,
+		DataHandler: func(data []byte, ringBuffer *RingBuffer, manager *Manager) {
+			samples <- ringBuffer.Name
+		},
+	})
+	if err != nil {
+		t.Skipf("ring buffers aren't supported: %v", err)
+	}
+	ringBuffer, _ := m.GetRingBuffer("events_c")
+	if ringBuffer.poolSource == nil {
+		t.Fatal("expected the ring buffer to be read by the reader pool")
+	}
+	ringbufProgram := newRingbufOutputProgram(t, ringBufferArray)
+	defer ringbufProgram.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	emit := func() {
+		for _, prog := range programs {
+			if _, _, err := prog.Benchmark(make([]byte, 14), 1, nil); err != nil {
+				t.Skipf("couldn't run the program: %v", err)
+			}
+		}
+		if _, _, err := ringbufProgram.Test(make([]byte, 14)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func() {
+		seen := make(map[string]bool)
+		for len(seen) < 3 {
+			select {
+			case name := <-samples:
+				seen[name] = true
+			case <-time.After(time.Second):
+				t.Fatalf("expected a sample from each map, got %v", seen)
+			}
+		}
+	}
+	emit()
+	expect()
+
+	// the pool watches the new perf ring buffers once the perf map was resized
+	if err = <-resize; err != nil {
+		t.Fatal(err)
+	}
+	if m.PerfMaps[0].PerfRingBufferSize != 2*os.Getpagesize() {
+		t.Fatalf("expected the perf map to be resized, got %d bytes", m.PerfMaps[0].PerfRingBufferSize)
+	}
+	emit()
+	expect()
+
+	if err = m.stop(0, CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if m.readerPool != nil {
+		t.Error("expected the reader pool to be stopped")
+	}
+}
@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordHeaderSize - Size of the header of a recorded sample: size (uint32), CPU (int32), timestamp (int64) and lost
+// samples (uint64), in little endian
+const recordHeaderSize = 24
+
+// RecordedSample - Sample of a perf map or a ring buffer, written to a RecordSink and read back by a RecordReader
+type RecordedSample struct {
+	// CPU - CPU of the perf ring buffer the sample was read from, -1 for the samples of a ring buffer
+	CPU int
+	// Timestamp - Time at which the sample was read from the kernel
+	Timestamp time.Time
+	// Data - Raw sample
+	Data []byte
+	// LostSamples - Number of samples dropped by the kernel, when the record reports lost samples instead of a sample
+	LostSamples uint64
+}
+
+// writeRecord - Frames the provided sample and writes it to the provided sink in a single Write call, so that the
+// records of the readers sharing a concurrency safe sink don't interleave
+func writeRecord(sink io.Writer, CPU int, data []byte, lostSamples uint64) error {
+	buf := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(int32(CPU)))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(buf[16:], lostSamples)
+	copy(buf[recordHeaderSize:], data)
+	_, err := sink.Write(buf)
+	return err
+}
+
+// RecordReader - Reads back the samples written to a RecordSink, to analyze a capture later. Use io.MultiReader to
+// read the files of a RotatingFile in order, from the oldest one.
+type RecordReader struct {
+	r      io.Reader
+	header [recordHeaderSize]byte
+}
+
+// NewRecordReader - Returns a RecordReader reading the samples recorded in the provided reader
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: r}
+}
+
+// Read - Returns the next recorded sample, or io.EOF once all the samples were read
+func (r *RecordReader) Read() (RecordedSample, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return RecordedSample{}, fmt.Errorf("error:%w , truncated record header", ErrInvalidRecord)
+		}
+		return RecordedSample{}, err
+	}
+	sample := RecordedSample{
+		CPU:         int(int32(binary.LittleEndian.Uint32(r.header[4:]))),
+		Timestamp:   time.Unix(0, int64(binary.LittleEndian.Uint64(r.header[8:]))),
+		LostSamples: binary.LittleEndian.Uint64(r.header[16:]),
+	}
+	sample.Data = make([]byte, binary.LittleEndian.Uint32(r.header[0:]))
+	if _, err := io.ReadFull(r.r, sample.Data); err != nil {
+		return RecordedSample{}, fmt.Errorf("error:%w , truncated record of %d bytes: %v", ErrInvalidRecord, len(sample.Data), err)
+	}
+	return sample, nil
+}
+
+// Replay - (TestMode) Feeds the samples recorded in the provided reader through the dispatch path of the perf map, as
+// if they were read from the perf ring buffers. The samples of a ring buffer are replayed on CPU 0.
+func (m *PerfMap) Replay(r io.Reader) error {
+	reader := NewRecordReader(r)
+	for {
+		sample, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if sample.CPU < 0 {
+			sample.CPU = 0
+		}
+		if sample.LostSamples > 0 {
+			err = m.InjectLostSamples(sample.CPU, sample.LostSamples)
+		} else {
+			err = m.InjectSample(sample.CPU, sample.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Replay - (TestMode) Feeds the samples recorded in the provided reader through the dispatch path of the ring buffer,
+// as if they were read from the ring buffer. The lost samples reports of a perf map are ignored.
+func (rb *RingBuffer) Replay(r io.Reader) error {
+	reader := NewRecordReader(r)
+	for {
+		sample, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if sample.LostSamples > 0 {
+			continue
+		}
+		if err = rb.InjectSample(sample.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// RotatingFile - RecordSink writing to a file that is rotated once it reaches a maximum size: the file is renamed
+// with a .1 suffix, the previous rotations are shifted up to MaxBackups, and the oldest one is removed. RotatingFile
+// is safe for concurrent use.
+type RotatingFile struct {
+	lock sync.Mutex
+	file *os.File
+	size int64
+
+	// Path - Path of the file being written
+	Path string
+	// MaxSize - Size in bytes after which the file is rotated. Disabled when 0.
+	MaxSize int64
+	// MaxBackups - Number of rotated files kept along with the current file
+	MaxBackups int
+}
+
+// NewRotatingFile - Creates (or truncates) the file at the provided path, and returns a RotatingFile writing to it
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open - Creates (or truncates) the file being written
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't open record file %s", err, rf.Path))
+	}
+	rf.file, rf.size = file, 0
+	return nil
+}
+
+// Write - Writes the provided data to the current file, after rotating it if it would exceed MaxSize
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate - Closes the current file, shifts the rotated files and opens a new file
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	if rf.MaxBackups <= 0 {
+		_ = os.Remove(rf.Path)
+	} else {
+		_ = os.Remove(fmt.Sprintf("%s.%d", rf.Path, rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.Path, i), fmt.Sprintf("%s.%d", rf.Path, i+1))
+		}
+		if err := os.Rename(rf.Path, rf.Path+".1"); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't rotate record file %s", err, rf.Path))
+		}
+	}
+	return rf.open()
+}
+
+// Files - Returns the paths of the files of the RotatingFile that exist, from the oldest to the current one
+func (rf *RotatingFile) Files() []string {
+	var files []string
+	for i := rf.MaxBackups; i > 0; i-- {
+		path := fmt.Sprintf("%s.%d", rf.Path, i)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return append(files, rf.Path)
+}
+
+// Close - Closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,109 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	newPerfMap := func(options PerfMapOptions) *PerfMap {
+		options.TestMode = true
+		perfMap := &PerfMap{Map: Map{Name: "events"}, PerfMapOptions: options}
+		if err := perfMap.Init(&Manager{wg: &sync.WaitGroup{}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := perfMap.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return perfMap
+	}
+
+	// record only, no handler is set
+	var capture bytes.Buffer
+	recorder := newPerfMap(PerfMapOptions{RecordSink: &capture})
+	if err := recorder.InjectSample(1, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.InjectLostSamples(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.InjectSample(3, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	// replay the capture through the handlers of another perf map
+	var samples []string
+	var cpus []int
+	var lost uint64
+	replayer := newPerfMap(PerfMapOptions{
+		DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+			samples = append(samples, string(data))
+			cpus = append(cpus, CPU)
+		},
+		LostHandler: func(CPU int, count uint64, perfMap *PerfMap, manager *Manager) {
+			lost += count
+		},
+	})
+	if err := replayer.Replay(bytes.NewReader(capture.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0] != "first" || samples[1] != "second" || cpus[0] != 1 || cpus[1] != 3 || lost != 3 {
+		t.Errorf("unexpected replay: samples %v on CPUs %v, %d lost", samples, cpus, lost)
+	}
+
+	// truncated capture
+	if err := replayer.Replay(bytes.NewReader(capture.Bytes()[:capture.Len()-1])); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("expected ErrInvalidRecord, got %v", err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.rec")
+	sink, err := NewRotatingFile(path, 2*(recordHeaderSize+4), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = writeRecord(sink, i, []byte("data"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest file was removed, the remaining files hold the last samples
+	files := sink.Files()
+	if len(files) != 2 {
+		t.Fatalf("expected a rotated file and the current file, got %v", files)
+	}
+	var readers []io.Reader
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	reader := NewRecordReader(io.MultiReader(readers...))
+	var cpus []int
+	for {
+		sample, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		cpus = append(cpus, sample.CPU)
+	}
+	if len(cpus) != 3 || cpus[0] != 2 || cpus[2] != 4 {
+		t.Errorf("expected the samples of CPUs 2 to 4, got %v", cpus)
+	}
+}
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// ReorderLatePolicy - Defines what the reorder buffer of a PerfMap does with a late sample, that is to say a sample
+// older than a sample that was already delivered.
+type ReorderLatePolicy int
+
+const (
+	// ReorderDropLate - Late samples are dropped and counted in PerfMapStats.ReorderDrops
+	ReorderDropLate ReorderLatePolicy = iota
+	// ReorderDeliverLate - Late samples are delivered right away, out of order, and counted in
+	// PerfMapStats.ReorderDrops
+	ReorderDeliverLate
+)
+
+const (
+	// DefaultReorderWindow - Default amount of time a sample is held in the reorder buffer of a PerfMap
+	DefaultReorderWindow = 10 * time.Millisecond
+	// DefaultReorderBufferSize - Default maximum number of samples held in the reorder buffer of a PerfMap
+	DefaultReorderBufferSize = 4096
+)
+
+// reorderSample - Sample held in a reorder buffer
+type reorderSample struct {
+	timestamp uint64
+	source    string
+	cpu       int
+	data      []byte
+}
+
+// reorderHeap - Min-heap of samples keyed by timestamp
+type reorderHeap []reorderSample
+
+func (h reorderHeap) Len() int           { return len(h) }
+func (h reorderHeap) Less(i, j int) bool { return h[i].timestamp < h[j].timestamp }
+func (h reorderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *reorderHeap) Push(x interface{}) {
+	*h = append(*h, x.(reorderSample))
+}
+
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	sample := old[n-1]
+	old[n-1] = reorderSample{}
+	*h = old[:n-1]
+	return sample
+}
+
+// reorderBuffer - Bounded buffer that holds samples for a window of time and delivers them in timestamp order.
+//
+// A sample is delivered once a sample at least window nanoseconds younger was pushed, once the buffer is full, or
+// once no sample was pushed for window. A sample older than the last delivered sample is late, and is handled
+// according to the late policy.
+type reorderBuffer struct {
+	lock         sync.Mutex
+	samples      reorderHeap
+	window       time.Duration
+	size         int
+	latePolicy   ReorderLatePolicy
+	watermark    uint64
+	delivered    bool
+	maxTimestamp uint64
+	lastPush     time.Time
+	deliver      func(source string, cpu int, data []byte)
+	onLate       func()
+}
+
+// newReorderBuffer - Creates a new reorder buffer, default values are used for the window and the size if they are
+// not set
+func newReorderBuffer(window time.Duration, size int, latePolicy ReorderLatePolicy, deliver func(source string, cpu int, data []byte), onLate func()) *reorderBuffer {
+	if window <= 0 {
+		window = DefaultReorderWindow
+	}
+	if size <= 0 {
+		size = DefaultReorderBufferSize
+	}
+	return &reorderBuffer{
+		window:     window,
+		size:       size,
+		latePolicy: latePolicy,
+		deliver:    deliver,
+		onLate:     onLate,
+	}
+}
+
+// push - Inserts a new sample in the buffer and delivers the samples that are ready
+func (rb *reorderBuffer) push(timestamp uint64, cpu int, data []byte) {
+	rb.pushFrom("", timestamp, cpu, data)
+}
+
+// pushFrom - Inserts a new sample of the provided source in the buffer and delivers the samples that are ready
+func (rb *reorderBuffer) pushFrom(source string, timestamp uint64, cpu int, data []byte) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.lastPush = time.Now()
+
+	if rb.delivered && timestamp < rb.watermark {
+		if rb.onLate != nil {
+			rb.onLate()
+		}
+		if rb.latePolicy == ReorderDeliverLate {
+			rb.deliver(source, cpu, data)
+		}
+		return
+	}
+
+	heap.Push(&rb.samples, reorderSample{timestamp: timestamp, source: source, cpu: cpu, data: data})
+	if timestamp > rb.maxTimestamp {
+		rb.maxTimestamp = timestamp
+	}
+
+	for rb.samples.Len() > 0 {
+		oldest := rb.samples[0]
+		if oldest.timestamp+uint64(rb.window) > rb.maxTimestamp && rb.samples.Len() <= rb.size {
+			break
+		}
+		rb.pop()
+	}
+}
+
+// flushIdle - Delivers all the buffered samples if no sample was pushed for a whole window
+func (rb *reorderBuffer) flushIdle(now time.Time) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if now.Sub(rb.lastPush) < rb.window {
+		return
+	}
+	rb.flush()
+}
+
+// flushAll - Delivers all the buffered samples
+func (rb *reorderBuffer) flushAll() {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.flush()
+}
+
+// flush - (not thread safe) delivers all the buffered samples
+func (rb *reorderBuffer) flush() {
+	for rb.samples.Len() > 0 {
+		rb.pop()
+	}
+}
+
+// pop - (not thread safe) delivers the oldest buffered sample
+func (rb *reorderBuffer) pop() {
+	sample := heap.Pop(&rb.samples).(reorderSample)
+	rb.watermark = sample.timestamp
+	rb.delivered = true
+	rb.deliver(sample.source, sample.cpu, sample.data)
+}
+
+// sampleTimestampAt - Returns the 64 bits timestamp at the provided offset of the sample, or 0 if the sample is too
+// short
+func sampleTimestampAt(data []byte, offset int) uint64 {
+	if offset < 0 || len(data) < offset+8 {
+		return 0
+	}
+	return nativeEndian.Uint64(data[offset : offset+8])
+}
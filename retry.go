@@ -0,0 +1,179 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRetryBackoff - Default delay before the first background attempt of a RetryPolicy
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff - Default maximum delay between two background attempts of a RetryPolicy
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryPolicy - Background retries of the attachment of a probe, see Probe.RetryPolicy and Options.DefaultRetryPolicy.
+// When a probe fails to attach at Start with a retryable error (its interface isn't up yet, its target binary isn't
+// present yet, tracefs is busy...), Start doesn't fail, even for a mandatory probe: the probe is attached again in the
+// background with an exponential backoff, until it is attached, its attempts are exhausted or the manager is stopped.
+// The progress of the retries is exposed by Manager.GetProbeStatus. The attempts of ProbeRetry are made first.
+type RetryPolicy struct {
+	// Attempts - Maximum number of background attempts, unlimited when 0
+	Attempts uint
+
+	// Backoff - Delay before the first background attempt, doubled after each failed attempt. Defaults to
+	// DefaultRetryBackoff.
+	Backoff time.Duration
+
+	// MaxBackoff - Maximum delay between two background attempts. Defaults to DefaultRetryMaxBackoff.
+	MaxBackoff time.Duration
+
+	// Retryable - Returns true if the provided attach error is transient. The probe isn't retried, and is reported
+	// to Options.ProbeFailureHandler, when it returns false. Defaults to IsRetryableAttachError.
+	Retryable func(err error) bool
+}
+
+// IsRetryableAttachError - Returns true if the provided attach error is likely transient: the interface, the target
+// binary, the kernel module or the hook point doesn't exist yet, or the kernel resource is busy
+func IsRetryableAttachError(err error) bool {
+	return errors.Is(err, ErrInterfaceNotFound) ||
+		errors.Is(err, ErrKernelModuleNotLoaded) ||
+		errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EAGAIN)
+}
+
+// retryable - Returns true if the provided attach error should be retried
+func (policy *RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return IsRetryableAttachError(err)
+}
+
+// nextBackoff - Returns the delay before the attempt that follows an attempt made after the provided delay
+func (policy *RetryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+	if backoff <= 0 {
+		backoff = policy.Backoff
+		if backoff <= 0 {
+			backoff = DefaultRetryBackoff
+		}
+	} else {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// attachRetryPolicy - Returns the retry policy of the probe, if any
+func (p *Probe) attachRetryPolicy() *RetryPolicy {
+	if p.RetryPolicy != nil {
+		return p.RetryPolicy
+	}
+	if p.WaitForModule && p.KernelModule != "" {
+		return moduleWaitPolicy
+	}
+	if p.manager != nil {
+		return p.manager.options.DefaultRetryPolicy
+	}
+	return nil
+}
+
+// setRetryStatus - Updates the progress of the background retries of the probe
+func (p *Probe) setRetryStatus(retrying bool, attempts uint, nextRetry time.Time) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	p.retrying, p.retryAttempts, p.nextRetry = retrying, attempts, nextRetry
+}
+
+// isRetrying - Returns true if the probe is being attached in the background
+func (p *Probe) isRetrying() bool {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	return p.retrying
+}
+
+// startAttachRetry - Starts attaching the provided probe in the background if its last attach error can be retried
+// according to its retry policy. Returns false if the probe won't be retried.
+func (m *Manager) startAttachRetry(p *Probe) bool {
+	policy := p.attachRetryPolicy()
+	// the lazy probes are initialized by their first successful attempt
+	if policy == nil || !p.Enabled || (!p.IsInitialized() && !p.loadsLazily()) || !policy.retryable(p.GetLastError()) {
+		return false
+	}
+	if m.retryStop == nil {
+		m.retryStop = make(chan struct{})
+	}
+	backoff := policy.nextBackoff(0)
+	p.setRetryStatus(true, 0, time.Now().Add(backoff))
+	m.retryGroup.Add(1)
+	go m.retryAttach(p, policy, backoff, m.retryStop)
+	return true
+}
+
+// retryAttach - Attaches the provided probe until it succeeds, the attempts of its retry policy are exhausted, its
+// error can no longer be retried or stop is closed
+func (m *Manager) retryAttach(p *Probe, policy *RetryPolicy, backoff time.Duration, stop chan struct{}) {
+	defer m.retryGroup.Done()
+	var err error
+	var attempt uint
+	for policy.Attempts == 0 || attempt < policy.Attempts {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-stop:
+			timer.Stop()
+			p.setRetryStatus(false, attempt, time.Time{})
+			return
+		case <-timer.C:
+		}
+		attempt++
+		err = p.attach()
+		if p.IsRunning() {
+			p.setRetryStatus(false, attempt, time.Time{})
+			return
+		}
+		// the last error of the probe holds the error of the hook point
+		if lastError := p.GetLastError(); lastError != nil {
+			err = lastError
+		}
+		if !policy.retryable(err) {
+			break
+		}
+		backoff = policy.nextBackoff(backoff)
+		p.setRetryStatus(true, attempt, time.Now().Add(backoff))
+	}
+	p.setRetryStatus(false, attempt, time.Time{})
+
+	// release the program of the probe, like a failed Attach
+	p.stateLock.Lock()
+	if p.state < running && p.program != nil {
+		_ = p.program.Close()
+		p.reset()
+	}
+	p.stateLock.Unlock()
+	m.dispatchFailure(p, &ProbeAttachError{Probe: p.GetIdentificationPair(), Err: fmt.Errorf("error:%w , gave up after %d attempts: %v", ErrAttachRetryExhausted, attempt, err)})
+}
+
+// stopAttachRetries - Stops the background retries of the probes of the manager, and waits until the attempts in
+// progress are done
+func (m *Manager) stopAttachRetries() {
+	if m.retryStop == nil {
+		return
+	}
+	close(m.retryStop)
+	m.retryStop = nil
+	m.retryGroup.Wait()
+}
@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"accept": {
+			Name:        "accept",
+			Type:        ebpf.SocketFilter,
+			SectionName: "socket/accept",
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, -1),
+				asm.Return(),
+			},
+		},
+	}}
+	// the interface of the probe doesn't exist yet
+	const name = "retrytest0"
+	id := ProbeIdentificationPair{EbpfFuncName: "accept"}
+	m := &Manager{Probes: []*Probe{{Section: "socket/accept", EbpfFuncName: "accept", Ifname: name, NetnsPath: path}}}
+	options := Options{DefaultRetryPolicy: &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}}
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: spec}}, options); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status, ok := m.GetProbeStatus(id)
+	if !ok || status.Running || !status.Retrying || !errors.Is(status.LastError, ErrInterfaceNotFound) {
+		t.Fatalf("expected the probe to be retried, got running %v, retrying %v: %v", status.Running, status.Retrying, status.LastError)
+	}
+
+	// the probe is attached once the interface shows up
+	if err := runInNetns(path, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetName(lo, name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, _ = m.GetProbeStatus(id)
+		if status.Running && !status.Retrying {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the probe to be attached, got running %v, retrying %v: %v", status.Running, status.Retrying, status.LastError)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.RetryAttempts == 0 {
+		t.Error("expected the probe to be attached by a background attempt")
+	}
+}
+
+func TestIsRetryableAttachError(t *testing.T) {
+	for err, expected := range map[error]bool{
+		ErrInterfaceNotFound:                   true,
+		syscall.ENOENT:                         true,
+		syscall.EBUSY:                          true,
+		syscall.EPERM:                          false,
+		ErrProbeNotInitialized:                 false,
+		&ProbeAttachError{Err: syscall.ENODEV}: true,
+	} {
+		if IsRetryableAttachError(err) != expected {
+			t.Errorf("expected IsRetryableAttachError(%v) to be %v", err, expected)
+		}
+	}
+}
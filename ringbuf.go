@@ -0,0 +1,444 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// RingBufferOptions - Ring buffer map specific options
+type RingBufferOptions struct {
+	// RingBufferSize - Size in bytes of the ring buffer. Overrides the max entries of the map spec if set, must be a
+	// power of 2 multiple of the page size.
+	RingBufferSize int
+
+	// ErrChan - Ring buffer reader error channel
+	ErrChan chan error
+
+	// DataHandler - Callback function called when a new sample was retrieved from the ring buffer.
+	DataHandler func(data []byte, ringBuffer *RingBuffer, manager *Manager)
+
+	// RecordSink - The raw samples of the ring buffer are written to this writer, framed with a timestamp, to capture
+	// now and analyze later, see RecordReader and RotatingFile. When no handler is set, the samples are only recorded.
+	// Must be safe for concurrent use if shared.
+	RecordSink io.Writer
+
+	// EventHandler - Callback function called with the structured event decoded from a new sample by Decoder. When set,
+	// DataHandler is ignored. The samples that can't be decoded are reported on ErrChan.
+	EventHandler func(event interface{}, ringBuffer *RingBuffer, manager *Manager)
+
+	// Decoder - (EventHandler) Decodes the samples of the ring buffer, see NewStructDecoder and NewBTFDecoder
+	Decoder Decoder
+
+	// EventType - (EventHandler) Name of a type of the BTF of the manager. When Decoder isn't set, the samples are
+	// decoded according to the layout of this type, see NewBTFDecoder.
+	EventType string
+
+	// DumpHandler - Callback function called when manager.Dump() is called
+	// and dump the current state (human readable)
+	DumpHandler func(ringBuffer *RingBuffer, manager *Manager) string
+
+	// OrderedStream - When enabled, the samples are delivered to Options.OrderedDataHandler of the manager, merged in
+	// timestamp order with the samples of the other perf maps and ring buffers of the manager that enable
+	// OrderedStream. DataHandler isn't required in this mode. See SampleTimestamp and TimestampOffset.
+	OrderedStream bool
+
+	// SampleTimestamp - (OrderedStream) Callback function used to extract the timestamp of a sample, usually the
+	// bpf_ktime_get_ns() value written by the eBPF program at the beginning of the event.
+	SampleTimestamp func(data []byte) uint64
+
+	// TimestampOffset - (OrderedStream) Offset of the 64 bits timestamp in the samples, in the host byte order. Used
+	// when SampleTimestamp isn't set, defaults to the beginning of the samples.
+	TimestampOffset int
+
+	// TestMode - When enabled, the ring buffer doesn't need a kernel: Init doesn't look for the underlying eBPF map and
+	// Start doesn't open a ring buffer reader. Use InjectSample to feed synthetic samples through the normal dispatch
+	// path. This is meant to unit test DataHandler and EventHandler without CAP_BPF.
+	TestMode bool
+}
+
+// RingBuffer - BPF ring buffer (BPF_MAP_TYPE_RINGBUF) reader wrapper. Unlike perf ring buffers, the ring buffer is
+// shared by all the CPUs and samples are delivered in the order they were committed. It requires kernel 5.8+. When
+// Options.MapTypeFallbacks substituted a perf event array to the ring buffer, the perf ring buffers are read instead
+// and their samples delivered like the samples of the ring buffer.
+type RingBuffer struct {
+	manager    *Manager
+	reader     *ringbuf.Reader
+	perfReader *perf.Reader
+	events     eventCounters
+	activity   readerActivity
+	poolSource *readerPoolSource
+
+	// Map - A RingBuffer has the same features as a normal Map
+	Map
+	RingBufferOptions
+}
+
+// loadNewRingBuffer - Creates a new ring buffer instance, loads it and setup the ring buffer reader
+func loadNewRingBuffer(spec ebpf.MapSpec, options MapOptions, ringBufferOptions RingBufferOptions) (*RingBuffer, error) {
+	if ringBufferOptions.RingBufferSize > 0 {
+		spec.MaxEntries = uint32(ringBufferOptions.RingBufferSize)
+	}
+
+	// Create the new map and its underlying map
+	ringBuffer := RingBuffer{
+		RingBufferOptions: ringBufferOptions,
+	}
+	if err := loadMap(&ringBuffer.Map, spec, options); err != nil {
+		return nil, err
+	}
+	return &ringBuffer, nil
+}
+
+// Init - Initialize a ring buffer
+func (rb *RingBuffer) Init(manager *Manager) error {
+	rb.manager = manager
+
+	if rb.recordOnly() && rb.RecordSink == nil {
+		return fmt.Errorf("no DataHandler set for %s", rb.Name)
+	}
+	if rb.OrderedStream && manager.options.OrderedDataHandler == nil {
+		return fmt.Errorf("error:%w , ring buffer %s", ErrNoOrderedDataHandler, rb.Name)
+	}
+	if rb.EventHandler != nil && rb.Decoder == nil {
+		if rb.EventType == "" {
+			return fmt.Errorf("error:%w , ring buffer %s", ErrMissingDecoder, rb.Name)
+		}
+		decoder, err := manager.newEventTypeDecoder(rb.EventType)
+		if err != nil {
+			return err
+		}
+		rb.Decoder = decoder
+	}
+
+	// Initialize the underlying map structure
+	if rb.TestMode {
+		rb.stateLock.Lock()
+		defer rb.stateLock.Unlock()
+		if rb.state >= initialized {
+			return ErrMapInitialized
+		}
+		rb.Map.manager = manager
+		rb.state = initialized
+		return nil
+	}
+	if err := rb.Map.Init(manager); err != nil {
+		return err
+	}
+	if rb.array.Type() == ebpf.PerfEventArray {
+		if _, ok := manager.mapSubstitution(rb.Name); ok {
+			return nil
+		}
+	}
+	if rb.array.Type() != ebpf.RingBuf {
+		return fmt.Errorf("error:%w , map %s has type %s", ErrNotRingBuffer, rb.Name, rb.array.Type())
+	}
+	return nil
+}
+
+// Start - Starts fetching events on a ring buffer
+func (rb *RingBuffer) Start() error {
+	rb.stateLock.Lock()
+	defer rb.stateLock.Unlock()
+	if rb.state == running {
+		return nil
+	}
+	if rb.state < initialized {
+		return ErrMapNotInitialized
+	}
+	if rb.TestMode {
+		rb.events.start()
+		rb.state = running
+		return nil
+	}
+
+	// Read the perf ring buffers of the perf event array substituted to the ring buffer
+	if rb.array.Type() == ebpf.PerfEventArray {
+		reader, err := perf.NewReader(rb.array, rb.manager.options.DefaultPerfRingBufferSize)
+		if err != nil {
+			return err
+		}
+		rb.perfReader = reader
+		rb.manager.wg.Add(1)
+		go rb.readPerf()
+
+		rb.events.start()
+		rb.state = running
+		return nil
+	}
+
+	// Create and start the ring buffer reader
+	reader, err := ringbuf.NewReader(rb.array)
+	if err != nil {
+		return err
+	}
+	rb.reader = reader
+
+	// Start listening for data
+	if rb.manager.readerPool != nil {
+		if err = rb.startPooled(); err != nil {
+			_ = reader.Close()
+			rb.reader = nil
+			return err
+		}
+	} else {
+		rb.manager.wg.Add(1)
+		go rb.read()
+	}
+
+	rb.events.start()
+	rb.state = running
+	return nil
+}
+
+// read - Reads the ring buffer until the reader is closed
+func (rb *RingBuffer) read() {
+	defer rb.manager.wg.Done()
+	var record ringbuf.Record
+	for {
+		rb.activity.beginRead()
+		err := rb.reader.ReadInto(&record)
+		rb.activity.endRead()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			rb.manager.reportError(&PerfReadError{Map: rb.Name, Err: err})
+			if rb.ErrChan != nil {
+				rb.ErrChan <- err
+			}
+			continue
+		}
+		rb.handleSample(record.RawSample)
+	}
+}
+
+// readPerf - Reads the perf event array substituted to the ring buffer until the reader is closed
+func (rb *RingBuffer) readPerf() {
+	defer rb.manager.wg.Done()
+	for {
+		rb.activity.beginRead()
+		record, err := rb.perfReader.Read()
+		rb.activity.endRead()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
+			}
+			rb.manager.reportError(&PerfReadError{Map: rb.Name, Err: err})
+			if rb.ErrChan != nil {
+				rb.ErrChan <- err
+			}
+			continue
+		}
+		if record.LostSamples > 0 {
+			atomic.AddUint64(&rb.events.kernelDrops, record.LostSamples)
+			continue
+		}
+		rb.handleSample(record.RawSample)
+	}
+}
+
+// handleSample - Records and dispatches a sample read from the kernel
+func (rb *RingBuffer) handleSample(rawSample []byte) {
+	// samples are dropped while the reader is paused, the ring buffer can't be paused in the kernel
+	rb.stateLock.RLock()
+	isPaused := rb.state == paused
+	rb.stateLock.RUnlock()
+	if isPaused {
+		return
+	}
+	rb.events.receive(len(rawSample))
+	data := make([]byte, len(rawSample))
+	copy(data, rawSample)
+	if rb.RecordSink != nil {
+		rb.record(data)
+	}
+	if rb.recordOnly() {
+		return
+	}
+	if rb.OrderedStream {
+		rb.manager.orderedStream.pushFrom(rb.Name, rb.sampleTimestamp(data), -1, data)
+		return
+	}
+	rb.manager.dispatchEvent(func() {
+		rb.handleData(data)
+	}, func() {
+		atomic.AddUint64(&rb.events.userspaceDrops, 1)
+	})
+}
+
+// InjectSample - (TestMode) Feeds a synthetic sample through the dispatch path of the ring buffer, as if it was read
+// from the ring buffer
+func (rb *RingBuffer) InjectSample(data []byte) error {
+	if !rb.TestMode {
+		return fmt.Errorf("error:%w , ring buffer %s", ErrNotTestMode, rb.Name)
+	}
+	rb.stateLock.RLock()
+	isRunning := rb.state >= running
+	rb.stateLock.RUnlock()
+	if !isRunning {
+		return ErrMapNotRunning
+	}
+	rb.handleSample(data)
+	return nil
+}
+
+// recordOnly - Returns true if the ring buffer doesn't have any handler, its samples are only written to RecordSink
+func (rb *RingBuffer) recordOnly() bool {
+	return rb.DataHandler == nil && rb.EventHandler == nil && !rb.OrderedStream
+}
+
+// record - Writes the provided sample to the RecordSink of the ring buffer, the samples of a ring buffer are recorded
+// on CPU -1
+func (rb *RingBuffer) record(data []byte) {
+	if err := writeRecord(rb.RecordSink, -1, data, 0); err != nil {
+		err = fmt.Errorf("error:%w , ring buffer %s: %v", ErrRecordSink, rb.Name, err)
+		rb.manager.reportError(err)
+		if rb.ErrChan != nil {
+			rb.ErrChan <- err
+		}
+	}
+}
+
+// sampleTimestamp - Returns the timestamp of the provided sample, see SampleTimestamp and TimestampOffset
+func (rb *RingBuffer) sampleTimestamp(data []byte) uint64 {
+	if rb.SampleTimestamp != nil {
+		return rb.SampleTimestamp(data)
+	}
+	return sampleTimestampAt(data, rb.TimestampOffset)
+}
+
+// handleData - Calls the event handler of the ring buffer with the decoded sample, or its data handler with the raw
+// sample
+func (rb *RingBuffer) handleData(data []byte) {
+	if rb.EventHandler == nil {
+		rb.manager.runHandler(&rb.events, rb.Name, "ring_buffer", -1, 1, func() {
+			rb.DataHandler(data, rb, rb.manager)
+		})
+		return
+	}
+	event, err := rb.Decoder.Decode(data)
+	if err != nil {
+		atomic.AddUint64(&rb.events.decodeErrors, 1)
+		if rb.ErrChan != nil {
+			rb.ErrChan <- err
+		}
+		return
+	}
+	rb.manager.runHandler(&rb.events, rb.Name, "ring_buffer", -1, 1, func() {
+		rb.EventHandler(event, rb, rb.manager)
+	})
+}
+
+// UserspaceDrops - Returns the number of samples of the ring buffer dropped by the event worker pool of the manager
+// because its queue was full, see Options.EventConcurrency
+func (rb *RingBuffer) UserspaceDrops() uint64 {
+	return atomic.LoadUint64(&rb.events.userspaceDrops)
+}
+
+// Stop - Stops the ring buffer reader
+func (rb *RingBuffer) Stop(cleanup MapCleanupType) error {
+	rb.stateLock.Lock()
+	defer rb.stateLock.Unlock()
+	if rb.state < running {
+		return nil
+	}
+
+	// close ring buffer reader
+	var err error
+	if rb.poolSource != nil {
+		rb.poolSource.remove()
+		rb.poolSource = nil
+	}
+	if rb.perfReader != nil {
+		err = rb.perfReader.Close()
+	} else if rb.reader != nil {
+		err = rb.reader.Close()
+	}
+
+	// close underlying map
+	if errTmp := rb.Map.close(cleanup); errTmp != nil {
+		if err == nil {
+			err = errTmp
+		} else {
+			err = fmt.Errorf("error%v, %s", errTmp, err.Error())
+		}
+	}
+	return err
+}
+
+// Pause - Pauses a ring buffer reader. The kernel keeps writing to the ring buffer, the samples read while the reader
+// is paused are dropped.
+func (rb *RingBuffer) Pause() error {
+	rb.stateLock.Lock()
+	defer rb.stateLock.Unlock()
+	if rb.state < running {
+		return ErrMapNotRunning
+	}
+	rb.state = paused
+	return nil
+}
+
+// Resume - Resumes a ring buffer reader
+func (rb *RingBuffer) Resume() error {
+	rb.stateLock.Lock()
+	defer rb.stateLock.Unlock()
+	if rb.state < paused {
+		return ErrMapNotRunning
+	}
+	rb.state = running
+	return nil
+}
+
+// ringbufRecordReader - recordReader used by a PerfMap defined on a BPF ring buffer. All the samples are reported on
+// CPU 0, and the samples read while the reader is paused are dropped.
+type ringbufRecordReader struct {
+	reader *ringbuf.Reader
+	paused int32
+}
+
+// newRingbufRecordReader - Creates a recordReader on the provided BPF ring buffer
+func newRingbufRecordReader(array *ebpf.Map) (*ringbufRecordReader, error) {
+	reader, err := ringbuf.NewReader(array)
+	if err != nil {
+		return nil, err
+	}
+	return &ringbufRecordReader{reader: reader}, nil
+}
+
+func (r *ringbufRecordReader) Read() (perf.Record, error) {
+	for {
+		record, err := r.reader.Read()
+		if err != nil {
+			return perf.Record{}, err
+		}
+		if atomic.LoadInt32(&r.paused) == 1 {
+			continue
+		}
+		return perf.Record{RawSample: record.RawSample}, nil
+	}
+}
+
+func (r *ringbufRecordReader) SetDeadline(t time.Time) {
+	r.reader.SetDeadline(t)
+}
+
+func (r *ringbufRecordReader) Pause() error {
+	atomic.StoreInt32(&r.paused, 1)
+	return nil
+}
+
+func (r *ringbufRecordReader) Resume() error {
+	atomic.StoreInt32(&r.paused, 0)
+	return nil
+}
+
+func (r *ringbufRecordReader) Close() error {
+	return r.reader.Close()
+}
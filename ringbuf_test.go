@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// newRingbufOutputProgram - Returns a socket filter that writes the 8 bytes sample 0x2a to the provided ring buffer
+func newRingbufOutputProgram(t *testing.T, array *ebpf.Map) *ebpf.Program {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SocketFilter,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -8, 0x2a, asm.DWord),
+			asm.LoadMapPtr(asm.R1, array.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -8),
+			asm.Mov.Imm(asm.R3, 8),
+			asm.Mov.Imm(asm.R4, 0),
+			asm.FnRingbufOutput.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestRingBuffer(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	samples := make(chan []byte, 10)
+	m := &Manager{wg: &sync.WaitGroup{}}
+	array, err := m.NewRingBuffer(ebpf.MapSpec{Name: "events", Type: ebpf.RingBuf}, MapOptions{}, RingBufferOptions{
+		RingBufferSize: 4096,
+		DataHandler: func(data []byte, ringBuffer *RingBuffer, manager *Manager) {
+			samples <- data
+		},
+	})
+	if err != nil {
+		t.Skipf("ring buffers aren't supported: %v", err)
+	}
+	ringBuffer, ok := m.GetRingBuffer("events")
+	if !ok {
+		t.Fatal("couldn't find the new ring buffer")
+	}
+	if array.MaxEntries() != 4096 {
+		t.Errorf("expected the ring buffer size to be overridden, got %d", array.MaxEntries())
+	}
+
+	prog := newRingbufOutputProgram(t, array)
+	defer prog.Close()
+	if _, _, err = prog.Test(make([]byte, 14)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-samples:
+		if len(data) != 8 || data[0] != 0x2a {
+			t.Errorf("unexpected sample %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no sample was delivered")
+	}
+
+	// samples are dropped while the ring buffer is paused
+	if err = ringBuffer.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = prog.Test(make([]byte, 14)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-samples:
+		t.Errorf("unexpected sample %v while paused", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = ringBuffer.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ringBuffer.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+}
+
+func TestPerfMapOnRingBuffer(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096})
+	if err != nil {
+		t.Skipf("ring buffers aren't supported: %v", err)
+	}
+	defer array.Close()
+
+	samples := make(chan []byte, 10)
+	perfMap := &PerfMap{
+		Map: Map{Name: "events"},
+		PerfMapOptions: PerfMapOptions{
+			DataHandler: func(CPU int, data []byte, perfMap *PerfMap, manager *Manager) {
+				samples <- data
+			},
+		},
+	}
+	m := &Manager{
+		wg:         &sync.WaitGroup{},
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}},
+	}
+	if err = perfMap.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = perfMap.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer perfMap.Stop(CleanAll)
+
+	prog := newRingbufOutputProgram(t, array)
+	defer prog.Close()
+	if _, _, err = prog.Test(make([]byte, 14)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-samples:
+		if len(data) != 8 || data[0] != 0x2a {
+			t.Errorf("unexpected sample %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no sample was delivered")
+	}
+}
+
+func TestRingBufferWrongMapType(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	ringBuffer := &RingBuffer{
+		Map: Map{Name: "events"},
+		RingBufferOptions: RingBufferOptions{
+			DataHandler: func(data []byte, ringBuffer *RingBuffer, manager *Manager) {},
+		},
+	}
+	m := &Manager{collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"events": array}}}
+	if err = ringBuffer.Init(m); !errors.Is(err, ErrNotRingBuffer) {
+		t.Errorf("expected ErrNotRingBuffer, got %v", err)
+	}
+}
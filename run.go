@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Run - Starts the manager, blocks until ctx is done or until a component of the manager stops on a fatal error (for
+// example a perf ring reader that can't read its buffers anymore), then stops the manager. The maps are cleaned up
+// according to Options.RunCleanup, and the samples left in the buffers are handled for Options.RunDrainTimeout. Returns
+// the error that stopped the manager, nil when ctx is done, joined with the stop error if any.
+func (m *Manager) Run(ctx context.Context) error {
+	m.stateLock.Lock()
+	if m.state < initialized {
+		m.stateLock.Unlock()
+		return ErrManagerNotInitialized
+	}
+	fatal := make(chan error, 1)
+	m.runFatal = fatal
+	m.stateLock.Unlock()
+
+	if err := m.Start(); err != nil {
+		return err
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-fatal:
+	}
+
+	cleanup := m.options.RunCleanup
+	if cleanup == 0 {
+		cleanup = CleanAll
+	}
+	var stopErr error
+	if m.options.RunDrainTimeout > 0 {
+		drainCtx, cancel := context.WithTimeout(context.Background(), m.options.RunDrainTimeout)
+		stopErr = m.StopWithContext(drainCtx, cleanup)
+		cancel()
+	} else {
+		stopErr = m.Stop(cleanup)
+	}
+	if runErr == nil {
+		return stopErr
+	}
+	if stopErr != nil {
+		return multierror.Append(runErr, stopErr)
+	}
+	return runErr
+}
+
+// reportFatal - Stops Run when the provided error is fatal to the manager
+func (m *Manager) reportFatal(err error) {
+	if m == nil || m.runFatal == nil {
+		return
+	}
+	var readErr *PerfReadError
+	if !errors.As(err, &readErr) || !readErr.Fatal {
+		return
+	}
+	select {
+	case m.runFatal <- err:
+	default:
+	}
+}
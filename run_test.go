@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+func TestRun(t *testing.T) {
+	newManager := func() *Manager {
+		return &Manager{wg: &sync.WaitGroup{}, collection: &ebpf.Collection{}, state: initialized}
+	}
+	if err := (&Manager{}).Run(context.Background()); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized, got %v", err)
+	}
+
+	// Run returns when the context is cancelled
+	m := newManager()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.Run(ctx); err != nil {
+		t.Errorf("expected a clean stop, got %v", err)
+	}
+	if m.state != reset {
+		t.Errorf("expected the manager to be stopped, got state %s", m.state)
+	}
+
+	// Run returns the fatal error of a component
+	m = newManager()
+	done := make(chan error)
+	go func() {
+		done <- m.Run(context.Background())
+	}()
+	fatal := &PerfReadError{Map: "events", Fatal: true, Err: unix.EBADF}
+	for {
+		m.stateLock.RLock()
+		running := m.state == running
+		m.stateLock.RUnlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.dispatchFailure(nil, &PerfReadError{Map: "events", Err: unix.EAGAIN})
+	m.dispatchFailure(nil, fatal)
+	select {
+	case err := <-done:
+		if !errors.Is(err, unix.EBADF) {
+			t.Errorf("expected the fatal read error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after a fatal error")
+	}
+}
@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// selfNetnsPath - Network namespace of the process, the default network namespace of the sk_lookup programs
+const selfNetnsPath = "/proc/self/ns/net"
+
+// skLookupNetnsPath - Returns the path of the network namespace the sk_lookup probe is attached to
+func (p *Probe) skLookupNetnsPath() string {
+	if p.NetnsPath != "" {
+		return p.NetnsPath
+	}
+	return selfNetnsPath
+}
+
+// attachSkLookup - (sk_lookup) Attaches the program to the network namespace at NetnsPath with a bpf_link (kernel
+// 5.9+). The program selects the listening socket of the incoming connections and packets with bpf_sk_assign, from a
+// SOCKMAP or a SOCKHASH populated with Manager.PutSocket: a single socket can serve many addresses and ports.
+func (p *Probe) attachSkLookup() error {
+	path := p.skLookupNetnsPath()
+	netns, err := os.Open(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't open network namespace %s", err, path))
+	}
+	defer netns.Close()
+	l, err := link.AttachNetNs(int(netns.Fd()), p.program)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't attach sk_lookup program %s to network namespace %s", err, p.EbpfFuncName, path))
+	}
+	p.link = l
+	return nil
+}
+
+// checkNetNsLinkTarget - Returns an error if the provided network namespace link doesn't target the network namespace
+// of the probe
+func (p *Probe) checkNetNsLinkTarget(info *link.NetNsInfo) error {
+	path := p.skLookupNetnsPath()
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return err
+	}
+	if uint64(info.NetnsIno) != stat.Ino {
+		return errors.New(fmt.Sprintf("the link targets network namespace %d instead of %s", info.NetnsIno, path))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestAttachSkLookup(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	netnsPath := newNetns(t)
+	spec := &ebpf.ProgramSpec{
+		Type:       ebpf.SkLookup,
+		AttachType: ebpf.AttachSkLookup,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			// SK_PASS
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return(),
+		},
+	}
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Skipf("sk_lookup programs aren't supported: %v", err)
+	}
+	defer prog.Close()
+
+	p := &Probe{
+		manager:      &Manager{},
+		program:      prog,
+		programSpec:  spec,
+		state:        initialized,
+		Section:      "sk_lookup/steer",
+		EbpfFuncName: "steer",
+		Enabled:      true,
+		ProbeRetry:   1,
+		NetnsPath:    netnsPath,
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := p.link.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stat unix.Stat_t
+	if err = unix.Stat(netnsPath, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if info.NetNs() == nil || uint64(info.NetNs().NetnsIno) != stat.Ino {
+		t.Errorf("expected the program to be attached to network namespace %d, got %+v", stat.Ino, info.NetNs())
+	}
+	if err = p.checkPinnedLinkTarget(info); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// a link pinned for another network namespace isn't adopted
+	other := &Probe{programSpec: spec, NetnsPath: newNetns(t)}
+	if err = other.checkPinnedLinkTarget(info); err == nil {
+		t.Error("expected the link of another network namespace to be rejected")
+	}
+	if err = p.Detach(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// packetSocketProtocol - ETH_P_ALL in network byte order, the protocol of the raw packet sockets created by the socket
+// filter probes
+const packetSocketProtocol = uint16(unix.ETH_P_ALL>>8 | unix.ETH_P_ALL<<8&0xff00)
+
+// openPacketSocket - (socket filter) Creates a raw packet socket (AF_PACKET) bound to the interface of the probe, in
+// the network namespace of the probe, with the program of the probe attached. The program is attached before the
+// socket is bound, so that no packet reaches the socket unfiltered.
+func (p *Probe) openPacketSocket() (int, error) {
+	var fd int
+	err := runInNetns(p.NetnsPath, func() error {
+		var err error
+		// protocol 0: the socket doesn't receive any packet until it is bound
+		if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0); err != nil {
+			return err
+		}
+		if err = sockAttach(fd, p.program.FD()); err != nil {
+			_ = unix.Close(fd)
+			return err
+		}
+		if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: packetSocketProtocol, Ifindex: int(p.Ifindex)}); err != nil {
+			_ = unix.Close(fd)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("error:%v , couldn't open a packet socket on interface %d for %v", err, p.Ifindex, p.GetIdentificationPair()))
+	}
+	return fd, nil
+}
+
+// SocketFilterFD - (socket filter) Returns the file descriptor of the socket the probe is attached to: SocketFD, or
+// the raw packet socket created by the probe on its interface. Read it to receive the packets accepted by the program
+// of the probe. Returns 0 if the probe isn't attached.
+func (p *Probe) SocketFilterFD() int {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	if p.SocketFD == 0 {
+		return p.packetSocket
+	}
+	if p.state < running {
+		return 0
+	}
+	return p.SocketFD
+}
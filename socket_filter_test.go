@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestSocketFilterPacketSocket(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	err := runInNetns(path, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetUp(lo)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"accept": {
+			Name:        "accept",
+			Type:        ebpf.SocketFilter,
+			SectionName: "socket/accept",
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				// accept the whole packet
+				asm.Mov.Imm(asm.R0, -1),
+				asm.Return(),
+			},
+		},
+	}}
+	m := &Manager{Probes: []*Probe{{Section: "socket/accept", EbpfFuncName: "accept", Ifname: "lo", NetnsPath: path}}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	probe := m.Probes[0]
+	if probe.SocketFilterFD() != 0 {
+		t.Error("expected no socket before the probe is attached")
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fd := probe.SocketFilterFD()
+	if fd == 0 {
+		t.Fatal("expected the probe to open a packet socket")
+	}
+
+	// send a datagram on the loopback interface of the namespace
+	payload := []byte("socket filter probe")
+	err = runInNetns(path, func() error {
+		conn, err := net.Dial("udp", "127.0.0.1:9")
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Write(payload)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(time.Second)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err == nil && n >= len(payload) && string(buf[n-len(payload):n]) == string(payload) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected to receive the datagram on the packet socket: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the socket is closed when the probe is stopped
+	if err = probe.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if probe.SocketFilterFD() != 0 {
+		t.Error("expected the packet socket to be closed")
+	}
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err == nil {
+		t.Error("expected the file descriptor of the packet socket to be closed")
+	}
+}
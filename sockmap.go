@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// sockMapAttachment - (sk_msg & sk_skb) Program attached to a SOCKMAP or a SOCKHASH with BPF_PROG_ATTACH
+type sockMapAttachment struct {
+	sockMap    *ebpf.Map
+	program    *ebpf.Program
+	attachType ebpf.AttachType
+}
+
+// Close - Detaches the program from the map
+func (a *sockMapAttachment) Close() error {
+	return link.RawDetachProgram(link.RawDetachProgramOptions{
+		Target:  a.sockMap.FD(),
+		Program: a.program,
+		Attach:  a.attachType,
+	})
+}
+
+// isSockMapType - Returns true if the provided map type holds sockets
+func isSockMapType(mapType ebpf.MapType) bool {
+	return mapType == ebpf.SockMap || mapType == ebpf.SockHash
+}
+
+// attachSockMap - Attaches the probe to the SOCKMAP or SOCKHASH of the manager set in SockMap. The attach type is
+// determined by the section: sk_msg, sk_skb/stream_parser or sk_skb/stream_verdict.
+func (p *Probe) attachSockMap() error {
+	if p.SockMap == "" {
+		return errors.New("SockMap cant be empty.")
+	}
+	if p.programSpec.AttachType == ebpf.AttachNone {
+		return fmt.Errorf("error:%v, expected SEC(\"sk_msg\"), SEC(\"sk_skb/stream_parser\") or SEC(\"sk_skb/stream_verdict\") got %s", ErrSectionFormat, p.Section)
+	}
+	sockMap, ok := p.manager.getMap(p.SockMap)
+	if !ok {
+		return errors.New(fmt.Sprintf("error:%v , couldn't find map %s", ErrUnknownMap, p.SockMap))
+	}
+	if !isSockMapType(sockMap.Type()) {
+		return fmt.Errorf("error:%w , map %s is a %s", ErrNotSockMap, p.SockMap, sockMap.Type())
+	}
+
+	err := link.RawAttachProgram(link.RawAttachProgramOptions{
+		Target:  sockMap.FD(),
+		Program: p.program,
+		Attach:  p.programSpec.AttachType,
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , failed to attach probe %v to map %s, attach type:%s", err, p.GetIdentificationPair(), p.SockMap, p.programSpec.AttachType.String()))
+	}
+	p.sockMapAttachment = &sockMapAttachment{sockMap: sockMap, program: p.program, attachType: p.programSpec.AttachType}
+	return nil
+}
+
+// detachSockMap - Detaches the probe from its SOCKMAP or SOCKHASH
+func (p *Probe) detachSockMap() error {
+	if p.sockMapAttachment == nil {
+		return nil
+	}
+	err := p.sockMapAttachment.Close()
+	p.sockMapAttachment = nil
+	return err
+}
+
+// sockMap - Returns the SOCKMAP or SOCKHASH of the manager with the provided name
+func (m *Manager) sockMap(name string) (*ebpf.Map, error) {
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	sockMap, ok := m.getMap(name)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't find map %s", ErrUnknownMap, name))
+	}
+	if !isSockMapType(sockMap.Type()) {
+		return nil, fmt.Errorf("error:%w , map %s is a %s", ErrNotSockMap, name, sockMap.Type())
+	}
+	return sockMap, nil
+}
+
+// PutSocket - Inserts the socket of the provided file descriptor in the SOCKMAP or SOCKHASH with the provided name, at
+// the provided key. The sk_msg and sk_skb programs attached to the map then run on the socket, and can redirect its
+// traffic to the other sockets of the map. The map keeps a reference to the socket, the file descriptor can be closed.
+func (m *Manager) PutSocket(mapName string, key interface{}, socketFD int) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	sockMap, err := m.sockMap(mapName)
+	if err != nil {
+		return err
+	}
+	if err = sockMap.Put(key, uint32(socketFD)); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't insert socket %d in map %s", err, socketFD, mapName))
+	}
+	return nil
+}
+
+// DeleteSocket - Removes the socket at the provided key from the SOCKMAP or SOCKHASH with the provided name.
+// ErrKeyNotExist is returned if the key doesn't exist.
+func (m *Manager) DeleteSocket(mapName string, key interface{}) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	sockMap, err := m.sockMap(mapName)
+	if err != nil {
+		return err
+	}
+	return sockMap.Delete(key)
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestSockMap(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	sockMap, err := ebpf.NewMap(&ebpf.MapSpec{
+		Type:       ebpf.SockMap,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: 2,
+	})
+	if err != nil {
+		t.Skipf("SOCKMAP not supported: %v", err)
+	}
+	defer sockMap.Close()
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	// sk_msg: returns SK_PASS
+	spec := &ebpf.ProgramSpec{
+		Type:       ebpf.SkMsg,
+		AttachType: ebpf.AttachSkMsgVerdict,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return(),
+		},
+	}
+	prog, err := ebpf.NewProgram(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"sock_map": sockMap, "array": array}},
+		state:      initialized,
+	}
+	p := &Probe{
+		manager:      m,
+		program:      prog,
+		programSpec:  spec,
+		state:        initialized,
+		Section:      "sk_msg",
+		EbpfFuncName: "sk_msg_pass",
+		Enabled:      true,
+		ProbeRetry:   1,
+		SockMap:      "sock_map",
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	file, err := conn.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err = m.PutSocket("sock_map", uint32(0), int(file.Fd())); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.DeleteSocket("sock_map", uint32(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.PutSocket("array", uint32(0), int(file.Fd())); !errors.Is(err, ErrNotSockMap) {
+		t.Errorf("expected ErrNotSockMap, got %v", err)
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// StateVersion - Version of the format of the state files written by SaveState
+const StateVersion = 1
+
+// ManagerState - Kernel objects of a manager persisted by SaveState, so that a new instance of the manager re-adopts
+// them with LoadState
+type ManagerState struct {
+	Version int          `json:"version"`
+	SavedAt time.Time    `json:"saved_at"`
+	Maps    []MapState   `json:"maps"`
+	Probes  []ProbeState `json:"probes"`
+}
+
+// MapState - Pinned map of a ManagerState
+type MapState struct {
+	Name       string       `json:"name"`
+	PinPath    string       `json:"pin_path"`
+	ID         ebpf.MapID   `json:"id"`
+	Type       ebpf.MapType `json:"type"`
+	KeySize    uint32       `json:"key_size"`
+	ValueSize  uint32       `json:"value_size"`
+	MaxEntries uint32       `json:"max_entries"`
+}
+
+// ProbeState - Pinned program and link of a probe of a ManagerState, along with the configuration of its hook point
+type ProbeState struct {
+	ProbeIdentificationPair
+	Section          string         `json:"section"`
+	AttachToFuncName string         `json:"attach_to_func_name,omitempty"`
+	Ifname           string         `json:"ifname,omitempty"`
+	Ifindex          int32          `json:"ifindex,omitempty"`
+	CGroupPath       string         `json:"cgroup_path,omitempty"`
+	BinaryPath       string         `json:"binary_path,omitempty"`
+	ProgramPinPath   string         `json:"program_pin_path,omitempty"`
+	ProgramID        ebpf.ProgramID `json:"program_id"`
+	LinkPinPath      string         `json:"link_pin_path,omitempty"`
+}
+
+// matches - Returns true if the saved probe has the hook point of the provided probe
+func (ps *ProbeState) matches(p *Probe) bool {
+	return p.IdentificationPairMatches(ps.ProbeIdentificationPair) && ps.Section == p.Section &&
+		ps.AttachToFuncName == p.AttachToFuncName && ps.Ifname == p.Ifname && ps.Ifindex == p.Ifindex &&
+		ps.CGroupPath == p.CGroupPath && ps.BinaryPath == p.BinaryPath
+}
+
+// pinnedMapID - Returns the ID of the map pinned at the provided path, 0 if there is none
+func pinnedMapID(path string) ebpf.MapID {
+	pinned, err := ebpf.LoadPinnedMap(path, nil)
+	if err != nil {
+		return 0
+	}
+	defer pinned.Close()
+	info, err := pinned.Info()
+	if err != nil {
+		return 0
+	}
+	id, _ := info.ID()
+	return id
+}
+
+// SaveState - Persists the maps, programs and links of the manager at the provided path, so that a new instance of
+// the manager can re-adopt them with LoadState and take over without detaching the programs: an agent can be
+// restarted or upgraded without missing events. The maps and links that aren't pinned yet are pinned in
+// Options.BPFFSRoot with the PinByName naming, the pinned links keep their programs loaded. Once the state is saved,
+// Stop leaves the saved pins in place, whatever its cleanup type.
+// Only the probes attached with a bpf_link that can be pinned (XDP, cgroup, tracing, LSM, netns programs...) stay
+// attached across the restart, the other probes are detached by Stop and attached again by the new instance.
+func (m *Manager) SaveState(path string) error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+
+	state := ManagerState{Version: StateVersion, SavedAt: time.Now()}
+	saveMap := func(managerMap *Map) error {
+		if managerMap.array == nil {
+			return nil
+		}
+		if managerMap.PinPath == "" {
+			managerMap.PinPath = m.pinName("map", managerMap.Name)
+			if err := managerMap.array.Pin(managerMap.PinPath); err != nil {
+				managerMap.PinPath = ""
+				return errors.New(fmt.Sprintf("error:%v , couldn't pin map %s", err, managerMap.Name))
+			}
+		}
+		info, err := managerMap.array.Info()
+		if err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't get the info of map %s", err, managerMap.Name))
+		}
+		id, _ := info.ID()
+		state.Maps = append(state.Maps, MapState{
+			Name:       managerMap.Name,
+			PinPath:    managerMap.PinPath,
+			ID:         id,
+			Type:       info.Type,
+			KeySize:    info.KeySize,
+			ValueSize:  info.ValueSize,
+			MaxEntries: info.MaxEntries,
+		})
+		return nil
+	}
+	for _, managerMap := range m.Maps {
+		if err := saveMap(managerMap); err != nil {
+			return err
+		}
+	}
+	for _, perfMap := range m.PerfMaps {
+		if err := saveMap(&perfMap.Map); err != nil {
+			return err
+		}
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		if err := saveMap(&ringBuffer.Map); err != nil {
+			return err
+		}
+	}
+
+	for _, probe := range m.Probes {
+		probe.stateLock.Lock()
+		if probe.program == nil {
+			probe.stateLock.Unlock()
+			continue
+		}
+		if probe.linkPinPath == "" && probe.link != nil {
+			probe.linkPinPath = m.pinName("link", probePinName(probe))
+			if err := probe.pinLink(); err != nil {
+				probe.linkPinPath = ""
+				probe.stateLock.Unlock()
+				return err
+			}
+		}
+		probeState := ProbeState{
+			ProbeIdentificationPair: probe.GetIdentificationPair(),
+			Section:                 probe.Section,
+			AttachToFuncName:        probe.AttachToFuncName,
+			Ifname:                  probe.Ifname,
+			Ifindex:                 probe.Ifindex,
+			CGroupPath:              probe.CGroupPath,
+			BinaryPath:              probe.BinaryPath,
+			ProgramPinPath:          probe.PinPath,
+			ProgramID:               probe.programID(),
+		}
+		// the links that can't be pinned (perf event based links) are attached again by the new instance
+		if _, err := os.Stat(probe.linkPinPath); probe.linkPinPath != "" && err == nil {
+			probeState.LinkPinPath = probe.linkPinPath
+		}
+		probe.stateLock.Unlock()
+		state.Probes = append(state.Probes, probeState)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write the state atomically, a crash must not leave a truncated state behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't save the state of the manager at %s", err, path))
+	}
+	_, err = tmp.Write(data)
+	err = ConcatErrors(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.New(fmt.Sprintf("error:%v , couldn't save the state of the manager at %s", err, path))
+	}
+	m.stateSaved = true
+	return nil
+}
+
+// ReadState - Reads the state saved by SaveState at the provided path
+func ReadState(path string) (*ManagerState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't read the state of the manager at %s", err, path))
+	}
+	var state ManagerState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error:%w , %s: %v", ErrInvalidState, path, err)
+	}
+	if state.Version != StateVersion {
+		return nil, fmt.Errorf("error:%w , %s has version %d, expected %d", ErrInvalidState, path, state.Version, StateVersion)
+	}
+	return &state, nil
+}
+
+// LoadState - Reads the state saved by a previous instance of the manager with SaveState, so that the next Init and
+// Start re-adopt its kernel objects: the saved maps are reused (see MapOptions.LoadPinPath) and the saved links are
+// adopted (see Options.AdoptPinnedLinks) instead of attaching the programs again, their programs are replaced with
+// the programs of the new instance. The perf maps and ring buffers are reused as well, so the events keep flowing to the new readers.
+// The saved objects whose pin disappeared, or whose probe has another hook point, are created again. The stale pins
+// aren't cleaned up when a state is loaded, see Options.CleanupStalePins. Must be called before Init.
+func (m *Manager) LoadState(path string) error {
+	state, err := ReadState(path)
+	if err != nil {
+		return err
+	}
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if m.state >= initialized {
+		return ErrManagerRunning
+	}
+	m.loadedState = state
+	return nil
+}
+
+// applyLoadedState - (not thread safe) Sets the pin paths of the maps and probes saved in the loaded state, if any
+func (m *Manager) applyLoadedState() {
+	if m.loadedState == nil {
+		return
+	}
+	maps := make(map[string]MapState, len(m.loadedState.Maps))
+	for _, mapState := range m.loadedState.Maps {
+		// the pin was replaced by another map since the state was saved
+		if pinnedMapID(mapState.PinPath) != mapState.ID {
+			continue
+		}
+		maps[mapState.Name] = mapState
+	}
+	restoreMap := func(managerMap *Map) {
+		if mapState, ok := maps[managerMap.Name]; ok {
+			managerMap.LoadPinPath = mapState.PinPath
+			managerMap.PinPath = mapState.PinPath
+		}
+	}
+	for _, managerMap := range m.Maps {
+		restoreMap(managerMap)
+	}
+	for _, perfMap := range m.PerfMaps {
+		restoreMap(&perfMap.Map)
+	}
+	for _, ringBuffer := range m.RingBuffers {
+		restoreMap(&ringBuffer.Map)
+	}
+
+	for _, probe := range m.Probes {
+		for _, probeState := range m.loadedState.Probes {
+			if !probeState.matches(probe) {
+				continue
+			}
+			if probeState.LinkPinPath != "" {
+				probe.linkPinPath = probeState.LinkPinPath
+			}
+			break
+		}
+	}
+}
+
+// RestoredState - Returns the state loaded with LoadState, nil if the manager didn't load one
+func (m *Manager) RestoredState() *ManagerState {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.loadedState
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	_, v2Root, err := cgroupMountPoints("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2Root == "" {
+		t.Skip("cgroup v2 isn't mounted")
+	}
+	cgroup := filepath.Join(v2Root, "ebpfmanager_state_test")
+	if err = os.Mkdir(cgroup, 0755); err != nil {
+		t.Skipf("couldn't create cgroup: %v", err)
+	}
+	defer os.Remove(cgroup)
+	root := mountBPFFS(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	newManager := func() (*Manager, []CollectionAsset) {
+		spec := &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"counts": {Name: "counts", Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+			},
+			Programs: map[string]*ebpf.ProgramSpec{
+				"egress": {
+					Name:         "egress",
+					Type:         ebpf.CGroupSKB,
+					AttachType:   ebpf.AttachCGroupInetEgress,
+					SectionName:  "cgroup_skb/egress",
+					License:      "GPL",
+					Instructions: asm.Instructions{asm.LoadMapPtr(asm.R1, 0).WithReference("counts"), asm.Mov.Imm(asm.R0, 1), asm.Return()},
+				},
+			},
+		}
+		m := &Manager{
+			Probes: []*Probe{{Section: "cgroup_skb/egress", EbpfFuncName: "egress", CGroupPath: cgroup}},
+			Maps:   []*Map{{Name: "counts"}},
+		}
+		return m, []CollectionAsset{{Spec: spec}}
+	}
+	options := Options{BPFFSRoot: root, PinPrefix: "state_"}
+
+	// the first instance saves its state before it exits
+	first, assets := newManager()
+	if err = first.InitWithAssets(assets, options); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	counts, _, _ := first.GetMap("counts")
+	if err = counts.Put(uint32(0), uint32(42)); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.SaveState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	savedLinkID := linkID(t, first.Probes[0].link)
+	if err = first.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := ReadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Maps) != 1 || len(state.Probes) != 1 || state.Probes[0].LinkPinPath == "" {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	// the program stays attached while no instance is running
+	pinned, err := link.LoadPinnedLink(state.Probes[0].LinkPinPath, nil)
+	if err != nil {
+		t.Fatalf("expected the link to stay pinned: %v", err)
+	}
+	_ = pinned.Close()
+
+	// the second instance re-adopts the map and the link
+	second, assets := newManager()
+	if err = second.LoadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.InitWithAssets(assets, options); err != nil {
+		t.Fatal(err)
+	}
+	if err = second.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !second.Maps[0].Reused() {
+		t.Error("expected the saved map to be reused")
+	}
+	counts, _, _ = second.GetMap("counts")
+	var value uint32
+	if err = counts.Lookup(uint32(0), &value); err != nil || value != 42 {
+		t.Errorf("expected the content of the map to survive the restart, got %d (%v)", value, err)
+	}
+	if id := linkID(t, second.Probes[0].link); id != savedLinkID {
+		t.Errorf("expected link %d to be adopted, got link %d", savedLinkID, id)
+	}
+	if err = second.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(state.Probes[0].LinkPinPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the link to be unpinned once the state is no longer saved, got %v", err)
+	}
+
+	if err = os.WriteFile(statePath, []byte(`{"version": 0}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = (&Manager{}).LoadState(statePath); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState, got %v", err)
+	}
+}
+
+func linkID(t *testing.T, l link.Link) link.ID {
+	info, err := l.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ID
+}
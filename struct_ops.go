@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// struct_ops attach type and map flag, see include/uapi/linux/bpf.h
+const (
+	attachStructOps = ebpf.AttachType(44)
+
+	// bpfFLink - BPF_F_LINK, struct_ops maps created with this flag are registered through a bpf_link
+	bpfFLink = 1 << 13
+)
+
+// StructOps - struct_ops map (BPF_MAP_TYPE_STRUCT_OPS) registered to the kernel by the manager, for example a TCP
+// congestion control or a sched_ext scheduler. The map is registered with a bpf_link (kernel 6.4+), it must therefore
+// be created with the BPF_F_LINK flag. Unregistering the map closes the link.
+//
+// Note: the vendored cilium/ebpf doesn't create struct_ops maps from the .struct_ops sections of an object file. The
+// map can be provided with Options.MapEditors, for example a map loaded from a pin with ebpf.LoadPinnedMap.
+type StructOps struct {
+	stateLock sync.Mutex
+	linkFD    int
+	enabled   bool
+
+	// Name - Name of the struct_ops map
+	Name string
+
+	// Disabled - If true, the struct_ops map isn't registered when the manager starts. Use Manager.EnableStructOps to
+	// register it later.
+	Disabled bool
+}
+
+// IsEnabled - Returns true if the struct_ops map is registered to the kernel
+func (s *StructOps) IsEnabled() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.enabled
+}
+
+// enable - Registers the struct_ops map with a bpf_link
+func (s *StructOps) enable(manager *Manager) error {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if s.enabled {
+		return nil
+	}
+	array, ok := manager.getMap(s.Name)
+	if !ok {
+		return errors.New(fmt.Sprintf("error:%v , couldn't find map %s", ErrUnknownMap, s.Name))
+	}
+	if array.Type() != ebpf.StructOpsMap {
+		return fmt.Errorf("error:%w , map %s is a %s", ErrNotStructOpsMap, s.Name, array.Type())
+	}
+	if array.Flags()&bpfFLink == 0 {
+		return fmt.Errorf("error:%w , map %s wasn't created with BPF_F_LINK", ErrStructOpsAttachFailed, s.Name)
+	}
+
+	// union bpf_attr, link_create variant: the map file descriptor replaces the program file descriptor
+	attr := struct {
+		mapFD      uint32
+		targetFD   uint32
+		attachType uint32
+		flags      uint32
+	}{
+		mapFD:      uint32(array.FD()),
+		attachType: uint32(attachStructOps),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return fmt.Errorf("error:%w , map %s: %v", ErrStructOpsAttachFailed, s.Name, errno)
+	}
+	s.linkFD, s.enabled = int(fd), true
+	return nil
+}
+
+// disable - Unregisters the struct_ops map by closing its bpf_link
+func (s *StructOps) disable() error {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if !s.enabled {
+		return nil
+	}
+	err := unix.Close(s.linkFD)
+	s.linkFD, s.enabled = 0, false
+	return err
+}
+
+// getStructOps - Returns the struct_ops map of the manager with the provided name
+func (m *Manager) getStructOps(name string) (*StructOps, error) {
+	for _, structOps := range m.StructOps {
+		if structOps.Name == name {
+			return structOps, nil
+		}
+	}
+	return nil, fmt.Errorf("error:%w , %s", ErrUnknownStructOps, name)
+}
+
+// EnableStructOps - Registers the struct_ops map with the provided name to the kernel, see StructOps
+func (m *Manager) EnableStructOps(name string) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	structOps, err := m.getStructOps(name)
+	if err != nil {
+		return err
+	}
+	return structOps.enable(m)
+}
+
+// DisableStructOps - Unregisters the struct_ops map with the provided name from the kernel. The map and its programs
+// stay loaded and can be registered again with EnableStructOps.
+func (m *Manager) DisableStructOps(name string) error {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return ErrManagerNotInitialized
+	}
+	structOps, err := m.getStructOps(name)
+	if err != nil {
+		return err
+	}
+	return structOps.disable()
+}
+
+// startStructOps - Registers the struct_ops maps that aren't disabled
+func (m *Manager) startStructOps() error {
+	for _, structOps := range m.StructOps {
+		if structOps.Disabled {
+			continue
+		}
+		if err := structOps.enable(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
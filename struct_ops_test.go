@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestStructOps(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	m := &Manager{StructOps: []*StructOps{{Name: "tcp_ca"}}}
+	if err = m.EnableStructOps("tcp_ca"); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected ErrManagerNotInitialized, got %v", err)
+	}
+
+	m.collection = &ebpf.Collection{Maps: map[string]*ebpf.Map{"tcp_ca": array}}
+	m.state = initialized
+	if err = m.EnableStructOps("unknown"); !errors.Is(err, ErrUnknownStructOps) {
+		t.Errorf("expected ErrUnknownStructOps, got %v", err)
+	}
+	if err = m.EnableStructOps("tcp_ca"); !errors.Is(err, ErrNotStructOpsMap) {
+		t.Errorf("expected ErrNotStructOpsMap, got %v", err)
+	}
+	if m.StructOps[0].IsEnabled() {
+		t.Error("the struct_ops map shouldn't be registered")
+	}
+	if err = m.DisableStructOps("tcp_ca"); err != nil {
+		t.Errorf("disabling an unregistered struct_ops map should succeed, got %v", err)
+	}
+}
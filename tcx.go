@@ -0,0 +1,130 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+// TCAttachMode - Defines how a TC classifier is attached to its interface
+type TCAttachMode int
+
+const (
+	// TCAttachModeNetlink - The classifier is added as a filter of the clsact qdisc of the interface, through netlink.
+	// This is the default mode.
+	TCAttachModeNetlink TCAttachMode = iota
+	// TCAttachModeTCX - The classifier is attached with a tcx link (kernel 6.6+), ordered with the other tcx programs of
+	// the interface according to Probe.TCXOrder. The manager falls back to TCAttachModeNetlink on older kernels.
+	TCAttachModeTCX
+)
+
+func (m TCAttachMode) String() string {
+	switch m {
+	case TCAttachModeNetlink:
+		return "netlink"
+	case TCAttachModeTCX:
+		return "tcx"
+	default:
+		return fmt.Sprintf("TCAttachMode(%d)", int(m))
+	}
+}
+
+// TCXOrder - Position of a tcx program in the list of the tcx programs of an interface
+type TCXOrder int
+
+const (
+	// TCXOrderDefault - The program is appended to the programs already attached
+	TCXOrderDefault TCXOrder = iota
+	// TCXOrderFirst - The program runs before all the programs already attached
+	TCXOrderFirst
+	// TCXOrderLast - The program runs after all the programs already attached
+	TCXOrderLast
+	// TCXOrderBefore - The program runs right before the program set in Probe.TCXRelativeTo or
+	// Probe.TCXRelativeProgramID
+	TCXOrderBefore
+	// TCXOrderAfter - The program runs right after the program set in Probe.TCXRelativeTo or
+	// Probe.TCXRelativeProgramID
+	TCXOrderAfter
+)
+
+// tcx attach types and mprog flags, see include/uapi/linux/bpf.h
+const (
+	attachTCXIngress = ebpf.AttachType(46)
+	attachTCXEgress  = ebpf.AttachType(47)
+
+	bpfFBefore = 1 << 3
+	bpfFAfter  = 1 << 4
+	bpfFID     = 1 << 5
+)
+
+// tcxAttachType - Returns the tcx attach type of the network direction of the probe
+func (p *Probe) tcxAttachType() (ebpf.AttachType, error) {
+	switch p.NetworkDirection {
+	case Ingress:
+		return attachTCXIngress, nil
+	case Egress:
+		return attachTCXEgress, nil
+	default:
+		return ebpf.AttachNone, errors.New(fmt.Sprintf("error:%v , unknown network direction %v", ErrTCXAttachFailed, p.NetworkDirection))
+	}
+}
+
+// tcxRelative - Returns the mprog flags and the relative file descriptor or ID matching the tcx order of the probe
+func (p *Probe) tcxRelative() (uint32, uint32, error) {
+	switch p.TCXOrder {
+	case TCXOrderDefault:
+		return 0, 0, nil
+	case TCXOrderFirst:
+		return bpfFBefore, 0, nil
+	case TCXOrderLast:
+		return bpfFAfter, 0, nil
+	case TCXOrderBefore, TCXOrderAfter:
+		flags := uint32(bpfFBefore)
+		if p.TCXOrder == TCXOrderAfter {
+			flags = bpfFAfter
+		}
+		if p.TCXRelativeTo.EbpfFuncName != "" {
+			relative, ok := p.manager.GetProbe(p.TCXRelativeTo)
+			if !ok || relative.program == nil {
+				return 0, 0, errors.New(fmt.Sprintf("error:%v , couldn't find the relative probe %s", ErrMissingTCXRelative, p.TCXRelativeTo))
+			}
+			return flags, uint32(relative.program.FD()), nil
+		}
+		if p.TCXRelativeProgramID != 0 {
+			return flags | bpfFID, uint32(p.TCXRelativeProgramID), nil
+		}
+		return 0, 0, ErrMissingTCXRelative
+	default:
+		return 0, 0, errors.New(fmt.Sprintf("error:%v , unknown tcx order %d", ErrTCXAttachFailed, p.TCXOrder))
+	}
+}
+
+// attachTCX - Attaches the probe to its interface with a tcx link
+func (p *Probe) attachTCX() error {
+	attachType, err := p.tcxAttachType()
+	if err != nil {
+		return err
+	}
+	flags, relative, err := p.tcxRelative()
+	if err != nil {
+		return err
+	}
+	return runInNetns(p.NetnsPath, func() error {
+		// the relative file descriptor or ID shares the union of bpf_attr.link_create with the target BTF ID
+		l, err := link.AttachRawLink(link.RawLinkOptions{
+			Target:  int(p.Ifindex),
+			Program: p.program,
+			Attach:  attachType,
+			BTF:     btf.TypeID(relative),
+			Flags:   flags,
+		})
+		if err != nil {
+			return fmt.Errorf("error:%w , couldn't attach probe %v to interface %v: %v", ErrTCXAttachFailed, p.GetIdentificationPair(), p.Ifindex, err)
+		}
+		p.link = l
+		return nil
+	})
+}
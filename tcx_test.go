@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+// queryTCXPrograms - Returns the IDs of the tcx programs of the provided interface, in order of execution
+func queryTCXPrograms(t *testing.T, netns string, ifindex int32, attachType ebpf.AttachType) []uint32 {
+	ids := make([]uint32, 8)
+	// union bpf_attr, query variant
+	attr := struct {
+		targetIfindex   uint32
+		attachType      uint32
+		queryFlags      uint32
+		attachFlags     uint32
+		progIDs         uint64
+		count           uint32
+		_               uint32
+		progAttachFlags uint64
+		linkIDs         uint64
+		linkAttachFlags uint64
+		revision        uint64
+	}{
+		targetIfindex: uint32(ifindex),
+		attachType:    uint32(attachType),
+		progIDs:       uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		count:         uint32(len(ids)),
+	}
+	err := runInNetns(netns, func() error {
+		_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_QUERY, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids[:attr.count]
+}
+
+func TestTCXOrder(t *testing.T) {
+	if err := HaveTCX(); err != nil {
+		t.Skip(err)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+
+	m := &Manager{netlinkCache: make(map[netlinkCacheKey]*netlinkCacheValue)}
+	newProbe := func(name string, order TCXOrder) *Probe {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Type:    ebpf.SchedCLS,
+			License: "MIT",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, -1),
+				asm.Return(),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { prog.Close() })
+		p := &Probe{
+			manager:          m,
+			program:          prog,
+			programSpec:      &ebpf.ProgramSpec{Type: ebpf.SchedCLS},
+			EbpfFuncName:     name,
+			Ifindex:          1,
+			NetnsPath:        path,
+			NetworkDirection: Ingress,
+			TCAttachMode:     TCAttachModeTCX,
+			TCXOrder:         order,
+			TCXRelativeTo:    ProbeIdentificationPair{EbpfFuncName: "last"},
+		}
+		m.Probes = append(m.Probes, p)
+		return p
+	}
+	last := newProbe("last", TCXOrderDefault)
+	first := newProbe("first", TCXOrderFirst)
+	middle := newProbe("middle", TCXOrderBefore)
+	for _, p := range []*Probe{last, first, middle} {
+		if err := p.attachTCCLS(); err != nil {
+			t.Fatal(err)
+		}
+		defer p.detach()
+		if mode := p.GetTCAttachMode(); mode != TCAttachModeTCX {
+			t.Fatalf("expected the probe to be attached with tcx, got %s", mode)
+		}
+	}
+
+	var expected []uint32
+	for _, p := range []*Probe{first, middle, last} {
+		info, err := p.program.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := info.ID()
+		expected = append(expected, uint32(id))
+	}
+	ids := queryTCXPrograms(t, path, 1, attachTCXIngress)
+	if len(ids) != len(expected) {
+		t.Fatalf("expected programs %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("expected programs %v, got %v", expected, ids)
+		}
+	}
+
+	missing := newProbe("missing", TCXOrderAfter)
+	missing.TCXRelativeTo = ProbeIdentificationPair{}
+	if err := missing.attachTCCLS(); err != ErrMissingTCXRelative {
+		t.Errorf("expected ErrMissingTCXRelative, got %v", err)
+	}
+}
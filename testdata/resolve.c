@@ -0,0 +1,13 @@
+/* Minimal binary with a stripped local function and a function imported from the libc */
+#include <stdio.h>
+
+__attribute__((noinline)) int resolve_target(int value)
+{
+	return value * 2;
+}
+
+int main(void)
+{
+	puts("resolve");
+	return resolve_target(21);
+}
@@ -0,0 +1,30 @@
+/* Minimal binary with a USDT marker, the note is emitted the same way as sys/sdt.h */
+unsigned short test_probe_semaphore __attribute__((section(".probes"))) __attribute__((used));
+
+int main(void)
+{
+	__asm__ __volatile__(
+		"990: nop\n"
+		".pushsection .note.stapsdt,\"?\",\"note\"\n"
+		".balign 4\n"
+		".4byte 992f-991f, 994f-993f, 3\n"
+		"991: .asciz \"stapsdt\"\n"
+		"992: .balign 4\n"
+		"993: .8byte 990b\n"
+		".8byte _.stapsdt.base\n"
+		".8byte test_probe_semaphore\n"
+		".asciz \"manager\"\n"
+		".asciz \"test_probe\"\n"
+		".asciz \"-4@%edi\"\n"
+		"994: .balign 4\n"
+		".popsection\n"
+		".ifndef _.stapsdt.base\n"
+		".pushsection .stapsdt.base,\"aG\",\"progbits\",.stapsdt.base,comdat\n"
+		".weak _.stapsdt.base\n"
+		".hidden _.stapsdt.base\n"
+		"_.stapsdt.base: .space 1\n"
+		".size _.stapsdt.base, 1\n"
+		".popsection\n"
+		".endif\n");
+	return 0;
+}
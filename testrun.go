@@ -0,0 +1,184 @@
+package manager
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// TestRunOptions - Input of a test run of a program, see Manager.TestRunProgramWithOptions
+type TestRunOptions struct {
+	// Data - Input data of the program, for example a packet for XDP and TC programs
+	Data []byte
+
+	// DataOutSize - Size of the buffer receiving the output data. Defaults to the size of Data plus 256 bytes, so that
+	// the programs growing the packet fit. The buffer is enlarged if the output doesn't fit.
+	DataOutSize int
+
+	// Context - Input context of the program, for example a struct __sk_buff or a struct xdp_md (kernel 5.2+ for TC,
+	// 5.14+ for XDP). The context of tracing programs is the array of the arguments of the program.
+	Context []byte
+
+	// ContextOutSize - Size of the buffer receiving the output context. Defaults to the size of Context. The buffer is
+	// enlarged if the output doesn't fit.
+	ContextOutSize int
+
+	// Repeat - Number of times the program is run, the reported duration is the average of the runs. Defaults to 1.
+	Repeat int
+
+	// Flags - BPF_F_TEST_RUN_* flags of the run
+	Flags uint32
+
+	// CPU - CPU the program runs on, requires the BPF_F_TEST_RUN_ON_CPU flag
+	CPU uint32
+}
+
+// TestRunResult - Output of a test run of a program
+type TestRunResult struct {
+	// ReturnValue - Value returned by the program, for example the XDP action or the TC verdict
+	ReturnValue uint32
+
+	// DataOut - Output data of the program, for example the packet modified by the program
+	DataOut []byte
+
+	// ContextOut - Output context of the program, if the run had an input context
+	ContextOut []byte
+
+	// Duration - Average duration of a run of the program
+	Duration time.Duration
+}
+
+// testRunAttr - union bpf_attr, test variant
+type testRunAttr struct {
+	progFD      uint32
+	retval      uint32
+	dataSizeIn  uint32
+	dataSizeOut uint32
+	dataIn      uint64
+	dataOut     uint64
+	repeat      uint32
+	duration    uint32
+	ctxSizeIn   uint32
+	ctxSizeOut  uint32
+	ctxIn       uint64
+	ctxOut      uint64
+	flags       uint32
+	cpu         uint32
+}
+
+// bufferAddress - Returns the address of the first byte of the provided buffer, or 0 if it is empty
+func bufferAddress(buf []byte) uint64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(&buf[0])))
+}
+
+// TestRunProgram - Runs the program of the provided function (or the program of the first probe with the provided
+// section) on the input data, with BPF_PROG_TEST_RUN, and returns its output data, its return value and the average
+// duration of the runs. repeat is the number of runs, defaults to 1. See TestRunProgramWithOptions to provide an input
+// context.
+func (m *Manager) TestRunProgram(funcName string, input []byte, repeat int) ([]byte, uint32, time.Duration, error) {
+	result, err := m.TestRunProgramWithOptions(funcName, TestRunOptions{Data: input, Repeat: repeat})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return result.DataOut, result.ReturnValue, result.Duration, nil
+}
+
+// TestRunProgramWithOptions - Runs the program of the provided function (or the program of the first probe with the
+// provided section) with BPF_PROG_TEST_RUN, with the provided options
+func (m *Manager) TestRunProgramWithOptions(funcName string, options TestRunOptions) (*TestRunResult, error) {
+	prog, err := m.testRunProgram(funcName)
+	if err != nil {
+		return nil, err
+	}
+	return testRun(prog, options)
+}
+
+// testRunProgram - Returns the program of the provided function, or the program of the first probe with the provided
+// section
+func (m *Manager) testRunProgram(funcName string) (*ebpf.Program, error) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	if m.collection == nil || m.state < initialized {
+		return nil, ErrManagerNotInitialized
+	}
+	for _, probe := range m.Probes {
+		if (probe.EbpfFuncName == funcName || probe.Section == funcName) && probe.program != nil {
+			return probe.program, nil
+		}
+	}
+	if prog, ok := m.collection.Programs[funcName]; ok {
+		return prog, nil
+	}
+	return nil, fmt.Errorf("error:%w , %s", ErrUnknownMatchFuncName, funcName)
+}
+
+// testRun - Runs the provided program with BPF_PROG_TEST_RUN
+func testRun(prog *ebpf.Program, options TestRunOptions) (*TestRunResult, error) {
+	repeat := options.Repeat
+	if repeat <= 0 {
+		repeat = 1
+	}
+	dataOut := make([]byte, options.DataOutSize)
+	if options.DataOutSize <= 0 && len(options.Data) > 0 {
+		dataOut = make([]byte, len(options.Data)+256)
+	}
+	var ctxOut []byte
+	if options.ContextOutSize > 0 {
+		ctxOut = make([]byte, options.ContextOutSize)
+	} else if len(options.Context) > 0 {
+		ctxOut = make([]byte, len(options.Context))
+	}
+
+	for {
+		attr := testRunAttr{
+			progFD:      uint32(prog.FD()),
+			dataSizeIn:  uint32(len(options.Data)),
+			dataSizeOut: uint32(len(dataOut)),
+			dataIn:      bufferAddress(options.Data),
+			dataOut:     bufferAddress(dataOut),
+			repeat:      uint32(repeat),
+			ctxSizeIn:   uint32(len(options.Context)),
+			ctxSizeOut:  uint32(len(ctxOut)),
+			ctxIn:       bufferAddress(options.Context),
+			ctxOut:      bufferAddress(ctxOut),
+			flags:       options.Flags,
+			cpu:         options.CPU,
+		}
+		_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_TEST_RUN, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		runtime.KeepAlive(options.Data)
+		runtime.KeepAlive(options.Context)
+		runtime.KeepAlive(dataOut)
+		runtime.KeepAlive(ctxOut)
+		if errno == unix.ENOSPC && (attr.dataSizeOut > uint32(len(dataOut)) || attr.ctxSizeOut > uint32(len(ctxOut))) {
+			// the output data or context doesn't fit, the kernel reports its actual size
+			if attr.dataSizeOut > uint32(len(dataOut)) {
+				dataOut = make([]byte, attr.dataSizeOut)
+			}
+			if attr.ctxSizeOut > uint32(len(ctxOut)) {
+				ctxOut = make([]byte, attr.ctxSizeOut)
+			}
+			continue
+		}
+		if errno != 0 {
+			return nil, fmt.Errorf("error:%w , %v", ErrTestRunFailed, errno)
+		}
+		result := &TestRunResult{
+			ReturnValue: attr.retval,
+			Duration:    time.Duration(attr.duration),
+		}
+		if len(dataOut) > 0 {
+			result.DataOut = dataOut[:attr.dataSizeOut]
+		}
+		if len(ctxOut) > 0 {
+			result.ContextOut = ctxOut[:attr.ctxSizeOut]
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestTestRunProgram(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// xdp_tx: writes 0xaa to the first byte of the packet and returns XDP_TX
+	xdp, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.XDP,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.LoadMem(asm.R2, asm.R1, 0, asm.Word),
+			asm.LoadMem(asm.R3, asm.R1, 4, asm.Word),
+			asm.Mov.Reg(asm.R4, asm.R2),
+			asm.Add.Imm(asm.R4, 1),
+			asm.JGT.Reg(asm.R4, asm.R3, "out"),
+			asm.StoreImm(asm.R2, 0, 0xaa, asm.Byte),
+			asm.Mov.Imm(asm.R0, 3).WithSymbol("out"),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xdp.Close()
+	// tc_mark: returns skb->mark
+	cls, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.SchedCLS,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.LoadMem(asm.R0, asm.R1, 8, asm.Word),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cls.Close()
+
+	m := &Manager{
+		collection: &ebpf.Collection{Programs: map[string]*ebpf.Program{"xdp_tx": xdp}},
+		state:      initialized,
+		Probes:     []*Probe{{Section: "classifier/mark", EbpfFuncName: "tc_mark", program: cls}},
+	}
+
+	packet := make([]byte, 64)
+	out, ret, _, err := m.TestRunProgram("xdp_tx", packet, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 3 {
+		t.Errorf("expected XDP_TX, got %d", ret)
+	}
+	if len(out) != len(packet) || out[0] != 0xaa {
+		t.Errorf("unexpected output data %x", out)
+	}
+
+	// __sk_buff up to the mark field
+	ctx := make([]byte, 12)
+	binary.LittleEndian.PutUint32(ctx[8:], 42)
+	result, err := m.TestRunProgramWithOptions("classifier/mark", TestRunOptions{Data: packet, Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReturnValue != 42 {
+		t.Errorf("expected the mark of the context, got %d", result.ReturnValue)
+	}
+	if len(result.ContextOut) < len(ctx) || binary.LittleEndian.Uint32(result.ContextOut[8:]) != 42 {
+		t.Errorf("unexpected output context %x", result.ContextOut)
+	}
+
+	if _, _, _, err = m.TestRunProgram("unknown", packet, 1); !errors.Is(err, ErrUnknownMatchFuncName) {
+		t.Errorf("expected ErrUnknownMatchFuncName for an unknown program, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	// SampleRateConstant - Constant rewritten with the Probe.SampleRate of the probes that aren't perf_event probes.
+	// The program declares it as a global constant (volatile const __u64 ebpfmanager_sample_rate) or loads it with the
+	// load_constant asm macro, and handles one out of SampleRate events.
+	SampleRateConstant = "ebpfmanager_sample_rate"
+
+	// MaxEventsPerSecConstant - Constant rewritten with the Probe.MaxEventsPerSec of the probes that aren't perf_event
+	// probes. The program declares it like SampleRateConstant, and drops the events above this rate, for example with a
+	// per-CPU counter reset every second.
+	MaxEventsPerSecConstant = "ebpfmanager_max_events_per_sec"
+)
+
+// isThrottled - Returns true if the probe sets SampleRate or MaxEventsPerSec
+func (p *Probe) isThrottled() bool {
+	return p.SampleRate > 1 || p.MaxEventsPerSec > 0
+}
+
+// throttleConstantEditors - Returns the constant editors applying the SampleRate and MaxEventsPerSec of the probes
+// that aren't perf_event probes. The constants are required: a program that doesn't read them can't be throttled.
+func (m *Manager) throttleConstantEditors() []ConstantEditor {
+	var editors []ConstantEditor
+	for _, probe := range m.Probes {
+		if !probe.isThrottled() || probe.skipReason != nil || probe.programSpec == nil || probe.programSpec.Type == ebpf.PerfEvent {
+			continue
+		}
+		ids := []ProbeIdentificationPair{probe.GetIdentificationPair()}
+		if probe.SampleRate > 1 {
+			editors = append(editors, ConstantEditor{Name: SampleRateConstant, Value: probe.SampleRate, FailOnMissing: true, ProbeIdentificationPairs: ids})
+		}
+		if probe.MaxEventsPerSec > 0 {
+			editors = append(editors, ConstantEditor{Name: MaxEventsPerSecConstant, Value: probe.MaxEventsPerSec, FailOnMissing: true, ProbeIdentificationPairs: ids})
+		}
+	}
+	return editors
+}
+
+// perfEventSample - (perf_event) Returns the sample period, or the sample frequency if freq is true, of the perf
+// events of the probe, once SampleRate and MaxEventsPerSec are applied
+func (p *Probe) perfEventSample() (sample uint64, freq bool, err error) {
+	if p.SampleFrequency != 0 && p.SamplePeriod != 0 {
+		return 0, false, fmt.Errorf("error:%w , SampleFrequency and SamplePeriod are exclusive", ErrInvalidPerfEvent)
+	}
+	if p.SamplePeriod != 0 || (p.SampleFrequency == 0 && p.MaxEventsPerSec == 0) {
+		if p.MaxEventsPerSec > 0 {
+			return 0, false, fmt.Errorf("error:%w , MaxEventsPerSec requires SampleFrequency, not SamplePeriod", ErrInvalidPerfEvent)
+		}
+		// one sample every SampleRate periods
+		sample = p.SamplePeriod
+		if sample == 0 {
+			sample = 1
+		}
+		if p.SampleRate > 1 {
+			sample *= p.SampleRate
+		}
+		return sample, false, nil
+	}
+
+	sample = p.SampleFrequency
+	if p.SampleRate > 1 {
+		sample /= p.SampleRate
+		if sample == 0 {
+			sample = 1
+		}
+	}
+	if p.MaxEventsPerSec > 0 && (sample == 0 || sample > p.MaxEventsPerSec) {
+		sample = p.MaxEventsPerSec
+	}
+	return sample, true, nil
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestPerfEventSample(t *testing.T) {
+	tests := []struct {
+		probe  *Probe
+		sample uint64
+		freq   bool
+		err    error
+	}{
+		{probe: &Probe{SamplePeriod: 100, SampleRate: 10}, sample: 1000},
+		{probe: &Probe{SampleRate: 10}, sample: 10},
+		{probe: &Probe{SampleFrequency: 1000, SampleRate: 10}, sample: 100, freq: true},
+		{probe: &Probe{SampleFrequency: 1000, MaxEventsPerSec: 50}, sample: 50, freq: true},
+		{probe: &Probe{MaxEventsPerSec: 50}, sample: 50, freq: true},
+		{probe: &Probe{SamplePeriod: 100, MaxEventsPerSec: 50}, err: ErrInvalidPerfEvent},
+		{probe: &Probe{SamplePeriod: 100, SampleFrequency: 1000}, err: ErrInvalidPerfEvent},
+	}
+	for i, test := range tests {
+		sample, freq, err := test.probe.perfEventSample()
+		if !errors.Is(err, test.err) {
+			t.Errorf("test %d: expected %v, got %v", i, test.err, err)
+			continue
+		}
+		if sample != test.sample || freq != test.freq {
+			t.Errorf("test %d: expected %d (freq %v), got %d (freq %v)", i, test.sample, test.freq, sample, freq)
+		}
+	}
+}
+
+func TestThrottleConstants(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// the program returns its sample rate
+	newSpec := func(constant string) *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"sample_rate": {
+				Name:        "sample_rate",
+				Type:        ebpf.SocketFilter,
+				SectionName: "socket/sample_rate",
+				License:     "GPL",
+				Instructions: asm.Instructions{
+					asm.LoadImm(asm.R0, 0, asm.DWord).WithReference(constant),
+					asm.Return(),
+				},
+			},
+		}}
+	}
+	newManager := func() *Manager {
+		return &Manager{Probes: []*Probe{{Section: "socket/sample_rate", EbpfFuncName: "sample_rate", SampleRate: 7}}}
+	}
+
+	m := newManager()
+	if err := m.InitWithAssets([]CollectionAsset{{Spec: newSpec(SampleRateConstant)}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop(CleanAll)
+	ret, _, err := m.Probes[0].Test(make([]byte, 14))
+	if err != nil {
+		t.Skipf("BPF_PROG_TEST_RUN not supported: %v", err)
+	}
+	if ret != 7 {
+		t.Errorf("expected the program to read a sample rate of 7, got %d", ret)
+	}
+
+	// a program that doesn't read the constant can't be throttled
+	if err = newManager().InitWithAssets([]CollectionAsset{{Spec: newSpec("other")}}, Options{}); err == nil {
+		t.Error("expected a program without the sample rate constant to be rejected")
+	}
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	selfStartTimeOnce  sync.Once
+	selfStartTimeValue uint64
+)
+
+// processStartTime - Returns the start time of the provided process, in clock ticks since boot
+func processStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name can contain spaces and parentheses, the fields that follow it start after the last parenthesis
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, errors.New(fmt.Sprintf("invalid stat file for process %d", pid))
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	// starttime is the 22nd field, the fields after the command name start at the 3rd
+	if len(fields) < 20 {
+		return 0, errors.New(fmt.Sprintf("invalid stat file for process %d", pid))
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// selfStartTime - Returns the start time of the current process, 0 if it isn't available
+func selfStartTime() uint64 {
+	selfStartTimeOnce.Do(func() {
+		selfStartTimeValue, _ = processStartTime(os.Getpid())
+	})
+	return selfStartTimeValue
+}
+
+// isStaleTracefsEvent - Returns true if the provided event of the manager group was created by a process that exited:
+// its name ends with the PID and the start time of the process, followed by a counter
+func isStaleTracefsEvent(name string) bool {
+	fields := strings.Split(name, "_")
+	if len(fields) < 5 {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[len(fields)-3])
+	if err != nil || pid <= 0 {
+		return false
+	}
+	startTime, err := strconv.ParseUint(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return false
+	}
+	current, err := processStartTime(pid)
+	if err != nil {
+		// the process exited
+		return errors.Is(err, os.ErrNotExist)
+	}
+	// the PID was reused by another process
+	return current != startTime
+}
+
+// cleanupStaleEventsFile - Removes the stale events of the manager group from the provided kprobe_events or
+// uprobe_events file. Returns the number of removed events.
+func cleanupStaleEventsFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var stale []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// p:group/name target, r16:group/name target...
+		definition := strings.Fields(scanner.Text())
+		if len(definition) == 0 {
+			continue
+		}
+		i := strings.IndexByte(definition[0], ':')
+		if i < 0 {
+			continue
+		}
+		event := definition[0][i+1:]
+		group, name, ok := strings.Cut(event, "/")
+		if ok && group == kprobeEventsGroup && isStaleTracefsEvent(name) {
+			stale = append(stale, event)
+		}
+	}
+	err = scanner.Err()
+	_ = f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, event := range stale {
+		out, errTmp := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if errTmp == nil {
+			_, errTmp = out.WriteString("-:" + event)
+			_ = out.Close()
+		}
+		if errTmp != nil {
+			err = ConcatErrors(err, fmt.Errorf("couldn't remove stale event %s: %w", event, errTmp))
+			continue
+		}
+		removed++
+	}
+	return removed, err
+}
+
+// CleanupStaleTracefsEvents - Removes the kprobe and uprobe events left in tracefs by the processes that exited
+// without removing them, after a crash for example, so that they don't leak probe slots. The events created by the
+// manager through kprobe_events (see AttachKprobeWithKprobeEvents) are named after the PID and the start time of their
+// process, the events of the processes that are still running are left intact. Called at Init for the managers that
+// have kprobes. Returns the number of removed events.
+func CleanupStaleTracefsEvents() (int, error) {
+	tracefsLock.RLock()
+	roots := append([]string(nil), tracefsRoots...)
+	tracefsLock.RUnlock()
+
+	var err error
+	removed := 0
+	for _, root := range roots {
+		for _, file := range []string{"kprobe_events", "uprobe_events"} {
+			n, errTmp := cleanupStaleEventsFile(filepath.Join(root, file))
+			removed += n
+			err = ConcatErrors(err, errTmp)
+		}
+	}
+	return removed, err
+}
@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// deadPID - PID above the maximum PID of the kernel, it never belongs to a running process
+const deadPID = 1 << 23
+
+func TestIsStaleTracefsEvent(t *testing.T) {
+	if isStaleTracefsEvent(kprobeEventName("do_nanosleep", false)) {
+		t.Error("expected the events of the current process to be kept")
+	}
+	if !isStaleTracefsEvent(fmt.Sprintf("p_do_nanosleep_%d_%d_1", os.Getpid(), selfStartTime()+1)) {
+		t.Error("expected the events of a reused PID to be stale")
+	}
+	if !isStaleTracefsEvent(fmt.Sprintf("r_do_nanosleep_%d_42_1", deadPID)) {
+		t.Error("expected the events of an exited process to be stale")
+	}
+	for _, name := range []string{"p_do_nanosleep_42_1", "other_event", "p_do_sys_open_1_2"} {
+		if isStaleTracefsEvent(name) {
+			t.Errorf("expected %s to be left intact", name)
+		}
+	}
+}
+
+func TestCleanupStaleTracefsEvents(t *testing.T) {
+	var uprobeEvents string
+	for _, root := range tracefsRoots {
+		if _, err := os.Stat(filepath.Join(root, "uprobe_events")); err == nil {
+			uprobeEvents = filepath.Join(root, "uprobe_events")
+			break
+		}
+	}
+	if uprobeEvents == "" {
+		t.Skip("uprobe_events not found")
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(line string) error {
+		f, err := os.OpenFile(uprobeEvents, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteString(line)
+		return err
+	}
+	stale := fmt.Sprintf("p_test_%d_42_1", deadPID)
+	live := fmt.Sprintf("p_test_%d_%d_1", os.Getpid(), selfStartTime())
+	for _, name := range []string{stale, live} {
+		if err = write(fmt.Sprintf("p:%s/%s %s:0x1000", kprobeEventsGroup, name, binary)); err != nil {
+			t.Skipf("couldn't create uprobe event: %v", err)
+		}
+	}
+	defer write(fmt.Sprintf("-:%s/%s", kprobeEventsGroup, live))
+
+	removed, err := CleanupStaleTracefsEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 stale event to be removed, got %d", removed)
+	}
+	events, err := os.ReadFile(uprobeEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(events), stale) {
+		t.Error("expected the stale event to be removed")
+	}
+	if !strings.Contains(string(events), live) {
+		t.Error("expected the event of the current process to be kept")
+	}
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+// isBPFTracingSpec - Returns true if the program of the probe is an fentry or fexit program traced on another program
+// of the manager, see AttachTarget
+func (p *Probe) isBPFTracingSpec() bool {
+	return p.AttachTarget != "" && p.programSpec != nil && p.isTrampolineSpec()
+}
+
+// tracedFuncName - (fentry / fexit on BPF) Returns the function of the target program traced by the probe
+func (p *Probe) tracedFuncName() string {
+	if p.AttachToFuncName != "" {
+		return p.AttachToFuncName
+	}
+	if p.programSpec.AttachTo != "" {
+		return p.programSpec.AttachTo
+	}
+	return p.AttachTarget
+}
+
+// resolveTracingTarget - (fentry / fexit on BPF) Resolves the program traced by the probe, and prepares the spec of the
+// probe to be loaded against the traced function
+func (p *Probe) resolveTracingTarget() error {
+	target, ok := p.manager.collection.Programs[p.AttachTarget]
+	if !ok {
+		return fmt.Errorf("error:%w , couldn't find program %s for probe %s", ErrTracingTarget, p.AttachTarget, p.GetIdentificationPair())
+	}
+	// the program belongs to the collection, use a duplicate so that the probe can close it
+	target, err := target.Clone()
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't duplicate program %s", err, p.AttachTarget))
+	}
+	return p.loadAgainst(target, p.tracedFuncName())
+}
+
+// attachBPFTracing - (fentry / fexit on BPF) Attaches the probe to the traced function of its target program. The
+// target is provided again so that the probe can be attached again after it was detached.
+func (p *Probe) attachBPFTracing() error {
+	typeID, err := p.tracedFuncTypeID()
+	if err == nil {
+		var l *link.RawLink
+		if l, err = link.AttachRawLink(link.RawLinkOptions{
+			Target:  p.targetProgram.FD(),
+			Program: p.program,
+			Attach:  p.programSpec.AttachType,
+			BTF:     typeID,
+		}); err == nil {
+			p.link = l
+			return nil
+		}
+	}
+	// the kernels older than 5.10 only attach the tracing programs to the target they were loaded against, once
+	if l, errTracing := link.AttachTracing(link.TracingOptions{Program: p.program}); errTracing == nil {
+		p.link = l
+		return nil
+	}
+	return errors.New(fmt.Sprintf("error:%v , couldn't trace function %s of program %s with probe %v", err, p.tracedFuncName(), p.AttachTarget, p.GetIdentificationPair()))
+}
+
+// tracedFuncTypeID - (fentry / fexit on BPF) Returns the BTF type ID of the traced function in the BTF of the target
+// program
+func (p *Probe) tracedFuncTypeID() (btf.TypeID, error) {
+	handle, err := p.targetProgram.Handle()
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+	spec, err := handle.Spec()
+	if err != nil {
+		return 0, err
+	}
+	var fn *btf.Func
+	if err = spec.TypeByName(p.tracedFuncName(), &fn); err != nil {
+		return 0, err
+	}
+	return spec.TypeID(fn)
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestAttachTarget(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher, err := ebpf.NewProgram(newXDPDispatcherSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, err := ebpf.NewMap(&ebpf.MapSpec{Name: "counter", Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{collection: &ebpf.Collection{
+		Programs: map[string]*ebpf.Program{xdpDispatcherName: dispatcher},
+		Maps:     map[string]*ebpf.Map{"counter": counter},
+	}}
+	defer m.collection.Close()
+
+	// fentry: counts the runs of the dispatcher
+	p := &Probe{
+		Section:      "fentry/" + xdpDispatcherName,
+		EbpfFuncName: "count_runs",
+		AttachTarget: "missing",
+		Enabled:      true,
+		programSpec: &ebpf.ProgramSpec{
+			Name:       "count_runs",
+			Type:       ebpf.Tracing,
+			AttachType: ebpf.AttachTraceFEntry,
+			License:    "GPL",
+			Instructions: asm.Instructions{
+				asm.StoreImm(asm.RFP, -4, 0, asm.Word),
+				asm.LoadMapPtr(asm.R1, 0).WithReference("counter"),
+				asm.Mov.Reg(asm.R2, asm.RFP),
+				asm.Add.Imm(asm.R2, -4),
+				asm.FnMapLookupElem.Call(),
+				asm.JEq.Imm(asm.R0, 0, "exit"),
+				asm.Mov.Imm(asm.R1, 1),
+				asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+				asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+				asm.Return(),
+			},
+		},
+	}
+	if err = p.Init(m); !errors.Is(err, ErrTracingTarget) {
+		t.Errorf("expected ErrTracingTarget, got %v", err)
+	}
+
+	if err = HaveTrampolines(); err != nil {
+		t.Skip(err)
+	}
+	p.AttachTarget = xdpDispatcherName
+	if err = p.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	defer p.program.Close()
+	runs := func() uint64 {
+		if _, _, err := dispatcher.Test(make([]byte, 64)); err != nil {
+			t.Fatal(err)
+		}
+		var count uint64
+		if err := counter.Lookup(uint32(0), &count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if err = p.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if count := runs(); count != 1 {
+		t.Errorf("expected the run of the dispatcher to be traced, got %d", count)
+	}
+
+	// the probe can be attached again once detached
+	if err = p.detach(); err != nil {
+		t.Fatal(err)
+	}
+	if count := runs(); count != 1 {
+		t.Errorf("expected the run of the dispatcher not to be traced once detached, got %d", count)
+	}
+	if err = p.attachBPFTracing(); err != nil {
+		t.Fatal(err)
+	}
+	if count := runs(); count != 2 {
+		t.Errorf("expected the probe to be attached again, got %d", count)
+	}
+	if err = p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if p.targetProgram != nil {
+		t.Error("expected the target program to be released")
+	}
+}
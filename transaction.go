@@ -0,0 +1,218 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/hashicorp/go-multierror"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// replacedProgram - Program of another application that a probe replaced on its hook point when it was attached
+// (XDP program of an interface, cgroup program attached without BPF_F_ALLOW_MULTI or replaced with BPF_F_REPLACE, TC
+// filter replaced by a classifier with TCFilterReplace). It is attached back if Start rolls back.
+type replacedProgram struct {
+	id      ebpf.ProgramID
+	restore func(prog *ebpf.Program) error
+}
+
+// Restore - Attaches the replaced program back to its hook point, if it still exists
+func (r *replacedProgram) Restore() error {
+	prog, err := ebpf.NewProgramFromID(r.id)
+	if err != nil {
+		// the program was unloaded in the meantime
+		return nil
+	}
+	defer prog.Close()
+	if err = r.restore(prog); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't restore program %d", err, r.id))
+	}
+	return nil
+}
+
+// isMandatory - Returns true if the failure of the probe to attach aborts Start, see Optional. selected are the probes
+// selected by a mandatory selector, see mandatoryProbes.
+func (p *Probe) isMandatory(selected []ProbeIdentificationPair) bool {
+	if !p.Enabled || p.Optional || p.skipReason != nil {
+		return false
+	}
+	for _, id := range selected {
+		if p.IdentificationPairMatches(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// mandatoryProbes - Returns the probes selected by the provided selectors which must be running: the probes of a
+// ProbeSelector, directly or in an AllOf. The probes of a OneOf or of a BestEffort may fail to attach, the selectors
+// validate them once all the probes were attached.
+func mandatoryProbes(selectors []ProbesSelector) []ProbeIdentificationPair {
+	var selected []ProbeIdentificationPair
+	for _, selector := range selectors {
+		switch s := selector.(type) {
+		case *ProbeSelector:
+			selected = append(selected, s.ProbeIdentificationPair)
+		case *AllOf:
+			selected = append(selected, mandatoryProbes(s.Selectors)...)
+		}
+	}
+	return selected
+}
+
+// replacedXDPProgram - (XDP) Returns the XDP program of the interface of the probe, which the probe is about to
+// replace. A program is only replaced in the attach mode of the probe, it is restored in the same mode.
+func (p *Probe) replacedXDPProgram(nlink netlink.Link) *replacedProgram {
+	xdp := nlink.Attrs().Xdp
+	if xdp == nil || !xdp.Attached || xdp.ProgId == 0 {
+		return nil
+	}
+	ifindex, netnsPath, mode := int(p.Ifindex), p.NetnsPath, int(p.XDPAttachMode)
+	return &replacedProgram{
+		id: ebpf.ProgramID(xdp.ProgId),
+		restore: func(prog *ebpf.Program) error {
+			return runInNetns(netnsPath, func() error {
+				nlink, err := netlink.LinkByIndex(ifindex)
+				if err != nil {
+					return err
+				}
+				return netlink.LinkSetXdpFdWithFlags(nlink, prog.FD(), mode)
+			})
+		},
+	}
+}
+
+// replacedCGroupProgram - (cgroup) Returns the program of the cgroup at the provided path which the probe is about to
+// replace: CGroupReplaceProgramID with CGroupAttachReplace, or the program attached to the cgroup when the probe
+// doesn't set CGroupAttachAllowMulti
+func (p *Probe) replacedCGroupProgram(path string) *replacedProgram {
+	var id ebpf.ProgramID
+	if p.CGroupAttachFlags&CGroupAttachReplace != 0 {
+		id = p.CGroupReplaceProgramID
+	} else if p.CGroupAttachFlags&CGroupAttachAllowMulti == 0 {
+		ids, err := link.QueryPrograms(link.QueryOptions{Path: path, Attach: p.programSpec.AttachType})
+		if err != nil || len(ids) != 1 {
+			return nil
+		}
+		id = ids[0]
+	}
+	if id == 0 {
+		return nil
+	}
+	attachType, flags := p.programSpec.AttachType, uint32(p.CGroupAttachFlags&^CGroupAttachReplace)
+	return &replacedProgram{
+		id: id,
+		restore: func(prog *ebpf.Program) error {
+			cgroup, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer cgroup.Close()
+			return link.RawAttachProgram(link.RawAttachProgramOptions{
+				Target:  int(cgroup.Fd()),
+				Program: prog,
+				Attach:  attachType,
+				Flags:   flags,
+			})
+		},
+	}
+}
+
+// replacedTCFilter - (TC classifier) Returns the filter of the interface with the TCFilterHandle and the TCFilterPrio of
+// the probe, which the probe is about to replace
+func (p *Probe) replacedTCFilter(ntl *netlinkCacheValue, msg tc.Msg) *replacedProgram {
+	if p.TCFilterHandle == 0 || p.TCFilterPrio == 0 {
+		return nil
+	}
+	filters, err := ntl.rtNetlink.Filter().Get(&tc.Msg{Family: unix.AF_UNSPEC, Ifindex: msg.Ifindex, Parent: msg.Parent})
+	if err != nil {
+		return nil
+	}
+	for _, filter := range filters {
+		if filter.Handle != msg.Handle || filter.Info>>16 != msg.Info>>16 || filter.BPF == nil || filter.BPF.ID == nil {
+			continue
+		}
+		replacedMsg := tc.Msg{Family: unix.AF_UNSPEC, Ifindex: msg.Ifindex, Handle: filter.Handle, Parent: msg.Parent, Info: filter.Info}
+		name, flags := filter.BPF.Name, filter.BPF.Flags
+		netns, netnsPath := p.IfindexNetns, p.NetnsPath
+		return &replacedProgram{
+			id: ebpf.ProgramID(*filter.BPF.ID),
+			restore: func(prog *ebpf.Program) error {
+				return runInNetns(netnsPath, func() error {
+					rtNetlink, err := tc.Open(&tc.Config{NetNS: int(netns)})
+					if err != nil {
+						return err
+					}
+					defer rtNetlink.Close()
+					// the clsact qdisc might have been deleted with the classifiers of the manager
+					_ = rtNetlink.Qdisc().Add(&tc.Object{
+						Msg: tc.Msg{
+							Family:  unix.AF_UNSPEC,
+							Ifindex: replacedMsg.Ifindex,
+							Handle:  core.BuildHandle(tc.HandleRoot, 0x0000),
+							Parent:  tc.HandleIngress,
+						},
+						Attribute: tc.Attribute{Kind: "clsact"},
+					})
+					fd := uint32(prog.FD())
+					return rtNetlink.Filter().Replace(&tc.Object{
+						Msg:       replacedMsg,
+						Attribute: tc.Attribute{Kind: "bpf", BPF: &tc.Bpf{FD: &fd, Name: name, Flags: flags}},
+					})
+				})
+			},
+		}
+	}
+	return nil
+}
+
+// attachProbes - Attaches the probes of the manager, all or none of the mandatory ones: when a mandatory probe fails to
+// attach, the probes attached so far are stopped and the programs they replaced are restored. Returns the attach
+// error of the probe, with the errors of the rollback.
+func (m *Manager) attachProbes() error {
+	selected := mandatoryProbes(m.options.ActivatedProbes)
+	for _, probe := range m.Probes {
+		// the errors of the optional probes are collected per probe, and surfaced by the activation validators if
+		// needed
+		_ = probe.Attach()
+		if probe.IsRunning() || m.startAttachRetry(probe) || !probe.isMandatory(selected) {
+			continue
+		}
+		err := probe.GetLastError()
+		if err == nil {
+			err = ErrProbeNotInitialized
+		}
+		return multierror.Append(fmt.Errorf("error:%w , mandatory probe %s couldn't be attached: %v", ErrStartRolledBack, probe.GetIdentificationPair(), err), m.rollbackProbes())
+	}
+	return nil
+}
+
+// rollbackProbes - Stops the probes of the manager, then restores the programs they replaced
+func (m *Manager) rollbackProbes() error {
+	var replaced []*replacedProgram
+	for _, probe := range m.Probes {
+		probe.stateLock.RLock()
+		if probe.replaced != nil {
+			replaced = append(replaced, probe.replaced)
+		}
+		probe.stateLock.RUnlock()
+	}
+	var err error
+	for _, probe := range m.Probes {
+		if e := probe.Stop(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	for i := len(replaced) - 1; i >= 0; i-- {
+		if e := replaced[i].Restore(); e != nil {
+			err = multierror.Append(err, e)
+		}
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/florianl/go-tc"
+	"github.com/florianl/go-tc/core"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestStartRollback(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	newSpec := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"xdp_pass": {
+				Name:        "xdp_pass",
+				Type:        ebpf.XDP,
+				SectionName: "xdp",
+				License:     "GPL",
+				Instructions: asm.Instructions{
+					asm.Mov.Imm(asm.R0, xdpPass),
+					asm.Return(),
+				},
+			},
+		}}
+	}
+	other, err := ebpf.NewProgram(newSpec().Programs["xdp_pass"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherInfo, _ := other.Info()
+	otherID, _ := otherInfo.ID()
+
+	// XDP program of the interface
+	attached := func() uint32 {
+		var id uint32
+		err := runInNetns(path, func() error {
+			lo, err := netlink.LinkByIndex(1)
+			if err == nil && lo.Attrs().Xdp != nil {
+				id = lo.Attrs().Xdp.ProgId
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// another application attached an XDP program to the interface
+	err = runInNetns(path, func() error {
+		lo, err := netlink.LinkByIndex(1)
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetXdpFdWithFlags(lo, other.FD(), int(XdpAttachModeSkb))
+	})
+	if err != nil {
+		t.Skipf("couldn't attach an XDP program: %v", err)
+	}
+
+	newManager := func(optional bool) *Manager {
+		return &Manager{Probes: []*Probe{
+			{UID: "lo", Section: "xdp", EbpfFuncName: "xdp_pass", Ifindex: 1, NetnsPath: path, XDPAttachMode: XdpAttachModeSkb},
+			// the interface doesn't exist
+			{UID: "missing", Section: "xdp", EbpfFuncName: "xdp_pass", Ifindex: 1000, NetnsPath: path, XDPAttachMode: XdpAttachModeSkb, Optional: optional},
+		}}
+	}
+
+	m := newManager(false)
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: newSpec()}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); !errors.Is(err, ErrStartRolledBack) {
+		t.Fatalf("expected ErrStartRolledBack, got %v", err)
+	}
+	if m.Probes[0].IsRunning() {
+		t.Error("expected the probes attached before the failure to be detached")
+	}
+	if id := attached(); id != uint32(otherID) {
+		t.Errorf("expected the XDP program %d of the other application to be restored, got %d", otherID, id)
+	}
+	_ = m.Stop(CleanAll)
+
+	// the failure of an optional probe doesn't abort Start
+	m = newManager(true)
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: newSpec()}}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Probes[0].IsRunning() || attached() == uint32(otherID) {
+		t.Error("expected the probe to replace the XDP program of the interface")
+	}
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+
+	// nor does the failure of a probe selected in a OneOf, as long as another one of its probes is running
+	m = newManager(false)
+	oneOf := &OneOf{Selectors: []ProbesSelector{
+		&ProbeSelector{ProbeIdentificationPair: ProbeIdentificationPair{UID: "missing", EbpfFuncName: "xdp_pass"}},
+		&ProbeSelector{ProbeIdentificationPair: ProbeIdentificationPair{UID: "lo", EbpfFuncName: "xdp_pass"}},
+	}}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: newSpec()}}, Options{ActivatedProbes: []ProbesSelector{oneOf}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Probes[0].IsRunning() {
+		t.Error("expected the probe of the OneOf selector to be running")
+	}
+	if err = m.Stop(CleanAll); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartRollbackTCFilter(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	path := newNetns(t)
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"classifier": {
+			Name:        "classifier",
+			Type:        ebpf.SchedCLS,
+			SectionName: "classifier",
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+		},
+		"xdp_pass": {
+			Name:        "xdp_pass",
+			Type:        ebpf.XDP,
+			SectionName: "xdp",
+			License:     "GPL",
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, xdpPass),
+				asm.Return(),
+			},
+		},
+	}}
+	other, err := ebpf.NewProgram(spec.Programs["classifier"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherInfo, _ := other.Info()
+	otherID, _ := otherInfo.ID()
+
+	// another application attached a TC filter with handle 7 and priority 42 to the interface
+	otherManager := &Manager{netlinkCache: make(map[netlinkCacheKey]*netlinkCacheValue)}
+	otherProbe := &Probe{
+		manager:          otherManager,
+		program:          other,
+		programSpec:      &ebpf.ProgramSpec{Type: ebpf.SchedCLS},
+		Section:          "classifier",
+		Ifindex:          1,
+		NetnsPath:        path,
+		NetworkDirection: Ingress,
+		TCFilterHandle:   7,
+		TCFilterPrio:     42,
+	}
+	if err = otherProbe.attachTCCLS(); err != nil {
+		t.Skipf("couldn't attach TC classifier: %v", err)
+	}
+	// program of the filter with handle 7 and priority 42
+	filterProgram := func() uint32 {
+		var id uint32
+		err := runInNetns(path, func() error {
+			rtNetlink, err := tc.Open(&tc.Config{})
+			if err != nil {
+				return err
+			}
+			defer rtNetlink.Close()
+			filters, err := rtNetlink.Filter().Get(&tc.Msg{
+				Family:  unix.AF_UNSPEC,
+				Ifindex: 1,
+				Parent:  core.BuildHandle(tc.HandleRoot, uint32(Ingress)),
+			})
+			for _, filter := range filters {
+				if filter.Handle == 7 && filter.Info>>16 == 42 && filter.BPF != nil && filter.BPF.ID != nil {
+					id = *filter.BPF.ID
+				}
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	for _, replace := range []bool{false, true} {
+		m := &Manager{Probes: []*Probe{
+			{UID: "lo", Section: "classifier", EbpfFuncName: "classifier", Ifindex: 1, NetnsPath: path, NetworkDirection: Ingress, TCFilterHandle: 7, TCFilterPrio: 42, TCFilterReplace: replace},
+			// the interface doesn't exist
+			{UID: "missing", Section: "xdp", EbpfFuncName: "xdp_pass", Ifindex: 1000, NetnsPath: path, XDPAttachMode: XdpAttachModeSkb},
+		}}
+		if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Start(); !errors.Is(err, ErrStartRolledBack) {
+			t.Errorf("replace %v: expected ErrStartRolledBack, got %v", replace, err)
+		}
+		if err = m.Probes[0].GetLastError(); replace && err != nil {
+			t.Errorf("expected the classifier to replace the TC filter before the rollback, got %v", err)
+		} else if !replace && err == nil {
+			t.Error("expected the classifier not to replace the TC filter without TCFilterReplace")
+		}
+		if id := filterProgram(); id != uint32(otherID) {
+			t.Errorf("replace %v: expected the TC filter %d of the other application to be left in place, got %d", replace, otherID, id)
+		}
+		_ = m.Stop(CleanAll)
+	}
+}
@@ -0,0 +1,225 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	// unprivilegedBPFSysctl - Sysctl that disables the bpf() syscall for the unprivileged users when it isn't 0
+	unprivilegedBPFSysctl = "/proc/sys/kernel/unprivileged_bpf_disabled"
+	// perfEventParanoidSysctl - Sysctl that restricts the perf events of the unprivileged users
+	perfEventParanoidSysctl = "/proc/sys/kernel/perf_event_paranoid"
+)
+
+// PrivilegeDegradation - Probe or map left out at Init because the process lacks the privileges it requires, see
+// Options.DegradeUnprivileged
+type PrivilegeDegradation struct {
+	// Probe - Skipped probe, empty for a map
+	Probe ProbeIdentificationPair
+
+	// Map - Name of the removed map, empty for a probe
+	Map string
+
+	// Reason - Missing privilege
+	Reason string
+}
+
+// privileges - Privileges of the process that decide which programs and maps it can use
+type privileges struct {
+	// bpf - CAP_BPF or CAP_SYS_ADMIN: any map can be created and any program loaded
+	bpf bool
+	// netAdmin - CAP_NET_ADMIN: the networking programs can be attached
+	netAdmin bool
+	// perfEvents - CAP_PERFMON, CAP_SYS_ADMIN or perf_event_paranoid <= 0: the per-CPU perf events of the perf maps
+	// can be opened
+	perfEvents bool
+}
+
+// currentPrivileges - Returns the privileges of the process
+func currentPrivileges() (privileges, error) {
+	effective, err := effectiveCapabilities()
+	if err != nil {
+		return privileges{}, err
+	}
+	version, err := CurrentKernelVersion()
+	if err != nil {
+		return privileges{}, err
+	}
+	has := func(capability Capability) bool {
+		return effective&(1<<uint(capability)) != 0
+	}
+	modern := version >= capabilitiesKernelVersion
+	priv := privileges{
+		bpf:        has(CapSysAdmin) || modern && has(CapBPF),
+		netAdmin:   has(CapNetAdmin),
+		perfEvents: has(CapSysAdmin) || modern && has(CapPerfmon),
+	}
+	if !priv.perfEvents {
+		paranoid, err := readSysctl(perfEventParanoidSysctl)
+		priv.perfEvents = err == nil && paranoid <= 0
+	}
+	return priv, nil
+}
+
+// readSysctl - Returns the integer value of the provided sysctl
+func readSysctl(path string) (int, error) {
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(value)))
+}
+
+// degradeUnprivileged - (Options.DegradeUnprivileged) Leaves out the probes and the maps that require privileges the
+// process doesn't have, so that Init loads the others. Returns ErrUnprivilegedBPFDisabled if the process can't use
+// bpf() at all.
+func (m *Manager) degradeUnprivileged() error {
+	m.degradations = nil
+	if !m.options.DegradeUnprivileged {
+		return nil
+	}
+	priv, err := currentPrivileges()
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't read the privileges of the process", err))
+	}
+	if priv.bpf {
+		return nil
+	}
+	if disabled, err := readSysctl(unprivilegedBPFSysctl); err != nil || disabled != 0 {
+		return fmt.Errorf("error:%w , %s is %d (%v)", ErrUnprivilegedBPFDisabled, unprivilegedBPFSysctl, disabled, err)
+	}
+	m.degrade(priv)
+	return nil
+}
+
+// degrade - Skips the probes whose program can't be loaded or attached with the provided privileges, and removes the
+// maps that can't be created along with the perf maps that can't be read
+func (m *Manager) degrade(priv privileges) {
+	mapReasons := make(map[string]string)
+	for name, spec := range m.collectionSpec.Maps {
+		if reason := mapPrivilege(spec.Type, priv); reason != "" {
+			mapReasons[name] = reason
+		}
+	}
+	programReasons := make(map[string]string)
+	for name, spec := range m.collectionSpec.Programs {
+		if reason := programPrivilege(spec.Type, priv); reason != "" {
+			programReasons[name] = reason
+			continue
+		}
+		for _, ins := range spec.Instructions {
+			if reason, ok := mapReasons[ins.Reference()]; ok {
+				programReasons[name] = fmt.Sprintf("map %s: %s", ins.Reference(), reason)
+				break
+			}
+		}
+	}
+
+	// the programs of the skipped probes are removed with the programs of the other skipped probes
+	probePrograms := make(map[string]bool)
+	for _, probe := range m.Probes {
+		probePrograms[probe.EbpfFuncName] = true
+		probe.privilegeErr = nil
+		if reason, ok := programReasons[probe.EbpfFuncName]; ok {
+			probe.privilegeErr = fmt.Errorf("error:%w , %s", ErrMissingPrivileges, reason)
+			m.degradations = append(m.degradations, PrivilegeDegradation{Probe: probe.GetIdentificationPair(), Reason: reason})
+		}
+	}
+	for name := range programReasons {
+		if !probePrograms[name] {
+			delete(m.collectionSpec.Programs, name)
+		}
+	}
+
+	if !priv.perfEvents {
+		for _, perfMap := range m.PerfMaps {
+			if !perfMap.TestMode {
+				mapReasons[perfMap.Name] = "requires CAP_PERFMON or perf_event_paranoid <= 0"
+			}
+		}
+	}
+	names := make([]string, 0, len(mapReasons))
+	for name := range mapReasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if spec := m.collectionSpec.Maps[name]; spec != nil && spec.Type != ebpf.PerfEventArray {
+			delete(m.collectionSpec.Maps, name)
+		}
+		m.degradations = append(m.degradations, PrivilegeDegradation{Map: name, Reason: mapReasons[name]})
+	}
+	keep := func(name string) bool {
+		_, removed := mapReasons[name]
+		return !removed
+	}
+	maps := m.Maps[:0]
+	for _, managerMap := range m.Maps {
+		if keep(managerMap.Name) {
+			maps = append(maps, managerMap)
+		}
+	}
+	m.Maps = maps
+	perfMaps := m.PerfMaps[:0]
+	for _, perfMap := range m.PerfMaps {
+		if keep(perfMap.Name) {
+			perfMaps = append(perfMaps, perfMap)
+		}
+	}
+	m.PerfMaps = perfMaps
+	ringBuffers := m.RingBuffers[:0]
+	for _, ringBuffer := range m.RingBuffers {
+		if keep(ringBuffer.Name) {
+			ringBuffers = append(ringBuffers, ringBuffer)
+		}
+	}
+	m.RingBuffers = ringBuffers
+}
+
+// programPrivilege - Returns the privilege missing to load and attach a program of the provided type, "" if the
+// provided privileges are enough
+func programPrivilege(programType ebpf.ProgramType, priv privileges) string {
+	switch programType {
+	case ebpf.SocketFilter:
+		return ""
+	case ebpf.CGroupSKB:
+		if priv.netAdmin {
+			return ""
+		}
+		return "cgroup_skb programs require CAP_NET_ADMIN"
+	default:
+		return fmt.Sprintf("%s programs require CAP_BPF", programType)
+	}
+}
+
+// mapPrivilege - Returns the privilege missing to create a map of the provided type, "" if the provided privileges are
+// enough
+func mapPrivilege(mapType ebpf.MapType, priv privileges) string {
+	switch mapType {
+	case ebpf.Array, ebpf.PerCPUArray, ebpf.ProgramArray, ebpf.PerfEventArray, ebpf.CGroupArray, ebpf.ArrayOfMaps,
+		ebpf.Hash, ebpf.PerCPUHash, ebpf.HashOfMaps, ebpf.RingBuf, ebpf.CGroupStorage, ebpf.PerCPUCGroupStorage:
+		return ""
+	case ebpf.SockMap, ebpf.SockHash, ebpf.DevMap, ebpf.DevMapHash, ebpf.XSKMap:
+		if priv.netAdmin {
+			return ""
+		}
+		return fmt.Sprintf("%s maps require CAP_NET_ADMIN", mapType)
+	default:
+		return fmt.Sprintf("%s maps require CAP_BPF", mapType)
+	}
+}
+
+// PrivilegeDegradations - Returns the probes and the maps left out at Init because the process lacks the privileges
+// they require, see Options.DegradeUnprivileged
+func (m *Manager) PrivilegeDegradations() []PrivilegeDegradation {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return append([]PrivilegeDegradation{}, m.degradations...)
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+func TestDegradeUnprivileged(t *testing.T) {
+	program := func(programType ebpf.ProgramType, maps ...string) *ebpf.ProgramSpec {
+		var instructions asm.Instructions
+		for _, name := range maps {
+			instructions = append(instructions, asm.LoadMapPtr(asm.R1, 0).WithReference(name))
+		}
+		return &ebpf.ProgramSpec{Type: programType, Instructions: append(instructions, asm.Mov.Imm(asm.R0, 0), asm.Return())}
+	}
+	m := &Manager{
+		collectionSpec: &ebpf.CollectionSpec{
+			Programs: map[string]*ebpf.ProgramSpec{
+				"filter":        program(ebpf.SocketFilter, "counts", "events"),
+				"filter_lru":    program(ebpf.SocketFilter, "lru"),
+				"kprobe_open":   program(ebpf.Kprobe),
+				"tail_call_lru": program(ebpf.SocketFilter, "lru"),
+			},
+			Maps: map[string]*ebpf.MapSpec{
+				"counts": {Type: ebpf.Hash},
+				"lru":    {Type: ebpf.LRUHash},
+				"events": {Type: ebpf.PerfEventArray},
+			},
+		},
+		Probes: []*Probe{
+			{EbpfFuncName: "filter"},
+			{EbpfFuncName: "filter_lru"},
+			{EbpfFuncName: "kprobe_open"},
+		},
+		Maps:     []*Map{{Name: "counts"}, {Name: "lru"}},
+		PerfMaps: []*PerfMap{{Map: Map{Name: "events"}}},
+	}
+	m.degrade(privileges{})
+
+	for _, probe := range m.Probes {
+		err := probe.checkKernelSupport()
+		if probe.EbpfFuncName == "filter" && err != nil {
+			t.Errorf("expected the socket filter to be kept, got %v", err)
+		}
+		if probe.EbpfFuncName != "filter" && !errors.Is(err, ErrMissingPrivileges) {
+			t.Errorf("expected %s to be skipped with ErrMissingPrivileges, got %v", probe.EbpfFuncName, err)
+		}
+	}
+	if _, ok := m.collectionSpec.Programs["tail_call_lru"]; ok {
+		t.Error("expected the program without probe that uses the LRU map to be removed")
+	}
+	if _, ok := m.collectionSpec.Maps["lru"]; ok {
+		t.Error("expected the LRU map to be removed")
+	}
+	if _, ok := m.collectionSpec.Maps["events"]; !ok {
+		t.Error("expected the perf event array to be kept for the socket filter")
+	}
+	if len(m.Maps) != 1 || m.Maps[0].Name != "counts" || len(m.PerfMaps) != 0 {
+		t.Errorf("expected only the counts map to be kept, got %d maps and %d perf maps", len(m.Maps), len(m.PerfMaps))
+	}
+	if len(m.degradations) != 4 {
+		t.Errorf("expected 2 probes and 2 maps to be left out, got %+v", m.degradations)
+	}
+
+	// CAP_NET_ADMIN allows the cgroup_skb programs and the networking maps
+	if reason := programPrivilege(ebpf.CGroupSKB, privileges{netAdmin: true}); reason != "" {
+		t.Errorf("expected cgroup_skb programs to be allowed with CAP_NET_ADMIN, got %s", reason)
+	}
+	if reason := mapPrivilege(ebpf.SockMap, privileges{netAdmin: true}); reason != "" {
+		t.Errorf("expected sockmaps to be allowed with CAP_NET_ADMIN, got %s", reason)
+	}
+}
@@ -0,0 +1,344 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/perf"
+)
+
+// execScanDelay - Delay between the exec of a new process and the scan of its mappings, so that the dynamic loader has
+// time to map its shared libraries
+const execScanDelay = 100 * time.Millisecond
+
+// UprobeProcessAttachment - (uprobes) Result of the attachment of a probe to a binary mapped by a process, see
+// Probe.UprobeAttachAllMatching and Probe.GetProcessAttachments
+type UprobeProcessAttachment struct {
+	// PID - Process that maps the binary
+	PID int
+
+	// BinaryPath - Path of the binary, in the root filesystem of the process
+	BinaryPath string
+
+	// Err - Error returned when the probe was attached to the binary, if any
+	Err error
+}
+
+// matchedBinary - (uprobes) Uprobe attached to a binary matching Probe.UprobeAttachAllMatching. The uprobe is shared
+// by all the processes that map the binary.
+type matchedBinary struct {
+	link link.Link
+	err  error
+}
+
+// processMappings - Returns the paths of the executable files mapped by the provided process, as seen in its root
+// filesystem
+func processMappings(pid int) ([]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address perms offset dev inode path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.Contains(fields[1], "x") || !strings.HasPrefix(fields[5], "/") {
+			continue
+		}
+		path := strings.Join(fields[5:], " ")
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		paths = append(paths, path)
+	}
+	return paths, scanner.Err()
+}
+
+// listProcesses - Returns the PIDs of the running processes
+func listProcesses() ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// attachUprobeMatching - Attaches the uprobe to the binaries matching UprobeAttachAllMatching in the running
+// processes, and watches the new processes if UprobeWatchExec is set
+func (p *Probe) attachUprobeMatching(isRet bool) error {
+	if _, err := filepath.Match(p.UprobeAttachAllMatching, ""); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , invalid pattern %s", err, p.UprobeAttachAllMatching))
+	}
+	p.processLock.Lock()
+	p.matchingIsRet = isRet
+	p.matchedBinaries = make(map[binaryIdentity]*matchedBinary)
+	p.processAttachments = make(map[int][]UprobeProcessAttachment)
+	p.processLock.Unlock()
+
+	pids, err := listProcesses()
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't list the processes", err))
+	}
+	for _, pid := range pids {
+		p.attachProcess(pid)
+	}
+
+	if p.UprobeWatchExec {
+		watcher, err := newExecWatcher(p.handleExec)
+		if err != nil {
+			_ = p.detachUprobeMatching()
+			return err
+		}
+		p.processLock.Lock()
+		p.execWatcher = watcher
+		p.processLock.Unlock()
+		return nil
+	}
+
+	p.processLock.Lock()
+	defer p.processLock.Unlock()
+	var firstErr error
+	for _, binary := range p.matchedBinaries {
+		if binary.err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = binary.err
+		}
+	}
+	if firstErr != nil {
+		_ = p.closeMatchedBinaries()
+		return firstErr
+	}
+	return fmt.Errorf("error:%w , pattern %s", ErrNoMatchingProcess, p.UprobeAttachAllMatching)
+}
+
+// attachProcess - Attaches the uprobe to the binaries of the provided process that match UprobeAttachAllMatching,
+// unless the uprobe is already attached to them
+func (p *Probe) attachProcess(pid int) {
+	paths, err := processMappings(pid)
+	if err != nil {
+		// the process exited
+		return
+	}
+	root := fmt.Sprintf("/proc/%d/root", pid)
+
+	p.processLock.Lock()
+	defer p.processLock.Unlock()
+	if p.matchedBinaries == nil {
+		// the probe was detached
+		return
+	}
+	var attachments []UprobeProcessAttachment
+	for _, path := range paths {
+		if ok, _ := filepath.Match(p.UprobeAttachAllMatching, path); !ok {
+			continue
+		}
+		attachment := UprobeProcessAttachment{PID: pid, BinaryPath: path}
+		binaryPath := rootedPath(root, path)
+		identity, err := statBinary(binaryPath)
+		if err != nil {
+			attachment.Err = err
+			attachments = append(attachments, attachment)
+			continue
+		}
+		binary, ok := p.matchedBinaries[identity]
+		if !ok {
+			// without a PID filter, the uprobe also covers the other processes of the binary
+			binary = &matchedBinary{}
+			binary.link, binary.err = p.openUprobe(binaryPath, root, 0, p.matchingIsRet)
+			p.matchedBinaries[identity] = binary
+		}
+		attachment.Err = binary.err
+		attachments = append(attachments, attachment)
+	}
+	if len(attachments) > 0 {
+		p.processAttachments[pid] = attachments
+	}
+}
+
+// handleExec - Attaches the uprobe to the binaries of a new process, once the process had time to map them
+func (p *Probe) handleExec(pid int) {
+	time.AfterFunc(execScanDelay, func() {
+		p.processLock.Lock()
+		watching := p.execWatcher != nil
+		if watching {
+			// forget the processes that exited
+			for knownPID := range p.processAttachments {
+				if _, err := os.Stat(fmt.Sprintf("/proc/%d", knownPID)); err != nil {
+					delete(p.processAttachments, knownPID)
+				}
+			}
+		}
+		p.processLock.Unlock()
+		if watching {
+			p.attachProcess(pid)
+		}
+	})
+}
+
+// GetProcessAttachments - (uprobes) Returns the result of the attachment of the probe to each process that maps a
+// binary matching UprobeAttachAllMatching, sorted by PID
+func (p *Probe) GetProcessAttachments() []UprobeProcessAttachment {
+	p.processLock.Lock()
+	defer p.processLock.Unlock()
+	var attachments []UprobeProcessAttachment
+	for _, processAttachments := range p.processAttachments {
+		attachments = append(attachments, processAttachments...)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		if attachments[i].PID != attachments[j].PID {
+			return attachments[i].PID < attachments[j].PID
+		}
+		return attachments[i].BinaryPath < attachments[j].BinaryPath
+	})
+	return attachments
+}
+
+// detachUprobeMatching - Stops watching the new processes and detaches the uprobe from all the matching binaries
+func (p *Probe) detachUprobeMatching() error {
+	p.processLock.Lock()
+	watcher := p.execWatcher
+	p.execWatcher = nil
+	p.processLock.Unlock()
+
+	var err error
+	if watcher != nil {
+		err = watcher.Close()
+	}
+	p.processLock.Lock()
+	defer p.processLock.Unlock()
+	return ConcatErrors(err, p.closeMatchedBinaries())
+}
+
+// closeMatchedBinaries - Closes the uprobes of the matching binaries, the process lock must be held
+func (p *Probe) closeMatchedBinaries() error {
+	var err error
+	for _, binary := range p.matchedBinaries {
+		if binary.link != nil {
+			err = ConcatErrors(err, binary.link.Close())
+		}
+	}
+	p.matchedBinaries = nil
+	p.processAttachments = nil
+	return err
+}
+
+// execWatcher - Reports the PIDs of the processes that call exec, with a program attached to the
+// sched/sched_process_exec tracepoint
+type execWatcher struct {
+	events  *ebpf.Map
+	program *ebpf.Program
+	link    link.Link
+	reader  *perf.Reader
+	wg      sync.WaitGroup
+}
+
+// newExecWatcher - Starts watching the exec calls, handler is called with the PID of each new process
+func newExecWatcher(handler func(pid int)) (*execWatcher, error) {
+	w := &execWatcher{}
+	var err error
+	w.events, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name: "exec_events",
+		Type: ebpf.PerfEventArray,
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't create the exec events map", err))
+	}
+	w.program, err = ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:    "exec_watcher",
+		Type:    ebpf.TracePoint,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			// u32 pid = bpf_get_current_pid_tgid() >> 32;
+			asm.Mov.Reg(asm.R6, asm.R1),
+			asm.FnGetCurrentPidTgid.Call(),
+			asm.RSh.Imm(asm.R0, 32),
+			asm.StoreMem(asm.RFP, -8, asm.R0, asm.Word),
+			// bpf_perf_event_output(ctx, &exec_events, BPF_F_CURRENT_CPU, &pid, sizeof(pid));
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.LoadMapPtr(asm.R2, w.events.FD()),
+			asm.LoadImm(asm.R3, 0xffffffff, asm.DWord),
+			asm.Mov.Reg(asm.R4, asm.RFP),
+			asm.Add.Imm(asm.R4, -8),
+			asm.Mov.Imm(asm.R5, 4),
+			asm.FnPerfEventOutput.Call(),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		_ = w.Close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't load the exec watcher", err))
+	}
+	w.link, err = link.Tracepoint("sched", "sched_process_exec", w.program, nil)
+	if err != nil {
+		_ = w.Close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't attach the exec watcher", err))
+	}
+	w.reader, err = perf.NewReader(w.events, os.Getpagesize())
+	if err != nil {
+		_ = w.Close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't read the exec events", err))
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			record, err := w.reader.Read()
+			if err != nil {
+				if errors.Is(err, perf.ErrClosed) {
+					return
+				}
+				continue
+			}
+			if len(record.RawSample) < 4 {
+				continue
+			}
+			handler(int(nativeEndian.Uint32(record.RawSample)))
+		}
+	}()
+	return w, nil
+}
+
+// Close - Stops watching the exec calls
+func (w *execWatcher) Close() error {
+	var err error
+	if w.link != nil {
+		err = ConcatErrors(err, w.link.Close())
+	}
+	if w.reader != nil {
+		err = ConcatErrors(err, w.reader.Close())
+	}
+	w.wg.Wait()
+	if w.program != nil {
+		err = ConcatErrors(err, w.program.Close())
+	}
+	if w.events != nil {
+		err = ConcatErrors(err, w.events.Close())
+	}
+	return err
+}
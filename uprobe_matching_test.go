@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestProcessMappings(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := processMappings(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if path == executable {
+			return
+		}
+	}
+	t.Errorf("%s not found in the mappings %v", executable, paths)
+}
+
+func TestAttachUprobeMatching(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	// the libc mapped by a child process
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("couldn't start sleep: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	time.Sleep(execScanDelay)
+	paths, err := processMappings(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pattern string
+	for _, path := range paths {
+		if filepath.Base(path) == "libc.so.6" {
+			pattern = filepath.Join(filepath.Dir(path), "libc.so*")
+		}
+	}
+	if pattern == "" {
+		t.Skipf("libc not found in the mappings %v", paths)
+	}
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:    ebpf.Kprobe,
+		License: "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	p := &Probe{
+		manager:                 &Manager{},
+		program:                 prog,
+		Section:                 "uprobe/getpid",
+		AttachToFuncName:        "getpid",
+		UprobeAttachAllMatching: pattern,
+	}
+	p.funcName = p.AttachToFuncName
+	if err = p.attachUprobeMatching(false); err != nil {
+		t.Skipf("uprobes not supported: %v", err)
+	}
+	attached := false
+	for _, attachment := range p.GetProcessAttachments() {
+		if attachment.PID == cmd.Process.Pid && attachment.Err == nil {
+			attached = true
+		}
+	}
+	if !attached {
+		t.Errorf("sleep not attached: %+v", p.GetProcessAttachments())
+	}
+	if err = p.detachUprobeMatching(); err != nil {
+		t.Error(err)
+	}
+	if len(p.GetProcessAttachments()) != 0 {
+		t.Error("expected no attachment once detached")
+	}
+
+	p.UprobeAttachAllMatching = "/nonexistent/*"
+	if err = p.attachUprobeMatching(false); !errors.Is(err, ErrNoMatchingProcess) {
+		t.Errorf("expected ErrNoMatchingProcess, got %v", err)
+	}
+}
+
+func TestExecWatcher(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	pids := make(chan int, 16)
+	watcher, err := newExecWatcher(func(pid int) {
+		pids <- pid
+	})
+	if err != nil {
+		t.Skipf("exec tracepoint not available: %v", err)
+	}
+	defer watcher.Close()
+
+	cmd := exec.Command("/bin/true")
+	if err = cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case pid := <-pids:
+			if pid == cmd.Process.Pid {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("exec of %d not reported", cmd.Process.Pid)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// attachTraceUprobeMulti - BPF_TRACE_UPROBE_MULTI, the expected attach type of the uprobe_multi programs, which isn't
+// defined by cilium/ebpf v0.10
+const attachTraceUprobeMulti = ebpf.AttachType(48)
+
+// uprobeMultiAttr - union bpf_attr, link_create variant with the uprobe_multi options
+type uprobeMultiAttr struct {
+	progFD        uint32
+	targetFD      uint32
+	attachType    uint32
+	flags         uint32
+	path          uint64
+	offsets       uint64
+	refCtrOffsets uint64
+	cookies       uint64
+	count         uint32
+	multiFlags    uint32
+	pid           uint32
+	_             uint32
+}
+
+// createUprobeMultiLink - Creates a uprobe_multi link attaching the provided program at the provided file offsets of
+// the binary at the provided path, for the provided process only if pid isn't 0. cookies is either empty or has one
+// cookie per offset.
+func createUprobeMultiLink(prog *ebpf.Program, path string, offsets []uint64, cookies []uint64, pid int) (int, error) {
+	if len(offsets) == 0 {
+		return -1, errors.New("no offset to attach to")
+	}
+	pathPtr, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	attr := uprobeMultiAttr{
+		progFD:     uint32(prog.FD()),
+		attachType: uint32(attachTraceUprobeMulti),
+		path:       uint64(uintptr(unsafe.Pointer(pathPtr))),
+		offsets:    uint64(uintptr(unsafe.Pointer(&offsets[0]))),
+		count:      uint32(len(offsets)),
+		pid:        uint32(pid),
+	}
+	if len(cookies) > 0 {
+		attr.cookies = uint64(uintptr(unsafe.Pointer(&cookies[0])))
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(pathPtr)
+	runtime.KeepAlive(offsets)
+	runtime.KeepAlive(cookies)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// uprobeMultiLink - uprobe_multi link attaching the program of a probe at several offsets of a binary
+type uprobeMultiLink struct {
+	fd int
+}
+
+// Close - Detaches the program by closing the link
+func (l *uprobeMultiLink) Close() error {
+	return unix.Close(l.fd)
+}
+
+// openUprobeMulti - Attaches the program of the probe at the provided file offsets of the binary at the provided path
+// with a single uprobe_multi link, for the provided process only if pid isn't 0
+func (p *Probe) openUprobeMulti(binaryPath string, offsets []uint64, pid int) (*uprobeMultiLink, error) {
+	if err := p.checkCookie(); err != nil {
+		return nil, err
+	}
+	path := binaryPath
+	if p.RealFilePath != "" {
+		path = p.RealFilePath
+	}
+	fileOffsets := make([]uint64, len(offsets))
+	for i, offset := range offsets {
+		fileOffsets[i] = offset + p.NonElfOffset
+	}
+	var cookies []uint64
+	if p.Cookie != 0 {
+		cookies = make([]uint64, len(offsets))
+		for i := range cookies {
+			cookies[i] = p.Cookie
+		}
+	}
+	fd, err := createUprobeMultiLink(p.program, path, fileOffsets, cookies, pid)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't attach the uprobe_multi link of %s to %d offsets of %s", err, p.GetIdentificationPair(), len(offsets), path))
+	}
+	return &uprobeMultiLink{fd: fd}, nil
+}
+
+// prepareUprobeMulti - Loads the programs of the uprobes attached at the return instructions of a Go function (see
+// Probe.UprobeGoReturns) as uprobe_multi programs when the kernel supports uprobe_multi links. A uprobe_multi program
+// can't be attached with a regular uprobe.
+func (m *Manager) prepareUprobeMulti() {
+	if m.options.DisableUprobeMulti {
+		return
+	}
+	for _, probe := range m.Probes {
+		if !probe.UprobeGoReturns || probe.programSpec == nil || probe.programSpec.Type != ebpf.Kprobe {
+			continue
+		}
+		if HaveUprobeMulti() != nil {
+			return
+		}
+		probe.programSpec.AttachType = attachTraceUprobeMulti
+	}
+}
+
+// usesUprobeMulti - Returns true if the program of the probe was loaded as a uprobe_multi program
+func (p *Probe) usesUprobeMulti() bool {
+	return p.programSpec != nil && p.programSpec.AttachType == attachTraceUprobeMulti
+}
@@ -0,0 +1,127 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf/link"
+)
+
+// targetPIDs - (uprobes) Returns the processes the uprobe is scoped to, AttachPID included, sorted and without
+// duplicates
+func (p *Probe) targetPIDs() []int {
+	seen := make(map[int]struct{})
+	var pids []int
+	for _, pid := range append([]int{p.AttachPID}, p.AttachPIDs...) {
+		if _, ok := seen[pid]; ok || pid <= 0 {
+			continue
+		}
+		seen[pid] = struct{}{}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// attachUprobePIDs - Opens the uprobe for each process of AttachPIDs and for AttachPID, all or none of them
+func (p *Probe) attachUprobePIDs(binaryPath string) error {
+	p.pidLinks = make(map[int]link.Link)
+	for _, pid := range p.targetPIDs() {
+		if err := p.attachUprobePID(binaryPath, pid); err != nil {
+			_ = p.detachUprobePIDs()
+			return err
+		}
+	}
+	return nil
+}
+
+// attachUprobePID - Opens the uprobe for the provided process
+func (p *Probe) attachUprobePID(binaryPath string, pid int) error {
+	kp, err := p.openUprobe(binaryPath, p.binaryRoot(), pid, strings.HasPrefix(p.Section, "uretprobe/"))
+	if err != nil {
+		return fmt.Errorf("%w , pid %d", err, pid)
+	}
+	p.pidLinks[pid] = kp
+	return nil
+}
+
+// detachUprobePIDs - Closes the uprobes opened for the processes of AttachPIDs
+func (p *Probe) detachUprobePIDs() error {
+	var err error
+	for _, kp := range p.pidLinks {
+		err = ConcatErrors(err, kp.Close())
+	}
+	p.pidLinks = nil
+	return err
+}
+
+// AddAttachPID - (uprobes) Adds a process to the processes the uprobe is scoped to. If the probe is running, the
+// uprobe is opened for the new process right away, which requires the probe to have been attached with at least one
+// PID (see AttachPIDs and AttachPID): a system wide uprobe can't be narrowed down at runtime.
+func (p *Probe) AddAttachPID(pid int) error {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	for _, target := range p.targetPIDs() {
+		if target == pid {
+			return nil
+		}
+	}
+	if p.state >= running && p.Enabled {
+		if p.pidLinks == nil && p.AttachPID > 0 && p.link != nil {
+			// the uprobe was opened for AttachPID only
+			p.pidLinks = map[int]link.Link{p.AttachPID: p.link}
+			p.link = nil
+		}
+		if p.pidLinks == nil {
+			return fmt.Errorf("error:%w , probe %s", ErrUprobeNotPIDScoped, p.GetIdentificationPair())
+		}
+		if err := p.attachUprobePID(p.binaryPath(), pid); err != nil {
+			return err
+		}
+	}
+	p.AttachPIDs = append(p.AttachPIDs, pid)
+	return nil
+}
+
+// RemoveAttachPID - (uprobes) Removes a process from the processes the uprobe is scoped to, and closes its uprobe if
+// the probe is running. A running probe whose last process was removed stays attached to no process until a new one
+// is added, but it is attached system wide when it is started again without any PID.
+func (p *Probe) RemoveAttachPID(pid int) error {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	var err error
+	if p.AttachPID == pid {
+		p.AttachPID = 0
+		if p.pidLinks == nil && p.link != nil && p.state >= running {
+			// the uprobe was opened for AttachPID only
+			err = p.link.Close()
+			p.pidLinks = make(map[int]link.Link)
+			p.link = nil
+		}
+	}
+	pids := p.AttachPIDs[:0]
+	for _, target := range p.AttachPIDs {
+		if target != pid {
+			pids = append(pids, target)
+		}
+	}
+	p.AttachPIDs = pids
+	if kp, ok := p.pidLinks[pid]; ok {
+		delete(p.pidLinks, pid)
+		err = kp.Close()
+	}
+	return err
+}
+
+// GetAttachedPIDs - (uprobes) Returns the processes for which the uprobe is currently opened, sorted by PID
+func (p *Probe) GetAttachedPIDs() []int {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+	pids := make([]int, 0, len(p.pidLinks))
+	for pid := range p.pidLinks {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+//go:noinline
+func uprobePIDTarget() int {
+	return os.Getpid()
+}
+
+// uprobePIDTargetOffset - Returns the offset of uprobePIDTarget in the test binary, whose symbols might be stripped
+func uprobePIDTargetOffset(executable string) (uint64, error) {
+	pc := uint64(reflect.ValueOf(uprobePIDTarget).Pointer())
+	f, err := os.Open("/proc/self/maps")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[5] != executable {
+			continue
+		}
+		var start, end, offset uint64
+		if _, err = fmt.Sscanf(fields[0]+" "+fields[2], "%x-%x %x", &start, &end, &offset); err != nil {
+			return 0, err
+		}
+		if start <= pc && pc < end {
+			return pc - start + offset, nil
+		}
+	}
+	return 0, fmt.Errorf("uprobePIDTarget not found in the mappings of %s", executable)
+}
+
+// newCallCounter - Returns a uprobe program counting its calls in the first entry of the returned array
+func newCallCounter(t *testing.T) (*ebpf.Map, *ebpf.Program) {
+	t.Helper()
+	return newCallCounterWithAttachType(t, ebpf.AttachNone)
+}
+
+// newCallCounterWithAttachType - Like newCallCounter, for a program loaded with the provided expected attach type
+func newCallCounterWithAttachType(t *testing.T, attachType ebpf.AttachType) (*ebpf.Map, *ebpf.Program) {
+	t.Helper()
+	calls, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.Kprobe,
+		AttachType: attachType,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.StoreImm(asm.RFP, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, calls.FD()),
+			asm.Mov.Reg(asm.R2, asm.RFP),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, "exit"),
+			asm.Mov.Imm(asm.R1, 1),
+			asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+			asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+			asm.Return(),
+		},
+	})
+	if err != nil {
+		_ = calls.Close()
+		t.Fatal(err)
+	}
+	return calls, prog
+}
+
+func TestUprobeAttachPIDs(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := uprobePIDTargetOffset(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls, prog := newCallCounter(t)
+	defer calls.Close()
+	defer prog.Close()
+	count := func() uint64 {
+		t.Helper()
+		var value uint64
+		if err := calls.Lookup(uint32(0), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	// another process
+	cmd := exec.Command("sleep", "10")
+	if err = cmd.Start(); err != nil {
+		t.Skipf("couldn't start sleep: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	p := &Probe{
+		manager:          &Manager{},
+		program:          prog,
+		Section:          "uprobe/uprobePIDTarget",
+		AttachToFuncName: "uprobePIDTarget",
+		UAddress:         offset,
+		BinaryPath:       executable,
+		AttachPIDs:       []int{cmd.Process.Pid},
+		Enabled:          true,
+	}
+	if err = p.attachUprobe(); err != nil {
+		t.Skipf("uprobes not supported: %v", err)
+	}
+	p.state = running
+	defer p.detachUprobePIDs()
+
+	uprobePIDTarget()
+	if count() != 0 {
+		t.Fatal("expected the uprobe to ignore the processes that aren't targeted")
+	}
+	if err = p.AddAttachPID(os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	uprobePIDTarget()
+	if count() != 1 {
+		t.Fatalf("expected 1 call once the process was added, got %d", count())
+	}
+	if pids := p.GetAttachedPIDs(); len(pids) != 2 {
+		t.Errorf("unexpected attached processes %v", pids)
+	}
+	if err = p.RemoveAttachPID(os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	uprobePIDTarget()
+	if count() != 1 || len(p.AttachPIDs) != 1 {
+		t.Errorf("expected the process to be removed, got %d calls and %v", count(), p.AttachPIDs)
+	}
+
+	// a system wide uprobe can't be narrowed down
+	if err = p.detachUprobePIDs(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.AddAttachPID(os.Getpid()); !errors.Is(err, ErrUprobeNotPIDScoped) {
+		t.Errorf("expected ErrUprobeNotPIDScoped, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package manager
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultDebugFileDirectory - Directory in which the separate debug files of the binaries are looked up by
+	// build-id, see Options.DebugFileDirectories
+	DefaultDebugFileDirectory = "/usr/lib/debug"
+
+	// gnuBuildIDNoteType - Type of the build-id note in the .note.gnu.build-id section
+	gnuBuildIDNoteType = 3
+)
+
+// binaryPath - (uprobes) Returns the path of the binary of the probe, in the root filesystem of BinaryRootPID if set
+func (p *Probe) binaryPath() string {
+	return rootedPath(p.binaryRoot(), p.BinaryPath)
+}
+
+// binaryRoot - (uprobes) Returns the root filesystem in which the binary of the probe lives, "" for the root of the
+// manager
+func (p *Probe) binaryRoot() string {
+	if p.BinaryRootPID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/proc/%d/root", p.BinaryRootPID)
+}
+
+// rootedPath - Returns the provided path, relative to root if it isn't empty
+func rootedPath(root, path string) string {
+	if root == "" {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// ReadBuildID - Returns the GNU build-id of the provided binary, hex encoded, as defined in its .note.gnu.build-id
+// section
+func ReadBuildID(binaryPath string) (string, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readBuildID(f)
+}
+
+// readBuildID - Returns the hex encoded GNU build-id of the provided ELF file
+func readBuildID(f *elf.File) (string, error) {
+	section := f.Section(".note.gnu.build-id")
+	if section == nil {
+		return "", ErrNoBuildID
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", err
+	}
+	// Elf_Nhdr: namesz, descsz, type, then the padded name and descriptor
+	for len(data) >= 12 {
+		nameSize := f.ByteOrder.Uint32(data[0:4])
+		descSize := f.ByteOrder.Uint32(data[4:8])
+		noteType := f.ByteOrder.Uint32(data[8:12])
+		nameEnd := 12 + (uint64(nameSize)+3)&^3
+		descEnd := nameEnd + (uint64(descSize)+3)&^3
+		if descEnd > uint64(len(data)) {
+			break
+		}
+		name := string(bytes.TrimRight(data[12:12+nameSize], "\x00"))
+		if noteType == gnuBuildIDNoteType && name == "GNU" && descSize > 0 {
+			return hex.EncodeToString(data[nameEnd : nameEnd+uint64(descSize)]), nil
+		}
+		data = data[descEnd:]
+	}
+	return "", ErrNoBuildID
+}
+
+// debugFileCandidates - Returns the paths at which the debug file of the provided build-id is looked up: in the
+// provided root filesystem first, and then in the root filesystem of the manager
+func debugFileCandidates(buildID string, root string, directories []string) []string {
+	if len(directories) == 0 {
+		directories = []string{DefaultDebugFileDirectory}
+	}
+	name := filepath.Join(".build-id", buildID[:2], buildID[2:]+".debug")
+	var candidates []string
+	roots := []string{root}
+	if root != "" {
+		roots = append(roots, "")
+	}
+	for _, r := range roots {
+		for _, directory := range directories {
+			candidates = append(candidates, rootedPath(r, filepath.Join(directory, name)))
+		}
+	}
+	return candidates
+}
+
+// lookupFunctionSymbol - Returns the virtual address of the provided function in the symbol table or in the dynamic
+// symbol table of the provided ELF file. Undefined symbols, like the functions imported from shared libraries, are
+// ignored.
+func lookupFunctionSymbol(f *elf.File, symbol string) (uint64, bool) {
+	for _, lookup := range []func() ([]elf.Symbol, error){f.Symbols, f.DynamicSymbols} {
+		syms, err := lookup()
+		if err != nil {
+			continue
+		}
+		for _, sym := range syms {
+			if sym.Name != symbol || sym.Section == elf.SHN_UNDEF || sym.Value == 0 {
+				continue
+			}
+			if typ := elf.ST_TYPE(sym.Info); typ == elf.STT_FUNC || typ == elf.STT_GNU_IFUNC {
+				return sym.Value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// lookupPLTStub - Returns the virtual address of the PLT stub through which the provided ELF file calls the provided
+// function of a shared library. Only x86_64 and arm64 binaries are supported.
+func lookupPLTStub(f *elf.File, symbol string) (uint64, error) {
+	if f.Class != elf.ELFCLASS64 {
+		return 0, errors.New("only 64 bits binaries are supported")
+	}
+	rela := f.Section(".rela.plt")
+	if rela == nil {
+		return 0, errors.New("no .rela.plt section")
+	}
+	data, err := rela.Data()
+	if err != nil {
+		return 0, err
+	}
+	dynsyms, err := f.DynamicSymbols()
+	if err != nil {
+		return 0, err
+	}
+
+	// find the index of the PLT relocation of the symbol, the stubs are in the same order as the relocations
+	index := -1
+	for i := 0; (i+1)*24 <= len(data); i++ {
+		var entry elf.Rela64
+		if err = binary.Read(bytes.NewReader(data[i*24:(i+1)*24]), f.ByteOrder, &entry); err != nil {
+			return 0, err
+		}
+		// DynamicSymbols skips the null symbol at index 0
+		symIndex := int(elf.R_SYM64(entry.Info))
+		if symIndex > 0 && symIndex <= len(dynsyms) && dynsyms[symIndex-1].Name == symbol {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, errors.New("no PLT relocation")
+	}
+
+	// with indirect branch tracking, the stubs are in .plt.sec and there is no header
+	if pltSec := f.Section(".plt.sec"); pltSec != nil {
+		return pltSec.Addr + uint64(index)*16, nil
+	}
+	plt := f.Section(".plt")
+	if plt == nil {
+		return 0, errors.New("no .plt section")
+	}
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return plt.Addr + uint64(index+1)*16, nil
+	case elf.EM_AARCH64:
+		return plt.Addr + 32 + uint64(index)*16, nil
+	default:
+		return 0, fmt.Errorf("unsupported machine %s", f.Machine)
+	}
+}
+
+// resolveUprobeSymbol - Returns the file offset of the provided function in the binary at the provided path, when it
+// can't be found in its symbol tables: the symbol is looked up in the separate debug file of the binary, found by
+// build-id in the provided directories of the root filesystem of the binary or of the manager, and then the PLT stub
+// of the function is used if the binary imports it from a shared library. The returned error lists the lookups that
+// were tried.
+func resolveUprobeSymbol(binaryPath string, symbol string, root string, debugDirectories []string) (uint64, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("error:%v , couldn't open %s", err, binaryPath))
+	}
+	defer f.Close()
+
+	var tried []string
+	if addr, ok := lookupFunctionSymbol(f, symbol); ok {
+		return virtualAddressToOffset(f, addr)
+	}
+	tried = append(tried, fmt.Sprintf("symbol tables of %s: not found", binaryPath))
+
+	buildID, err := readBuildID(f)
+	if err != nil {
+		tried = append(tried, fmt.Sprintf("debug file: %v", err))
+	} else {
+		for _, candidate := range debugFileCandidates(buildID, root, debugDirectories) {
+			addr, err := lookupDebugFileSymbol(candidate, symbol)
+			if err != nil {
+				tried = append(tried, fmt.Sprintf("debug file %s: %v", candidate, err))
+				continue
+			}
+			// the debug file has the same layout as the binary
+			return virtualAddressToOffset(f, addr)
+		}
+	}
+
+	addr, err := lookupPLTStub(f, symbol)
+	if err == nil {
+		return virtualAddressToOffset(f, addr)
+	}
+	tried = append(tried, fmt.Sprintf("PLT of %s: %v", binaryPath, err))
+
+	return 0, fmt.Errorf("error:%w , symbol %s, tried %s", ErrUprobeSymbolNotFound, symbol, strings.Join(tried, "; "))
+}
+
+// lookupDebugFileSymbol - Returns the virtual address of the provided function in the debug file at the provided path
+func lookupDebugFileSymbol(path string, symbol string) (uint64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, errors.New("not found")
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	addr, ok := lookupFunctionSymbol(f, symbol)
+	if !ok {
+		return 0, errors.New("symbol not found")
+	}
+	return addr, nil
+}
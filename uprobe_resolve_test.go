@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadBuildID(t *testing.T) {
+	buildID, err := ReadBuildID("testdata/resolve.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buildID != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("unexpected build-id %s", buildID)
+	}
+	if _, err = ReadBuildID("testdata/usdt.elf"); !errors.Is(err, ErrNoBuildID) {
+		t.Errorf("expected ErrNoBuildID, got %v", err)
+	}
+}
+
+func TestResolveUprobeSymbol(t *testing.T) {
+	for _, test := range []struct {
+		symbol string
+		offset uint64
+	}{
+		// stripped local function, found in the debug file
+		{symbol: "resolve_target", offset: 0x1139},
+		// function of the libc, found in the PLT
+		{symbol: "puts", offset: 0x1030},
+	} {
+		offset, err := resolveUprobeSymbol("testdata/resolve.elf", test.symbol, "", []string{"testdata/debug"})
+		if err != nil {
+			t.Errorf("%s: %v", test.symbol, err)
+			continue
+		}
+		if offset != test.offset {
+			t.Errorf("%s: expected offset 0x%x, got 0x%x", test.symbol, test.offset, offset)
+		}
+	}
+
+	_, err := resolveUprobeSymbol("testdata/resolve.elf", "missing", "", []string{"testdata/debug"})
+	if !errors.Is(err, ErrUprobeSymbolNotFound) {
+		t.Fatalf("expected ErrUprobeSymbolNotFound, got %v", err)
+	}
+	for _, attempt := range []string{"symbol tables", "debug file testdata/debug/.build-id/01/", "PLT"} {
+		if !strings.Contains(err.Error(), attempt) {
+			t.Errorf("expected the error to list %q, got %v", attempt, err)
+		}
+	}
+}
+
+func TestBinaryRootPID(t *testing.T) {
+	p := &Probe{BinaryPath: "/usr/bin/bash", BinaryRootPID: 42}
+	if path := p.binaryPath(); path != "/proc/42/root/usr/bin/bash" {
+		t.Errorf("unexpected path %s", path)
+	}
+	candidates := debugFileCandidates("0123", p.binaryRoot(), nil)
+	if len(candidates) != 2 || candidates[0] != "/proc/42/root/usr/lib/debug/.build-id/01/23.debug" || candidates[1] != "/usr/lib/debug/.build-id/01/23.debug" {
+		t.Errorf("unexpected debug file candidates %v", candidates)
+	}
+}
@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// stapsdtNoteType - Type of the USDT notes in the .note.stapsdt section
+	stapsdtNoteType = 3
+	// stapsdtNoteName - Owner of the USDT notes in the .note.stapsdt section
+	stapsdtNoteName = "stapsdt"
+)
+
+// USDTNote - USDT (user statically defined tracepoint) marker, as defined in the .note.stapsdt section of a binary
+type USDTNote struct {
+	// Provider - Provider of the USDT marker
+	Provider string
+
+	// Name - Name of the USDT marker
+	Name string
+
+	// Args - Description of the arguments of the USDT marker, for example "-4@%edi 8@%rsi"
+	Args string
+
+	// Location - File offset of the USDT marker, this is where the uprobe is attached
+	Location uint64
+
+	// SemaphoreOffset - File offset of the semaphore of the USDT marker, 0 if the marker doesn't have one. The
+	// semaphore is incremented by the kernel while a probe is attached (see UprobeOptions.RefCtrOffset) so that the
+	// traced binary can skip expensive argument computations when nobody listens.
+	SemaphoreOffset uint64
+}
+
+// ReadUSDTNotes - Lists the USDT markers defined in the .note.stapsdt section of the provided binary
+func ReadUSDTNotes(binaryPath string) ([]USDTNote, error) {
+	f, err := elf.Open(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := f.Section(".note.stapsdt")
+	if section == nil {
+		return nil, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("error:%v , couldn't read the USDT notes of %s", err, binaryPath)
+	}
+
+	// the addresses of the notes are relative to .stapsdt.base at link time, they need to be adjusted if the binary
+	// was prelinked
+	var baseAddr uint64
+	if base := f.Section(".stapsdt.base"); base != nil {
+		baseAddr = base.Addr
+	}
+
+	notes, err := parseUSDTNotes(data, f.ByteOrder, f.Class == elf.ELFCLASS64)
+	if err != nil {
+		return nil, fmt.Errorf("error:%v , couldn't parse the USDT notes of %s", err, binaryPath)
+	}
+	for i := range notes {
+		// at this point Location and SemaphoreOffset hold virtual addresses, base holds the link time .stapsdt.base
+		if baseAddr != 0 && notes[i].base != 0 {
+			notes[i].Location += baseAddr - notes[i].base
+			if notes[i].SemaphoreOffset != 0 {
+				notes[i].SemaphoreOffset += baseAddr - notes[i].base
+			}
+		}
+		if notes[i].Location, err = virtualAddressToOffset(f, notes[i].Location); err != nil {
+			return nil, fmt.Errorf("error:%v , USDT marker %s:%s", err, notes[i].Provider, notes[i].Name)
+		}
+		if notes[i].SemaphoreOffset != 0 {
+			if notes[i].SemaphoreOffset, err = virtualAddressToOffset(f, notes[i].SemaphoreOffset); err != nil {
+				return nil, fmt.Errorf("error:%v , semaphore of USDT marker %s:%s", err, notes[i].Provider, notes[i].Name)
+			}
+		}
+	}
+
+	output := make([]USDTNote, 0, len(notes))
+	for _, note := range notes {
+		output = append(output, note.USDTNote)
+	}
+	return output, nil
+}
+
+// FindUSDTNote - Looks for the USDT marker identified by the provided provider and name in the provided binary
+func FindUSDTNote(binaryPath, provider, name string) (USDTNote, error) {
+	notes, err := ReadUSDTNotes(binaryPath)
+	if err != nil {
+		return USDTNote{}, err
+	}
+	for _, note := range notes {
+		if note.Provider == provider && note.Name == name {
+			return note, nil
+		}
+	}
+	return USDTNote{}, fmt.Errorf("error:%w , couldn't find USDT marker %s:%s in %s", ErrUnknownUSDT, provider, name, binaryPath)
+}
+
+// rawUSDTNote - USDT note with the link time address of .stapsdt.base
+type rawUSDTNote struct {
+	USDTNote
+	base uint64
+}
+
+// parseUSDTNotes - Parses the content of a .note.stapsdt section
+func parseUSDTNotes(data []byte, order binary.ByteOrder, is64 bool) ([]rawUSDTNote, error) {
+	addrSize := 4
+	if is64 {
+		addrSize = 8
+	}
+	readAddr := func(b []byte) uint64 {
+		if is64 {
+			return order.Uint64(b)
+		}
+		return uint64(order.Uint32(b))
+	}
+	align4 := func(n int) int {
+		return (n + 3) &^ 3
+	}
+
+	var notes []rawUSDTNote
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("truncated note header")
+		}
+		nameSize := int(order.Uint32(data[0:4]))
+		descSize := int(order.Uint32(data[4:8]))
+		noteType := order.Uint32(data[8:12])
+		data = data[12:]
+		if len(data) < align4(nameSize)+descSize {
+			return nil, errors.New("truncated note")
+		}
+		name := string(bytes.TrimRight(data[:nameSize], "\x00"))
+		desc := data[align4(nameSize) : align4(nameSize)+descSize]
+		if next := align4(nameSize) + align4(descSize); next < len(data) {
+			data = data[next:]
+		} else {
+			data = nil
+		}
+		if noteType != stapsdtNoteType || name != stapsdtNoteName {
+			continue
+		}
+
+		if len(desc) < 3*addrSize {
+			return nil, errors.New("truncated USDT note")
+		}
+		note := rawUSDTNote{
+			USDTNote: USDTNote{
+				Location:        readAddr(desc[0:]),
+				SemaphoreOffset: readAddr(desc[2*addrSize:]),
+			},
+			base: readAddr(desc[addrSize:]),
+		}
+		strs := bytes.SplitN(desc[3*addrSize:], []byte{0}, 4)
+		if len(strs) < 3 {
+			return nil, errors.New("truncated USDT note strings")
+		}
+		note.Provider, note.Name, note.Args = string(strs[0]), string(strs[1]), string(strs[2])
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// virtualAddressToOffset - Converts a virtual address to a file offset, using the loadable segments of the binary
+func virtualAddressToOffset(f *elf.File, addr uint64) (uint64, error) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if addr >= prog.Vaddr && addr < prog.Vaddr+prog.Memsz {
+			return addr - prog.Vaddr + prog.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("address 0x%x isn't in a loadable segment", addr)
+}
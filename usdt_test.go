@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestReadUSDTNotes(t *testing.T) {
+	// see testdata/usdt.c
+	notes, err := ReadUSDTNotes("testdata/usdt.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 {
+		t.Fatalf("expected 1 USDT marker, got %d", len(notes))
+	}
+	expected := USDTNote{
+		Provider:        "manager",
+		Name:            "test_probe",
+		Args:            "-4@%edi",
+		Location:        0x112d,
+		SemaphoreOffset: 0x3010,
+	}
+	if notes[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, notes[0])
+	}
+
+	if _, err = FindUSDTNote("testdata/usdt.elf", "manager", "unknown"); !errors.Is(err, ErrUnknownUSDT) {
+		t.Errorf("expected ErrUnknownUSDT, got %v", err)
+	}
+}
+
+func TestParseUSDTNotesTruncated(t *testing.T) {
+	note := make([]byte, 12)
+	binary.LittleEndian.PutUint32(note[0:], 8)
+	binary.LittleEndian.PutUint32(note[4:], 64)
+	binary.LittleEndian.PutUint32(note[8:], stapsdtNoteType)
+	note = append(note, []byte("stapsdt\x00")...)
+	if _, err := parseUSDTNotes(note, binary.LittleEndian, true); err == nil {
+		t.Error("expected an error for a truncated note")
+	}
+}
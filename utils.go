@@ -3,6 +3,7 @@ package manager
 import (
 	"bufio"
 	"debug/elf"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -12,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unsafe"
 )
 
 type state uint
@@ -32,6 +35,15 @@ const (
 	maxBPFClassifierNameLen = 256
 )
 
+// nativeEndian - Byte order of the host, in which the eBPF programs write their samples
+var nativeEndian = func() binary.ByteOrder {
+	value := uint16(1)
+	if *(*byte)(unsafe.Pointer(&value)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
 // ConcatErrors - Concatenate 2 errors into one error.
 func ConcatErrors(err1, err2 error) error {
 	if err1 == nil {
@@ -43,6 +55,24 @@ func ConcatErrors(err1, err2 error) error {
 	return err1
 }
 
+// runWithTimeout - Runs fn in a dedicated goroutine and waits at most timeout for it to return. A timeout of 0 waits
+// forever. ErrTimeout is returned if fn didn't return in time, the goroutine is then left behind.
+func runWithTimeout(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
 // availableFilterFunctions - cache of the list of available kernel functions.
 var availableFilterFunctions []string
 
@@ -212,6 +242,41 @@ func getSyscallFnNameWithKallsyms(name string, kallsymsContent string) (string,
 	return "", errors.New("could not find a valid syscall name")
 }
 
+// syscallArchPrefixes - Prefix of the syscall functions of the kernels built with syscall wrappers (4.17+ on x86_64,
+// 5.0+ on arm64...), per GOARCH
+var syscallArchPrefixes = map[string]string{
+	"amd64":   "__x64_",
+	"386":     "__ia32_",
+	"arm64":   "__arm64_",
+	"s390x":   "__s390x_",
+	"riscv64": "__riscv_",
+}
+
+// SyscallSymbols - Returns the kernel functions that may implement the provided syscall on the current architecture,
+// most recent naming scheme first: __x64_sys_openat, sys_openat, SyS_openat for openat on x86_64. When compat is set,
+// the functions called by the 32-bit processes on 64-bit kernels are returned instead: __ia32_compat_sys_openat,
+// __ia32_sys_openat, compat_sys_openat. See Probe.SyscallName.
+func SyscallSymbols(name string, compat bool) []string {
+	prefix := syscallArchPrefixes[runtime.GOARCH]
+	if !compat {
+		var symbols []string
+		if prefix != "" {
+			symbols = append(symbols, prefix+"sys_"+name)
+		}
+		return append(symbols, "sys_"+name, "SyS_"+name)
+	}
+	// the compat syscalls of x86_64 are the ones of i386
+	if runtime.GOARCH == "amd64" {
+		prefix = syscallArchPrefixes["386"]
+	}
+	var symbols []string
+	if prefix != "" {
+		// the compat syscalls without a compat version share the native implementation
+		symbols = append(symbols, prefix+"compat_sys_"+name, prefix+"sys_"+name)
+	}
+	return append(symbols, "compat_sys_"+name)
+}
+
 var safeEventRegexp = regexp.MustCompile("[^a-zA-Z0-9]")
 
 func GenerateEventName(probeType, funcName, UID string, attachPID int) (string, error) {
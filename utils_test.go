@@ -1,6 +1,9 @@
 package manager
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -45,3 +48,130 @@ func TestGetSyscallFnNameWithSymFile(t *testing.T) {
 	}
 	t.Logf("Expected function name %s, got %s", expectedFnName, fnName)
 }
+
+func TestSyscallSymbols(t *testing.T) {
+	native, compat := SyscallSymbols("openat", false), SyscallSymbols("openat", true)
+	if native[len(native)-2] != "sys_openat" || compat[len(compat)-1] != "compat_sys_openat" {
+		t.Errorf("unexpected syscall functions %v %v", native, compat)
+	}
+
+	symFile := filepath.Join(t.TempDir(), "kallsyms")
+	content := "ffffffff81000100 T " + native[0] + "\n" +
+		"ffffffff81000200 T " + compat[0] + "\n" +
+		"ffffffff81000300 T sys_openat\n"
+	if err := os.WriteFile(symFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &Probe{
+		Section:     "kprobe/openat",
+		SyscallName: "openat",
+		manager:     &Manager{options: Options{SymFile: symFile}},
+	}
+	if err := p.resolveKprobeTarget(); err != nil || p.funcName != native[0] {
+		t.Errorf("expected %s, got %s (%v)", native[0], p.funcName, err)
+	}
+	p.SyscallCompat = true
+	if err := p.resolveKprobeTarget(); err != nil || p.funcName != compat[0] {
+		t.Errorf("expected %s, got %s (%v)", compat[0], p.funcName, err)
+	}
+	p.SyscallName = "missing"
+	if err := p.resolveKprobeTarget(); !errors.Is(err, ErrNoKprobeCandidate) {
+		t.Errorf("expected ErrNoKprobeCandidate, got %v", err)
+	}
+}
+
+func TestKernelSymbols(t *testing.T) {
+	symFile := filepath.Join(t.TempDir(), "kallsyms")
+	content := "ffffffff81000000 T _stext\n" +
+		"ffffffff81a00100 t tcp_sendmsg_locked\n" +
+		"ffffffff81a00400 T tcp_sendmsg\n" +
+		"ffffffff81a00480 d tcp_data\n" +
+		"ffffffffc0000000 t mod_func\t[module]\n"
+	if err := os.WriteFile(symFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	symbol, offset, err := resolveKernelAddress(0xffffffff81a00410, symFile)
+	if err != nil || symbol != "tcp_sendmsg" || offset != 0x10 {
+		t.Errorf("expected tcp_sendmsg+0x10, got %s+0x%x (%v)", symbol, offset, err)
+	}
+	if _, _, err = resolveKernelAddress(0x1000, symFile); !errors.Is(err, ErrKprobeAddress) {
+		t.Errorf("expected ErrKprobeAddress, got %v", err)
+	}
+
+	candidates, err := existingKernelSymbols([]string{"tcp_sendmsg_renamed", "tcp_data", "tcp_sendmsg", "mod_func"}, symFile)
+	if err != nil || strings.Join(candidates, ",") != "tcp_sendmsg,mod_func" {
+		t.Errorf("unexpected candidates %v (%v)", candidates, err)
+	}
+	if _, err = existingKernelSymbols([]string{"tcp_sendmsg_renamed"}, symFile); !errors.Is(err, ErrNoKprobeCandidate) {
+		t.Errorf("expected ErrNoKprobeCandidate, got %v", err)
+	}
+
+	p := &Probe{
+		Section:                "kprobe/tcp_sendmsg",
+		AttachToFuncCandidates: []string{"tcp_sendmsg_renamed", "tcp_sendmsg_locked", "tcp_sendmsg"},
+		manager:                &Manager{options: Options{SymFile: symFile}},
+	}
+	if err = p.resolveKprobeTarget(); err != nil || p.funcName != "tcp_sendmsg_locked" || len(p.kprobeCandidates) != 2 {
+		t.Errorf("unexpected kprobe target %s %v (%v)", p.funcName, p.kprobeCandidates, err)
+	}
+	p.KprobeAddress = 0xffffffff81a00410
+	if err = p.resolveKprobeTarget(); err != nil || p.funcName != "tcp_sendmsg" || p.kprobeOffset != 0x10 {
+		t.Errorf("unexpected kprobe target %s+0x%x (%v)", p.funcName, p.kprobeOffset, err)
+	}
+
+	p.KprobeAddress = 0
+	p.AttachToFuncCandidates = []string{"tcp_sendmsg_locked", "tcp_sendmsg"}
+	p.KprobeOffset = 0x300
+	if err = p.resolveKprobeTarget(); err != nil || p.funcName != "tcp_sendmsg" || len(p.kprobeCandidates) != 1 {
+		t.Errorf("unexpected kprobe target %s %v (%v)", p.funcName, p.kprobeCandidates, err)
+	}
+	p.AttachToFuncCandidates = []string{"tcp_sendmsg_locked"}
+	p.KprobeOffset = 0x300
+	if err = p.resolveKprobeTarget(); !errors.Is(err, ErrInvalidKprobeOffset) {
+		t.Errorf("expected ErrInvalidKprobeOffset, got %v", err)
+	}
+	p.Section = "kretprobe/tcp_sendmsg"
+	p.KprobeOffset = 0x10
+	if err = p.resolveKprobeTarget(); !errors.Is(err, ErrInvalidKprobeOffset) {
+		t.Errorf("expected ErrInvalidKprobeOffset for a kretprobe, got %v", err)
+	}
+
+	hidden := filepath.Join(t.TempDir(), "kallsyms")
+	if err = os.WriteFile(hidden, []byte("0000000000000000 T tcp_sendmsg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = resolveKernelAddress(0xffffffff81a00410, hidden); !errors.Is(err, ErrKprobeAddress) {
+		t.Errorf("expected ErrKprobeAddress with hidden addresses, got %v", err)
+	}
+}
+
+func TestMatchKernelSymbols(t *testing.T) {
+	symFile := filepath.Join(t.TempDir(), "kallsyms")
+	content := "ffffffff81a00100 t tcp_sendmsg_locked\n" +
+		"ffffffff81a00400 T tcp_sendmsg\n" +
+		"ffffffff81a00480 d tcp_data\n" +
+		"ffffffff81a00500 T tcp_recvmsg\n" +
+		"ffffffff81a00600 t tcp_recvmsg.cold\n" +
+		"ffffffff81a00700 T udp_sendmsg\n"
+	if err := os.WriteFile(symFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := matchKernelSymbols("^tcp_", []string{`\.cold$`, "_locked$"}, 0, symFile)
+	if err != nil || strings.Join(names, ",") != "tcp_recvmsg,tcp_sendmsg" {
+		t.Errorf("unexpected matches %v (%v)", names, err)
+	}
+	if _, err = matchKernelSymbols("sendmsg$", nil, 2, symFile); err != nil {
+		t.Errorf("expected 2 matches, got %v", err)
+	}
+	if _, err = matchKernelSymbols("msg", nil, 2, symFile); !errors.Is(err, ErrTooManyMatches) {
+		t.Errorf("expected ErrTooManyMatches, got %v", err)
+	}
+	if _, err = matchKernelSymbols("^sctp_", nil, 0, symFile); !errors.Is(err, ErrNoMatchingFunction) {
+		t.Errorf("expected ErrNoMatchingFunction, got %v", err)
+	}
+	if _, err = matchKernelSymbols("(", nil, 0, symFile); err == nil {
+		t.Error("expected an invalid pattern error")
+	}
+}
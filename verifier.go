@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// ProgramLoadError - Returned when the kernel rejected a program of the manager. It retains the full verifier log of the
+// program, use errors.As to surface it.
+type ProgramLoadError struct {
+	// ProgramName - Name of the program in the CollectionSpec
+	ProgramName string
+	// Section - Section of the program, as defined in its section SEC("[section]")
+	Section string
+	// KernelVersion - Version of the running kernel, 0 if it couldn't be determined
+	KernelVersion KernelVersion
+	// Log - Verifier log, split into lines
+	Log []string
+	// Truncated - True if the verifier log didn't fit in the log buffer, see Options.VerifierLogSizeMax
+	Truncated bool
+	// Err - Error returned by the kernel
+	Err error
+}
+
+// Error - Returns the error with the last lines of the verifier log
+func (e *ProgramLoadError) Error() string {
+	return fmt.Sprintf("error:%v , couldn't load program %s (section %s) on kernel %s", e.Err, e.ProgramName, e.Section, e.KernelVersion)
+}
+
+// Unwrap - Returns the error returned by the kernel
+func (e *ProgramLoadError) Unwrap() error {
+	return e.Err
+}
+
+// VerifierLog - Returns the full verifier log
+func (e *ProgramLoadError) VerifierLog() string {
+	return strings.Join(e.Log, "\n")
+}
+
+// newProgramLoadError - Wraps the provided load error of a program in a ProgramLoadError, if it carries a verifier
+// log. Returns nil otherwise.
+func newProgramLoadError(name string, spec *ebpf.ProgramSpec, err error) *ProgramLoadError {
+	var verifierErr *ebpf.VerifierError
+	if !errors.As(err, &verifierErr) {
+		return nil
+	}
+	loadErr := &ProgramLoadError{
+		ProgramName: name,
+		Log:         verifierErr.Log,
+		Truncated:   verifierErr.Truncated,
+		Err:         err,
+	}
+	if spec != nil {
+		loadErr.Section = spec.SectionName
+	}
+	loadErr.KernelVersion, _ = CurrentKernelVersion()
+	return loadErr
+}
+
+// failedProgramName - Returns the name of the program that NewCollectionWithOptions couldn't load, as reported in its
+// error: program [name]: ...
+func failedProgramName(err error) string {
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "program ") {
+		return ""
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(msg, "program "), ": ")
+	return name
+}
+
+// newCollection - Loads the CollectionSpec of the manager. When a program is rejected and its verifier log was
+// truncated, the load is retried with a bigger log buffer, up to Options.VerifierLogSizeMax. The rejection is
+// returned as a ProgramLoadError.
+func (m *Manager) newCollection() (*ebpf.Collection, error) {
+	opts := m.options.VerifierOptions
+	if m.options.VerifierLogSizeStart > 0 {
+		opts.Programs.LogSize = m.options.VerifierLogSizeStart
+	}
+	spec := m.collectionLoadSpec()
+	for {
+		collection, err := ebpf.NewCollectionWithOptions(spec, opts)
+		if err == nil {
+			return collection, nil
+		}
+		name := failedProgramName(err)
+		loadErr := newProgramLoadError(name, m.collectionSpec.Programs[name], err)
+		if loadErr == nil {
+			return nil, err
+		}
+
+		logSize := opts.Programs.LogSize
+		if logSize == 0 {
+			logSize = ebpf.DefaultVerifierLogSize
+		}
+		if !loadErr.Truncated || opts.Programs.LogDisabled || logSize >= m.options.VerifierLogSizeMax {
+			return nil, loadErr
+		}
+		opts.Programs.LogSize = logSize * 2
+		if opts.Programs.LogSize > m.options.VerifierLogSizeMax {
+			opts.Programs.LogSize = m.options.VerifierLogSizeMax
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// rejectedCollectionSpec - Returns a CollectionSpec with a socket filter that reads an uninitialized register
+func rejectedCollectionSpec() *ebpf.CollectionSpec {
+	var insns asm.Instructions
+	for i := 0; i < 64; i++ {
+		insns = append(insns, asm.Mov.Imm(asm.R0, int32(i)))
+	}
+	insns = append(insns, asm.Mov.Reg(asm.R0, asm.R2), asm.Return())
+	return &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"rejected": {
+				Name:         "rejected",
+				Type:         ebpf.SocketFilter,
+				SectionName:  "socket",
+				License:      "MIT",
+				Instructions: insns,
+			},
+		},
+	}
+}
+
+func TestProgramLoadError(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{}
+	err := m.InitWithAssets([]CollectionAsset{{Spec: rejectedCollectionSpec()}}, Options{})
+	var loadErr *ProgramLoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected a ProgramLoadError, got %v", err)
+	}
+	if loadErr.ProgramName != "rejected" || loadErr.Section != "socket" {
+		t.Errorf("unexpected program %s, section %s", loadErr.ProgramName, loadErr.Section)
+	}
+	if len(loadErr.Log) == 0 || loadErr.Truncated {
+		t.Errorf("expected the full verifier log, got %d lines (truncated: %v)", len(loadErr.Log), loadErr.Truncated)
+	}
+}
+
+func TestProgramLoadErrorLogRetry(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{
+		VerifierOptions:      ebpf.CollectionOptions{Programs: ebpf.ProgramOptions{LogLevel: ebpf.LogLevelInstruction}},
+		VerifierLogSizeStart: 128,
+	}
+	m := &Manager{}
+	err := m.InitWithAssets([]CollectionAsset{{Spec: rejectedCollectionSpec()}}, options)
+	var loadErr *ProgramLoadError
+	if !errors.As(err, &loadErr) || !loadErr.Truncated {
+		t.Fatalf("expected a truncated verifier log, got %v", err)
+	}
+
+	options.VerifierLogSizeMax = 1 << 20
+	m = &Manager{}
+	err = m.InitWithAssets([]CollectionAsset{{Spec: rejectedCollectionSpec()}}, options)
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected a ProgramLoadError, got %v", err)
+	}
+	if loadErr.Truncated {
+		t.Errorf("expected the verifier log to fit after the retries, got %d lines", len(loadErr.Log))
+	}
+}
+
+func TestVerifyOnly(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ebpf.LoadCollectionSpec("testdata/rewrite.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := rejectedCollectionSpec().Programs["rejected"]
+	spec.Programs["rejected"] = rejected
+
+	m := &Manager{
+		Probes: []*Probe{{Section: "socket/map", EbpfFuncName: "rewrite_map"}},
+		Maps:   []*Map{{Name: "map_val"}},
+	}
+	err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{VerifyOnly: true})
+	var loadErr *ProgramLoadError
+	if !errors.As(err, &loadErr) || loadErr.ProgramName != "rejected" {
+		t.Fatalf("expected the rejected program to be reported, got %v", err)
+	}
+	if m.collection != nil || m.Maps[0].array != nil {
+		t.Error("expected nothing to be loaded by the manager")
+	}
+	if err = m.Start(); !errors.Is(err, ErrManagerNotInitialized) {
+		t.Errorf("expected the manager to be left uninitialized, got %v", err)
+	}
+
+	delete(spec.Programs, "rejected")
+	m = &Manager{}
+	if err = m.InitWithAssets([]CollectionAsset{{Spec: spec}}, Options{VerifyOnly: true}); err != nil {
+		t.Errorf("expected the programs to verify, got %v", err)
+	}
+}
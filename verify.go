@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/hashicorp/go-multierror"
+)
+
+// verifyPrograms - (VerifyOnly) Loads every program of the CollectionSpec with the verifier, and closes it right away.
+// The maps are created once, without pinning them, and are closed once all the programs were verified. The rejected
+// programs are reported in the returned error, as ProgramLoadErrors when the kernel returned a verifier log. The
+// manager is left uninitialized.
+func (m *Manager) verifyPrograms() error {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.state = reset
+
+	spec := m.collectionSpec.Copy()
+	for _, mapSpec := range spec.Maps {
+		mapSpec.Pinning = ebpf.PinNone
+		if mapSpec.Type == ebpf.ProgramArray {
+			// the programs of the initial contents aren't loaded
+			mapSpec.Contents = nil
+		}
+	}
+	maps, err := ebpf.NewCollectionWithOptions(&ebpf.CollectionSpec{
+		Maps:      spec.Maps,
+		Types:     spec.Types,
+		ByteOrder: spec.ByteOrder,
+	}, m.options.VerifierOptions)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't create the maps of the programs to verify", err))
+	}
+	defer maps.Close()
+
+	names := make([]string, 0, len(spec.Programs))
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	opts := m.options.VerifierOptions.Programs
+	if m.options.VerifierLogSizeStart > 0 {
+		opts.LogSize = m.options.VerifierLogSizeStart
+	}
+	var errs error
+	for _, name := range names {
+		progSpec := spec.Programs[name]
+		for mapName, array := range maps.Maps {
+			if err = progSpec.Instructions.AssociateMap(mapName, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+				return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with program %s", err, mapName, name))
+			}
+		}
+		prog, err := ebpf.NewProgramWithOptions(progSpec, opts)
+		if err != nil {
+			if loadErr := newProgramLoadError(name, progSpec, err); loadErr != nil {
+				errs = multierror.Append(errs, loadErr)
+			} else {
+				errs = multierror.Append(errs, errors.New(fmt.Sprintf("error:%v , couldn't load program %s", err, name)))
+			}
+			continue
+		}
+		_ = prog.Close()
+	}
+	return errs
+}
@@ -0,0 +1,246 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
+)
+
+// XDPDispatcherSlots - Number of XDP programs that can share an interface through its dispatcher
+const XDPDispatcherSlots = 10
+
+const (
+	// xdpDispatcherName - Name of the main function of the XDP dispatcher
+	xdpDispatcherName = "xdp_dispatcher"
+	// xdpPass - XDP_PASS action, the dispatcher runs the next slot when a program returns it
+	xdpPass = 2
+)
+
+// xdpDispatcherSlot - Returns the name of the function of the provided slot of the dispatcher, which is replaced by
+// the program extension of a probe
+func xdpDispatcherSlot(slot int) string {
+	return fmt.Sprintf("prog%d", slot)
+}
+
+// xdpFuncProto - Returns the BTF prototype of the dispatcher, of its slots and of the programs that replace them:
+// int func(struct xdp_md *ctx)
+func xdpFuncProto() *btf.FuncProto {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4}
+	var members []btf.Member
+	for i, name := range []string{"data", "data_end", "data_meta", "ingress_ifindex", "rx_queue_index", "egress_ifindex"} {
+		members = append(members, btf.Member{Name: name, Type: u32, Offset: btf.Bits(i * 32)})
+	}
+	return &btf.FuncProto{
+		Return: &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed},
+		Params: []btf.FuncParam{
+			{Name: "ctx", Type: &btf.Pointer{Target: &btf.Struct{Name: "xdp_md", Size: 24, Members: members}}},
+		},
+	}
+}
+
+// newXDPDispatcherSpec - Returns the spec of an XDP dispatcher, in the fashion of libxdp: the dispatcher calls the
+// functions of its slots in order, and returns the action of the first one that doesn't return XDP_PASS. The slots
+// return XDP_PASS until they are replaced by a program extension.
+func newXDPDispatcherSpec() *ebpf.ProgramSpec {
+	proto := xdpFuncProto()
+	insns := asm.Instructions{
+		btf.WithFuncMetadata(asm.Mov.Reg(asm.R6, asm.R1), &btf.Func{Name: xdpDispatcherName, Type: proto, Linkage: btf.GlobalFunc}).
+			WithSymbol(xdpDispatcherName),
+	}
+	for slot := 0; slot < XDPDispatcherSlots; slot++ {
+		insns = append(insns,
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Call.Label(xdpDispatcherSlot(slot)),
+			asm.JNE.Imm(asm.R0, xdpPass, "out"),
+		)
+	}
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, xdpPass),
+		asm.Return().WithSymbol("out"),
+	)
+	for slot := 0; slot < XDPDispatcherSlots; slot++ {
+		fn := &btf.Func{Name: xdpDispatcherSlot(slot), Type: proto, Linkage: btf.GlobalFunc}
+		insns = append(insns,
+			btf.WithFuncMetadata(asm.Mov.Imm(asm.R0, xdpPass), fn).WithSymbol(fn.Name),
+			asm.Return(),
+		)
+	}
+
+	return &ebpf.ProgramSpec{
+		Name:         xdpDispatcherName,
+		Type:         ebpf.XDP,
+		License:      "GPL",
+		Instructions: insns,
+	}
+}
+
+// xdpDispatcherPinPrefix - Returns the prefix of the pins of the dispatcher of the interface of the probe. The
+// dispatcher is pinned so that the managers of other applications can share it: the program is pinned at the prefix,
+// its link at [prefix]-link, and the link of the program extension of each slot at [prefix]-prog[slot].
+func (p *Probe) xdpDispatcherPinPrefix() (string, error) {
+	netns := p.IfindexNetns
+	if netns == 0 {
+		path := p.NetnsPath
+		if path == "" {
+			path = "/proc/self/ns/net"
+		}
+		var stat unix.Stat_t
+		if err := unix.Stat(path, &stat); err != nil {
+			return "", errors.New(fmt.Sprintf("error:%v , couldn't identify network namespace %s", err, path))
+		}
+		netns = stat.Ino
+	}
+	return filepath.Join(p.manager.bpffsRoot(), "xdp", fmt.Sprintf("dispatch-%d-%d", netns, p.Ifindex)), nil
+}
+
+// loadXDPDispatcher - Returns the dispatcher pinned at the provided prefix. If there is none, a new dispatcher is
+// loaded, attached to the interface of the probe and pinned.
+func (p *Probe) loadXDPDispatcher(prefix string) (*ebpf.Program, error) {
+	dispatcher, err := ebpf.LoadPinnedProgram(prefix, nil)
+	if err == nil {
+		return dispatcher, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't load the XDP dispatcher pinned at %s", err, prefix))
+	}
+
+	dispatcher, err = ebpf.NewProgram(newXDPDispatcherSpec())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't load the XDP dispatcher", err))
+	}
+	var l link.Link
+	err = runInNetns(p.NetnsPath, func() error {
+		var err error
+		l, err = link.AttachXDP(link.XDPOptions{
+			Program:   dispatcher,
+			Interface: int(p.Ifindex),
+			Flags:     link.XDPAttachFlags(p.XDPAttachMode),
+		})
+		return err
+	})
+	if err != nil {
+		_ = dispatcher.Close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't attach the XDP dispatcher to interface %v", err, p.Ifindex))
+	}
+	if err = os.MkdirAll(filepath.Dir(prefix), 0755); err == nil {
+		if err = l.Pin(prefix + "-link"); err == nil {
+			err = dispatcher.Pin(prefix)
+		}
+	}
+	if err != nil {
+		_ = l.Unpin()
+		_ = l.Close()
+		_ = dispatcher.Close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't pin the XDP dispatcher at %s", err, prefix))
+	}
+	// the pin keeps the dispatcher attached
+	_ = l.Close()
+	return dispatcher, nil
+}
+
+// attachXDPDispatcher - Attaches the program of the probe to its slot of the dispatcher of the interface, as a program
+// extension. The dispatcher is created if the interface doesn't have one yet.
+func (p *Probe) attachXDPDispatcher() error {
+	if p.XDPPriority < 0 || p.XDPPriority >= XDPDispatcherSlots {
+		return errors.New(fmt.Sprintf("error:%v , got %d", ErrXDPPriority, p.XDPPriority))
+	}
+	// program extensions are attached with BPF trampolines
+	if err := HaveTrampolines(); err != nil {
+		return err
+	}
+	prefix, err := p.xdpDispatcherPinPrefix()
+	if err != nil {
+		return err
+	}
+	dispatcher, err := p.loadXDPDispatcher(prefix)
+	if err != nil {
+		return err
+	}
+	// the pin keeps the dispatcher loaded
+	defer dispatcher.Close()
+	if err = p.attachXDPExtension(dispatcher, prefix); err != nil {
+		// don't leave behind a dispatcher created for this probe
+		return ConcatErrors(err, releaseXDPDispatcher(prefix))
+	}
+	return nil
+}
+
+// attachXDPExtension - Loads the program of the probe as an extension of its slot of the provided dispatcher, and
+// attaches it
+func (p *Probe) attachXDPExtension(dispatcher *ebpf.Program, prefix string) error {
+	var err error
+	// Load the program of the probe as an extension of its slot
+	slot := xdpDispatcherSlot(p.XDPPriority)
+	spec := p.programSpec.Copy()
+	spec.Type = ebpf.Extension
+	spec.AttachType = ebpf.AttachNone
+	spec.AttachTarget = dispatcher
+	spec.AttachTo = slot
+	for name, array := range p.manager.collection.Maps {
+		if err = spec.Instructions.AssociateMap(name, array); err != nil && !errors.Is(err, asm.ErrUnreferencedSymbol) {
+			return errors.New(fmt.Sprintf("error:%v , couldn't associate map %s with the extension of probe %v", err, name, p.GetIdentificationPair()))
+		}
+	}
+	extension, err := ebpf.NewProgramWithOptions(spec, p.manager.options.VerifierOptions.Programs)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't load the extension of probe %v", err, p.GetIdentificationPair()))
+	}
+
+	l, err := link.AttachFreplace(dispatcher, slot, extension)
+	if err != nil {
+		_ = extension.Close()
+		if errors.Is(err, unix.EBUSY) {
+			return fmt.Errorf("error:%w , slot %d of interface %v", ErrXDPSlotInUse, p.XDPPriority, p.Ifindex)
+		}
+		return errors.New(fmt.Sprintf("error:%v , couldn't attach probe %v to the XDP dispatcher", err, p.GetIdentificationPair()))
+	}
+	if err = l.Pin(fmt.Sprintf("%s-%s", prefix, slot)); err != nil {
+		_ = l.Close()
+		_ = extension.Close()
+		return errors.New(fmt.Sprintf("error:%v , couldn't pin the link of probe %v", err, p.GetIdentificationPair()))
+	}
+	p.xdpExtension = extension
+	p.xdpExtensionLink = l
+	p.xdpDispatcherPrefix = prefix
+	return nil
+}
+
+// detachXDPDispatcher - Detaches the program of the probe from the dispatcher of the interface. The dispatcher is
+// detached from the interface once all its slots are free.
+func (p *Probe) detachXDPDispatcher() error {
+	var err error
+	if p.xdpExtensionLink != nil {
+		err = ConcatErrors(p.xdpExtensionLink.Unpin(), p.xdpExtensionLink.Close())
+		p.xdpExtensionLink = nil
+	}
+	if p.xdpExtension != nil {
+		err = ConcatErrors(err, p.xdpExtension.Close())
+		p.xdpExtension = nil
+	}
+	prefix := p.xdpDispatcherPrefix
+	p.xdpDispatcherPrefix = ""
+	return ConcatErrors(err, releaseXDPDispatcher(prefix))
+}
+
+// releaseXDPDispatcher - Detaches the dispatcher pinned at the provided prefix from its interface and removes it, if
+// none of its slots is in use
+func releaseXDPDispatcher(prefix string) error {
+	if slots, _ := filepath.Glob(prefix + "-prog*"); len(slots) > 0 {
+		// the dispatcher is still used by another probe or application
+		return nil
+	}
+	dispatcherLink, err := link.LoadPinnedLink(prefix+"-link", nil)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't load the link of the XDP dispatcher", err))
+	}
+	err = dispatcherLink.Unpin()
+	err = ConcatErrors(err, dispatcherLink.Close())
+	return ConcatErrors(err, os.Remove(prefix))
+}
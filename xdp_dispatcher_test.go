@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+func TestXDPDispatcher(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	root := mountBPFFS(t)
+	path := newNetns(t)
+
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{}},
+		options:    Options{BPFFSRoot: root},
+	}
+	newProbe := func(name string, priority int, action int32) *Probe {
+		fn := &btf.Func{Name: name, Type: xdpFuncProto(), Linkage: btf.GlobalFunc}
+		p := &Probe{
+			manager: m,
+			programSpec: &ebpf.ProgramSpec{
+				Name:    name,
+				Type:    ebpf.XDP,
+				License: "GPL",
+				Instructions: asm.Instructions{
+					btf.WithFuncMetadata(asm.Mov.Imm(asm.R0, action), fn).WithSymbol(name),
+					asm.Return(),
+				},
+			},
+			EbpfFuncName:     name,
+			Ifindex:          1,
+			NetnsPath:        path,
+			XDPUseDispatcher: true,
+			XDPPriority:      priority,
+		}
+		return p
+	}
+	pass, drop := newProbe("pass", 3, xdpPass), newProbe("drop", 5, 1)
+	prefix, err := pass.xdpDispatcherPinPrefix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func() uint32 {
+		dispatcher, err := ebpf.LoadPinnedProgram(prefix, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dispatcher.Close()
+		ret, _, err := dispatcher.Test(make([]byte, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	// the dispatcher passes the packets as long as its slots are free
+	dispatcher, err := pass.loadXDPDispatcher(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Close()
+	if ret := run(); ret != xdpPass {
+		t.Errorf("expected an empty dispatcher to pass, got %d", ret)
+	}
+	if err = releaseXDPDispatcher(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(prefix); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the dispatcher to be removed, got %v", err)
+	}
+
+	if err = HaveTrampolines(); err != nil {
+		t.Skip(err)
+	}
+	// XDP_PASS, then XDP_DROP
+	for _, p := range []*Probe{pass, drop} {
+		if err = p.attachXDP(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ret := run(); ret != 1 {
+		t.Errorf("expected the dispatcher to chain to the XDP_DROP program, got %d", ret)
+	}
+
+	if err = newProbe("conflict", 5, xdpPass).attachXDP(); !errors.Is(err, ErrXDPSlotInUse) {
+		t.Errorf("expected ErrXDPSlotInUse, got %v", err)
+	}
+	if err = newProbe("invalid", XDPDispatcherSlots, xdpPass).attachXDP(); err == nil {
+		t.Error("expected an invalid priority to be rejected")
+	}
+
+	if err = drop.detachXDP(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := run(); ret != xdpPass {
+		t.Errorf("expected the dispatcher to pass once the XDP_DROP program is detached, got %d", ret)
+	}
+	if err = pass.detachXDP(); err != nil {
+		t.Fatal(err)
+	}
+	if pins, _ := filepath.Glob(filepath.Join(root, "xdp", "*")); len(pins) > 0 {
+		t.Errorf("expected the dispatcher to be removed, got %v", pins)
+	}
+}
@@ -0,0 +1,395 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultXSKFrameSize - Default size of the frames of the UMEM of an AF_XDP socket, see XSKOptions.FrameSize
+	DefaultXSKFrameSize = 4096
+	// DefaultXSKFrameCount - Default number of frames of the UMEM of an AF_XDP socket, see XSKOptions.FrameCount
+	DefaultXSKFrameCount = 4096
+	// DefaultXSKRingSize - Default number of descriptors of the rings of an AF_XDP socket, see XSKOptions.RingSize
+	DefaultXSKRingSize = 2048
+)
+
+// XSKOptions - Options of an AF_XDP socket created with Manager.NewXSKSocket
+type XSKOptions struct {
+	// Ifindex, Ifname - Interface the socket is bound to. Ifname is used when Ifindex isn't set.
+	Ifindex int
+	Ifname  string
+
+	// QueueID - Receive queue of the interface the socket is bound to. It is also the key of the socket in the XSKMAP,
+	// so that an XDP program can redirect the packets of a queue with bpf_redirect_map(&xsks, ctx->rx_queue_index, 0).
+	QueueID int
+
+	// FrameSize - Size of the frames of the UMEM, the packet buffers shared with the kernel: 2048 or 4096. Defaults to
+	// DefaultXSKFrameSize.
+	FrameSize int
+
+	// FrameCount - Number of frames of the UMEM. Defaults to DefaultXSKFrameCount.
+	FrameCount int
+
+	// RingSize - Number of descriptors of the fill, completion, RX and TX rings, a power of 2. Defaults to
+	// DefaultXSKRingSize.
+	RingSize int
+
+	// BindFlags - Flags of the socket: unix.XDP_COPY or unix.XDP_ZEROCOPY to force a mode, and unix.XDP_USE_NEED_WAKEUP
+	BindFlags uint16
+}
+
+// xskRing - Ring of descriptors shared with the kernel, mapped from the socket
+type xskRing struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	flags    *uint32
+	descs    unsafe.Pointer
+	size     uint32
+}
+
+// newXSKRing - Maps the ring at the provided page offset of the socket. The descriptors of the ring are descSize bytes
+// long.
+func newXSKRing(fd int, pgoff int64, offsets unix.XDPRingOffset, size uint32, descSize uint64) (*xskRing, error) {
+	mem, err := unix.Mmap(fd, pgoff, int(offsets.Desc+uint64(size)*descSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, err
+	}
+	base := unsafe.Pointer(&mem[0])
+	return &xskRing{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(offsets.Producer))),
+		consumer: (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(offsets.Consumer))),
+		flags:    (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(offsets.Flags))),
+		descs:    unsafe.Pointer(uintptr(base) + uintptr(offsets.Desc)),
+		size:     size,
+	}, nil
+}
+
+// addr - Returns the i-th descriptor of a fill or completion ring
+func (r *xskRing) addr(i uint32) *uint64 {
+	return (*uint64)(unsafe.Pointer(uintptr(r.descs) + uintptr(i&(r.size-1))*8))
+}
+
+// desc - Returns the i-th descriptor of a RX or TX ring
+func (r *xskRing) desc(i uint32) *unix.XDPDesc {
+	return (*unix.XDPDesc)(unsafe.Pointer(uintptr(r.descs) + uintptr(i&(r.size-1))*unsafe.Sizeof(unix.XDPDesc{})))
+}
+
+// needWakeup - Returns true if the kernel has to be woken up to process the ring (XDP_USE_NEED_WAKEUP)
+func (r *xskRing) needWakeup() bool {
+	return atomic.LoadUint32(r.flags)&unix.XDP_RING_NEED_WAKEUP != 0
+}
+
+func (r *xskRing) close() error {
+	return unix.Munmap(r.mem)
+}
+
+// XSKSocket - AF_XDP socket registered in an XSKMAP of the manager, see Manager.NewXSKSocket. The packets redirected
+// to the socket by an XDP program are read with Receive, packets are sent with Transmit. An XSKSocket isn't thread
+// safe, use one socket per goroutine and per queue.
+type XSKSocket struct {
+	fd         int
+	options    XSKOptions
+	umem       []byte
+	fill       *xskRing
+	completion *xskRing
+	rx         *xskRing
+	tx         *xskRing
+	freeFrames []uint64
+	xskMap     *ebpf.Map
+	manager    *Manager
+}
+
+// NewXSKSocket - Creates an AF_XDP socket bound to a queue of an interface, and registers it in the XSKMAP of the
+// manager with the provided name, at the index of the queue. The socket is closed when the manager stops.
+func (m *Manager) NewXSKSocket(xskMap string, options XSKOptions) (*XSKSocket, error) {
+	m.stateLock.RLock()
+	if m.collection == nil || m.state < initialized {
+		m.stateLock.RUnlock()
+		return nil, ErrManagerNotInitialized
+	}
+	array, ok := m.getMap(xskMap)
+	m.stateLock.RUnlock()
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't find map %s", ErrUnknownMap, xskMap))
+	}
+	if array.Type() != ebpf.XSKMap {
+		return nil, fmt.Errorf("error:%w , map %s is a %s", ErrNotXSKMap, xskMap, array.Type())
+	}
+
+	s, err := newXSKSocket(options)
+	if err != nil {
+		return nil, err
+	}
+	if err = array.Put(uint32(s.options.QueueID), uint32(s.fd)); err != nil {
+		_ = s.close()
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't register the AF_XDP socket in %s", err, xskMap))
+	}
+	s.xskMap, s.manager = array, m
+
+	m.xskLock.Lock()
+	m.xskSockets = append(m.xskSockets, s)
+	m.xskLock.Unlock()
+	return s, nil
+}
+
+// newXSKSocket - Creates and binds an AF_XDP socket, its UMEM and its rings
+func newXSKSocket(options XSKOptions) (*XSKSocket, error) {
+	if options.FrameSize == 0 {
+		options.FrameSize = DefaultXSKFrameSize
+	}
+	if options.FrameCount == 0 {
+		options.FrameCount = DefaultXSKFrameCount
+	}
+	if options.RingSize == 0 {
+		options.RingSize = DefaultXSKRingSize
+	}
+	if options.RingSize&(options.RingSize-1) != 0 || options.FrameCount < options.RingSize {
+		return nil, fmt.Errorf("error:%w , the ring size must be a power of 2, lower than the number of frames", ErrInvalidXSKOptions)
+	}
+	if options.Ifindex == 0 {
+		inter, err := net.InterfaceByName(options.Ifname)
+		if err != nil {
+			return nil, fmt.Errorf("error:%w , couldn't find interface %s: %v", ErrInvalidXSKOptions, options.Ifname, err)
+		}
+		options.Ifindex = inter.Index
+	}
+
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error:%v , couldn't create the AF_XDP socket", err))
+	}
+	s := &XSKSocket{fd: fd, options: options}
+	if err = s.setup(); err != nil {
+		_ = s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setup - Registers the UMEM of the socket, maps its rings and binds it to its queue
+func (s *XSKSocket) setup() error {
+	var err error
+	s.umem, err = unix.Mmap(-1, 0, s.options.FrameCount*s.options.FrameSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't allocate the UMEM", err))
+	}
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:  uint64(len(s.umem)),
+		Size: uint32(s.options.FrameSize),
+	}
+	if err = setsockopt(s.fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't register the UMEM", err))
+	}
+	for _, ring := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING, unix.XDP_TX_RING} {
+		if err = unix.SetsockoptInt(s.fd, unix.SOL_XDP, ring, s.options.RingSize); err != nil {
+			return errors.New(fmt.Sprintf("error:%v , couldn't set the size of the rings", err))
+		}
+	}
+
+	var offsets unix.XDPMmapOffsets
+	size := uint32(unsafe.Sizeof(offsets))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(s.fd), unix.SOL_XDP, unix.XDP_MMAP_OFFSETS, uintptr(unsafe.Pointer(&offsets)), uintptr(unsafe.Pointer(&size)), 0); errno != 0 {
+		return errors.New(fmt.Sprintf("error:%v , couldn't read the offsets of the rings", errno))
+	}
+	ringSize := uint32(s.options.RingSize)
+	descSize := uint64(unsafe.Sizeof(unix.XDPDesc{}))
+	if s.fill, err = newXSKRing(s.fd, unix.XDP_UMEM_PGOFF_FILL_RING, offsets.Fr, ringSize, 8); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't map the fill ring", err))
+	}
+	if s.completion, err = newXSKRing(s.fd, unix.XDP_UMEM_PGOFF_COMPLETION_RING, offsets.Cr, ringSize, 8); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't map the completion ring", err))
+	}
+	if s.rx, err = newXSKRing(s.fd, unix.XDP_PGOFF_RX_RING, offsets.Rx, ringSize, descSize); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't map the RX ring", err))
+	}
+	if s.tx, err = newXSKRing(s.fd, unix.XDP_PGOFF_TX_RING, offsets.Tx, ringSize, descSize); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't map the TX ring", err))
+	}
+
+	for i := 0; i < s.options.FrameCount; i++ {
+		s.freeFrames = append(s.freeFrames, uint64(i*s.options.FrameSize))
+	}
+	// hand half of the frames over to the kernel for reception, the other half is kept for transmission
+	s.refill(s.options.FrameCount / 2)
+
+	sa := &unix.SockaddrXDP{Flags: s.options.BindFlags, Ifindex: uint32(s.options.Ifindex), QueueID: uint32(s.options.QueueID)}
+	if err = unix.Bind(s.fd, sa); err != nil {
+		return errors.New(fmt.Sprintf("error:%v , couldn't bind the AF_XDP socket to queue %d of interface %d", err, s.options.QueueID, s.options.Ifindex))
+	}
+	return nil
+}
+
+// setsockopt - Sets a SOL_XDP option of the provided socket
+func setsockopt(fd int, option int, value unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), unix.SOL_XDP, uintptr(option), uintptr(value), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// refill - Hands at most count free frames over to the kernel through the fill ring
+func (s *XSKSocket) refill(count int) {
+	producer := atomic.LoadUint32(s.fill.producer)
+	free := s.fill.size - (producer - atomic.LoadUint32(s.fill.consumer))
+	if uint32(count) > free {
+		count = int(free)
+	}
+	if count > len(s.freeFrames) {
+		count = len(s.freeFrames)
+	}
+	for i := 0; i < count; i++ {
+		*s.fill.addr(producer + uint32(i)) = s.freeFrames[len(s.freeFrames)-1-i]
+	}
+	s.freeFrames = s.freeFrames[:len(s.freeFrames)-count]
+	atomic.StoreUint32(s.fill.producer, producer+uint32(count))
+}
+
+// FD - Returns the file descriptor of the socket
+func (s *XSKSocket) FD() int {
+	return s.fd
+}
+
+// Poll - Waits at most timeout for packets to receive, returns true if there are. A negative timeout waits forever.
+func (s *XSKSocket) Poll(timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+	milliseconds := -1
+	if timeout >= 0 {
+		milliseconds = int(timeout / time.Millisecond)
+	}
+	n, err := unix.Poll(fds, milliseconds)
+	if err != nil && !errors.Is(err, unix.EINTR) {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Receive - Calls handler with each packet of the RX ring, then hands the frames back to the kernel through the fill
+// ring. The packet is only valid during the call. Returns the number of packets received.
+func (s *XSKSocket) Receive(handler func(packet []byte)) int {
+	consumer := atomic.LoadUint32(s.rx.consumer)
+	available := atomic.LoadUint32(s.rx.producer) - consumer
+	for i := uint32(0); i < available; i++ {
+		desc := s.rx.desc(consumer + i)
+		handler(s.umem[desc.Addr : desc.Addr+uint64(desc.Len)])
+		// the address may carry an offset in the frame, the frame is recycled from its start
+		s.freeFrames = append(s.freeFrames, desc.Addr-desc.Addr%uint64(s.options.FrameSize))
+	}
+	atomic.StoreUint32(s.rx.consumer, consumer+available)
+	s.refill(len(s.freeFrames))
+	if available > 0 && s.fill.needWakeup() {
+		_, _ = unix.Poll([]unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}, 0)
+	}
+	return int(available)
+}
+
+// Transmit - Copies the provided packet in a free frame and queues it on the TX ring. Returns ErrXSKRingFull when no
+// frame or TX descriptor is available, call Complete to reclaim the frames of the sent packets.
+func (s *XSKSocket) Transmit(packet []byte) error {
+	if len(packet) > s.options.FrameSize {
+		return fmt.Errorf("error:%w , the packet is larger than a frame (%d bytes)", ErrInvalidXSKOptions, s.options.FrameSize)
+	}
+	s.Complete()
+	producer := atomic.LoadUint32(s.tx.producer)
+	if len(s.freeFrames) == 0 || producer-atomic.LoadUint32(s.tx.consumer) >= s.tx.size {
+		return ErrXSKRingFull
+	}
+	frame := s.freeFrames[len(s.freeFrames)-1]
+	s.freeFrames = s.freeFrames[:len(s.freeFrames)-1]
+	copy(s.umem[frame:], packet)
+	*s.tx.desc(producer) = unix.XDPDesc{Addr: frame, Len: uint32(len(packet))}
+	atomic.StoreUint32(s.tx.producer, producer+1)
+
+	// kick the kernel to send the packet
+	err := unix.Sendto(s.fd, nil, unix.MSG_DONTWAIT, nil)
+	if err != nil && !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EBUSY) && !errors.Is(err, unix.ENOBUFS) {
+		return errors.New(fmt.Sprintf("error:%v , couldn't wake up the kernel", err))
+	}
+	return nil
+}
+
+// Complete - Reclaims the frames of the packets sent by the kernel from the completion ring, returns their number
+func (s *XSKSocket) Complete() int {
+	consumer := atomic.LoadUint32(s.completion.consumer)
+	available := atomic.LoadUint32(s.completion.producer) - consumer
+	for i := uint32(0); i < available; i++ {
+		s.freeFrames = append(s.freeFrames, *s.completion.addr(consumer + i))
+	}
+	atomic.StoreUint32(s.completion.consumer, consumer+available)
+	return int(available)
+}
+
+// Stats - Returns the statistics of the socket (dropped packets, invalid descriptors, full or empty rings)
+func (s *XSKSocket) Stats() (unix.XDPStatistics, error) {
+	var stats unix.XDPStatistics
+	size := uint32(unsafe.Sizeof(stats))
+	if _, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(s.fd), unix.SOL_XDP, unix.XDP_STATISTICS, uintptr(unsafe.Pointer(&stats)), uintptr(unsafe.Pointer(&size)), 0); errno != 0 {
+		return stats, errno
+	}
+	return stats, nil
+}
+
+// Close - Removes the socket from its XSKMAP and closes it
+func (s *XSKSocket) Close() error {
+	if s.manager != nil {
+		s.manager.xskLock.Lock()
+		for i, socket := range s.manager.xskSockets {
+			if socket == s {
+				s.manager.xskSockets = append(s.manager.xskSockets[:i], s.manager.xskSockets[i+1:]...)
+				break
+			}
+		}
+		s.manager.xskLock.Unlock()
+	}
+	return s.close()
+}
+
+// close - Removes the socket from its XSKMAP, unmaps its rings and its UMEM, and closes it
+func (s *XSKSocket) close() error {
+	var err error
+	if s.xskMap != nil {
+		if e := s.xskMap.Delete(uint32(s.options.QueueID)); e != nil && !errors.Is(e, ebpf.ErrKeyNotExist) {
+			err = ConcatErrors(err, e)
+		}
+		s.xskMap = nil
+	}
+	for _, ring := range []*xskRing{s.fill, s.completion, s.rx, s.tx} {
+		if ring != nil {
+			err = ConcatErrors(err, ring.close())
+		}
+	}
+	s.fill, s.completion, s.rx, s.tx = nil, nil, nil, nil
+	if s.fd >= 0 {
+		err = ConcatErrors(err, unix.Close(s.fd))
+		s.fd = -1
+	}
+	if s.umem != nil {
+		err = ConcatErrors(err, unix.Munmap(s.umem))
+		s.umem = nil
+	}
+	return err
+}
+
+// closeXSKSockets - Closes the AF_XDP sockets of the manager
+func (m *Manager) closeXSKSockets() error {
+	m.xskLock.Lock()
+	sockets := m.xskSockets
+	m.xskSockets = nil
+	m.xskLock.Unlock()
+	var err error
+	for _, s := range sockets {
+		err = ConcatErrors(err, s.close())
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+func TestXSKSocket(t *testing.T) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	xskMap, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.XSKMap, KeySize: 4, ValueSize: 4, MaxEntries: 4})
+	if err != nil {
+		t.Skipf("XSKMAP not supported: %v", err)
+	}
+	defer xskMap.Close()
+	array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	m := &Manager{
+		collection: &ebpf.Collection{Maps: map[string]*ebpf.Map{"xsks": xskMap, "array": array}},
+		state:      initialized,
+	}
+	options := XSKOptions{Ifname: "lo", FrameCount: 64, RingSize: 32, BindFlags: unix.XDP_COPY}
+	if _, err = m.NewXSKSocket("array", options); !errors.Is(err, ErrNotXSKMap) {
+		t.Errorf("expected ErrNotXSKMap, got %v", err)
+	}
+	if _, err = m.NewXSKSocket("xsks", XSKOptions{Ifname: "lo", RingSize: 100}); !errors.Is(err, ErrInvalidXSKOptions) {
+		t.Errorf("expected ErrInvalidXSKOptions, got %v", err)
+	}
+
+	socket, err := m.NewXSKSocket("xsks", options)
+	if err != nil {
+		t.Skipf("AF_XDP not supported: %v", err)
+	}
+	var fd uint32
+	if err = xskMap.Lookup(uint32(0), &fd); err != nil && !errors.Is(err, unix.EOPNOTSUPP) {
+		t.Errorf("the socket wasn't registered: %v", err)
+	}
+	if n := socket.Receive(func(packet []byte) {}); n != 0 {
+		t.Errorf("expected no packet, got %d", n)
+	}
+	if _, err = socket.Stats(); err != nil {
+		t.Error(err)
+	}
+
+	// the sockets are closed and unregistered when the manager stops
+	if err = m.closeXSKSockets(); err != nil {
+		t.Fatal(err)
+	}
+	if socket.FD() != -1 {
+		t.Error("the socket wasn't closed")
+	}
+	if err = xskMap.Lookup(uint32(0), &fd); !errors.Is(err, ebpf.ErrKeyNotExist) && !errors.Is(err, unix.EOPNOTSUPP) {
+		t.Errorf("the socket wasn't unregistered: %v", err)
+	}
+}